@@ -1,3 +1,3 @@
 #!/bin/sh
-/app/whatsapp-bridge &
+/app/whatsapp-bridge --headless &
 exec python -m whatsapp_mcp_server.main
\ No newline at end of file
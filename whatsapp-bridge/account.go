@@ -0,0 +1,637 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// DefaultAccountID is used when a request does not name an account. Its data
+// lives directly in the store directory so single-account setups keep working.
+const DefaultAccountID = "default"
+
+// Valid account IDs are used as directory names, so keep them simple
+var accountIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Account bundles everything the bridge keeps per linked WhatsApp number
+type Account struct {
+	ID        string
+	Dir       string
+	Media     MediaStorage
+	Client    *whatsmeow.Client
+	Container *sqlstore.Container
+	// Database behind Container, kept for WAL checkpoints and backups
+	sessionDB     *sql.DB
+	MessageStore  *MessageStore
+	QR            *QRManager
+	Session       *SessionManager
+	Notifier      *WebhookNotifier
+	LiveLocations *LiveLocationTracker
+	MediaRetries  *MediaRetryWaiter
+	Scheduler     *Scheduler
+	Broadcaster   *Broadcaster
+	Outbox        *Outbox
+	Sync          *SyncTracker
+	Janitor       *MediaJanitor
+	Pruner        *Pruner
+	MediaJobs     *MediaJobQueue
+	// Reviews outgoing messages, nil when no policy is configured
+	Policy ContentPolicy
+	// Hold scheduled, queued and broadcast messages instead of sending them
+	ReadOnly bool
+	// How outgoing images are shrunk before upload
+	ImageCompression ImageCompression
+	// How outgoing videos are converted before upload
+	VideoTranscoding VideoTranscoding
+	// Only set when stored messages should disappear with their timer
+	Purger *DisappearingPurger
+	// Only set when incoming media is downloaded automatically
+	Downloader *AutoDownloader
+	Logger     waLog.Logger
+	// Span that sends made through this copy of the account are part of
+	traceParent spanContext
+}
+
+// AccountManager owns all accounts managed by the bridge
+type AccountManager struct {
+	mu       sync.RWMutex
+	accounts map[string]*Account
+	// IDs reserved by Create while their account opens
+	creating map[string]bool
+	baseDir  string
+	notifier *WebhookNotifier
+	cfg      *Config
+	policy   ContentPolicy
+}
+
+// AccountInfo is the JSON representation of an account
+type AccountInfo struct {
+	ID        string `json:"id"`
+	JID       string `json:"jid,omitempty"`
+	State     string `json:"state"`
+	Connected bool   `json:"connected"`
+}
+
+// AccountResponse represents the response for the single-account APIs
+type AccountResponse struct {
+	Success bool         `json:"success"`
+	Message string       `json:"message"`
+	Account *AccountInfo `json:"account,omitempty"`
+}
+
+// CreateAccountRequest represents the request body for creating an account
+type CreateAccountRequest struct {
+	ID string `json:"id"`
+}
+
+// Create an account manager and open the default account plus every account found on disk
+func NewAccountManager(baseDir string, notifier *WebhookNotifier, cfg *Config) (*AccountManager, error) {
+	am := &AccountManager{
+		accounts: make(map[string]*Account),
+		creating: make(map[string]bool),
+		baseDir:  baseDir,
+		notifier: notifier,
+		cfg:      cfg,
+	}
+	policy, err := newContentPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	am.policy = policy
+
+	if _, err := am.open(DefaultAccountID); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(baseDir, "accounts"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read accounts directory: %v", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !accountIDPattern.MatchString(entry.Name()) || entry.Name() == DefaultAccountID {
+			continue
+		}
+		if _, err := am.open(entry.Name()); err != nil {
+			am.Close()
+			return nil, err
+		}
+	}
+
+	return am, nil
+}
+
+// Directory holding the databases and media of an account
+func (am *AccountManager) accountDir(id string) string {
+	if id == DefaultAccountID {
+		return am.baseDir
+	}
+	return filepath.Join(am.baseDir, "accounts", id)
+}
+
+// Open the stores of an account and create its client (without connecting)
+func (am *AccountManager) open(id string) (*Account, error) {
+	dir := am.accountDir(id)
+
+	logName := "Client"
+	if id != DefaultAccountID {
+		logName = "Client/" + id
+	}
+	logger := newLogger(logName)
+
+	// Create directory for database if it doesn't exist
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %v", err)
+	}
+
+	// Create database connection for storing session data
+	dbLog := newLogger("Database")
+	db, dialect, err := am.cfg.openAccountDatabase(id, dir, "whatsapp.db", "_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	container := sqlstore.NewWithDB(db, dialect, dbLog)
+	if err := container.Upgrade(); err != nil {
+		container.Close()
+		return nil, fmt.Errorf("failed to upgrade database: %v", err)
+	}
+
+	// Get device store - This contains session information
+	deviceStore, err := container.GetFirstDevice()
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// No device exists, create one
+			deviceStore = container.NewDevice()
+			logger.Infof("Created new device")
+		} else {
+			container.Close()
+			return nil, fmt.Errorf("failed to get device: %v", err)
+		}
+	}
+
+	// Create client instance
+	client := whatsmeow.NewClient(deviceStore, logger)
+	if client == nil {
+		container.Close()
+		return nil, fmt.Errorf("failed to create WhatsApp client")
+	}
+
+	// Initialize message store
+	messageStore, err := NewMessageStore(am.cfg, id, dir)
+	if err != nil {
+		container.Close()
+		return nil, fmt.Errorf("failed to initialize message store: %v", err)
+	}
+	messageStore.keepViewOnceMedia = am.cfg.StoreViewOnceMedia
+	if !messageStore.searchIndexed && messageStore.db.isSQLite() {
+		logger.Warnf("SQLite was built without FTS5, message search is unranked (build with -tags sqlite_fts5)")
+	}
+
+	media, err := am.cfg.mediaStorage(id, dir)
+	if err != nil {
+		messageStore.Close()
+		container.Close()
+		return nil, fmt.Errorf("failed to set up media storage: %v", err)
+	}
+
+	qrManager := NewQRManager()
+	account := &Account{
+		ID:            id,
+		Dir:           dir,
+		Client:        client,
+		Container:     container,
+		sessionDB:     db,
+		MessageStore:  messageStore,
+		Media:         media,
+		QR:            qrManager,
+		Session:       NewSessionManager(id, client, qrManager, am.notifier, am.cfg.terminalQRMode(), logger),
+		Notifier:      am.notifier,
+		LiveLocations: NewLiveLocationTracker(),
+		MediaRetries:  NewMediaRetryWaiter(),
+		Sync:          NewSyncTracker(am.cfg.HistorySync),
+		Logger:        logger,
+	}
+	account.ReadOnly = am.cfg.ReadOnly
+	account.ImageCompression = am.cfg.imageCompression()
+	account.VideoTranscoding = am.cfg.videoTranscoding()
+	account.Scheduler = NewScheduler(account)
+	account.Broadcaster = NewBroadcaster(account)
+	account.Outbox = NewOutbox(account)
+	account.Janitor = NewMediaJanitor(account, int64(am.cfg.MediaQuotaMB)<<20)
+	account.Pruner = NewPruner(account, am.cfg.retentionPolicy(), am.cfg.pruneMinute(), am.cfg.vacuumInterval())
+	account.MediaJobs = NewMediaJobQueue(account)
+	account.Policy = am.policy
+	if am.cfg.PurgeDisappearing {
+		account.Purger = NewDisappearingPurger(account)
+		go account.Purger.Run()
+	}
+	if am.cfg.AutoDownloadMedia {
+		account.Downloader = NewAutoDownloader(account, am.cfg.autoDownloadConfig())
+		account.Downloader.Run()
+	}
+	account.registerEventHandlers()
+	go account.Scheduler.Run()
+	go account.Outbox.Run()
+	go account.Janitor.Run()
+	go account.Pruner.Run()
+	go account.MediaJobs.Run()
+	// Queued sends wait while the bridge is read-only
+	if !account.ReadOnly {
+		account.Broadcaster.Resume()
+	}
+
+	am.mu.Lock()
+	am.accounts[id] = account
+	am.mu.Unlock()
+
+	return account, nil
+}
+
+// Setup event handling for messages and history sync
+func (a *Account) registerEventHandlers() {
+	a.Client.AddEventHandler(func(evt interface{}) {
+		switch v := evt.(type) {
+		case *events.Message:
+			if !v.Info.IsFromMe {
+				bridgeMetrics.MessagesReceived.Inc(a.ID, messageMetricType(v.Message))
+			}
+			// Channel posts are kept apart from chats
+			if v.Info.Chat.Server == types.NewsletterServer {
+				a.handleNewsletterMessage(v)
+				return
+			}
+			// Reactions update the message they point at instead of being stored as messages
+			if reaction := v.Message.GetReactionMessage(); reaction != nil {
+				a.handleReaction(v, reaction)
+				return
+			}
+			// Poll votes are tallied against the poll they belong to
+			if v.Message.GetPollUpdateMessage() != nil {
+				a.handlePollVote(v)
+				return
+			}
+			// Orders are forwarded with their items, which takes a query
+			if order := v.Message.GetOrderMessage(); order != nil {
+				go a.handleOrder(v, order)
+				return
+			}
+			if poll := pollCreation(v.Message); poll != nil {
+				a.handlePollCreation(v, poll)
+				return
+			}
+			// Edits and revokes change the original message
+			if protocolMsg := v.Message.GetProtocolMessage(); protocolMsg != nil {
+				switch protocolMsg.GetType() {
+				case waProto.ProtocolMessage_MESSAGE_EDIT:
+					a.handleEdit(v, protocolMsg)
+				case waProto.ProtocolMessage_REVOKE:
+					a.handleRevoke(v, protocolMsg)
+				case waProto.ProtocolMessage_EPHEMERAL_SETTING:
+					a.handleDisappearingSetting(v, protocolMsg)
+				}
+				return
+			}
+			// Process regular messages
+			handleMessage(a.Client, a.MessageStore, v, a.Logger)
+			a.trackDisappearing(v)
+			a.stashThumbnail(v.Info.ID, v.Info.Chat, v.Message, isViewOnce(v))
+			if a.Downloader != nil {
+				a.Downloader.Enqueue(v)
+			}
+			a.notifyMessage(v)
+			a.notifyInteractiveReply(v)
+
+		case *events.Receipt:
+			// Reading a chat on the phone marks it read here too
+			if v.IsFromMe && (v.Type == types.ReceiptTypeRead || v.Type == types.ReceiptTypeReadSelf) {
+				a.handleReadSelf(v)
+			} else if !v.IsFromMe {
+				a.handleReceipt(v)
+			}
+
+		case *events.Presence:
+			a.handlePresence(v)
+
+		case *events.MediaRetry:
+			a.MediaRetries.Deliver(v)
+
+		case *events.ChatPresence:
+			a.handleChatPresence(v)
+
+		case *events.GroupInfo:
+			a.handleGroupInfo(v)
+
+		case *events.JoinedGroup:
+			a.handleJoinedGroup(v)
+
+		case *events.Pin, *events.Archive, *events.Mute:
+			a.handleChatStateEvent(v)
+
+		case *events.Star:
+			a.handleStarEvent(v)
+
+		case *events.LabelEdit, *events.LabelAssociationChat, *events.LabelAssociationMessage:
+			a.handleLabelEvent(v)
+
+		case *events.Contact, *events.PushName, *events.BusinessName, *events.AppStateSyncComplete:
+			a.handleContactEvent(v)
+
+		case *events.Blocklist:
+			a.handleBlocklist(v)
+
+		case *events.NewsletterJoin, *events.NewsletterLeave, *events.NewsletterMuteChange, *events.NewsletterLiveUpdate:
+			a.handleNewsletterEvent(v)
+
+		case *events.HistorySync:
+			// Process history sync events
+			a.handleHistorySync(v)
+
+		case *events.Connected:
+			a.Logger.Infof("Connected to WhatsApp")
+			a.Outbox.Wake()
+			go a.syncContacts()
+			go a.resubscribePresence()
+
+		case *events.LoggedOut:
+			a.Logger.Warnf("Device logged out, call /api/reauth and scan the new QR code to log in again")
+		}
+	})
+}
+
+// Send an incoming or outgoing message to webhooks subscribed to message events
+func (a *Account) notifyMessage(msg *events.Message) {
+	content := extractTextContent(msg.Message)
+	mediaType, filename, _, _, _, _, _ := extractMediaInfo(msg.Message)
+	if content == "" && mediaType == "" {
+		return
+	}
+
+	details := extractMessageDetails(msg.Message)
+	a.Notifier.Notify(a.ID, WebhookEventMessage, map[string]interface{}{
+		"id":                msg.Info.ID,
+		"chat_jid":          msg.Info.Chat.String(),
+		"sender":            msg.Info.Sender.User,
+		"push_name":         msg.Info.PushName,
+		"content":           content,
+		"caption":           details.Caption,
+		"message_type":      details.Type,
+		"quoted_message_id": details.QuotedMessageID,
+		"timestamp":         msg.Info.Timestamp,
+		"is_from_me":        msg.Info.IsFromMe,
+		"media_type":        mediaType,
+		"filename":          filename,
+		"duration_seconds":  details.Seconds,
+		"waveform":          waveformValues(details.Waveform),
+	})
+}
+
+// Info summarizes the account for API responses
+func (a *Account) Info() *AccountInfo {
+	info := &AccountInfo{
+		ID:        a.ID,
+		State:     a.Session.State(),
+		Connected: a.Client.IsConnected(),
+	}
+	if a.Client.Store.ID != nil {
+		info.JID = a.Client.Store.ID.String()
+	}
+	return info
+}
+
+// Close disconnects the client and releases the account databases
+func (a *Account) Close() {
+	a.Scheduler.Stop()
+	a.Broadcaster.Stop()
+	a.Outbox.Stop()
+	a.Janitor.Stop()
+	a.Pruner.Stop()
+	a.MediaJobs.Stop()
+	if a.Purger != nil {
+		a.Purger.Stop()
+	}
+	if a.Downloader != nil {
+		a.Downloader.Stop()
+	}
+	a.Session.Stop()
+	a.Client.Disconnect()
+	a.MessageStore.Close()
+	a.Container.Close()
+}
+
+// Get an account by ID
+func (am *AccountManager) Get(id string) (*Account, bool) {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	account, ok := am.accounts[id]
+	return account, ok
+}
+
+// List all accounts sorted by ID
+func (am *AccountManager) List() []*Account {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	accounts := make([]*Account, 0, len(am.accounts))
+	for _, account := range am.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	return accounts
+}
+
+// Create a new account and start pairing it
+func (am *AccountManager) Create(id string) (*Account, error) {
+	if !accountIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("account ID must be 1-64 letters, digits, dashes or underscores")
+	}
+	// Reserve the ID so a concurrent create can't open the same stores
+	am.mu.Lock()
+	if _, exists := am.accounts[id]; exists || am.creating[id] {
+		am.mu.Unlock()
+		return nil, fmt.Errorf("account %s already exists", id)
+	}
+	am.creating[id] = true
+	am.mu.Unlock()
+	defer func() {
+		am.mu.Lock()
+		delete(am.creating, id)
+		am.mu.Unlock()
+	}()
+
+	account, err := am.open(id)
+	if err != nil {
+		return nil, err
+	}
+	go account.Session.Run()
+	return account, nil
+}
+
+// Delete unlinks an account and removes its data from disk and, with
+// --database-url, its schema
+func (am *AccountManager) Delete(id string) error {
+	if id == DefaultAccountID {
+		return fmt.Errorf("the default account cannot be deleted")
+	}
+	account, ok := am.Get(id)
+	if !ok {
+		return fmt.Errorf("account %s not found", id)
+	}
+
+	if account.Client.Store.ID != nil {
+		if err := account.Client.Logout(); err != nil {
+			account.Logger.Warnf("Logout request failed, removing local data anyway: %v", err)
+		}
+	}
+	account.Close()
+
+	am.mu.Lock()
+	delete(am.accounts, id)
+	am.mu.Unlock()
+
+	if err := os.RemoveAll(account.Dir); err != nil {
+		return fmt.Errorf("failed to remove account data: %v", err)
+	}
+	if am.cfg.DatabaseURL != "" {
+		if err := dropPostgresSchema(am.cfg.DatabaseURL, id); err != nil {
+			return fmt.Errorf("failed to remove account data: %v", err)
+		}
+	}
+	return nil
+}
+
+// StartAll connects every account in the background
+func (am *AccountManager) StartAll() {
+	for _, account := range am.List() {
+		go account.Session.Run()
+	}
+}
+
+// Close disconnects all accounts
+func (am *AccountManager) Close() {
+	for _, account := range am.List() {
+		account.Close()
+	}
+}
+
+// Resolve the account targeted by a request via the account_id query parameter
+// (or X-Account-ID header), falling back to the default account
+func (am *AccountManager) FromRequest(r *http.Request) (*Account, error) {
+	id := r.URL.Query().Get("account_id")
+	if id == "" {
+		id = r.Header.Get("X-Account-ID")
+	}
+	if id == "" {
+		id = DefaultAccountID
+	}
+	account, ok := am.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("account %s not found", id)
+	}
+	return account, nil
+}
+
+// WithAccount wraps a handler so it receives the account the request targets
+func (am *AccountManager) WithAccount(handler func(account *Account, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, err := am.FromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		handler(account.forRequest(r), w, r)
+	}
+}
+
+// Register the /api/accounts CRUD endpoints
+func (am *AccountManager) registerHandlers() {
+	// List and create accounts
+	http.HandleFunc("/api/accounts", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+			infos := []*AccountInfo{}
+			for _, account := range am.List() {
+				infos = append(infos, account.Info())
+			}
+			json.NewEncoder(w).Encode(infos)
+
+		case http.MethodPost:
+			var req CreateAccountRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			account, err := am.Create(req.ID)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(AccountResponse{
+					Success: false,
+					Message: fmt.Sprintf("Failed to create account: %v", err),
+				})
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(AccountResponse{
+				Success: true,
+				Message: fmt.Sprintf("Account created, scan the QR code at /qr.html?account_id=%s", account.ID),
+				Account: account.Info(),
+			})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Read and delete a single account
+	http.HandleFunc("/api/accounts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+			account, ok := am.Get(id)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(AccountResponse{
+					Success: false,
+					Message: fmt.Sprintf("Account %s not found", id),
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(AccountResponse{
+				Success: true,
+				Message: "OK",
+				Account: account.Info(),
+			})
+
+		case http.MethodDelete:
+			if err := am.Delete(id); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(AccountResponse{
+					Success: false,
+					Message: fmt.Sprintf("Failed to delete account: %v", err),
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(AccountResponse{
+				Success: true,
+				Message: fmt.Sprintf("Account %s deleted", id),
+			})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request and response bytes kept to find the target and result; the payload
+// hash still covers the whole body
+const auditCaptureLimit = 64 * 1024
+
+// Fields naming who a request acts on, most specific first
+var auditTargetFields = []string{
+	"recipient", "chat_jid", "jid", "group_jid", "newsletter_jid", "business_jid", "phone_number",
+	"recipients", "jids", "phones", "phone_numbers",
+}
+
+// Context key of the MCP tool a request runs for
+type mcpToolContextKey struct{}
+
+// Context key of the address of the MCP client a request runs for
+type mcpRemoteAddrContextKey struct{}
+
+// AuditEntry records one mutating API call
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	Time       time.Time `json:"time"`
+	KeyID      string    `json:"key_id,omitempty"`
+	KeyName    string    `json:"key_name,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	// rest, or mcp for tool calls
+	Source    string `json:"source"`
+	Tool      string `json:"tool,omitempty"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	AccountID string `json:"account_id"`
+	TargetJID string `json:"target_jid,omitempty"`
+	// Hex SHA-256 of the request body; the body itself is not kept
+	PayloadSHA256 string `json:"payload_sha256"`
+	Status        int    `json:"status"`
+	// Message of the response, or its error text
+	Result string `json:"result,omitempty"`
+}
+
+// AuditListOptions filters the audit log
+type AuditListOptions struct {
+	KeyID     string
+	TargetJID string
+	// Path prefix, for example /api/send
+	Path   string
+	Since  time.Time
+	Until  time.Time
+	Before int64
+	Limit  int
+}
+
+// AuditListResponse represents the response for the audit log API. Entries
+// are newest first.
+type AuditListResponse struct {
+	Entries []AuditEntry `json:"entries"`
+	// Pass as cursor to read the next, older page; empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// AuditLog keeps an append-only record of mutating API and MCP calls in
+// audit.db. A nil log records nothing.
+type AuditLog struct {
+	db *sql.DB
+}
+
+// Open audit.db in the given directory
+func NewAuditLog(dir string) (*AuditLog, error) {
+	db, err := openSQLite(filepath.Join(dir, "audit.db"), "_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit database: %v", err)
+	}
+	// The triggers keep entries from being changed or removed through SQL
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			time TIMESTAMP,
+			key_id TEXT,
+			key_name TEXT,
+			remote_addr TEXT,
+			source TEXT,
+			tool TEXT,
+			method TEXT,
+			path TEXT,
+			account_id TEXT,
+			target_jid TEXT,
+			payload_sha256 TEXT,
+			status INTEGER,
+			result TEXT
+		);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_time ON audit_log (time);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log (target_jid);
+		CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'the audit log is append-only');
+		END;
+		CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'the audit log is append-only');
+		END;
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create audit table: %v", err)
+	}
+	return &AuditLog{db: db}, nil
+}
+
+// Close releases the audit database
+func (l *AuditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.db.Close()
+}
+
+// Append an entry
+func (l *AuditLog) Record(entry *AuditEntry) error {
+	_, err := l.db.Exec(`
+		INSERT INTO audit_log (time, key_id, key_name, remote_addr, source, tool, method, path,
+			account_id, target_jid, payload_sha256, status, result)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Time.UTC(), entry.KeyID, entry.KeyName, entry.RemoteAddr, entry.Source, entry.Tool, entry.Method, entry.Path,
+		entry.AccountID, entry.TargetJID, entry.PayloadSHA256, entry.Status, entry.Result,
+	)
+	return err
+}
+
+// List entries newest first, one page at a time
+func (l *AuditLog) List(opts AuditListOptions) (*AuditListResponse, error) {
+	var query strings.Builder
+	query.WriteString(`SELECT id, time, key_id, key_name, remote_addr, source, tool, method, path,
+		account_id, target_jid, payload_sha256, status, result FROM audit_log WHERE 1 = 1`)
+	var args []interface{}
+
+	if opts.KeyID != "" {
+		query.WriteString(" AND key_id = ?")
+		args = append(args, opts.KeyID)
+	}
+	if opts.TargetJID != "" {
+		// Requests to several recipients list them all
+		query.WriteString(" AND (target_jid = ? OR ',' || target_jid || ',' LIKE ?)")
+		args = append(args, opts.TargetJID, "%,"+opts.TargetJID+",%")
+	}
+	if opts.Path != "" {
+		query.WriteString(" AND path LIKE ?")
+		args = append(args, opts.Path+"%")
+	}
+	if !opts.Since.IsZero() {
+		query.WriteString(" AND time >= ?")
+		args = append(args, opts.Since.UTC())
+	}
+	if !opts.Until.IsZero() {
+		query.WriteString(" AND time < ?")
+		args = append(args, opts.Until.UTC())
+	}
+	if opts.Before > 0 {
+		query.WriteString(" AND id < ?")
+		args = append(args, opts.Before)
+	}
+
+	// Fetch one extra row to know whether there is another page
+	query.WriteString(" ORDER BY id DESC LIMIT ?")
+	args = append(args, opts.Limit+1)
+
+	rows, err := l.db.Query(query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	response := &AuditListResponse{Entries: []AuditEntry{}}
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Time, &entry.KeyID, &entry.KeyName, &entry.RemoteAddr, &entry.Source,
+			&entry.Tool, &entry.Method, &entry.Path, &entry.AccountID, &entry.TargetJID, &entry.PayloadSHA256,
+			&entry.Status, &entry.Result); err != nil {
+			return nil, err
+		}
+		if len(response.Entries) == opts.Limit {
+			response.NextCursor = strconv.FormatInt(response.Entries[len(response.Entries)-1].ID, 10)
+			break
+		}
+		response.Entries = append(response.Entries, entry)
+	}
+	return response, rows.Err()
+}
+
+// Request body reader that hashes everything read and keeps the start
+type auditBody struct {
+	io.ReadCloser
+	hash    hash.Hash
+	capture bytes.Buffer
+}
+
+func (b *auditBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	if room := auditCaptureLimit - b.capture.Len(); room > 0 {
+		b.capture.Write(p[:min(n, room)])
+	}
+	return n, err
+}
+
+// Response writer that notes the status and keeps the start of the body
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	capture bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if room := auditCaptureLimit - w.capture.Len(); room > 0 {
+		w.capture.Write(data[:min(len(data), room)])
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *auditResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijacking not supported")
+	}
+	return hijacker.Hijack()
+}
+
+// Middleware records every request that isn't a GET, HEAD or OPTIONS. It
+// runs after the API key middleware so it knows who made the call. /mcp
+// itself is skipped, as the tool calls it makes are recorded individually.
+func (l *AuditLog) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		case r.URL.Path == "/mcp":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body := &auditBody{ReadCloser: r.Body, hash: sha256.New()}
+		if r.Body == nil {
+			body.ReadCloser = http.NoBody
+		}
+		r.Body = body
+		recorder := &auditResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		// Hash what the handler didn't read too
+		io.Copy(io.Discard, body)
+
+		entry := &AuditEntry{
+			Time:          time.Now(),
+			Source:        "rest",
+			Method:        r.Method,
+			Path:          r.URL.Path,
+			AccountID:     r.URL.Query().Get("account_id"),
+			PayloadSHA256: hex.EncodeToString(body.hash.Sum(nil)),
+			Status:        recorder.status,
+			Result:        auditResult(recorder.capture.Bytes()),
+		}
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		if entry.AccountID == "" {
+			entry.AccountID = r.Header.Get("X-Account-ID")
+		}
+		if entry.AccountID == "" {
+			entry.AccountID = DefaultAccountID
+		}
+		if key, _ := r.Context().Value(apiKeyContextKey{}).(*APIKey); key != nil {
+			entry.KeyID, entry.KeyName = key.ID, key.Name
+		}
+		if tool, _ := r.Context().Value(mcpToolContextKey{}).(string); tool != "" {
+			entry.Source, entry.Tool = "mcp", tool
+		}
+		if ip := clientIP(r); ip != nil {
+			entry.RemoteAddr = ip.String()
+		}
+		entry.TargetJID = auditTarget(r, body.capture.Bytes())
+
+		if err := l.Record(entry); err != nil {
+			bridgeLog.Errorf("Failed to record audit entry for %s %s: %v", r.Method, r.URL.Path, err)
+		}
+	})
+}
+
+// Find who a request acts on from its JSON or form fields, or from a JID in its path
+func auditTarget(r *http.Request, body []byte) string {
+	fields := map[string]interface{}{}
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")):
+		// Handlers decode JSON whatever the content type, as curl -d sends a form type
+		json.Unmarshal(body, &fields)
+	case mediaType == "multipart/form-data":
+		// Only the part of the body that was captured is read
+		reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FileName() == "" {
+				value, _ := io.ReadAll(io.LimitReader(part, 1024))
+				fields[part.FormName()] = string(value)
+			}
+		}
+	case mediaType == "application/x-www-form-urlencoded":
+		values, _ := url.ParseQuery(string(body))
+		for name := range values {
+			fields[name] = values.Get(name)
+		}
+	}
+
+	for _, name := range auditTargetFields {
+		switch value := fields[name].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case []interface{}:
+			var targets []string
+			for _, item := range value {
+				if s, ok := item.(string); ok && s != "" {
+					targets = append(targets, s)
+				}
+			}
+			if len(targets) > 0 {
+				return strings.Join(targets, ",")
+			}
+		}
+	}
+
+	for _, segment := range strings.Split(r.URL.Path, "/") {
+		if strings.Contains(segment, "@") {
+			return segment
+		}
+	}
+	return ""
+}
+
+// The message or error text of a response
+func auditResult(body []byte) string {
+	var response struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(body, &response) == nil {
+		if response.Message != "" {
+			return response.Message
+		}
+		if response.Error != "" {
+			return response.Error
+		}
+	}
+	// Plain text errors from http.Error
+	text := strings.TrimSpace(string(body))
+	if len(text) > 200 || strings.HasPrefix(text, "{") || strings.HasPrefix(text, "[") {
+		return ""
+	}
+	return text
+}
+
+// Handle GET /api/audit
+func (l *AuditLog) handleList(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := AuditListOptions{
+		KeyID:     query.Get("key_id"),
+		TargetJID: strings.TrimPrefix(query.Get("target"), "+"),
+		Path:      query.Get("path"),
+		Limit:     defaultHistoryPageSize,
+	}
+	for param, target := range map[string]*time.Time{"since": &opts.Since, "until": &opts.Until} {
+		if value := query.Get(param); value != "" {
+			t, err := parseTimeParam(value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s: %v", param, err), http.StatusBadRequest)
+				return
+			}
+			*target = t
+		}
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		before, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || before < 1 {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		opts.Before = before
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		opts.Limit = min(limit, maxHistoryPageSize)
+	}
+
+	response, err := l.List(opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Register the audit log endpoint
+func (l *AuditLog) registerHandlers() {
+	if l == nil {
+		return
+	}
+	http.HandleFunc("/api/audit", l.handleList)
+}
+
+// Tag the context of an in-process request with the MCP tool it runs for
+func withMCPTool(ctx context.Context, tool string) context.Context {
+	return context.WithValue(ctx, mcpToolContextKey{}, tool)
+}
+
+// Tag the context of an MCP request with the address of its client
+func withMCPRemoteAddr(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, mcpRemoteAddrContextKey{}, addr)
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes an API key can hold. admin allows everything, including managing
+// pairing, accounts, webhooks and keys.
+const (
+	ScopeRead  = "read"
+	ScopeSend  = "send"
+	ScopeAdmin = "admin"
+)
+
+// Prefix of the IDs of keys from --api-keys
+const configAPIKeyPrefix = "config-"
+
+// Endpoints that need the admin scope; everything else needs read for GET
+// and send for other methods
+var adminPaths = []string{
+	"/api/qr", "/qr.html", "/api/reauth", "/api/session", "/api/pair-phone",
+	"/api/accounts", "/api/webhooks", "/api/keys", "/api/audit", "/api/policy", "/api/log-level",
+	"/api/admin",
+}
+
+// Probes that orchestrators call without a key
+var publicPaths = []string{"/healthz", "/readyz"}
+
+// POST endpoints that only read, so read keys and --read-only allow them
+var readPostPaths = []string{"/api/download", "/api/contacts/check", "/api/sync/request"}
+
+// POST endpoints for pairing the bridge, which --read-only still allows. The
+// QR code is read with GET; reauth and DELETE /api/session unlink the device,
+// so they stay refused.
+var pairingPaths = []string{"/api/pair-phone"}
+
+// APIKey grants the holder of its secret some scopes
+type APIKey struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name,omitempty"`
+	Scopes []string `json:"scopes"`
+	// Requests allowed per minute, 0 for the --rate-limit default
+	RateLimit int       `json:"rate_limit,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Hex SHA-256 of the secret; secrets themselves are never stored
+	hash string
+}
+
+// CreateAPIKeyRequest represents the request body for creating an API key
+type CreateAPIKeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	RateLimit int      `json:"rate_limit"`
+}
+
+// APIKeyResponse represents the response for the API key management APIs
+type APIKeyResponse struct {
+	Success bool    `json:"success"`
+	Message string  `json:"message"`
+	Key     *APIKey `json:"key,omitempty"`
+	// Only returned when the key is created
+	Secret string `json:"secret,omitempty"`
+}
+
+// Context key of the API key a request was authenticated with
+type apiKeyContextKey struct{}
+
+// Report whether a key holds a scope
+func (k *APIKey) allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Hash a secret the way keys are stored
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Check and deduplicate a list of scopes
+func parseScopes(scopes []string) ([]string, error) {
+	seen := map[string]bool{}
+	var result []string
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		switch scope {
+		case ScopeRead, ScopeSend, ScopeAdmin:
+		default:
+			return nil, fmt.Errorf("unknown scope %q, expected read, send or admin", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return result, nil
+}
+
+// Parse --api-keys: comma separated secret[:scope+scope] entries, where a
+// key without scopes is an admin key
+func parseConfigAPIKeys(value string) ([]*APIKey, error) {
+	var keys []*APIKey
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		secret, scopeList, _ := strings.Cut(entry, ":")
+		if secret == "" {
+			return nil, fmt.Errorf("empty key in %q", entry)
+		}
+		if scopeList == "" {
+			scopeList = ScopeAdmin
+		}
+		scopes, err := parseScopes(strings.Split(scopeList, "+"))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &APIKey{
+			ID:        fmt.Sprintf("%s%d", configAPIKeyPrefix, len(keys)+1),
+			Name:      "from --api-keys",
+			Scopes:    scopes,
+			CreatedAt: time.Now(),
+			hash:      hashAPIKey(secret),
+		})
+	}
+	return keys, nil
+}
+
+// APIKeyStore holds the keys from the config plus those created through the
+// API. With no keys at all the bridge is open, as it always was.
+type APIKeyStore struct {
+	db   *sql.DB
+	mu   sync.RWMutex
+	keys []*APIKey
+}
+
+// Load the keys from the config and from api_keys.db in the given directory
+func NewAPIKeyStore(cfg *Config, dir string) (*APIKeyStore, error) {
+	keys, err := parseConfigAPIKeys(cfg.APIKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --api-keys: %v", err)
+	}
+
+	db, err := openSQLite(filepath.Join(dir, "api_keys.db"), "_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open API key database: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id TEXT PRIMARY KEY,
+			name TEXT,
+			scopes TEXT,
+			hash TEXT UNIQUE,
+			rate_limit INTEGER DEFAULT 0,
+			created_at TIMESTAMP
+		);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create API key table: %v", err)
+	}
+
+	rows, err := db.Query("SELECT id, name, scopes, hash, rate_limit, created_at FROM api_keys ORDER BY created_at")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load API keys: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key APIKey
+		var scopes string
+		if err := rows.Scan(&key.ID, &key.Name, &scopes, &key.hash, &key.RateLimit, &key.CreatedAt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to load API keys: %v", err)
+		}
+		key.Scopes = strings.Split(scopes, ",")
+		keys = append(keys, &key)
+	}
+	return &APIKeyStore{db: db, keys: keys}, rows.Err()
+}
+
+// Close releases the API key database
+func (s *APIKeyStore) Close() error {
+	return s.db.Close()
+}
+
+// Snapshot of the current keys
+func (s *APIKeyStore) List() []*APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*APIKey(nil), s.keys...)
+}
+
+// Report whether requests need a key
+func (s *APIKeyStore) Enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys) > 0
+}
+
+// Find the key with a secret
+func (s *APIKeyStore) Lookup(secret string) *APIKey {
+	hash := []byte(hashAPIKey(secret))
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if subtle.ConstantTimeCompare(hash, []byte(key.hash)) == 1 {
+			return key
+		}
+	}
+	return nil
+}
+
+// Create a key, returning it with its secret
+func (s *APIKeyStore) Create(name string, scopes []string, rateLimit int) (*APIKey, string, error) {
+	b := make([]byte, 24)
+	rand.Read(b)
+	secret := "wak_" + hex.EncodeToString(b)
+	key := &APIKey{ID: newJobID(), Name: name, Scopes: scopes, RateLimit: rateLimit, CreatedAt: time.Now(), hash: hashAPIKey(secret)}
+
+	_, err := s.db.Exec(
+		"INSERT INTO api_keys (id, name, scopes, hash, rate_limit, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		key.ID, key.Name, strings.Join(key.Scopes, ","), key.hash, key.RateLimit, key.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	s.mu.Lock()
+	s.keys = append(s.keys, key)
+	s.mu.Unlock()
+	return key, secret, nil
+}
+
+// Revoke a key created through the API
+func (s *APIKeyStore) Remove(id string) (bool, error) {
+	result, err := s.db.Exec("DELETE FROM api_keys WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, key := range s.keys {
+		if key.ID == id {
+			s.keys = append(s.keys[:i], s.keys[i+1:]...)
+			break
+		}
+	}
+	return true, nil
+}
+
+// The secret a request carries in the Authorization or X-API-Key header, or
+// the api_key query parameter for browsers and EventSource
+func requestAPIKey(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// The scope a request needs
+func requiredScope(r *http.Request) string {
+	return scopeFor(r.Method, r.URL.Path)
+}
+
+// The scope needed to call a method on a path
+func scopeFor(method, path string) string {
+	for _, admin := range adminPaths {
+		if path == admin || strings.HasPrefix(path, admin+"/") {
+			return ScopeAdmin
+		}
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ScopeRead
+	}
+	for _, read := range readPostPaths {
+		if path == read {
+			return ScopeRead
+		}
+	}
+	return ScopeSend
+}
+
+// Report whether a path is served without a key
+func isPublicPath(path string) bool {
+	for _, public := range publicPaths {
+		if path == public {
+			return true
+		}
+	}
+	return false
+}
+
+// Report whether --read-only lets a request through: reads, the POST
+// endpoints that only read, and pairing. Tool calls made through /mcp are
+// checked when they reach their route.
+func readOnlyAllows(method, path string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if path == "/mcp" {
+		return true
+	}
+	for _, read := range readPostPaths {
+		if path == read {
+			return true
+		}
+	}
+	for _, pairing := range pairingPaths {
+		if path == pairing {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware refusing everything that sends or changes anything, admin
+// endpoints included, for --read-only. History, search, media downloads and
+// the event streams keep working, as does pairing the bridge, but not
+// unlinking it.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !readOnlyAllows(r.Method, r.URL.Path) {
+			http.Error(w, "The bridge is in read-only mode", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Wrap a handler so every request needs a key with the right scope once any
+// key exists. Requests made in process on behalf of an authenticated request,
+// such as MCP tool calls, carry its key in their context.
+func (s *APIKeyStore) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.Enabled() || isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, _ := r.Context().Value(apiKeyContextKey{}).(*APIKey)
+		if key == nil {
+			if key = s.Lookup(requestAPIKey(r)); key == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="whatsapp-bridge"`)
+				http.Error(w, "A valid API key is required", http.StatusUnauthorized)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key))
+		}
+		if scope := requiredScope(r); !key.allows(scope) {
+			http.Error(w, fmt.Sprintf("This API key lacks the %s scope", scope), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Register the /api/keys endpoints
+func (s *APIKeyStore) registerHandlers() {
+	// List and create keys
+	http.HandleFunc("/api/keys", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(s.List())
+
+		case http.MethodPost:
+			var req CreateAPIKeyRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			scopes, err := parseScopes(req.Scopes)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.RateLimit < 0 {
+				http.Error(w, "rate_limit must not be negative", http.StatusBadRequest)
+				return
+			}
+			key, secret, err := s.Create(strings.TrimSpace(req.Name), scopes, req.RateLimit)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(APIKeyResponse{Success: false, Message: fmt.Sprintf("Failed to create API key: %v", err)})
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(APIKeyResponse{Success: true, Message: "API key created; store the secret now, it is not shown again", Key: key, Secret: secret})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Revoke a key
+	http.HandleFunc("/api/keys/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := r.PathValue("id")
+		w.Header().Set("Content-Type", "application/json")
+
+		if strings.HasPrefix(id, configAPIKeyPrefix) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIKeyResponse{Success: false, Message: "Keys from --api-keys can only be removed from the config"})
+			return
+		}
+		removed, err := s.Remove(id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIKeyResponse{Success: false, Message: fmt.Sprintf("Failed to revoke API key: %v", err)})
+			return
+		}
+		if !removed {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(APIKeyResponse{Success: false, Message: "API key not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(APIKeyResponse{Success: true, Message: "API key revoked"})
+	})
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReadOnlyKeepsTheDeviceLinked(t *testing.T) {
+	for _, tc := range []struct {
+		method, path string
+		allowed      bool
+	}{
+		{http.MethodGet, "/api/qr", true},
+		{http.MethodGet, "/qr.html", true},
+		{http.MethodPost, "/api/pair-phone", true},
+		{http.MethodPost, "/api/download", true},
+		{http.MethodPost, "/api/reauth", false},
+		{http.MethodDelete, "/api/session", false},
+		{http.MethodPost, "/api/messages/text", false},
+		{http.MethodDelete, "/api/accounts/work", false},
+	} {
+		if got := readOnlyAllows(tc.method, tc.path); got != tc.allowed {
+			t.Errorf("read-only %s %s allowed=%v, want %v", tc.method, tc.path, got, tc.allowed)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Downloads waiting beyond this are dropped; /api/download still fetches them
+const mediaQueueSize = 256
+
+// AutoDownloadConfig selects which incoming media is downloaded
+type AutoDownloadConfig struct {
+	Workers int
+	// Larger files are left for on-demand download
+	MaxSize int64
+	// Media types to download: image, video, audio and document
+	Types map[string]bool
+}
+
+// Parse a comma separated list of media types
+func parseMediaTypes(value string) (map[string]bool, error) {
+	types := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "":
+			continue
+		case MediaKindImage, MediaKindVideo, MediaKindAudio, MediaKindDocument:
+			types[name] = true
+		default:
+			return nil, fmt.Errorf("unknown media type %q, expected image, video, audio or document", name)
+		}
+	}
+	return types, nil
+}
+
+// Record where a message's media was saved
+func (store *MessageStore) SetLocalMedia(id, chatJID, path string, size int64, mimeType string) error {
+	_, err := store.db.Exec(
+		"UPDATE messages SET local_path = ?, local_size = ?, local_mime = ?, downloaded_at = ? WHERE id = ? AND chat_jid = ?",
+		path, size, mimeType, time.Now().UTC(), id, chatJID,
+	)
+	return err
+}
+
+// Get where a message's media was saved, empty if it wasn't downloaded yet
+func (store *MessageStore) GetLocalMedia(id, chatJID string) (string, string, error) {
+	var path, mimeType sql.NullString
+	err := store.db.QueryRow("SELECT local_path, local_mime FROM messages WHERE id = ? AND chat_jid = ?", id, chatJID).Scan(&path, &mimeType)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	return path.String, mimeType.String, err
+}
+
+// Pick the file name of downloaded media, keyed by message ID so names never
+// clash and the file can be found again without the database
+func localMediaName(messageID, filename, mimeType string) string {
+	ext := filepath.Ext(filename)
+	if ext == "" && mimeType != "" {
+		if exts, _ := mime.ExtensionsByType(strings.Split(mimeType, ";")[0]); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	return messageID + ext
+}
+
+// A message whose media should be downloaded
+type mediaJob struct {
+	messageID string
+	chatJID   string
+}
+
+// AutoDownloader downloads and decrypts incoming media in the background
+type AutoDownloader struct {
+	account *Account
+	cfg     AutoDownloadConfig
+	jobs    chan mediaJob
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Create an auto downloader for an account; Run starts its workers
+func NewAutoDownloader(account *Account, cfg AutoDownloadConfig) *AutoDownloader {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	return &AutoDownloader{
+		account: account,
+		cfg:     cfg,
+		jobs:    make(chan mediaJob, mediaQueueSize),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Run starts the download workers
+func (d *AutoDownloader) Run() {
+	for i := 0; i < d.cfg.Workers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for {
+				select {
+				case job := <-d.jobs:
+					if err := d.download(job); err != nil {
+						d.account.Logger.Warnf("Failed to download media of %s: %v", job.messageID, err)
+					}
+				case <-d.stop:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Stop the workers and wait for in-flight downloads to finish. Queued
+// downloads are dropped and can still be fetched on demand.
+func (d *AutoDownloader) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// Queue the media of an incoming message if it passes the type and size limits
+func (d *AutoDownloader) Enqueue(msg *events.Message) {
+	mediaType, _, url, _, _, _, fileLength := extractMediaInfo(msg.Message)
+	if mediaType == "" || url == "" || !d.cfg.Types[mediaType] {
+		return
+	}
+	// View-once media keys are dropped unless the operator opted in
+	if isViewOnce(msg) && !d.account.MessageStore.keepViewOnceMedia {
+		return
+	}
+	if d.cfg.MaxSize > 0 && int64(fileLength) > d.cfg.MaxSize {
+		d.account.Logger.Debugf("Not downloading %s, %d bytes is over the size cap", msg.Info.ID, fileLength)
+		return
+	}
+
+	select {
+	case <-d.stop:
+	case d.jobs <- mediaJob{messageID: msg.Info.ID, chatJID: msg.Info.Chat.String()}:
+	default:
+		d.account.Logger.Warnf("Media download queue is full, skipping %s", msg.Info.ID)
+	}
+}
+
+// Download, decrypt and save the media of a stored message
+func (d *AutoDownloader) download(job mediaJob) error {
+	chat, err := parseRecipient(job.chatJID)
+	if err != nil {
+		return err
+	}
+	_, _, err = d.account.messageMedia(chat, job.messageID)
+	return err
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// How long a cached profile picture is served before checking for a new one
+const avatarRefreshInterval = time.Hour
+
+// Limits for downloading profile pictures
+const (
+	avatarFetchTimeout = 30 * time.Second
+	maxAvatarSize      = 5 << 20
+)
+
+// Sizes profile pictures come in
+const (
+	AvatarFull    = "image"
+	AvatarPreview = "preview"
+)
+
+var errAvatarNotCached = errors.New("profile picture not cached")
+
+// A profile picture saved on disk
+type cachedAvatar struct {
+	PictureID string
+	Path      string
+	FetchedAt time.Time
+}
+
+// Get the cached profile picture of a contact or group
+func (store *MessageStore) GetAvatar(jid, kind string) (*cachedAvatar, error) {
+	var avatar cachedAvatar
+	err := store.db.QueryRow(
+		"SELECT picture_id, path, fetched_at FROM avatars WHERE jid = ? AND type = ?",
+		jid, kind,
+	).Scan(&avatar.PictureID, &avatar.Path, &avatar.FetchedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &avatar, nil
+}
+
+// Save a downloaded profile picture and remember its ID for change detection
+func (store *MessageStore) StoreAvatar(jid, kind, pictureID string, data []byte) (*cachedAvatar, error) {
+	dir := filepath.Join(store.dir, "avatars")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	avatar := &cachedAvatar{
+		PictureID: pictureID,
+		Path:      filepath.Join(dir, fmt.Sprintf("%s-%s.jpg", strings.ReplaceAll(jid, ":", "_"), kind)),
+		FetchedAt: time.Now().UTC(),
+	}
+
+	// Write to a temporary file first so readers never see half a picture
+	tmp := avatar.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, avatar.Path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	_, err := store.db.Exec(
+		`INSERT INTO avatars (jid, type, picture_id, path, fetched_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (jid, type) DO UPDATE SET picture_id = excluded.picture_id, path = excluded.path, fetched_at = excluded.fetched_at`,
+		jid, kind, avatar.PictureID, avatar.Path, avatar.FetchedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return avatar, nil
+}
+
+// Note that a cached profile picture is still current
+func (store *MessageStore) TouchAvatar(jid, kind string) error {
+	_, err := store.db.Exec("UPDATE avatars SET fetched_at = ? WHERE jid = ? AND type = ?", time.Now().UTC(), jid, kind)
+	return err
+}
+
+// Forget a profile picture that was removed
+func (store *MessageStore) DeleteAvatar(jid, kind string) error {
+	avatar, err := store.GetAvatar(jid, kind)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	os.Remove(avatar.Path)
+	_, err = store.db.Exec("DELETE FROM avatars WHERE jid = ? AND type = ?", jid, kind)
+	return err
+}
+
+// Download a profile picture from the WhatsApp CDN
+func fetchAvatar(url string) ([]byte, error) {
+	client := &http.Client{Timeout: avatarFetchTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch profile picture: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch profile picture: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAvatarSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile picture: %v", err)
+	}
+	if len(data) > maxAvatarSize {
+		return nil, fmt.Errorf("profile picture is larger than %d MB", maxAvatarSize>>20)
+	}
+	return data, nil
+}
+
+// Get a profile picture, from the cache while it is fresh and otherwise from
+// WhatsApp. The cached picture ID is sent along, so an unchanged picture is
+// not downloaded again. When offline a stale cached picture is still served.
+func (a *Account) avatar(jid types.JID, kind string, refresh bool) (*cachedAvatar, error) {
+	cached, err := a.MessageStore.GetAvatar(jid.String(), kind)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if cached != nil {
+		if _, err := os.Stat(cached.Path); err != nil {
+			cached = nil
+		}
+	}
+	if cached != nil && !refresh && time.Since(cached.FetchedAt) < avatarRefreshInterval {
+		return cached, nil
+	}
+	if !a.Client.IsConnected() {
+		if cached != nil {
+			return cached, nil
+		}
+		return nil, errNotConnected
+	}
+
+	params := &whatsmeow.GetProfilePictureParams{Preview: kind == AvatarPreview}
+	if cached != nil {
+		params.ExistingID = cached.PictureID
+	}
+	if jid.Server == types.GroupServer {
+		if info, err := a.Client.GetGroupInfo(jid); err == nil && info.IsParent {
+			params.IsCommunity = true
+		}
+	}
+	info, err := a.Client.GetProfilePictureInfo(jid, params)
+	if err == whatsmeow.ErrProfilePictureNotSet {
+		if err := a.MessageStore.DeleteAvatar(jid.String(), kind); err != nil {
+			a.Logger.Warnf("Failed to remove cached profile picture of %s: %v", jid, err)
+		}
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	// No info means the picture hasn't changed since it was cached
+	if info == nil {
+		if cached == nil {
+			return nil, errAvatarNotCached
+		}
+		if err := a.MessageStore.TouchAvatar(jid.String(), kind); err != nil {
+			a.Logger.Warnf("Failed to update cached profile picture of %s: %v", jid, err)
+		}
+		return cached, nil
+	}
+
+	data, err := fetchAvatar(info.URL)
+	if err != nil {
+		return nil, err
+	}
+	return a.MessageStore.StoreAvatar(jid.String(), kind, info.ID, data)
+}
+
+// Handle GET /api/contacts/{jid}/avatar. Serves the full picture, or the
+// thumbnail with preview=true; refresh=true skips the cache.
+func (a *Account) HandleAvatarEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET and HEAD requests
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jid, err := parseRecipient(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jid = jid.ToNonAD()
+
+	query := r.URL.Query()
+	kind := AvatarFull
+	if preview, _ := strconv.ParseBool(query.Get("preview")); preview {
+		kind = AvatarPreview
+	}
+	refresh, _ := strconv.ParseBool(query.Get("refresh"))
+
+	avatar, err := a.avatar(jid, kind, refresh)
+	switch {
+	case err == whatsmeow.ErrProfilePictureNotSet:
+		http.Error(w, "No profile picture set", http.StatusNotFound)
+		return
+	case err == whatsmeow.ErrProfilePictureUnauthorized:
+		http.Error(w, "Profile picture is hidden by privacy settings", http.StatusForbidden)
+		return
+	case err == errNotConnected:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Failed to get profile picture: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	file, err := os.Open(avatar.Path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read profile picture: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	// The picture ID changes whenever the picture does, so it makes a good ETag
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("ETag", strconv.Quote(avatar.PictureID))
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(avatarRefreshInterval.Seconds())))
+	w.Header().Set("X-Picture-ID", avatar.PictureID)
+	http.ServeContent(w, r, filepath.Base(avatar.Path), avatar.FetchedAt, file)
+}
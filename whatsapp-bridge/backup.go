@@ -0,0 +1,377 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Name of the file describing a backup, first in the archive
+const backupManifestName = "manifest.json"
+
+// File in the store recording the backup last restored, so a --restore left
+// in place doesn't undo everything since on the next start
+const restoredMarkerName = "restored-from"
+
+// Files a backup may hold, laid out as in the store directory
+var backupEntryPattern = regexp.MustCompile(`^(accounts/[A-Za-z0-9_-]{1,64}/)?(whatsapp\.db|messages\.db|media-index\.json)$|^(webhooks|api_keys|audit)\.db$|^manifest\.json$`)
+
+// BackupManifest describes what a backup holds
+type BackupManifest struct {
+	CreatedAt time.Time       `json:"created_at"`
+	Accounts  []BackupAccount `json:"accounts"`
+	Files     []string        `json:"files"`
+}
+
+// BackupAccount is an account in a backup
+type BackupAccount struct {
+	ID string `json:"id"`
+	// Empty when the account wasn't paired
+	JID string `json:"jid,omitempty"`
+	// Directory of its files within the archive, empty for the default account
+	Dir string `json:"dir,omitempty"`
+	// Schema version of its message database
+	SchemaVersion int `json:"schema_version"`
+	// Downloaded media listed in its media index
+	MediaFiles int `json:"media_files"`
+}
+
+// MediaIndexEntry is a downloaded file listed in a backup's media index. The
+// files themselves stay where --media-dir or --media-storage put them.
+type MediaIndexEntry struct {
+	MessageID    string     `json:"message_id"`
+	ChatJID      string     `json:"chat_jid"`
+	MediaType    string     `json:"media_type,omitempty"`
+	Path         string     `json:"path"`
+	Size         int64      `json:"size"`
+	DownloadedAt *time.Time `json:"downloaded_at,omitempty"`
+}
+
+// Every downloaded media file, for the media index of a backup
+func (store *MessageStore) MediaIndex() ([]MediaIndexEntry, error) {
+	rows, err := store.db.Query(`
+		SELECT id, chat_jid, COALESCE(media_type, ''), local_path, COALESCE(local_size, 0), downloaded_at
+		FROM messages WHERE COALESCE(local_path, '') != '' ORDER BY chat_jid, timestamp`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []MediaIndexEntry{}
+	for rows.Next() {
+		var entry MediaIndexEntry
+		var downloadedAt sql.NullTime
+		if err := rows.Scan(&entry.MessageID, &entry.ChatJID, &entry.MediaType, &entry.Path, &entry.Size, &downloadedAt); err != nil {
+			return nil, err
+		}
+		if downloadedAt.Valid {
+			entry.DownloadedAt = &downloadedAt.Time
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Backups writes consistent copies of the session, message and bridge
+// databases into a tar archive, which --restore unpacks on a new host
+type Backups struct {
+	accounts *AccountManager
+	// Databases not owned by an account, by file name
+	databases map[string]*sql.DB
+	// One backup at a time
+	running sync.Mutex
+}
+
+// Create backups of the accounts and the bridge-wide databases
+func NewBackups(accounts *AccountManager, keys *APIKeyStore, audit *AuditLog) *Backups {
+	databases := map[string]*sql.DB{
+		"webhooks.db": accounts.notifier.db,
+		"api_keys.db": keys.db,
+	}
+	if audit != nil {
+		databases["audit.db"] = audit.db
+	}
+	return &Backups{accounts: accounts, databases: databases}
+}
+
+// Copy a live database into a new file with the SQLite online backup API,
+// which sees one consistent snapshot while writers carry on. An encrypted
+// database is copied encrypted with the same key.
+func backupSQLite(src *sql.DB, file string) error {
+	dest, err := openSQLite(file, "")
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	ctx := context.Background()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			destSQLite, ok := destDriver.(*sqlite3.SQLiteConn)
+			srcSQLite, ok2 := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return fmt.Errorf("not a SQLite connection")
+			}
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			// Copy every page in one step so no write lands halfway through
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// Write every database and media index into dir, returning the manifest
+func (b *Backups) snapshot(dir string) (*BackupManifest, error) {
+	manifest := &BackupManifest{CreatedAt: time.Now().UTC()}
+	for name, db := range b.databases {
+		if err := backupSQLite(db, filepath.Join(dir, name)); err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %v", name, err)
+		}
+		manifest.Files = append(manifest.Files, name)
+	}
+
+	for _, account := range b.accounts.List() {
+		entry := BackupAccount{ID: account.ID}
+		if account.ID != DefaultAccountID {
+			entry.Dir = path.Join("accounts", account.ID)
+			if err := os.MkdirAll(filepath.Join(dir, entry.Dir), 0700); err != nil {
+				return nil, err
+			}
+		}
+		if account.Client.Store.ID != nil {
+			entry.JID = account.Client.Store.ID.String()
+		}
+		migrator, err := newMessageMigrator(account.MessageStore.db)
+		if err == nil {
+			entry.SchemaVersion, err = migrator.Version()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the schema version of %s: %v", account.ID, err)
+		}
+
+		databases := map[string]*sql.DB{"whatsapp.db": account.sessionDB, "messages.db": account.MessageStore.db.DB}
+		for file, db := range databases {
+			name := path.Join(entry.Dir, file)
+			if err := backupSQLite(db, filepath.Join(dir, name)); err != nil {
+				return nil, fmt.Errorf("failed to back up %s: %v", name, err)
+			}
+			manifest.Files = append(manifest.Files, name)
+		}
+
+		index, err := account.MessageStore.MediaIndex()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the media of %s: %v", account.ID, err)
+		}
+		data, err := json.MarshalIndent(index, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		name := path.Join(entry.Dir, "media-index.json")
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, name)
+		entry.MediaFiles = len(index)
+		manifest.Accounts = append(manifest.Accounts, entry)
+	}
+	sort.Strings(manifest.Files)
+	sort.Slice(manifest.Accounts, func(i, j int) bool { return manifest.Accounts[i].ID < manifest.Accounts[j].ID })
+	return manifest, nil
+}
+
+// Write the manifest and then the snapshot files to a tar archive
+func writeBackupArchive(w io.Writer, dir string, manifest *BackupManifest) error {
+	tw := tar.NewWriter(w)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: backupManifestName, Mode: 0600, Size: int64(len(data)), ModTime: manifest.CreatedAt}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	for _, name := range manifest.Files {
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err == nil {
+			err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: info.Size(), ModTime: manifest.CreatedAt})
+		}
+		if err == nil {
+			_, err = io.Copy(tw, f)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// Handle POST /api/admin/backup, answering with a tar of the databases
+func (b *Backups) HandleBackupEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if b.accounts.cfg.DatabaseURL != "" {
+		http.Error(w, "Sessions and messages are in Postgres; back them up with pg_dump", http.StatusNotImplemented)
+		return
+	}
+
+	b.running.Lock()
+	defer b.running.Unlock()
+
+	dir, err := os.MkdirTemp("", "whatsapp-backup-")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create backup: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// Snapshot everything before answering, so a failure still gets a status
+	manifest, err := b.snapshot(dir)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create backup: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="whatsapp-backup-%s.tar"`, manifest.CreatedAt.Format("20060102-150405")))
+	if err := writeBackupArchive(w, dir, manifest); err != nil {
+		bridgeLog.Warnf("Failed to send backup: %v", err)
+		return
+	}
+	bridgeLog.Infof("Backed up %d accounts", len(manifest.Accounts))
+}
+
+// Unpack a backup into the store directory before anything opens it.
+// Databases already there are kept beside it with a .pre-restore suffix.
+func restoreBackup(cfg *Config, baseDir, archive string) error {
+	if cfg.DatabaseURL != "" {
+		return fmt.Errorf("--restore only applies to SQLite stores; restore Postgres with pg_restore")
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var manifest *BackupManifest
+	restored := 0
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", archive, err)
+		}
+		name := header.Name
+		if header.Typeflag != tar.TypeReg || !backupEntryPattern.MatchString(name) {
+			return fmt.Errorf("unexpected entry %s in %s", name, archive)
+		}
+
+		if name == backupManifestName {
+			manifest = &BackupManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return fmt.Errorf("invalid manifest: %v", err)
+			}
+			if last, err := os.ReadFile(filepath.Join(baseDir, restoredMarkerName)); err == nil && string(last) == manifest.CreatedAt.Format(time.RFC3339Nano) {
+				bridgeLog.Infof("Backup taken at %s was already restored, starting without restoring it again", manifest.CreatedAt.Format(time.RFC3339))
+				return nil
+			}
+			continue
+		}
+		if manifest == nil {
+			return fmt.Errorf("%s doesn't start with a manifest", archive)
+		}
+		// The media index describes the backup and has no place in the store
+		if strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if err := restoreBackupFile(filepath.Join(baseDir, filepath.FromSlash(name)), tr); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", name, err)
+		}
+		restored++
+	}
+	if manifest == nil {
+		return fmt.Errorf("%s holds no manifest", archive)
+	}
+	if err := os.WriteFile(filepath.Join(baseDir, restoredMarkerName), []byte(manifest.CreatedAt.Format(time.RFC3339Nano)), 0600); err != nil {
+		return err
+	}
+	bridgeLog.Infof("Restored %d databases of %d accounts from the backup taken at %s", restored, len(manifest.Accounts), manifest.CreatedAt.Format(time.RFC3339))
+	return nil
+}
+
+// Write one database from a backup, moving any existing file and its
+// journal aside first
+func restoreBackupFile(file string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	partial := file + ".restoring"
+	out, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(partial)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(partial)
+		return err
+	}
+
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if _, err := os.Stat(file + suffix); err == nil {
+			if err := os.Rename(file+suffix, file+".pre-restore"+suffix); err != nil {
+				os.Remove(partial)
+				return err
+			}
+		}
+	}
+	return os.Rename(partial, file)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// BlocklistResponse represents the response for the block list APIs
+type BlocklistResponse struct {
+	Success bool     `json:"success"`
+	Message string   `json:"message,omitempty"`
+	JIDs    []string `json:"jids"`
+	// Set when WhatsApp couldn't be reached and the cached list is returned
+	Cached    bool       `json:"cached"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// Replace the cached block list
+func (store *MessageStore) StoreBlocklist(jids []types.JID) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM blocklist"); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, jid := range jids {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO blocklist (jid, updated_at) VALUES (?, ?)", jid.ToNonAD().String(), now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Apply individual block list changes to the cache
+func (store *MessageStore) UpdateBlocklist(changes []events.BlocklistChange) error {
+	now := time.Now().UTC()
+	for _, change := range changes {
+		var err error
+		switch change.Action {
+		case events.BlocklistChangeActionBlock:
+			_, err = store.db.Exec("INSERT OR REPLACE INTO blocklist (jid, updated_at) VALUES (?, ?)", change.JID.ToNonAD().String(), now)
+		case events.BlocklistChangeActionUnblock:
+			_, err = store.db.Exec("DELETE FROM blocklist WHERE jid = ?", change.JID.ToNonAD().String())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get the cached block list and when it last changed
+func (store *MessageStore) GetBlocklist() ([]string, *time.Time, error) {
+	rows, err := store.db.Query("SELECT jid, updated_at FROM blocklist ORDER BY jid")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	jids := []string{}
+	var updatedAt *time.Time
+	for rows.Next() {
+		var jid string
+		var updated time.Time
+		if err := rows.Scan(&jid, &updated); err != nil {
+			return nil, nil, err
+		}
+		jids = append(jids, jid)
+		if updatedAt == nil || updated.After(*updatedAt) {
+			updatedAt = &updated
+		}
+	}
+	return jids, updatedAt, rows.Err()
+}
+
+// Fetch the block list from WhatsApp and cache it
+func (a *Account) refreshBlocklist() error {
+	blocklist, err := a.Client.GetBlocklist()
+	if err != nil {
+		return fmt.Errorf("failed to get block list: %v", err)
+	}
+	return a.MessageStore.StoreBlocklist(blocklist.JIDs)
+}
+
+// Keep the cached block list in sync with changes made on other devices
+func (a *Account) handleBlocklist(evt *events.Blocklist) {
+	var err error
+	if evt.Action == events.BlocklistActionModify {
+		// Only told that something changed, so fetch the whole list again
+		err = a.refreshBlocklist()
+	} else {
+		err = a.MessageStore.UpdateBlocklist(evt.Changes)
+	}
+	if err != nil {
+		a.Logger.Warnf("Failed to update block list: %v", err)
+	}
+}
+
+// Write the cached block list as a response
+func (a *Account) writeBlocklist(w http.ResponseWriter, message string, cached bool) {
+	jids, updatedAt, err := a.MessageStore.GetBlocklist()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load block list: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BlocklistResponse{Success: true, Message: message, JIDs: jids, Cached: cached, UpdatedAt: updatedAt})
+}
+
+// Handle GET /api/blocklist
+func (a *Account) HandleBlocklistEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cached := true
+	if a.Client.IsConnected() {
+		if err := a.refreshBlocklist(); err != nil {
+			a.Logger.Warnf("Serving cached block list: %v", err)
+		} else {
+			cached = false
+		}
+	}
+	a.writeBlocklist(w, "", cached)
+}
+
+// Handle POST /api/contacts/{jid}/block and /api/contacts/{jid}/unblock
+func (a *Account) HandleBlockEndpoint(w http.ResponseWriter, r *http.Request, action events.BlocklistChangeAction) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jid, err := parseRecipient(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jid = jid.ToNonAD()
+	if jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer {
+		http.Error(w, "Only individual contacts can be blocked", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !a.Client.IsConnected() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(BlocklistResponse{Message: errNotConnected.Error(), JIDs: []string{}})
+		return
+	}
+
+	// The server answers with the updated list
+	blocklist, err := a.Client.UpdateBlocklist(jid, action)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(BlocklistResponse{Message: fmt.Sprintf("Failed to %s %s: %v", action, jid, err), JIDs: []string{}})
+		return
+	}
+	if err := a.MessageStore.StoreBlocklist(blocklist.JIDs); err != nil {
+		a.Logger.Warnf("Failed to cache block list: %v", err)
+	}
+
+	message := fmt.Sprintf("Blocked %s", jid)
+	if action == events.BlocklistChangeActionUnblock {
+		message = fmt.Sprintf("Unblocked %s", jid)
+	}
+	a.writeBlocklist(w, message, false)
+}
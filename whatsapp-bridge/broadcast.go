@@ -0,0 +1,495 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Broadcast job and recipient states
+const (
+	BroadcastStatusRunning   = "running"
+	BroadcastStatusCompleted = "completed"
+	BroadcastStatusPending   = "pending"
+	BroadcastStatusSent      = "sent"
+	BroadcastStatusFailed    = "failed"
+)
+
+// Pacing between broadcast messages. Sending to many chats in a burst is a
+// common reason for numbers to get banned, so there is always some delay.
+const (
+	defaultBroadcastDelay  = 3 * time.Second
+	defaultBroadcastJitter = 2 * time.Second
+	minBroadcastDelay      = 500 * time.Millisecond
+	maxBroadcastRecipients = 1000
+)
+
+// BroadcastRequest represents the request body for the broadcast API
+type BroadcastRequest struct {
+	Recipients    []string `json:"recipients"`
+	Body          string   `json:"body"`
+	MentionedJIDs []string `json:"mentioned_jids,omitempty"`
+	LinkPreview   bool     `json:"link_preview,omitempty"`
+	DelayMillis   *int     `json:"delay_ms,omitempty"`
+	JitterMillis  *int     `json:"jitter_ms,omitempty"`
+}
+
+// BroadcastRecipient is the delivery result for one recipient of a broadcast
+type BroadcastRecipient struct {
+	JID       string     `json:"jid"`
+	Status    string     `json:"status"`
+	MessageID string     `json:"message_id,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+}
+
+// Broadcast is a message fanned out to many recipients
+type Broadcast struct {
+	ID           string               `json:"id"`
+	Body         string               `json:"body"`
+	Status       string               `json:"status"`
+	DelayMillis  int                  `json:"delay_ms"`
+	JitterMillis int                  `json:"jitter_ms"`
+	Sent         int                  `json:"sent"`
+	Failed       int                  `json:"failed"`
+	Pending      int                  `json:"pending"`
+	CreatedAt    time.Time            `json:"created_at"`
+	FinishedAt   *time.Time           `json:"finished_at,omitempty"`
+	Recipients   []BroadcastRecipient `json:"recipients,omitempty"`
+	message      SendTextRequest
+}
+
+// BroadcastResponse represents the response for the broadcast APIs
+type BroadcastResponse struct {
+	Success   bool       `json:"success"`
+	Message   string     `json:"message"`
+	Broadcast *Broadcast `json:"broadcast,omitempty"`
+}
+
+// Persist a new broadcast and its recipients
+func (store *MessageStore) CreateBroadcast(b *Broadcast, recipients []types.JID) error {
+	payload, err := json.Marshal(b.message)
+	if err != nil {
+		return err
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"INSERT INTO broadcasts (id, payload, status, delay_ms, jitter_ms, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		b.ID, string(payload), b.Status, b.DelayMillis, b.JitterMillis, b.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	for i, jid := range recipients {
+		_, err = tx.Exec(
+			"INSERT OR IGNORE INTO broadcast_recipients (broadcast_id, jid, position, status) VALUES (?, ?, ?, ?)",
+			b.ID, jid.String(), i, BroadcastStatusPending,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Get a broadcast, optionally with the per-recipient results
+func (store *MessageStore) GetBroadcast(id string, withRecipients bool) (*Broadcast, error) {
+	var b Broadcast
+	var payload string
+	var finishedAt sql.NullTime
+	err := store.db.QueryRow(
+		"SELECT id, payload, status, delay_ms, jitter_ms, created_at, finished_at FROM broadcasts WHERE id = ?", id,
+	).Scan(&b.ID, &payload, &b.Status, &b.DelayMillis, &b.JitterMillis, &b.CreatedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(payload), &b.message); err != nil {
+		return nil, err
+	}
+	b.Body = b.message.Body
+	if finishedAt.Valid {
+		b.FinishedAt = &finishedAt.Time
+	}
+
+	rows, err := store.db.Query(
+		"SELECT jid, status, message_id, error, sent_at FROM broadcast_recipients WHERE broadcast_id = ? ORDER BY position", id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r BroadcastRecipient
+		var messageID, sendErr sql.NullString
+		var sentAt sql.NullTime
+		if err := rows.Scan(&r.JID, &r.Status, &messageID, &sendErr, &sentAt); err != nil {
+			return nil, err
+		}
+		r.MessageID = messageID.String
+		r.Error = sendErr.String
+		if sentAt.Valid {
+			r.SentAt = &sentAt.Time
+		}
+		switch r.Status {
+		case BroadcastStatusSent:
+			b.Sent++
+		case BroadcastStatusFailed:
+			b.Failed++
+		default:
+			b.Pending++
+		}
+		if withRecipients {
+			b.Recipients = append(b.Recipients, r)
+		}
+	}
+	return &b, rows.Err()
+}
+
+// List broadcasts, newest first, without per-recipient results
+func (store *MessageStore) ListBroadcasts(status string) ([]*Broadcast, error) {
+	query := "SELECT id FROM broadcasts"
+	var args []interface{}
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY created_at DESC"
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	broadcasts := []*Broadcast{}
+	for _, id := range ids {
+		b, err := store.GetBroadcast(id, false)
+		if err != nil {
+			return nil, err
+		}
+		broadcasts = append(broadcasts, b)
+	}
+	return broadcasts, nil
+}
+
+// Recipients of a broadcast that have not been attempted yet, in order
+func (store *MessageStore) PendingBroadcastRecipients(id string) ([]string, error) {
+	rows, err := store.db.Query(
+		"SELECT jid FROM broadcast_recipients WHERE broadcast_id = ? AND status = ? ORDER BY position",
+		id, BroadcastStatusPending,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jids []string
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+		jids = append(jids, jid)
+	}
+	return jids, rows.Err()
+}
+
+// Record the outcome of sending a broadcast to one recipient
+func (store *MessageStore) UpdateBroadcastRecipient(id, jid, status, messageID, sendErr string, sentAt *time.Time) error {
+	var sent interface{}
+	if sentAt != nil {
+		sent = sentAt.UTC()
+	}
+	_, err := store.db.Exec(
+		"UPDATE broadcast_recipients SET status = ?, message_id = ?, error = ?, sent_at = ? WHERE broadcast_id = ? AND jid = ?",
+		status, messageID, sendErr, sent, id, jid,
+	)
+	return err
+}
+
+// Mark a broadcast as finished
+func (store *MessageStore) FinishBroadcast(id string, finishedAt time.Time) error {
+	_, err := store.db.Exec(
+		"UPDATE broadcasts SET status = ?, finished_at = ? WHERE id = ?",
+		BroadcastStatusCompleted, finishedAt.UTC(), id,
+	)
+	return err
+}
+
+// IDs of broadcasts that were still running, e.g. when the bridge stopped
+func (store *MessageStore) RunningBroadcasts() ([]string, error) {
+	rows, err := store.db.Query("SELECT id FROM broadcasts WHERE status = ? ORDER BY created_at", BroadcastStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Broadcaster runs an account's broadcast jobs in the background
+type Broadcaster struct {
+	account *Account
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Create a broadcaster for an account
+func NewBroadcaster(account *Account) *Broadcaster {
+	return &Broadcaster{account: account, stop: make(chan struct{})}
+}
+
+// Resume broadcasts interrupted by a restart
+func (b *Broadcaster) Resume() {
+	ids, err := b.account.MessageStore.RunningBroadcasts()
+	if err != nil {
+		b.account.Logger.Warnf("Failed to load running broadcasts: %v", err)
+		return
+	}
+	for _, id := range ids {
+		b.Start(id)
+	}
+}
+
+// Start working through the pending recipients of a broadcast
+func (b *Broadcaster) Start(id string) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.run(id)
+	}()
+}
+
+// Stop all running broadcasts; they resume on the next start
+func (b *Broadcaster) Stop() {
+	close(b.stop)
+	b.wg.Wait()
+}
+
+// Sleep for d, returning false if the broadcaster is stopped meanwhile
+func (b *Broadcaster) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-b.stop:
+		return false
+	}
+}
+
+// Send a broadcast to each pending recipient with a randomized pause in between
+func (b *Broadcaster) run(id string) {
+	a := b.account
+	job, err := a.MessageStore.GetBroadcast(id, false)
+	if err != nil {
+		a.Logger.Warnf("Failed to load broadcast %s: %v", id, err)
+		return
+	}
+	pending, err := a.MessageStore.PendingBroadcastRecipients(id)
+	if err != nil {
+		a.Logger.Warnf("Failed to load recipients of broadcast %s: %v", id, err)
+		return
+	}
+
+	delay := time.Duration(job.DelayMillis) * time.Millisecond
+	jitter := time.Duration(job.JitterMillis) * time.Millisecond
+	for i, jid := range pending {
+		if i > 0 {
+			pause := delay
+			if jitter > 0 {
+				pause += time.Duration(rand.Int63n(int64(jitter)))
+			}
+			if !b.wait(pause) {
+				return
+			}
+		}
+
+		// Hold the remaining recipients until the connection is back and
+		// the bridge isn't draining
+		for !a.Client.IsConnected() || draining.Load() {
+			if !b.wait(schedulerInterval) {
+				return
+			}
+		}
+
+		req := job.message
+		req.Recipient = jid
+		status, messageID, errText := BroadcastStatusSent, "", ""
+		var sentAt *time.Time
+		to, err := req.validate()
+		if err == nil {
+			resp, sendErr := a.sendMessage(to, a.textMessage(to, &req))
+			if sendErr == nil {
+				messageID, sentAt = resp.ID, &resp.Timestamp
+			}
+			err = sendErr
+		}
+		if err != nil {
+			status, errText = BroadcastStatusFailed, err.Error()
+			a.Logger.Warnf("Broadcast %s to %s failed: %v", id, jid, err)
+		}
+		if err := a.MessageStore.UpdateBroadcastRecipient(id, jid, status, messageID, errText, sentAt); err != nil {
+			a.Logger.Warnf("Failed to record broadcast result for %s: %v", jid, err)
+		}
+	}
+
+	if err := a.MessageStore.FinishBroadcast(id, time.Now()); err != nil {
+		a.Logger.Warnf("Failed to finish broadcast %s: %v", id, err)
+	}
+	if job, err = a.MessageStore.GetBroadcast(id, false); err == nil {
+		a.Logger.Infof("Broadcast %s finished: %d sent, %d failed", id, job.Sent, job.Failed)
+		a.Notifier.Notify(a.ID, WebhookEventBroadcastCompleted, map[string]interface{}{
+			"broadcast_id": id,
+			"sent":         job.Sent,
+			"failed":       job.Failed,
+		})
+	}
+}
+
+// Handle /api/messages/broadcast: POST starts a broadcast, GET lists them
+func (a *Account) HandleBroadcastEndpoint(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		broadcasts, err := a.MessageStore.ListBroadcasts(r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list broadcasts: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(broadcasts)
+
+	case http.MethodPost:
+		// Parse the request body
+		var req BroadcastRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		// Validate request
+		if len(req.Recipients) == 0 {
+			http.Error(w, "At least one recipient is required", http.StatusBadRequest)
+			return
+		}
+		if len(req.Recipients) > maxBroadcastRecipients {
+			http.Error(w, fmt.Sprintf("Broadcasts are limited to %d recipients", maxBroadcastRecipients), http.StatusBadRequest)
+			return
+		}
+		message := SendTextRequest{Body: req.Body, MentionedJIDs: req.MentionedJIDs, LinkPreview: req.LinkPreview}
+		var recipients []types.JID
+		seen := make(map[types.JID]bool)
+		for _, recipient := range req.Recipients {
+			message.Recipient = recipient
+			to, err := message.validate()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid recipient %s: %v", recipient, err), http.StatusBadRequest)
+				return
+			}
+			if !seen[to] {
+				seen[to] = true
+				recipients = append(recipients, to)
+			}
+		}
+		message.Recipient = ""
+
+		delay, jitter := defaultBroadcastDelay, defaultBroadcastJitter
+		if req.DelayMillis != nil {
+			delay = time.Duration(*req.DelayMillis) * time.Millisecond
+		}
+		if req.JitterMillis != nil {
+			jitter = time.Duration(*req.JitterMillis) * time.Millisecond
+		}
+		if delay < minBroadcastDelay {
+			http.Error(w, fmt.Sprintf("delay_ms must be at least %d", minBroadcastDelay.Milliseconds()), http.StatusBadRequest)
+			return
+		}
+		if jitter < 0 {
+			http.Error(w, "jitter_ms cannot be negative", http.StatusBadRequest)
+			return
+		}
+
+		job := &Broadcast{
+			ID:           newJobID(),
+			Body:         req.Body,
+			Status:       BroadcastStatusRunning,
+			DelayMillis:  int(delay.Milliseconds()),
+			JitterMillis: int(jitter.Milliseconds()),
+			Pending:      len(recipients),
+			CreatedAt:    time.Now(),
+			message:      message,
+		}
+		if err := a.MessageStore.CreateBroadcast(job, recipients); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create broadcast: %v", err), http.StatusInternalServerError)
+			return
+		}
+		a.Broadcaster.Start(job.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(BroadcastResponse{
+			Success:   true,
+			Message:   fmt.Sprintf("Broadcasting to %d recipients", len(recipients)),
+			Broadcast: job,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Handle GET /api/broadcasts/{id}
+func (a *Account) HandleBroadcastStatusEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	w.Header().Set("Content-Type", "application/json")
+	job, err := a.MessageStore.GetBroadcast(id, true)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(BroadcastResponse{Success: false, Message: fmt.Sprintf("Broadcast %s not found", id)})
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(BroadcastResponse{Success: false, Message: fmt.Sprintf("Failed to load broadcast: %v", err)})
+		return
+	}
+	json.NewEncoder(w).Encode(BroadcastResponse{Success: true, Message: "OK", Broadcast: job})
+}
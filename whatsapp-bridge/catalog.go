@@ -0,0 +1,503 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Sent to webhooks and event streams when a customer places an order from a
+// business catalog
+const WebhookEventOrder = "order"
+
+// Page sizes for catalog products
+const (
+	defaultCatalogPageSize = 20
+	maxCatalogPageSize     = 100
+)
+
+// How many catalog pages are searched for a product before giving up
+const maxCatalogPages = 10
+
+// Timeout for the catalog and order queries whatsmeow has no helpers for
+const businessQueryTimeout = 30 * time.Second
+
+// Product is an item in a business catalog. Prices are in the currency's
+// units; WhatsApp sends them multiplied by 1000.
+type Product struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Price       float64 `json:"price"`
+	Currency    string  `json:"currency,omitempty"`
+	RetailerID  string  `json:"retailer_id,omitempty"`
+	URL         string  `json:"url,omitempty"`
+	ImageURL    string  `json:"image_url,omitempty"`
+	IsHidden    bool    `json:"is_hidden,omitempty"`
+
+	priceAmount1000 int64
+}
+
+// CatalogResponse represents the response for the catalog API
+type CatalogResponse struct {
+	Success  bool       `json:"success"`
+	Message  string     `json:"message,omitempty"`
+	JID      string     `json:"jid"`
+	Products []*Product `json:"products"`
+	// Pass as after to read the next page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// SendProductRequest represents the request body for the product message API
+type SendProductRequest struct {
+	Recipient string `json:"recipient"`
+	// The business whose catalog has the product; defaults to our own
+	BusinessJID string `json:"business_jid"`
+	ProductID   string `json:"product_id"`
+	Body        string `json:"body"`
+	Footer      string `json:"footer"`
+	// ID of the message this one replies to
+	QuotedMessageID string `json:"quoted_message_id"`
+}
+
+// SendCatalogRequest represents the request body for the catalog message API
+type SendCatalogRequest struct {
+	Recipient string `json:"recipient"`
+	// The business whose catalog is shared; defaults to our own
+	BusinessJID string `json:"business_jid"`
+	// Text sent before the catalog link
+	Body string `json:"body"`
+}
+
+// OrderItem is a product in an order
+type OrderItem struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency,omitempty"`
+	Quantity int     `json:"quantity"`
+	ImageURL string  `json:"image_url,omitempty"`
+}
+
+// Order is a customer order, parsed from an order message and its details
+type Order struct {
+	ID        string      `json:"id"`
+	MessageID string      `json:"message_id,omitempty"`
+	ChatJID   string      `json:"chat_jid,omitempty"`
+	Sender    string      `json:"sender,omitempty"`
+	Title     string      `json:"title,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	Status    string      `json:"status,omitempty"`
+	SellerJID string      `json:"seller_jid,omitempty"`
+	ItemCount int         `json:"item_count"`
+	Total     float64     `json:"total"`
+	Currency  string      `json:"currency,omitempty"`
+	Items     []OrderItem `json:"items,omitempty"`
+	// Needed to fetch the order items later
+	Token     string    `json:"token,omitempty"`
+	Timestamp time.Time `json:"timestamp,omitempty"`
+}
+
+// Convert a WhatsApp amount, multiplied by 1000, to currency units
+func amountFrom1000(amount int64) float64 {
+	return float64(amount) / 1000
+}
+
+// Text content of a child node
+func childText(node waBinary.Node, tags ...string) string {
+	data, _ := node.GetChildByTag(tags...).Content.([]byte)
+	return string(data)
+}
+
+// Send an IQ query whatsmeow has no helper for and wait for the response
+func (a *Account) sendRawIQ(attrs waBinary.Attrs, content []waBinary.Node) (*waBinary.Node, error) {
+	if !a.Client.IsConnected() {
+		return nil, errNotConnected
+	}
+	internals := a.Client.DangerousInternals()
+	id := internals.GenerateRequestID()
+	attrs["id"] = id
+	attrs["to"] = types.ServerJID
+
+	ch := internals.WaitResponse(id)
+	if err := internals.SendNode(waBinary.Node{Tag: "iq", Attrs: attrs, Content: content}); err != nil {
+		internals.CancelResponse(id, ch)
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		if resp.AttrGetter().OptionalString("type") == "error" {
+			errNode := resp.GetChildByTag("error")
+			return nil, fmt.Errorf("server returned error %s: %s",
+				errNode.AttrGetter().OptionalString("code"), errNode.AttrGetter().OptionalString("text"))
+		}
+		return resp, nil
+	case <-time.After(businessQueryTimeout):
+		internals.CancelResponse(id, ch)
+		return nil, fmt.Errorf("timed out waiting for a response")
+	}
+}
+
+// Get a page of a business's product catalog
+func (a *Account) catalog(business types.JID, limit int, after string) ([]*Product, string, error) {
+	content := []waBinary.Node{
+		{Tag: "limit", Content: []byte(strconv.Itoa(limit))},
+		{Tag: "width", Content: []byte("100")},
+		{Tag: "height", Content: []byte("100")},
+	}
+	if after != "" {
+		content = append(content, waBinary.Node{Tag: "after", Content: []byte(after)})
+	}
+	resp, err := a.sendRawIQ(waBinary.Attrs{"type": "get", "xmlns": "w:biz:catalog"}, []waBinary.Node{{
+		Tag:     "product_catalog",
+		Attrs:   waBinary.Attrs{"jid": business, "allow_shop_source": "true"},
+		Content: content,
+	}})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get catalog: %w", err)
+	}
+
+	catalogNode := resp.GetChildByTag("product_catalog")
+	products := []*Product{}
+	for _, node := range catalogNode.GetChildrenByTag("product") {
+		price, _ := strconv.ParseInt(childText(node, "price"), 10, 64)
+		products = append(products, &Product{
+			ID:              childText(node, "id"),
+			Name:            childText(node, "name"),
+			Description:     childText(node, "description"),
+			Price:           amountFrom1000(price),
+			Currency:        childText(node, "currency"),
+			RetailerID:      childText(node, "retailer_id"),
+			URL:             childText(node, "url"),
+			ImageURL:        childText(node, "media", "image", "request_image_url"),
+			IsHidden:        node.AttrGetter().OptionalString("is_hidden") == "true",
+			priceAmount1000: price,
+		})
+	}
+	return products, childText(catalogNode, "paging", "after"), nil
+}
+
+// Find a product in a business's catalog
+func (a *Account) catalogProduct(business types.JID, productID string) (*Product, error) {
+	after := ""
+	for page := 0; page < maxCatalogPages; page++ {
+		products, next, err := a.catalog(business, maxCatalogPageSize, after)
+		if err != nil {
+			return nil, err
+		}
+		for _, product := range products {
+			if product.ID == productID || product.RetailerID == productID {
+				return product, nil
+			}
+		}
+		if next == "" || len(products) == 0 {
+			break
+		}
+		after = next
+	}
+	return nil, fmt.Errorf("product %s not found in the catalog of %s", productID, business)
+}
+
+// Get the items of an order. The token comes from the order message.
+func (a *Account) orderItems(orderID, token string) ([]OrderItem, float64, string, error) {
+	resp, err := a.sendRawIQ(waBinary.Attrs{"type": "get", "xmlns": "fb:thrift_iq", "smax_id": "5"}, []waBinary.Node{{
+		Tag:   "order",
+		Attrs: waBinary.Attrs{"op": "get", "id": orderID},
+		Content: []waBinary.Node{
+			{Tag: "image_dimensions", Content: []waBinary.Node{
+				{Tag: "width", Content: []byte("100")},
+				{Tag: "height", Content: []byte("100")},
+			}},
+			{Tag: "token", Content: []byte(token)},
+		},
+	}})
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to get order: %w", err)
+	}
+
+	orderNode := resp.GetChildByTag("order")
+	items := []OrderItem{}
+	for _, node := range orderNode.GetChildrenByTag("product") {
+		price, _ := strconv.ParseInt(childText(node, "price"), 10, 64)
+		quantity, _ := strconv.Atoi(childText(node, "quantity"))
+		items = append(items, OrderItem{
+			ID:       childText(node, "id"),
+			Name:     childText(node, "name"),
+			Price:    amountFrom1000(price),
+			Currency: childText(node, "currency"),
+			Quantity: quantity,
+			ImageURL: childText(node, "image", "url"),
+		})
+	}
+	total, _ := strconv.ParseInt(childText(orderNode, "price", "total"), 10, 64)
+	return items, amountFrom1000(total), childText(orderNode, "price", "currency"), nil
+}
+
+// Parse an order message into an order
+func orderFromMessage(evt *events.Message, msg *waProto.OrderMessage) *Order {
+	order := &Order{
+		ID:        msg.GetOrderID(),
+		MessageID: evt.Info.ID,
+		ChatJID:   evt.Info.Chat.String(),
+		Sender:    evt.Info.Sender.User,
+		Title:     msg.GetOrderTitle(),
+		Message:   msg.GetMessage(),
+		SellerJID: msg.GetSellerJID(),
+		ItemCount: int(msg.GetItemCount()),
+		Total:     amountFrom1000(msg.GetTotalAmount1000()),
+		Currency:  msg.GetTotalCurrencyCode(),
+		Token:     msg.GetToken(),
+		Timestamp: evt.Info.Timestamp,
+	}
+	if msg.Status != nil {
+		order.Status = strings.ToLower(msg.GetStatus().String())
+	}
+	return order
+}
+
+// Forward an incoming order with its items, when WhatsApp lets us fetch them
+func (a *Account) handleOrder(evt *events.Message, msg *waProto.OrderMessage) {
+	order := orderFromMessage(evt, msg)
+	if order.Token != "" && !evt.Info.IsFromMe {
+		items, total, currency, err := a.orderItems(order.ID, order.Token)
+		if err != nil {
+			a.Logger.Warnf("Failed to get items of order %s: %v", order.ID, err)
+		} else {
+			order.Items = items
+			if total > 0 {
+				order.Total, order.Currency = total, currency
+			}
+		}
+	}
+
+	a.Logger.Infof("%s placed order %s for %d items", order.Sender, order.ID, order.ItemCount)
+
+	data := map[string]interface{}{}
+	encoded, _ := json.Marshal(order)
+	json.Unmarshal(encoded, &data)
+	a.Notifier.Notify(a.ID, WebhookEventOrder, data)
+}
+
+// Resolve the business of a catalog request, defaulting to our own account
+func (a *Account) catalogBusiness(value string) (types.JID, error) {
+	if value == "" {
+		if a.Client.Store.ID == nil {
+			return types.JID{}, fmt.Errorf("not paired yet, name the business explicitly")
+		}
+		return a.Client.Store.ID.ToNonAD(), nil
+	}
+	jid, err := parseRecipient(value)
+	if err != nil {
+		return types.JID{}, err
+	}
+	if jid.Server != types.DefaultUserServer {
+		return types.JID{}, fmt.Errorf("%s is not a business account", value)
+	}
+	return jid, nil
+}
+
+// Handle GET /api/catalog/{jid}. "me" reads our own catalog.
+func (a *Account) HandleCatalogEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	value := r.PathValue("jid")
+	if value == "me" {
+		value = ""
+	}
+	business, err := a.catalogBusiness(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultCatalogPageSize
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		if limit > maxCatalogPageSize {
+			limit = maxCatalogPageSize
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := CatalogResponse{JID: business.String(), Products: []*Product{}}
+	products, next, err := a.catalog(business, limit, query.Get("after"))
+	if err != nil {
+		response.Message = err.Error()
+		if errors.Is(err, errNotConnected) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	response.Success = true
+	response.Products = products
+	response.NextCursor = next
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handle POST /api/messages/product, sending a product card from a catalog
+func (a *Account) HandleSendProductEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var req SendProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	to, err := parseRecipient(req.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ProductID == "" {
+		http.Error(w, "product_id is required", http.StatusBadRequest)
+		return
+	}
+	business, err := a.catalogBusiness(req.BusinessJID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The card is built from the catalog, so this can't be queued
+	product, err := a.catalogProduct(business, req.ProductID)
+	if err != nil {
+		writeSendResult(w, to, whatsmeow.SendResponse{}, err)
+		return
+	}
+
+	snapshot := &waProto.ProductMessage_ProductSnapshot{
+		ProductID:       proto.String(product.ID),
+		Title:           proto.String(product.Name),
+		Description:     proto.String(product.Description),
+		CurrencyCode:    proto.String(product.Currency),
+		PriceAmount1000: proto.Int64(product.priceAmount1000),
+		RetailerID:      proto.String(product.RetailerID),
+		URL:             proto.String(product.URL),
+	}
+	// Clients won't render the card without its image
+	if product.ImageURL != "" {
+		data, filename, err := fetchMedia(product.ImageURL)
+		if err == nil {
+			var att *Attachment
+			if att, err = newAttachment(data, filename, MediaKindImage); err == nil {
+				var image *waProto.Message
+				if image, err = a.buildMediaMessage(att, ""); err == nil {
+					snapshot.ProductImage = image.ImageMessage
+					snapshot.ProductImageCount = proto.Uint32(1)
+				}
+			}
+		}
+		if err != nil {
+			a.Logger.Warnf("Failed to attach image of product %s: %v", product.ID, err)
+		}
+	}
+
+	msg := &waProto.Message{ProductMessage: &waProto.ProductMessage{
+		Product:          snapshot,
+		BusinessOwnerJID: proto.String(business.String()),
+	}}
+	if req.Body != "" {
+		msg.ProductMessage.Body = proto.String(req.Body)
+	}
+	if req.Footer != "" {
+		msg.ProductMessage.Footer = proto.String(req.Footer)
+	}
+	if req.QuotedMessageID != "" {
+		applyContextInfo(msg, a.quoteContext(to, req.QuotedMessageID))
+	}
+
+	resp, err := a.sendMessage(to, msg)
+	writeSendResult(w, to, resp, err)
+}
+
+// Handle POST /api/messages/catalog, sharing a link to a business catalog
+func (a *Account) HandleSendCatalogEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var req SendCatalogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	to, err := parseRecipient(req.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	business, err := a.catalogBusiness(req.BusinessJID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The apps turn catalog links into a catalog card
+	body := "https://wa.me/c/" + business.User
+	if text := strings.TrimSpace(req.Body); text != "" {
+		body = text + "\n" + body
+	}
+	msg := a.textMessage(to, &SendTextRequest{Recipient: req.Recipient, Body: body, LinkPreview: true})
+	resp, err := a.sendOrQueue(to, msg)
+	writeSendResult(w, to, resp, err)
+}
+
+// Handle GET /api/orders/{id}?token=, fetching the items of an order
+func (a *Account) HandleOrderEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	orderID := r.PathValue("id")
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "token is required, it comes with the order event", http.StatusBadRequest)
+		return
+	}
+
+	items, total, currency, err := a.orderItems(orderID, token)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, errNotConnected) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Order{ID: orderID, ItemCount: len(items), Total: total, Currency: currency, Items: items})
+}
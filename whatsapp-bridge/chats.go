@@ -0,0 +1,465 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/appstate"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Page sizes for the chat list
+const (
+	defaultChatPageSize = 50
+	maxChatPageSize     = 500
+)
+
+// Longest last message preview in the chat list, in characters
+const chatSnippetLength = 100
+
+// ChatSummary is a chat as shown in the chat list
+type ChatSummary struct {
+	JID             string     `json:"jid"`
+	Name            string     `json:"name"`
+	IsGroup         bool       `json:"is_group"`
+	LastMessageTime *time.Time `json:"last_message_time,omitempty"`
+	UnreadCount     int        `json:"unread_count"`
+	Pinned          bool       `json:"pinned"`
+	Archived        bool       `json:"archived"`
+	Muted           bool       `json:"muted"`
+	MutedUntil      *time.Time `json:"muted_until,omitempty"`
+	// Seconds until new messages disappear; 0 when off
+	DisappearingTimer uint32       `json:"disappearing_timer,omitempty"`
+	LastMessage       *ChatSnippet `json:"last_message,omitempty"`
+}
+
+// ChatSnippet previews the last message of a chat
+type ChatSnippet struct {
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Text      string    `json:"text"`
+	MediaType string    `json:"media_type,omitempty"`
+	IsFromMe  bool      `json:"is_from_me"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ChatListResponse represents the response for the chat list API
+type ChatListResponse struct {
+	Chats []ChatSummary `json:"chats"`
+	// Pass as cursor to get the next page; empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ChatListOptions narrows and pages the chat list
+type ChatListOptions struct {
+	Query string
+	// Only archived (true) or only unarchived (false) chats
+	Archived *bool
+	Limit    int
+	Cursor   chatCursor
+}
+
+// Position in the chat list: the last chat of the previous page. Chats are
+// ordered by the stored last message time, then JID.
+type chatCursor struct {
+	LastMessageTime string
+	JID             string
+}
+
+// Encode a cursor as an opaque token
+func (c chatCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.LastMessageTime + "\n" + c.JID))
+}
+
+// Decode a cursor token
+func decodeChatCursor(token string) (chatCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return chatCursor{}, fmt.Errorf("invalid cursor")
+	}
+	lastMessageTime, jid, ok := strings.Cut(string(data), "\n")
+	if !ok || jid == "" {
+		return chatCursor{}, fmt.Errorf("invalid cursor")
+	}
+	return chatCursor{LastMessageTime: lastMessageTime, JID: jid}, nil
+}
+
+// Convert a mute end timestamp, which WhatsApp sends in seconds or milliseconds
+func muteEndTime(value int64) time.Time {
+	if value > 1e12 {
+		return time.UnixMilli(value)
+	}
+	return time.Unix(value, 0)
+}
+
+// Save the pinned, archived and muted state of a chat, creating it if needed
+func (store *MessageStore) setChatState(jid, column string, value interface{}) error {
+	_, err := store.db.Exec(
+		fmt.Sprintf("INSERT INTO chats (jid, %[1]s) VALUES (?, ?) ON CONFLICT (jid) DO UPDATE SET %[1]s = excluded.%[1]s", column),
+		jid, value,
+	)
+	return err
+}
+
+// Mark a chat pinned or unpinned
+func (store *MessageStore) SetChatPinned(jid string, pinned bool) error {
+	return store.setChatState(jid, "pinned", pinned)
+}
+
+// Mark a chat archived or unarchived
+func (store *MessageStore) SetChatArchived(jid string, archived bool) error {
+	return store.setChatState(jid, "archived", archived)
+}
+
+// Mute a chat until the given time (zero mutes it forever) or unmute it
+func (store *MessageStore) SetChatMuted(jid string, muted bool, until time.Time) error {
+	var mutedUntil interface{}
+	if muted && !until.IsZero() {
+		mutedUntil = until.UTC()
+	}
+	_, err := store.db.Exec(
+		`INSERT INTO chats (jid, muted, muted_until) VALUES (?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET muted = excluded.muted, muted_until = excluded.muted_until`,
+		jid, muted, mutedUntil,
+	)
+	return err
+}
+
+// List chats by last activity with unread counts and a preview of the last message
+func (store *MessageStore) ListChats(opts ChatListOptions) ([]ChatSummary, string, error) {
+	var query strings.Builder
+	query.WriteString(`SELECT c.jid, COALESCE(c.name, ''), c.last_message_time, COALESCE(CAST(c.last_message_time AS TEXT), ''),
+		COALESCE(c.pinned, 0), COALESCE(c.archived, 0), COALESCE(c.muted, 0), c.muted_until, COALESCE(c.disappearing_timer, 0),
+		(SELECT COUNT(*) FROM messages u WHERE u.chat_jid = c.jid AND u.is_read = 0 AND u.is_from_me = 0),
+		m.id, m.sender, COALESCE(NULLIF(m.content, ''), m.caption, ''), COALESCE(m.media_type, ''), m.is_from_me, m.timestamp
+		FROM chats c
+		LEFT JOIN messages m ON m.chat_jid = c.jid AND m.id = (
+			SELECT id FROM messages WHERE chat_jid = c.jid ORDER BY timestamp DESC LIMIT 1
+		)
+		WHERE 1 = 1`)
+	var args []interface{}
+
+	if opts.Query != "" {
+		query.WriteString(" AND (c.name LIKE ? OR c.jid LIKE ?)")
+		pattern := "%" + opts.Query + "%"
+		args = append(args, pattern, pattern)
+	}
+	if opts.Archived != nil {
+		query.WriteString(" AND COALESCE(c.archived, 0) = ?")
+		args = append(args, *opts.Archived)
+	}
+	if opts.Cursor.JID != "" {
+		query.WriteString(` AND (COALESCE(CAST(c.last_message_time AS TEXT), '') < ?
+			OR (COALESCE(CAST(c.last_message_time AS TEXT), '') = ? AND c.jid > ?))`)
+		args = append(args, opts.Cursor.LastMessageTime, opts.Cursor.LastMessageTime, opts.Cursor.JID)
+	}
+
+	// Fetch one extra row to know whether there is another page
+	query.WriteString(" ORDER BY COALESCE(CAST(c.last_message_time AS TEXT), '') DESC, c.jid LIMIT ?")
+	args = append(args, opts.Limit+1)
+
+	rows, err := store.db.Query(query.String(), args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	chats := []ChatSummary{}
+	var cursors []chatCursor
+	now := time.Now()
+	for rows.Next() {
+		var chat ChatSummary
+		var lastMessageTime string
+		var lastActivity, mutedUntil sql.NullTime
+		var msgID, msgSender, msgText, msgMediaType sql.NullString
+		var msgFromMe sql.NullBool
+		var msgTime sql.NullTime
+		err := rows.Scan(&chat.JID, &chat.Name, &lastActivity, &lastMessageTime, &chat.Pinned, &chat.Archived, &chat.Muted, &mutedUntil,
+			&chat.DisappearingTimer, &chat.UnreadCount, &msgID, &msgSender, &msgText, &msgMediaType, &msgFromMe, &msgTime)
+		if err != nil {
+			return nil, "", err
+		}
+
+		chat.IsGroup = strings.HasSuffix(chat.JID, "@g.us")
+		if lastActivity.Valid {
+			chat.LastMessageTime = &lastActivity.Time
+		}
+		if mutedUntil.Valid {
+			if mutedUntil.Time.After(now) {
+				chat.MutedUntil = &mutedUntil.Time
+			} else {
+				chat.Muted = false
+			}
+		}
+		if msgID.Valid {
+			text := []rune(msgText.String)
+			if len(text) > chatSnippetLength {
+				text = append(text[:chatSnippetLength], '…')
+			}
+			chat.LastMessage = &ChatSnippet{
+				ID:        msgID.String,
+				Sender:    msgSender.String,
+				Text:      string(text),
+				MediaType: msgMediaType.String,
+				IsFromMe:  msgFromMe.Bool,
+				Timestamp: msgTime.Time,
+			}
+		}
+		chats = append(chats, chat)
+		cursors = append(cursors, chatCursor{LastMessageTime: lastMessageTime, JID: chat.JID})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(chats) > opts.Limit {
+		chats = chats[:opts.Limit]
+		next = cursors[opts.Limit-1].encode()
+	}
+	return chats, next, nil
+}
+
+// Keep chat flags in sync with changes made on other devices
+func (a *Account) handleChatStateEvent(evt interface{}) {
+	var err error
+	switch v := evt.(type) {
+	case *events.Pin:
+		err = a.MessageStore.SetChatPinned(v.JID.String(), v.Action.GetPinned())
+	case *events.Archive:
+		err = a.MessageStore.SetChatArchived(v.JID.String(), v.Action.GetArchived())
+	case *events.Mute:
+		var until time.Time
+		if end := v.Action.GetMuteEndTimestamp(); end > 0 {
+			until = muteEndTime(end)
+		}
+		err = a.MessageStore.SetChatMuted(v.JID.String(), v.Action.GetMuted(), until)
+	}
+	if err != nil {
+		a.Logger.Warnf("Failed to update chat state: %v", err)
+	}
+}
+
+// Handle GET /api/chats
+func (a *Account) HandleChatsEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := ChatListOptions{Query: strings.TrimSpace(query.Get("q")), Limit: defaultChatPageSize}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		opts.Limit = limit
+		if opts.Limit > maxChatPageSize {
+			opts.Limit = maxChatPageSize
+		}
+	}
+	if value := query.Get("archived"); value != "" {
+		archived, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "archived must be true or false", http.StatusBadRequest)
+			return
+		}
+		opts.Archived = &archived
+	}
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeChatCursor(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.Cursor = cursor
+	}
+
+	chats, next, err := a.MessageStore.ListChats(opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list chats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ChatListResponse{Chats: chats, NextCursor: next})
+}
+
+// WhatsApp allows this many pinned chats
+const maxPinnedChats = 3
+
+// Actions of the chat state endpoints
+const (
+	ChatActionArchive = "archive"
+	ChatActionPin     = "pin"
+	ChatActionMute    = "mute"
+)
+
+// ChatStateRequest represents the request body for the archive, pin and
+// mute APIs. The flag of the endpoint defaults to true when left out.
+type ChatStateRequest struct {
+	Archived *bool `json:"archived"`
+	Pinned   *bool `json:"pinned"`
+	Muted    *bool `json:"muted"`
+	// Seconds to mute for; 0 mutes until unmuted
+	Duration int64 `json:"duration"`
+}
+
+// ChatStateResponse represents the response for the archive, pin and mute APIs
+type ChatStateResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	JID     string `json:"jid,omitempty"`
+}
+
+// Count pinned chats other than the given one
+func (store *MessageStore) countOtherPinnedChats(jid string) (int, error) {
+	var count int
+	err := store.db.QueryRow("SELECT COUNT(*) FROM chats WHERE pinned = 1 AND jid != ?", jid).Scan(&count)
+	return count, err
+}
+
+// Key of the last stored message in a chat, which WhatsApp wants when
+// archiving so other devices agree on what was archived
+func (store *MessageStore) lastMessageKey(chat types.JID) (*waProto.MessageKey, time.Time, error) {
+	var id, sender string
+	var fromMe bool
+	var timestamp time.Time
+	err := store.db.QueryRow(
+		"SELECT id, COALESCE(sender_jid, ''), is_from_me, timestamp FROM messages WHERE chat_jid = ? ORDER BY timestamp DESC LIMIT 1",
+		chat.String(),
+	).Scan(&id, &sender, &fromMe, &timestamp)
+	if err == sql.ErrNoRows {
+		return nil, time.Time{}, nil
+	} else if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	key := &waProto.MessageKey{
+		RemoteJID: proto.String(chat.String()),
+		FromMe:    proto.Bool(fromMe),
+		ID:        proto.String(id),
+	}
+	// Messages stored before full sender JIDs were kept go without a participant
+	if chat.Server == types.GroupServer && !fromMe && sender != "" {
+		key.Participant = proto.String(sender)
+	}
+	return key, timestamp, nil
+}
+
+// Handle POST /api/chats/{jid}/archive, /pin and /mute. The change is sent as
+// an app state patch so the phone and other linked devices pick it up.
+func (a *Account) HandleChatStateEndpoint(w http.ResponseWriter, r *http.Request, action string) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	chat, err := parseRecipient(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse the request body; an empty body applies the action
+	var req ChatStateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if req.Duration < 0 {
+		http.Error(w, "duration must not be negative", http.StatusBadRequest)
+		return
+	}
+	flag := map[string]*bool{ChatActionArchive: req.Archived, ChatActionPin: req.Pinned, ChatActionMute: req.Muted}[action]
+	enable := flag == nil || *flag
+
+	w.Header().Set("Content-Type", "application/json")
+	response := ChatStateResponse{JID: chat.String()}
+	if !a.Client.IsConnected() {
+		response.Message = errNotConnected.Error()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	var patch appstate.PatchInfo
+	var until time.Time
+	switch action {
+	case ChatActionArchive:
+		key, timestamp, err := a.MessageStore.lastMessageKey(chat)
+		if err != nil {
+			a.Logger.Warnf("Failed to get last message of %s: %v", chat, err)
+		}
+		patch = appstate.BuildArchive(chat, enable, timestamp, key)
+	case ChatActionPin:
+		if enable {
+			if pinned, err := a.MessageStore.countOtherPinnedChats(chat.String()); err == nil && pinned >= maxPinnedChats {
+				response.Message = fmt.Sprintf("At most %d chats can be pinned", maxPinnedChats)
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(response)
+				return
+			}
+		}
+		patch = appstate.BuildPin(chat, enable)
+	case ChatActionMute:
+		duration := time.Duration(req.Duration) * time.Second
+		if enable && duration > 0 {
+			until = time.Now().Add(duration)
+		}
+		patch = appstate.BuildMute(chat, enable, duration)
+	}
+	if err := a.Client.SendAppState(patch); err != nil {
+		response.Message = fmt.Sprintf("Failed to %s chat: %v", action, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// Our own patches aren't echoed back, so update the stored flags ourselves
+	switch action {
+	case ChatActionArchive:
+		err = a.MessageStore.SetChatArchived(chat.String(), enable)
+		if err == nil && enable {
+			// Archiving unpins the chat
+			err = a.MessageStore.SetChatPinned(chat.String(), false)
+		}
+	case ChatActionPin:
+		err = a.MessageStore.SetChatPinned(chat.String(), enable)
+	case ChatActionMute:
+		err = a.MessageStore.SetChatMuted(chat.String(), enable, until)
+	}
+	if err != nil {
+		a.Logger.Warnf("Failed to update chat state: %v", err)
+	}
+
+	verbs := map[string][2]string{
+		ChatActionArchive: {"Archived", "Unarchived"},
+		ChatActionPin:     {"Pinned", "Unpinned"},
+		ChatActionMute:    {"Muted", "Unmuted"},
+	}
+	verb := verbs[action][1]
+	if enable {
+		verb = verbs[action][0]
+	}
+	response.Success = true
+	response.Message = fmt.Sprintf("%s %s", verb, chat)
+	if !until.IsZero() {
+		response.Message += fmt.Sprintf(" until %s", until.UTC().Format(time.RFC3339))
+	}
+	json.NewEncoder(w).Encode(response)
+}
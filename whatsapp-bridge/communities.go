@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// CommunityGroup is a group linked to a community
+type CommunityGroup struct {
+	JID  string `json:"jid"`
+	Name string `json:"name"`
+	// The announcement group every community has, where only admins post
+	IsAnnouncement bool `json:"is_announcement"`
+}
+
+// CommunityGroupsResponse represents the response for the community subgroup APIs
+type CommunityGroupsResponse struct {
+	Success bool             `json:"success"`
+	Message string           `json:"message,omitempty"`
+	JID     string           `json:"jid"`
+	Groups  []CommunityGroup `json:"groups"`
+}
+
+// LinkGroupRequest represents the request body for linking a group to a community
+type LinkGroupRequest struct {
+	GroupJID string `json:"group_jid"`
+}
+
+// AnnouncementRequest represents the request body for posting to a community
+type AnnouncementRequest struct {
+	Body string `json:"body"`
+	// JIDs or phone numbers to mention, in addition to @phone tokens in the body
+	MentionedJIDs []string `json:"mentioned_jids,omitempty"`
+	// Fetch the first link in the body and attach a preview card
+	LinkPreview bool `json:"link_preview,omitempty"`
+}
+
+// Parse a community JID from a request path
+func parseCommunityJID(value string) (types.JID, error) {
+	jid, err := parseGroupJID(value)
+	if err != nil {
+		return types.JID{}, fmt.Errorf("invalid community JID: %s", value)
+	}
+	return jid, nil
+}
+
+// Get the groups linked to a community
+func (a *Account) communityGroups(community types.JID) ([]CommunityGroup, error) {
+	targets, err := a.Client.GetSubGroups(community)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get community groups: %w", err)
+	}
+	groups := make([]CommunityGroup, len(targets))
+	for i, target := range targets {
+		groups[i] = CommunityGroup{JID: target.JID.String(), Name: target.Name, IsAnnouncement: target.IsDefaultSubGroup}
+	}
+	return groups, nil
+}
+
+// Handle GET /api/communities
+func (a *Account) HandleCommunitiesEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	groups, cached, err := a.joinedGroups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	communities := []*GroupMetadata{}
+	for _, group := range groups {
+		if group.IsCommunity {
+			communities = append(communities, group)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GroupsResponse{Groups: communities, Total: len(communities), Cached: cached})
+}
+
+// Handle GET and POST /api/communities/{jid}/groups. GET lists the linked
+// groups; POST links an existing group we administer.
+func (a *Account) HandleCommunityGroupsEndpoint(w http.ResponseWriter, r *http.Request) {
+	community, err := parseCommunityJID(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var group types.JID
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req LinkGroupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if group, err = parseGroupJID(req.GroupJID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	a.writeCommunityGroups(w, community, group, false)
+}
+
+// Handle DELETE /api/communities/{jid}/groups/{group}
+func (a *Account) HandleCommunityGroupEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow DELETE requests
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	community, err := parseCommunityJID(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group, err := parseGroupJID(r.PathValue("group"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.writeCommunityGroups(w, community, group, true)
+}
+
+// Link or unlink a group when one is given, then write the community's groups
+func (a *Account) writeCommunityGroups(w http.ResponseWriter, community, group types.JID, unlink bool) {
+	w.Header().Set("Content-Type", "application/json")
+	response := CommunityGroupsResponse{JID: community.String(), Groups: []CommunityGroup{}}
+	if !a.Client.IsConnected() {
+		response.Message = errNotConnected.Error()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	if !group.IsEmpty() {
+		var err error
+		if unlink {
+			err = a.Client.UnlinkGroup(community, group)
+			response.Message = fmt.Sprintf("Unlinked %s", group)
+		} else {
+			err = a.Client.LinkGroup(community, group)
+			response.Message = fmt.Sprintf("Linked %s", group)
+		}
+		if err != nil {
+			response.Message = fmt.Sprintf("Failed to update community groups: %v", err)
+			w.WriteHeader(groupErrorStatus(err))
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		go a.refreshCachedGroup(group)
+	}
+
+	groups, err := a.communityGroups(community)
+	if err != nil {
+		response.Message = err.Error()
+		w.WriteHeader(groupErrorStatus(err))
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	response.Success = true
+	response.Groups = groups
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handle POST /api/communities/{jid}/announcements, posting a text message to
+// the community's announcement group
+func (a *Account) HandleCommunityAnnouncementEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	community, err := parseCommunityJID(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse the request body
+	var req AnnouncementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		http.Error(w, "Body is required", http.StatusBadRequest)
+		return
+	}
+	if _, _, err := resolveMentions(req.Body, req.MentionedJIDs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !a.Client.IsConnected() {
+		writeSendResult(w, community, whatsmeow.SendResponse{}, errNotConnected)
+		return
+	}
+
+	groups, err := a.communityGroups(community)
+	if err != nil {
+		writeSendResult(w, community, whatsmeow.SendResponse{}, err)
+		return
+	}
+	var to types.JID
+	for _, group := range groups {
+		if group.IsAnnouncement {
+			to, _ = types.ParseJID(group.JID)
+			break
+		}
+	}
+	if to.IsEmpty() {
+		writeSendResult(w, community, whatsmeow.SendResponse{}, fmt.Errorf("%s has no announcement group", community))
+		return
+	}
+
+	msg := a.textMessage(to, &SendTextRequest{
+		Recipient:     to.String(),
+		Body:          req.Body,
+		MentionedJIDs: req.MentionedJIDs,
+		LinkPreview:   req.LinkPreview,
+	})
+	resp, err := a.sendMessage(to, msg)
+	writeSendResult(w, to, resp, err)
+}
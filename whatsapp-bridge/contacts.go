@@ -0,0 +1,469 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Page sizes for the contact directory
+const (
+	defaultContactPageSize = 100
+	maxContactPageSize     = 1000
+)
+
+// Numbers per number check request, and per usync query sent to WhatsApp
+const (
+	maxCheckNumbers   = 1000
+	checkNumbersBatch = 50
+)
+
+// Contact is an entry of the contact directory
+type Contact struct {
+	JID   string `json:"jid"`
+	Phone string `json:"phone,omitempty"`
+	// Best name to show: address book name, then push name, then business name
+	Name      string `json:"name"`
+	FirstName string `json:"first_name,omitempty"`
+	FullName  string `json:"full_name,omitempty"`
+	// Display name the contact set for themselves
+	PushName string `json:"push_name,omitempty"`
+	// Verified business name, set only for verified business accounts
+	BusinessName string `json:"business_name,omitempty"`
+	Verified     bool   `json:"verified"`
+	// About text, fetched when a single contact is refreshed
+	Status    string    `json:"status,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ContactListResponse represents the response for the contact directory API
+type ContactListResponse struct {
+	Contacts []Contact `json:"contacts"`
+	Total    int       `json:"total"`
+}
+
+// ContactMatch is a contact found by a fuzzy search
+type ContactMatch struct {
+	Contact
+	// 1 for an exact match, down to minRecipientScore
+	Score float64 `json:"score"`
+	// The name or number that matched
+	MatchedOn string `json:"matched_on"`
+}
+
+// ContactSearchResponse represents the response for the fuzzy contact search API
+type ContactSearchResponse struct {
+	Query string `json:"query"`
+	// Best matches first
+	Matches []ContactMatch `json:"matches"`
+}
+
+// CheckNumbersRequest represents the request body for the number check API
+type CheckNumbersRequest struct {
+	PhoneNumbers []string `json:"phone_numbers"`
+}
+
+// NumberCheckResult tells whether a phone number is on WhatsApp
+type NumberCheckResult struct {
+	// The number as given in the request
+	PhoneNumber string `json:"phone_number"`
+	// Canonical JID to send to; empty when the number is not registered
+	JID          string `json:"jid,omitempty"`
+	Registered   bool   `json:"registered"`
+	BusinessName string `json:"business_name,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// CheckNumbersResponse represents the response for the number check API
+type CheckNumbersResponse struct {
+	Results    []NumberCheckResult `json:"results"`
+	Registered int                 `json:"registered"`
+}
+
+// Pick the name to show for a contact
+func (c *Contact) displayName() string {
+	for _, name := range []string{c.FullName, c.FirstName, c.PushName, c.BusinessName} {
+		if name != "" {
+			return name
+		}
+	}
+	return c.Phone
+}
+
+// Build a directory entry from whatsmeow's contact store
+func contactFromInfo(jid types.JID, info types.ContactInfo) Contact {
+	contact := Contact{
+		JID:          jid.ToNonAD().String(),
+		FirstName:    info.FirstName,
+		FullName:     info.FullName,
+		PushName:     info.PushName,
+		BusinessName: info.BusinessName,
+		Verified:     info.BusinessName != "",
+	}
+	if jid.Server == types.DefaultUserServer {
+		contact.Phone = jid.User
+	}
+	return contact
+}
+
+// Save a contact's names, keeping the about text fetched earlier
+func (store *MessageStore) StoreContact(contact Contact) error {
+	_, err := store.db.Exec(
+		`INSERT INTO contacts (jid, phone, first_name, full_name, push_name, business_name, verified, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET phone = excluded.phone, first_name = excluded.first_name,
+			full_name = excluded.full_name, push_name = excluded.push_name, business_name = excluded.business_name,
+			verified = excluded.verified, updated_at = excluded.updated_at`,
+		contact.JID, contact.Phone, contact.FirstName, contact.FullName, contact.PushName, contact.BusinessName,
+		contact.Verified, time.Now().UTC(),
+	)
+	return err
+}
+
+// Save what the server reports about a contact's business verification and about text
+func (store *MessageStore) StoreContactUserInfo(jid string, info types.UserInfo) error {
+	var businessName interface{}
+	if info.VerifiedName != nil {
+		businessName = info.VerifiedName.Details.GetVerifiedName()
+	}
+	_, err := store.db.Exec(
+		`INSERT INTO contacts (jid, business_name, verified, status, updated_at) VALUES (?, COALESCE(?, ''), ?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET business_name = COALESCE(?, contacts.business_name), verified = excluded.verified,
+			status = excluded.status, updated_at = excluded.updated_at`,
+		jid, businessName, info.VerifiedName != nil, info.Status, time.Now().UTC(), businessName,
+	)
+	return err
+}
+
+// Columns read into a Contact by scanContact
+const contactColumns = `jid, COALESCE(phone, ''), COALESCE(first_name, ''), COALESCE(full_name, ''), COALESCE(push_name, ''),
+	COALESCE(business_name, ''), COALESCE(verified, 0), COALESCE(status, ''), updated_at`
+
+// Scan a row selected with contactColumns
+func scanContact(row interface{ Scan(...interface{}) error }) (Contact, error) {
+	var contact Contact
+	var updatedAt sql.NullTime
+	err := row.Scan(&contact.JID, &contact.Phone, &contact.FirstName, &contact.FullName, &contact.PushName,
+		&contact.BusinessName, &contact.Verified, &contact.Status, &updatedAt)
+	contact.Name = contact.displayName()
+	contact.UpdatedAt = updatedAt.Time
+	return contact, err
+}
+
+// Get a contact by JID
+func (store *MessageStore) GetContact(jid string) (*Contact, error) {
+	contact, err := scanContact(store.db.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE jid = ?", jid))
+	if err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// List contacts by name, optionally matching any name or the phone number
+func (store *MessageStore) ListContacts(search string, limit, offset int) ([]Contact, int, error) {
+	where := ""
+	var args []interface{}
+	if search != "" {
+		where = ` WHERE full_name LIKE ? OR first_name LIKE ? OR push_name LIKE ? OR business_name LIKE ? OR phone LIKE ?`
+		pattern := "%" + search + "%"
+		args = append(args, pattern, pattern, pattern, pattern)
+		// Phone numbers are stored without formatting
+		phone := strings.NewReplacer("+", "", " ", "", "-", "", "(", "", ")", "").Replace(search)
+		if phone == "" {
+			phone = search
+		}
+		args = append(args, "%"+phone+"%")
+	}
+
+	var total int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM contacts"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := store.db.Query(
+		"SELECT "+contactColumns+` FROM contacts`+where+`
+		ORDER BY LOWER(COALESCE(NULLIF(full_name, ''), NULLIF(first_name, ''), NULLIF(push_name, ''), NULLIF(business_name, ''), phone)), jid
+		LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	contacts := []Contact{}
+	for rows.Next() {
+		contact, err := scanContact(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, total, rows.Err()
+}
+
+// Copy every contact whatsmeow knows about into the directory
+func (a *Account) syncContacts() {
+	contacts, err := a.Client.Store.Contacts.GetAllContacts()
+	if err != nil {
+		a.Logger.Warnf("Failed to read contacts: %v", err)
+		return
+	}
+	for jid, info := range contacts {
+		if err := a.MessageStore.StoreContact(contactFromInfo(jid, info)); err != nil {
+			a.Logger.Warnf("Failed to store contact %s: %v", jid, err)
+			return
+		}
+	}
+	a.Logger.Infof("Synced %d contacts", len(contacts))
+}
+
+// Refresh a single contact after its names changed. whatsmeow updates its own
+// store before emitting the event, so read it back from there.
+func (a *Account) syncContact(jid types.JID) {
+	info, err := a.Client.Store.Contacts.GetContact(jid)
+	if err != nil {
+		a.Logger.Warnf("Failed to read contact %s: %v", jid, err)
+		return
+	}
+	if err := a.MessageStore.StoreContact(contactFromInfo(jid, info)); err != nil {
+		a.Logger.Warnf("Failed to store contact %s: %v", jid, err)
+	}
+}
+
+// Keep the contact directory in sync with the address book and push names
+func (a *Account) handleContactEvent(evt interface{}) {
+	switch v := evt.(type) {
+	case *events.Contact:
+		a.syncContact(v.JID)
+	case *events.PushName:
+		a.syncContact(v.JID)
+	case *events.BusinessName:
+		a.syncContact(v.JID)
+	case *events.AppStateSyncComplete:
+		go a.syncContacts()
+	}
+}
+
+// Handle GET /api/contacts
+func (a *Account) HandleContactsEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	limit, offset := defaultContactPageSize, 0
+	for param, target := range map[string]*int{"limit": &limit, "offset": &offset} {
+		if value := query.Get(param); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("%s must be a non-negative number", param), http.StatusBadRequest)
+				return
+			}
+			*target = n
+		}
+	}
+	if limit == 0 {
+		limit = defaultContactPageSize
+	} else if limit > maxContactPageSize {
+		limit = maxContactPageSize
+	}
+
+	contacts, total, err := a.MessageStore.ListContacts(strings.TrimSpace(query.Get("q")), limit, offset)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list contacts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ContactListResponse{Contacts: contacts, Total: total})
+}
+
+// Handle GET /api/contacts/search. Unlike q on the contact directory, typos
+// and partial names still match, and the best matches come first.
+func (a *Account) HandleSearchContactsEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	search := strings.TrimSpace(query.Get("q"))
+	if search == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	limit := 10
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxContactPageSize)
+	}
+
+	// A limit of -1 lifts SQLite's limit; the whole directory is ranked
+	contacts, _, err := a.MessageStore.ListContacts("", -1, 0)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list contacts: %v", err), http.StatusInternalServerError)
+		return
+	}
+	matches := []ContactMatch{}
+	for _, contact := range contacts {
+		if score, matchedOn := scoreContact(search, contact); score >= minRecipientScore {
+			matches = append(matches, ContactMatch{Contact: contact, Score: score, MatchedOn: matchedOn})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ContactSearchResponse{Query: search, Matches: matches})
+}
+
+// Handle GET /api/contacts/{jid}. With refresh=true the verified business
+// name and about text are fetched from WhatsApp first.
+func (a *Account) HandleContactEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jid, err := parseRecipient(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jid = jid.ToNonAD()
+
+	if refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh")); refresh {
+		if !a.Client.IsConnected() {
+			http.Error(w, errNotConnected.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		a.syncContact(jid)
+		users, err := a.Client.GetUserInfo([]types.JID{jid})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to fetch contact info: %v", err), http.StatusBadGateway)
+			return
+		}
+		if info, ok := users[jid]; ok {
+			if err := a.MessageStore.StoreContactUserInfo(jid.String(), info); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to store contact: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	contact, err := a.MessageStore.GetContact(jid.String())
+	if err == sql.ErrNoRows {
+		http.Error(w, "Contact not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load contact: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(contact)
+}
+
+// Check which phone numbers are registered on WhatsApp. Numbers that can't be
+// parsed get an error of their own instead of failing the whole batch.
+func (a *Account) checkNumbers(numbers []string) ([]NumberCheckResult, error) {
+	results := make([]NumberCheckResult, len(numbers))
+	// Positions of each query in results; the same number may be given twice
+	positions := make(map[string][]int)
+	var queries []string
+	for i, number := range numbers {
+		results[i].PhoneNumber = number
+		jid, err := parseRecipient(number)
+		if err != nil || strings.Contains(number, "@") {
+			results[i].Error = fmt.Sprintf("invalid phone number: %s", number)
+			continue
+		}
+		query := "+" + jid.User
+		if _, seen := positions[query]; !seen {
+			queries = append(queries, query)
+		}
+		positions[query] = append(positions[query], i)
+	}
+
+	for start := 0; start < len(queries); start += checkNumbersBatch {
+		end := min(start+checkNumbersBatch, len(queries))
+		responses, err := a.Client.IsOnWhatsApp(queries[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, response := range responses {
+			for _, i := range positions[response.Query] {
+				results[i].Registered = response.IsIn
+				if response.IsIn {
+					results[i].JID = response.JID.String()
+				}
+				if response.VerifiedName != nil {
+					results[i].BusinessName = response.VerifiedName.Details.GetVerifiedName()
+				}
+			}
+		}
+	}
+	return results, nil
+}
+
+// Handle POST /api/contacts/check
+func (a *Account) HandleCheckNumbersEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var req CheckNumbersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if len(req.PhoneNumbers) == 0 {
+		http.Error(w, "phone_numbers is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.PhoneNumbers) > maxCheckNumbers {
+		http.Error(w, fmt.Sprintf("At most %d phone numbers can be checked at once", maxCheckNumbers), http.StatusBadRequest)
+		return
+	}
+	if !a.Client.IsConnected() {
+		http.Error(w, errNotConnected.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	results, err := a.checkNumbers(req.PhoneNumbers)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to check numbers: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	response := CheckNumbersResponse{Results: results}
+	for _, result := range results {
+		if result.Registered {
+			response.Registered++
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Response headers browsers let cross-origin scripts read
+const corsExposedHeaders = "Mcp-Session-Id, Retry-After, X-RateLimit-Limit, X-RateLimit-Remaining, X-Request-ID"
+
+// How long browsers may cache a preflight, in seconds
+const corsMaxAge = 600
+
+// CORSPolicy lets web pages on other origins call the REST API, the event
+// streams and /mcp. A nil policy allows no other origins, which is what
+// browsers assume anyway.
+type CORSPolicy struct {
+	// Allowed origins such as https://dash.example.com, or * for any
+	origins     []string
+	methods     string
+	headers     string
+	credentials bool
+}
+
+// Build the CORS policy from the config, nil when no origins are allowed
+func newCORSPolicy(cfg *Config) *CORSPolicy {
+	var origins []string
+	for _, origin := range strings.Split(cfg.CORSOrigins, ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		return nil
+	}
+	return &CORSPolicy{
+		origins:     origins,
+		methods:     cfg.CORSMethods,
+		headers:     cfg.CORSHeaders,
+		credentials: cfg.CORSCredentials,
+	}
+}
+
+// Report whether pages from an origin may call the bridge
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	if p == nil {
+		return false
+	}
+	for _, allowed := range p.origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Report whether a request comes from no browser, a page on this machine or
+// an allowed origin; streams that can't use CORS headers check this instead.
+// Matching the Origin against Host wouldn't do: after DNS rebinding a page
+// on another site sends its own name in both.
+func (p *CORSPolicy) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if parsed, err := url.Parse(origin); err == nil && isLoopbackHost(parsed.Hostname()) {
+		return true
+	}
+	return p.allowsOrigin(origin)
+}
+
+// Whether a host name always means this machine
+func isLoopbackHost(host string) bool {
+	host = strings.ToLower(host)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.Unmap().IsLoopback()
+}
+
+// Middleware adds CORS headers for allowed origins and answers their
+// preflights itself, since browsers send those without the API key
+func (p *CORSPolicy) Middleware(next http.Handler) http.Handler {
+	if p == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !p.allowsOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The origin is echoed rather than sending *, which browsers refuse with credentials
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if p.credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", p.methods)
+			w.Header().Set("Access-Control-Allow-Headers", p.headers)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOriginRefusesRebinding(t *testing.T) {
+	cors := newCORSPolicy(&Config{CORSOrigins: "https://dash.example.com"})
+	for _, tc := range []struct {
+		host, origin string
+		allowed      bool
+	}{
+		{"localhost:8080", "", true},
+		{"localhost:8080", "http://localhost:8080", true},
+		{"127.0.0.1:8080", "http://127.0.0.1:3000", true},
+		{"[::1]:8080", "http://[::1]:8080", true},
+		{"bridge.internal:8080", "https://dash.example.com", true},
+		// A rebound name reaches the bridge with the page's own host in both headers
+		{"evil.example:8080", "http://evil.example:8080", false},
+		{"localhost:8080", "https://evil.example", false},
+	} {
+		r := httptest.NewRequest("POST", "/mcp", nil)
+		r.Host = tc.host
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if got := cors.checkOrigin(r); got != tc.allowed {
+			t.Errorf("Origin %q to %s allowed=%v, want %v", tc.origin, tc.host, got, tc.allowed)
+		}
+	}
+	if !(*CORSPolicy)(nil).checkOrigin(httptest.NewRequest("GET", "http://evil.example/mcp", nil)) {
+		t.Error("a request without an Origin was refused")
+	}
+}
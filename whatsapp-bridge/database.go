@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// Databases the message store and session can live in
+const (
+	DialectSQLite   = "sqlite3"
+	DialectPostgres = "postgres"
+)
+
+// Primary keys of the tables written with INSERT OR REPLACE, which Postgres
+// spells as an upsert on them
+var replaceConflictKeys = map[string][]string{
+	"reactions":   {"message_id", "chat_jid", "sender"},
+	"polls":       {"id", "chat_jid"},
+	"poll_votes":  {"poll_id", "chat_jid", "voter", "option"},
+	"blocklist":   {"jid"},
+	"groups":      {"jid"},
+	"newsletters": {"jid"},
+}
+
+var (
+	insertOrReplacePattern = regexp.MustCompile(`(?is)^\s*INSERT OR REPLACE INTO (\w+)\s*\(([^)]*)\)`)
+	insertOrIgnorePattern  = regexp.MustCompile(`(?is)^\s*INSERT OR IGNORE INTO `)
+	likePattern            = regexp.MustCompile(`\bLIKE\b`)
+)
+
+// DB is a database the message store queries with SQLite syntax. Queries
+// sent to Postgres are rewritten: ? placeholders are numbered, INSERT OR
+// REPLACE and INSERT OR IGNORE become ON CONFLICT clauses, LIKE ignores case
+// as it does in SQLite, and booleans are stored as 0 and 1.
+type DB struct {
+	*sql.DB
+	dialect string
+	// Postgres spellings of queries already rewritten
+	rewritten sync.Map
+}
+
+// Tx is a transaction on a DB
+type Tx struct {
+	*sql.Tx
+	db *DB
+}
+
+// Wrap a connection pool for a dialect
+func newDB(db *sql.DB, dialect string) *DB {
+	return &DB{DB: db, dialect: dialect}
+}
+
+// Report whether the database is a SQLite file
+func (db *DB) isSQLite() bool {
+	return db.dialect == DialectSQLite
+}
+
+// Spell a query for the database
+func (db *DB) rebind(query string) string {
+	if db.isSQLite() {
+		return query
+	}
+	if cached, ok := db.rewritten.Load(query); ok {
+		return cached.(string)
+	}
+	rewritten := postgresQuery(query)
+	db.rewritten.Store(query, rewritten)
+	return rewritten
+}
+
+// Store booleans the way SQLite does, so comparisons with 0 and 1 work
+func (db *DB) args(args []interface{}) []interface{} {
+	if db.isSQLite() {
+		return args
+	}
+	var converted []interface{}
+	for i, arg := range args {
+		var value interface{}
+		switch b := arg.(type) {
+		case bool:
+			value = boolInt(b)
+		case *bool:
+			if b == nil {
+				continue
+			}
+			value = boolInt(*b)
+		case sql.NullBool:
+			value = nil
+			if b.Valid {
+				value = boolInt(b.Bool)
+			}
+		default:
+			continue
+		}
+		// Leave the caller's slice alone
+		if converted == nil {
+			converted = append([]interface{}(nil), args...)
+		}
+		converted[i] = value
+	}
+	if converted == nil {
+		return args
+	}
+	return converted
+}
+
+// SQLite spelling of a boolean
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.rebind(query), db.args(args)...)
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.rebind(query), db.args(args)...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.rebind(query), db.args(args)...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.rebind(query), db.args(args)...)
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.rebind(query), db.args(args)...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.rebind(query), db.args(args)...)
+}
+
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, db: db}, nil
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.db.rebind(query), tx.db.args(args)...)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.db.rebind(query), tx.db.args(args)...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.db.rebind(query), tx.db.args(args)...)
+}
+
+// Rewrite a SQLite query for Postgres
+func postgresQuery(query string) string {
+	if match := insertOrReplacePattern.FindStringSubmatch(query); match != nil {
+		if keys, ok := replaceConflictKeys[match[1]]; ok {
+			query = strings.Replace(query, "INSERT OR REPLACE INTO", "INSERT INTO", 1)
+			query = strings.TrimRight(query, " \t\n;") + " " + upsertClause(keys, match[2])
+		}
+	} else if insertOrIgnorePattern.MatchString(query) {
+		query = strings.Replace(query, "INSERT OR IGNORE INTO", "INSERT INTO", 1)
+		query = strings.TrimRight(query, " \t\n;") + " ON CONFLICT DO NOTHING"
+	}
+	query = likePattern.ReplaceAllString(query, "ILIKE")
+	return numberPlaceholders(query)
+}
+
+// ON CONFLICT clause overwriting every inserted column but the key
+func upsertClause(keys []string, columnList string) string {
+	isKey := map[string]bool{}
+	for _, key := range keys {
+		isKey[key] = true
+	}
+	var updates []string
+	for _, column := range strings.Split(columnList, ",") {
+		if column = strings.TrimSpace(column); column != "" && !isKey[column] {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", column, column))
+		}
+	}
+	if len(updates) == 0 {
+		return fmt.Sprintf("ON CONFLICT (%s) DO NOTHING", strings.Join(keys, ", "))
+	}
+	return fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(keys, ", "), strings.Join(updates, ", "))
+}
+
+// Replace ? placeholders outside string literals with $1, $2, ...
+func numberPlaceholders(query string) string {
+	var b strings.Builder
+	n, quoted := 0, false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Postgres schema holding an account's tables. The default account uses the
+// schema the DSN selects, others get one of their own.
+func postgresSchema(accountID string) string {
+	if accountID == DefaultAccountID {
+		return ""
+	}
+	return "account_" + accountID
+}
+
+// Add a search_path to a Postgres DSN in either the URL or the key=value form.
+// Account IDs may hold capitals and dashes, so the schema is quoted.
+func postgresDSNWithSchema(dsn, schema string) (string, error) {
+	if schema == "" {
+		return dsn, nil
+	}
+	searchPath := pq.QuoteIdentifier(schema)
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", fmt.Errorf("invalid database URL: %v", err)
+		}
+		query := u.Query()
+		query.Set("search_path", searchPath)
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+	return dsn + " search_path='" + searchPath + "'", nil
+}
+
+// Open a pool on an account's Postgres schema, creating the schema first
+func openPostgres(dsn, accountID string) (*sql.DB, error) {
+	schema := postgresSchema(accountID)
+	if schema != "" {
+		admin, err := sql.Open(DialectPostgres, dsn)
+		if err != nil {
+			return nil, err
+		}
+		_, err = admin.Exec("CREATE SCHEMA IF NOT EXISTS " + pq.QuoteIdentifier(schema))
+		admin.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create schema %s: %v", schema, err)
+		}
+	}
+	scoped, err := postgresDSNWithSchema(dsn, schema)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(DialectPostgres, scoped)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to Postgres: %v", err)
+	}
+	return db, nil
+}
+
+// Drop an account's Postgres schema with everything in it, its session
+// included, so an account created later with the same ID starts empty
+func dropPostgresSchema(dsn, accountID string) error {
+	schema := postgresSchema(accountID)
+	if schema == "" {
+		return fmt.Errorf("the default account has no schema of its own")
+	}
+	admin, err := sql.Open(DialectPostgres, dsn)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	if _, err := admin.Exec("DROP SCHEMA IF EXISTS " + pq.QuoteIdentifier(schema) + " CASCADE"); err != nil {
+		return fmt.Errorf("failed to drop schema %s: %v", schema, err)
+	}
+	return nil
+}
+
+// Open one of an account's databases: a SQLite file in its directory, or its
+// schema in Postgres when --database-url is set. Returns the dialect for
+// whatsmeow's container along with the pool.
+func (c *Config) openAccountDatabase(accountID, dir, file, sqliteParams string) (*sql.DB, string, error) {
+	if c.DatabaseURL != "" {
+		db, err := openPostgres(c.DatabaseURL, accountID)
+		return db, DialectPostgres, err
+	}
+	db, err := openSQLite(filepath.Join(dir, file), sqliteParams)
+	return db, DialectSQLite, err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// MessageDetails is what we store about a message beyond its text and media keys
+type MessageDetails struct {
+	// text, image, video, audio, document, sticker, location, live_location or contact
+	Type     string
+	Caption  string
+	MimeType string
+	// The message this one replies to, if any
+	QuotedMessageID string
+	QuotedSender    string
+	// Display name the sender set for themselves
+	PushName    string
+	IsForwarded bool
+	// Length of audio and video in seconds, 0 when unknown
+	Seconds uint32
+	// Loudness of 64 slices of an audio message, each 0 to 100
+	Waveform []byte
+	// Size of images, videos and stickers in pixels, 0 when unknown
+	Width  uint32
+	Height uint32
+	// How many times the message was forwarded before reaching us
+	ForwardingScore uint32
+	// Whether a sticker moves
+	IsAnimated bool
+	// Full JID of the sender, whose server tells a phone number from a LID
+	SenderJID string
+}
+
+// Extract the type, caption, MIME type and reply context of a message
+func extractMessageDetails(msg *waProto.Message) MessageDetails {
+	var details MessageDetails
+	var info *waProto.ContextInfo
+
+	switch {
+	case msg.GetConversation() != "":
+		details.Type = "text"
+	case msg.GetExtendedTextMessage() != nil:
+		details.Type = "text"
+		info = msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		img := msg.GetImageMessage()
+		details.Type, details.Caption, details.MimeType = "image", img.GetCaption(), img.GetMimetype()
+		details.Width, details.Height = img.GetWidth(), img.GetHeight()
+		info = img.GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		vid := msg.GetVideoMessage()
+		details.Type, details.Caption, details.MimeType = "video", vid.GetCaption(), vid.GetMimetype()
+		details.Seconds = vid.GetSeconds()
+		details.Width, details.Height = vid.GetWidth(), vid.GetHeight()
+		info = vid.GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		aud := msg.GetAudioMessage()
+		details.Type, details.MimeType = "audio", aud.GetMimetype()
+		details.Seconds, details.Waveform = aud.GetSeconds(), aud.GetWaveform()
+		info = aud.GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		doc := msg.GetDocumentMessage()
+		details.Type, details.Caption, details.MimeType = "document", doc.GetCaption(), doc.GetMimetype()
+		info = doc.GetContextInfo()
+	case msg.GetStickerMessage() != nil:
+		sticker := msg.GetStickerMessage()
+		details.Type, details.MimeType = "sticker", sticker.GetMimetype()
+		details.Width, details.Height, details.IsAnimated = sticker.GetWidth(), sticker.GetHeight(), sticker.GetIsAnimated()
+		info = sticker.GetContextInfo()
+	case msg.GetLocationMessage() != nil:
+		details.Type = "location"
+		info = msg.GetLocationMessage().GetContextInfo()
+	case msg.GetLiveLocationMessage() != nil:
+		details.Type = "live_location"
+		info = msg.GetLiveLocationMessage().GetContextInfo()
+	case msg.GetContactMessage() != nil:
+		details.Type = "contact"
+		info = msg.GetContactMessage().GetContextInfo()
+	case interactiveReply(msg) != nil:
+		reply := interactiveReply(msg)
+		details.Type = reply.Type + "_reply"
+		details.QuotedMessageID = reply.QuotedMessageID
+	}
+
+	if info != nil {
+		details.QuotedMessageID = info.GetStanzaID()
+		details.QuotedSender = jidUser(info.GetParticipant())
+		details.IsForwarded = info.GetIsForwarded()
+		details.ForwardingScore = info.GetForwardingScore()
+	}
+	return details
+}
+
+// A waveform as numbers rather than base64, so clients can draw it directly
+func waveformValues(waveform []byte) []int {
+	if len(waveform) == 0 {
+		return nil
+	}
+	values := make([]int, len(waveform))
+	for i, sample := range waveform {
+		values[i] = int(sample)
+	}
+	return values
+}
+
+// Strip the server and device from a JID string, matching how senders are stored
+func jidUser(jid string) string {
+	parsed, err := types.ParseJID(jid)
+	if err != nil {
+		return jid
+	}
+	return parsed.User
+}
+
+// Save the details of a stored message
+func (store *MessageStore) StoreMessageDetails(id, chatJID string, details MessageDetails) error {
+	_, err := store.db.Exec(
+		`UPDATE messages SET message_type = ?, caption = ?, mime_type = ?, quoted_message_id = ?, quoted_sender = ?,
+		push_name = ?, is_forwarded = ?, duration_seconds = ?, waveform = ?, width = ?, height = ?, forwarding_score = ?,
+		is_animated = ?, sender_jid = COALESCE(NULLIF(?, ''), sender_jid) WHERE id = ? AND chat_jid = ?`,
+		details.Type, details.Caption, details.MimeType, details.QuotedMessageID, details.QuotedSender,
+		details.PushName, details.IsForwarded, details.Seconds, details.Waveform, details.Width, details.Height,
+		details.ForwardingScore, details.IsAnimated, details.SenderJID, id, chatJID,
+	)
+	return err
+}
+
+// Fill in the duration and waveform of an audio message that arrived without
+// them, keeping whatever the sender provided
+func (store *MessageStore) FillAudioDetails(id, chatJID string, seconds uint32, waveform []byte) error {
+	_, err := store.db.Exec(
+		`UPDATE messages SET duration_seconds = CASE WHEN COALESCE(duration_seconds, 0) = 0 THEN ? ELSE duration_seconds END,
+		waveform = COALESCE(waveform, ?) WHERE id = ? AND chat_jid = ?`,
+		seconds, waveform, id, chatJID,
+	)
+	return err
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// How often expired disappearing messages are purged from the store
+const purgeInterval = time.Minute
+
+// Timer names accepted by PUT /api/chats/{jid}/disappearing, in seconds
+var disappearingTimerNames = map[string]uint32{
+	"off": 0,
+	"24h": 24 * 60 * 60,
+	"7d":  7 * 24 * 60 * 60,
+	"90d": 90 * 24 * 60 * 60,
+}
+
+// DisappearingRequest represents the request body for the disappearing messages API
+type DisappearingRequest struct {
+	// off, 24h, 7d or 90d
+	Timer string `json:"timer"`
+}
+
+// DisappearingResponse represents the response for the disappearing messages API
+type DisappearingResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	JID     string `json:"jid,omitempty"`
+	Timer   string `json:"timer,omitempty"`
+	// Seconds until new messages disappear; 0 when off
+	DisappearingTimer uint32 `json:"disappearing_timer"`
+}
+
+// Name a disappearing timer for responses
+func disappearingTimerName(seconds uint32) string {
+	for name, value := range disappearingTimerNames {
+		if value == seconds {
+			return name
+		}
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
+// Get the context info of a message, and whether its type can carry one
+func messageContextInfo(msg *waProto.Message) (*waProto.ContextInfo, bool) {
+	switch {
+	case msg.Conversation != nil:
+		return nil, true
+	case msg.ExtendedTextMessage != nil:
+		return msg.ExtendedTextMessage.GetContextInfo(), true
+	case msg.ImageMessage != nil:
+		return msg.ImageMessage.GetContextInfo(), true
+	case msg.VideoMessage != nil:
+		return msg.VideoMessage.GetContextInfo(), true
+	case msg.AudioMessage != nil:
+		return msg.AudioMessage.GetContextInfo(), true
+	case msg.DocumentMessage != nil:
+		return msg.DocumentMessage.GetContextInfo(), true
+	case msg.StickerMessage != nil:
+		return msg.StickerMessage.GetContextInfo(), true
+	case msg.LocationMessage != nil:
+		return msg.LocationMessage.GetContextInfo(), true
+	case msg.LiveLocationMessage != nil:
+		return msg.LiveLocationMessage.GetContextInfo(), true
+	case msg.ContactMessage != nil:
+		return msg.ContactMessage.GetContextInfo(), true
+	case msg.ContactsArrayMessage != nil:
+		return msg.ContactsArrayMessage.GetContextInfo(), true
+	case msg.ProductMessage != nil:
+		return msg.ProductMessage.GetContextInfo(), true
+	}
+	return nil, false
+}
+
+// Save the disappearing timer of a chat, creating it if needed
+func (store *MessageStore) SetChatDisappearingTimer(jid string, seconds uint32) error {
+	return store.setChatState(jid, "disappearing_timer", seconds)
+}
+
+// Get the disappearing timer of a chat, 0 when off or unknown
+func (store *MessageStore) GetChatDisappearingTimer(jid string) (uint32, error) {
+	var seconds uint32
+	err := store.db.QueryRow("SELECT COALESCE(disappearing_timer, 0) FROM chats WHERE jid = ?", jid).Scan(&seconds)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seconds, err
+}
+
+// Record when a stored message disappears
+func (store *MessageStore) SetMessageExpiry(id, chatJID string, expiresAt time.Time) error {
+	_, err := store.db.Exec("UPDATE messages SET expires_at = ? WHERE id = ? AND chat_jid = ?", expiresAt.UTC(), id, chatJID)
+	return err
+}
+
+// Delete messages whose disappearing timer ran out, with their reactions and
+// edit history
+func (store *MessageStore) PurgeExpiredMessages(now time.Time) (int64, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	expired := "SELECT id, chat_jid FROM messages WHERE expires_at IS NOT NULL AND expires_at <= ?"
+	now = now.UTC()
+	if _, err := tx.Exec("DELETE FROM reactions WHERE (message_id, chat_jid) IN ("+expired+")", now); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM message_edits WHERE (message_id, chat_jid) IN ("+expired+")", now); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM messages WHERE expires_at IS NOT NULL AND expires_at <= ?", now)
+	if err != nil {
+		return 0, err
+	}
+	purged, _ := result.RowsAffected()
+	return purged, tx.Commit()
+}
+
+// Mark outgoing messages with the chat's disappearing timer, as the apps do,
+// so they disappear for the recipient too
+func (a *Account) applyDisappearingTimer(to types.JID, msg *waProto.Message) uint32 {
+	seconds, err := a.MessageStore.GetChatDisappearingTimer(to.String())
+	if err != nil {
+		a.Logger.Warnf("Failed to get disappearing timer of %s: %v", to, err)
+		return 0
+	}
+	if seconds == 0 {
+		return 0
+	}
+	// Reactions, edits and other control messages don't disappear on their own
+	info, ok := messageContextInfo(msg)
+	if !ok {
+		return 0
+	}
+	if info == nil {
+		info = &waProto.ContextInfo{}
+	}
+	info.Expiration = &seconds
+	applyContextInfo(msg, info)
+	return seconds
+}
+
+// Track the disappearing timer of incoming messages. Each message carries
+// the chat's current timer, so it also keeps the chat setting up to date.
+func (a *Account) trackDisappearing(msg *events.Message) {
+	info, _ := messageContextInfo(msg.Message)
+	seconds := info.GetExpiration()
+	if seconds == 0 {
+		return
+	}
+	chatJID := msg.Info.Chat.String()
+	expiresAt := msg.Info.Timestamp.Add(time.Duration(seconds) * time.Second)
+	if err := a.MessageStore.SetMessageExpiry(msg.Info.ID, chatJID, expiresAt); err != nil {
+		a.Logger.Warnf("Failed to store expiry of %s: %v", msg.Info.ID, err)
+	}
+	if err := a.MessageStore.SetChatDisappearingTimer(chatJID, seconds); err != nil {
+		a.Logger.Warnf("Failed to store disappearing timer of %s: %v", chatJID, err)
+	}
+}
+
+// Save a disappearing timer change announced in a chat
+func (a *Account) handleDisappearingSetting(evt *events.Message, protocolMsg *waProto.ProtocolMessage) {
+	if err := a.MessageStore.SetChatDisappearingTimer(evt.Info.Chat.String(), protocolMsg.GetEphemeralExpiration()); err != nil {
+		a.Logger.Warnf("Failed to store disappearing timer of %s: %v", evt.Info.Chat, err)
+	}
+}
+
+// DisappearingPurger deletes stored messages once their disappearing timer
+// runs out, mirroring what the phone does
+type DisappearingPurger struct {
+	account *Account
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Create a purger for an account
+func NewDisappearingPurger(account *Account) *DisappearingPurger {
+	return &DisappearingPurger{
+		account: account,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Run purges expired messages until Stop is called
+func (p *DisappearingPurger) Run() {
+	defer close(p.done)
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if purged, err := p.account.MessageStore.PurgeExpiredMessages(time.Now()); err != nil {
+			p.account.Logger.Warnf("Failed to purge disappearing messages: %v", err)
+		} else if purged > 0 {
+			p.account.Logger.Infof("Purged %d disappearing messages", purged)
+		}
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Stop the purger and wait for an in-flight purge to finish
+func (p *DisappearingPurger) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+// Handle PUT /api/chats/{jid}/disappearing
+func (a *Account) HandleDisappearingEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow PUT requests
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	chat, err := parseRecipient(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse the request body
+	var req DisappearingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	seconds, ok := disappearingTimerNames[strings.ToLower(strings.TrimSpace(req.Timer))]
+	if !ok {
+		http.Error(w, "timer must be off, 24h, 7d or 90d", http.StatusBadRequest)
+		return
+	}
+	timer, _ := parseDisappearingTimer(seconds)
+
+	w.Header().Set("Content-Type", "application/json")
+	response := DisappearingResponse{JID: chat.String(), Timer: disappearingTimerName(seconds), DisappearingTimer: seconds}
+	if !a.Client.IsConnected() {
+		response.Message = errNotConnected.Error()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	if err := a.Client.SetDisappearingTimer(chat, timer); err != nil {
+		response.Message = fmt.Sprintf("Failed to set disappearing timer: %v", err)
+		w.WriteHeader(groupErrorStatus(err))
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	// Our own setting change isn't echoed back, so store it ourselves
+	if err := a.MessageStore.SetChatDisappearingTimer(chat.String(), seconds); err != nil {
+		a.Logger.Warnf("Failed to store disappearing timer of %s: %v", chat, err)
+	}
+
+	response.Success = true
+	response.Message = "Disappearing messages turned off"
+	if seconds > 0 {
+		response.Message = fmt.Sprintf("New messages disappear after %s", response.Timer)
+	}
+	json.NewEncoder(w).Encode(response)
+}
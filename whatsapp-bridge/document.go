@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Width of the cover image shown on PDF documents
+const documentThumbSize = 240
+
+// Page objects in an uncompressed PDF; /Type /Pages nodes don't match
+var pdfPageObject = regexp.MustCompile(`/Type\s*/Page\b`)
+
+// DocumentPreview is what recipients see of a document before opening it
+type DocumentPreview struct {
+	PageCount uint32
+	// JPEG of the first page
+	Thumbnail []byte
+	Width     uint32
+	Height    uint32
+}
+
+// Whether an attachment is a PDF
+func isPDF(att *Attachment) bool {
+	return strings.Split(att.MimeType, ";")[0] == "application/pdf" || bytes.HasPrefix(att.Data, []byte("%PDF-"))
+}
+
+// Read the page count and render the cover of a PDF. Poppler's pdfinfo and
+// pdftoppm are used when installed; without them only the page count of
+// PDFs that don't compress their page tree is found.
+func pdfPreview(data []byte) (*DocumentPreview, error) {
+	file, err := os.CreateTemp("", "whatsapp-document-*.pdf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write temp file: %v", err)
+	}
+
+	preview := &DocumentPreview{PageCount: pdfInfoPages(file.Name())}
+	if preview.PageCount == 0 {
+		preview.PageCount = uint32(len(pdfPageObject.FindAll(data, -1)))
+	}
+
+	cover, err := pdfCover(file.Name())
+	if err != nil {
+		return preview, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(cover))
+	if err != nil {
+		return preview, fmt.Errorf("failed to read cover: %v", err)
+	}
+	preview.Thumbnail = cover
+	preview.Width, preview.Height = uint32(config.Width), uint32(config.Height)
+	return preview, nil
+}
+
+// Page count reported by pdfinfo, 0 when it isn't available
+func pdfInfoPages(path string) uint32 {
+	pdfinfo, err := exec.LookPath("pdfinfo")
+	if err != nil {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, pdfinfo, path).Output()
+	if err != nil {
+		return 0
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "Pages:"); ok {
+			pages, _ := strconv.Atoi(strings.TrimSpace(value))
+			return uint32(max(pages, 0))
+		}
+	}
+	return 0
+}
+
+// Render the first page of a PDF as a JPEG with pdftoppm
+func pdfCover(path string) ([]byte, error) {
+	pdftoppm, err := exec.LookPath("pdftoppm")
+	if err != nil {
+		return nil, fmt.Errorf("pdftoppm is required for PDF covers but was not found in PATH")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+	defer cancel()
+	// An output root of - writes the single image to stdout
+	cmd := exec.CommandContext(ctx, pdftoppm, "-jpeg", "-f", "1", "-l", "1", "-singlefile",
+		"-scale-to-x", strconv.Itoa(documentThumbSize), "-scale-to-y", "-1", path, "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("pdftoppm failed: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return output, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How often a drain checks whether the queues are empty
+const drainPollInterval = 200 * time.Millisecond
+
+// How long requests still running at shutdown get to finish
+const httpShutdownTimeout = 5 * time.Second
+
+// Set while draining: send requests are refused and the scheduler and
+// broadcasts hold their messages, while the outbox and media jobs finish
+var draining atomic.Bool
+
+// AccountDrain is what an account still had queued when a drain ended
+type AccountDrain struct {
+	Account   string `json:"account"`
+	Connected bool   `json:"connected"`
+	// Outbox messages due for delivery. Those of a disconnected account stay
+	// queued in the database and go out after the next start.
+	Outbox    int `json:"outbox"`
+	MediaJobs int `json:"media_jobs"`
+}
+
+// DrainResponse is the JSON body of the /api/admin/drain endpoint
+type DrainResponse struct {
+	Draining bool `json:"draining"`
+	// Whether everything queued went out before the timeout
+	Complete        bool           `json:"complete"`
+	Accounts        []AccountDrain `json:"accounts,omitempty"`
+	WebhooksPending int            `json:"webhooks_pending"`
+	Checkpointed    []string       `json:"checkpointed,omitempty"`
+	Errors          []string       `json:"errors,omitempty"`
+	StartedAt       *time.Time     `json:"started_at,omitempty"`
+	FinishedAt      *time.Time     `json:"finished_at,omitempty"`
+}
+
+// Drainer stops new sends and waits for what is already queued to go out,
+// on shutdown or when asked through /api/admin/drain
+type Drainer struct {
+	accounts *AccountManager
+	timeout  time.Duration
+	// Databases not owned by an account, by name
+	databases map[string]*sql.DB
+
+	// Held for the length of a drain
+	running sync.Mutex
+	mu      sync.Mutex
+	// Outcome of the last drain
+	last DrainResponse
+}
+
+// Create a drainer for the accounts and the bridge-wide databases
+func NewDrainer(accounts *AccountManager, keys *APIKeyStore, audit *AuditLog, timeout time.Duration) *Drainer {
+	databases := map[string]*sql.DB{
+		"webhooks": accounts.notifier.db,
+		"api_keys": keys.db,
+	}
+	if audit != nil {
+		databases["audit"] = audit.db
+	}
+	return &Drainer{accounts: accounts, timeout: timeout, databases: databases}
+}
+
+// Middleware refusing everything that needs the send scope while draining.
+// Tool calls made through /mcp are checked when they reach their route.
+func drainMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if draining.Load() && requiredScope(r) == ScopeSend && r.URL.Path != "/mcp" {
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "The bridge is draining and not accepting new messages", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stop accepting sends, wait until the outbox, media jobs and webhook retries
+// are done or the timeout passes, then checkpoint every database. The bridge
+// stays connected and keeps refusing sends until Resume.
+func (d *Drainer) Drain() DrainResponse {
+	d.running.Lock()
+	defer d.running.Unlock()
+
+	started := time.Now()
+	if !draining.Swap(true) {
+		bridgeLog.Infof("Draining: refusing new sends for up to %v while queues empty", d.timeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	response := DrainResponse{Draining: true, StartedAt: &started}
+	for _, account := range d.accounts.List() {
+		account.Outbox.Wake()
+	}
+	response.Accounts = d.waitForAccounts(ctx)
+	response.WebhooksPending = d.accounts.notifier.Flush(ctx)
+
+	response.Complete = response.WebhooksPending == 0
+	for _, account := range response.Accounts {
+		if account.MediaJobs > 0 || (account.Connected && account.Outbox > 0) {
+			response.Complete = false
+		}
+	}
+
+	for name, db := range d.checkpointTargets() {
+		if err := checkpointWAL(db); err != nil {
+			response.Errors = append(response.Errors, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		response.Checkpointed = append(response.Checkpointed, name)
+	}
+	sort.Strings(response.Checkpointed)
+	sort.Strings(response.Errors)
+
+	finished := time.Now()
+	response.FinishedAt = &finished
+	if response.Complete {
+		bridgeLog.Infof("Drained in %v", finished.Sub(started).Round(time.Millisecond))
+	} else {
+		bridgeLog.Warnf("Drain timed out after %v with messages or webhooks still pending", d.timeout)
+	}
+	for _, err := range response.Errors {
+		bridgeLog.Warnf("Failed to checkpoint %s", err)
+	}
+	d.mu.Lock()
+	d.last = response
+	d.mu.Unlock()
+	return response
+}
+
+// Accept sends again after a drain
+func (d *Drainer) Resume() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if draining.Swap(false) {
+		d.accounts.notifier.Resume()
+		bridgeLog.Infof("Drain ended, accepting sends again")
+	}
+	d.last.Draining = false
+}
+
+// Poll the accounts until none has due outbox messages or media jobs left,
+// returning what each still has when ctx ends
+func (d *Drainer) waitForAccounts(ctx context.Context) []AccountDrain {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		remaining, busy := []AccountDrain{}, false
+		for _, account := range d.accounts.List() {
+			state := account.drainState(ctx)
+			if state.MediaJobs > 0 || (state.Connected && state.Outbox > 0) {
+				busy = true
+			}
+			remaining = append(remaining, state)
+		}
+		if !busy {
+			return remaining
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return remaining
+		}
+	}
+}
+
+// What an account has left to send
+func (a *Account) drainState(ctx context.Context) AccountDrain {
+	state := AccountDrain{Account: a.ID, Connected: a.Client.IsConnected() && !a.ReadOnly}
+	for _, job := range a.MediaJobs.List() {
+		if job.Status == MediaJobQueued || job.Status == MediaJobProcessing {
+			state.MediaJobs++
+		}
+	}
+	err := a.MessageStore.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM outbox WHERE status = ? AND next_attempt_at <= ?",
+		OutboxStatusPending, time.Now().UTC(),
+	).Scan(&state.Outbox)
+	if err != nil && ctx.Err() == nil {
+		a.Logger.Warnf("Failed to count outbox: %v", err)
+	}
+	return state
+}
+
+// Every database to checkpoint, by name
+func (d *Drainer) checkpointTargets() map[string]*sql.DB {
+	targets := make(map[string]*sql.DB, len(d.databases))
+	for name, db := range d.databases {
+		targets[name] = db
+	}
+	for _, account := range d.accounts.List() {
+		// Postgres needs no checkpoint
+		if account.MessageStore.db.isSQLite() {
+			targets[account.ID+"/messages"] = account.MessageStore.db.DB
+			targets[account.ID+"/session"] = account.sessionDB
+		}
+	}
+	return targets
+}
+
+// Copy the write-ahead log into the database file and truncate it, so the
+// file alone is a complete copy. Databases not in WAL mode have nothing to do.
+func checkpointWAL(db *sql.DB) error {
+	var busy, logFrames, checkpointed int
+	if err := db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return err
+	}
+	if busy != 0 {
+		return fmt.Errorf("database busy, %d of %d frames checkpointed", checkpointed, logFrames)
+	}
+	return nil
+}
+
+// Handle GET, POST and DELETE /api/admin/drain
+func (d *Drainer) HandleDrainEndpoint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		d.mu.Lock()
+		response := d.last
+		d.mu.Unlock()
+		response.Draining = draining.Load()
+		json.NewEncoder(w).Encode(response)
+
+	case http.MethodPost:
+		// Answers once the drain has finished or timed out
+		json.NewEncoder(w).Encode(d.Drain())
+
+	case http.MethodDelete:
+		d.Resume()
+		json.NewEncoder(w).Encode(DrainResponse{Draining: false})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// WhatsApp clients only offer editing for 15 minutes after sending
+const messageEditWindow = 15 * time.Minute
+
+// EditMessageRequest represents the request body for the edit message API
+type EditMessageRequest struct {
+	ChatJID string `json:"chat_jid"`
+	Body    string `json:"body"`
+}
+
+// Replace the content of a message, keeping the previous text in message_edits
+func (store *MessageStore) EditMessage(id, chatJID, content string, editedAt time.Time) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRow("SELECT content FROM messages WHERE id = ? AND chat_jid = ?", id, chatJID).Scan(&previous)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO message_edits (message_id, chat_jid, content, edited_at) VALUES (?, ?, ?, ?)",
+		id, chatJID, previous, editedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE messages SET content = ? WHERE id = ? AND chat_jid = ?", content, id, chatJID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Apply an edit made on another device or by the other party
+func (a *Account) handleEdit(evt *events.Message, protocolMsg *waProto.ProtocolMessage) {
+	messageID := protocolMsg.GetKey().GetID()
+	content := extractTextContent(protocolMsg.GetEditedMessage())
+	if content == "" {
+		return
+	}
+
+	err := a.MessageStore.EditMessage(messageID, evt.Info.Chat.String(), content, evt.Info.Timestamp)
+	if err == sql.ErrNoRows {
+		a.Logger.Debugf("Ignoring edit of unknown message %s", messageID)
+		return
+	} else if err != nil {
+		a.Logger.Warnf("Failed to store edit: %v", err)
+		return
+	}
+
+	a.Logger.Infof("%s edited %s: %s", evt.Info.Sender.User, messageID, content)
+}
+
+// Handle PATCH /api/messages/{id}
+func (a *Account) handleEditEndpoint(w http.ResponseWriter, r *http.Request) {
+	messageID := r.PathValue("id")
+
+	// Parse the request body
+	var req EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		http.Error(w, "Body is required", http.StatusBadRequest)
+		return
+	}
+
+	chat, err := a.resolveMessageChat(messageID, req.ChatJID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only our own text messages inside the edit window can be edited
+	original, err := a.MessageStore.GetMessage(messageID, chat.String())
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf("Message %s not found", messageID), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to look up message: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !original.IsFromMe {
+		http.Error(w, "Only messages sent by this account can be edited", http.StatusForbidden)
+		return
+	}
+	if original.MediaType != "" {
+		http.Error(w, "Only text messages can be edited", http.StatusBadRequest)
+		return
+	}
+	if time.Since(original.Time) > messageEditWindow {
+		http.Error(w, fmt.Sprintf("Messages can only be edited within %d minutes of sending", int(messageEditWindow.Minutes())), http.StatusConflict)
+		return
+	}
+
+	msg := a.Client.BuildEdit(chat, messageID, &waProto.Message{Conversation: proto.String(req.Body)})
+	resp, err := a.sendMessage(chat, msg)
+	if err == nil {
+		if err := a.MessageStore.EditMessage(messageID, chat.String(), req.Body, resp.Timestamp); err != nil {
+			a.Logger.Warnf("Failed to store edit: %v", err)
+		}
+		// Report the ID of the edited message rather than the protocol message
+		resp.ID = messageID
+	}
+	writeSendResult(w, chat, resp, err)
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Presence and group events, sent to webhooks and event streams
+const (
+	WebhookEventPresence     = "presence"
+	WebhookEventChatPresence = "chat_presence"
+	WebhookEventGroupUpdate  = "group_update"
+	WebhookEventGroupJoined  = "group_joined"
+)
+
+// Control frames sent to event stream clients
+const (
+	streamEventSubscribed = "subscribed"
+	streamEventError      = "error"
+)
+
+// Keepalive and buffering limits for event streams. A client that falls more
+// than eventBufferSize events behind misses events rather than stalling others,
+// and reconnecting clients can resume from the last eventHistorySize events.
+const (
+	eventBufferSize   = 256
+	eventHistorySize  = 1000
+	wsWriteTimeout    = 10 * time.Second
+	wsPongTimeout     = 60 * time.Second
+	wsPingInterval    = 30 * time.Second
+	wsMaxControlFrame = 64 * 1024
+
+	sseHeartbeatInterval = 15 * time.Second
+	sseRetryMillis       = 3000
+)
+
+// The Origin check rejects browsers on sites other than localhost that
+// --cors-origins doesn't allow; other clients send no Origin
+var eventUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// EventFilter selects the events a stream receives. Empty fields match everything.
+type EventFilter struct {
+	AccountID string   `json:"account_id,omitempty"`
+	Events    []string `json:"events,omitempty"`
+	ChatJIDs  []string `json:"chat_jids,omitempty"`
+}
+
+// An event numbered in publish order, which SSE clients resume from
+type streamEvent struct {
+	Seq     uint64
+	Payload WebhookPayload
+}
+
+// Subscriber to the event hub
+type eventSubscriber struct {
+	frames chan streamEvent
+	filter EventFilter
+}
+
+// EventHub fans events out to live stream clients
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+	seq         uint64
+	recent      []streamEvent
+}
+
+// Create an event hub with no subscribers
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[*eventSubscriber]struct{})}
+}
+
+// Build a filter from the account_id, events and chat_jid query parameters.
+// events and chat_jid may be repeated or comma separated.
+func filterFromQuery(r *http.Request) (EventFilter, error) {
+	query := r.URL.Query()
+	filter := EventFilter{
+		AccountID: query.Get("account_id"),
+		Events:    parseEventList(strings.Join(query["events"], ",")),
+		ChatJIDs:  parseEventList(strings.Join(query["chat_jid"], ",")),
+	}
+	return filter, filter.normalize()
+}
+
+// Normalize chat JIDs so phone numbers match the JIDs events carry
+func (f *EventFilter) normalize() error {
+	for i, chat := range f.ChatJIDs {
+		jid, err := parseRecipient(chat)
+		if err != nil {
+			return fmt.Errorf("invalid chat_jid %q: %v", chat, err)
+		}
+		f.ChatJIDs[i] = jid.String()
+	}
+	return nil
+}
+
+// Report whether an event passes the filter. Events without a chat only
+// match filters that don't name chats.
+func (f *EventFilter) matches(payload WebhookPayload) bool {
+	if f.AccountID != "" && f.AccountID != payload.AccountID {
+		return false
+	}
+	if len(f.Events) > 0 {
+		target := WebhookTarget{Events: f.Events}
+		if !target.wants(payload.Event) {
+			return false
+		}
+	}
+	if len(f.ChatJIDs) > 0 {
+		chat, _ := payload.Data["chat_jid"].(string)
+		for _, jid := range f.ChatJIDs {
+			if jid == chat {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Subscribe to events matching a filter. With a non-zero after, the matching
+// events still in history that were published after that sequence number are
+// returned too, so a resuming client sees no gap. A sequence number from before
+// a restart replays the whole history.
+func (h *EventHub) Subscribe(filter EventFilter, after uint64) (*eventSubscriber, []streamEvent) {
+	sub := &eventSubscriber{frames: make(chan streamEvent, eventBufferSize), filter: filter}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[sub] = struct{}{}
+
+	var backlog []streamEvent
+	if after > 0 {
+		if after > h.seq {
+			after = 0
+		}
+		for _, evt := range h.recent {
+			if evt.Seq > after && filter.matches(evt.Payload) {
+				backlog = append(backlog, evt)
+			}
+		}
+	}
+	return sub, backlog
+}
+
+// Stop delivering events to a subscriber
+func (h *EventHub) Unsubscribe(sub *eventSubscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, sub)
+	h.mu.Unlock()
+}
+
+// Replace the filter of a subscriber
+func (h *EventHub) SetFilter(sub *eventSubscriber, filter EventFilter) {
+	h.mu.Lock()
+	sub.filter = filter
+	h.mu.Unlock()
+}
+
+// Publish an event to every matching subscriber without blocking
+func (h *EventHub) Publish(payload WebhookPayload) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	evt := streamEvent{Seq: h.seq, Payload: payload}
+	h.recent = append(h.recent, evt)
+	if len(h.recent) > eventHistorySize {
+		h.recent = h.recent[len(h.recent)-eventHistorySize:]
+	}
+
+	for sub := range h.subscribers {
+		if !sub.filter.matches(payload) {
+			continue
+		}
+		select {
+		case sub.frames <- evt:
+		default:
+		}
+	}
+}
+
+// Build a control frame for a stream client
+func streamFrame(event string, data map[string]interface{}) WebhookPayload {
+	return WebhookPayload{ID: newJobID(), Event: event, Timestamp: time.Now(), Data: data}
+}
+
+// Describe a filter in a subscribed frame
+func subscribedFrame(filter EventFilter) WebhookPayload {
+	return streamFrame(streamEventSubscribed, map[string]interface{}{
+		"account_id": filter.AccountID,
+		"events":     filter.Events,
+		"chat_jids":  filter.ChatJIDs,
+	})
+}
+
+// Handle GET /api/events/ws
+//
+// Every event is sent as a JSON text frame shaped like a webhook payload. The
+// initial filter comes from the query string; clients can replace it at any
+// time by sending {"events": [...], "chat_jids": [...], "account_id": "..."}.
+func (h *EventHub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := filterFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Upgrade writes its own error response
+	conn, err := eventUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub, _ := h.Subscribe(filter, 0)
+	defer h.Unsubscribe(sub)
+
+	// Read filter updates until the client goes away; replies go through the
+	// writer below since a connection supports only one concurrent writer
+	replies := make(chan WebhookPayload, 4)
+	reply := func(frame WebhookPayload) {
+		select {
+		case replies <- frame:
+		default:
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadLimit(wsMaxControlFrame)
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		})
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var update EventFilter
+			if err := json.Unmarshal(message, &update); err != nil {
+				reply(streamFrame(streamEventError, map[string]interface{}{"message": "Invalid filter: " + err.Error()}))
+				continue
+			}
+			if err := update.normalize(); err != nil {
+				reply(streamFrame(streamEventError, map[string]interface{}{"message": err.Error()}))
+				continue
+			}
+			h.SetFilter(sub, update)
+			reply(subscribedFrame(update))
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	send := func(frame WebhookPayload) bool {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(frame) == nil
+	}
+	if !send(subscribedFrame(filter)) {
+		return
+	}
+	for {
+		select {
+		case evt := <-sub.frames:
+			if !send(evt.Payload) {
+				return
+			}
+		case frame := <-replies:
+			if !send(frame) {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Handle GET /api/events/sse
+//
+// Server-Sent Events carry the same payloads as the WebSocket stream for
+// clients behind proxies that block WebSockets. Events are unnamed so
+// EventSource.onmessage receives all of them, and each has an id so a
+// reconnecting client resumes via the Last-Event-ID header (or the
+// last_event_id query parameter). Comments are sent as heartbeats.
+func (h *EventHub) HandleSSE(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := filterFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var after uint64
+	if lastEventID != "" {
+		if after, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub, backlog := h.Subscribe(filter, after)
+	defer h.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stop nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n: connected\n\n", sseRetryMillis)
+
+	send := func(evt streamEvent) bool {
+		data, err := json.Marshal(evt.Payload)
+		if err != nil {
+			return true
+		}
+		_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", evt.Seq, data)
+		return err == nil
+	}
+	for _, evt := range backlog {
+		if !send(evt) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case evt := <-sub.frames:
+			if !send(evt) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// Store a contact coming online or going offline and forward it to event streams and webhooks
+func (a *Account) handlePresence(evt *events.Presence) {
+	a.storePresence(evt)
+
+	data := map[string]interface{}{
+		"chat_jid":  evt.From.ToNonAD().String(),
+		"available": !evt.Unavailable,
+	}
+	if !evt.LastSeen.IsZero() {
+		data["last_seen"] = evt.LastSeen
+	}
+	a.Notifier.Notify(a.ID, WebhookEventPresence, data)
+}
+
+// Forward typing and recording indicators
+func (a *Account) handleChatPresence(evt *events.ChatPresence) {
+	state := string(evt.State)
+	if evt.State == types.ChatPresenceComposing && evt.Media == types.ChatPresenceMediaAudio {
+		state = TypingStateRecording
+	}
+	a.Notifier.Notify(a.ID, WebhookEventChatPresence, map[string]interface{}{
+		"chat_jid": evt.Chat.String(),
+		"sender":   evt.Sender.User,
+		"state":    state,
+	})
+}
+
+// Convert a list of JIDs for an event payload
+func jidStrings(jids []types.JID) []string {
+	values := make([]string, len(jids))
+	for i, jid := range jids {
+		values[i] = jid.String()
+	}
+	return values
+}
+
+// Forward group metadata and membership changes and refresh the cached metadata
+func (a *Account) handleGroupInfo(evt *events.GroupInfo) {
+	data := map[string]interface{}{
+		"chat_jid":  evt.JID.String(),
+		"timestamp": evt.Timestamp,
+	}
+	if evt.Sender != nil {
+		data["sender"] = evt.Sender.User
+	}
+	if evt.Name != nil {
+		data["name"] = evt.Name.Name
+	}
+	if evt.Topic != nil {
+		data["topic"] = evt.Topic.Topic
+	}
+	if evt.Locked != nil {
+		data["locked"] = evt.Locked.IsLocked
+	}
+	if evt.Announce != nil {
+		data["announce"] = evt.Announce.IsAnnounce
+	}
+	if evt.Ephemeral != nil {
+		data["disappearing_timer"] = evt.Ephemeral.DisappearingTimer
+	}
+	if evt.Delete != nil {
+		data["deleted"] = true
+	}
+	if evt.NewInviteLink != nil {
+		data["invite_link"] = *evt.NewInviteLink
+	}
+	for key, jids := range map[string][]types.JID{
+		"joined":   evt.Join,
+		"left":     evt.Leave,
+		"promoted": evt.Promote,
+		"demoted":  evt.Demote,
+	} {
+		if len(jids) > 0 {
+			data[key] = jidStrings(jids)
+		}
+	}
+	a.Notifier.Notify(a.ID, WebhookEventGroupUpdate, data)
+	a.handleJoinRequestChanges(evt)
+	go a.refreshCachedGroup(evt.JID)
+}
+
+// Cache and forward being added to a group or creating one
+func (a *Account) handleJoinedGroup(evt *events.JoinedGroup) {
+	a.cacheGroup(&evt.GroupInfo)
+	a.Notifier.Notify(a.ID, WebhookEventGroupJoined, map[string]interface{}{
+		"chat_jid":     evt.JID.String(),
+		"name":         evt.Name,
+		"reason":       evt.Reason,
+		"type":         evt.Type,
+		"participants": len(evt.Participants),
+	})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Formats a chat can be exported in
+const (
+	ExportFormatJSON = "json"
+	ExportFormatCSV  = "csv"
+	ExportFormatTXT  = "txt"
+)
+
+// How media appears in an export
+const (
+	// Only that a message had media
+	ExportMediaNone = "none"
+	// Where the downloaded file is
+	ExportMediaPath = "path"
+	// The downloaded file itself, base64 encoded
+	ExportMediaBase64 = "base64"
+)
+
+// Layout of a line of the phone's own "Export chat" text file
+const exportTextTimeLayout = "02/01/2006, 15:04"
+
+// Header row of a CSV export
+var exportCSVHeader = []string{"timestamp", "id", "sender", "sender_name", "is_from_me", "message_type", "text",
+	"media_type", "filename", "mime_type", "media_path", "media_base64", "quoted_message_id", "is_forwarded", "is_starred"}
+
+// ExportedMessage is a message in a JSON export
+type ExportedMessage struct {
+	StoredMessage
+	SenderName string `json:"sender_name,omitempty"`
+	// Downloaded media, with media=base64
+	MediaBase64 string `json:"media_base64,omitempty"`
+}
+
+// ChatExportHeader opens a JSON export, followed by its messages oldest first
+type ChatExportHeader struct {
+	ChatJID    string    `json:"chat_jid"`
+	Name       string    `json:"name,omitempty"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// Pass every stored message of a chat to fn, oldest first
+func (store *MessageStore) EachChatMessage(chatJID string, fn func(StoredMessage) error) error {
+	rows, err := store.db.Query("SELECT "+storedMessageColumns+" FROM messages WHERE chat_jid = ? ORDER BY timestamp, id", chatJID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		msg, _, err := scanStoredMessage(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Name of a chat as the chat list shows it, empty when unknown
+func (store *MessageStore) chatName(jid string) string {
+	var name string
+	store.db.QueryRow("SELECT COALESCE(name, '') FROM chats WHERE jid = ?", jid).Scan(&name)
+	return name
+}
+
+// chatExporter writes one chat's messages in one format
+type chatExporter struct {
+	account  *Account
+	media    string
+	location *time.Location
+	// Sender names already looked up
+	names map[string]string
+}
+
+// Name to show for the sender of a message: the contact name, then the push
+// name the message came with, then the number
+func (e *chatExporter) senderName(msg StoredMessage) string {
+	if msg.IsFromMe {
+		if name := e.account.Client.Store.PushName; name != "" {
+			return name
+		}
+		return "You"
+	}
+	if name, ok := e.names[msg.Sender]; ok {
+		return name
+	}
+	// History syncs stored the sender as a full JID, other paths as a bare user
+	user, _, _ := strings.Cut(msg.Sender, "@")
+	candidates := []string{user + "@" + types.DefaultUserServer, user + "@" + types.HiddenUserServer}
+	if msg.SenderJID != "" {
+		candidates = append([]string{msg.SenderJID}, candidates...)
+	}
+	name := msg.PushName
+	for _, jid := range candidates {
+		if contact, err := e.account.MessageStore.GetContact(jid); err == nil {
+			if contactName := contact.displayName(); contactName != "" && contactName != contact.Phone {
+				name = contactName
+				break
+			}
+		}
+	}
+	if name == "" {
+		name = "+" + user
+	}
+	e.names[msg.Sender] = name
+	return name
+}
+
+// Where a message's downloaded media is, empty when it wasn't downloaded
+func (e *chatExporter) mediaPath(msg StoredMessage) string {
+	if e.media == ExportMediaNone {
+		return ""
+	}
+	return msg.LocalPath
+}
+
+// A message's downloaded media, base64 encoded, with media=base64
+func (e *chatExporter) mediaBase64(msg StoredMessage) string {
+	if e.media != ExportMediaBase64 || msg.LocalPath == "" {
+		return ""
+	}
+	data, err := e.account.Media.Read(msg.LocalPath)
+	if err != nil {
+		e.account.Logger.Warnf("Failed to read %s for an export: %v", msg.LocalPath, err)
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// Text of a message, or its caption for media
+func exportText(msg StoredMessage) string {
+	if msg.Content != "" {
+		return msg.Content
+	}
+	return msg.Caption
+}
+
+// Write a JSON object holding the header fields and a messages array,
+// streamed one message at a time
+func (e *chatExporter) writeJSON(w io.Writer, chatJID string) error {
+	header, err := json.Marshal(ChatExportHeader{ChatJID: chatJID, Name: e.account.MessageStore.chatName(chatJID), ExportedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	// Reopen the header object to append the array
+	if _, err := fmt.Fprintf(w, "%s,\"messages\":[", header[:len(header)-1]); err != nil {
+		return err
+	}
+	first := true
+	err = e.account.MessageStore.EachChatMessage(chatJID, func(msg StoredMessage) error {
+		exported := ExportedMessage{StoredMessage: msg, SenderName: e.senderName(msg), MediaBase64: e.mediaBase64(msg)}
+		exported.LocalPath = e.mediaPath(msg)
+		data, err := json.Marshal(exported)
+		if err != nil {
+			return err
+		}
+		if !first {
+			w.Write([]byte(","))
+		}
+		first = false
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// Write a header row and a row per message
+func (e *chatExporter) writeCSV(w io.Writer, chatJID string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportCSVHeader); err != nil {
+		return err
+	}
+	err := e.account.MessageStore.EachChatMessage(chatJID, func(msg StoredMessage) error {
+		return cw.Write([]string{
+			msg.Timestamp.UTC().Format(time.RFC3339), msg.ID, msg.Sender, e.senderName(msg),
+			strconv.FormatBool(msg.IsFromMe), msg.MessageType, exportText(msg),
+			msg.MediaType, msg.Filename, msg.MimeType, e.mediaPath(msg), e.mediaBase64(msg),
+			msg.QuotedMessageID, strconv.FormatBool(msg.IsForwarded), strconv.FormatBool(msg.IsStarred),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Write lines like the phone's "Export chat": the date and time, the sender
+// and the text, with media as "<Media omitted>" or "name (file attached)"
+func (e *chatExporter) writeText(w io.Writer, chatJID string) error {
+	return e.account.MessageStore.EachChatMessage(chatJID, func(msg StoredMessage) error {
+		text := exportText(msg)
+		if msg.MediaType != "" {
+			attachment := "<Media omitted>"
+			if file := e.mediaPath(msg); file != "" {
+				name := msg.Filename
+				if name == "" {
+					name = path.Base(file)
+				}
+				attachment = name + " (file attached)"
+			}
+			if msg.Caption != "" {
+				attachment += "\n" + msg.Caption
+			}
+			text = attachment
+		} else if text == "" && msg.MessageType != "" {
+			text = fmt.Sprintf("<%s omitted>", msg.MessageType)
+		}
+		_, err := fmt.Fprintf(w, "%s - %s: %s\n", msg.Timestamp.In(e.location).Format(exportTextTimeLayout), e.senderName(msg), strings.ReplaceAll(text, "\r\n", "\n"))
+		return err
+	})
+}
+
+// Handle GET /api/chats/{jid}/export
+func (a *Account) HandleChatExportEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	chat, err := parseRecipient(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = ExportFormatJSON
+	}
+	if format != ExportFormatJSON && format != ExportFormatCSV && format != ExportFormatTXT {
+		http.Error(w, "format must be json, csv or txt", http.StatusBadRequest)
+		return
+	}
+	media := query.Get("media")
+	if media == "" {
+		media = ExportMediaNone
+	}
+	if media != ExportMediaNone && media != ExportMediaPath && media != ExportMediaBase64 {
+		http.Error(w, "media must be none, path or base64", http.StatusBadRequest)
+		return
+	}
+	if media == ExportMediaBase64 && format == ExportFormatTXT {
+		http.Error(w, "media=base64 needs the json or csv format", http.StatusBadRequest)
+		return
+	}
+	// The phone writes times in its own zone
+	location := time.Local
+	if tz := query.Get("tz"); tz != "" {
+		if location, err = time.LoadLocation(tz); err != nil {
+			http.Error(w, "tz must be an IANA time zone such as Europe/Madrid", http.StatusBadRequest)
+			return
+		}
+	}
+
+	exporter := &chatExporter{account: a, media: media, location: location, names: map[string]string{}}
+	filename := fmt.Sprintf("WhatsApp Chat - %s.%s", chat.User, format)
+	switch format {
+	case ExportFormatJSON:
+		w.Header().Set("Content-Type", "application/json")
+	case ExportFormatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	case ExportFormatTXT:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// Messages are streamed, so a failure partway can only be logged
+	switch format {
+	case ExportFormatJSON:
+		err = exporter.writeJSON(w, chat.String())
+	case ExportFormatCSV:
+		err = exporter.writeCSV(w, chat.String())
+	case ExportFormatTXT:
+		err = exporter.writeText(w, chat.String())
+	}
+	if err != nil {
+		a.Logger.Warnf("Failed to export %s: %v", chat, err)
+	}
+}
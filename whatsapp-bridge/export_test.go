@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExportSenderNameOfSyncedMessages(t *testing.T) {
+	store, err := NewMessageStore(&Config{}, DefaultAccountID, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.StoreContact(Contact{JID: "34600000000@s.whatsapp.net", Phone: "34600000000", FullName: "Ana"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StoreContact(Contact{JID: "81234567890123@lid", FullName: "Luis"}); err != nil {
+		t.Fatal(err)
+	}
+	group := "120363000000000000@g.us"
+	if err := store.StoreChat(group, "G", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// History sync stores the participant's JID as the sender
+	for id, sender := range map[string]string{"synced": "34600000000@s.whatsapp.net", "live": "81234567890123", "unknown": "34611111111@s.whatsapp.net"} {
+		if err := store.StoreMessage(id, group, sender, "hi", time.Now(), false, "", "", "", nil, nil, nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.StoreMessageDetails("live", group, MessageDetails{Type: "text", SenderJID: "81234567890123@lid"}); err != nil {
+		t.Fatal(err)
+	}
+
+	exporter := &chatExporter{account: &Account{MessageStore: store}, names: map[string]string{}}
+	want := map[string]string{"synced": "Ana", "live": "Luis", "unknown": "+34611111111"}
+	err = store.EachChatMessage(group, func(msg StoredMessage) error {
+		if name := exporter.senderName(msg); name != want[msg.ID] {
+			t.Errorf("sender of %s is %q, want %q", msg.ID, name, want[msg.ID])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
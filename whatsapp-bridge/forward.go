@@ -0,0 +1,249 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// ForwardRequest represents the request body for the forward message API
+type ForwardRequest struct {
+	ChatJID string   `json:"chat_jid"`
+	Targets []string `json:"targets"`
+}
+
+// ForwardResponse represents the response for the forward message API
+type ForwardResponse struct {
+	Success bool         `json:"success"`
+	Message string       `json:"message"`
+	Results []SendResult `json:"results,omitempty"`
+}
+
+// A size or duration for a message, left out when unknown
+func knownUint32(value uint32) *uint32 {
+	if value == 0 {
+		return nil
+	}
+	return proto.Uint32(value)
+}
+
+// Rebuild a stored message for forwarding. Media keeps pointing at the
+// already uploaded file, so nothing is re-uploaded, and keeps the type,
+// size and preview it arrived with.
+func (a *Account) buildForwardMessage(chat types.JID, messageID string) (*waProto.Message, error) {
+	original, err := a.MessageStore.GetMessage(messageID, chat.String())
+	if err != nil {
+		return nil, err
+	}
+	_, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength, err := a.MessageStore.GetMediaInfo(messageID, chat.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// Every hop counts, so often forwarded messages get their label
+	forwarded := &waProto.ContextInfo{
+		IsForwarded:     proto.Bool(true),
+		ForwardingScore: proto.Uint32(original.ForwardingScore + 1),
+	}
+
+	if original.MediaType == "" {
+		return &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:        proto.String(original.Content),
+			ContextInfo: forwarded,
+		}}, nil
+	}
+	if url == "" || len(mediaKey) == 0 {
+		return nil, fmt.Errorf("media of message %s is missing its download keys", messageID)
+	}
+
+	thumbnail, _ := a.MessageStore.GetThumbnail(messageID, chat.String())
+	directPath := extractDirectPathFromURL(url)
+	msg := &waProto.Message{}
+	// Messages stored before MIME types were kept fall back to the usual ones
+	switch original.MediaType {
+	case MediaKindImage:
+		msg.ImageMessage = &waProto.ImageMessage{
+			Caption:       proto.String(original.Content),
+			Mimetype:      proto.String(firstNonEmpty(original.MimeType, "image/jpeg")),
+			URL:           proto.String(url),
+			DirectPath:    proto.String(directPath),
+			MediaKey:      mediaKey,
+			FileEncSHA256: fileEncSHA256,
+			FileSHA256:    fileSHA256,
+			FileLength:    proto.Uint64(fileLength),
+			Width:         knownUint32(original.Width),
+			Height:        knownUint32(original.Height),
+			JPEGThumbnail: thumbnail,
+			ContextInfo:   forwarded,
+		}
+	case MediaKindVideo:
+		msg.VideoMessage = &waProto.VideoMessage{
+			Caption:       proto.String(original.Content),
+			Mimetype:      proto.String(firstNonEmpty(original.MimeType, "video/mp4")),
+			URL:           proto.String(url),
+			DirectPath:    proto.String(directPath),
+			MediaKey:      mediaKey,
+			FileEncSHA256: fileEncSHA256,
+			FileSHA256:    fileSHA256,
+			FileLength:    proto.Uint64(fileLength),
+			Seconds:       knownUint32(original.Seconds),
+			Width:         knownUint32(original.Width),
+			Height:        knownUint32(original.Height),
+			JPEGThumbnail: thumbnail,
+			ContextInfo:   forwarded,
+		}
+	case MediaKindAudio:
+		msg.AudioMessage = &waProto.AudioMessage{
+			Mimetype:      proto.String(firstNonEmpty(original.MimeType, "audio/ogg; codecs=opus")),
+			URL:           proto.String(url),
+			DirectPath:    proto.String(directPath),
+			MediaKey:      mediaKey,
+			FileEncSHA256: fileEncSHA256,
+			FileSHA256:    fileSHA256,
+			FileLength:    proto.Uint64(fileLength),
+			Seconds:       knownUint32(original.Seconds),
+			Waveform:      original.Waveform,
+			ContextInfo:   forwarded,
+		}
+	case MediaKindDocument:
+		mimeType := original.MimeType
+		if mimeType == "" {
+			mimeType = mime.TypeByExtension(strings.ToLower(filepath.Ext(filename)))
+		}
+		msg.DocumentMessage = &waProto.DocumentMessage{
+			Title:         proto.String(filename),
+			FileName:      proto.String(filename),
+			Caption:       proto.String(original.Content),
+			Mimetype:      proto.String(firstNonEmpty(mimeType, "application/octet-stream")),
+			URL:           proto.String(url),
+			DirectPath:    proto.String(directPath),
+			MediaKey:      mediaKey,
+			FileEncSHA256: fileEncSHA256,
+			FileSHA256:    fileSHA256,
+			FileLength:    proto.Uint64(fileLength),
+			JPEGThumbnail: thumbnail,
+			ContextInfo:   forwarded,
+		}
+	case "sticker":
+		msg.StickerMessage = &waProto.StickerMessage{
+			Mimetype:      proto.String(firstNonEmpty(original.MimeType, "image/webp")),
+			URL:           proto.String(url),
+			DirectPath:    proto.String(directPath),
+			MediaKey:      mediaKey,
+			FileEncSHA256: fileEncSHA256,
+			FileSHA256:    fileSHA256,
+			FileLength:    proto.Uint64(fileLength),
+			Width:         knownUint32(original.Width),
+			Height:        knownUint32(original.Height),
+			IsAnimated:    proto.Bool(original.IsAnimated),
+			ContextInfo:   forwarded,
+		}
+	default:
+		return nil, fmt.Errorf("messages of type %s cannot be forwarded", original.MediaType)
+	}
+	return msg, nil
+}
+
+// Handle POST /api/messages/{id}/forward
+func (a *Account) HandleForwardEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID := r.PathValue("id")
+
+	// Parse the request body
+	var req ForwardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if len(req.Targets) == 0 {
+		http.Error(w, "At least one target is required", http.StatusBadRequest)
+		return
+	}
+
+	// Validate all targets before sending anything
+	targets := make([]types.JID, 0, len(req.Targets))
+	for _, target := range req.Targets {
+		jid, err := parseRecipient(target)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid target %s: %v", target, err), http.StatusBadRequest)
+			return
+		}
+		targets = append(targets, jid)
+	}
+
+	chat, err := a.resolveMessageChat(messageID, req.ChatJID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if !a.Client.IsConnected() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(ForwardResponse{
+			Success: false,
+			Message: errNotConnected.Error(),
+		})
+		return
+	}
+
+	msg, err := a.buildForwardMessage(chat, messageID)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ForwardResponse{
+			Success: false,
+			Message: fmt.Sprintf("Message %s not found", messageID),
+		})
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ForwardResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to forward message: %v", err),
+		})
+		return
+	}
+
+	// Send to each target, reporting failures per target
+	results := make([]SendResult, 0, len(targets))
+	sent := 0
+	for _, target := range targets {
+		resp, err := a.sendMessage(target, proto.Clone(msg).(*waProto.Message))
+		if err != nil {
+			results = append(results, SendResult{Success: false, Message: err.Error(), JID: target.String()})
+			continue
+		}
+		sent++
+		timestamp := resp.Timestamp
+		results = append(results, SendResult{
+			Success:   true,
+			Message:   fmt.Sprintf("Message forwarded to %s", target),
+			ID:        resp.ID,
+			Timestamp: &timestamp,
+			JID:       target.String(),
+		})
+	}
+
+	if sent == 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(ForwardResponse{
+		Success: sent == len(targets),
+		Message: fmt.Sprintf("Forwarded to %d of %d chats", sent, len(targets)),
+		Results: results,
+	})
+}
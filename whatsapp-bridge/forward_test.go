@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestForwardKeepsStoredDetails(t *testing.T) {
+	store, err := NewMessageStore(&Config{}, DefaultAccountID, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	account := &Account{MessageStore: store}
+	chat := types.NewJID("1", types.DefaultUserServer)
+	if err := store.StoreChat(chat.String(), "A", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	received := map[string]*waProto.Message{
+		"img": {ImageMessage: &waProto.ImageMessage{
+			Mimetype: proto.String("image/png"), Width: proto.Uint32(800), Height: proto.Uint32(600),
+			URL: proto.String("https://mmg.whatsapp.net/v/t62/img"), MediaKey: []byte{1},
+			ContextInfo: &waProto.ContextInfo{IsForwarded: proto.Bool(true), ForwardingScore: proto.Uint32(4)},
+		}},
+		"stk": {StickerMessage: &waProto.StickerMessage{
+			Mimetype: proto.String("image/webp"), Width: proto.Uint32(512), Height: proto.Uint32(512), IsAnimated: proto.Bool(true),
+			URL: proto.String("https://mmg.whatsapp.net/v/t62/stk"), MediaKey: []byte{2},
+		}},
+	}
+	for id, msg := range received {
+		mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength := extractMediaInfo(msg)
+		if err := store.StoreMessage(id, chat.String(), "2", "", time.Now(), false, mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.StoreMessageDetails(id, chat.String(), extractMessageDetails(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SetThumbnail("img", chat.String(), []byte{0xff, 0xd8}); err != nil {
+		t.Fatal(err)
+	}
+
+	image, err := account.buildForwardMessage(chat, "img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := image.GetImageMessage()
+	if got.GetMimetype() != "image/png" || got.GetWidth() != 800 || got.GetHeight() != 600 || len(got.GetJPEGThumbnail()) != 2 {
+		t.Errorf("forwarded image lost its details: %v", got)
+	}
+	if score := got.GetContextInfo().GetForwardingScore(); score != 5 {
+		t.Errorf("forwarding score is %d, want 5", score)
+	}
+
+	sticker, err := account.buildForwardMessage(chat, "stk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sticker.GetStickerMessage(); got == nil || !got.GetIsAnimated() || got.GetWidth() != 512 || got.GetContextInfo().GetForwardingScore() != 1 {
+		t.Errorf("forwarded sticker is %v, want an animated 512px sticker forwarded once", sticker)
+	}
+}
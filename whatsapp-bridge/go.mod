@@ -3,23 +3,26 @@ module whatsapp-client
 go 1.24.1
 
 require (
+	github.com/gorilla/websocket v1.5.0
+	github.com/lib/pq v1.12.3
 	github.com/mattn/go-sqlite3 v1.14.24
+	github.com/mdp/qrterminal v1.0.1
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	go.mau.fi/whatsmeow v0.0.0-20250318233852-06705625cf82
+	golang.org/x/crypto v0.36.0
+	golang.org/x/net v0.37.0
+	google.golang.org/protobuf v1.36.5
 )
 
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
-	github.com/mdp/qrterminal v1.0.1 // indirect
 	github.com/rs/zerolog v1.33.0 // indirect
 	go.mau.fi/libsignal v0.1.2 // indirect
 	go.mau.fi/util v0.8.6 // indirect
-	golang.org/x/crypto v0.36.0 // indirect
-	golang.org/x/net v0.37.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
-	google.golang.org/protobuf v1.36.5 // indirect
+	golang.org/x/text v0.23.0 // indirect
 	rsc.io/qr v0.2.0 // indirect
 )
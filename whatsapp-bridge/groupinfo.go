@@ -0,0 +1,514 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// How long cached group metadata is served before it is fetched again
+const groupCacheTTL = 5 * time.Minute
+
+// Longest group description WhatsApp accepts, in characters
+const maxGroupTopicLength = 2048
+
+// GroupMember is a participant of a group
+type GroupMember struct {
+	JID          string `json:"jid"`
+	Phone        string `json:"phone,omitempty"`
+	IsAdmin      bool   `json:"is_admin"`
+	IsSuperAdmin bool   `json:"is_super_admin"`
+}
+
+// GroupMetadata is what the bridge knows about a group
+type GroupMetadata struct {
+	JID       string     `json:"jid"`
+	Name      string     `json:"name"`
+	Topic     string     `json:"topic,omitempty"`
+	OwnerJID  string     `json:"owner_jid,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	// Only admins can send messages
+	Announce bool `json:"announce"`
+	// Only admins can edit the group info
+	Locked bool `json:"locked"`
+	// Seconds until messages disappear; 0 when off
+	DisappearingTimer    uint32 `json:"disappearing_timer"`
+	JoinApprovalRequired bool   `json:"join_approval_required"`
+	// admin_add or all_member_add
+	MemberAddMode   string `json:"member_add_mode,omitempty"`
+	IsCommunity     bool   `json:"is_community"`
+	LinkedParentJID string `json:"linked_parent_jid,omitempty"`
+	// Whether this is the announcement group of a community
+	IsDefaultSubGroup bool `json:"is_default_sub_group,omitempty"`
+	ParticipantCount  int  `json:"participant_count"`
+	IsAdmin           bool `json:"is_admin"`
+	// Left out of group listings
+	Participants []GroupMember `json:"participants,omitempty"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+	// Set when WhatsApp couldn't be reached and stale metadata is returned
+	Cached bool `json:"cached"`
+}
+
+// UpdateGroupRequest represents the request body for the group update API.
+// Fields left out are not changed.
+type UpdateGroupRequest struct {
+	Name  *string `json:"name"`
+	Topic *string `json:"topic"`
+	// Seconds until messages disappear: 0, 86400, 604800 or 7776000
+	DisappearingTimer *uint32 `json:"disappearing_timer"`
+	Announce          *bool   `json:"announce"`
+	Locked            *bool   `json:"locked"`
+}
+
+// Disappearing message timers the WhatsApp apps offer
+var disappearingTimers = map[uint32]time.Duration{
+	0: whatsmeow.DisappearingTimerOff,
+	uint32(whatsmeow.DisappearingTimer24Hours.Seconds()): whatsmeow.DisappearingTimer24Hours,
+	uint32(whatsmeow.DisappearingTimer7Days.Seconds()):   whatsmeow.DisappearingTimer7Days,
+	uint32(whatsmeow.DisappearingTimer90Days.Seconds()):  whatsmeow.DisappearingTimer90Days,
+}
+
+// Convert a disappearing timer in seconds, rejecting ones the apps would ignore
+func parseDisappearingTimer(seconds uint32) (time.Duration, error) {
+	timer, ok := disappearingTimers[seconds]
+	if !ok {
+		return 0, fmt.Errorf("disappearing_timer must be 0 (off), 86400 (24 hours), 604800 (7 days) or 7776000 (90 days)")
+	}
+	return timer, nil
+}
+
+// Build the metadata of a group from whatsmeow's group info
+func groupMetadata(info *types.GroupInfo, own types.JID) *GroupMetadata {
+	group := &GroupMetadata{
+		JID:                  info.JID.String(),
+		Name:                 info.Name,
+		Topic:                info.Topic,
+		Announce:             info.IsAnnounce,
+		Locked:               info.IsLocked,
+		JoinApprovalRequired: info.IsJoinApprovalRequired,
+		MemberAddMode:        string(info.MemberAddMode),
+		IsCommunity:          info.IsParent,
+		IsDefaultSubGroup:    info.IsDefaultSubGroup,
+		ParticipantCount:     len(info.Participants),
+		Participants:         make([]GroupMember, 0, len(info.Participants)),
+		UpdatedAt:            time.Now().UTC(),
+	}
+	if info.IsEphemeral {
+		group.DisappearingTimer = info.DisappearingTimer
+	}
+	if !info.OwnerJID.IsEmpty() {
+		group.OwnerJID = info.OwnerJID.ToNonAD().String()
+	}
+	if !info.GroupCreated.IsZero() {
+		created := info.GroupCreated
+		group.CreatedAt = &created
+	}
+	if !info.LinkedParentJID.IsEmpty() {
+		group.LinkedParentJID = info.LinkedParentJID.String()
+	}
+	for _, participant := range info.Participants {
+		member := GroupMember{
+			JID:          participant.JID.ToNonAD().String(),
+			IsAdmin:      participant.IsAdmin || participant.IsSuperAdmin,
+			IsSuperAdmin: participant.IsSuperAdmin,
+		}
+		if participant.JID.Server == types.DefaultUserServer {
+			member.Phone = participant.JID.User
+		}
+		if participant.JID.User == own.User && member.IsAdmin {
+			group.IsAdmin = true
+		}
+		group.Participants = append(group.Participants, member)
+	}
+	return group
+}
+
+// GroupsResponse represents the response for the joined groups API
+type GroupsResponse struct {
+	Groups []*GroupMetadata `json:"groups"`
+	Total  int              `json:"total"`
+	// Set when WhatsApp couldn't be reached and the cached list is returned
+	Cached bool `json:"cached"`
+}
+
+// Either the database or a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Cache the metadata of a group
+func (store *MessageStore) StoreGroup(group *GroupMetadata) error {
+	return storeGroup(store.db, group)
+}
+
+// Replace the cached groups with the full list of joined groups, dropping
+// groups we are no longer in
+func (store *MessageStore) StoreGroups(groups []*GroupMetadata) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM groups"); err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if err := storeGroup(tx, group); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Write a group and its chat list name
+func storeGroup(db execer, group *GroupMetadata) error {
+	participants, err := json.Marshal(group.Participants)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`INSERT OR REPLACE INTO groups (jid, name, topic, owner_jid, created_at, announce, locked, disappearing_timer,
+			join_approval_required, member_add_mode, is_community, linked_parent_jid, is_default_sub_group,
+			participant_count, is_admin, participants, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		group.JID, group.Name, group.Topic, group.OwnerJID, group.CreatedAt, group.Announce, group.Locked, group.DisappearingTimer,
+		group.JoinApprovalRequired, group.MemberAddMode, group.IsCommunity, group.LinkedParentJID, group.IsDefaultSubGroup,
+		group.ParticipantCount, group.IsAdmin, string(participants), group.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	// Keep the chat list name and disappearing timer in step with the group
+	_, err = db.Exec(
+		`INSERT INTO chats (jid, name, disappearing_timer) VALUES (?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET name = excluded.name, disappearing_timer = excluded.disappearing_timer`,
+		group.JID, group.Name, group.DisappearingTimer,
+	)
+	return err
+}
+
+// Columns read into a GroupMetadata by scanGroup
+const groupColumns = `jid, COALESCE(name, ''), COALESCE(topic, ''), COALESCE(owner_jid, ''), created_at, COALESCE(announce, 0),
+	COALESCE(locked, 0), COALESCE(disappearing_timer, 0), COALESCE(join_approval_required, 0), COALESCE(member_add_mode, ''),
+	COALESCE(is_community, 0), COALESCE(linked_parent_jid, ''), COALESCE(is_default_sub_group, 0), COALESCE(participant_count, 0),
+	COALESCE(is_admin, 0), COALESCE(participants, '[]'), updated_at`
+
+// Scan a row selected with groupColumns
+func scanGroup(row interface{ Scan(...interface{}) error }) (*GroupMetadata, error) {
+	var group GroupMetadata
+	var createdAt sql.NullTime
+	var participants string
+	err := row.Scan(&group.JID, &group.Name, &group.Topic, &group.OwnerJID, &createdAt, &group.Announce,
+		&group.Locked, &group.DisappearingTimer, &group.JoinApprovalRequired, &group.MemberAddMode,
+		&group.IsCommunity, &group.LinkedParentJID, &group.IsDefaultSubGroup, &group.ParticipantCount,
+		&group.IsAdmin, &participants, &group.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if createdAt.Valid {
+		group.CreatedAt = &createdAt.Time
+	}
+	if err := json.Unmarshal([]byte(participants), &group.Participants); err != nil {
+		return nil, fmt.Errorf("invalid cached participants: %v", err)
+	}
+	return &group, nil
+}
+
+// Get the cached metadata of a group
+func (store *MessageStore) GetGroup(jid string) (*GroupMetadata, error) {
+	return scanGroup(store.db.QueryRow("SELECT "+groupColumns+" FROM groups WHERE jid = ?", jid))
+}
+
+// List the cached groups by name
+func (store *MessageStore) ListGroups() ([]*GroupMetadata, error) {
+	rows, err := store.db.Query("SELECT " + groupColumns + " FROM groups ORDER BY LOWER(name), jid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []*GroupMetadata{}
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// Fetch all joined groups from WhatsApp and replace the cache with them
+func (a *Account) refreshGroups() ([]*GroupMetadata, error) {
+	infos, err := a.Client.GetJoinedGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get joined groups: %w", err)
+	}
+	var own types.JID
+	if a.Client.Store.ID != nil {
+		own = *a.Client.Store.ID
+	}
+	groups := make([]*GroupMetadata, len(infos))
+	for i, info := range infos {
+		groups[i] = groupMetadata(info, own)
+	}
+	if err := a.MessageStore.StoreGroups(groups); err != nil {
+		a.Logger.Warnf("Failed to cache joined groups: %v", err)
+	}
+	return groups, nil
+}
+
+// Fetch a group's metadata from WhatsApp and cache it
+func (a *Account) refreshGroup(jid types.JID) (*GroupMetadata, error) {
+	info, err := a.Client.GetGroupInfo(jid)
+	if err != nil {
+		return nil, err
+	}
+	return a.cacheGroup(info), nil
+}
+
+// Cache group info received from WhatsApp
+func (a *Account) cacheGroup(info *types.GroupInfo) *GroupMetadata {
+	var own types.JID
+	if a.Client.Store.ID != nil {
+		own = *a.Client.Store.ID
+	}
+	group := groupMetadata(info, own)
+	if err := a.MessageStore.StoreGroup(group); err != nil {
+		a.Logger.Warnf("Failed to cache group %s: %v", info.JID, err)
+	}
+	return group
+}
+
+// Get a group's metadata, from the cache while it is fresh. When WhatsApp
+// can't be reached stale metadata is returned and marked as cached.
+func (a *Account) group(jid types.JID, refresh bool) (*GroupMetadata, error) {
+	cached, err := a.MessageStore.GetGroup(jid.String())
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if cached != nil && !refresh && time.Since(cached.UpdatedAt) < groupCacheTTL {
+		return cached, nil
+	}
+	if !a.Client.IsConnected() {
+		if cached != nil {
+			cached.Cached = true
+			return cached, nil
+		}
+		return nil, errNotConnected
+	}
+	return a.refreshGroup(jid)
+}
+
+// Forget the cached metadata of a group
+func (store *MessageStore) DeleteGroup(jid string) error {
+	_, err := store.db.Exec("DELETE FROM groups WHERE jid = ?", jid)
+	return err
+}
+
+// Refresh the cache after a group changed. Group info events only carry the
+// change, so the full metadata is fetched again; groups we can no longer see
+// are dropped from the cache.
+func (a *Account) refreshCachedGroup(jid types.JID) {
+	_, err := a.refreshGroup(jid)
+	if errors.Is(err, whatsmeow.ErrNotInGroup) || errors.Is(err, whatsmeow.ErrGroupNotFound) {
+		err = a.MessageStore.DeleteGroup(jid.String())
+	}
+	if err != nil {
+		a.Logger.Warnf("Failed to refresh group %s: %v", jid, err)
+	}
+}
+
+// Apply metadata changes to a group, stopping at the first that fails
+func (a *Account) updateGroup(jid types.JID, req *UpdateGroupRequest) error {
+	if req.Name != nil {
+		if err := a.Client.SetGroupName(jid, *req.Name); err != nil {
+			return fmt.Errorf("failed to set name: %w", err)
+		}
+	}
+	if req.Topic != nil {
+		if err := a.Client.SetGroupTopic(jid, "", "", *req.Topic); err != nil {
+			return fmt.Errorf("failed to set topic: %w", err)
+		}
+	}
+	if req.DisappearingTimer != nil {
+		timer, _ := parseDisappearingTimer(*req.DisappearingTimer)
+		if err := a.Client.SetDisappearingTimer(jid, timer); err != nil {
+			return fmt.Errorf("failed to set disappearing timer: %w", err)
+		}
+	}
+	if req.Announce != nil {
+		if err := a.Client.SetGroupAnnounce(jid, *req.Announce); err != nil {
+			return fmt.Errorf("failed to set announce mode: %w", err)
+		}
+	}
+	if req.Locked != nil {
+		if err := a.Client.SetGroupLocked(jid, *req.Locked); err != nil {
+			return fmt.Errorf("failed to set locked mode: %w", err)
+		}
+	}
+	return nil
+}
+
+// Validate a group update before sending any of it
+func (req *UpdateGroupRequest) validate() error {
+	if req.Name == nil && req.Topic == nil && req.DisappearingTimer == nil && req.Announce == nil && req.Locked == nil {
+		return fmt.Errorf("nothing to update")
+	}
+	if req.Name != nil {
+		*req.Name = strings.TrimSpace(*req.Name)
+		if *req.Name == "" || len([]rune(*req.Name)) > maxGroupNameLength {
+			return fmt.Errorf("name must be 1-%d characters", maxGroupNameLength)
+		}
+	}
+	if req.Topic != nil && len([]rune(*req.Topic)) > maxGroupTopicLength {
+		return fmt.Errorf("topic must be at most %d characters", maxGroupTopicLength)
+	}
+	if req.DisappearingTimer != nil {
+		if _, err := parseDisappearingTimer(*req.DisappearingTimer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Status code for a failed group request
+func groupErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errNotConnected):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, whatsmeow.ErrGroupNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, whatsmeow.ErrNotInGroup), errors.Is(err, whatsmeow.ErrIQForbidden),
+		errors.Is(err, whatsmeow.ErrGroupInviteLinkUnauthorized):
+		return http.StatusForbidden
+	case errors.Is(err, whatsmeow.ErrInviteLinkInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, whatsmeow.ErrInviteLinkRevoked):
+		return http.StatusGone
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Get all joined groups without their participants, falling back to the
+// cache when WhatsApp can't be reached
+func (a *Account) joinedGroups() (groups []*GroupMetadata, cached bool, err error) {
+	cached = true
+	if a.Client.IsConnected() {
+		if groups, err = a.refreshGroups(); err != nil {
+			a.Logger.Warnf("Serving cached groups: %v", err)
+		} else {
+			cached = false
+		}
+	}
+	if cached {
+		if groups, err = a.MessageStore.ListGroups(); err != nil {
+			return nil, true, fmt.Errorf("failed to load groups: %v", err)
+		}
+	}
+
+	for _, group := range groups {
+		group.Participants = nil
+		group.Cached = cached
+	}
+	return groups, cached, nil
+}
+
+// Handle GET /api/groups
+func (a *Account) listGroups(w http.ResponseWriter) {
+	groups, cached, err := a.joinedGroups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GroupsResponse{Groups: groups, Total: len(groups), Cached: cached})
+}
+
+// Handle POST /api/groups/{jid}/leave
+func (a *Account) HandleLeaveGroupEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	group, err := parseGroupJID(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !a.Client.IsConnected() {
+		writeGroupResponse(w, http.StatusServiceUnavailable, GroupResponse{Message: errNotConnected.Error(), JID: group.String()})
+		return
+	}
+
+	if err := a.Client.LeaveGroup(group); err != nil {
+		writeGroupResponse(w, groupErrorStatus(err), GroupResponse{
+			Message: fmt.Sprintf("Failed to leave group: %v", err),
+			JID:     group.String(),
+		})
+		return
+	}
+	if err := a.MessageStore.DeleteGroup(group.String()); err != nil {
+		a.Logger.Warnf("Failed to remove %s from the group cache: %v", group, err)
+	}
+
+	writeGroupResponse(w, http.StatusOK, GroupResponse{Success: true, Message: fmt.Sprintf("Left group %s", group), JID: group.String()})
+}
+
+// Handle GET and PATCH /api/groups/{jid}
+func (a *Account) HandleGroupEndpoint(w http.ResponseWriter, r *http.Request) {
+	jid, err := parseGroupJID(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh"))
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var req UpdateGroupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !a.Client.IsConnected() {
+			http.Error(w, errNotConnected.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err := a.updateGroup(jid, &req); err != nil {
+			http.Error(w, err.Error(), groupErrorStatus(err))
+			return
+		}
+		refresh = true
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	group, err := a.group(jid, refresh)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get group: %v", err), groupErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
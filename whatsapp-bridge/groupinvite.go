@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// JoinGroupRequest represents the request body for the group join API
+type JoinGroupRequest struct {
+	// An invite link or just its code
+	Link string `json:"link"`
+}
+
+// GroupInviteResponse represents the response for the group invite APIs
+type GroupInviteResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	JID     string `json:"jid,omitempty"`
+	Link    string `json:"link,omitempty"`
+	Code    string `json:"code,omitempty"`
+	// The group an invite leads to, or the group that was joined
+	Group *GroupMetadata `json:"group,omitempty"`
+}
+
+// Extract the code from an invite link, accepting the bare code as well
+func inviteCode(link string) (string, error) {
+	code := strings.TrimSpace(link)
+	code = strings.TrimPrefix(code, "http://")
+	code = strings.TrimPrefix(code, "https://")
+	code = strings.TrimPrefix(code, "chat.whatsapp.com/")
+	code = strings.TrimPrefix(code, "invite/")
+	code = strings.TrimRight(code, "/")
+	if code == "" || strings.ContainsAny(code, "/?# ") {
+		return "", fmt.Errorf("invalid invite link: %s", link)
+	}
+	return code, nil
+}
+
+// Write a group invite API response
+func writeGroupInviteResponse(w http.ResponseWriter, status int, response GroupInviteResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handle GET /api/groups/{jid}/invite-link and POST /api/groups/{jid}/invite-link/revoke.
+// Revoking invalidates the current link and returns its replacement.
+func (a *Account) HandleGroupInviteLinkEndpoint(w http.ResponseWriter, r *http.Request, reset bool) {
+	// Reading the link is a GET, replacing it a POST
+	if (!reset && r.Method != http.MethodGet) || (reset && r.Method != http.MethodPost) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	group, err := parseGroupJID(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !a.Client.IsConnected() {
+		writeGroupInviteResponse(w, http.StatusServiceUnavailable, GroupInviteResponse{Message: errNotConnected.Error(), JID: group.String()})
+		return
+	}
+
+	link, err := a.Client.GetGroupInviteLink(group, reset)
+	if err != nil {
+		writeGroupInviteResponse(w, groupErrorStatus(err), GroupInviteResponse{
+			Message: fmt.Sprintf("Failed to get invite link: %v", err),
+			JID:     group.String(),
+		})
+		return
+	}
+
+	message := "Invite link"
+	if reset {
+		message = "Invite link revoked and replaced"
+	}
+	writeGroupInviteResponse(w, http.StatusOK, GroupInviteResponse{
+		Success: true,
+		Message: message,
+		JID:     group.String(),
+		Link:    link,
+		Code:    strings.TrimPrefix(link, whatsmeow.InviteLinkPrefix),
+	})
+}
+
+// Handle GET and POST /api/groups/join. GET resolves an invite link to the
+// group it leads to without joining; POST joins the group.
+func (a *Account) HandleJoinGroupEndpoint(w http.ResponseWriter, r *http.Request) {
+	var link string
+	switch r.Method {
+	case http.MethodGet:
+		link = r.URL.Query().Get("link")
+	case http.MethodPost:
+		var req JoinGroupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		link = req.Link
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	code, err := inviteCode(link)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response := GroupInviteResponse{Link: whatsmeow.InviteLinkPrefix + code, Code: code}
+
+	if !a.Client.IsConnected() {
+		response.Message = errNotConnected.Error()
+		writeGroupInviteResponse(w, http.StatusServiceUnavailable, response)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		info, err := a.Client.GetGroupInfoFromLink(code)
+		if err != nil {
+			response.Message = fmt.Sprintf("Failed to resolve invite link: %v", err)
+			writeGroupInviteResponse(w, groupErrorStatus(err), response)
+			return
+		}
+		var own types.JID
+		if a.Client.Store.ID != nil {
+			own = *a.Client.Store.ID
+		}
+		response.Success = true
+		response.Message = fmt.Sprintf("Invite to %s", info.Name)
+		response.JID = info.JID.String()
+		response.Group = groupMetadata(info, own)
+		writeGroupInviteResponse(w, http.StatusOK, response)
+		return
+	}
+
+	jid, err := a.Client.JoinGroupWithLink(code)
+	if err != nil {
+		response.Message = fmt.Sprintf("Failed to join group: %v", err)
+		writeGroupInviteResponse(w, groupErrorStatus(err), response)
+		return
+	}
+	response.Success = true
+	response.JID = jid.String()
+	response.Message = fmt.Sprintf("Joined %s", jid)
+
+	// Groups with membership approval only queue a join request, so we may
+	// not be able to see the group yet
+	group, err := a.refreshGroup(jid)
+	switch {
+	case err == nil:
+		response.Group = group
+		response.Message = fmt.Sprintf("Joined %s", group.Name)
+	case errors.Is(err, whatsmeow.ErrNotInGroup):
+		response.Message = fmt.Sprintf("Requested to join %s, an admin has to approve the request", jid)
+	default:
+		a.Logger.Warnf("Failed to fetch joined group %s: %v", jid, err)
+	}
+	writeGroupInviteResponse(w, http.StatusOK, response)
+}
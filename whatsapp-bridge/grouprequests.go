@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Sent to webhooks and event streams when someone asks to join a group we
+// administer, withdraws the request, or a request is approved or rejected
+const WebhookEventGroupJoinRequest = "group_join_request"
+
+// Group change notifications whatsmeow doesn't parse, carrying join requests
+const (
+	groupChangeJoinRequested = "created_membership_requests"
+	groupChangeJoinRevoked   = "revoked_membership_requests"
+)
+
+// JoinRequest is a pending request to join a group
+type JoinRequest struct {
+	JID         string    `json:"jid"`
+	Phone       string    `json:"phone,omitempty"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// JoinRequestsResponse represents the response for the pending join requests API
+type JoinRequestsResponse struct {
+	Success  bool          `json:"success"`
+	Message  string        `json:"message,omitempty"`
+	JID      string        `json:"jid"`
+	Requests []JoinRequest `json:"requests"`
+}
+
+// UpdateJoinRequestsRequest represents the request body for approving or rejecting join requests
+type UpdateJoinRequestsRequest struct {
+	// approve or reject
+	Action       string   `json:"action"`
+	Participants []string `json:"participants"`
+	// Act on every pending request instead of the listed participants
+	All bool `json:"all"`
+}
+
+// Forward join requests from group change notifications
+func (a *Account) handleJoinRequestChanges(evt *events.GroupInfo) {
+	for _, change := range evt.UnknownChanges {
+		var action string
+		switch change.Tag {
+		case groupChangeJoinRequested:
+			action = "requested"
+		case groupChangeJoinRevoked:
+			action = "revoked"
+		default:
+			continue
+		}
+
+		var participants []types.JID
+		for _, child := range change.GetChildren() {
+			if jid := child.AttrGetter().OptionalJIDOrEmpty("jid"); !jid.IsEmpty() {
+				participants = append(participants, jid)
+			}
+		}
+		if len(participants) == 0 && evt.Sender != nil {
+			participants = append(participants, *evt.Sender)
+		}
+
+		data := map[string]interface{}{
+			"chat_jid":     evt.JID.String(),
+			"action":       action,
+			"participants": jidStrings(participants),
+			"timestamp":    evt.Timestamp,
+		}
+		if method, ok := change.Attrs["request_method"].(string); ok {
+			data["request_method"] = method
+		}
+		a.Notifier.Notify(a.ID, WebhookEventGroupJoinRequest, data)
+	}
+}
+
+// Handle GET and POST /api/groups/{jid}/requests. GET lists pending join
+// requests; POST approves or rejects them.
+func (a *Account) HandleGroupRequestsEndpoint(w http.ResponseWriter, r *http.Request) {
+	group, err := parseGroupJID(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		a.listJoinRequests(w, group)
+	case http.MethodPost:
+		a.updateJoinRequests(w, r, group)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Write the pending join requests of a group
+func (a *Account) listJoinRequests(w http.ResponseWriter, group types.JID) {
+	w.Header().Set("Content-Type", "application/json")
+	if !a.Client.IsConnected() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(JoinRequestsResponse{Message: errNotConnected.Error(), JID: group.String(), Requests: []JoinRequest{}})
+		return
+	}
+
+	pending, err := a.Client.GetGroupRequestParticipants(group)
+	if err != nil {
+		w.WriteHeader(groupErrorStatus(err))
+		json.NewEncoder(w).Encode(JoinRequestsResponse{
+			Message:  fmt.Sprintf("Failed to get join requests: %v", err),
+			JID:      group.String(),
+			Requests: []JoinRequest{},
+		})
+		return
+	}
+
+	requests := make([]JoinRequest, len(pending))
+	for i, request := range pending {
+		requests[i] = JoinRequest{JID: request.JID.ToNonAD().String(), RequestedAt: request.RequestedAt}
+		if request.JID.Server == types.DefaultUserServer {
+			requests[i].Phone = request.JID.User
+		}
+	}
+	json.NewEncoder(w).Encode(JoinRequestsResponse{
+		Success:  true,
+		Message:  fmt.Sprintf("%d pending join requests", len(requests)),
+		JID:      group.String(),
+		Requests: requests,
+	})
+}
+
+// Approve or reject join requests of a group
+func (a *Account) updateJoinRequests(w http.ResponseWriter, r *http.Request, group types.JID) {
+	// Parse the request body
+	var req UpdateJoinRequestsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	action := whatsmeow.ParticipantRequestChange(strings.ToLower(req.Action))
+	if action != whatsmeow.ParticipantChangeApprove && action != whatsmeow.ParticipantChangeReject {
+		http.Error(w, "action must be approve or reject", http.StatusBadRequest)
+		return
+	}
+	participants, err := parseParticipants(req.Participants)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(participants) == 0 && !req.All {
+		http.Error(w, "participants is required unless all is set", http.StatusBadRequest)
+		return
+	}
+
+	if !a.Client.IsConnected() {
+		writeGroupResponse(w, http.StatusServiceUnavailable, GroupResponse{Message: errNotConnected.Error(), JID: group.String()})
+		return
+	}
+
+	if req.All {
+		pending, err := a.Client.GetGroupRequestParticipants(group)
+		if err != nil {
+			writeGroupResponse(w, groupErrorStatus(err), GroupResponse{
+				Message: fmt.Sprintf("Failed to get join requests: %v", err),
+				JID:     group.String(),
+			})
+			return
+		}
+		participants = participants[:0]
+		for _, request := range pending {
+			participants = append(participants, request.JID.ToNonAD())
+		}
+		if len(participants) == 0 {
+			writeGroupResponse(w, http.StatusOK, GroupResponse{Success: true, Message: "No pending join requests", JID: group.String()})
+			return
+		}
+	}
+
+	changed, err := a.Client.UpdateGroupRequestParticipants(group, participants, action)
+	if err != nil {
+		writeGroupResponse(w, groupErrorStatus(err), GroupResponse{
+			Message: fmt.Sprintf("Failed to %s join requests: %v", action, err),
+			JID:     group.String(),
+		})
+		return
+	}
+	results := participantResults(participants, changed)
+
+	verb := "approved"
+	if action == whatsmeow.ParticipantChangeReject {
+		verb = "rejected"
+	}
+	a.Notifier.Notify(a.ID, WebhookEventGroupJoinRequest, map[string]interface{}{
+		"chat_jid":     group.String(),
+		"action":       verb,
+		"participants": results,
+	})
+
+	writeGroupResponse(w, http.StatusOK, GroupResponse{
+		Success:      true,
+		Message:      participantSummary(strings.ToUpper(verb[:1])+verb[1:], results),
+		JID:          group.String(),
+		Participants: results,
+	})
+}
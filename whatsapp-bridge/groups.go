@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Group names longer than this are rejected by WhatsApp
+const maxGroupNameLength = 25
+
+// Sent to webhooks and event streams when participants are changed through the API
+const WebhookEventGroupParticipants = "group_participants"
+
+// CreateGroupRequest represents the request body for the group creation API
+type CreateGroupRequest struct {
+	Name string `json:"name"`
+	// Phone numbers or JIDs to add; we are added implicitly
+	Participants []string `json:"participants"`
+}
+
+// UpdateParticipantsRequest represents the request body for the group participants API
+type UpdateParticipantsRequest struct {
+	// add, remove, promote or demote
+	Action       string   `json:"action"`
+	Participants []string `json:"participants"`
+	// Send an invite message to participants who can only join by invite
+	SendInvites bool `json:"send_invites"`
+}
+
+// GroupParticipantResult is the outcome of adding, removing or changing one participant
+type GroupParticipantResult struct {
+	JID     string `json:"jid"`
+	Success bool   `json:"success"`
+	// WhatsApp's error code, e.g. 403 when privacy settings require an invite
+	ErrorCode int    `json:"error_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Set when the participant can't be added directly and has to be invited
+	InviteRequired   bool       `json:"invite_required,omitempty"`
+	InviteCode       string     `json:"invite_code,omitempty"`
+	InviteExpiration *time.Time `json:"invite_expiration,omitempty"`
+	// The group's invite link, to share with participants who need an invite
+	InviteLink string `json:"invite_link,omitempty"`
+	InviteSent bool   `json:"invite_sent,omitempty"`
+}
+
+// GroupResponse represents the response for the group APIs that change membership
+type GroupResponse struct {
+	Success      bool                     `json:"success"`
+	Message      string                   `json:"message"`
+	JID          string                   `json:"jid,omitempty"`
+	Name         string                   `json:"name,omitempty"`
+	Participants []GroupParticipantResult `json:"participants,omitempty"`
+}
+
+// Describe a participant error code from WhatsApp
+func participantErrorText(code int) string {
+	switch code {
+	case 403:
+		return "privacy settings only allow adding them with an invite"
+	case 404:
+		return "not on WhatsApp"
+	case 408:
+		return "left the group recently and can't be added back yet"
+	case 409:
+		return "already a participant"
+	case 500:
+		return "the group is full"
+	default:
+		return fmt.Sprintf("failed with code %d", code)
+	}
+}
+
+// Parse participant phone numbers or JIDs
+func parseParticipants(values []string) ([]types.JID, error) {
+	jids := make([]types.JID, 0, len(values))
+	seen := make(map[types.JID]bool)
+	for _, value := range values {
+		jid, err := parseRecipient(value)
+		if err != nil {
+			return nil, err
+		}
+		jid = jid.ToNonAD()
+		if jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer {
+			return nil, fmt.Errorf("participant %s is not a user", value)
+		}
+		if !seen[jid] {
+			seen[jid] = true
+			jids = append(jids, jid)
+		}
+	}
+	return jids, nil
+}
+
+// Match the participants WhatsApp reports back to the ones requested. The
+// server may answer with phone number or LID JIDs, so compare both.
+func participantResults(requested []types.JID, participants []types.GroupParticipant) []GroupParticipantResult {
+	byJID := make(map[types.JID]types.GroupParticipant)
+	for _, participant := range participants {
+		byJID[participant.JID.ToNonAD()] = participant
+		if !participant.LID.IsEmpty() {
+			byJID[participant.LID.ToNonAD()] = participant
+		}
+	}
+
+	results := make([]GroupParticipantResult, len(requested))
+	for i, jid := range requested {
+		result := GroupParticipantResult{JID: jid.String()}
+		participant, ok := byJID[jid]
+		switch {
+		case !ok:
+			result.Error = "not in the server response"
+		case participant.Error == 0:
+			result.Success = true
+		default:
+			result.ErrorCode = participant.Error
+			result.Error = participantErrorText(participant.Error)
+			if participant.AddRequest != nil {
+				result.InviteRequired = true
+				result.InviteCode = participant.AddRequest.Code
+				if !participant.AddRequest.Expiration.IsZero() {
+					expiration := participant.AddRequest.Expiration
+					result.InviteExpiration = &expiration
+				}
+			}
+		}
+		results[i] = result
+	}
+	return results
+}
+
+// Summarize participant results for a response message
+func participantSummary(verb string, results []GroupParticipantResult) string {
+	var failed, invites int
+	for _, result := range results {
+		if !result.Success {
+			failed++
+		}
+		if result.InviteRequired {
+			invites++
+		}
+	}
+	summary := fmt.Sprintf("%s %d of %d participants", verb, len(results)-failed, len(results))
+	if invites > 0 {
+		summary += fmt.Sprintf(", %d need an invite", invites)
+	}
+	return summary
+}
+
+// Write a group API response
+func writeGroupResponse(w http.ResponseWriter, status int, response GroupResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handle GET and POST /api/groups. GET lists joined groups; POST creates one.
+func (a *Account) HandleGroupsEndpoint(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.listGroups(w)
+	case http.MethodPost:
+		a.createGroup(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Create a group
+func (a *Account) createGroup(w http.ResponseWriter, r *http.Request) {
+	// Parse the request body
+	var req CreateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len([]rune(req.Name)) > maxGroupNameLength {
+		http.Error(w, fmt.Sprintf("name must be 1-%d characters", maxGroupNameLength), http.StatusBadRequest)
+		return
+	}
+	participants, err := parseParticipants(req.Participants)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !a.Client.IsConnected() {
+		writeGroupResponse(w, http.StatusServiceUnavailable, GroupResponse{Message: errNotConnected.Error()})
+		return
+	}
+
+	info, err := a.Client.CreateGroup(whatsmeow.ReqCreateGroup{Name: req.Name, Participants: participants})
+	if err != nil {
+		writeGroupResponse(w, http.StatusInternalServerError, GroupResponse{Message: fmt.Sprintf("Failed to create group: %v", err)})
+		return
+	}
+
+	a.cacheGroup(info)
+	results := participantResults(participants, info.Participants)
+	writeGroupResponse(w, http.StatusCreated, GroupResponse{
+		Success:      true,
+		Message:      fmt.Sprintf("Created group %s, %s", info.Name, participantSummary("added", results)),
+		JID:          info.JID.String(),
+		Name:         info.Name,
+		Participants: results,
+	})
+}
+
+// Parse a group JID from a request path
+func parseGroupJID(value string) (types.JID, error) {
+	jid, err := types.ParseJID(strings.TrimSpace(value))
+	if err != nil || jid.Server != types.GroupServer || jid.User == "" {
+		return types.JID{}, fmt.Errorf("invalid group JID: %s", value)
+	}
+	return jid, nil
+}
+
+// Offer an invite to participants who can't be added directly: the group's
+// invite link for sharing, and optionally the invite message WhatsApp clients
+// send, which works even without a link
+func (a *Account) offerInvites(group types.JID, results []GroupParticipantResult, send bool) {
+	var link, name string
+	var fetched bool
+	for i := range results {
+		result := &results[i]
+		if !result.InviteRequired {
+			continue
+		}
+		if !fetched {
+			fetched = true
+			// Only admins can read the link, so failing here is not fatal
+			if groupLink, err := a.Client.GetGroupInviteLink(group, false); err == nil {
+				link = groupLink
+			} else {
+				a.Logger.Warnf("Failed to get invite link of %s: %v", group, err)
+			}
+			if info, err := a.Client.GetGroupInfo(group); err == nil {
+				name = info.Name
+			}
+		}
+		result.InviteLink = link
+		if !send || result.InviteCode == "" {
+			continue
+		}
+
+		invite := &waProto.GroupInviteMessage{
+			GroupJID:   proto.String(group.String()),
+			InviteCode: proto.String(result.InviteCode),
+			GroupName:  proto.String(name),
+			Caption:    proto.String("Invitation to join my WhatsApp group"),
+		}
+		if result.InviteExpiration != nil {
+			invite.InviteExpiration = proto.Int64(result.InviteExpiration.Unix())
+		}
+		to, err := types.ParseJID(result.JID)
+		if err != nil {
+			continue
+		}
+		if _, err := a.sendMessage(to, &waProto.Message{GroupInviteMessage: invite}); err != nil {
+			a.Logger.Warnf("Failed to send group invite to %s: %v", to, err)
+			continue
+		}
+		result.InviteSent = true
+	}
+}
+
+// Handle POST /api/groups/{jid}/participants
+func (a *Account) HandleGroupParticipantsEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	group, err := parseGroupJID(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse the request body
+	var req UpdateParticipantsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	action := whatsmeow.ParticipantChange(strings.ToLower(req.Action))
+	switch action {
+	case whatsmeow.ParticipantChangeAdd, whatsmeow.ParticipantChangeRemove,
+		whatsmeow.ParticipantChangePromote, whatsmeow.ParticipantChangeDemote:
+	default:
+		http.Error(w, "action must be add, remove, promote or demote", http.StatusBadRequest)
+		return
+	}
+	participants, err := parseParticipants(req.Participants)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(participants) == 0 {
+		http.Error(w, "participants is required", http.StatusBadRequest)
+		return
+	}
+
+	if !a.Client.IsConnected() {
+		writeGroupResponse(w, http.StatusServiceUnavailable, GroupResponse{Message: errNotConnected.Error(), JID: group.String()})
+		return
+	}
+
+	changed, err := a.Client.UpdateGroupParticipants(group, participants, action)
+	if err != nil {
+		writeGroupResponse(w, http.StatusInternalServerError, GroupResponse{
+			Message: fmt.Sprintf("Failed to %s participants: %v", action, err),
+			JID:     group.String(),
+		})
+		return
+	}
+	results := participantResults(participants, changed)
+	if action == whatsmeow.ParticipantChangeAdd {
+		a.offerInvites(group, results, req.SendInvites)
+	}
+
+	a.Notifier.Notify(a.ID, WebhookEventGroupParticipants, map[string]interface{}{
+		"chat_jid":     group.String(),
+		"action":       string(action),
+		"participants": results,
+	})
+
+	verbs := map[whatsmeow.ParticipantChange]string{
+		whatsmeow.ParticipantChangeAdd:     "added",
+		whatsmeow.ParticipantChangeRemove:  "removed",
+		whatsmeow.ParticipantChangePromote: "promoted",
+		whatsmeow.ParticipantChangeDemote:  "demoted",
+	}
+	writeGroupResponse(w, http.StatusOK, GroupResponse{
+		Success:      true,
+		Message:      participantSummary(strings.ToUpper(verbs[action][:1])+verbs[action][1:], results),
+		JID:          group.String(),
+		Participants: results,
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPAllowlist lets only clients in some networks reach the REST API. A nil
+// allowlist lets everyone through.
+type IPAllowlist struct {
+	networks []*net.IPNet
+}
+
+// Parse comma separated CIDRs or bare addresses, returning nil when there are none
+func parseIPAllowlist(value string) (*IPAllowlist, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	if len(networks) == 0 {
+		return nil, nil
+	}
+	return &IPAllowlist{networks: networks}, nil
+}
+
+// Address of the peer that sent a request. Forwarding headers are ignored as
+// any client could set them.
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// Report whether an address is in one of the networks
+func (a *IPAllowlist) allows(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware rejects requests from addresses outside the allowlist
+func (a *IPAllowlist) Middleware(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.allows(clientIP(r)) {
+			http.Error(w, "Address not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimiter caps the requests per minute of each API key, or of each
+// address when no key was used. Every client gets a bucket holding a
+// minute's worth of requests that refills continuously, so short bursts are
+// fine but the average rate can't exceed the limit.
+type RateLimiter struct {
+	// Requests per minute for clients without a limit of their own, 0 for none
+	perMinute int
+
+	mu        sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+}
+
+// Remaining requests of one client
+type rateBucket struct {
+	tokens  float64
+	limit   int
+	updated time.Time
+}
+
+// Create a rate limiter with a default of perMinute requests per minute
+func NewRateLimiter(perMinute int) *RateLimiter {
+	return &RateLimiter{perMinute: perMinute, buckets: make(map[string]*rateBucket), lastSweep: time.Now()}
+}
+
+// Take a request from a client's bucket, returning the requests left or how
+// long to wait before the next one is allowed
+func (l *RateLimiter) take(client string, limit int) (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	// Forget buckets that have refilled, they are the same as new ones
+	if now.Sub(l.lastSweep) > time.Minute {
+		for id, bucket := range l.buckets {
+			if now.Sub(bucket.updated) > time.Minute {
+				delete(l.buckets, id)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	bucket, ok := l.buckets[client]
+	if !ok || bucket.limit != limit {
+		bucket = &rateBucket{tokens: float64(limit), limit: limit, updated: now}
+		l.buckets[client] = bucket
+	}
+	perSecond := float64(limit) / 60
+	bucket.tokens = math.Min(float64(limit), bucket.tokens+now.Sub(bucket.updated).Seconds()*perSecond)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		return 0, time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+	}
+	bucket.tokens--
+	return int(bucket.tokens), 0
+}
+
+// Middleware answers 429 with Retry-After once a client is over its limit.
+// It must run after the API key middleware, which identifies the key.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := l.perMinute
+		var client string
+		if key, _ := r.Context().Value(apiKeyContextKey{}).(*APIKey); key != nil {
+			if key.RateLimit > 0 {
+				limit = key.RateLimit
+			}
+			client = "key:" + key.ID
+		} else {
+			client = "ip:" + clientIP(r).String()
+		}
+		if limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		remaining, wait := l.take(client, limit)
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// How long a queued message may sit past its due time on a connected account
+// before the queue counts as wedged. The queues are polled every
+// schedulerInterval, so anything much later is stuck.
+const queueStallTimeout = 5 * time.Minute
+
+// How long the database check may take
+const healthDBTimeout = 2 * time.Second
+
+// When the process started, for the uptime in /healthz
+var processStarted = time.Now()
+
+// HealthResponse is the JSON body of /healthz
+type HealthResponse struct {
+	Status        string `json:"status"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	Goroutines    int    `json:"goroutines"`
+}
+
+// HealthCheck is the outcome of one readiness check
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Account string `json:"account,omitempty"`
+	OK      bool   `json:"ok"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// ReadinessResponse is the JSON body of /readyz
+type ReadinessResponse struct {
+	Ready     bool          `json:"ready"`
+	Checks    []HealthCheck `json:"checks"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// Handle GET /healthz, which only says the process is serving requests
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(HealthResponse{
+		Status:        "ok",
+		UptimeSeconds: int64(time.Since(processStarted).Seconds()),
+		Goroutines:    runtime.NumGoroutine(),
+	})
+}
+
+// Check that an account is logged in and its socket is up
+func (a *Account) checkConnection() HealthCheck {
+	check := HealthCheck{Name: "whatsapp", Account: a.ID, Detail: a.Session.State()}
+	switch {
+	case a.Client.Store.ID == nil:
+		check.Detail = "not paired"
+	case !a.Client.IsConnected():
+		check.Detail = fmt.Sprintf("socket not connected (%s)", a.Session.State())
+	case !a.Client.IsLoggedIn():
+		check.Detail = "connected but not logged in"
+	default:
+		check.OK = true
+	}
+	return check
+}
+
+// Check that the message database answers
+func (a *Account) checkDatabase(ctx context.Context) HealthCheck {
+	check := HealthCheck{Name: "database", Account: a.ID}
+	ctx, cancel := context.WithTimeout(ctx, healthDBTimeout)
+	defer cancel()
+	var one int
+	if err := a.MessageStore.db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+// Check that queued and scheduled messages are leaving. Queues only drain
+// while connected and writable, so a stall counts only then.
+func (a *Account) checkQueues(ctx context.Context) HealthCheck {
+	check := HealthCheck{Name: "queues", Account: a.ID, OK: true}
+	if len(a.MediaJobs.queue) == cap(a.MediaJobs.queue) {
+		check.OK = false
+		check.Detail = "media job queue is full"
+		return check
+	}
+	if a.ReadOnly || !a.Client.IsConnected() {
+		return check
+	}
+
+	stalled := time.Now().Add(-queueStallTimeout).UTC()
+	queries := []struct {
+		queue, query, status string
+	}{
+		{"outbox", "SELECT COUNT(*) FROM outbox WHERE status = ? AND next_attempt_at <= ?", OutboxStatusPending},
+		{"scheduled", "SELECT COUNT(*) FROM scheduled_messages WHERE status = ? AND next_attempt_at <= ?", ScheduleStatusPending},
+	}
+	for _, q := range queries {
+		var count int
+		if err := a.MessageStore.db.QueryRowContext(ctx, q.query, q.status, stalled).Scan(&count); err != nil {
+			check.OK = false
+			check.Detail = fmt.Sprintf("failed to inspect %s: %v", q.queue, err)
+			return check
+		}
+		if count > 0 {
+			check.OK = false
+			check.Detail = fmt.Sprintf("%d %s messages overdue by more than %v", count, q.queue, queueStallTimeout)
+			return check
+		}
+	}
+	return check
+}
+
+// Handle GET /readyz, answering 503 while draining or unless every account is
+// connected, its database answers and its queues are moving
+func (am *AccountManager) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	response := ReadinessResponse{Ready: true, Checks: []HealthCheck{}, CheckedAt: time.Now()}
+	// Take the bridge out of rotation while it drains
+	if draining.Load() {
+		response.Checks = append(response.Checks, HealthCheck{Name: "drain", Detail: "draining, not accepting sends"})
+	}
+	for _, account := range am.List() {
+		response.Checks = append(response.Checks,
+			account.checkConnection(),
+			account.checkDatabase(r.Context()),
+			account.checkQueues(r.Context()),
+		)
+	}
+	for _, check := range response.Checks {
+		if !check.OK {
+			response.Ready = false
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !response.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
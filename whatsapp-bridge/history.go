@@ -0,0 +1,416 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes for chat history
+const (
+	defaultHistoryPageSize = 50
+	maxHistoryPageSize     = 500
+)
+
+// Directions chat history can be read in
+const (
+	HistoryBackward = "backward"
+	HistoryForward  = "forward"
+)
+
+// StoredMessage is a message read back from the local store
+type StoredMessage struct {
+	ID              string    `json:"id"`
+	ChatJID         string    `json:"chat_jid"`
+	Sender          string    `json:"sender"`
+	SenderJID       string    `json:"sender_jid,omitempty"`
+	PushName        string    `json:"push_name,omitempty"`
+	Content         string    `json:"content,omitempty"`
+	Caption         string    `json:"caption,omitempty"`
+	Timestamp       time.Time `json:"timestamp"`
+	IsFromMe        bool      `json:"is_from_me"`
+	IsRead          bool      `json:"is_read"`
+	MessageType     string    `json:"message_type,omitempty"`
+	MediaType       string    `json:"media_type,omitempty"`
+	Filename        string    `json:"filename,omitempty"`
+	MimeType        string    `json:"mime_type,omitempty"`
+	FileLength      int64     `json:"file_length,omitempty"`
+	QuotedMessageID string    `json:"quoted_message_id,omitempty"`
+	QuotedSender    string    `json:"quoted_sender,omitempty"`
+	IsForwarded     bool      `json:"is_forwarded,omitempty"`
+	IsViewOnce      bool      `json:"is_view_once,omitempty"`
+	IsStarred       bool      `json:"is_starred,omitempty"`
+	DurationSeconds uint32    `json:"duration_seconds,omitempty"`
+	// Loudness of 64 slices of an audio message, each 0 to 100
+	Waveform []int `json:"waveform,omitempty"`
+	// Where downloaded media was saved
+	LocalPath string `json:"local_path,omitempty"`
+	LocalSize int64  `json:"local_size,omitempty"`
+	// When a disappearing message is due to vanish
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ChatHistoryResponse represents the response for the chat history API.
+// Messages are always oldest first, whichever direction was read.
+type ChatHistoryResponse struct {
+	ChatJID  string          `json:"chat_jid"`
+	Messages []StoredMessage `json:"messages"`
+	// Whether more messages exist in the direction read
+	HasMore bool `json:"has_more"`
+	// Pass as before to read older messages, or as after to read newer ones
+	BeforeCursor string `json:"before_cursor,omitempty"`
+	AfterCursor  string `json:"after_cursor,omitempty"`
+}
+
+// ChatHistoryOptions selects a page of chat history
+type ChatHistoryOptions struct {
+	// Read older (backward) or newer (forward) messages; without a cursor
+	// backward starts at the newest message and forward at the oldest
+	Direction string
+	Cursor    *messageCursor
+	MediaOnly bool
+	MediaType string
+	Limit     int
+}
+
+// Position in a chat's history, ordered by stored timestamp then ID
+type messageCursor struct {
+	Timestamp string
+	ID        string
+}
+
+// Encode a cursor as an opaque token
+func (c messageCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.Timestamp + "\n" + c.ID))
+}
+
+// Decode a cursor token
+func decodeMessageCursor(token string) (*messageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	timestamp, id, ok := strings.Cut(string(data), "\n")
+	if !ok || id == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &messageCursor{Timestamp: timestamp, ID: id}, nil
+}
+
+// Columns read into a StoredMessage by scanStoredMessage
+const storedMessageColumns = `id, chat_jid, sender, COALESCE(push_name, ''), COALESCE(content, ''), COALESCE(caption, ''),
+	timestamp, CAST(timestamp AS TEXT), is_from_me, COALESCE(is_read, 1), COALESCE(message_type, ''), COALESCE(media_type, ''),
+	COALESCE(filename, ''), COALESCE(mime_type, ''), COALESCE(file_length, 0), COALESCE(quoted_message_id, ''),
+	COALESCE(quoted_sender, ''), COALESCE(is_forwarded, 0), COALESCE(is_view_once, 0), COALESCE(starred, 0), COALESCE(local_path, ''),
+	COALESCE(local_size, 0), expires_at, COALESCE(duration_seconds, 0), waveform, COALESCE(sender_jid, '')`
+
+// Scan a row selected with storedMessageColumns, returning its cursor too
+func scanStoredMessage(row interface{ Scan(...interface{}) error }) (StoredMessage, messageCursor, error) {
+	var msg StoredMessage
+	var cursor messageCursor
+	var expiresAt sql.NullTime
+	var waveform []byte
+	err := row.Scan(&msg.ID, &msg.ChatJID, &msg.Sender, &msg.PushName, &msg.Content, &msg.Caption,
+		&msg.Timestamp, &cursor.Timestamp, &msg.IsFromMe, &msg.IsRead, &msg.MessageType, &msg.MediaType,
+		&msg.Filename, &msg.MimeType, &msg.FileLength, &msg.QuotedMessageID,
+		&msg.QuotedSender, &msg.IsForwarded, &msg.IsViewOnce, &msg.IsStarred, &msg.LocalPath,
+		&msg.LocalSize, &expiresAt, &msg.DurationSeconds, &waveform, &msg.SenderJID)
+	if expiresAt.Valid {
+		msg.ExpiresAt = &expiresAt.Time
+	}
+	msg.Waveform = waveformValues(waveform)
+	cursor.ID = msg.ID
+	return msg, cursor, err
+}
+
+// Read a page of a chat's history
+func (store *MessageStore) ChatHistory(chatJID string, opts ChatHistoryOptions) (*ChatHistoryResponse, error) {
+	var query strings.Builder
+	query.WriteString("SELECT " + storedMessageColumns + " FROM messages WHERE chat_jid = ?")
+	args := []interface{}{chatJID}
+
+	if opts.MediaOnly {
+		query.WriteString(" AND COALESCE(media_type, '') != ''")
+	}
+	if opts.MediaType != "" {
+		query.WriteString(" AND media_type = ?")
+		args = append(args, opts.MediaType)
+	}
+
+	forward := opts.Direction == HistoryForward
+	if opts.Cursor != nil {
+		if forward {
+			query.WriteString(" AND (CAST(timestamp AS TEXT) > ? OR (CAST(timestamp AS TEXT) = ? AND id > ?))")
+		} else {
+			query.WriteString(" AND (CAST(timestamp AS TEXT) < ? OR (CAST(timestamp AS TEXT) = ? AND id < ?))")
+		}
+		args = append(args, opts.Cursor.Timestamp, opts.Cursor.Timestamp, opts.Cursor.ID)
+	}
+	if forward {
+		query.WriteString(" ORDER BY CAST(timestamp AS TEXT), id")
+	} else {
+		query.WriteString(" ORDER BY CAST(timestamp AS TEXT) DESC, id DESC")
+	}
+
+	// Fetch one extra row to know whether there is another page
+	query.WriteString(" LIMIT ?")
+	args = append(args, opts.Limit+1)
+
+	rows, err := store.db.Query(query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []StoredMessage
+	var cursors []messageCursor
+	for rows.Next() {
+		msg, cursor, err := scanStoredMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+		cursors = append(cursors, cursor)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	response := &ChatHistoryResponse{ChatJID: chatJID, Messages: []StoredMessage{}}
+	if len(messages) > opts.Limit {
+		messages, cursors = messages[:opts.Limit], cursors[:opts.Limit]
+		response.HasMore = true
+	}
+
+	// Put the page in chronological order
+	if !forward {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+			cursors[i], cursors[j] = cursors[j], cursors[i]
+		}
+	}
+	if len(messages) > 0 {
+		response.Messages = messages
+		response.BeforeCursor = cursors[0].encode()
+		response.AfterCursor = cursors[len(cursors)-1].encode()
+	}
+	return response, nil
+}
+
+// Handle GET /api/chats/{jid}/messages
+func (a *Account) HandleChatMessagesEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	chat, err := parseRecipient(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := ChatHistoryOptions{
+		Direction: HistoryBackward,
+		MediaType: query.Get("media_type"),
+		Limit:     defaultHistoryPageSize,
+	}
+	if value := query.Get("direction"); value != "" {
+		if value != HistoryBackward && value != HistoryForward {
+			http.Error(w, "direction must be backward or forward", http.StatusBadRequest)
+			return
+		}
+		opts.Direction = value
+	}
+
+	// before and after imply the direction
+	before, after := query.Get("before"), query.Get("after")
+	if before != "" && after != "" {
+		http.Error(w, "Use either before or after, not both", http.StatusBadRequest)
+		return
+	}
+	if before != "" || after != "" {
+		token := before
+		opts.Direction = HistoryBackward
+		if after != "" {
+			token = after
+			opts.Direction = HistoryForward
+		}
+		if opts.Cursor, err = decodeMessageCursor(token); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if value := query.Get("media_only"); value != "" {
+		if opts.MediaOnly, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "media_only must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		opts.Limit = limit
+		if opts.Limit > maxHistoryPageSize {
+			opts.Limit = maxHistoryPageSize
+		}
+	}
+
+	response, err := a.MessageStore.ChatHistory(chat.String(), opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// MessageListOptions filters stored messages across chats
+type MessageListOptions struct {
+	ChatJID string
+	Sender  string
+	// Substring of the text or caption
+	Query  string
+	After  time.Time
+	Before time.Time
+	Cursor *messageCursor
+	Limit  int
+}
+
+// MessageListResponse represents the response for the message list API.
+// Messages are newest first.
+type MessageListResponse struct {
+	Messages []StoredMessage `json:"messages"`
+	// Pass as cursor to read the next, older page; empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// List stored messages newest first, one page at a time
+func (store *MessageStore) ListMessages(opts MessageListOptions) (*MessageListResponse, error) {
+	var query strings.Builder
+	query.WriteString("SELECT " + storedMessageColumns + " FROM messages WHERE 1 = 1")
+	var args []interface{}
+
+	if opts.ChatJID != "" {
+		query.WriteString(" AND chat_jid = ?")
+		args = append(args, opts.ChatJID)
+	}
+	if opts.Sender != "" {
+		query.WriteString(" AND sender = ?")
+		args = append(args, opts.Sender)
+	}
+	if opts.Query != "" {
+		query.WriteString(" AND (content LIKE ? OR caption LIKE ?)")
+		pattern := "%" + opts.Query + "%"
+		args = append(args, pattern, pattern)
+	}
+	// Timestamps are stored in local time, so compare in local time too
+	if !opts.After.IsZero() {
+		query.WriteString(" AND timestamp >= ?")
+		args = append(args, opts.After.Local())
+	}
+	if !opts.Before.IsZero() {
+		query.WriteString(" AND timestamp < ?")
+		args = append(args, opts.Before.Local())
+	}
+	if opts.Cursor != nil {
+		query.WriteString(" AND (CAST(timestamp AS TEXT) < ? OR (CAST(timestamp AS TEXT) = ? AND id < ?))")
+		args = append(args, opts.Cursor.Timestamp, opts.Cursor.Timestamp, opts.Cursor.ID)
+	}
+
+	// Fetch one extra row to know whether there is another page
+	query.WriteString(" ORDER BY CAST(timestamp AS TEXT) DESC, id DESC LIMIT ?")
+	args = append(args, opts.Limit+1)
+
+	rows, err := store.db.Query(query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	response := &MessageListResponse{Messages: []StoredMessage{}}
+	var last messageCursor
+	for rows.Next() {
+		msg, cursor, err := scanStoredMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(response.Messages) == opts.Limit {
+			response.NextCursor = last.encode()
+			break
+		}
+		response.Messages = append(response.Messages, msg)
+		last = cursor
+	}
+	return response, rows.Err()
+}
+
+// Handle GET /api/messages
+func (a *Account) HandleListMessagesEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := MessageListOptions{
+		Sender: strings.TrimPrefix(query.Get("sender"), "+"),
+		Query:  strings.TrimSpace(query.Get("q")),
+		Limit:  defaultHistoryPageSize,
+	}
+	if chat := query.Get("chat_jid"); chat != "" {
+		jid, err := parseRecipient(chat)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.ChatJID = jid.String()
+	}
+	for param, target := range map[string]*time.Time{"after": &opts.After, "before": &opts.Before} {
+		if value := query.Get(param); value != "" {
+			t, err := parseTimeParam(value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s: %v", param, err), http.StatusBadRequest)
+				return
+			}
+			*target = t
+		}
+	}
+	if token := query.Get("cursor"); token != "" {
+		var err error
+		if opts.Cursor, err = decodeMessageCursor(token); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		opts.Limit = min(limit, maxHistoryPageSize)
+	}
+
+	response, err := a.MessageStore.ListMessages(opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"path/filepath"
+	"strings"
+)
+
+// Images that already fit and are at most this large are sent untouched
+const compressMinSize = 1 << 20
+
+// Largest image decoded, in pixels. A file of a few kilobytes can declare a
+// canvas that takes gigabytes to decode, so its header is checked first.
+const maxDecodePixels = 64 << 20
+
+// ImageCompression controls how outgoing images are shrunk before upload
+type ImageCompression struct {
+	Enabled bool
+	// Longest side in pixels after resizing
+	MaxDimension int
+	// JPEG quality from 1 to 100
+	Quality int
+}
+
+// Refuse to decode an image larger than maxDecodePixels
+func checkImagePixels(config image.Config) error {
+	if int64(config.Width)*int64(config.Height) > maxDecodePixels {
+		return fmt.Errorf("image is %dx%d, larger than %d megapixels", config.Width, config.Height, maxDecodePixels>>20)
+	}
+	return nil
+}
+
+// Decode an image once its header shows it fits maxDecodePixels
+func decodeImage(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image size: %v", err)
+	}
+	if err := checkImagePixels(config); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	return src, nil
+}
+
+// Resize and re-encode an image attachment as JPEG when it is too large,
+// returning whether it changed. Animated GIFs and formats Go can't decode
+// are left alone.
+func compressImage(att *Attachment, opts ImageCompression) (bool, error) {
+	mimeType := strings.Split(att.MimeType, ";")[0]
+	if mimeType != "image/jpeg" && mimeType != "image/png" {
+		return false, nil
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(att.Data))
+	if err != nil {
+		return false, fmt.Errorf("failed to read image size: %v", err)
+	}
+	if err := checkImagePixels(config); err != nil {
+		return false, err
+	}
+
+	orientation := 1
+	if mimeType == "image/jpeg" {
+		orientation = jpegOrientation(att.Data)
+	}
+	oversized := max(config.Width, config.Height) > opts.MaxDimension
+	if !oversized && orientation == 1 && len(att.Data) <= compressMinSize {
+		return false, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(att.Data))
+	if err != nil {
+		return false, fmt.Errorf("failed to decode image: %v", err)
+	}
+	width, height := config.Width, config.Height
+	if orientation >= 5 {
+		width, height = height, width
+	}
+	if oversized {
+		if width > height {
+			width, height = opts.MaxDimension, max(height*opts.MaxDimension/width, 1)
+		} else {
+			width, height = max(width*opts.MaxDimension/height, 1), opts.MaxDimension
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleImage(src, orientation, width, height), &jpeg.Options{Quality: opts.Quality}); err != nil {
+		return false, fmt.Errorf("failed to encode image: %v", err)
+	}
+	// Re-encoding a small, upright image can make it bigger
+	if !oversized && orientation == 1 && buf.Len() >= len(att.Data) {
+		return false, nil
+	}
+
+	att.Data = buf.Bytes()
+	att.MimeType = "image/jpeg"
+	if att.Filename != "" {
+		att.Filename = strings.TrimSuffix(att.Filename, filepath.Ext(att.Filename)) + ".jpg"
+	}
+	return true, nil
+}
+
+// Downscale an image with a box filter, applying its EXIF orientation and
+// flattening transparency onto white, since JPEG has no alpha
+func scaleImage(src image.Image, orientation, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	orientedWidth, orientedHeight := srcWidth, srcHeight
+	if orientation >= 5 {
+		orientedWidth, orientedHeight = srcHeight, srcWidth
+	}
+
+	// Sum every source pixel into the output pixel it lands on
+	sums := make([][4]uint64, width*height)
+	for sy := 0; sy < srcHeight; sy++ {
+		for sx := 0; sx < srcWidth; sx++ {
+			ox, oy := orient(sx, sy, srcWidth, srcHeight, orientation)
+			dx, dy := ox*width/orientedWidth, oy*height/orientedHeight
+			r, g, b, a := src.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+			sum := &sums[dy*width+dx]
+			sum[0] += uint64(r + 0xffff - a)
+			sum[1] += uint64(g + 0xffff - a)
+			sum[2] += uint64(b + 0xffff - a)
+			sum[3]++
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, sum := range sums {
+		if sum[3] == 0 {
+			continue
+		}
+		dst.Pix[i*4] = uint8(sum[0] / sum[3] >> 8)
+		dst.Pix[i*4+1] = uint8(sum[1] / sum[3] >> 8)
+		dst.Pix[i*4+2] = uint8(sum[2] / sum[3] >> 8)
+		dst.Pix[i*4+3] = 0xff
+	}
+	return dst
+}
+
+// Map a stored pixel to where it is displayed under an EXIF orientation
+func orient(x, y, width, height, orientation int) (int, int) {
+	switch orientation {
+	case 2:
+		return width - 1 - x, y
+	case 3:
+		return width - 1 - x, height - 1 - y
+	case 4:
+		return x, height - 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return height - 1 - y, x
+	case 7:
+		return height - 1 - y, width - 1 - x
+	case 8:
+		return y, width - 1 - x
+	}
+	return x, y
+}
+
+// Read the EXIF orientation of a JPEG, 1 (upright) when it has none
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		// Metadata segments all come before the image data
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return 1
+		}
+		if marker == 0xE1 {
+			if orientation := exifOrientation(data[i+4 : i+2+size]); orientation != 0 {
+				return orientation
+			}
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// Find the orientation tag in the first IFD of an EXIF segment, 0 if missing
+func exifOrientation(segment []byte) int {
+	if len(segment) < 14 || string(segment[:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := segment[6:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < entries; k++ {
+		entry := ifd + 2 + k*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 0
+		}
+	}
+	return 0
+}
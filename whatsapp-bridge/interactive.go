@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Sent to webhooks and event streams when someone taps a button or picks a
+// list row in one of our interactive messages
+const WebhookEventInteractiveReply = "interactive_reply"
+
+// Limits the apps put on interactive messages
+const (
+	maxButtons  = 3
+	maxListRows = 10
+)
+
+// Button types of the buttons API
+const (
+	ButtonTypeReply = "reply"
+	ButtonTypeURL   = "url"
+	ButtonTypeCall  = "call"
+)
+
+// Button is a button of an interactive message. Reply buttons send their ID
+// back; URL and call buttons open a link or dial a number.
+type Button struct {
+	// reply (the default), url or call
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Text  string `json:"text"`
+	URL   string `json:"url,omitempty"`
+	Phone string `json:"phone,omitempty"`
+}
+
+// SendButtonsRequest represents the request body for the buttons message API
+type SendButtonsRequest struct {
+	Recipient string   `json:"recipient"`
+	Header    string   `json:"header"`
+	Body      string   `json:"body"`
+	Footer    string   `json:"footer"`
+	Buttons   []Button `json:"buttons"`
+	// ID of the message this one replies to
+	QuotedMessageID string `json:"quoted_message_id"`
+}
+
+// ListRow is a choice in a list message
+type ListRow struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// ListSection groups rows of a list message under a title
+type ListSection struct {
+	Title string    `json:"title"`
+	Rows  []ListRow `json:"rows"`
+}
+
+// SendListRequest represents the request body for the list message API
+type SendListRequest struct {
+	Recipient string `json:"recipient"`
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	Footer    string `json:"footer"`
+	// Label of the button that opens the list
+	ButtonText string        `json:"button_text"`
+	Sections   []ListSection `json:"sections"`
+	// ID of the message this one replies to
+	QuotedMessageID string `json:"quoted_message_id"`
+}
+
+// InteractiveReply is a button tap or list choice
+type InteractiveReply struct {
+	// button, template_button or list
+	Type         string `json:"type"`
+	SelectedID   string `json:"selected_id"`
+	SelectedText string `json:"selected_text"`
+	Description  string `json:"description,omitempty"`
+	// The interactive message that was answered
+	QuotedMessageID string `json:"quoted_message_id,omitempty"`
+}
+
+// Parse a button or list reply, or return nil for other messages
+func interactiveReply(msg *waProto.Message) *InteractiveReply {
+	switch {
+	case msg.GetButtonsResponseMessage() != nil:
+		resp := msg.GetButtonsResponseMessage()
+		return &InteractiveReply{
+			Type:            "button",
+			SelectedID:      resp.GetSelectedButtonID(),
+			SelectedText:    resp.GetSelectedDisplayText(),
+			QuotedMessageID: resp.GetContextInfo().GetStanzaID(),
+		}
+	case msg.GetTemplateButtonReplyMessage() != nil:
+		resp := msg.GetTemplateButtonReplyMessage()
+		return &InteractiveReply{
+			Type:            "template_button",
+			SelectedID:      resp.GetSelectedID(),
+			SelectedText:    resp.GetSelectedDisplayText(),
+			QuotedMessageID: resp.GetContextInfo().GetStanzaID(),
+		}
+	case msg.GetListResponseMessage() != nil:
+		resp := msg.GetListResponseMessage()
+		return &InteractiveReply{
+			Type:            "list",
+			SelectedID:      resp.GetSingleSelectReply().GetSelectedRowID(),
+			SelectedText:    resp.GetTitle(),
+			Description:     resp.GetDescription(),
+			QuotedMessageID: resp.GetContextInfo().GetStanzaID(),
+		}
+	}
+	return nil
+}
+
+// Forward button and list replies as a structured event for bots
+func (a *Account) notifyInteractiveReply(msg *events.Message) {
+	reply := interactiveReply(msg.Message)
+	if reply == nil {
+		return
+	}
+	a.Notifier.Notify(a.ID, WebhookEventInteractiveReply, map[string]interface{}{
+		"id":                msg.Info.ID,
+		"chat_jid":          msg.Info.Chat.String(),
+		"sender":            msg.Info.Sender.User,
+		"push_name":         msg.Info.PushName,
+		"type":              reply.Type,
+		"selected_id":       reply.SelectedID,
+		"selected_text":     reply.SelectedText,
+		"description":       reply.Description,
+		"quoted_message_id": reply.QuotedMessageID,
+		"timestamp":         msg.Info.Timestamp,
+	})
+}
+
+// Interactive messages only render on phones when wrapped like this
+func wrapInteractive(msg *waProto.Message) *waProto.Message {
+	msg.MessageContextInfo = &waProto.MessageContextInfo{
+		DeviceListMetadata:        &waE2E.DeviceListMetadata{},
+		DeviceListMetadataVersion: proto.Int32(2),
+	}
+	return &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{Message: msg}}
+}
+
+// Check the buttons of a request, filling in defaults
+func (req *SendButtonsRequest) validate() error {
+	if strings.TrimSpace(req.Body) == "" {
+		return fmt.Errorf("body is required")
+	}
+	if len(req.Buttons) == 0 || len(req.Buttons) > maxButtons {
+		return fmt.Errorf("between 1 and %d buttons are required", maxButtons)
+	}
+	for i := range req.Buttons {
+		button := &req.Buttons[i]
+		if strings.TrimSpace(button.Text) == "" {
+			return fmt.Errorf("button %d needs text", i+1)
+		}
+		switch button.Type {
+		case "", ButtonTypeReply:
+			button.Type = ButtonTypeReply
+			if button.ID == "" {
+				button.ID = fmt.Sprintf("%d", i+1)
+			}
+		case ButtonTypeURL:
+			if !strings.HasPrefix(button.URL, "http://") && !strings.HasPrefix(button.URL, "https://") {
+				return fmt.Errorf("button %d needs an http or https url", i+1)
+			}
+		case ButtonTypeCall:
+			if button.Phone == "" {
+				return fmt.Errorf("button %d needs a phone number", i+1)
+			}
+		default:
+			return fmt.Errorf("button %d has unknown type %q, expected reply, url or call", i+1, button.Type)
+		}
+	}
+	return nil
+}
+
+// Build a buttons message. Only template messages can carry URL and call
+// buttons, so those are used when any button isn't a reply.
+func buildButtonsMessage(req *SendButtonsRequest, info *waProto.ContextInfo) *waProto.Message {
+	template := false
+	for _, button := range req.Buttons {
+		if button.Type != ButtonTypeReply {
+			template = true
+		}
+	}
+
+	if !template {
+		buttons := &waProto.ButtonsMessage{
+			ContentText: proto.String(req.Body),
+			HeaderType:  waProto.ButtonsMessage_EMPTY.Enum(),
+			ContextInfo: info,
+		}
+		if req.Header != "" {
+			buttons.HeaderType = waProto.ButtonsMessage_TEXT.Enum()
+			buttons.Header = &waProto.ButtonsMessage_Text{Text: req.Header}
+		}
+		if req.Footer != "" {
+			buttons.FooterText = proto.String(req.Footer)
+		}
+		for _, button := range req.Buttons {
+			buttons.Buttons = append(buttons.Buttons, &waProto.ButtonsMessage_Button{
+				ButtonID:   proto.String(button.ID),
+				ButtonText: &waProto.ButtonsMessage_Button_ButtonText{DisplayText: proto.String(button.Text)},
+				Type:       waProto.ButtonsMessage_Button_RESPONSE.Enum(),
+			})
+		}
+		return &waProto.Message{ButtonsMessage: buttons}
+	}
+
+	hydrated := &waProto.TemplateMessage_HydratedFourRowTemplate{
+		HydratedContentText: proto.String(req.Body),
+	}
+	if req.Header != "" {
+		hydrated.Title = &waProto.TemplateMessage_HydratedFourRowTemplate_HydratedTitleText{HydratedTitleText: req.Header}
+	}
+	if req.Footer != "" {
+		hydrated.HydratedFooterText = proto.String(req.Footer)
+	}
+	for i, button := range req.Buttons {
+		templateButton := &waProto.HydratedTemplateButton{Index: proto.Uint32(uint32(i))}
+		switch button.Type {
+		case ButtonTypeURL:
+			templateButton.HydratedButton = &waProto.HydratedTemplateButton_UrlButton{
+				UrlButton: &waProto.HydratedTemplateButton_HydratedURLButton{
+					DisplayText: proto.String(button.Text),
+					URL:         proto.String(button.URL),
+				},
+			}
+		case ButtonTypeCall:
+			templateButton.HydratedButton = &waProto.HydratedTemplateButton_CallButton{
+				CallButton: &waProto.HydratedTemplateButton_HydratedCallButton{
+					DisplayText: proto.String(button.Text),
+					PhoneNumber: proto.String(button.Phone),
+				},
+			}
+		default:
+			templateButton.HydratedButton = &waProto.HydratedTemplateButton_QuickReplyButton{
+				QuickReplyButton: &waProto.HydratedTemplateButton_HydratedQuickReplyButton{
+					DisplayText: proto.String(button.Text),
+					ID:          proto.String(button.ID),
+				},
+			}
+		}
+		hydrated.HydratedButtons = append(hydrated.HydratedButtons, templateButton)
+	}
+	return &waProto.Message{TemplateMessage: &waProto.TemplateMessage{
+		Format:           &waProto.TemplateMessage_HydratedFourRowTemplate_{HydratedFourRowTemplate: hydrated},
+		HydratedTemplate: hydrated,
+		ContextInfo:      info,
+	}}
+}
+
+// Check the sections of a list request
+func (req *SendListRequest) validate() error {
+	if strings.TrimSpace(req.Body) == "" {
+		return fmt.Errorf("body is required")
+	}
+	if strings.TrimSpace(req.ButtonText) == "" {
+		return fmt.Errorf("button_text is required")
+	}
+	if len(req.Sections) == 0 {
+		return fmt.Errorf("at least one section is required")
+	}
+	rows := 0
+	seen := make(map[string]bool)
+	for i, section := range req.Sections {
+		if len(section.Rows) == 0 {
+			return fmt.Errorf("section %d has no rows", i+1)
+		}
+		for _, row := range section.Rows {
+			if row.ID == "" || strings.TrimSpace(row.Title) == "" {
+				return fmt.Errorf("every row needs an id and a title")
+			}
+			if seen[row.ID] {
+				return fmt.Errorf("row id %s is used twice", row.ID)
+			}
+			seen[row.ID] = true
+			rows++
+		}
+	}
+	if rows > maxListRows {
+		return fmt.Errorf("lists can have at most %d rows", maxListRows)
+	}
+	return nil
+}
+
+// Build a single-select list message
+func buildListMessage(req *SendListRequest, info *waProto.ContextInfo) *waProto.Message {
+	list := &waProto.ListMessage{
+		Title:       proto.String(req.Title),
+		Description: proto.String(req.Body),
+		ButtonText:  proto.String(req.ButtonText),
+		ListType:    waProto.ListMessage_SINGLE_SELECT.Enum(),
+		ContextInfo: info,
+	}
+	if req.Footer != "" {
+		list.FooterText = proto.String(req.Footer)
+	}
+	for _, section := range req.Sections {
+		listSection := &waProto.ListMessage_Section{Title: proto.String(section.Title)}
+		for _, row := range section.Rows {
+			listSection.Rows = append(listSection.Rows, &waProto.ListMessage_Row{
+				RowID:       proto.String(row.ID),
+				Title:       proto.String(row.Title),
+				Description: proto.String(row.Description),
+			})
+		}
+		list.Sections = append(list.Sections, listSection)
+	}
+	return &waProto.Message{ListMessage: list}
+}
+
+// Handle POST /api/messages/buttons
+func (a *Account) HandleSendButtonsEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var req SendButtonsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	to, err := parseRecipient(req.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var info *waProto.ContextInfo
+	if req.QuotedMessageID != "" {
+		info = a.quoteContext(to, req.QuotedMessageID)
+	}
+	resp, err := a.sendOrQueue(to, wrapInteractive(buildButtonsMessage(&req, info)))
+	writeSendResult(w, to, resp, err)
+}
+
+// Handle POST /api/messages/list
+func (a *Account) HandleSendListEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var req SendListRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	to, err := parseRecipient(req.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var info *waProto.ContextInfo
+	if req.QuotedMessageID != "" {
+		info = a.quoteContext(to, req.QuotedMessageID)
+	}
+	resp, err := a.sendOrQueue(to, wrapInteractive(buildListMessage(&req, info)))
+	writeSendResult(w, to, resp, err)
+}
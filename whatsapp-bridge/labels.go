@@ -0,0 +1,434 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Labels can use one of the apps' 20 colors
+const labelColors = 20
+
+// Label is a WhatsApp Business label, with how many chats and messages carry it
+type Label struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Color        int32  `json:"color"`
+	PredefinedID int32  `json:"predefined_id,omitempty"`
+	ChatCount    int    `json:"chat_count"`
+	MessageCount int    `json:"message_count"`
+}
+
+// LabeledMessage is a message carrying a label
+type LabeledMessage struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id"`
+}
+
+// LabelRequest represents the request body for creating or editing a label
+type LabelRequest struct {
+	Name  *string `json:"name"`
+	Color *int32  `json:"color"`
+}
+
+// LabelAssignRequest represents the request body for labeling a chat or message
+type LabelAssignRequest struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id,omitempty"`
+	// Defaults to true; false removes the label
+	Labeled *bool `json:"labeled"`
+}
+
+// LabelResponse represents the response for the label APIs
+type LabelResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Label   *Label `json:"label,omitempty"`
+	// Filled in when reading a single label
+	Chats    []string         `json:"chats,omitempty"`
+	Messages []LabeledMessage `json:"messages,omitempty"`
+}
+
+// LabelsResponse represents the response for the label list API
+type LabelsResponse struct {
+	Labels []*Label `json:"labels"`
+	Total  int      `json:"total"`
+}
+
+// Store a label, keeping the name or color when left empty
+func (store *MessageStore) StoreLabel(id string, name *string, color, predefinedID *int32) error {
+	_, err := store.db.Exec(`
+		INSERT INTO labels (id, name, color, predefined_id) VALUES (?, COALESCE(?, ''), COALESCE(?, 0), ?)
+		ON CONFLICT(id) DO UPDATE SET
+			name = COALESCE(?, labels.name),
+			color = COALESCE(?, labels.color),
+			predefined_id = COALESCE(?, labels.predefined_id)`,
+		id, name, color, predefinedID, name, color, predefinedID,
+	)
+	return err
+}
+
+// Delete a label along with its chat and message assignments
+func (store *MessageStore) DeleteLabel(id string) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM label_chats WHERE label_id = ?",
+		"DELETE FROM label_messages WHERE label_id = ?",
+		"DELETE FROM labels WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Columns read into a Label by scanLabel
+const labelColumns = `id, name, color, COALESCE(predefined_id, 0),
+	(SELECT COUNT(*) FROM label_chats WHERE label_id = labels.id),
+	(SELECT COUNT(*) FROM label_messages WHERE label_id = labels.id)`
+
+// Scan a row selected with labelColumns
+func scanLabel(row interface{ Scan(...interface{}) error }) (*Label, error) {
+	var label Label
+	err := row.Scan(&label.ID, &label.Name, &label.Color, &label.PredefinedID, &label.ChatCount, &label.MessageCount)
+	return &label, err
+}
+
+// Get a stored label, or nil if it doesn't exist
+func (store *MessageStore) GetLabel(id string) (*Label, error) {
+	label, err := scanLabel(store.db.QueryRow("SELECT "+labelColumns+" FROM labels WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return label, err
+}
+
+// List stored labels by ID
+func (store *MessageStore) ListLabels() ([]*Label, error) {
+	rows, err := store.db.Query("SELECT " + labelColumns + " FROM labels ORDER BY CAST(id AS INTEGER), id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := []*Label{}
+	for rows.Next() {
+		label, err := scanLabel(rows)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, label)
+	}
+	return labels, rows.Err()
+}
+
+// Pick an ID for a new label. The apps number labels, so follow on from the
+// highest one.
+func (store *MessageStore) nextLabelID() (string, error) {
+	var highest int
+	err := store.db.QueryRow("SELECT COALESCE(MAX(CAST(id AS INTEGER)), 0) FROM labels").Scan(&highest)
+	return strconv.Itoa(highest + 1), err
+}
+
+// Add or remove a label on a chat
+func (store *MessageStore) SetChatLabel(labelID, chatJID string, labeled bool) error {
+	query := "INSERT OR IGNORE INTO label_chats (label_id, chat_jid) VALUES (?, ?)"
+	if !labeled {
+		query = "DELETE FROM label_chats WHERE label_id = ? AND chat_jid = ?"
+	}
+	_, err := store.db.Exec(query, labelID, chatJID)
+	return err
+}
+
+// Add or remove a label on a message
+func (store *MessageStore) SetMessageLabel(labelID, chatJID, messageID string, labeled bool) error {
+	query := "INSERT OR IGNORE INTO label_messages (label_id, chat_jid, message_id) VALUES (?, ?, ?)"
+	if !labeled {
+		query = "DELETE FROM label_messages WHERE label_id = ? AND chat_jid = ? AND message_id = ?"
+	}
+	_, err := store.db.Exec(query, labelID, chatJID, messageID)
+	return err
+}
+
+// Get the chats and messages carrying a label
+func (store *MessageStore) LabelAssignments(labelID string) ([]string, []LabeledMessage, error) {
+	rows, err := store.db.Query("SELECT chat_jid FROM label_chats WHERE label_id = ? ORDER BY chat_jid", labelID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	chats := []string{}
+	for rows.Next() {
+		var chatJID string
+		if err := rows.Scan(&chatJID); err != nil {
+			return nil, nil, err
+		}
+		chats = append(chats, chatJID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = store.db.Query("SELECT chat_jid, message_id FROM label_messages WHERE label_id = ? ORDER BY chat_jid, message_id", labelID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	messages := []LabeledMessage{}
+	for rows.Next() {
+		var msg LabeledMessage
+		if err := rows.Scan(&msg.ChatJID, &msg.MessageID); err != nil {
+			return nil, nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return chats, messages, rows.Err()
+}
+
+// Keep labels and their assignments in sync with changes made on other devices
+func (a *Account) handleLabelEvent(evt interface{}) {
+	var err error
+	switch v := evt.(type) {
+	case *events.LabelEdit:
+		if v.Action.GetDeleted() {
+			err = a.MessageStore.DeleteLabel(v.LabelID)
+		} else {
+			err = a.MessageStore.StoreLabel(v.LabelID, v.Action.Name, v.Action.Color, v.Action.PredefinedID)
+		}
+	case *events.LabelAssociationChat:
+		err = a.MessageStore.SetChatLabel(v.LabelID, v.JID.String(), v.Action.GetLabeled())
+	case *events.LabelAssociationMessage:
+		err = a.MessageStore.SetMessageLabel(v.LabelID, v.JID.String(), v.MessageID, v.Action.GetLabeled())
+	}
+	if err != nil {
+		a.Logger.Warnf("Failed to update labels: %v", err)
+	}
+}
+
+// Write a label API response
+func writeLabelResponse(w http.ResponseWriter, status int, response LabelResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Check the name and color of a label request
+func (req *LabelRequest) validate() error {
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return fmt.Errorf("name must not be empty")
+		}
+		req.Name = &name
+	}
+	if req.Color != nil && (*req.Color < 0 || *req.Color >= labelColors) {
+		return fmt.Errorf("color must be between 0 and %d", labelColors-1)
+	}
+	return nil
+}
+
+// Handle GET and POST /api/labels. GET lists labels synced from the phone;
+// POST creates one.
+func (a *Account) HandleLabelsEndpoint(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		labels, err := a.MessageStore.ListLabels()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to load labels: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LabelsResponse{Labels: labels, Total: len(labels)})
+	case http.MethodPost:
+		var req LabelRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Name == nil {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, err := a.MessageStore.nextLabelID()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to pick a label ID: %v", err), http.StatusInternalServerError)
+			return
+		}
+		a.editLabel(w, id, nil, req, false)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Handle GET, PATCH and DELETE /api/labels/{id}
+func (a *Account) HandleLabelEndpoint(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	label, err := a.MessageStore.GetLabel(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load label: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if label == nil {
+		writeLabelResponse(w, http.StatusNotFound, LabelResponse{Message: fmt.Sprintf("Label %s not found", id)})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		chats, messages, err := a.MessageStore.LabelAssignments(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to load label: %v", err), http.StatusInternalServerError)
+			return
+		}
+		writeLabelResponse(w, http.StatusOK, LabelResponse{Success: true, Message: label.Name, Label: label, Chats: chats, Messages: messages})
+	case http.MethodPatch:
+		var req LabelRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.editLabel(w, id, label, req, false)
+	case http.MethodDelete:
+		a.editLabel(w, id, label, LabelRequest{}, true)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Create, edit or delete a label on every device. The patch carries the whole
+// label, so unchanged fields come from the stored one.
+func (a *Account) editLabel(w http.ResponseWriter, id string, current *Label, req LabelRequest, deleted bool) {
+	if !a.Client.IsConnected() {
+		writeLabelResponse(w, http.StatusServiceUnavailable, LabelResponse{Message: errNotConnected.Error()})
+		return
+	}
+
+	label := &Label{ID: id}
+	if current != nil {
+		*label = *current
+	}
+	if req.Name != nil {
+		label.Name = *req.Name
+	}
+	if req.Color != nil {
+		label.Color = *req.Color
+	}
+
+	if err := a.Client.SendAppState(appstate.BuildLabelEdit(id, label.Name, label.Color, deleted)); err != nil {
+		writeLabelResponse(w, http.StatusInternalServerError, LabelResponse{Message: fmt.Sprintf("Failed to update label: %v", err)})
+		return
+	}
+
+	// Our own patches aren't echoed back, so update the store ourselves
+	var err error
+	message := fmt.Sprintf("Saved label %s", label.Name)
+	if deleted {
+		err = a.MessageStore.DeleteLabel(id)
+		message = fmt.Sprintf("Deleted label %s", label.Name)
+		label = nil
+	} else {
+		err = a.MessageStore.StoreLabel(id, &label.Name, &label.Color, nil)
+	}
+	if err != nil {
+		a.Logger.Warnf("Failed to store label %s: %v", id, err)
+	}
+	writeLabelResponse(w, http.StatusOK, LabelResponse{Success: true, Message: message, Label: label})
+}
+
+// Handle POST /api/labels/{id}/chats and /api/labels/{id}/messages, adding or
+// removing a label on a chat or on one of its messages
+func (a *Account) HandleLabelAssignEndpoint(w http.ResponseWriter, r *http.Request, messages bool) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+
+	// Parse the request body
+	var req LabelAssignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	labeled := req.Labeled == nil || *req.Labeled
+	if messages && req.MessageID == "" {
+		http.Error(w, "message_id is required", http.StatusBadRequest)
+		return
+	}
+	if !messages && req.ChatJID == "" {
+		http.Error(w, "chat_jid is required", http.StatusBadRequest)
+		return
+	}
+	chat, err := a.resolveMessageChat(req.MessageID, req.ChatJID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	label, err := a.MessageStore.GetLabel(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load label: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if label == nil {
+		writeLabelResponse(w, http.StatusNotFound, LabelResponse{Message: fmt.Sprintf("Label %s not found", id)})
+		return
+	}
+	if !a.Client.IsConnected() {
+		writeLabelResponse(w, http.StatusServiceUnavailable, LabelResponse{Message: errNotConnected.Error(), Label: label})
+		return
+	}
+
+	patch := appstate.BuildLabelChat(chat, id, labeled)
+	target := chat.String()
+	if messages {
+		patch = appstate.BuildLabelMessage(chat, id, req.MessageID, labeled)
+		target = req.MessageID
+	}
+	if err := a.Client.SendAppState(patch); err != nil {
+		writeLabelResponse(w, http.StatusInternalServerError, LabelResponse{Message: fmt.Sprintf("Failed to update label: %v", err), Label: label})
+		return
+	}
+
+	// Our own patches aren't echoed back, so update the store ourselves
+	if messages {
+		err = a.MessageStore.SetMessageLabel(id, chat.String(), req.MessageID, labeled)
+	} else {
+		err = a.MessageStore.SetChatLabel(id, chat.String(), labeled)
+	}
+	if err != nil {
+		a.Logger.Warnf("Failed to store label %s: %v", id, err)
+	}
+	if label, err = a.MessageStore.GetLabel(id); err != nil || label == nil {
+		a.Logger.Warnf("Failed to reload label %s: %v", id, err)
+	}
+
+	message := fmt.Sprintf("Labeled %s", target)
+	if !labeled {
+		message = fmt.Sprintf("Removed label from %s", target)
+	}
+	writeLabelResponse(w, http.StatusOK, LabelResponse{Success: true, Message: message, Label: label})
+}
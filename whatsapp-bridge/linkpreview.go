@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"golang.org/x/net/html"
+	"google.golang.org/protobuf/proto"
+)
+
+// Limits for fetching link previews, kept small so sends aren't held up
+const (
+	linkPreviewTimeout   = 10 * time.Second
+	maxLinkPreviewPage   = 1 << 20
+	maxLinkPreviewImage  = 5 << 20
+	linkPreviewThumbSize = 300
+)
+
+// First http or https URL in a message
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// LinkPreview holds the page metadata shown under a link
+type LinkPreview struct {
+	URL         string
+	Title       string
+	Description string
+	Thumbnail   []byte
+}
+
+// Find the first URL in a text, trimming punctuation that usually ends a sentence
+func firstURL(text string) string {
+	return strings.TrimRight(linkPattern.FindString(text), ".,;:!?)]}'")
+}
+
+// Fetch a page and read its Open Graph metadata, falling back to the <title>
+// and meta description
+func fetchLinkPreview(rawURL string) (*LinkPreview, error) {
+	client := newPublicHTTPClient(linkPreviewTimeout)
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch page: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch page: %s", resp.Status)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return nil, fmt.Errorf("not an HTML page")
+	}
+
+	meta, title := parsePageMeta(io.LimitReader(resp.Body, maxLinkPreviewPage))
+	preview := &LinkPreview{
+		URL:         rawURL,
+		Title:       firstNonEmpty(meta["og:title"], meta["twitter:title"], title),
+		Description: firstNonEmpty(meta["og:description"], meta["twitter:description"], meta["description"]),
+	}
+	if preview.Title == "" {
+		return nil, fmt.Errorf("page has no title")
+	}
+
+	// Resolve the image against the final URL after redirects
+	if imageURL := firstNonEmpty(meta["og:image"], meta["twitter:image"]); imageURL != "" {
+		if ref, err := resp.Request.URL.Parse(imageURL); err == nil {
+			preview.Thumbnail, _ = fetchThumbnail(client, ref)
+		}
+	}
+	return preview, nil
+}
+
+// Collect <meta> tags and the <title> from the head of a page
+func parsePageMeta(r io.Reader) (map[string]string, string) {
+	meta := make(map[string]string)
+	var title string
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return meta, title
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "meta":
+				var key, content string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				if key != "" && meta[key] == "" {
+					meta[key] = content
+				}
+			case "title":
+				if title == "" && tokenizer.Next() == html.TextToken {
+					title = strings.TrimSpace(string(tokenizer.Text()))
+				}
+			}
+		case html.EndTagToken:
+			// Everything we need lives in the head
+			if token := tokenizer.Token(); token.Data == "head" {
+				return meta, title
+			}
+		}
+	}
+}
+
+// Download a preview image and shrink it into a small JPEG thumbnail
+func fetchThumbnail(client *http.Client, ref *url.URL) ([]byte, error) {
+	if ref.Scheme != "http" && ref.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported image URL")
+	}
+	resp, err := client.Get(ref.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch image: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxLinkPreviewImage))
+	if err != nil {
+		return nil, err
+	}
+	return jpegThumbnail(data, linkPreviewThumbSize)
+}
+
+// Decode an image and re-encode it as a JPEG no larger than maxSize on either side
+func jpegThumbnail(data []byte, maxSize int) ([]byte, error) {
+	src, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+	if width > maxSize || height > maxSize {
+		if width > height {
+			width, height = maxSize, height*maxSize/width
+		} else {
+			width, height = width*maxSize/height, maxSize
+		}
+		width, height = max(width, 1), max(height, 1)
+	}
+
+	// Nearest-neighbour scaling is plenty for a thumbnail
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dst.Set(x, y, src.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Return the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Build a text message, attaching a preview of its first link when asked to.
+// Preview failures are logged and the text is sent without one.
+func (a *Account) buildTextMessage(body string, withPreview bool) *waProto.Message {
+	link := firstURL(body)
+	if !withPreview || link == "" {
+		return &waProto.Message{Conversation: proto.String(body)}
+	}
+
+	preview, err := fetchLinkPreview(link)
+	if err != nil {
+		a.Logger.Warnf("Failed to build link preview for %s: %v", link, err)
+		return &waProto.Message{Conversation: proto.String(body)}
+	}
+
+	extended := &waProto.ExtendedTextMessage{
+		Text:        proto.String(body),
+		MatchedText: proto.String(preview.URL),
+		Title:       proto.String(preview.Title),
+		PreviewType: waProto.ExtendedTextMessage_NONE.Enum(),
+	}
+	if preview.Description != "" {
+		extended.Description = proto.String(preview.Description)
+	}
+	if len(preview.Thumbnail) > 0 {
+		extended.JPEGThumbnail = preview.Thumbnail
+	}
+	return &waProto.Message{ExtendedTextMessage: extended}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Header of a PNG declaring a width x height canvas, with no pixel data
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12], ihdr[13] = 8, 2 // 8-bit RGB
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(13))
+	buf.Write(ihdr)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return buf.Bytes()
+}
+
+func TestJPEGThumbnailRefusesHugeCanvas(t *testing.T) {
+	if _, err := jpegThumbnail(pngHeader(100000, 100000), linkPreviewThumbSize); err == nil || !strings.Contains(err.Error(), "megapixels") {
+		t.Errorf("thumbnailing a 100000x100000 image gave %v, want a refusal", err)
+	}
+	if _, err := squareProfilePhoto(pngHeader(100000, 100000)); err == nil || !strings.Contains(err.Error(), "megapixels") {
+		t.Errorf("cropping a 100000x100000 image gave %v, want a refusal", err)
+	}
+}
+
+func TestFetchLinkPreviewRefusesLocalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>internal</title></head></html>"))
+	}))
+	defer server.Close()
+
+	if _, err := fetchLinkPreview(server.URL); err == nil || !strings.Contains(err.Error(), "not a public address") {
+		t.Errorf("previewing %s gave %v, want a refusal", server.URL, err)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Live location durations offered by WhatsApp range from 15 minutes to 8 hours
+const (
+	defaultLiveLocationDuration = 15 * time.Minute
+	maxLiveLocationDuration     = 8 * time.Hour
+)
+
+// SendLocationRequest represents the request body for the location message API
+type SendLocationRequest struct {
+	Recipient string   `json:"recipient"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Name      string   `json:"name"`
+	Address   string   `json:"address"`
+	// Live starts live location sharing for DurationSeconds
+	Live            bool   `json:"live"`
+	DurationSeconds int    `json:"duration_seconds"`
+	Caption         string `json:"caption"`
+	QuotedMessageID string `json:"quoted_message_id"`
+}
+
+// UpdateLiveLocationRequest represents the request body for live location updates
+type UpdateLiveLocationRequest struct {
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+}
+
+// LiveLocationSession is a live location being shared with a chat
+type LiveLocationSession struct {
+	ID        string    `json:"id"`
+	ChatJID   string    `json:"chat_jid"`
+	Caption   string    `json:"caption,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Sequence  int64     `json:"sequence"`
+	// Last position sent
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	chat      types.JID
+}
+
+// The update carrying a session's current position
+func (s *LiveLocationSession) update() *waProto.Message {
+	return &waProto.Message{LiveLocationMessage: &waProto.LiveLocationMessage{
+		DegreesLatitude:  proto.Float64(s.Latitude),
+		DegreesLongitude: proto.Float64(s.Longitude),
+		Caption:          proto.String(s.Caption),
+		SequenceNumber:   proto.Int64(s.Sequence),
+		TimeOffset:       proto.Uint32(uint32(time.Since(s.StartedAt).Seconds())),
+	}}
+}
+
+// LiveLocationTracker keeps the live locations an account is sharing
+type LiveLocationTracker struct {
+	mu       sync.Mutex
+	sessions map[string]*LiveLocationSession
+}
+
+// Create an empty tracker
+func NewLiveLocationTracker() *LiveLocationTracker {
+	return &LiveLocationTracker{sessions: make(map[string]*LiveLocationSession)}
+}
+
+// Start tracking a session, stopping it automatically when it expires and
+// passing its final state to expired
+func (t *LiveLocationTracker) Start(session *LiveLocationSession, expired func(*LiveLocationSession)) {
+	t.mu.Lock()
+	t.sessions[session.ID] = session
+	t.mu.Unlock()
+
+	time.AfterFunc(time.Until(session.ExpiresAt), func() {
+		if final := t.Stop(session.ID); final != nil {
+			expired(final)
+		}
+	})
+}
+
+// Move an active session to a new position and its next sequence number
+func (t *LiveLocationTracker) Next(id string, latitude, longitude float64) (*LiveLocationSession, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	session, ok := t.sessions[id]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return nil, fmt.Errorf("live location %s is not being shared", id)
+	}
+	session.Sequence++
+	session.Latitude, session.Longitude = latitude, longitude
+	copied := *session
+	return &copied, nil
+}
+
+// Stop a session, returning its state advanced for a final update, or nil
+// if it wasn't active
+func (t *LiveLocationTracker) Stop(id string) *LiveLocationSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	session, ok := t.sessions[id]
+	if !ok {
+		return nil
+	}
+	delete(t.sessions, id)
+	session.Sequence++
+	copied := *session
+	return &copied
+}
+
+// List active sessions, most recent first
+func (t *LiveLocationTracker) List() []LiveLocationSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sessions := []LiveLocationSession{}
+	for _, session := range t.sessions {
+		sessions = append(sessions, *session)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].StartedAt.After(sessions[j].StartedAt) })
+	return sessions
+}
+
+// Send the last update of a live location share. WhatsApp's messages have no
+// field that ends a share early, so its bubble stays live at this position
+// until recipients stop hearing from it.
+func (a *Account) finishLiveLocation(session *LiveLocationSession) (whatsmeow.SendResponse, error) {
+	resp, err := a.sendMessage(session.chat, session.update())
+	if err != nil {
+		a.Logger.Warnf("Failed to send the final update of live location %s: %v", session.ID, err)
+	}
+	return resp, err
+}
+
+// Check that coordinates were given and are on the globe
+func validateCoordinates(latitude, longitude *float64) error {
+	if latitude == nil || longitude == nil {
+		return fmt.Errorf("latitude and longitude are required")
+	}
+	if *latitude < -90 || *latitude > 90 {
+		return fmt.Errorf("latitude must be between -90 and 90")
+	}
+	if *longitude < -180 || *longitude > 180 {
+		return fmt.Errorf("longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// Handle POST /api/messages/location
+func (a *Account) HandleSendLocationEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var req SendLocationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	to, err := parseRecipient(req.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCoordinates(req.Latitude, req.Longitude); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	if duration == 0 {
+		duration = defaultLiveLocationDuration
+	}
+	if req.Live && (duration < 0 || duration > maxLiveLocationDuration) {
+		http.Error(w, fmt.Sprintf("Duration must be at most %d seconds", int(maxLiveLocationDuration.Seconds())), http.StatusBadRequest)
+		return
+	}
+
+	msg := &waProto.Message{}
+	if req.Live {
+		// The message has no field for the duration, so the bridge keeps it
+		// and sends the final update when it runs out
+		msg.LiveLocationMessage = &waProto.LiveLocationMessage{
+			DegreesLatitude:  req.Latitude,
+			DegreesLongitude: req.Longitude,
+			Caption:          proto.String(req.Caption),
+			SequenceNumber:   proto.Int64(0),
+		}
+	} else {
+		msg.LocationMessage = &waProto.LocationMessage{
+			DegreesLatitude:  req.Latitude,
+			DegreesLongitude: req.Longitude,
+			Name:             proto.String(req.Name),
+			Address:          proto.String(req.Address),
+		}
+	}
+	if req.QuotedMessageID != "" {
+		applyContextInfo(msg, a.quoteContext(to, req.QuotedMessageID))
+	}
+
+	// Live locations are only worth sharing while they are current, so only
+	// static pins wait in the outbox
+	var resp whatsmeow.SendResponse
+	if req.Live {
+		resp, err = a.sendMessage(to, msg)
+	} else {
+		resp, err = a.sendOrQueue(to, msg)
+	}
+	if err == nil && req.Live {
+		a.LiveLocations.Start(&LiveLocationSession{
+			ID:        resp.ID,
+			ChatJID:   to.String(),
+			Caption:   req.Caption,
+			StartedAt: resp.Timestamp,
+			ExpiresAt: resp.Timestamp.Add(duration),
+			Latitude:  *req.Latitude,
+			Longitude: *req.Longitude,
+			chat:      to,
+		}, func(session *LiveLocationSession) { a.finishLiveLocation(session) })
+	}
+	writeSendResult(w, to, resp, err)
+}
+
+// Handle GET /api/messages/location/live
+func (a *Account) HandleLiveLocationsEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.LiveLocations.List())
+}
+
+// Handle PUT (update position) and DELETE (stop sharing) on /api/messages/location/live/{id}
+func (a *Account) HandleLiveLocationEndpoint(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodPut:
+		// Parse the request body
+		var req UpdateLiveLocationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if err := validateCoordinates(req.Latitude, req.Longitude); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		session, err := a.LiveLocations.Next(id, *req.Latitude, *req.Longitude)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		resp, err := a.sendMessage(session.chat, session.update())
+		writeSendResult(w, session.chat, resp, err)
+
+	case http.MethodDelete:
+		// Stopping sends a final update at the last position, then no more
+		w.Header().Set("Content-Type", "application/json")
+		session := a.LiveLocations.Stop(id)
+		if session == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(SendResult{
+				Success: false,
+				Message: fmt.Sprintf("Live location %s is not being shared", id),
+			})
+			return
+		}
+		if _, err := a.finishLiveLocation(session); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendResult{
+				Success: false,
+				Message: fmt.Sprintf("Live location sharing stopped, but its final update failed: %v", err),
+				ID:      id,
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(SendResult{
+			Success: true,
+			Message: "Live location sharing stopped",
+			ID:      id,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
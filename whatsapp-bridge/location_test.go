@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLiveLocationEndsWithFinalUpdate(t *testing.T) {
+	tracker := NewLiveLocationTracker()
+	now := time.Now()
+	tracker.Start(&LiveLocationSession{ID: "l1", StartedAt: now, ExpiresAt: now.Add(time.Hour), Latitude: 1, Longitude: 2}, func(*LiveLocationSession) {
+		t.Error("a stopped share expired")
+	})
+	if _, err := tracker.Next("l1", 3, 4); err != nil {
+		t.Fatal(err)
+	}
+	final := tracker.Stop("l1")
+	if final == nil {
+		t.Fatal("the share wasn't active")
+	}
+	update := final.update().GetLiveLocationMessage()
+	if update.GetSequenceNumber() != 2 || update.GetDegreesLatitude() != 3 || update.GetDegreesLongitude() != 4 {
+		t.Errorf("final update is %v, want sequence 2 at the last position", update)
+	}
+	if tracker.Stop("l1") != nil {
+		t.Error("a share stopped twice")
+	}
+
+	expired := make(chan *LiveLocationSession, 1)
+	tracker.Start(&LiveLocationSession{ID: "l2", StartedAt: now, ExpiresAt: time.Now().Add(10 * time.Millisecond), Latitude: 5}, func(session *LiveLocationSession) {
+		expired <- session
+	})
+	select {
+	case session := <-expired:
+		if session.Sequence != 1 || session.Latitude != 5 {
+			t.Errorf("expired share is %+v, want its final update", session)
+		}
+	case <-time.After(time.Second):
+		t.Error("the share never expired")
+	}
+}
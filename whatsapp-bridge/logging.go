@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Log output formats
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// Header carrying the ID that ties log lines to a request
+const requestIDHeader = "X-Request-ID"
+
+// Request IDs taken from clients are used as log values, so keep them simple
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Minimum level logged, changed at runtime through /api/log-level
+var logLevel = new(slog.LevelVar)
+
+// Send every log line, including whatsmeow's, through one slog handler
+func setupLogging(cfg *Config) {
+	level, err := parseLogLevel(cfg.LogLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --log-level %q, falling back to info\n", cfg.LogLevel)
+	}
+	logLevel.Set(level)
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	// os.Stdout is stderr by now in stdio mode
+	var handler slog.Handler = slog.NewJSONHandler(os.Stdout, opts)
+	if cfg.LogFormat == LogFormatText {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// Parse debug, info, warn or error
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return slog.LevelInfo, err
+	}
+	return level, nil
+}
+
+// Logger for code that doesn't belong to an account
+var bridgeLog = newLogger("Bridge")
+
+// slogLogger adapts slog to the logger interface whatsmeow and the bridge
+// use, keeping the module as an attribute instead of a prefix. It writes to
+// the default slog logger at the time of each line, so loggers created
+// before setupLogging still follow it.
+type slogLogger struct {
+	module string
+	attrs  []any
+}
+
+// Create a logger for a module
+func newLogger(module string) waLog.Logger {
+	return &slogLogger{module: module}
+}
+
+func (l *slogLogger) log(level slog.Level, msg string, args []interface{}) {
+	ctx := context.Background()
+	logger := slog.Default()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	logger.Log(ctx, level, fmt.Sprintf(msg, args...), append([]any{"module", l.module}, l.attrs...)...)
+}
+
+func (l *slogLogger) Errorf(msg string, args ...interface{}) { l.log(slog.LevelError, msg, args) }
+func (l *slogLogger) Warnf(msg string, args ...interface{})  { l.log(slog.LevelWarn, msg, args) }
+func (l *slogLogger) Infof(msg string, args ...interface{})  { l.log(slog.LevelInfo, msg, args) }
+func (l *slogLogger) Debugf(msg string, args ...interface{}) { l.log(slog.LevelDebug, msg, args) }
+
+func (l *slogLogger) Sub(module string) waLog.Logger {
+	return &slogLogger{module: l.module + "/" + module, attrs: l.attrs}
+}
+
+// Copy of a logger that adds attributes to every line
+func withLogAttrs(logger waLog.Logger, args ...any) waLog.Logger {
+	l, ok := logger.(*slogLogger)
+	if !ok {
+		return logger
+	}
+	return &slogLogger{module: l.module, attrs: append(l.attrs[:len(l.attrs):len(l.attrs)], args...)}
+}
+
+type requestIDContextKey struct{}
+
+// ID of the request a context belongs to, empty outside requests
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// Copy of an account whose logger tags every line with the request and
+// trace IDs, so handlers and the sends they make can be traced back to the
+// request. Its spans join the request's trace.
+func (a *Account) forRequest(r *http.Request) *Account {
+	id := requestID(r.Context())
+	trace := spanContextFrom(r.Context())
+	if id == "" && !trace.valid() {
+		return a
+	}
+	scoped := *a
+	if id != "" {
+		scoped.Logger = withLogAttrs(scoped.Logger, "request_id", id)
+	}
+	if trace.valid() {
+		scoped.Logger = withLogAttrs(scoped.Logger, "trace_id", hex.EncodeToString(trace.TraceID[:]))
+		scoped.traceParent = trace
+	}
+	return &scoped
+}
+
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *loggingResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijacking not supported")
+	}
+	return hijacker.Hijack()
+}
+
+// requestIDMiddleware gives every request an ID, taken from X-Request-ID
+// when the client sent a usable one, and logs the request once it is done.
+// Requests MCP tools make internally inherit the ID of the /mcp call.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newJobID()
+		}
+		r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id))
+		w.Header().Set(requestIDHeader, id)
+
+		started := time.Now()
+		recorder := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		// Reads are frequent enough to drown everything else out
+		level := slog.LevelInfo
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			level = slog.LevelDebug
+		}
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelWarn
+		}
+		slog.Log(r.Context(), level, "Handled request",
+			"module", "HTTP",
+			"request_id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration_ms", time.Since(started).Milliseconds(),
+		)
+	})
+}
+
+// LogLevelRequest represents the request body for changing the log level
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// LogLevelResponse represents the response for the log level API
+type LogLevelResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	Level   string `json:"level"`
+}
+
+// Handle GET and PUT /api/log-level
+func handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(LogLevelResponse{Success: true, Level: strings.ToLower(logLevel.Level().String())})
+
+	case http.MethodPut, http.MethodPost:
+		var req LogLevelRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(LogLevelResponse{Success: false, Message: "Invalid request format", Level: strings.ToLower(logLevel.Level().String())})
+			return
+		}
+		level, err := parseLogLevel(req.Level)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(LogLevelResponse{Success: false, Message: "Level must be debug, info, warn or error", Level: strings.ToLower(logLevel.Level().String())})
+			return
+		}
+		previous := logLevel.Level()
+		logLevel.Set(level)
+		slog.Warn("Changed log level", "module", "HTTP", "request_id", requestID(r.Context()), "from", previous.String(), "to", level.String())
+		json.NewEncoder(w).Encode(LogLevelResponse{Success: true, Message: "Log level changed", Level: strings.ToLower(level.String())})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
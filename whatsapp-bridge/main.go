@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"encoding/binary"
 	"encoding/json"
@@ -18,17 +19,14 @@ import (
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/mdp/qrterminal"
 
 	"bytes"
 
 	"go.mau.fi/whatsmeow"
 	waProto "go.mau.fi/whatsmeow/binary/proto"
-	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
 	waLog "go.mau.fi/whatsmeow/util/log"
-	"google.golang.org/protobuf/proto"
 )
 
 // Message represents a chat message for our client
@@ -39,58 +37,107 @@ type Message struct {
 	IsFromMe  bool
 	MediaType string
 	Filename  string
+	MessageDetails
+}
+
+// Columns read into MessageDetails; older rows have NULLs there
+const messageDetailColumns = `COALESCE(message_type, ''), COALESCE(caption, ''), COALESCE(mime_type, ''),
+	COALESCE(quoted_message_id, ''), COALESCE(quoted_sender, ''), COALESCE(push_name, ''), COALESCE(is_forwarded, 0),
+	COALESCE(duration_seconds, 0), waveform, COALESCE(width, 0), COALESCE(height, 0), COALESCE(forwarding_score, 0),
+	COALESCE(is_animated, 0), COALESCE(sender_jid, '')`
+
+// Scan targets matching messageDetailColumns
+func (d *MessageDetails) scanTargets() []interface{} {
+	return []interface{}{&d.Type, &d.Caption, &d.MimeType, &d.QuotedMessageID, &d.QuotedSender, &d.PushName, &d.IsForwarded,
+		&d.Seconds, &d.Waveform, &d.Width, &d.Height, &d.ForwardingScore, &d.IsAnimated, &d.SenderJID}
 }
 
 // Database handler for storing message history
 type MessageStore struct {
-	db *sql.DB
+	db  *DB
+	dir string
+	// Whether download keys of view-once media are kept
+	keepViewOnceMedia bool
+	// Whether the FTS5 index is available for search
+	searchIndexed bool
 }
 
-// Initialize message store
-func NewMessageStore() (*MessageStore, error) {
+// Initialize an account's message store in messages.db in its directory, or
+// in Postgres when --database-url is set
+func NewMessageStore(cfg *Config, accountID, dir string) (*MessageStore, error) {
 	// Create directory for database if it doesn't exist
-	if err := os.MkdirAll("store", 0755); err != nil {
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create store directory: %v", err)
 	}
 
-	// Open SQLite database for messages
-	db, err := sql.Open("sqlite3", "file:store/messages.db?_foreign_keys=on")
+	// Recursive triggers make INSERT OR REPLACE fire delete triggers, which
+	// keeps the SQLite search index in sync.
+	pool, dialect, err := cfg.openAccountDatabase(accountID, dir, "messages.db", "_foreign_keys=on&_recursive_triggers=on")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open message database: %v", err)
 	}
+	db := newDB(pool, dialect)
+	// Readers don't wait on the event handler's writes in WAL mode. The mode is
+	// stored in the file, and setting it here rather than in the DSN keeps it
+	// after the key on encrypted databases.
+	if db.isSQLite() {
+		if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to enable WAL mode: %v", err)
+		}
+	}
 
-	// Create tables if they don't exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS chats (
-			jid TEXT PRIMARY KEY,
-			name TEXT,
-			last_message_time TIMESTAMP
-		);
-		
-		CREATE TABLE IF NOT EXISTS messages (
-			id TEXT,
-			chat_jid TEXT,
-			sender TEXT,
-			content TEXT,
-			timestamp TIMESTAMP,
-			is_from_me BOOLEAN,
-			media_type TEXT,
-			filename TEXT,
-			url TEXT,
-			media_key BLOB,
-			file_sha256 BLOB,
-			file_enc_sha256 BLOB,
-			file_length INTEGER,
-			PRIMARY KEY (id, chat_jid),
-			FOREIGN KEY (chat_jid) REFERENCES chats(jid)
-		);
-	`)
+	// Bring the schema up to date
+	migrator, err := newMessageMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load migrations: %v", err)
+	}
+	applied, err := migrator.Up()
 	if err != nil {
 		db.Close()
-		return nil, fmt.Errorf("failed to create tables: %v", err)
+		return nil, fmt.Errorf("failed to migrate tables: %v", err)
+	}
+	if applied > 0 {
+		bridgeLog.Infof("Migrated the message store of %s to schema version %d", accountID, migrator.Latest())
+	}
+	// Postgres searches with ILIKE
+	searchIndexed := false
+	if db.isSQLite() {
+		if searchIndexed, err = setupSearchIndex(db); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create search index: %v", err)
+		}
+	}
+
+	return &MessageStore{db: db, dir: dir, searchIndexed: searchIndexed}, nil
+}
+
+// Add a column to an existing table unless it is already there
+func addColumnIfMissing(db *DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
 	}
 
-	return &MessageStore{db: db}, nil
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
 }
 
 // Close the database connection
@@ -101,7 +148,8 @@ func (store *MessageStore) Close() error {
 // Store a chat in the database
 func (store *MessageStore) StoreChat(jid, name string, lastMessageTime time.Time) error {
 	_, err := store.db.Exec(
-		"INSERT OR REPLACE INTO chats (jid, name, last_message_time) VALUES (?, ?, ?)",
+		`INSERT INTO chats (jid, name, last_message_time) VALUES (?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET name = excluded.name, last_message_time = excluded.last_message_time`,
 		jid, name, lastMessageTime,
 	)
 	return err
@@ -115,10 +163,16 @@ func (store *MessageStore) StoreMessage(id, chatJID, sender, content string, tim
 		return nil
 	}
 
+	// A message stored again, as history sync and redeliveries do, keeps the
+	// columns the bridge manages itself: stars, downloads and expiry
 	_, err := store.db.Exec(
-		`INSERT OR REPLACE INTO messages 
-		(id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO messages
+		(id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id, chat_jid) DO UPDATE SET sender = excluded.sender, content = excluded.content,
+			timestamp = excluded.timestamp, is_from_me = excluded.is_from_me, media_type = excluded.media_type,
+			filename = excluded.filename, url = excluded.url, media_key = excluded.media_key,
+			file_sha256 = excluded.file_sha256, file_enc_sha256 = excluded.file_enc_sha256, file_length = excluded.file_length`,
 		id, chatJID, sender, content, timestamp, isFromMe, mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength,
 	)
 	return err
@@ -127,7 +181,7 @@ func (store *MessageStore) StoreMessage(id, chatJID, sender, content string, tim
 // Get messages from a chat
 func (store *MessageStore) GetMessages(chatJID string, limit int) ([]Message, error) {
 	rows, err := store.db.Query(
-		"SELECT sender, content, timestamp, is_from_me, media_type, filename FROM messages WHERE chat_jid = ? ORDER BY timestamp DESC LIMIT ?",
+		"SELECT sender, content, timestamp, is_from_me, media_type, filename, "+messageDetailColumns+" FROM messages WHERE chat_jid = ? ORDER BY timestamp DESC LIMIT ?",
 		chatJID, limit,
 	)
 	if err != nil {
@@ -139,7 +193,8 @@ func (store *MessageStore) GetMessages(chatJID string, limit int) ([]Message, er
 	for rows.Next() {
 		var msg Message
 		var timestamp time.Time
-		err := rows.Scan(&msg.Sender, &msg.Content, &timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename)
+		dest := append([]interface{}{&msg.Sender, &msg.Content, &timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename}, msg.scanTargets()...)
+		err := rows.Scan(dest...)
 		if err != nil {
 			return nil, err
 		}
@@ -150,6 +205,29 @@ func (store *MessageStore) GetMessages(chatJID string, limit int) ([]Message, er
 	return messages, nil
 }
 
+// Flag a stored message as view-once
+func (store *MessageStore) MarkViewOnce(id, chatJID string) error {
+	_, err := store.db.Exec("UPDATE messages SET is_view_once = 1 WHERE id = ? AND chat_jid = ?", id, chatJID)
+	return err
+}
+
+// Get a single message by ID
+func (store *MessageStore) GetMessage(id, chatJID string) (*Message, error) {
+	var msg Message
+	var mediaType, filename sql.NullString
+	dest := append([]interface{}{&msg.Sender, &msg.Content, &msg.Time, &msg.IsFromMe, &mediaType, &filename}, msg.scanTargets()...)
+	err := store.db.QueryRow(
+		"SELECT sender, content, timestamp, is_from_me, media_type, filename, "+messageDetailColumns+" FROM messages WHERE id = ? AND chat_jid = ?",
+		id, chatJID,
+	).Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	msg.MediaType = mediaType.String
+	msg.Filename = filename.String
+	return &msg, nil
+}
+
 // Get all chats
 func (store *MessageStore) GetChats() (map[string]time.Time, error) {
 	rows, err := store.db.Query("SELECT jid, last_message_time FROM chats ORDER BY last_message_time DESC")
@@ -183,192 +261,22 @@ func extractTextContent(msg *waProto.Message) string {
 		return text
 	} else if extendedText := msg.GetExtendedTextMessage(); extendedText != nil {
 		return extendedText.GetText()
+	} else if reply := interactiveReply(msg); reply != nil {
+		// Button and list replies read as the choice that was picked
+		return reply.SelectedText
 	}
 
 	// For now, we're ignoring non-text messages
 	return ""
 }
 
-// SendMessageResponse represents the response for the send message API
-type SendMessageResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-}
-
 // SendMessageRequest represents the request body for the send message API
 type SendMessageRequest struct {
 	Recipient string `json:"recipient"`
 	Message   string `json:"message"`
 	MediaPath string `json:"media_path,omitempty"`
-}
-
-// Function to send a WhatsApp message
-func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message string, mediaPath string) (bool, string) {
-	if !client.IsConnected() {
-		return false, "Not connected to WhatsApp"
-	}
-
-	// Create JID for recipient
-	var recipientJID types.JID
-	var err error
-
-	// Check if recipient is a JID
-	isJID := strings.Contains(recipient, "@")
-
-	if isJID {
-		// Parse the JID string
-		recipientJID, err = types.ParseJID(recipient)
-		if err != nil {
-			return false, fmt.Sprintf("Error parsing JID: %v", err)
-		}
-	} else {
-		// Create JID from phone number
-		recipientJID = types.JID{
-			User:   recipient,
-			Server: "s.whatsapp.net", // For personal chats
-		}
-	}
-
-	msg := &waProto.Message{}
-
-	// Check if we have media to send
-	if mediaPath != "" {
-		// Read media file
-		mediaData, err := os.ReadFile(mediaPath)
-		if err != nil {
-			return false, fmt.Sprintf("Error reading media file: %v", err)
-		}
-
-		// Determine media type and mime type based on file extension
-		fileExt := strings.ToLower(mediaPath[strings.LastIndex(mediaPath, ".")+1:])
-		var mediaType whatsmeow.MediaType
-		var mimeType string
-
-		// Handle different media types
-		switch fileExt {
-		// Image types
-		case "jpg", "jpeg":
-			mediaType = whatsmeow.MediaImage
-			mimeType = "image/jpeg"
-		case "png":
-			mediaType = whatsmeow.MediaImage
-			mimeType = "image/png"
-		case "gif":
-			mediaType = whatsmeow.MediaImage
-			mimeType = "image/gif"
-		case "webp":
-			mediaType = whatsmeow.MediaImage
-			mimeType = "image/webp"
-
-		// Audio types
-		case "ogg":
-			mediaType = whatsmeow.MediaAudio
-			mimeType = "audio/ogg; codecs=opus"
-
-		// Video types
-		case "mp4":
-			mediaType = whatsmeow.MediaVideo
-			mimeType = "video/mp4"
-		case "avi":
-			mediaType = whatsmeow.MediaVideo
-			mimeType = "video/avi"
-		case "mov":
-			mediaType = whatsmeow.MediaVideo
-			mimeType = "video/quicktime"
-
-		// Document types (for any other file type)
-		default:
-			mediaType = whatsmeow.MediaDocument
-			mimeType = "application/octet-stream"
-		}
-
-		// Upload media to WhatsApp servers
-		resp, err := client.Upload(context.Background(), mediaData, mediaType)
-		if err != nil {
-			return false, fmt.Sprintf("Error uploading media: %v", err)
-		}
-
-		fmt.Println("Media uploaded", resp)
-
-		// Create the appropriate message type based on media type
-		switch mediaType {
-		case whatsmeow.MediaImage:
-			msg.ImageMessage = &waProto.ImageMessage{
-				Caption:       proto.String(message),
-				Mimetype:      proto.String(mimeType),
-				URL:           &resp.URL,
-				DirectPath:    &resp.DirectPath,
-				MediaKey:      resp.MediaKey,
-				FileEncSHA256: resp.FileEncSHA256,
-				FileSHA256:    resp.FileSHA256,
-				FileLength:    &resp.FileLength,
-			}
-		case whatsmeow.MediaAudio:
-			// Handle ogg audio files
-			var seconds uint32 = 30 // Default fallback
-			var waveform []byte = nil
-
-			// Try to analyze the ogg file
-			if strings.Contains(mimeType, "ogg") {
-				analyzedSeconds, analyzedWaveform, err := analyzeOggOpus(mediaData)
-				if err == nil {
-					seconds = analyzedSeconds
-					waveform = analyzedWaveform
-				} else {
-					return false, fmt.Sprintf("Failed to analyze Ogg Opus file: %v", err)
-				}
-			} else {
-				fmt.Printf("Not an Ogg Opus file: %s\n", mimeType)
-			}
-
-			msg.AudioMessage = &waProto.AudioMessage{
-				Mimetype:      proto.String(mimeType),
-				URL:           &resp.URL,
-				DirectPath:    &resp.DirectPath,
-				MediaKey:      resp.MediaKey,
-				FileEncSHA256: resp.FileEncSHA256,
-				FileSHA256:    resp.FileSHA256,
-				FileLength:    &resp.FileLength,
-				Seconds:       proto.Uint32(seconds),
-				PTT:           proto.Bool(true),
-				Waveform:      waveform,
-			}
-		case whatsmeow.MediaVideo:
-			msg.VideoMessage = &waProto.VideoMessage{
-				Caption:       proto.String(message),
-				Mimetype:      proto.String(mimeType),
-				URL:           &resp.URL,
-				DirectPath:    &resp.DirectPath,
-				MediaKey:      resp.MediaKey,
-				FileEncSHA256: resp.FileEncSHA256,
-				FileSHA256:    resp.FileSHA256,
-				FileLength:    &resp.FileLength,
-			}
-		case whatsmeow.MediaDocument:
-			msg.DocumentMessage = &waProto.DocumentMessage{
-				Title:         proto.String(mediaPath[strings.LastIndex(mediaPath, "/")+1:]),
-				Caption:       proto.String(message),
-				Mimetype:      proto.String(mimeType),
-				URL:           &resp.URL,
-				DirectPath:    &resp.DirectPath,
-				MediaKey:      resp.MediaKey,
-				FileEncSHA256: resp.FileEncSHA256,
-				FileSHA256:    resp.FileSHA256,
-				FileLength:    &resp.FileLength,
-			}
-		}
-	} else {
-		msg.Conversation = proto.String(message)
-	}
-
-	// Send message
-	_, err = client.SendMessage(context.Background(), recipientJID, msg)
-
-	if err != nil {
-		return false, fmt.Sprintf("Error sending message: %v", err)
-	}
-
-	return true, fmt.Sprintf("Message sent to %s", recipient)
+	// ID of the message this one replies to
+	QuotedMessageID string `json:"quoted_message_id,omitempty"`
 }
 
 // Extract media info from a message
@@ -405,9 +313,24 @@ func extractMediaInfo(msg *waProto.Message) (mediaType string, filename string,
 			doc.GetURL(), doc.GetMediaKey(), doc.GetFileSHA256(), doc.GetFileEncSHA256(), doc.GetFileLength()
 	}
 
+	// Check for sticker message
+	if sticker := msg.GetStickerMessage(); sticker != nil {
+		return "sticker", "sticker_" + time.Now().Format("20060102_150405") + ".webp",
+			sticker.GetURL(), sticker.GetMediaKey(), sticker.GetFileSHA256(), sticker.GetFileEncSHA256(), sticker.GetFileLength()
+	}
+
 	return "", "", "", nil, nil, nil, 0
 }
 
+// Report whether a message was sent as view-once, wrapped or flagged inline
+func isViewOnce(msg *events.Message) bool {
+	if msg.IsViewOnce {
+		return true
+	}
+	m := msg.Message
+	return m.GetImageMessage().GetViewOnce() || m.GetVideoMessage().GetViewOnce() || m.GetAudioMessage().GetViewOnce()
+}
+
 // Handle regular incoming messages with media support
 func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *events.Message, logger waLog.Logger) {
 	// Save message to database
@@ -434,6 +357,12 @@ func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *ev
 		return
 	}
 
+	// View-once media is only kept downloadable if the operator opted in
+	viewOnce := isViewOnce(msg)
+	if viewOnce && !messageStore.keepViewOnceMedia {
+		url, mediaKey, fileSHA256, fileEncSHA256 = "", nil, nil, nil
+	}
+
 	// Store message in database
 	err = messageStore.StoreMessage(
 		msg.Info.ID,
@@ -454,8 +383,24 @@ func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *ev
 	if err != nil {
 		logger.Warnf("Failed to store message: %v", err)
 	} else {
+		if viewOnce {
+			if err := messageStore.MarkViewOnce(msg.Info.ID, chatJID); err != nil {
+				logger.Warnf("Failed to flag view-once message: %v", err)
+			}
+		}
+		if !msg.Info.IsFromMe {
+			if err := messageStore.MarkUnread(msg.Info.ID, chatJID); err != nil {
+				logger.Warnf("Failed to flag message unread: %v", err)
+			}
+		}
+		details := extractMessageDetails(msg.Message)
+		details.PushName = msg.Info.PushName
+		details.SenderJID = msg.Info.Sender.ToNonAD().String()
+		if err := messageStore.StoreMessageDetails(msg.Info.ID, chatJID, details); err != nil {
+			logger.Warnf("Failed to store message details: %v", err)
+		}
+
 		// Log message reception
-		timestamp := msg.Info.Timestamp.Format("2006-01-02 15:04:05")
 		direction := "←"
 		if msg.Info.IsFromMe {
 			direction = "→"
@@ -463,9 +408,12 @@ func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *ev
 
 		// Log based on message type
 		if mediaType != "" {
-			fmt.Printf("[%s] %s %s: [%s: %s] %s\n", timestamp, direction, sender, mediaType, filename, content)
+			if viewOnce {
+				mediaType = "view-once " + mediaType
+			}
+			logger.Infof("%s %s: [%s: %s] %s", direction, sender, mediaType, filename, content)
 		} else if content != "" {
-			fmt.Printf("[%s] %s %s: %s\n", timestamp, direction, sender, content)
+			logger.Infof("%s %s: %s", direction, sender, content)
 		}
 	}
 }
@@ -553,8 +501,35 @@ func (d *MediaDownloader) GetMediaType() whatsmeow.MediaType {
 	return d.MediaType
 }
 
+// Build a downloader for stored media info
+func newMediaDownloader(mediaType, url string, mediaKey, fileSHA256, fileEncSHA256 []byte, fileLength uint64) (*MediaDownloader, error) {
+	var waMediaType whatsmeow.MediaType
+	switch mediaType {
+	case "image", "sticker":
+		waMediaType = whatsmeow.MediaImage
+	case "video":
+		waMediaType = whatsmeow.MediaVideo
+	case "audio":
+		waMediaType = whatsmeow.MediaAudio
+	case "document":
+		waMediaType = whatsmeow.MediaDocument
+	default:
+		return nil, fmt.Errorf("unsupported media type: %s", mediaType)
+	}
+
+	return &MediaDownloader{
+		URL:           url,
+		DirectPath:    extractDirectPathFromURL(url),
+		MediaKey:      mediaKey,
+		FileLength:    fileLength,
+		FileSHA256:    fileSHA256,
+		FileEncSHA256: fileEncSHA256,
+		MediaType:     waMediaType,
+	}, nil
+}
+
 // Function to download media from a message
-func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, messageID, chatJID string) (bool, string, string, string, error) {
+func downloadMedia(accountID string, client *whatsmeow.Client, messageStore *MessageStore, messageID, chatJID string) (bool, string, string, string, error) {
 	// Query the database for the message
 	var mediaType, filename, url string
 	var mediaKey, fileSHA256, fileEncSHA256 []byte
@@ -562,7 +537,7 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 	var err error
 
 	// First, check if we already have this file
-	chatDir := fmt.Sprintf("store/%s", strings.ReplaceAll(chatJID, ":", "_"))
+	chatDir := filepath.Join(messageStore.dir, strings.ReplaceAll(chatJID, ":", "_"))
 	localPath := ""
 
 	// Get media info from the database
@@ -585,6 +560,17 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 		return false, "", "", "", fmt.Errorf("not a media message")
 	}
 
+	// Media downloaded in the background is served from where it was saved
+	if saved, _, err := messageStore.GetLocalMedia(messageID, chatJID); err == nil && saved != "" {
+		if _, err := os.Stat(saved); err == nil {
+			absPath, err := filepath.Abs(saved)
+			if err == nil {
+				messageStore.TouchLocalMedia(messageID, chatJID)
+				return true, mediaType, filename, absPath, nil
+			}
+		}
+	}
+
 	// Create directory for the chat if it doesn't exist
 	if err := os.MkdirAll(chatDir, 0755); err != nil {
 		return false, "", "", "", fmt.Errorf("failed to create chat directory: %v", err)
@@ -607,37 +593,20 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 
 	// If we don't have all the media info we need, we can't download
 	if url == "" || len(mediaKey) == 0 || len(fileSHA256) == 0 || len(fileEncSHA256) == 0 || fileLength == 0 {
+		var viewOnce bool
+		messageStore.db.QueryRow("SELECT is_view_once FROM messages WHERE id = ? AND chat_jid = ?", messageID, chatJID).Scan(&viewOnce)
+		if viewOnce {
+			return false, "", "", "", fmt.Errorf("view-once media is not stored, start the bridge with --store-view-once-media to keep it")
+		}
 		return false, "", "", "", fmt.Errorf("incomplete media information for download")
 	}
 
-	fmt.Printf("Attempting to download media for message %s in chat %s...\n", messageID, chatJID)
-
-	// Extract direct path from URL
-	directPath := extractDirectPathFromURL(url)
+	bridgeLog.Infof("Downloading media of %s in %s", messageID, chatJID)
 
 	// Create a downloader that implements DownloadableMessage
-	var waMediaType whatsmeow.MediaType
-	switch mediaType {
-	case "image":
-		waMediaType = whatsmeow.MediaImage
-	case "video":
-		waMediaType = whatsmeow.MediaVideo
-	case "audio":
-		waMediaType = whatsmeow.MediaAudio
-	case "document":
-		waMediaType = whatsmeow.MediaDocument
-	default:
-		return false, "", "", "", fmt.Errorf("unsupported media type: %s", mediaType)
-	}
-
-	downloader := &MediaDownloader{
-		URL:           url,
-		DirectPath:    directPath,
-		MediaKey:      mediaKey,
-		FileLength:    fileLength,
-		FileSHA256:    fileSHA256,
-		FileEncSHA256: fileEncSHA256,
-		MediaType:     waMediaType,
+	downloader, err := newMediaDownloader(mediaType, url, mediaKey, fileSHA256, fileEncSHA256, fileLength)
+	if err != nil {
+		return false, "", "", "", err
 	}
 
 	// Download the media using whatsmeow client
@@ -645,13 +614,14 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 	if err != nil {
 		return false, "", "", "", fmt.Errorf("failed to download media: %v", err)
 	}
+	bridgeMetrics.MediaDownloaded.Add(float64(len(mediaData)), accountID, mediaType)
 
 	// Save the downloaded media to file
 	if err := os.WriteFile(localPath, mediaData, 0644); err != nil {
 		return false, "", "", "", fmt.Errorf("failed to save media file: %v", err)
 	}
 
-	fmt.Printf("Successfully downloaded %s media to %s (%d bytes)\n", mediaType, absPath, len(mediaData))
+	bridgeLog.Infof("Downloaded %s media to %s (%d bytes)", mediaType, absPath, len(mediaData))
 	return true, mediaType, filename, absPath, nil
 }
 
@@ -675,10 +645,98 @@ func extractDirectPathFromURL(url string) string {
 	return "/" + pathPart
 }
 
+// PairPhoneRequest represents the request body for the phone pairing API
+type PairPhoneRequest struct {
+	PhoneNumber string `json:"phone_number"`
+}
+
+// PairPhoneResponse represents the response for the phone pairing API
+type PairPhoneResponse struct {
+	Success     bool   `json:"success"`
+	Message     string `json:"message"`
+	PairingCode string `json:"pairing_code,omitempty"`
+}
+
 // Start a REST API server to expose the WhatsApp client functionality
-func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port int) {
-	// Handler for sending messages
-	http.HandleFunc("/api/send", func(w http.ResponseWriter, r *http.Request) {
+func startRESTServer(accounts *AccountManager, keys *APIKeyStore, audit *AuditLog, cors *CORSPolicy, drainer *Drainer, backups *Backups, limiter *RateLimiter, api, handler http.Handler, readOnly bool, tlsConfig *tls.Config, port int) *http.Server {
+	// Handlers for managing accounts
+	accounts.registerHandlers()
+
+	// Handlers for managing API keys
+	keys.registerHandlers()
+	audit.registerHandlers()
+
+	// Handlers for managing webhooks
+	accounts.notifier.registerHandlers()
+
+	// Liveness and readiness probes
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", accounts.handleReadyz)
+
+	// Prometheus metrics
+	http.HandleFunc("/metrics", accounts.handleMetrics)
+
+	// Handler for reading and changing the log level
+	http.HandleFunc("/api/log-level", handleLogLevel)
+
+	// Handler for draining the queues without exiting
+	http.HandleFunc("/api/admin/drain", drainer.HandleDrainEndpoint)
+
+	// Handler for applying the retention policy now
+	http.HandleFunc("/api/admin/prune", accounts.HandlePruneEndpoint)
+
+	// Handler for downloading a backup of every database
+	http.HandleFunc("/api/admin/backup", backups.HandleBackupEndpoint)
+
+	// Handler for streaming events over WebSocket
+	http.HandleFunc("/api/events/ws", accounts.notifier.hub.HandleWebSocket)
+
+	// Handler for streaming events as Server-Sent Events
+	http.HandleFunc("/api/events/sse", accounts.notifier.hub.HandleSSE)
+
+	// Handler for MCP clients connecting over Streamable HTTP
+	// Tool calls run with the key the MCP request was authenticated with,
+	// and each one counts against its rate limit
+	http.Handle("/mcp", NewMCPHTTPHandler(NewMCPServer(keys.Middleware(limiter.Middleware(api)), readOnly), accounts.notifier.hub, cors))
+
+	// Handler for the current pairing QR code
+	http.HandleFunc("/api/qr", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.QR.HandleQREndpoint(w, r)
+	}))
+
+	// Server-Sent Events stream of QR rotations and auth state changes
+	http.HandleFunc("/api/qr/stream", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.QR.HandleQRStreamEndpoint(w, r)
+	}))
+
+	// Browser page for pairing without reading JSON
+	http.HandleFunc("/qr.html", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.QR.HandleQRPage(w, r)
+	}))
+
+	// Handler for dropping the current session and pairing again
+	http.HandleFunc("/api/reauth", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.Session.HandleReauthEndpoint(w, r)
+	}))
+
+	// Handler for the connection state machine
+	http.HandleFunc("/api/status", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.Session.HandleStatusEndpoint(w, r)
+	}))
+
+	// Handler for metadata about the linked device
+	http.HandleFunc("/api/device", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.Session.HandleDeviceEndpoint(w, r)
+	}))
+
+	// Handler for unlinking the device
+	http.HandleFunc("/api/session", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.Session.HandleSessionEndpoint(w, r)
+	}))
+
+	// Handler for linking by phone number instead of scanning the QR code
+	http.HandleFunc("/api/pair-phone", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		client := account.Client
 		// Only allow POST requests
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -686,45 +744,408 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 		}
 
 		// Parse the request body
-		var req SendMessageRequest
+		var req PairPhoneRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid request format", http.StatusBadRequest)
 			return
 		}
 
 		// Validate request
-		if req.Recipient == "" {
-			http.Error(w, "Recipient is required", http.StatusBadRequest)
+		if req.PhoneNumber == "" {
+			http.Error(w, "Phone number is required", http.StatusBadRequest)
 			return
 		}
 
-		if req.Message == "" && req.MediaPath == "" {
-			http.Error(w, "Message or media path is required", http.StatusBadRequest)
+		w.Header().Set("Content-Type", "application/json")
+
+		// Pairing codes can only be requested on a connected, not yet paired socket
+		if client.Store.ID != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(PairPhoneResponse{
+				Success: false,
+				Message: "Device is already paired",
+			})
+			return
+		}
+		if !client.IsConnected() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(PairPhoneResponse{
+				Success: false,
+				Message: "Not connected to WhatsApp",
+			})
 			return
 		}
 
-		fmt.Println("Received request to send message", req.Message, req.MediaPath)
-
-		// Send the message
-		success, message := sendWhatsAppMessage(client, req.Recipient, req.Message, req.MediaPath)
-		fmt.Println("Message sent", success, message)
-		// Set response headers
-		w.Header().Set("Content-Type", "application/json")
-
-		// Set appropriate status code
-		if !success {
+		code, err := client.PairPhone(req.PhoneNumber, true, whatsmeow.PairClientChrome, "Chrome (Linux)")
+		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(PairPhoneResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to request pairing code: %v", err),
+			})
+			return
 		}
 
-		// Send response
-		json.NewEncoder(w).Encode(SendMessageResponse{
-			Success: success,
-			Message: message,
+		account.QR.SetPairingCode(req.PhoneNumber, code)
+		account.Logger.Infof("Pairing code for %s: %s", req.PhoneNumber, code)
+
+		json.NewEncoder(w).Encode(PairPhoneResponse{
+			Success:     true,
+			Message:     "Enter this code in WhatsApp under Linked devices > Link with phone number instead",
+			PairingCode: code,
 		})
-	})
+	}))
+
+	// Handler for sending messages
+	http.HandleFunc("/api/send", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleLegacySendEndpoint(w, r)
+	}))
+
+	// Handler for sending text messages with the server-assigned metadata
+	http.HandleFunc("/api/messages/text", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSendTextEndpoint(w, r)
+	}))
+
+	// Handler for sending images, videos, audio and documents
+	http.HandleFunc("/api/messages/media", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSendMediaEndpoint(w, r)
+	}))
+
+	// Handler for sending voice notes, transcoding other audio formats to Ogg/Opus
+	http.HandleFunc("/api/messages/voice", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSendVoiceEndpoint(w, r)
+	}))
+
+	// Handler for sending stickers, converting PNG and JPEG to WebP
+	http.HandleFunc("/api/messages/sticker", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSendStickerEndpoint(w, r)
+	}))
+
+	// Handlers for sending locations and managing live location sharing
+	http.HandleFunc("/api/messages/location", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSendLocationEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/messages/location/live", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleLiveLocationsEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/messages/location/live/{id}", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleLiveLocationEndpoint(w, r)
+	}))
+
+	// Handler for sending contact cards
+	http.HandleFunc("/api/messages/contact", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSendContactEndpoint(w, r)
+	}))
+
+	// Handlers for sending polls and reading their tallies
+	http.HandleFunc("/api/messages/poll", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSendPollEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/polls/{message_id}/results", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandlePollResultsEndpoint(w, r)
+	}))
+
+	// Handlers for sending button and list messages
+	http.HandleFunc("/api/messages/buttons", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSendButtonsEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/messages/list", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSendListEndpoint(w, r)
+	}))
+
+	// Handlers for business catalogs, product and catalog messages, and orders
+	http.HandleFunc("/api/catalog/{jid}", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleCatalogEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/messages/product", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSendProductEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/messages/catalog", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSendCatalogEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/orders/{id}", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleOrderEndpoint(w, r)
+	}))
+
+	// Handler for sending read receipts
+	http.HandleFunc("/api/chats/{jid}/read", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleMarkReadEndpoint(w, r)
+	}))
+
+	// Handler for typing and recording indicators
+	http.HandleFunc("/api/chats/{jid}/typing", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleTypingEndpoint(w, r)
+	}))
+
+	// Handler for listing chats
+	http.HandleFunc("/api/chats", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleChatsEndpoint(w, r)
+	}))
+
+	// Handlers for archiving, pinning and muting chats, synced to the phone
+	http.HandleFunc("/api/chats/{jid}/archive", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleChatStateEndpoint(w, r, ChatActionArchive)
+	}))
+	http.HandleFunc("/api/chats/{jid}/pin", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleChatStateEndpoint(w, r, ChatActionPin)
+	}))
+	http.HandleFunc("/api/chats/{jid}/mute", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleChatStateEndpoint(w, r, ChatActionMute)
+	}))
+
+	// Handler for turning disappearing messages on or off in a chat
+	http.HandleFunc("/api/chats/{jid}/disappearing", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleDisappearingEndpoint(w, r)
+	}))
+
+	// Handler for how long a chat's downloaded media is kept
+	http.HandleFunc("/api/chats/{jid}/media-retention", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleMediaRetentionEndpoint(w, r)
+	}))
+
+	// Handler for paging through a chat's stored messages
+	http.HandleFunc("/api/chats/{jid}/messages", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleChatMessagesEndpoint(w, r)
+	}))
+
+	// Handler for downloading a chat's whole transcript
+	http.HandleFunc("/api/chats/{jid}/export", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleChatExportEndpoint(w, r)
+	}))
+
+	// Handlers for the contact directory, fuzzy contact search and checking numbers are on WhatsApp
+	http.HandleFunc("/api/contacts", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleContactsEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/contacts/check", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleCheckNumbersEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/contacts/search", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSearchContactsEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/contacts/{jid}", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleContactEndpoint(w, r)
+	}))
+
+	// Handler for finding who a number, contact name or group name refers to
+	http.HandleFunc("/api/recipients/resolve", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleResolveRecipientEndpoint(w, r)
+	}))
+
+	// Handler for cached profile pictures of contacts and groups
+	http.HandleFunc("/api/contacts/{jid}/avatar", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleAvatarEndpoint(w, r)
+	}))
+
+	// Handlers for subscribing to and querying contact presence
+	http.HandleFunc("/api/contacts/{jid}/presence", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandlePresenceEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/contacts/{jid}/presence/subscribe", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandlePresenceSubscribeEndpoint(w, r)
+	}))
+
+	// Handlers for blocking contacts and the cached block list
+	http.HandleFunc("/api/contacts/{jid}/block", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleBlockEndpoint(w, r, events.BlocklistChangeActionBlock)
+	}))
+	http.HandleFunc("/api/contacts/{jid}/unblock", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleBlockEndpoint(w, r, events.BlocklistChangeActionUnblock)
+	}))
+	http.HandleFunc("/api/blocklist", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleBlocklistEndpoint(w, r)
+	}))
+
+	// Handlers for the linked account's own profile
+	http.HandleFunc("/api/profile", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleProfileEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/profile/photo", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleProfilePhotoEndpoint(w, r)
+	}))
+
+	// Handlers for listing, creating and leaving groups, their metadata, participants and join requests
+	http.HandleFunc("/api/groups", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleGroupsEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/groups/{jid}", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleGroupEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/groups/{jid}/participants", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleGroupParticipantsEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/groups/{jid}/leave", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleLeaveGroupEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/groups/{jid}/requests", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleGroupRequestsEndpoint(w, r)
+	}))
+
+	// Handlers for group invite links
+	http.HandleFunc("/api/groups/{jid}/invite-link", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleGroupInviteLinkEndpoint(w, r, false)
+	}))
+	http.HandleFunc("/api/groups/{jid}/invite-link/revoke", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleGroupInviteLinkEndpoint(w, r, true)
+	}))
+	http.HandleFunc("/api/groups/join", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleJoinGroupEndpoint(w, r)
+	}))
+
+	// Handlers for communities, their linked groups and announcements
+	http.HandleFunc("/api/communities", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleCommunitiesEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/communities/{jid}/groups", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleCommunityGroupsEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/communities/{jid}/groups/{group}", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleCommunityGroupEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/communities/{jid}/announcements", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleCommunityAnnouncementEndpoint(w, r)
+	}))
+
+	// Handlers for following channels and reading their posts
+	http.HandleFunc("/api/newsletters", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleNewslettersEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/newsletters/follow", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleFollowNewsletterEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/newsletters/{jid}/unfollow", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleUnfollowNewsletterEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/newsletters/{jid}/messages", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleNewsletterMessagesEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/newsletters/{jid}/messages/{server_id}/reaction", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleNewsletterReactionEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/newsletters/{jid}/views", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleNewsletterViewsEndpoint(w, r)
+	}))
+
+	// Handlers for WhatsApp Business labels and labeling chats and messages
+	http.HandleFunc("/api/labels", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleLabelsEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/labels/{id}", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleLabelEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/labels/{id}/chats", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleLabelAssignEndpoint(w, r, false)
+	}))
+	http.HandleFunc("/api/labels/{id}/messages", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleLabelAssignEndpoint(w, r, true)
+	}))
+
+	// Handlers for history sync progress and on-demand backfill
+	http.HandleFunc("/api/sync/status", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSyncStatusEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/sync/request", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSyncRequestEndpoint(w, r)
+	}))
+
+	// Handlers for listing and searching stored messages
+	http.HandleFunc("/api/messages", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleListMessagesEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/messages/search", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleSearchEndpoint(w, r)
+	}))
+
+	// Handler for scheduling text messages and listing scheduled ones
+	http.HandleFunc("/api/messages/schedule", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleScheduleEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/scheduled/{id}", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleScheduledMessageEndpoint(w, r)
+	}))
+
+	// Handler for broadcasting a text message to many recipients
+	http.HandleFunc("/api/messages/broadcast", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleBroadcastEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/broadcasts/{id}", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleBroadcastStatusEndpoint(w, r)
+	}))
+
+	// Handler for inspecting and cancelling messages queued while disconnected
+	http.HandleFunc("/api/outbox", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleOutboxEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/outbox/{id}", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleOutboxEntryEndpoint(w, r)
+	}))
+
+	// Handlers for messages the content policy held and the decisions it made
+	http.HandleFunc("/api/policy/holds", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandlePolicyHoldsEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/policy/holds/{id}", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandlePolicyHoldEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/policy/decisions", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandlePolicyDecisionsEndpoint(w, r)
+	}))
+
+	// Handler for editing or deleting a single message
+	http.HandleFunc("/api/messages/{id}", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleMessageEndpoint(w, r)
+	}))
+
+	// Handler for reacting to a message, an empty emoji removes the reaction
+	http.HandleFunc("/api/messages/{id}/reaction", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleReactionEndpoint(w, r)
+	}))
+
+	// Handler for delivery and read receipts of a message
+	http.HandleFunc("/api/messages/{id}/receipts", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleReceiptsEndpoint(w, r)
+	}))
+
+	// Handler for forwarding a stored message to other chats
+	http.HandleFunc("/api/messages/{id}/forward", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleForwardEndpoint(w, r)
+	}))
+
+	// Handler for starring or unstarring a message on every device
+	http.HandleFunc("/api/messages/{id}/star", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleStarEndpoint(w, r)
+	}))
+
+	// Handler for streaming a message's media, downloading it first if needed
+	http.HandleFunc("/api/messages/{id}/media", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleMessageMediaEndpoint(w, r)
+	}))
+
+	// Handler for the JPEG thumbnail of an image or video message
+	http.HandleFunc("/api/messages/{id}/thumbnail", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleThumbnailEndpoint(w, r)
+	}))
+
+	// Handler for listing starred messages across chats
+	http.HandleFunc("/api/messages/starred", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleStarredMessagesEndpoint(w, r)
+	}))
+
+	// Handler for disk usage of downloaded media by chat and type
+	http.HandleFunc("/api/media/stats", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleMediaStatsEndpoint(w, r)
+	}))
+
+	// Handlers for media messages sent in the background
+	http.HandleFunc("/api/media/jobs", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleMediaJobsEndpoint(w, r)
+	}))
+	http.HandleFunc("/api/media/jobs/{id}", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
+		account.HandleMediaJobEndpoint(w, r)
+	}))
 
 	// Handler for downloading media
-	http.HandleFunc("/api/download", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/api/download", accounts.WithAccount(func(account *Account, w http.ResponseWriter, r *http.Request) {
 		// Only allow POST requests
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -745,7 +1166,7 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 		}
 
 		// Download the media
-		success, mediaType, filename, path, err := downloadMedia(client, messageStore, req.MessageID, req.ChatJID)
+		success, mediaType, filename, path, err := downloadMedia(account.ID, account.Client, account.MessageStore, req.MessageID, req.ChatJID)
 
 		// Set response headers
 		w.Header().Set("Content-Type", "application/json")
@@ -772,154 +1193,201 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 			Filename: filename,
 			Path:     path,
 		})
-	})
+	}))
 
 	// Start the server
 	serverAddr := fmt.Sprintf(":%d", port)
-	fmt.Printf("Starting REST API server on %s...\n", serverAddr)
+	bridgeLog.Infof("Starting REST API server on %s", serverAddr)
 
 	// Run server in a goroutine so it doesn't block
+	server := &http.Server{Addr: serverAddr, Handler: handler, TLSConfig: tlsConfig}
 	go func() {
-		if err := http.ListenAndServe(serverAddr, nil); err != nil {
-			fmt.Printf("REST API server error: %v\n", err)
+		var err error
+		if tlsConfig != nil {
+			// Certificates come from the TLS config
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			bridgeLog.Errorf("REST API server error: %v", err)
 		}
 	}()
+	return server
 }
 
 func main() {
+	// Parse flags and environment
+	cfg := loadConfig()
+	applyHistorySyncConfig(cfg)
+
+	// stdout carries MCP messages in stdio mode, so everything else printed
+	// goes to stderr
+	mcpOut := os.Stdout
+	if cfg.MCPStdio {
+		os.Stdout = os.Stderr
+	}
+
 	// Set up logger
-	logger := waLog.Stdout("Client", "INFO", true)
+	setupLogging(cfg)
+	logger := newLogger("Client")
 	logger.Infof("Starting WhatsApp client...")
 
-	// Create database connection for storing session data
-	dbLog := waLog.Stdout("Database", "INFO", true)
-
-	// Create directory for database if it doesn't exist
-	if err := os.MkdirAll("store", 0755); err != nil {
-		logger.Errorf("Failed to create store directory: %v", err)
+	// Unlock encrypted databases before any is opened
+	if err := configureDatabaseEncryption(cfg); err != nil {
+		logger.Errorf("Failed to set up database encryption: %v", err)
 		return
 	}
 
-	container, err := sqlstore.New("sqlite3", "file:store/whatsapp.db?_foreign_keys=on", dbLog)
-	if err != nil {
-		logger.Errorf("Failed to connect to database: %v", err)
+	// Downgrade the schema for an older build instead of starting
+	if cfg.RollbackTo >= 0 {
+		if err := rollbackMessageStores(cfg, "store", cfg.RollbackTo); err != nil {
+			logger.Errorf("Rollback failed: %v", err)
+		}
 		return
 	}
 
-	// Get device store - This contains session information
-	deviceStore, err := container.GetFirstDevice()
-	if err != nil {
-		if err == sql.ErrNoRows {
-			// No device exists, create one
-			deviceStore = container.NewDevice()
-			logger.Infof("Created new device")
-		} else {
-			logger.Errorf("Failed to get device: %v", err)
+	// Move a bridge from another host without pairing again
+	if cfg.Restore != "" {
+		if err := restoreBackup(cfg, "store", cfg.Restore); err != nil {
+			logger.Errorf("Restore failed: %v", err)
 			return
 		}
 	}
 
-	// Create client instance
-	client := whatsmeow.NewClient(deviceStore, logger)
-	if client == nil {
-		logger.Errorf("Failed to create WhatsApp client")
+	// Export traces of the send path when a collector is configured
+	var err error
+	tracer, err = NewTracer(cfg)
+	if err != nil {
+		logger.Errorf("Failed to set up tracing: %v", err)
 		return
 	}
+	if tracer != nil {
+		go tracer.Run()
+		defer tracer.Close()
+		logger.Infof("Exporting traces to %s", tracer.endpoint)
+	}
 
-	// Initialize message store
-	messageStore, err := NewMessageStore()
+	// Set up webhook delivery
+	notifier, err := NewWebhookNotifier(cfg, "store")
 	if err != nil {
-		logger.Errorf("Failed to initialize message store: %v", err)
+		logger.Errorf("Failed to initialize webhooks: %v", err)
 		return
 	}
-	defer messageStore.Close()
-
-	// Setup event handling for messages and history sync
-	client.AddEventHandler(func(evt interface{}) {
-		switch v := evt.(type) {
-		case *events.Message:
-			// Process regular messages
-			handleMessage(client, messageStore, v, logger)
-
-		case *events.HistorySync:
-			// Process history sync events
-			handleHistorySync(client, messageStore, v, logger)
-
-		case *events.Connected:
-			logger.Infof("Connected to WhatsApp")
+	defer notifier.Close()
 
-		case *events.LoggedOut:
-			logger.Warnf("Device logged out, please scan QR code to log in again")
-		}
-	})
-
-	// Create channel to track connection success
-	connected := make(chan bool, 1)
+	// Load the API keys that guard the REST API
+	keys, err := NewAPIKeyStore(cfg, "store")
+	if err != nil {
+		logger.Errorf("Failed to initialize API keys: %v", err)
+		return
+	}
+	defer keys.Close()
+	if !keys.Enabled() {
+		logger.Warnf("No API keys configured; anyone who can reach the REST API can use it. Set --api-keys to require one.")
+	}
 
-	// Connect to WhatsApp
-	if client.Store.ID == nil {
-		// No ID stored, this is a new client, need to pair with phone
-		qrChan, _ := client.GetQRChannel(context.Background())
-		err = client.Connect()
-		if err != nil {
-			logger.Errorf("Failed to connect: %v", err)
+	// Record who sent what
+	var audit *AuditLog
+	if cfg.AuditLog {
+		if audit, err = NewAuditLog("store"); err != nil {
+			logger.Errorf("Failed to initialize audit log: %v", err)
 			return
 		}
+		defer audit.Close()
+	}
 
-		// Print QR code for pairing with phone
-		for evt := range qrChan {
-			if evt.Event == "code" {
-				fmt.Println("\nScan this QR code with your WhatsApp app:")
-				qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
-			} else if evt.Event == "success" {
-				connected <- true
-				break
-			}
-		}
+	// Check addresses before keys, then limit each key's request rate. CORS
+	// preflights are answered before the key check as browsers send them
+	// without one.
+	allowlist, err := parseIPAllowlist(cfg.AllowedIPs)
+	if err != nil {
+		logger.Errorf("Invalid --allowed-ips: %v", err)
+		return
+	}
+	cors := newCORSPolicy(cfg)
+	eventUpgrader.CheckOrigin = cors.checkOrigin
+	// HTTP requests and MCP tool calls alike reach the routes through the
+	// read-only check and the audit log
+	api := drainMiddleware(traceMiddleware(audit.Middleware(http.DefaultServeMux)))
+	if cfg.ReadOnly {
+		api = readOnlyMiddleware(api)
+		logger.Infof("Read-only mode: sending and changing endpoints are disabled")
+	}
+	limiter := NewRateLimiter(cfg.RateLimit)
+	handler := requestIDMiddleware(allowlist.Middleware(cors.Middleware(keys.Middleware(limiter.Middleware(api)))))
 
-		// Wait for connection
-		select {
-		case <-connected:
-			fmt.Println("\nSuccessfully connected and authenticated!")
-		case <-time.After(3 * time.Minute):
-			logger.Errorf("Timeout waiting for QR code scan")
-			return
-		}
-	} else {
-		// Already logged in, just connect
-		err = client.Connect()
+	// Open the default account and any additional accounts found in the store
+	accounts, err := NewAccountManager("store", notifier, cfg)
+	if err != nil {
+		logger.Errorf("Failed to initialize accounts: %v", err)
+		return
+	}
+	drainer := NewDrainer(accounts, keys, audit, time.Duration(cfg.DrainTimeout)*time.Second)
+	backups := NewBackups(accounts, keys, audit)
+
+	// Serve HTTPS when a certificate is configured or ACME is enabled
+	var tlsConfig *tls.Config
+	scheme := "http"
+	if cfg.tlsEnabled() {
+		var challenge http.Handler
+		tlsConfig, challenge, err = newServerTLS(cfg, "store")
 		if err != nil {
-			logger.Errorf("Failed to connect: %v", err)
+			logger.Errorf("Failed to set up TLS: %v", err)
 			return
 		}
-		connected <- true
-	}
-
-	// Wait a moment for connection to stabilize
-	time.Sleep(2 * time.Second)
-
-	if !client.IsConnected() {
-		logger.Errorf("Failed to establish stable connection")
-		return
+		scheme = "https"
+		if challenge != nil && cfg.ACMEHTTPAddr != "" {
+			go func() {
+				if err := http.ListenAndServe(cfg.ACMEHTTPAddr, challenge); err != nil {
+					logger.Errorf("ACME challenge server error: %v", err)
+				}
+			}()
+		}
 	}
 
-	fmt.Println("\n✓ Connected to WhatsApp! Type 'help' for commands.")
+	// Start REST API server before pairing so /api/qr is reachable while waiting for a scan
+	server := startRESTServer(accounts, keys, audit, cors, drainer, backups, limiter, api, handler, cfg.ReadOnly, tlsConfig, 8080)
+	logger.Infof("Open %s://localhost:8080/qr.html in a browser to pair", scheme)
 
-	// Start REST API server
-	startRESTServer(client, messageStore, 8080)
+	// Connect every account in the background
+	accounts.StartAll()
 
 	// Create a channel to keep the main goroutine alive
 	exitChan := make(chan os.Signal, 1)
 	signal.Notify(exitChan, syscall.SIGINT, syscall.SIGTERM)
 
-	fmt.Println("REST server is running. Press Ctrl+C to disconnect and exit.")
+	// In stdio mode the MCP client owns the process and closing stdin ends it
+	mcpDone := make(chan struct{})
+	if cfg.MCPStdio {
+		go func() {
+			defer close(mcpDone)
+			if err := NewMCPServer(api, cfg.ReadOnly).ServeStdio(context.Background(), os.Stdin, mcpOut); err != nil {
+				logger.Errorf("MCP stdio failed: %v", err)
+			}
+		}()
+	}
+
+	logger.Infof("REST server is running. Press Ctrl+C to disconnect and exit.")
 
 	// Wait for termination signal
-	<-exitChan
+	select {
+	case <-exitChan:
+	case <-mcpDone:
+	}
+
+	// Let queued messages and webhook retries go out before disconnecting
+	drainer.Drain()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		// Event streams stay open until closed
+		server.Close()
+	}
+	cancel()
 
-	fmt.Println("Disconnecting...")
-	// Disconnect client
-	client.Disconnect()
+	logger.Infof("Disconnecting...")
+	// Disconnect all clients and close their databases
+	accounts.Close()
 }
 
 // GetChatName determines the appropriate name for a chat based on JID and other info
@@ -1005,9 +1473,9 @@ func GetChatName(client *whatsmeow.Client, messageStore *MessageStore, jid types
 	return name
 }
 
-// Handle history sync events
-func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, historySync *events.HistorySync, logger waLog.Logger) {
-	fmt.Printf("Received history sync event with %d conversations\n", len(historySync.Data.Conversations))
+// Handle history sync events, returning how many messages were stored
+func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, historySync *events.HistorySync, logger waLog.Logger) int {
+	logger.Infof("Received history sync event with %d conversations", len(historySync.Data.Conversations))
 
 	syncedCount := 0
 	for _, conversation := range historySync.Data.Conversations {
@@ -1028,6 +1496,23 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 		// Get appropriate chat name by passing the history sync conversation directly
 		name := GetChatName(client, messageStore, jid, chatJID, conversation, "", logger)
 
+		// Pinned, archived and muted state as the phone has it
+		if err := messageStore.SetChatPinned(chatJID, conversation.GetPinned() > 0); err != nil {
+			logger.Warnf("Failed to store chat state: %v", err)
+		}
+		if err := messageStore.SetChatArchived(chatJID, conversation.GetArchived()); err != nil {
+			logger.Warnf("Failed to store chat state: %v", err)
+		}
+		if end := int64(conversation.GetMuteEndTime()); end != 0 {
+			until := time.Time{}
+			if end > 0 {
+				until = muteEndTime(end)
+			}
+			if err := messageStore.SetChatMuted(chatJID, until.IsZero() || until.After(time.Now()), until); err != nil {
+				logger.Warnf("Failed to store chat state: %v", err)
+			}
+		}
+
 		// Process messages
 		messages := conversation.Messages
 		if len(messages) > 0 {
@@ -1082,6 +1567,12 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 
 				// Determine sender
 				var sender string
+				// Only a direct chat says who sent a message without its key;
+				// in groups the sender then stays unknown rather than the group
+				senderJID := jid.ToNonAD()
+				if jid.Server == types.GroupServer {
+					senderJID = types.EmptyJID
+				}
 				isFromMe := false
 				if msg.Message.Key != nil {
 					if msg.Message.Key.FromMe != nil {
@@ -1089,8 +1580,12 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 					}
 					if !isFromMe && msg.Message.Key.Participant != nil && *msg.Message.Key.Participant != "" {
 						sender = *msg.Message.Key.Participant
+						if participant, err := types.ParseJID(sender); err == nil {
+							senderJID = participant.ToNonAD()
+						}
 					} else if isFromMe {
 						sender = client.Store.ID.User
+						senderJID = client.Store.ID.ToNonAD()
 					} else {
 						sender = jid.User
 					}
@@ -1131,6 +1626,12 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 					logger.Warnf("Failed to store history message: %v", err)
 				} else {
 					syncedCount++
+					details := extractMessageDetails(msg.Message.Message)
+					details.PushName = msg.Message.GetPushName()
+					details.SenderJID = senderJID.String()
+					if err := messageStore.StoreMessageDetails(msgID, chatJID, details); err != nil {
+						logger.Warnf("Failed to store message details: %v", err)
+					}
 					// Log successful message storage
 					if mediaType != "" {
 						logger.Infof("Stored message: [%s] %s -> %s: [%s: %s] %s",
@@ -1144,43 +1645,8 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 		}
 	}
 
-	fmt.Printf("History sync complete. Stored %d messages.\n", syncedCount)
-}
-
-// Request history sync from the server
-func requestHistorySync(client *whatsmeow.Client) {
-	if client == nil {
-		fmt.Println("Client is not initialized. Cannot request history sync.")
-		return
-	}
-
-	if !client.IsConnected() {
-		fmt.Println("Client is not connected. Please ensure you are connected to WhatsApp first.")
-		return
-	}
-
-	if client.Store.ID == nil {
-		fmt.Println("Client is not logged in. Please scan the QR code first.")
-		return
-	}
-
-	// Build and send a history sync request
-	historyMsg := client.BuildHistorySyncRequest(nil, 100)
-	if historyMsg == nil {
-		fmt.Println("Failed to build history sync request.")
-		return
-	}
-
-	_, err := client.SendMessage(context.Background(), types.JID{
-		Server: "s.whatsapp.net",
-		User:   "status",
-	}, historyMsg)
-
-	if err != nil {
-		fmt.Printf("Failed to request history sync: %v\n", err)
-	} else {
-		fmt.Println("History sync requested. Waiting for server response...")
-	}
+	logger.Infof("History sync complete. Stored %d messages.", syncedCount)
+	return syncedCount
 }
 
 // analyzeOggOpus tries to extract duration and generate a simple waveform from an Ogg Opus file
@@ -1242,7 +1708,7 @@ func analyzeOggOpus(data []byte) (duration uint32, waveform []byte, err error) {
 					preSkip = binary.LittleEndian.Uint16(pageData[headPos+10 : headPos+12])
 					sampleRate = binary.LittleEndian.Uint32(pageData[headPos+12 : headPos+16])
 					foundOpusHead = true
-					fmt.Printf("Found OpusHead: sampleRate=%d, preSkip=%d\n", sampleRate, preSkip)
+					bridgeLog.Debugf("Found OpusHead: sampleRate=%d, preSkip=%d", sampleRate, preSkip)
 				}
 			}
 		}
@@ -1257,7 +1723,7 @@ func analyzeOggOpus(data []byte) (duration uint32, waveform []byte, err error) {
 	}
 
 	if !foundOpusHead {
-		fmt.Println("Warning: OpusHead not found, using default values")
+		bridgeLog.Warnf("OpusHead not found, using default values")
 	}
 
 	// Calculate duration based on granule position
@@ -1265,11 +1731,11 @@ func analyzeOggOpus(data []byte) (duration uint32, waveform []byte, err error) {
 		// Formula for duration: (lastGranule - preSkip) / sampleRate
 		durationSeconds := float64(lastGranule-uint64(preSkip)) / float64(sampleRate)
 		duration = uint32(math.Ceil(durationSeconds))
-		fmt.Printf("Calculated Opus duration from granule: %f seconds (lastGranule=%d)\n",
+		bridgeLog.Debugf("Calculated Opus duration from granule: %f seconds (lastGranule=%d)",
 			durationSeconds, lastGranule)
 	} else {
 		// Fallback to rough estimation if granule position not found
-		fmt.Println("Warning: No valid granule position found, using estimation")
+		bridgeLog.Warnf("No valid granule position found, using estimation")
 		durationEstimate := float64(len(data)) / 2000.0 // Very rough approximation
 		duration = uint32(durationEstimate)
 	}
@@ -1284,7 +1750,7 @@ func analyzeOggOpus(data []byte) (duration uint32, waveform []byte, err error) {
 	// Generate waveform
 	waveform = placeholderWaveform(duration)
 
-	fmt.Printf("Ogg Opus analysis: size=%d bytes, calculated duration=%d sec, waveform=%d bytes\n",
+	bridgeLog.Debugf("Ogg Opus analysis: size=%d bytes, calculated duration=%d sec, waveform=%d bytes",
 		len(data), duration, len(waveform))
 
 	return duration, waveform, nil
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStoreMessageAgainKeepsBridgeColumns(t *testing.T) {
+	store, err := NewMessageStore(&Config{}, DefaultAccountID, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	chat := "1@s.whatsapp.net"
+	sent := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	expires := sent.Add(24 * time.Hour)
+	if err := store.StoreChat(chat, "A", sent); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StoreMessage("m1", chat, "1", "", sent, false, "image", "", "https://mmg.example", nil, nil, nil, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetLocalMedia("m1", chat, "/media/m1.jpg", 5, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetThumbnail("m1", chat, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetMessageStarred("m1", chat, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetMessageExpiry("m1", chat, expires); err != nil {
+		t.Fatal(err)
+	}
+
+	// History sync delivers the same message again
+	if err := store.StoreMessage("m1", chat, "1", "", sent, false, "image", "", "https://mmg.example/new", nil, nil, nil, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	var starred bool
+	var localPath, url string
+	var localSize int64
+	var thumbnail []byte
+	var expiresAt time.Time
+	err = store.db.QueryRow("SELECT starred, local_path, local_size, thumbnail, expires_at, url FROM messages WHERE id = 'm1'").
+		Scan(&starred, &localPath, &localSize, &thumbnail, &expiresAt, &url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !starred {
+		t.Error("the star was lost")
+	}
+	if localPath != "/media/m1.jpg" || localSize != 5 || len(thumbnail) != 3 {
+		t.Errorf("downloaded media was forgotten: path %q, size %d, thumbnail %d bytes", localPath, localSize, len(thumbnail))
+	}
+	if !expiresAt.Equal(expires) {
+		t.Errorf("expiry is %v, want %v", expiresAt, expires)
+	}
+	if url != "https://mmg.example/new" {
+		t.Errorf("url is %q, want the one stored last", url)
+	}
+}
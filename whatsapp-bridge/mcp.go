@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// MCP protocol versions this server speaks, newest first
+var mcpProtocolVersions = []string{"2025-03-26", "2024-11-05"}
+
+// Largest JSON-RPC message read from stdin
+const mcpMaxMessageSize = 16 << 20
+
+// Most messages in one JSON-RPC batch
+const mcpMaxBatchSize = 32
+
+// JSON-RPC error codes
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+)
+
+// Where a tool argument goes in the REST request it becomes
+const (
+	mcpInPath  = "path"
+	mcpInQuery = "query"
+	mcpInBody  = "body"
+)
+
+// mcpParam is an argument of an MCP tool
+type mcpParam struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+	In          string
+	// Allowed values of a string
+	Enum []string
+}
+
+// mcpTool is an MCP tool backed by one of the bridge's REST endpoints
+type mcpTool struct {
+	Name        string
+	Description string
+	Method      string
+	// Path with {name} placeholders filled from path arguments
+	Path   string
+	Params []mcpParam
+}
+
+// Every tool can target another account
+var mcpAccountParam = mcpParam{Name: "account_id", Type: "string", Description: "Account to use, the default account when omitted", In: mcpInQuery}
+
+// Tools offered over MCP, mirroring the Python MCP server's core tools
+var mcpTools = []mcpTool{
+	{
+		Name: "get_connection_status", Description: "Get whether WhatsApp is paired and connected.",
+		Method: http.MethodGet, Path: "/api/status",
+	},
+	{
+		Name: "get_qr", Description: "Get the QR code to pair WhatsApp, as a string and a base64 PNG.",
+		Method: http.MethodGet, Path: "/api/qr",
+	},
+	{
+		Name: "pair_phone", Description: "Get an 8-character code to link WhatsApp by phone number instead of the QR code.",
+		Method: http.MethodPost, Path: "/api/pair-phone",
+		Params: []mcpParam{
+			{Name: "phone_number", Type: "string", Description: "Phone number with country code", Required: true, In: mcpInBody},
+		},
+	},
+	{
+		Name: "list_accounts", Description: "List the WhatsApp accounts the bridge manages.",
+		Method: http.MethodGet, Path: "/api/accounts",
+	},
+	{
+		Name: "list_chats", Description: "List chats by last activity. Pass next_cursor from the response as cursor for the next page.",
+		Method: http.MethodGet, Path: "/api/chats",
+		Params: []mcpParam{
+			{Name: "q", Type: "string", Description: "Only chats whose name or JID contains this", In: mcpInQuery},
+			{Name: "limit", Type: "integer", Description: "Chats per page", In: mcpInQuery},
+			{Name: "cursor", Type: "string", Description: "The next_cursor of a previous page", In: mcpInQuery},
+			{Name: "archived", Type: "boolean", Description: "Only archived chats, or false for only unarchived ones", In: mcpInQuery},
+		},
+	},
+	{
+		Name: "read_chat_history", Description: "Read a page of a chat's messages, oldest first. Pass before_cursor as before to read further back.",
+		Method: http.MethodGet, Path: "/api/chats/{chat_jid}/messages",
+		Params: []mcpParam{
+			{Name: "chat_jid", Type: "string", Description: "The chat JID or phone number", Required: true, In: mcpInPath},
+			{Name: "limit", Type: "integer", Description: "Messages per page", In: mcpInQuery},
+			{Name: "before", Type: "string", Description: "Read messages older than this cursor", In: mcpInQuery},
+			{Name: "after", Type: "string", Description: "Read messages newer than this cursor", In: mcpInQuery},
+			{Name: "media_only", Type: "boolean", Description: "Only messages carrying media", In: mcpInQuery},
+		},
+	},
+	{
+		Name: "list_messages", Description: "List stored messages matching all the given filters, newest first.",
+		Method: http.MethodGet, Path: "/api/messages",
+		Params: []mcpParam{
+			{Name: "chat_jid", Type: "string", Description: "Only messages in this chat", In: mcpInQuery},
+			{Name: "sender", Type: "string", Description: "Only messages from this phone number", In: mcpInQuery},
+			{Name: "q", Type: "string", Description: "Only messages whose text or caption contains this", In: mcpInQuery},
+			{Name: "after", Type: "string", Description: "Only messages at or after this ISO 8601 time or YYYY-MM-DD date", In: mcpInQuery},
+			{Name: "before", Type: "string", Description: "Only messages before this time or date", In: mcpInQuery},
+			{Name: "limit", Type: "integer", Description: "Messages per page", In: mcpInQuery},
+			{Name: "cursor", Type: "string", Description: "The next_cursor of a previous page", In: mcpInQuery},
+		},
+	},
+	{
+		Name: "search_messages", Description: "Full-text search over stored messages and captions, best matches first. End a word with * to match prefixes.",
+		Method: http.MethodGet, Path: "/api/messages/search",
+		Params: []mcpParam{
+			{Name: "q", Type: "string", Description: "Words to search for", Required: true, In: mcpInQuery},
+			{Name: "chat_jid", Type: "string", Description: "Only search this chat", In: mcpInQuery},
+			{Name: "sender", Type: "string", Description: "Only messages from this phone number", In: mcpInQuery},
+			{Name: "after", Type: "string", Description: "Only messages at or after this ISO 8601 time or YYYY-MM-DD date", In: mcpInQuery},
+			{Name: "before", Type: "string", Description: "Only messages before this time or date", In: mcpInQuery},
+			{Name: "limit", Type: "integer", Description: "Results per page", In: mcpInQuery},
+			{Name: "offset", Type: "integer", Description: "Results to skip", In: mcpInQuery},
+		},
+	},
+	{
+		Name: "search_contacts", Description: "Search contacts by name or phone number, tolerating typos and partial names; best matches first.",
+		Method: http.MethodGet, Path: "/api/contacts/search",
+		Params: []mcpParam{
+			{Name: "q", Type: "string", Description: "Name or number to look for", Required: true, In: mcpInQuery},
+			{Name: "limit", Type: "integer", Description: "Most matches to return", In: mcpInQuery},
+		},
+	},
+	{
+		Name: "get_contact", Description: "Get a contact's names and business verification.",
+		Method: http.MethodGet, Path: "/api/contacts/{jid}",
+		Params: []mcpParam{
+			{Name: "jid", Type: "string", Description: "The contact's JID or phone number", Required: true, In: mcpInPath},
+		},
+	},
+	{
+		Name: "resolve_recipient", Description: "Find which contact or group a phone number or (partial, misspelled) name refers to. resolved is set when the match is clear.",
+		Method: http.MethodGet, Path: "/api/recipients/resolve",
+		Params: []mcpParam{
+			{Name: "q", Type: "string", Description: "Phone number, JID, contact name or group name", Required: true, In: mcpInQuery},
+			{Name: "limit", Type: "integer", Description: "Most candidates to return", In: mcpInQuery},
+		},
+	},
+	{
+		Name: "send_message", Description: "Send a text message to a phone number or JID. Mention people with @phone in the text.",
+		Method: http.MethodPost, Path: "/api/messages/text",
+		Params: []mcpParam{
+			{Name: "recipient", Type: "string", Description: "Phone number or JID to send to", Required: true, In: mcpInBody},
+			{Name: "body", Type: "string", Description: "The message text", Required: true, In: mcpInBody},
+			{Name: "quoted_message_id", Type: "string", Description: "ID of a message to reply to", In: mcpInBody},
+			{Name: "mentioned_jids", Type: "array", Description: "JIDs or phone numbers to mention", In: mcpInBody},
+			{Name: "link_preview", Type: "boolean", Description: "Attach a preview card for the first link", In: mcpInBody},
+		},
+	},
+	{
+		Name: "send_media", Description: "Send an image, video, audio file or document fetched from a URL.",
+		Method: http.MethodPost, Path: "/api/messages/media",
+		Params: []mcpParam{
+			{Name: "recipient", Type: "string", Description: "Phone number or JID to send to", Required: true, In: mcpInBody},
+			{Name: "url", Type: "string", Description: "http(s) URL of the file", Required: true, In: mcpInBody},
+			{Name: "caption", Type: "string", Description: "Text shown with the media", In: mcpInBody},
+			{Name: "type", Type: "string", Description: "Kind of media, inferred from the file when omitted", In: mcpInBody,
+				Enum: []string{MediaKindImage, MediaKindVideo, MediaKindAudio, MediaKindDocument}},
+			{Name: "filename", Type: "string", Description: "Name recipients see for documents", In: mcpInBody},
+			{Name: "quoted_message_id", Type: "string", Description: "ID of a message to reply to", In: mcpInBody},
+		},
+	},
+	{
+		Name: "send_reaction", Description: "React to a message with an emoji, or remove our reaction with an empty emoji.",
+		Method: http.MethodPost, Path: "/api/messages/{message_id}/reaction",
+		Params: []mcpParam{
+			{Name: "message_id", Type: "string", Description: "ID of the message to react to", Required: true, In: mcpInPath},
+			{Name: "chat_jid", Type: "string", Description: "Chat the message is in", Required: true, In: mcpInBody},
+			{Name: "emoji", Type: "string", Description: "The reaction emoji", Required: true, In: mcpInBody},
+		},
+	},
+	{
+		Name: "mark_read", Description: "Send read receipts for messages, or for every unread message in the chat.",
+		Method: http.MethodPost, Path: "/api/chats/{chat_jid}/read",
+		Params: []mcpParam{
+			{Name: "chat_jid", Type: "string", Description: "The chat JID or phone number", Required: true, In: mcpInPath},
+			{Name: "message_ids", Type: "array", Description: "Messages to mark read; all unread ones when omitted", In: mcpInBody},
+		},
+	},
+	{
+		Name: "list_groups", Description: "List the groups we are in, with participant counts and whether we are an admin.",
+		Method: http.MethodGet, Path: "/api/groups",
+	},
+	{
+		Name: "get_group_info", Description: "Get a group's subject, description, settings and participants.",
+		Method: http.MethodGet, Path: "/api/groups/{group_jid}",
+		Params: []mcpParam{
+			{Name: "group_jid", Type: "string", Description: "The group JID, ending in @g.us", Required: true, In: mcpInPath},
+		},
+	},
+	{
+		Name: "create_group", Description: "Create a group with a subject of up to 25 characters.",
+		Method: http.MethodPost, Path: "/api/groups",
+		Params: []mcpParam{
+			{Name: "name", Type: "string", Description: "The group subject", Required: true, In: mcpInBody},
+			{Name: "participants", Type: "array", Description: "Phone numbers or JIDs to add", Required: true, In: mcpInBody},
+		},
+	},
+	{
+		Name: "update_group_participants", Description: "Add, remove, promote or demote participants of a group we administer.",
+		Method: http.MethodPost, Path: "/api/groups/{group_jid}/participants",
+		Params: []mcpParam{
+			{Name: "group_jid", Type: "string", Description: "The group JID, ending in @g.us", Required: true, In: mcpInPath},
+			{Name: "action", Type: "string", Description: "What to do with the participants", Required: true, In: mcpInBody,
+				Enum: []string{"add", "remove", "promote", "demote"}},
+			{Name: "participants", Type: "array", Description: "Phone numbers or JIDs", Required: true, In: mcpInBody},
+			{Name: "send_invites", Type: "boolean", Description: "Invite people whose privacy settings block direct adds", In: mcpInBody},
+		},
+	},
+	{
+		Name: "get_group_invite_link", Description: "Get the invite link of a group we administer.",
+		Method: http.MethodGet, Path: "/api/groups/{group_jid}/invite-link",
+		Params: []mcpParam{
+			{Name: "group_jid", Type: "string", Description: "The group JID, ending in @g.us", Required: true, In: mcpInPath},
+		},
+	},
+}
+
+// JSON schema of a tool's arguments
+func (t mcpTool) inputSchema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, param := range append(t.Params, mcpAccountParam) {
+		property := map[string]interface{}{"type": param.Type, "description": param.Description}
+		if param.Type == "array" {
+			property["items"] = map[string]string{"type": "string"}
+		}
+		if len(param.Enum) > 0 {
+			property["enum"] = param.Enum
+		}
+		properties[param.Name] = property
+		if param.Required {
+			required = append(required, param.Name)
+		}
+	}
+	return map[string]interface{}{"type": "object", "properties": properties, "required": required}
+}
+
+// Build the REST request a tool call stands for
+func (t mcpTool) request(ctx context.Context, args map[string]interface{}) (*http.Request, error) {
+	path := t.Path
+	query := url.Values{}
+	body := map[string]interface{}{}
+	for _, param := range append(t.Params, mcpAccountParam) {
+		value, ok := args[param.Name]
+		if !ok || value == nil {
+			if param.Required {
+				return nil, fmt.Errorf("%s is required", param.Name)
+			}
+			continue
+		}
+		switch param.In {
+		case mcpInPath:
+			path = strings.Replace(path, "{"+param.Name+"}", url.PathEscape(fmt.Sprint(value)), 1)
+		case mcpInQuery:
+			query.Set(param.Name, fmt.Sprint(value))
+		case mcpInBody:
+			body[param.Name] = value
+		}
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if t.Method != http.MethodGet {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, t.Method, path, reader)
+	if err != nil {
+		return nil, err
+	}
+	// Rate limits and the audit log see the MCP client's address
+	req.RemoteAddr, _ = ctx.Value(mcpRemoteAddrContextKey{}).(string)
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// mcpResponseRecorder captures the response of a REST handler called in process
+type mcpResponseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *mcpResponseRecorder) Header() http.Header { return r.header }
+
+func (r *mcpResponseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(data)
+}
+
+func (r *mcpResponseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+// jsonrpcMessage is a JSON-RPC 2.0 request, notification or response
+type jsonrpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// MCPServer answers MCP requests by calling the bridge's REST handlers in
+// process, so MCP clients need nothing but the bridge binary
+type MCPServer struct {
+	handler http.Handler
+	tools   map[string]mcpTool
+	// Hide the tools that send or change anything
+	readOnly bool
+}
+
+// Create an MCP server calling the REST handlers registered on handler,
+// offering only reading tools when readOnly is set
+func NewMCPServer(handler http.Handler, readOnly bool) *MCPServer {
+	tools := make(map[string]mcpTool, len(mcpTools))
+	for _, tool := range mcpTools {
+		tools[tool.Name] = tool
+	}
+	return &MCPServer{handler: handler, tools: tools, readOnly: readOnly}
+}
+
+// Report whether a tool is offered to the client of ctx, which needs the
+// tool's scope if it authenticated with an API key
+func (s *MCPServer) offers(ctx context.Context, tool mcpTool) bool {
+	if s.readOnly && !readOnlyAllows(tool.Method, tool.Path) {
+		return false
+	}
+	scope := scopeFor(tool.Method, tool.Path)
+	key, _ := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	return key == nil || key.allows(scope)
+}
+
+// Answer a JSON-RPC message or batch; nil when nothing needs to be sent back
+func (s *MCPServer) Handle(ctx context.Context, data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(data, &batch); err != nil || len(batch) == 0 {
+			return mustMarshal(jsonrpcFailure(nil, jsonrpcInvalidRequest, "Invalid batch"))
+		}
+		if len(batch) > mcpMaxBatchSize {
+			return mustMarshal(jsonrpcFailure(nil, jsonrpcInvalidRequest, fmt.Sprintf("Batch has more than %d messages", mcpMaxBatchSize)))
+		}
+		responses := []*jsonrpcMessage{}
+		for _, raw := range batch {
+			if response := s.handleMessage(ctx, raw); response != nil {
+				responses = append(responses, response)
+			}
+		}
+		if len(responses) == 0 {
+			return nil
+		}
+		return mustMarshal(responses)
+	}
+	if response := s.handleMessage(ctx, data); response != nil {
+		return mustMarshal(response)
+	}
+	return nil
+}
+
+// Answer a single JSON-RPC message; notifications and responses get no answer
+func (s *MCPServer) handleMessage(ctx context.Context, data []byte) *jsonrpcMessage {
+	var msg jsonrpcMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return jsonrpcFailure(nil, jsonrpcParseError, "Parse error")
+	}
+	if msg.Method == "" || msg.ID == nil {
+		return nil
+	}
+
+	var result interface{}
+	var err *jsonrpcError
+	switch msg.Method {
+	case "initialize":
+		result, err = s.initialize(msg.Params)
+	case "ping", "logging/setLevel":
+		// Message notifications are the only logs sent, at info
+		result = struct{}{}
+	case "tools/list":
+		result = s.listTools(ctx)
+	case "tools/call":
+		result, err = s.callTool(ctx, msg.Params)
+	default:
+		err = &jsonrpcError{Code: jsonrpcMethodNotFound, Message: fmt.Sprintf("Method %s not found", msg.Method)}
+	}
+	if err != nil {
+		return jsonrpcFailure(msg.ID, err.Code, err.Message)
+	}
+	return &jsonrpcMessage{JSONRPC: "2.0", ID: msg.ID, Result: result}
+}
+
+// Agree on a protocol version and describe the server
+func (s *MCPServer) initialize(params json.RawMessage) (interface{}, *jsonrpcError) {
+	var req struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, &jsonrpcError{Code: jsonrpcInvalidParams, Message: "Invalid initialize params"}
+	}
+	// Answer with the client's version when we speak it, otherwise our newest
+	version := mcpProtocolVersions[0]
+	for _, supported := range mcpProtocolVersions {
+		if req.ProtocolVersion == supported {
+			version = supported
+		}
+	}
+	return map[string]interface{}{
+		"protocolVersion": version,
+		"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}, "logging": map[string]interface{}{}},
+		"serverInfo":      map[string]string{"name": "whatsapp-bridge", "version": "1.0.0"},
+		"instructions":    "Read and send WhatsApp messages. Pair first with get_qr or pair_phone if get_connection_status reports no session.",
+	}, nil
+}
+
+// Describe the tools
+func (s *MCPServer) listTools(ctx context.Context) interface{} {
+	tools := make([]map[string]interface{}, 0, len(mcpTools))
+	for _, tool := range mcpTools {
+		if !s.offers(ctx, tool) {
+			continue
+		}
+		tools = append(tools, map[string]interface{}{
+			"name":        tool.Name,
+			"description": tool.Description,
+			"inputSchema": tool.inputSchema(),
+		})
+	}
+	return map[string]interface{}{"tools": tools}
+}
+
+// Run a tool through its REST handler, returning the response body as text
+func (s *MCPServer) callTool(ctx context.Context, params json.RawMessage) (interface{}, *jsonrpcError) {
+	var call struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &call); err != nil {
+		return nil, &jsonrpcError{Code: jsonrpcInvalidParams, Message: "Invalid tools/call params"}
+	}
+	tool, ok := s.tools[call.Name]
+	if !ok || !s.offers(ctx, tool) {
+		return nil, &jsonrpcError{Code: jsonrpcInvalidParams, Message: fmt.Sprintf("Unknown tool %s", call.Name)}
+	}
+
+	req, err := tool.request(withMCPTool(ctx, call.Name), call.Arguments)
+	if err != nil {
+		return mcpToolResult(err.Error(), true), nil
+	}
+	recorder := &mcpResponseRecorder{header: http.Header{}}
+	s.handler.ServeHTTP(recorder, req)
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return mcpToolResult(strings.TrimSpace(recorder.body.String()), recorder.status >= http.StatusBadRequest), nil
+}
+
+// Tool result holding one text block
+func mcpToolResult(text string, isError bool) map[string]interface{} {
+	return map[string]interface{}{
+		"content": []map[string]string{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
+
+func jsonrpcFailure(id json.RawMessage, code int, message string) *jsonrpcMessage {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &jsonrpcMessage{JSONRPC: "2.0", ID: id, Error: &jsonrpcError{Code: code, Message: message}}
+}
+
+func mustMarshal(value interface{}) []byte {
+	data, _ := json.Marshal(value)
+	return data
+}
+
+// Serve MCP over newline delimited JSON-RPC until in is closed. Tool calls
+// are answered concurrently so a slow send doesn't hold up other calls;
+// everything else is answered in order.
+func (s *MCPServer) ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	var writeMu sync.Mutex
+	var pending sync.WaitGroup
+	defer pending.Wait()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), mcpMaxMessageSize)
+	for scanner.Scan() {
+		line := bytes.Clone(scanner.Bytes())
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		respond := func() {
+			if response := s.Handle(ctx, line); response != nil {
+				writeMu.Lock()
+				defer writeMu.Unlock()
+				out.Write(append(response, '\n'))
+			}
+		}
+		var msg struct {
+			Method string `json:"method"`
+		}
+		if json.Unmarshal(line, &msg) != nil || msg.Method != "tools/call" {
+			respond()
+			continue
+		}
+		pending.Add(1)
+		go func() {
+			defer pending.Done()
+			respond()
+		}()
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header carrying the MCP session over Streamable HTTP
+const mcpSessionHeader = "Mcp-Session-Id"
+
+// Sessions unused for longer are forgotten
+const mcpSessionIdleTimeout = time.Hour
+
+// MCPHTTPHandler serves MCP over the Streamable HTTP transport. POST carries
+// client messages and is answered with JSON; GET opens an SSE stream of new
+// message notifications that resumes from Last-Event-ID; DELETE ends the
+// session.
+type MCPHTTPHandler struct {
+	server *MCPServer
+	hub    *EventHub
+	cors   *CORSPolicy
+
+	mu sync.Mutex
+	// Last use of each session
+	sessions map[string]time.Time
+}
+
+// Create a Streamable HTTP handler for an MCP server, streaming events from hub
+// and accepting browsers from the origins cors allows
+func NewMCPHTTPHandler(server *MCPServer, hub *EventHub, cors *CORSPolicy) *MCPHTTPHandler {
+	return &MCPHTTPHandler{server: server, hub: hub, cors: cors, sessions: make(map[string]time.Time)}
+}
+
+// Start a session
+func (h *MCPHTTPHandler) createSession() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sessions[id] = time.Now()
+	return id
+}
+
+// Check the session of a request, noting that it is still in use
+func (h *MCPHTTPHandler) touchSession(r *http.Request) (string, int) {
+	id := r.Header.Get(mcpSessionHeader)
+	if id == "" {
+		return "", http.StatusBadRequest
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for session, lastUsed := range h.sessions {
+		if now.Sub(lastUsed) > mcpSessionIdleTimeout {
+			delete(h.sessions, session)
+		}
+	}
+	if _, ok := h.sessions[id]; !ok {
+		return "", http.StatusNotFound
+	}
+	h.sessions[id] = now
+	return id, 0
+}
+
+// Handle /mcp
+func (h *MCPHTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Browsers on other sites must not reach a local bridge, even through DNS
+	// rebinding, unless --cors-origins lets them
+	if !h.cors.checkOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		h.handlePost(w, r)
+	case http.MethodGet:
+		h.handleStream(w, r)
+	case http.MethodDelete:
+		id, status := h.touchSession(r)
+		if status != 0 {
+			http.Error(w, "Unknown or missing session", status)
+			return
+		}
+		h.mu.Lock()
+		delete(h.sessions, id)
+		h.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Answer the messages of a POST
+func (h *MCPHTTPHandler) handlePost(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, mcpMaxMessageSize))
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	ctx := withMCPRemoteAddr(r.Context(), r.RemoteAddr)
+
+	// initialize starts a session; everything else needs one
+	var msg struct {
+		Method string `json:"method"`
+	}
+	json.Unmarshal(body, &msg)
+	if msg.Method == "initialize" {
+		response := h.server.Handle(ctx, body)
+		w.Header().Set(mcpSessionHeader, h.createSession())
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+		return
+	}
+	if _, status := h.touchSession(r); status != 0 {
+		http.Error(w, "Unknown or missing session", status)
+		return
+	}
+
+	response := h.server.Handle(ctx, body)
+	if response == nil {
+		// Only notifications or responses were sent
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// Stream new WhatsApp messages to a session as log notifications. Event IDs
+// are the event hub's sequence numbers, so a reconnecting client passing
+// Last-Event-ID gets what it missed from the hub's history.
+func (h *MCPHTTPHandler) handleStream(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		http.Error(w, "Accept must include text/event-stream", http.StatusNotAcceptable)
+		return
+	}
+	if _, status := h.touchSession(r); status != 0 {
+		http.Error(w, "Unknown or missing session", status)
+		return
+	}
+	var after uint64
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		var err error
+		if after, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub, backlog := h.hub.Subscribe(EventFilter{Events: []string{WebhookEventMessage}}, after)
+	defer h.hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetryMillis)
+
+	send := func(evt streamEvent) bool {
+		_, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", evt.Seq, mcpMessageNotification(evt.Payload))
+		return err == nil
+	}
+	for _, evt := range backlog {
+		if !send(evt) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case evt := <-sub.frames:
+			if !send(evt) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// Describe a new message as an MCP log notification, like the Python server
+func mcpMessageNotification(payload WebhookPayload) []byte {
+	data := payload.Data
+	text, _ := data["content"].(string)
+	if text == "" {
+		text, _ = data["caption"].(string)
+	}
+	sender, _ := data["push_name"].(string)
+	if sender == "" {
+		sender, _ = data["sender"].(string)
+	}
+	return mustMarshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "notifications/message",
+		"params": map[string]interface{}{
+			"level":  "info",
+			"logger": "whatsapp.messages",
+			"data": map[string]interface{}{
+				"account": payload.AccountID,
+				"chat":    data["chat_jid"],
+				"id":      data["id"],
+				"sender":  sender,
+				"from_me": data["is_from_me"],
+				"text":    text,
+				"media":   data["media_type"],
+				"time":    data["timestamp"],
+			},
+		},
+	})
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Largest attachment accepted for upload or fetched from a URL
+const maxMediaSize = 100 << 20
+
+// Timeout for fetching media from a URL
+const mediaFetchTimeout = 60 * time.Second
+
+// Media kinds accepted by the media API
+const (
+	MediaKindImage    = "image"
+	MediaKindVideo    = "video"
+	MediaKindAudio    = "audio"
+	MediaKindDocument = "document"
+)
+
+// SendMediaRequest represents the JSON request body for the media message API.
+// Multipart requests carry the same fields as form values plus a "file" part.
+type SendMediaRequest struct {
+	Recipient string `json:"recipient"`
+	URL       string `json:"url"`
+	Caption   string `json:"caption"`
+	Filename  string `json:"filename"`
+	Type      string `json:"type"`
+	// ID of the message this one replies to
+	QuotedMessageID string `json:"quoted_message_id"`
+	// Let the recipient open the media only once
+	ViewOnce bool `json:"view_once"`
+	// JIDs or phone numbers to mention in the caption
+	MentionedJIDs []string `json:"mentioned_jids"`
+	// Send images and videos as they are, even when compression or
+	// transcoding is on
+	Original bool `json:"original"`
+	// Send in the background and answer with a job to poll instead of waiting
+	Async bool `json:"async"`
+}
+
+// Attachment is a media file to be uploaded to WhatsApp
+type Attachment struct {
+	Data     []byte
+	Filename string
+	MimeType string
+	Kind     string
+	// Skip image compression and video transcoding
+	Original bool
+	// Loop the video without sound, as WhatsApp shows GIFs
+	GIFPlayback bool
+}
+
+// Detect the MIME type of a file, preferring its extension since content
+// sniffing reports container formats like docx as plain zip
+func detectMimeType(data []byte, filename string) string {
+	mimeType := ""
+	if ext := filepath.Ext(filename); ext != "" {
+		mimeType = mime.TypeByExtension(strings.ToLower(ext))
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+
+	// WhatsApp only plays Ogg audio labelled with the Opus codec
+	if mimeType == "application/ogg" || mimeType == "audio/ogg" {
+		return "audio/ogg; codecs=opus"
+	}
+	return mimeType
+}
+
+// Pick the WhatsApp message type for a MIME type
+func mediaKindForMime(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return MediaKindImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return MediaKindVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return MediaKindAudio
+	default:
+		return MediaKindDocument
+	}
+}
+
+// Build an attachment from raw data, detecting what is not given
+func newAttachment(data []byte, filename, kind string) (*Attachment, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("media file is empty")
+	}
+	mimeType := detectMimeType(data, filename)
+	if kind == "" {
+		kind = mediaKindForMime(mimeType)
+	}
+	switch kind {
+	case MediaKindImage, MediaKindVideo, MediaKindAudio, MediaKindDocument:
+	default:
+		return nil, fmt.Errorf("unsupported media type %q, expected image, video, audio or document", kind)
+	}
+	return &Attachment{Data: data, Filename: filename, MimeType: mimeType, Kind: kind}, nil
+}
+
+// Ranges that aren't public beyond what netip already classifies: "this"
+// network, carrier-grade NAT (home to some cloud metadata services),
+// IETF protocol assignments, benchmarking and NAT64
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// Whether an address is on the public internet, so not the bridge's own
+// host, its network or a link-local metadata service such as 169.254.169.254
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Dialer hook refusing connections to addresses that aren't public. It sees
+// the address after DNS resolution, so a public name resolving to a private
+// address is refused too.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("refusing to connect to %s: %v", address, err)
+	}
+	if !isPublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("refusing to connect to %s: not a public address", addrPort.Addr())
+	}
+	return nil
+}
+
+// HTTP client for URLs an API caller supplies. Every connection, including
+// those of redirects, must go to a public address, and no proxy is used so
+// the check applies to the server itself.
+func newPublicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: dialPublicOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirected to a non-http URL")
+			}
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+}
+
+// Download an attachment from a URL
+func fetchMedia(rawURL string) ([]byte, string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, "", fmt.Errorf("media URL must be an http or https URL")
+	}
+
+	client := newPublicHTTPClient(mediaFetchTimeout)
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch media: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to fetch media: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMediaSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read media: %v", err)
+	}
+	if len(data) > maxMediaSize {
+		return nil, "", fmt.Errorf("media is larger than %d MB", maxMediaSize>>20)
+	}
+
+	// Prefer the server's filename, falling back to the last path segment
+	filename := path.Base(parsed.Path)
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		filename = params["filename"]
+	}
+	if filename == "/" || filename == "." {
+		filename = ""
+	}
+	return data, filename, nil
+}
+
+// Upload an attachment and wrap it in the matching message type
+func (a *Account) buildMediaMessage(att *Attachment, caption string) (*waProto.Message, error) {
+	// WhatsApp has no GIF messages; animations are sent as looping videos
+	if (att.Kind == MediaKindImage || att.Kind == MediaKindVideo) && isAnimatedGIF(att) {
+		if err := convertGIF(att); err != nil {
+			a.Logger.Warnf("Sending GIF as a still image: %v", err)
+			att.Kind = MediaKindImage
+		}
+	}
+	if att.Kind == MediaKindImage && a.ImageCompression.Enabled && !att.Original {
+		size := len(att.Data)
+		if changed, err := compressImage(att, a.ImageCompression); err != nil {
+			a.Logger.Warnf("Sending image uncompressed: %v", err)
+		} else if changed {
+			a.Logger.Debugf("Compressed image from %d to %d bytes", size, len(att.Data))
+		}
+	}
+	if att.Kind == MediaKindVideo && a.VideoTranscoding.Enabled && !att.Original {
+		size := len(att.Data)
+		if changed, err := transcodeVideo(att, a.VideoTranscoding); err != nil {
+			a.Logger.Warnf("Sending video as is: %v", err)
+		} else if changed {
+			a.Logger.Debugf("Transcoded video from %d to %d bytes", size, len(att.Data))
+		}
+	}
+
+	var mediaType whatsmeow.MediaType
+	switch att.Kind {
+	case MediaKindImage:
+		mediaType = whatsmeow.MediaImage
+	case MediaKindVideo:
+		mediaType = whatsmeow.MediaVideo
+	case MediaKindAudio:
+		mediaType = whatsmeow.MediaAudio
+	default:
+		mediaType = whatsmeow.MediaDocument
+	}
+
+	// Upload encrypts the file and computes the hashes WhatsApp needs
+	resp, err := a.upload(att.Data, mediaType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload media: %v", err)
+	}
+
+	// The embedded preview shows before the recipient downloads the media
+	var thumbnail []byte
+	if hasThumbnail(att.Kind) {
+		if thumbnail, err = mediaThumbnail(att.Kind, att.Data, att.Filename, messageThumbSize); err != nil {
+			a.Logger.Debugf("Sending %s without a thumbnail: %v", att.Kind, err)
+		}
+	}
+
+	msg := &waProto.Message{}
+	switch att.Kind {
+	case MediaKindImage:
+		msg.ImageMessage = &waProto.ImageMessage{
+			Caption:       proto.String(caption),
+			Mimetype:      proto.String(att.MimeType),
+			URL:           &resp.URL,
+			DirectPath:    &resp.DirectPath,
+			MediaKey:      resp.MediaKey,
+			FileEncSHA256: resp.FileEncSHA256,
+			FileSHA256:    resp.FileSHA256,
+			FileLength:    &resp.FileLength,
+			JPEGThumbnail: thumbnail,
+		}
+	case MediaKindVideo:
+		msg.VideoMessage = &waProto.VideoMessage{
+			Caption:       proto.String(caption),
+			Mimetype:      proto.String(att.MimeType),
+			URL:           &resp.URL,
+			DirectPath:    &resp.DirectPath,
+			MediaKey:      resp.MediaKey,
+			FileEncSHA256: resp.FileEncSHA256,
+			FileSHA256:    resp.FileSHA256,
+			FileLength:    &resp.FileLength,
+			JPEGThumbnail: thumbnail,
+		}
+		if seconds := mediaDuration(att.Data, ".mp4"); seconds > 0 {
+			msg.VideoMessage.Seconds = proto.Uint32(seconds)
+		}
+		if att.GIFPlayback {
+			msg.VideoMessage.GifPlayback = proto.Bool(true)
+		}
+	case MediaKindAudio:
+		audio := &waProto.AudioMessage{
+			Mimetype:      proto.String(att.MimeType),
+			URL:           &resp.URL,
+			DirectPath:    &resp.DirectPath,
+			MediaKey:      resp.MediaKey,
+			FileEncSHA256: resp.FileEncSHA256,
+			FileSHA256:    resp.FileSHA256,
+			FileLength:    &resp.FileLength,
+		}
+		// Clients show the length and waveform before downloading the audio
+		if seconds := mediaDuration(att.Data, audioExt(att)); seconds > 0 {
+			audio.Seconds = proto.Uint32(seconds)
+		}
+		if waveform, err := computeWaveform(att.Data, audioExt(att)); err == nil {
+			audio.Waveform = waveform
+		}
+		msg.AudioMessage = audio
+	default:
+		filename := att.Filename
+		if filename == "" {
+			filename = "document"
+		}
+		doc := &waProto.DocumentMessage{
+			Title:         proto.String(filename),
+			FileName:      proto.String(filename),
+			Caption:       proto.String(caption),
+			Mimetype:      proto.String(att.MimeType),
+			URL:           &resp.URL,
+			DirectPath:    &resp.DirectPath,
+			MediaKey:      resp.MediaKey,
+			FileEncSHA256: resp.FileEncSHA256,
+			FileSHA256:    resp.FileSHA256,
+			FileLength:    &resp.FileLength,
+		}
+		// PDFs show their page count and cover like they do from the apps
+		if isPDF(att) {
+			preview, err := pdfPreview(att.Data)
+			if err != nil {
+				a.Logger.Debugf("Sending PDF without a cover: %v", err)
+			}
+			if preview != nil {
+				if preview.PageCount > 0 {
+					doc.PageCount = proto.Uint32(preview.PageCount)
+				}
+				if preview.Thumbnail != nil {
+					doc.JPEGThumbnail = preview.Thumbnail
+					doc.ThumbnailWidth = proto.Uint32(preview.Width)
+					doc.ThumbnailHeight = proto.Uint32(preview.Height)
+				}
+			}
+		}
+		msg.DocumentMessage = doc
+	}
+	return msg, nil
+}
+
+// Flag a media message as view-once and wrap it the way WhatsApp clients expect
+func wrapViewOnce(msg *waProto.Message) (*waProto.Message, error) {
+	switch {
+	case msg.ImageMessage != nil:
+		msg.ImageMessage.ViewOnce = proto.Bool(true)
+	case msg.VideoMessage != nil:
+		msg.VideoMessage.ViewOnce = proto.Bool(true)
+	case msg.AudioMessage != nil:
+		msg.AudioMessage.ViewOnce = proto.Bool(true)
+	default:
+		return nil, fmt.Errorf("only images, videos and audio can be sent as view-once")
+	}
+	return &waProto.Message{ViewOnceMessageV2: &waProto.FutureProofMessage{Message: msg}}, nil
+}
+
+// Read the media request from either a multipart upload or a JSON body
+func parseSendMediaRequest(w http.ResponseWriter, r *http.Request) (*SendMediaRequest, *Attachment, error) {
+	var req SendMediaRequest
+	var data []byte
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.Body = http.MaxBytesReader(w, r.Body, maxMediaSize+(1<<20))
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, nil, fmt.Errorf("invalid multipart form: %v", err)
+		}
+		req.Recipient = r.FormValue("recipient")
+		req.URL = r.FormValue("url")
+		req.Caption = r.FormValue("caption")
+		req.Filename = r.FormValue("filename")
+		req.Type = r.FormValue("type")
+		req.QuotedMessageID = r.FormValue("quoted_message_id")
+		req.ViewOnce, _ = strconv.ParseBool(r.FormValue("view_once"))
+		req.Original, _ = strconv.ParseBool(r.FormValue("original"))
+		req.Async, _ = strconv.ParseBool(r.FormValue("async"))
+		req.MentionedJIDs = r.Form["mentioned_jids"]
+
+		file, header, err := r.FormFile("file")
+		if err == nil {
+			defer file.Close()
+			if data, err = io.ReadAll(file); err != nil {
+				return nil, nil, fmt.Errorf("failed to read uploaded file: %v", err)
+			}
+			if req.Filename == "" {
+				req.Filename = header.Filename
+			}
+		} else if err != http.ErrMissingFile {
+			return nil, nil, fmt.Errorf("invalid file upload: %v", err)
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, nil, fmt.Errorf("invalid request format")
+	}
+
+	if data == nil {
+		if req.URL == "" {
+			return nil, nil, fmt.Errorf("a file upload or media URL is required")
+		}
+		fetched, filename, err := fetchMedia(req.URL)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = fetched
+		if req.Filename == "" {
+			req.Filename = filename
+		}
+	}
+
+	att, err := newAttachment(data, req.Filename, strings.ToLower(req.Type))
+	if err != nil {
+		return nil, nil, err
+	}
+	att.Original = req.Original
+	return &req, att, nil
+}
+
+// Handle POST /api/messages/media
+func (a *Account) HandleSendMediaEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, att, err := parseSendMediaRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to, err := parseRecipient(req.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !a.Client.IsConnected() {
+		writeSendResult(w, to, whatsmeow.SendResponse{}, errNotConnected)
+		return
+	}
+
+	if _, _, err := resolveMentions(req.Caption, req.MentionedJIDs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ViewOnce && att.Kind == MediaKindDocument {
+		http.Error(w, "only images, videos and audio can be sent as view-once", http.StatusBadRequest)
+		return
+	}
+
+	if req.Async {
+		job, err := a.MediaJobs.Submit(to, req, att, a.traceParent)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+		return
+	}
+
+	resp, err := a.sendMedia(to, req, att)
+	writeSendResult(w, to, resp, err)
+}
+
+// Upload media to WhatsApp, which encrypts it and computes the hashes
+// messages need
+func (a *Account) upload(data []byte, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	span := a.span("whatsapp.upload", spanKindClient)
+	span.SetAttr("whatsapp.media_type", string(mediaType))
+	span.SetAttr("whatsapp.media_size", len(data))
+	resp, err := a.Client.Upload(context.Background(), data, mediaType)
+	span.RecordError(err)
+	span.End()
+	return resp, err
+}
+
+// Convert, upload and send a media request
+func (a *Account) sendMedia(to types.JID, req *SendMediaRequest, att *Attachment) (whatsmeow.SendResponse, error) {
+	caption, mentioned, err := resolveMentions(req.Caption, req.MentionedJIDs)
+	if err != nil {
+		return whatsmeow.SendResponse{}, err
+	}
+
+	msg, err := a.buildMediaMessage(att, caption)
+	if err != nil {
+		return whatsmeow.SendResponse{}, err
+	}
+	var info *waProto.ContextInfo
+	if req.QuotedMessageID != "" {
+		info = a.quoteContext(to, req.QuotedMessageID)
+	}
+	applyContextInfo(msg, withMentions(info, mentioned))
+	if req.ViewOnce {
+		if msg, err = wrapViewOnce(msg); err != nil {
+			return whatsmeow.SendResponse{}, err
+		}
+	}
+	return a.sendMessage(to, msg)
+}
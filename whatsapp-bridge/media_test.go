@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"8.8.8.8":          true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"192.168.0.10":     false,
+		"169.254.169.254":  false,
+		"100.100.100.200":  false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00:ec2::254":    false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := isPublicAddr(netip.MustParseAddr(addr)); got != public {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", addr, got, public)
+		}
+	}
+}
+
+func TestFetchMediaRefusesLocalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer server.Close()
+
+	if _, _, err := fetchMedia(server.URL); err == nil || !strings.Contains(err.Error(), "not a public address") {
+		t.Errorf("fetching %s gave %v, want a refusal", server.URL, err)
+	}
+}
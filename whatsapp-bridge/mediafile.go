@@ -0,0 +1,280 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waMmsRetry"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// How long to wait for the sender's phone to re-upload expired media
+const mediaRetryTimeout = 30 * time.Second
+
+// Media host used to rebuild a URL from a re-uploaded file's direct path
+const mediaHost = "https://mmg.whatsapp.net"
+
+// Returned when the store lacks the keys needed to download a message's media
+var errMediaUnavailable = errors.New("media cannot be downloaded")
+
+// MediaRetryWaiter hands media re-upload responses to the downloads waiting
+// for them
+type MediaRetryWaiter struct {
+	mu      sync.Mutex
+	waiting map[string][]chan *events.MediaRetry
+}
+
+// Create an empty media retry waiter
+func NewMediaRetryWaiter() *MediaRetryWaiter {
+	return &MediaRetryWaiter{waiting: make(map[string][]chan *events.MediaRetry)}
+}
+
+// Wait for the re-upload response of a message. Call cancel once done.
+func (m *MediaRetryWaiter) Wait(messageID string) (responses <-chan *events.MediaRetry, cancel func()) {
+	ch := make(chan *events.MediaRetry, 1)
+	m.mu.Lock()
+	m.waiting[messageID] = append(m.waiting[messageID], ch)
+	m.mu.Unlock()
+
+	return ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		waiting := m.waiting[messageID]
+		for i, other := range waiting {
+			if other == ch {
+				waiting = append(waiting[:i], waiting[i+1:]...)
+				break
+			}
+		}
+		if len(waiting) == 0 {
+			delete(m.waiting, messageID)
+		} else {
+			m.waiting[messageID] = waiting
+		}
+	}
+}
+
+// Deliver a re-upload response to everyone waiting for it
+func (m *MediaRetryWaiter) Deliver(evt *events.MediaRetry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.waiting[evt.MessageID] {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
+
+// Get the saved copy of a message's media, downloading, decrypting and saving
+// it first when needed. Returns its storage location and MIME type.
+func (a *Account) messageMedia(chat types.JID, messageID string) (string, string, error) {
+	store := a.MessageStore
+	chatJID := chat.String()
+	if location, mimeType, err := store.GetLocalMedia(messageID, chatJID); err == nil && location != "" {
+		if a.Media.Exists(location) {
+			return location, mimeType, nil
+		}
+	}
+
+	original, err := store.GetMessage(messageID, chatJID)
+	if err != nil {
+		return "", "", err
+	}
+	if original.MediaType == "" {
+		return "", "", fmt.Errorf("%w: not a media message", errMediaUnavailable)
+	}
+	mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength, err := store.GetMediaInfo(messageID, chatJID)
+	if err != nil || url == "" || len(mediaKey) == 0 || len(fileSHA256) == 0 || len(fileEncSHA256) == 0 || fileLength == 0 {
+		var viewOnce bool
+		store.db.QueryRow("SELECT COALESCE(is_view_once, 0) FROM messages WHERE id = ? AND chat_jid = ?", messageID, chatJID).Scan(&viewOnce)
+		if viewOnce {
+			return "", "", fmt.Errorf("%w: view-once media is not stored, start the bridge with --store-view-once-media to keep it", errMediaUnavailable)
+		}
+		return "", "", fmt.Errorf("%w: incomplete media information", errMediaUnavailable)
+	}
+	downloader, err := newMediaDownloader(mediaType, url, mediaKey, fileSHA256, fileEncSHA256, fileLength)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !a.Client.IsConnected() {
+		return "", "", errNotConnected
+	}
+	data, err := a.Client.Download(downloader)
+	// WhatsApp deletes media from its servers after a while; the sender's
+	// phone can upload it again if it still has the file
+	if errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404) || errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410) {
+		a.Logger.Infof("Media of %s expired, asking the sender to upload it again", messageID)
+		data, err = a.reuploadMedia(chat, messageID, original, downloader)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to download media: %w", err)
+	}
+	bridgeMetrics.MediaDownloaded.Add(float64(len(data)), a.ID, mediaType)
+
+	mimeType := detectMimeType(data, filename)
+	location, err := a.Media.Save(mediaStorageKey(chatJID, localMediaName(messageID, filename, mimeType)), data, mimeType)
+	if err != nil {
+		return "", "", err
+	}
+	if err := store.SetLocalMedia(messageID, chatJID, location, int64(len(data)), mimeType); err != nil {
+		return "", "", fmt.Errorf("failed to record media file: %v", err)
+	}
+	a.Logger.Debugf("Downloaded %s media of %s to %s (%d bytes)", mediaType, messageID, location, len(data))
+	if hasThumbnail(mediaType) {
+		if _, err := a.galleryThumbnail(messageID, chatJID, mediaType, data, filename); err != nil {
+			a.Logger.Debugf("No gallery thumbnail for %s: %v", messageID, err)
+		}
+	}
+	// Audio files forwarded from other apps often arrive without the length
+	// and waveform voice notes have
+	if mediaType == "audio" && (original.Seconds == 0 || len(original.Waveform) == 0) {
+		a.fillAudioDetails(messageID, chatJID, data, filename)
+	}
+	a.Janitor.Trigger()
+	return location, mimeType, nil
+}
+
+// Work out the duration and waveform of downloaded audio and store them where
+// the message lacked them
+func (a *Account) fillAudioDetails(messageID, chatJID string, data []byte, filename string) {
+	ext := filepath.Ext(filename)
+	if ext == "" {
+		ext = ".audio"
+	}
+	seconds := mediaDuration(data, ext)
+	waveform, err := computeWaveform(data, ext)
+	if err != nil {
+		a.Logger.Debugf("No waveform for %s: %v", messageID, err)
+	}
+	if seconds == 0 && waveform == nil {
+		return
+	}
+	if err := a.MessageStore.FillAudioDetails(messageID, chatJID, seconds, waveform); err != nil {
+		a.Logger.Warnf("Failed to store audio details of %s: %v", messageID, err)
+	}
+}
+
+// Ask the sender's phone to upload expired media again and download it from
+// the new location
+func (a *Account) reuploadMedia(chat types.JID, messageID string, original *Message, downloader *MediaDownloader) ([]byte, error) {
+	sender, err := a.messageSender(chat, original)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request re-upload: %v", err)
+	}
+	info := &types.MessageInfo{
+		MessageSource: types.MessageSource{
+			Chat:     chat,
+			Sender:   sender,
+			IsFromMe: original.IsFromMe,
+			IsGroup:  chat.Server == types.GroupServer,
+		},
+		ID: messageID,
+	}
+
+	responses, cancel := a.MediaRetries.Wait(messageID)
+	defer cancel()
+	if err := a.Client.SendMediaRetryReceipt(info, downloader.MediaKey); err != nil {
+		return nil, fmt.Errorf("failed to request re-upload: %v", err)
+	}
+
+	var evt *events.MediaRetry
+	select {
+	case evt = <-responses:
+	case <-time.After(mediaRetryTimeout):
+		return nil, fmt.Errorf("media expired and the sender did not upload it again")
+	}
+	retry, err := whatsmeow.DecryptMediaRetryNotification(evt, downloader.MediaKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read re-upload response: %v", err)
+	}
+	if retry.GetResult() != waMmsRetry.MediaRetryNotification_SUCCESS {
+		return nil, fmt.Errorf("media expired and the sender could not upload it again (%s)", retry.GetResult())
+	}
+
+	data, err := a.Client.DownloadMediaWithPath(retry.GetDirectPath(), downloader.FileEncSHA256, downloader.FileSHA256,
+		downloader.MediaKey, int(downloader.FileLength), downloader.MediaType, "")
+	if err != nil {
+		return nil, err
+	}
+	// Remember the new location for later downloads
+	if err := a.MessageStore.StoreMediaInfo(messageID, chat.String(), mediaHost+retry.GetDirectPath(), downloader.MediaKey,
+		downloader.FileSHA256, downloader.FileEncSHA256, downloader.FileLength); err != nil {
+		a.Logger.Warnf("Failed to store new media location of %s: %v", messageID, err)
+	}
+	return data, nil
+}
+
+// Handle GET /api/messages/{id}/media
+func (a *Account) HandleMessageMediaEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID := r.PathValue("id")
+	chat, err := a.resolveMessageChat(messageID, r.URL.Query().Get("chat_jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	location, mimeType, err := a.messageMedia(chat, messageID)
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, fmt.Sprintf("Message %s not found in %s", messageID, chat), http.StatusNotFound)
+		return
+	case errors.Is(err, errMediaUnavailable):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errNotConnected):
+		http.Error(w, "Media is not cached and "+err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if err := a.MessageStore.TouchLocalMedia(messageID, chat.String()); err != nil {
+		a.Logger.Warnf("Failed to record access to media of %s: %v", messageID, err)
+	}
+
+	// Object stores hand out signed URLs so the bytes don't pass through the
+	// bridge; redirect=false proxies them for clients that can't reach the store
+	if r.URL.Query().Get("redirect") != "false" {
+		signed, err := a.Media.SignedURL(location, mimeType)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to sign media URL: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if signed != "" {
+			http.Redirect(w, r, signed, http.StatusFound)
+			return
+		}
+	}
+
+	file, err := a.Media.Open(location)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open media file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": path.Base(filepath.ToSlash(location))}))
+	http.ServeContent(w, r, "", time.Time{}, file)
+}
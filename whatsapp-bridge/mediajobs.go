@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Media job states
+const (
+	MediaJobQueued     = "queued"
+	MediaJobProcessing = "processing"
+	MediaJobSent       = "sent"
+	MediaJobFailed     = "failed"
+)
+
+// Webhook event sent when a background media send finishes
+const WebhookEventMediaJob = "media_job"
+
+// How many media sends may wait for the worker
+const mediaJobQueueSize = 16
+
+// How long finished jobs can still be looked up
+const mediaJobRetention = 24 * time.Hour
+
+// MediaJob is a media message sent in the background, for large files that
+// take too long to convert and upload within a request
+type MediaJob struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Recipient  string     `json:"recipient"`
+	Kind       string     `json:"kind"`
+	Filename   string     `json:"filename,omitempty"`
+	InputSize  int        `json:"input_size"`
+	OutputSize int        `json:"output_size,omitempty"`
+	MessageID  string     `json:"message_id,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	to  types.JID
+	req *SendMediaRequest
+	att *Attachment
+	// Span of the request that queued the send
+	trace spanContext
+}
+
+// MediaJobQueue sends queued media messages one at a time. Jobs live in
+// memory, so sends still queued when the bridge stops are lost.
+type MediaJobQueue struct {
+	account *Account
+	mu      sync.Mutex
+	jobs    map[string]*MediaJob
+	queue   chan *MediaJob
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Create an empty media job queue for an account
+func NewMediaJobQueue(account *Account) *MediaJobQueue {
+	return &MediaJobQueue{
+		account: account,
+		jobs:    make(map[string]*MediaJob),
+		queue:   make(chan *MediaJob, mediaJobQueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Run sends queued media until Stop is called
+func (q *MediaJobQueue) Run() {
+	defer close(q.done)
+	for {
+		select {
+		case job := <-q.queue:
+			q.process(job)
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// Stop the worker and wait for an in-flight send to finish
+func (q *MediaJobQueue) Stop() {
+	close(q.stop)
+	<-q.done
+}
+
+// Queue a media send, failing when the queue is full
+func (q *MediaJobQueue) Submit(to types.JID, req *SendMediaRequest, att *Attachment, trace spanContext) (MediaJob, error) {
+	job := &MediaJob{
+		ID:        newJobID(),
+		Status:    MediaJobQueued,
+		Recipient: to.String(),
+		Kind:      att.Kind,
+		Filename:  att.Filename,
+		InputSize: len(att.Data),
+		CreatedAt: time.Now(),
+		to:        to,
+		req:       req,
+		att:       att,
+		trace:     trace,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune(job.CreatedAt)
+	select {
+	case q.queue <- job:
+	default:
+		return MediaJob{}, fmt.Errorf("too many media sends in progress, try again later")
+	}
+	q.jobs[job.ID] = job
+	return *job, nil
+}
+
+// Look up a job
+func (q *MediaJobQueue) Get(id string) (MediaJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return MediaJob{}, false
+	}
+	return *job, true
+}
+
+// All known jobs, newest first
+func (q *MediaJobQueue) List() []MediaJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]MediaJob, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// Forget jobs that finished long ago. Callers hold q.mu.
+func (q *MediaJobQueue) prune(now time.Time) {
+	for id, job := range q.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > mediaJobRetention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// Convert, upload and send a job's media, then report how it went
+func (q *MediaJobQueue) process(job *MediaJob) {
+	q.mu.Lock()
+	job.Status = MediaJobProcessing
+	q.mu.Unlock()
+
+	span := q.account.withTrace(job.trace).span("media_job.process", spanKindInternal)
+	span.SetAttr("media_job.id", job.ID)
+	span.SetAttr("media_job.queued_ms", time.Since(job.CreatedAt).Milliseconds())
+	a := q.account.withTrace(span.context())
+	resp, err := a.sendMedia(job.to, job.req, job.att)
+	span.RecordError(err)
+	span.End()
+
+	q.mu.Lock()
+	now := time.Now()
+	job.FinishedAt = &now
+	job.OutputSize = len(job.att.Data)
+	job.Filename = job.att.Filename
+	if err != nil {
+		job.Status, job.Error = MediaJobFailed, err.Error()
+		a.Logger.Warnf("Background %s send %s to %s failed: %v", job.Kind, job.ID, job.Recipient, err)
+	} else {
+		job.Status, job.MessageID = MediaJobSent, resp.ID
+	}
+	// The file is no longer needed once sent
+	job.req, job.att = nil, nil
+	result := *job
+	q.mu.Unlock()
+
+	a.Notifier.Notify(a.ID, WebhookEventMediaJob, map[string]interface{}{
+		"id":          result.ID,
+		"status":      result.Status,
+		"recipient":   result.Recipient,
+		"message_id":  result.MessageID,
+		"error":       result.Error,
+		"output_size": result.OutputSize,
+	})
+}
+
+// Handle GET /api/media/jobs
+func (a *Account) HandleMediaJobsEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.MediaJobs.List())
+}
+
+// Handle GET /api/media/jobs/{id}
+func (a *Account) HandleMediaJobEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job, ok := a.MediaJobs.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Media job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
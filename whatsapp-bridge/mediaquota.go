@@ -0,0 +1,371 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// How often downloaded media is checked against the quota and retention rules
+const mediaJanitorInterval = 10 * time.Minute
+
+// MediaRetention overrides how long a chat's downloaded media is kept
+type MediaRetention struct {
+	// Never evict this chat's media to stay under the quota
+	Keep bool `json:"keep"`
+	// Delete media downloaded more than this many days ago, 0 for no limit
+	MaxAgeDays int `json:"max_age_days"`
+}
+
+// ChatMediaUsage is the disk space used by one chat's media
+type ChatMediaUsage struct {
+	ChatJID   string          `json:"chat_jid"`
+	Name      string          `json:"name,omitempty"`
+	Files     int64           `json:"files"`
+	Bytes     int64           `json:"bytes"`
+	Retention *MediaRetention `json:"retention,omitempty"`
+}
+
+// TypeMediaUsage is the disk space used by one media type
+type TypeMediaUsage struct {
+	MediaType string `json:"media_type"`
+	Files     int64  `json:"files"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// MediaStats represents the response for the media stats API
+type MediaStats struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// 0 when there is no cap
+	QuotaBytes int64            `json:"quota_bytes"`
+	Chats      []ChatMediaUsage `json:"chats"`
+	Types      []TypeMediaUsage `json:"types"`
+}
+
+// A downloaded media file
+type localMediaFile struct {
+	messageID string
+	chatJID   string
+	path      string
+	size      int64
+}
+
+// Record that downloaded media was just used, so it is evicted last
+func (store *MessageStore) TouchLocalMedia(id, chatJID string) error {
+	_, err := store.db.Exec("UPDATE messages SET media_accessed_at = ? WHERE id = ? AND chat_jid = ?", time.Now().UTC(), id, chatJID)
+	return err
+}
+
+// Forget where a message's media was saved, once the file is gone
+func (store *MessageStore) ClearLocalMedia(id, chatJID string) error {
+	_, err := store.db.Exec(
+		"UPDATE messages SET local_path = NULL, local_size = NULL, local_mime = NULL, downloaded_at = NULL, media_accessed_at = NULL WHERE id = ? AND chat_jid = ?",
+		id, chatJID,
+	)
+	return err
+}
+
+// Save a chat's media retention override; the zero value restores the default
+func (store *MessageStore) SetChatMediaRetention(jid string, retention MediaRetention) error {
+	_, err := store.db.Exec(
+		`INSERT INTO chats (jid, media_keep, media_max_age_days) VALUES (?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET media_keep = excluded.media_keep, media_max_age_days = excluded.media_max_age_days`,
+		jid, retention.Keep, retention.MaxAgeDays,
+	)
+	return err
+}
+
+// Get a chat's media retention override, the zero value when it has none
+func (store *MessageStore) GetChatMediaRetention(jid string) (MediaRetention, error) {
+	var retention MediaRetention
+	err := store.db.QueryRow(
+		"SELECT COALESCE(media_keep, 0), COALESCE(media_max_age_days, 0) FROM chats WHERE jid = ?", jid,
+	).Scan(&retention.Keep, &retention.MaxAgeDays)
+	if err == sql.ErrNoRows {
+		return MediaRetention{}, nil
+	}
+	return retention, err
+}
+
+// Read downloaded media files from a query selecting id, chat, path and size
+func (store *MessageStore) queryLocalMedia(query string, args ...interface{}) ([]localMediaFile, error) {
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []localMediaFile
+	for rows.Next() {
+		var file localMediaFile
+		if err := rows.Scan(&file.messageID, &file.chatJID, &file.path, &file.size); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// Media downloaded before its chat's maximum age
+func (store *MessageStore) ExpiredLocalMedia(now time.Time) ([]localMediaFile, error) {
+	rows, err := store.db.Query(`
+		SELECT m.id, m.chat_jid, m.local_path, COALESCE(m.local_size, 0), m.downloaded_at, c.media_max_age_days
+		FROM messages m JOIN chats c ON c.jid = m.chat_jid
+		WHERE COALESCE(m.local_path, '') != '' AND m.downloaded_at IS NOT NULL AND COALESCE(c.media_max_age_days, 0) > 0`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []localMediaFile
+	for rows.Next() {
+		var file localMediaFile
+		var downloadedAt time.Time
+		var maxAgeDays int
+		if err := rows.Scan(&file.messageID, &file.chatJID, &file.path, &file.size, &downloadedAt, &maxAgeDays); err != nil {
+			return nil, err
+		}
+		if now.Sub(downloadedAt) > time.Duration(maxAgeDays)*24*time.Hour {
+			files = append(files, file)
+		}
+	}
+	return files, rows.Err()
+}
+
+// Media that may be evicted, least recently used first
+func (store *MessageStore) EvictableLocalMedia() ([]localMediaFile, error) {
+	return store.queryLocalMedia(`
+		SELECT m.id, m.chat_jid, m.local_path, COALESCE(m.local_size, 0)
+		FROM messages m LEFT JOIN chats c ON c.jid = m.chat_jid
+		WHERE COALESCE(m.local_path, '') != '' AND COALESCE(c.media_keep, 0) = 0
+		ORDER BY COALESCE(m.media_accessed_at, m.downloaded_at)`)
+}
+
+// Total size of downloaded media
+func (store *MessageStore) LocalMediaSize() (int64, error) {
+	var size int64
+	err := store.db.QueryRow("SELECT COALESCE(SUM(local_size), 0) FROM messages WHERE COALESCE(local_path, '') != ''").Scan(&size)
+	return size, err
+}
+
+// Disk usage of downloaded media by chat, largest first, and by type
+func (store *MessageStore) MediaStats() (*MediaStats, error) {
+	stats := &MediaStats{Chats: []ChatMediaUsage{}, Types: []TypeMediaUsage{}}
+
+	rows, err := store.db.Query(`
+		SELECT m.chat_jid, COALESCE(c.name, ''), COUNT(*), COALESCE(SUM(m.local_size), 0),
+			COALESCE(c.media_keep, 0), COALESCE(c.media_max_age_days, 0)
+		FROM messages m LEFT JOIN chats c ON c.jid = m.chat_jid
+		WHERE COALESCE(m.local_path, '') != ''
+		GROUP BY m.chat_jid, c.name, c.media_keep, c.media_max_age_days ORDER BY 4 DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var usage ChatMediaUsage
+		var retention MediaRetention
+		if err := rows.Scan(&usage.ChatJID, &usage.Name, &usage.Files, &usage.Bytes, &retention.Keep, &retention.MaxAgeDays); err != nil {
+			return nil, err
+		}
+		if retention != (MediaRetention{}) {
+			usage.Retention = &retention
+		}
+		stats.Chats = append(stats.Chats, usage)
+		stats.Files += usage.Files
+		stats.Bytes += usage.Bytes
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	typeRows, err := store.db.Query(`
+		SELECT COALESCE(media_type, ''), COUNT(*), COALESCE(SUM(local_size), 0)
+		FROM messages WHERE COALESCE(local_path, '') != ''
+		GROUP BY media_type ORDER BY 3 DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer typeRows.Close()
+	for typeRows.Next() {
+		var usage TypeMediaUsage
+		if err := typeRows.Scan(&usage.MediaType, &usage.Files, &usage.Bytes); err != nil {
+			return nil, err
+		}
+		stats.Types = append(stats.Types, usage)
+	}
+	return stats, typeRows.Err()
+}
+
+// MediaJanitor deletes downloaded media past its chat's retention and evicts
+// the least recently used files while the total is over the quota. Evicted
+// media is downloaded again on demand.
+type MediaJanitor struct {
+	account *Account
+	// 0 when there is no cap
+	quota   int64
+	trigger chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Create a janitor for an account with a quota in bytes
+func NewMediaJanitor(account *Account, quota int64) *MediaJanitor {
+	return &MediaJanitor{
+		account: account,
+		quota:   quota,
+		trigger: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Run cleans up media periodically and when triggered, until Stop is called
+func (j *MediaJanitor) Run() {
+	defer close(j.done)
+	ticker := time.NewTicker(mediaJanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.clean(time.Now()); err != nil {
+			j.account.Logger.Warnf("Failed to clean up downloaded media: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-j.trigger:
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// Ask for a cleanup soon, after new media was saved
+func (j *MediaJanitor) Trigger() {
+	select {
+	case j.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Stop the janitor and wait for an in-flight cleanup to finish
+func (j *MediaJanitor) Stop() {
+	close(j.stop)
+	<-j.done
+}
+
+// Apply retention overrides, then the quota
+func (j *MediaJanitor) clean(now time.Time) error {
+	store := j.account.MessageStore
+	expired, err := store.ExpiredLocalMedia(now)
+	if err != nil {
+		return err
+	}
+	for _, file := range expired {
+		if err := j.remove(file); err != nil {
+			return err
+		}
+	}
+	if len(expired) > 0 {
+		j.account.Logger.Infof("Deleted %d downloaded media files past their chat's retention", len(expired))
+	}
+
+	if j.quota <= 0 {
+		return nil
+	}
+	used, err := store.LocalMediaSize()
+	if err != nil || used <= j.quota {
+		return err
+	}
+	candidates, err := store.EvictableLocalMedia()
+	if err != nil {
+		return err
+	}
+	evicted := 0
+	for _, file := range candidates {
+		if used <= j.quota {
+			break
+		}
+		if err := j.remove(file); err != nil {
+			return err
+		}
+		used -= file.size
+		evicted++
+	}
+	if evicted > 0 {
+		j.account.Logger.Infof("Evicted %d downloaded media files to stay under the %d MB quota", evicted, j.quota>>20)
+	}
+	if used > j.quota {
+		j.account.Logger.Warnf("Downloaded media uses %d MB, over the %d MB quota, but the rest is kept by chat retention settings", used>>20, j.quota>>20)
+	}
+	return nil
+}
+
+// Delete a downloaded file and forget it
+func (j *MediaJanitor) remove(file localMediaFile) error {
+	if err := j.account.Media.Delete(file.path); err != nil {
+		return fmt.Errorf("failed to delete %s: %v", file.path, err)
+	}
+	return j.account.MessageStore.ClearLocalMedia(file.messageID, file.chatJID)
+}
+
+// Handle GET /api/media/stats
+func (a *Account) HandleMediaStatsEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := a.MessageStore.MediaStats()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load media stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	stats.QuotaBytes = a.Janitor.quota
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// Handle GET and PUT /api/chats/{jid}/media-retention
+func (a *Account) HandleMediaRetentionEndpoint(w http.ResponseWriter, r *http.Request) {
+	chat, err := parseRecipient(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		// Parse the request body
+		var req MediaRetention
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.MaxAgeDays < 0 {
+			http.Error(w, "max_age_days must not be negative", http.StatusBadRequest)
+			return
+		}
+		if err := a.MessageStore.SetChatMediaRetention(chat.String(), req); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to save media retention: %v", err), http.StatusInternalServerError)
+			return
+		}
+		a.Janitor.Trigger()
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	retention, err := a.MessageStore.GetChatMediaRetention(chat.String())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load media retention: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(retention)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// @-mentions of phone numbers in message text, with an optional +
+var mentionPattern = regexp.MustCompile(`@\+?(\d{5,20})\b`)
+
+// Collect the JIDs mentioned in a text, both passed explicitly and written as
+// @phone tokens. Tokens are normalized to @digits, the form WhatsApp highlights.
+func resolveMentions(text string, explicit []string) (string, []string, error) {
+	var mentioned []string
+	seen := make(map[string]bool)
+	add := func(jid types.JID) {
+		if !seen[jid.String()] {
+			seen[jid.String()] = true
+			mentioned = append(mentioned, jid.String())
+		}
+	}
+
+	for _, recipient := range explicit {
+		jid, err := parseRecipient(recipient)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid mention %s: %v", recipient, err)
+		}
+		add(jid)
+	}
+
+	text = mentionPattern.ReplaceAllStringFunc(text, func(token string) string {
+		phone := mentionPattern.FindStringSubmatch(token)[1]
+		add(types.NewJID(phone, types.DefaultUserServer))
+		return "@" + phone
+	})
+	return text, mentioned, nil
+}
+
+// Add mentions to a message context, creating it if needed
+func withMentions(info *waProto.ContextInfo, mentioned []string) *waProto.ContextInfo {
+	if len(mentioned) == 0 {
+		return info
+	}
+	if info == nil {
+		info = &waProto.ContextInfo{}
+	}
+	info.MentionedJID = mentioned
+	return info
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Returned when a message is sent while the client is offline
+var errNotConnected = errors.New("not connected to WhatsApp")
+
+// Returned instead of sending while the bridge is read-only
+var errReadOnly = errors.New("the bridge is in read-only mode")
+
+// SendTextRequest represents the request body for the text message API
+type SendTextRequest struct {
+	Recipient       string `json:"recipient"`
+	Body            string `json:"body"`
+	QuotedMessageID string `json:"quoted_message_id,omitempty"`
+	// JIDs or phone numbers to mention, in addition to @phone tokens in the body
+	MentionedJIDs []string `json:"mentioned_jids,omitempty"`
+	// Fetch the first link in the body and attach a preview card
+	LinkPreview bool `json:"link_preview,omitempty"`
+	// Show "typing..." for a human-like delay before sending
+	SimulateTyping bool `json:"simulate_typing,omitempty"`
+}
+
+// SendResult represents the response for the message sending APIs
+type SendResult struct {
+	Success   bool       `json:"success"`
+	Message   string     `json:"message"`
+	ID        string     `json:"id,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	JID       string     `json:"jid,omitempty"`
+	// Queued is set when the message waits in the outbox for the connection
+	Queued bool `json:"queued,omitempty"`
+	// Set when the content policy holds the message until it is confirmed
+	HoldID string `json:"hold_id,omitempty"`
+}
+
+// Normalize a recipient given as a JID or phone number (with or without +,
+// spaces and dashes) into a JID
+func parseRecipient(recipient string) (types.JID, error) {
+	recipient = strings.TrimSpace(recipient)
+	if recipient == "" {
+		return types.JID{}, fmt.Errorf("recipient is required")
+	}
+
+	if strings.Contains(recipient, "@") {
+		jid, err := types.ParseJID(recipient)
+		if err != nil {
+			return types.JID{}, fmt.Errorf("invalid JID: %v", err)
+		}
+		if jid.User == "" && jid.Server != types.BroadcastServer {
+			return types.JID{}, fmt.Errorf("invalid JID: missing user part")
+		}
+		return jid, nil
+	}
+
+	phone := strings.NewReplacer("+", "", " ", "", "-", "", "(", "", ")", "").Replace(recipient)
+	if len(phone) < 5 || len(phone) > 20 {
+		return types.JID{}, fmt.Errorf("invalid phone number: %s", recipient)
+	}
+	for _, c := range phone {
+		if c < '0' || c > '9' {
+			return types.JID{}, fmt.Errorf("invalid phone number: %s", recipient)
+		}
+	}
+	return types.NewJID(phone, types.DefaultUserServer), nil
+}
+
+// Build the context that makes a message render as a reply to quotedID.
+// The quoted message is looked up in the message store so the bubble shows its
+// text; unknown IDs still link to the original on devices that have it.
+func (a *Account) quoteContext(chat types.JID, quotedID string) *waProto.ContextInfo {
+	info := &waProto.ContextInfo{
+		StanzaID:      proto.String(quotedID),
+		QuotedMessage: &waProto.Message{Conversation: proto.String("")},
+	}
+
+	quoted, err := a.MessageStore.GetMessage(quotedID, chat.String())
+	if err == nil {
+		content := quoted.Content
+		if content == "" && quoted.MediaType != "" {
+			content = fmt.Sprintf("[%s]", quoted.MediaType)
+		}
+		info.QuotedMessage = &waProto.Message{Conversation: proto.String(content)}
+	} else {
+		a.Logger.Warnf("Quoted message %s not found in %s, reply will show without a preview", quotedID, chat)
+	}
+	if sender, err := a.messageSender(chat, quoted); err == nil {
+		info.Participant = proto.String(sender.String())
+	} else {
+		a.Logger.Warnf("Reply to %s in %s won't name who is quoted: %v", quotedID, chat, err)
+	}
+	return info
+}
+
+// Attach context (quotes, mentions) to whichever message type is set. Plain
+// conversation messages cannot carry context, so they become extended text.
+func applyContextInfo(msg *waProto.Message, info *waProto.ContextInfo) {
+	if info == nil {
+		return
+	}
+	switch {
+	case msg.Conversation != nil:
+		msg.ExtendedTextMessage = &waProto.ExtendedTextMessage{Text: msg.Conversation, ContextInfo: info}
+		msg.Conversation = nil
+	case msg.ExtendedTextMessage != nil:
+		msg.ExtendedTextMessage.ContextInfo = info
+	case msg.ImageMessage != nil:
+		msg.ImageMessage.ContextInfo = info
+	case msg.VideoMessage != nil:
+		msg.VideoMessage.ContextInfo = info
+	case msg.AudioMessage != nil:
+		msg.AudioMessage.ContextInfo = info
+	case msg.DocumentMessage != nil:
+		msg.DocumentMessage.ContextInfo = info
+	case msg.StickerMessage != nil:
+		msg.StickerMessage.ContextInfo = info
+	case msg.LocationMessage != nil:
+		msg.LocationMessage.ContextInfo = info
+	case msg.LiveLocationMessage != nil:
+		msg.LiveLocationMessage.ContextInfo = info
+	case msg.ContactMessage != nil:
+		msg.ContactMessage.ContextInfo = info
+	case msg.ContactsArrayMessage != nil:
+		msg.ContactsArrayMessage.ContextInfo = info
+	case msg.ProductMessage != nil:
+		msg.ProductMessage.ContextInfo = info
+	}
+}
+
+// Send a message the content policy allows, redacted if it says so
+func (a *Account) sendMessage(to types.JID, msg *waProto.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error) {
+	if err := a.applyContentPolicy(to, msg); err != nil {
+		return whatsmeow.SendResponse{}, err
+	}
+	return a.deliverMessage(to, msg, extra...)
+}
+
+// Send a message and record it in the message store, since WhatsApp does not
+// echo our own messages back as events
+func (a *Account) deliverMessage(to types.JID, msg *waProto.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error) {
+	if a.ReadOnly {
+		return whatsmeow.SendResponse{}, errReadOnly
+	}
+	if !a.Client.IsConnected() {
+		return whatsmeow.SendResponse{}, errNotConnected
+	}
+
+	timer := a.applyDisappearingTimer(to, msg)
+	span := a.span("whatsapp.send", spanKindClient)
+	span.SetAttr("whatsapp.account", a.ID)
+	span.SetAttr("whatsapp.chat", to.String())
+	span.SetAttr("whatsapp.message_type", messageMetricType(msg))
+	started := time.Now()
+	resp, err := a.Client.SendMessage(context.Background(), to, msg, extra...)
+	bridgeMetrics.observeSend(a.ID, msg, started, err)
+	span.RecordError(err)
+	span.SetAttr("whatsapp.message_id", resp.ID)
+	span.End()
+	if err != nil {
+		return resp, fmt.Errorf("failed to send message: %w", err)
+	}
+	tracer.awaitReceipts(resp.ID, span.context(), resp.Timestamp)
+
+	a.storeSentMessage(to, resp, msg)
+	if timer > 0 {
+		if err := a.MessageStore.SetMessageExpiry(resp.ID, to.String(), resp.Timestamp.Add(time.Duration(timer)*time.Second)); err != nil {
+			a.Logger.Warnf("Failed to store expiry of %s: %v", resp.ID, err)
+		}
+	}
+	return resp, nil
+}
+
+// Save a message we sent so it shows up in chat history
+func (a *Account) storeSentMessage(to types.JID, resp whatsmeow.SendResponse, msg *waProto.Message) {
+	viewOnce := msg.GetViewOnceMessageV2().GetMessage() != nil
+	if viewOnce {
+		msg = msg.GetViewOnceMessageV2().GetMessage()
+	}
+
+	content := extractTextContent(msg)
+	mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength := extractMediaInfo(msg)
+	if content == "" && mediaType == "" {
+		return
+	}
+	if viewOnce && !a.MessageStore.keepViewOnceMedia {
+		url, mediaKey, fileSHA256, fileEncSHA256 = "", nil, nil, nil
+	}
+
+	chatJID := to.String()
+	name := GetChatName(a.Client, a.MessageStore, to, chatJID, nil, "", a.Logger)
+	if err := a.MessageStore.StoreChat(chatJID, name, resp.Timestamp); err != nil {
+		a.Logger.Warnf("Failed to store chat: %v", err)
+	}
+
+	sender := ""
+	if a.Client.Store.ID != nil {
+		sender = a.Client.Store.ID.User
+	}
+	err := a.MessageStore.StoreMessage(resp.ID, chatJID, sender, content, resp.Timestamp, true,
+		mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength)
+	if err != nil {
+		a.Logger.Warnf("Failed to store sent message: %v", err)
+		return
+	}
+	if viewOnce {
+		if err := a.MessageStore.MarkViewOnce(resp.ID, chatJID); err != nil {
+			a.Logger.Warnf("Failed to flag view-once message: %v", err)
+		}
+	}
+	a.stashThumbnail(resp.ID, to, msg, viewOnce)
+	details := extractMessageDetails(msg)
+	details.PushName = a.Client.Store.PushName
+	if a.Client.Store.ID != nil {
+		details.SenderJID = a.Client.Store.ID.ToNonAD().String()
+	}
+	if err := a.MessageStore.StoreMessageDetails(resp.ID, chatJID, details); err != nil {
+		a.Logger.Warnf("Failed to store message details: %v", err)
+	}
+}
+
+// Write the outcome of a send as JSON
+func writeSendResult(w http.ResponseWriter, to types.JID, resp whatsmeow.SendResponse, err error) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if errors.Is(err, errQueued) {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(SendResult{
+			Success:   true,
+			Message:   fmt.Sprintf("Not connected, message to %s queued for delivery", to),
+			ID:        resp.ID,
+			Timestamp: &resp.Timestamp,
+			JID:       to.String(),
+			Queued:    true,
+		})
+		return
+	}
+
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) && policyErr.HoldID != "" {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(SendResult{
+			Success: true,
+			Message: fmt.Sprintf("Message to %s held for confirmation by the content policy", to),
+			JID:     to.String(),
+			HoldID:  policyErr.HoldID,
+		})
+		return
+	}
+
+	if err != nil {
+		if errors.Is(err, errNotConnected) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else if policyErr != nil {
+			w.WriteHeader(http.StatusForbidden)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(SendResult{
+			Success: false,
+			Message: err.Error(),
+			JID:     to.String(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(SendResult{
+		Success:   true,
+		Message:   fmt.Sprintf("Message sent to %s", to),
+		ID:        resp.ID,
+		Timestamp: &resp.Timestamp,
+		JID:       to.String(),
+	})
+}
+
+// Validate a text send and resolve its recipient
+func (req *SendTextRequest) validate() (types.JID, error) {
+	to, err := parseRecipient(req.Recipient)
+	if err != nil {
+		return types.JID{}, err
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		return types.JID{}, fmt.Errorf("Body is required")
+	}
+	if _, _, err := resolveMentions(req.Body, req.MentionedJIDs); err != nil {
+		return types.JID{}, err
+	}
+	return to, nil
+}
+
+// Build the message for a validated text send
+func (a *Account) textMessage(to types.JID, req *SendTextRequest) *waProto.Message {
+	body, mentioned, _ := resolveMentions(req.Body, req.MentionedJIDs)
+	msg := a.buildTextMessage(body, req.LinkPreview)
+	var info *waProto.ContextInfo
+	if req.QuotedMessageID != "" {
+		info = a.quoteContext(to, req.QuotedMessageID)
+	}
+	applyContextInfo(msg, withMentions(info, mentioned))
+	return msg
+}
+
+// Handle POST /api/messages/text
+func (a *Account) HandleSendTextEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var req SendTextRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	to, err := req.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msg := a.textMessage(to, &req)
+	if req.SimulateTyping && a.Client.IsConnected() {
+		a.simulateTyping(to, req.Body)
+	}
+	resp, err := a.sendOrQueue(to, msg)
+	writeSendResult(w, to, resp, err)
+}
+
+// Handle POST /api/send, which takes text or a file on the bridge's disk,
+// sent like any other message so it goes through the policy and the outbox
+func (a *Account) HandleLegacySendEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var req SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.Recipient == "" {
+		http.Error(w, "Recipient is required", http.StatusBadRequest)
+		return
+	}
+	if req.Message == "" && req.MediaPath == "" {
+		http.Error(w, "Message or media path is required", http.StatusBadRequest)
+		return
+	}
+	to, err := parseRecipient(req.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.Logger.Debugf("Sending message to %s (media %q)", to, req.MediaPath)
+	msg := &waProto.Message{Conversation: proto.String(req.Message)}
+	if req.MediaPath != "" {
+		data, err := os.ReadFile(req.MediaPath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading media file: %v", err), http.StatusBadRequest)
+			return
+		}
+		att, err := newAttachment(data, filepath.Base(req.MediaPath), "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Media is uploaded before sending, so it can't wait in the outbox
+		if !a.Client.IsConnected() {
+			writeSendResult(w, to, whatsmeow.SendResponse{}, errNotConnected)
+			return
+		}
+		if msg, err = a.buildMediaMessage(att, req.Message); err != nil {
+			writeSendResult(w, to, whatsmeow.SendResponse{}, err)
+			return
+		}
+		// Ogg Opus files have always gone out as voice notes here
+		if msg.AudioMessage != nil && isOggOpus(att.Data) {
+			msg.AudioMessage.PTT = proto.Bool(true)
+		}
+	}
+	if req.QuotedMessageID != "" {
+		applyContextInfo(msg, a.quoteContext(to, req.QuotedMessageID))
+	}
+
+	resp, err := a.sendOrQueue(to, msg)
+	writeSendResult(w, to, resp, err)
+}
+
+// Handle /api/messages/{id}
+func (a *Account) HandleMessageEndpoint(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPatch:
+		a.handleEditEndpoint(w, r)
+	case http.MethodDelete:
+		a.handleDeleteEndpoint(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
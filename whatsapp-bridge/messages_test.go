@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+func TestLegacySendAppliesContentPolicy(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewMessageStore(&Config{}, DefaultAccountID, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	rules := filepath.Join(dir, "rules.json")
+	if err := os.WriteFile(rules, []byte(`[{"name": "no secrets", "pattern": "secret", "action": "block"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadRulePolicy(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := &Account{ID: DefaultAccountID, MessageStore: store, Policy: policy, Logger: waLog.Noop}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/send", strings.NewReader(`{"recipient": "34600000000", "message": "the secret is 42"}`))
+	account.HandleLegacySendEndpoint(w, r)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "no secrets") {
+		t.Errorf("/api/send answered %d %s, want the rule to block it", w.Code, w.Body)
+	}
+}
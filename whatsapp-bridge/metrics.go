@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+)
+
+// Upper bounds in seconds of the send latency buckets. Sends include
+// encryption and the server round trip, and media uploads take longest.
+var sendLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Outcomes of a webhook delivery attempt
+const (
+	WebhookOutcomeDelivered  = "delivered"
+	WebhookOutcomeRetried    = "retried"
+	WebhookOutcomeDeadLetter = "dead_letter"
+)
+
+// Metrics collects the counters and histograms served at /metrics in the
+// Prometheus text format. Gauges such as queue depths are read from the
+// accounts when scraped instead.
+type Metrics struct {
+	MessagesSent     *counterVec
+	MessagesReceived *counterVec
+	SendErrors       *counterVec
+	SendLatency      *histogramVec
+	Webhooks         *counterVec
+	MediaDownloaded  *counterVec
+	Reconnects       *counterVec
+	// Webhook deliveries still being attempted
+	webhooksPending int64
+}
+
+// Metrics shared by every account
+var bridgeMetrics = &Metrics{
+	MessagesSent:     newCounterVec("whatsapp_messages_sent_total", "Messages sent, by message type.", "account", "type"),
+	MessagesReceived: newCounterVec("whatsapp_messages_received_total", "Messages received from other users, by message type.", "account", "type"),
+	SendErrors:       newCounterVec("whatsapp_send_errors_total", "Messages WhatsApp refused or that failed to send.", "account"),
+	SendLatency:      newHistogramVec("whatsapp_send_duration_seconds", "Time WhatsApp took to accept a sent message.", sendLatencyBuckets, "account"),
+	Webhooks:         newCounterVec("whatsapp_webhook_deliveries_total", "Webhook delivery attempts, by outcome.", "event", "outcome"),
+	MediaDownloaded:  newCounterVec("whatsapp_media_download_bytes_total", "Bytes of media downloaded and decrypted, by media type.", "account", "type"),
+	Reconnects:       newCounterVec("whatsapp_reconnects_total", "Attempts to reconnect after losing the connection.", "account"),
+}
+
+// Kind of a message for the type label
+func messageMetricType(msg *waProto.Message) string {
+	if inner := msg.GetViewOnceMessageV2().GetMessage(); inner != nil {
+		msg = inner
+	}
+	if mediaType, _, _, _, _, _, _ := extractMediaInfo(msg); mediaType != "" {
+		return mediaType
+	}
+	switch {
+	case msg.GetConversation() != "" || msg.GetExtendedTextMessage() != nil:
+		return "text"
+	case msg.GetReactionMessage() != nil:
+		return "reaction"
+	case msg.GetLocationMessage() != nil || msg.GetLiveLocationMessage() != nil:
+		return "location"
+	case msg.GetContactMessage() != nil || msg.GetContactsArrayMessage() != nil:
+		return "contact"
+	case pollCreation(msg) != nil || msg.GetPollUpdateMessage() != nil:
+		return "poll"
+	case msg.GetProtocolMessage() != nil:
+		return "protocol"
+	}
+	return "other"
+}
+
+// Count a send attempt and how long WhatsApp took to answer it
+func (m *Metrics) observeSend(accountID string, msg *waProto.Message, started time.Time, err error) {
+	if err != nil {
+		m.SendErrors.Inc(accountID)
+		return
+	}
+	m.MessagesSent.Inc(accountID, messageMetricType(msg))
+	m.SendLatency.Observe(time.Since(started).Seconds(), accountID)
+}
+
+// Count a webhook delivery for as long as it is being attempted
+func (m *Metrics) webhookStarted()  { atomic.AddInt64(&m.webhooksPending, 1) }
+func (m *Metrics) webhookFinished() { atomic.AddInt64(&m.webhooksPending, -1) }
+
+// Label values joined into a map key
+func metricKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// Format label names and values as {name="value",...}
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", name, strconv.Quote(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", extra[i], strconv.Quote(extra[i+1])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Format a sample value the way Prometheus expects
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// counterVec is a counter split by label values
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64), keys: make(map[string][]string)}
+}
+
+// Add one to the counter with these label values
+func (c *counterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add to the counter with these label values
+func (c *counterVec) Add(delta float64, values ...string) {
+	key := metricKey(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.keys[key]; !ok {
+		c.keys[key] = values
+	}
+	c.values[key] += delta
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, c.keys[key]), formatValue(c.values[key]))
+	}
+}
+
+// histogramVec is a histogram split by label values
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+// Observations of one label combination
+type histogram struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+}
+
+// Record an observation with these label values
+func (h *histogramVec) Observe(value float64, values ...string) {
+	key := metricKey(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogram{values: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, series.values, "le", formatValue(bound)), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, series.values, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, series.values), formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, series.values), series.count)
+	}
+}
+
+// Write a gauge with one sample per label combination
+func writeGauge(w io.Writer, name, help string, labels []string, samples map[string][]string, values map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, samples[key]), formatValue(values[key]))
+	}
+}
+
+// Number of messages waiting in each of an account's queues
+func (a *Account) queueDepths() map[string]int {
+	depths := map[string]int{
+		"media_jobs": len(a.MediaJobs.queue),
+	}
+	if a.Downloader != nil {
+		depths["auto_download"] = len(a.Downloader.jobs)
+	}
+	tables := map[string]string{
+		"outbox":       "SELECT COUNT(*) FROM outbox WHERE status = ?",
+		"scheduled":    "SELECT COUNT(*) FROM scheduled_messages WHERE status = ?",
+		"broadcast":    "SELECT COUNT(*) FROM broadcast_recipients WHERE status = ?",
+		"policy_holds": "SELECT COUNT(*) FROM policy_holds WHERE status = ?",
+	}
+	statuses := map[string]string{
+		"outbox":       OutboxStatusPending,
+		"scheduled":    ScheduleStatusPending,
+		"broadcast":    BroadcastStatusPending,
+		"policy_holds": PolicyHoldStatusPending,
+	}
+	for queue, query := range tables {
+		var count int
+		if err := a.MessageStore.db.QueryRow(query, statuses[queue]).Scan(&count); err != nil {
+			a.Logger.Warnf("Failed to count %s queue: %v", queue, err)
+			continue
+		}
+		depths[queue] = count
+	}
+	return depths
+}
+
+// Handle GET /metrics
+func (am *AccountManager) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m := bridgeMetrics
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.MessagesSent.write(w)
+	m.MessagesReceived.write(w)
+	m.SendErrors.write(w)
+	m.SendLatency.write(w)
+	m.Webhooks.write(w)
+	writeGauge(w, "whatsapp_webhook_deliveries_pending", "Webhook deliveries still being attempted.", nil,
+		map[string][]string{"": nil}, map[string]float64{"": float64(atomic.LoadInt64(&m.webhooksPending))})
+	m.MediaDownloaded.write(w)
+	m.Reconnects.write(w)
+
+	connectedLabels, connected := map[string][]string{}, map[string]float64{}
+	queueLabels, queued := map[string][]string{}, map[string]float64{}
+	for _, account := range am.List() {
+		connectedLabels[account.ID] = []string{account.ID}
+		if account.Client.IsConnected() {
+			connected[account.ID] = 1
+		} else {
+			connected[account.ID] = 0
+		}
+		for queue, depth := range account.queueDepths() {
+			key := metricKey([]string{account.ID, queue})
+			queueLabels[key] = []string{account.ID, queue}
+			queued[key] = float64(depth)
+		}
+	}
+	writeGauge(w, "whatsapp_connected", "Whether the account is connected to WhatsApp.", []string{"account"}, connectedLabels, connected)
+	writeGauge(w, "whatsapp_queue_depth", "Messages waiting in each queue.", []string{"account", "queue"}, queueLabels, queued)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Schema changes to the message store, applied in order on startup, with a
+// directory per dialect. Each version is NNNN_name.up.sql, with a
+// NNNN_name.down.sql undoing it. Released files must never change; add a new
+// version to both directories instead.
+//
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var messageMigrations embed.FS
+
+// Name of a migration file
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Columns added before the schema was versioned. Databases from those
+// releases may lack any of them, and get them before the first migration.
+var legacyColumns = []struct{ table, name, definition string }{
+	{"messages", "is_view_once", "BOOLEAN DEFAULT 0"},
+	{"messages", "is_read", "BOOLEAN DEFAULT 1"},
+	{"messages", "message_type", "TEXT"},
+	{"messages", "caption", "TEXT"},
+	{"messages", "mime_type", "TEXT"},
+	{"messages", "quoted_message_id", "TEXT"},
+	{"messages", "quoted_sender", "TEXT"},
+	{"messages", "push_name", "TEXT"},
+	{"messages", "is_forwarded", "BOOLEAN DEFAULT 0"},
+	{"chats", "pinned", "BOOLEAN DEFAULT 0"},
+	{"chats", "archived", "BOOLEAN DEFAULT 0"},
+	{"chats", "muted", "BOOLEAN DEFAULT 0"},
+	{"chats", "muted_until", "TIMESTAMP"},
+	{"chats", "disappearing_timer", "INTEGER DEFAULT 0"},
+	{"messages", "expires_at", "TIMESTAMP"},
+	{"messages", "starred", "BOOLEAN DEFAULT 0"},
+	{"messages", "local_path", "TEXT"},
+	{"messages", "local_size", "INTEGER"},
+	{"messages", "local_mime", "TEXT"},
+	{"messages", "downloaded_at", "TIMESTAMP"},
+	{"messages", "thumbnail", "BLOB"},
+	{"messages", "media_accessed_at", "TIMESTAMP"},
+	{"chats", "media_keep", "BOOLEAN DEFAULT 0"},
+	{"chats", "media_max_age_days", "INTEGER DEFAULT 0"},
+	{"messages", "duration_seconds", "INTEGER"},
+	{"messages", "waveform", "BLOB"},
+	{"outbox", "traceparent", "TEXT"},
+}
+
+// Migration is one version of a schema
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// Migrator moves a database between schema versions, recording the
+// versions applied in its schema_version table
+type Migrator struct {
+	db         *DB
+	migrations []Migration
+}
+
+// SchemaVersion is a row of schema_version
+type SchemaVersion struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// Read the migrations in a directory of an embedded file system
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions skip from %d to %d", i, m.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Create a migrator for a message store
+func newMessageMigrator(db *DB) (*Migrator, error) {
+	dir := "migrations/sqlite"
+	if !db.isSQLite() {
+		dir = "migrations/postgres"
+	}
+	migrations, err := loadMigrations(messageMigrations, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Latest version this build knows
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Create schema_version, first bringing a database from before versioning
+// up to the baseline so the first migration finds every column it defines
+func (m *Migrator) prepare() error {
+	versioned, err := m.hasTable("schema_version")
+	if err != nil || versioned {
+		return err
+	}
+	// Postgres support came with versioning
+	legacy := false
+	if m.db.isSQLite() {
+		if legacy, err = m.hasTable("messages"); err != nil {
+			return err
+		}
+	}
+	if legacy {
+		tables := map[string]bool{}
+		for _, column := range legacyColumns {
+			exists, checked := tables[column.table]
+			if !checked {
+				if exists, err = m.hasTable(column.table); err != nil {
+					return err
+				}
+				tables[column.table] = exists
+			}
+			// Tables newer than the database come whole from the first migration
+			if !exists {
+				continue
+			}
+			if err := addColumnIfMissing(m.db, column.table, column.name, column.definition); err != nil {
+				return fmt.Errorf("failed to add %s.%s: %v", column.table, column.name, err)
+			}
+		}
+	}
+	_, err = m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT,
+		applied_at TIMESTAMP
+	)`)
+	return err
+}
+
+// Report whether a table exists
+func (m *Migrator) hasTable(name string) (bool, error) {
+	query := "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?"
+	if !m.db.isSQLite() {
+		query = "SELECT COUNT(*) > 0 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	}
+	var exists bool
+	err := m.db.QueryRow(query, name).Scan(&exists)
+	return exists, err
+}
+
+// Versions applied to the database, oldest first
+func (m *Migrator) Applied() ([]SchemaVersion, error) {
+	if err := m.prepare(); err != nil {
+		return nil, err
+	}
+	rows, err := m.db.Query("SELECT version, name, applied_at FROM schema_version ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []SchemaVersion
+	for rows.Next() {
+		var v SchemaVersion
+		if err := rows.Scan(&v.Version, &v.Name, &v.AppliedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// Current version of the database, 0 before the first migration
+func (m *Migrator) Version() (int, error) {
+	if err := m.prepare(); err != nil {
+		return 0, err
+	}
+	var version int
+	err := m.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// Apply every migration the database hasn't had yet, returning how many ran.
+// A database newer than this build is refused rather than used, as columns
+// it relies on may be gone.
+func (m *Migrator) Up() (int, error) {
+	version, err := m.Version()
+	if err != nil {
+		return 0, err
+	}
+	if version > m.Latest() {
+		return 0, fmt.Errorf("database schema version %d is newer than this bridge supports (%d); run the newer bridge with --rollback-to %d first", version, m.Latest(), m.Latest())
+	}
+	for _, migration := range m.migrations[version:] {
+		if err := m.apply(migration, migration.up, true); err != nil {
+			return 0, err
+		}
+	}
+	return m.Latest() - version, nil
+}
+
+// Undo migrations, newest first, until the database is at the target version
+func (m *Migrator) Down(target int) error {
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if version > m.Latest() {
+		return fmt.Errorf("database schema version %d is newer than this bridge, which can't undo it", version)
+	}
+	for v := version; v > target; v-- {
+		migration := m.migrations[v-1]
+		if migration.down == "" {
+			return fmt.Errorf("migration %d_%s can't be undone", migration.Version, migration.Name)
+		}
+		if err := m.apply(migration, migration.down, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run one migration and record it in the same transaction
+func (m *Migrator) apply(migration Migration, script string, up bool) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return fmt.Errorf("migration %d_%s failed: %v", migration.Version, migration.Name, err)
+	}
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+			migration.Version, migration.Name, time.Now().UTC())
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_version WHERE version = ?", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Roll the message store of every account back to a schema version, for
+// --rollback-to before running an older bridge
+func rollbackMessageStores(cfg *Config, baseDir string, version int) error {
+	dirs := map[string]string{DefaultAccountID: baseDir}
+	entries, err := os.ReadDir(filepath.Join(baseDir, "accounts"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read accounts directory: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && accountIDPattern.MatchString(entry.Name()) {
+			dirs[entry.Name()] = filepath.Join(baseDir, "accounts", entry.Name())
+		}
+	}
+
+	for id, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, "messages.db")); cfg.DatabaseURL == "" && os.IsNotExist(err) {
+			continue
+		}
+		pool, dialect, err := cfg.openAccountDatabase(id, dir, "messages.db", "_foreign_keys=on")
+		if err != nil {
+			return fmt.Errorf("failed to open the message store of %s: %v", id, err)
+		}
+		migrator, err := newMessageMigrator(newDB(pool, dialect))
+		if err == nil {
+			err = migrator.Down(version)
+		}
+		pool.Close()
+		if err != nil {
+			return fmt.Errorf("failed to roll back the message store of %s: %v", id, err)
+		}
+		bridgeLog.Infof("Rolled the message store of %s back to schema version %d", id, version)
+	}
+	return nil
+}
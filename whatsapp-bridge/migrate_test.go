@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Schema of the first release, before any column was added
+const baselineSchema = `
+	CREATE TABLE chats (
+		jid TEXT PRIMARY KEY,
+		name TEXT,
+		last_message_time TIMESTAMP
+	);
+	CREATE TABLE messages (
+		id TEXT,
+		chat_jid TEXT,
+		sender TEXT,
+		content TEXT,
+		timestamp TIMESTAMP,
+		is_from_me BOOLEAN,
+		media_type TEXT,
+		filename TEXT,
+		url TEXT,
+		media_key BLOB,
+		file_sha256 BLOB,
+		file_enc_sha256 BLOB,
+		file_length INTEGER,
+		PRIMARY KEY (id, chat_jid),
+		FOREIGN KEY (chat_jid) REFERENCES chats(jid)
+	);
+	INSERT INTO chats (jid, name, last_message_time) VALUES ('1@s.whatsapp.net', 'A', '2024-01-01 10:00:00+00:00');
+	INSERT INTO messages (id, chat_jid, sender, content, timestamp, is_from_me)
+		VALUES ('m1', '1@s.whatsapp.net', '1', 'hello', '2024-01-01 10:00:00+00:00', 0);
+`
+
+func TestMigrateBaselineSchema(t *testing.T) {
+	pool, err := openSQLite(filepath.Join(t.TempDir(), "messages.db"), "_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if _, err := pool.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	migrator, err := newMessageMigrator(newDB(pool, DialectSQLite))
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("migrating the baseline schema failed: %v", err)
+	}
+	if applied != migrator.Latest() {
+		t.Errorf("applied %d migrations, want %d", applied, migrator.Latest())
+	}
+
+	var content string
+	var starred bool
+	if err := pool.QueryRow("SELECT content, starred FROM messages WHERE id = 'm1'").Scan(&content, &starred); err != nil {
+		t.Fatalf("reading the upgraded message failed: %v", err)
+	}
+	if content != "hello" || starred {
+		t.Errorf("upgraded message is %q starred=%v, want %q unstarred", content, starred, "hello")
+	}
+	if _, err := pool.Exec("INSERT INTO outbox (id, chat_jid, traceparent) VALUES ('o1', '1@s.whatsapp.net', '')"); err != nil {
+		t.Errorf("outbox wasn't created: %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+-- Schema of the message store in Postgres. Booleans are 0 and 1 as in
+-- SQLite so the same queries work on both.
+
+CREATE TABLE IF NOT EXISTS chats (
+    jid TEXT PRIMARY KEY,
+    name TEXT,
+    last_message_time TIMESTAMPTZ,
+    pinned BIGINT DEFAULT 0,
+    archived BIGINT DEFAULT 0,
+    muted BIGINT DEFAULT 0,
+    muted_until TIMESTAMPTZ,
+    disappearing_timer BIGINT DEFAULT 0,
+    media_keep BIGINT DEFAULT 0,
+    media_max_age_days BIGINT DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id TEXT,
+    chat_jid TEXT,
+    sender TEXT,
+    content TEXT,
+    timestamp TIMESTAMPTZ,
+    is_from_me BIGINT,
+    media_type TEXT,
+    filename TEXT,
+    url TEXT,
+    media_key BYTEA,
+    file_sha256 BYTEA,
+    file_enc_sha256 BYTEA,
+    file_length BIGINT,
+    is_view_once BIGINT DEFAULT 0,
+    is_read BIGINT DEFAULT 1,
+    message_type TEXT,
+    caption TEXT,
+    mime_type TEXT,
+    quoted_message_id TEXT,
+    quoted_sender TEXT,
+    push_name TEXT,
+    is_forwarded BIGINT DEFAULT 0,
+    expires_at TIMESTAMPTZ,
+    starred BIGINT DEFAULT 0,
+    local_path TEXT,
+    local_size BIGINT,
+    local_mime TEXT,
+    downloaded_at TIMESTAMPTZ,
+    thumbnail BYTEA,
+    media_accessed_at TIMESTAMPTZ,
+    duration_seconds BIGINT,
+    waveform BYTEA,
+    PRIMARY KEY (id, chat_jid),
+    FOREIGN KEY (chat_jid) REFERENCES chats(jid)
+);
+
+CREATE TABLE IF NOT EXISTS reactions (
+    message_id TEXT,
+    chat_jid TEXT,
+    sender TEXT,
+    emoji TEXT,
+    timestamp TIMESTAMPTZ,
+    PRIMARY KEY (message_id, chat_jid, sender)
+);
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    message_id TEXT,
+    chat_jid TEXT,
+    content TEXT,
+    edited_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS message_receipts (
+    message_id TEXT,
+    chat_jid TEXT,
+    recipient TEXT,
+    status TEXT,
+    timestamp TIMESTAMPTZ,
+    PRIMARY KEY (message_id, chat_jid, recipient)
+);
+
+CREATE TABLE IF NOT EXISTS polls (
+    id TEXT,
+    chat_jid TEXT,
+    sender TEXT,
+    question TEXT,
+    options TEXT,
+    selectable_count BIGINT,
+    timestamp TIMESTAMPTZ,
+    PRIMARY KEY (id, chat_jid)
+);
+
+CREATE TABLE IF NOT EXISTS poll_votes (
+    poll_id TEXT,
+    chat_jid TEXT,
+    voter TEXT,
+    option TEXT,
+    timestamp TIMESTAMPTZ,
+    PRIMARY KEY (poll_id, chat_jid, voter, option)
+);
+
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+    id TEXT PRIMARY KEY,
+    payload TEXT,
+    send_at TIMESTAMPTZ,
+    next_attempt_at TIMESTAMPTZ,
+    status TEXT,
+    attempts BIGINT DEFAULT 0,
+    last_error TEXT,
+    message_id TEXT,
+    created_at TIMESTAMPTZ,
+    sent_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS broadcasts (
+    id TEXT PRIMARY KEY,
+    payload TEXT,
+    status TEXT,
+    delay_ms BIGINT,
+    jitter_ms BIGINT,
+    created_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS broadcast_recipients (
+    broadcast_id TEXT,
+    jid TEXT,
+    position BIGINT,
+    status TEXT,
+    message_id TEXT,
+    error TEXT,
+    sent_at TIMESTAMPTZ,
+    PRIMARY KEY (broadcast_id, jid),
+    FOREIGN KEY (broadcast_id) REFERENCES broadcasts(id)
+);
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id TEXT PRIMARY KEY,
+    chat_jid TEXT,
+    message BYTEA,
+    status TEXT,
+    attempts BIGINT DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMPTZ,
+    next_attempt_at TIMESTAMPTZ,
+    sent_at TIMESTAMPTZ,
+    traceparent TEXT
+);
+
+CREATE TABLE IF NOT EXISTS policy_holds (
+    id TEXT PRIMARY KEY,
+    chat_jid TEXT,
+    message BYTEA,
+    rule TEXT,
+    reason TEXT,
+    status TEXT,
+    created_at TIMESTAMPTZ,
+    resolved_at TIMESTAMPTZ,
+    message_id TEXT
+);
+
+CREATE TABLE IF NOT EXISTS policy_decisions (
+    id BIGSERIAL PRIMARY KEY,
+    time TIMESTAMPTZ,
+    chat_jid TEXT,
+    action TEXT,
+    rule TEXT,
+    reason TEXT,
+    hold_id TEXT
+);
+
+CREATE TABLE IF NOT EXISTS contacts (
+    jid TEXT PRIMARY KEY,
+    phone TEXT,
+    first_name TEXT,
+    full_name TEXT,
+    push_name TEXT,
+    business_name TEXT,
+    verified BIGINT DEFAULT 0,
+    status TEXT,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS avatars (
+    jid TEXT,
+    type TEXT,
+    picture_id TEXT,
+    path TEXT,
+    fetched_at TIMESTAMPTZ,
+    PRIMARY KEY (jid, type)
+);
+
+CREATE TABLE IF NOT EXISTS presence (
+    jid TEXT PRIMARY KEY,
+    available BIGINT,
+    last_seen TIMESTAMPTZ,
+    last_seen_hidden BIGINT DEFAULT 0,
+    subscribed_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS blocklist (
+    jid TEXT PRIMARY KEY,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS groups (
+    jid TEXT PRIMARY KEY,
+    name TEXT,
+    topic TEXT,
+    owner_jid TEXT,
+    created_at TIMESTAMPTZ,
+    announce BIGINT DEFAULT 0,
+    locked BIGINT DEFAULT 0,
+    disappearing_timer BIGINT DEFAULT 0,
+    join_approval_required BIGINT DEFAULT 0,
+    member_add_mode TEXT,
+    is_community BIGINT DEFAULT 0,
+    linked_parent_jid TEXT,
+    is_default_sub_group BIGINT DEFAULT 0,
+    participant_count BIGINT DEFAULT 0,
+    is_admin BIGINT DEFAULT 0,
+    participants TEXT,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS newsletters (
+    jid TEXT PRIMARY KEY,
+    name TEXT,
+    description TEXT,
+    invite_link TEXT,
+    subscriber_count BIGINT DEFAULT 0,
+    verified BIGINT DEFAULT 0,
+    role TEXT,
+    muted BIGINT DEFAULT 0,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS newsletter_messages (
+    newsletter_jid TEXT,
+    server_id BIGINT,
+    id TEXT,
+    type TEXT,
+    content TEXT,
+    caption TEXT,
+    timestamp TIMESTAMPTZ,
+    views BIGINT DEFAULT 0,
+    reactions TEXT,
+    PRIMARY KEY (newsletter_jid, server_id)
+);
+
+CREATE TABLE IF NOT EXISTS labels (
+    id TEXT PRIMARY KEY,
+    name TEXT,
+    color BIGINT,
+    predefined_id BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS label_chats (
+    label_id TEXT,
+    chat_jid TEXT,
+    PRIMARY KEY (label_id, chat_jid)
+);
+
+CREATE TABLE IF NOT EXISTS label_messages (
+    label_id TEXT,
+    chat_jid TEXT,
+    message_id TEXT,
+    PRIMARY KEY (label_id, chat_jid, message_id)
+);
+
+-- Lookups by chat or sender over a time range
+CREATE INDEX IF NOT EXISTS idx_messages_chat_time ON messages (chat_jid, timestamp);
+CREATE INDEX IF NOT EXISTS idx_messages_sender_time ON messages (sender, timestamp);
+CREATE INDEX IF NOT EXISTS idx_chats_last_message_time ON chats (last_message_time);
+CREATE INDEX IF NOT EXISTS idx_contacts_phone ON contacts (phone);
@@ -0,0 +1,4 @@
+ALTER TABLE messages DROP COLUMN is_animated;
+ALTER TABLE messages DROP COLUMN forwarding_score;
+ALTER TABLE messages DROP COLUMN height;
+ALTER TABLE messages DROP COLUMN width;
@@ -0,0 +1,6 @@
+-- Kept so forwarded media looks like the original: its size on screen, how
+-- often it was forwarded and whether a sticker is animated
+ALTER TABLE messages ADD COLUMN width BIGINT;
+ALTER TABLE messages ADD COLUMN height BIGINT;
+ALTER TABLE messages ADD COLUMN forwarding_score BIGINT;
+ALTER TABLE messages ADD COLUMN is_animated BIGINT DEFAULT 0;
@@ -0,0 +1,26 @@
+-- Drops everything, including the search index built when SQLite has FTS5
+
+DROP TABLE IF EXISTS messages_fts;
+DROP TABLE IF EXISTS label_messages;
+DROP TABLE IF EXISTS label_chats;
+DROP TABLE IF EXISTS labels;
+DROP TABLE IF EXISTS newsletter_messages;
+DROP TABLE IF EXISTS newsletters;
+DROP TABLE IF EXISTS groups;
+DROP TABLE IF EXISTS blocklist;
+DROP TABLE IF EXISTS presence;
+DROP TABLE IF EXISTS avatars;
+DROP TABLE IF EXISTS contacts;
+DROP TABLE IF EXISTS policy_decisions;
+DROP TABLE IF EXISTS policy_holds;
+DROP TABLE IF EXISTS outbox;
+DROP TABLE IF EXISTS broadcast_recipients;
+DROP TABLE IF EXISTS broadcasts;
+DROP TABLE IF EXISTS scheduled_messages;
+DROP TABLE IF EXISTS poll_votes;
+DROP TABLE IF EXISTS polls;
+DROP TABLE IF EXISTS message_receipts;
+DROP TABLE IF EXISTS message_edits;
+DROP TABLE IF EXISTS reactions;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS chats;
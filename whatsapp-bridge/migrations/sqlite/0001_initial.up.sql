@@ -0,0 +1,273 @@
+-- Schema of messages.db as of the first versioned release. Every statement
+-- is idempotent so databases created before versioning can run it too.
+
+CREATE TABLE IF NOT EXISTS chats (
+    jid TEXT PRIMARY KEY,
+    name TEXT,
+    last_message_time TIMESTAMP,
+    pinned BOOLEAN DEFAULT 0,
+    archived BOOLEAN DEFAULT 0,
+    muted BOOLEAN DEFAULT 0,
+    muted_until TIMESTAMP,
+    disappearing_timer INTEGER DEFAULT 0,
+    media_keep BOOLEAN DEFAULT 0,
+    media_max_age_days INTEGER DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id TEXT,
+    chat_jid TEXT,
+    sender TEXT,
+    content TEXT,
+    timestamp TIMESTAMP,
+    is_from_me BOOLEAN,
+    media_type TEXT,
+    filename TEXT,
+    url TEXT,
+    media_key BLOB,
+    file_sha256 BLOB,
+    file_enc_sha256 BLOB,
+    file_length INTEGER,
+    is_view_once BOOLEAN DEFAULT 0,
+    is_read BOOLEAN DEFAULT 1,
+    message_type TEXT,
+    caption TEXT,
+    mime_type TEXT,
+    quoted_message_id TEXT,
+    quoted_sender TEXT,
+    push_name TEXT,
+    is_forwarded BOOLEAN DEFAULT 0,
+    expires_at TIMESTAMP,
+    starred BOOLEAN DEFAULT 0,
+    local_path TEXT,
+    local_size INTEGER,
+    local_mime TEXT,
+    downloaded_at TIMESTAMP,
+    thumbnail BLOB,
+    media_accessed_at TIMESTAMP,
+    duration_seconds INTEGER,
+    waveform BLOB,
+    PRIMARY KEY (id, chat_jid),
+    FOREIGN KEY (chat_jid) REFERENCES chats(jid)
+);
+
+CREATE TABLE IF NOT EXISTS reactions (
+    message_id TEXT,
+    chat_jid TEXT,
+    sender TEXT,
+    emoji TEXT,
+    timestamp TIMESTAMP,
+    PRIMARY KEY (message_id, chat_jid, sender)
+);
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    message_id TEXT,
+    chat_jid TEXT,
+    content TEXT,
+    edited_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS message_receipts (
+    message_id TEXT,
+    chat_jid TEXT,
+    recipient TEXT,
+    status TEXT,
+    timestamp TIMESTAMP,
+    PRIMARY KEY (message_id, chat_jid, recipient)
+);
+
+CREATE TABLE IF NOT EXISTS polls (
+    id TEXT,
+    chat_jid TEXT,
+    sender TEXT,
+    question TEXT,
+    options TEXT,
+    selectable_count INTEGER,
+    timestamp TIMESTAMP,
+    PRIMARY KEY (id, chat_jid)
+);
+
+CREATE TABLE IF NOT EXISTS poll_votes (
+    poll_id TEXT,
+    chat_jid TEXT,
+    voter TEXT,
+    option TEXT,
+    timestamp TIMESTAMP,
+    PRIMARY KEY (poll_id, chat_jid, voter, option)
+);
+
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+    id TEXT PRIMARY KEY,
+    payload TEXT,
+    send_at TIMESTAMP,
+    next_attempt_at TIMESTAMP,
+    status TEXT,
+    attempts INTEGER DEFAULT 0,
+    last_error TEXT,
+    message_id TEXT,
+    created_at TIMESTAMP,
+    sent_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS broadcasts (
+    id TEXT PRIMARY KEY,
+    payload TEXT,
+    status TEXT,
+    delay_ms INTEGER,
+    jitter_ms INTEGER,
+    created_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS broadcast_recipients (
+    broadcast_id TEXT,
+    jid TEXT,
+    position INTEGER,
+    status TEXT,
+    message_id TEXT,
+    error TEXT,
+    sent_at TIMESTAMP,
+    PRIMARY KEY (broadcast_id, jid),
+    FOREIGN KEY (broadcast_id) REFERENCES broadcasts(id)
+);
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id TEXT PRIMARY KEY,
+    chat_jid TEXT,
+    message BLOB,
+    status TEXT,
+    attempts INTEGER DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP,
+    next_attempt_at TIMESTAMP,
+    sent_at TIMESTAMP,
+    traceparent TEXT
+);
+
+CREATE TABLE IF NOT EXISTS policy_holds (
+    id TEXT PRIMARY KEY,
+    chat_jid TEXT,
+    message BLOB,
+    rule TEXT,
+    reason TEXT,
+    status TEXT,
+    created_at TIMESTAMP,
+    resolved_at TIMESTAMP,
+    message_id TEXT
+);
+
+CREATE TABLE IF NOT EXISTS policy_decisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    time TIMESTAMP,
+    chat_jid TEXT,
+    action TEXT,
+    rule TEXT,
+    reason TEXT,
+    hold_id TEXT
+);
+
+CREATE TABLE IF NOT EXISTS contacts (
+    jid TEXT PRIMARY KEY,
+    phone TEXT,
+    first_name TEXT,
+    full_name TEXT,
+    push_name TEXT,
+    business_name TEXT,
+    verified BOOLEAN DEFAULT 0,
+    status TEXT,
+    updated_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS avatars (
+    jid TEXT,
+    type TEXT,
+    picture_id TEXT,
+    path TEXT,
+    fetched_at TIMESTAMP,
+    PRIMARY KEY (jid, type)
+);
+
+CREATE TABLE IF NOT EXISTS presence (
+    jid TEXT PRIMARY KEY,
+    available BOOLEAN,
+    last_seen TIMESTAMP,
+    last_seen_hidden BOOLEAN DEFAULT 0,
+    subscribed_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS blocklist (
+    jid TEXT PRIMARY KEY,
+    updated_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS groups (
+    jid TEXT PRIMARY KEY,
+    name TEXT,
+    topic TEXT,
+    owner_jid TEXT,
+    created_at TIMESTAMP,
+    announce BOOLEAN DEFAULT 0,
+    locked BOOLEAN DEFAULT 0,
+    disappearing_timer INTEGER DEFAULT 0,
+    join_approval_required BOOLEAN DEFAULT 0,
+    member_add_mode TEXT,
+    is_community BOOLEAN DEFAULT 0,
+    linked_parent_jid TEXT,
+    is_default_sub_group BOOLEAN DEFAULT 0,
+    participant_count INTEGER DEFAULT 0,
+    is_admin BOOLEAN DEFAULT 0,
+    participants TEXT,
+    updated_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS newsletters (
+    jid TEXT PRIMARY KEY,
+    name TEXT,
+    description TEXT,
+    invite_link TEXT,
+    subscriber_count INTEGER DEFAULT 0,
+    verified BOOLEAN DEFAULT 0,
+    role TEXT,
+    muted BOOLEAN DEFAULT 0,
+    updated_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS newsletter_messages (
+    newsletter_jid TEXT,
+    server_id INTEGER,
+    id TEXT,
+    type TEXT,
+    content TEXT,
+    caption TEXT,
+    timestamp TIMESTAMP,
+    views INTEGER DEFAULT 0,
+    reactions TEXT,
+    PRIMARY KEY (newsletter_jid, server_id)
+);
+
+CREATE TABLE IF NOT EXISTS labels (
+    id TEXT PRIMARY KEY,
+    name TEXT,
+    color INTEGER,
+    predefined_id INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS label_chats (
+    label_id TEXT,
+    chat_jid TEXT,
+    PRIMARY KEY (label_id, chat_jid)
+);
+
+CREATE TABLE IF NOT EXISTS label_messages (
+    label_id TEXT,
+    chat_jid TEXT,
+    message_id TEXT,
+    PRIMARY KEY (label_id, chat_jid, message_id)
+);
+
+-- Lookups by chat or sender over a time range
+CREATE INDEX IF NOT EXISTS idx_messages_chat_time ON messages (chat_jid, timestamp);
+CREATE INDEX IF NOT EXISTS idx_messages_sender_time ON messages (sender, timestamp);
+CREATE INDEX IF NOT EXISTS idx_chats_last_message_time ON chats (last_message_time);
+CREATE INDEX IF NOT EXISTS idx_contacts_phone ON contacts (phone);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_messages_expires_at;
+DROP INDEX IF EXISTS idx_scheduled_messages_due;
+DROP INDEX IF EXISTS idx_outbox_due;
@@ -0,0 +1,4 @@
+-- The queues and the disappearing message purger poll for due rows
+CREATE INDEX IF NOT EXISTS idx_outbox_due ON outbox (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_messages_expires_at ON messages (expires_at) WHERE expires_at IS NOT NULL;
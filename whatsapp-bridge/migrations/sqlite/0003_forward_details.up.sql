@@ -0,0 +1,6 @@
+-- Kept so forwarded media looks like the original: its size on screen, how
+-- often it was forwarded and whether a sticker is animated
+ALTER TABLE messages ADD COLUMN width INTEGER;
+ALTER TABLE messages ADD COLUMN height INTEGER;
+ALTER TABLE messages ADD COLUMN forwarding_score INTEGER;
+ALTER TABLE messages ADD COLUMN is_animated BOOLEAN DEFAULT 0;
@@ -0,0 +1,4 @@
+-- The sender column only holds the user part, which can't tell a phone
+-- number from a LID. History sync stored full JIDs there, which carry over.
+ALTER TABLE messages ADD COLUMN sender_jid TEXT;
+UPDATE messages SET sender_jid = sender WHERE sender LIKE '%@%';
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Sent to webhooks and event streams when a followed channel posts
+const WebhookEventNewsletterMessage = "newsletter_message"
+
+// Channel invite links look like https://whatsapp.com/channel/<code>
+const newsletterLinkPrefix = "https://whatsapp.com/channel/"
+
+// Page size limits for channel posts
+const (
+	defaultNewsletterPosts = 20
+	maxNewsletterPosts     = 100
+)
+
+// Newsletter is a WhatsApp channel
+type Newsletter struct {
+	JID             string `json:"jid"`
+	Name            string `json:"name"`
+	Description     string `json:"description,omitempty"`
+	InviteLink      string `json:"invite_link,omitempty"`
+	SubscriberCount int    `json:"subscriber_count"`
+	Verified        bool   `json:"verified"`
+	// subscriber, admin or owner; guest when not followed
+	Role      string    `json:"role,omitempty"`
+	Muted     bool      `json:"muted"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewsletterPost is a message posted to a channel
+type NewsletterPost struct {
+	NewsletterJID string `json:"newsletter_jid"`
+	// Channel posts are addressed by their server ID when reacting or marking them viewed
+	ServerID  int            `json:"server_id"`
+	ID        string         `json:"id,omitempty"`
+	Type      string         `json:"type,omitempty"`
+	Content   string         `json:"content,omitempty"`
+	Caption   string         `json:"caption,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
+	Views     int            `json:"views"`
+	Reactions map[string]int `json:"reactions,omitempty"`
+}
+
+// NewslettersResponse represents the response for the followed channels API
+type NewslettersResponse struct {
+	Newsletters []*Newsletter `json:"newsletters"`
+	Total       int           `json:"total"`
+	// Set when WhatsApp couldn't be reached and the cached list is returned
+	Cached bool `json:"cached"`
+}
+
+// NewsletterPostsResponse represents the response for the channel posts API
+type NewsletterPostsResponse struct {
+	JID      string            `json:"jid"`
+	Messages []*NewsletterPost `json:"messages"`
+	HasMore  bool              `json:"has_more"`
+	// Pass as before to get the next, older page
+	NextBefore int  `json:"next_before,omitempty"`
+	Cached     bool `json:"cached"`
+}
+
+// NewsletterResponse represents the response for the channel follow, reaction and view APIs
+type NewsletterResponse struct {
+	Success    bool        `json:"success"`
+	Message    string      `json:"message"`
+	JID        string      `json:"jid,omitempty"`
+	Newsletter *Newsletter `json:"newsletter,omitempty"`
+}
+
+// FollowNewsletterRequest represents the request body for the channel follow API
+type FollowNewsletterRequest struct {
+	JID string `json:"jid"`
+	// A channel invite link or just its code, instead of the JID
+	Invite string `json:"invite"`
+}
+
+// NewsletterReactionRequest represents the request body for reacting to a channel post
+type NewsletterReactionRequest struct {
+	// An emoji; empty removes our reaction
+	Reaction string `json:"reaction"`
+}
+
+// NewsletterViewsRequest represents the request body for marking channel posts viewed
+type NewsletterViewsRequest struct {
+	ServerIDs []int `json:"server_ids"`
+}
+
+// Build a channel from whatsmeow's metadata
+func newsletterFromMetadata(meta *types.NewsletterMetadata) *Newsletter {
+	newsletter := &Newsletter{
+		JID:             meta.ID.String(),
+		Name:            meta.ThreadMeta.Name.Text,
+		Description:     meta.ThreadMeta.Description.Text,
+		SubscriberCount: meta.ThreadMeta.SubscriberCount,
+		Verified:        meta.ThreadMeta.VerificationState == types.NewsletterVerificationStateVerified,
+		UpdatedAt:       time.Now().UTC(),
+	}
+	if meta.ThreadMeta.InviteCode != "" {
+		newsletter.InviteLink = newsletterLinkPrefix + meta.ThreadMeta.InviteCode
+	}
+	if meta.ViewerMeta != nil {
+		newsletter.Role = string(meta.ViewerMeta.Role)
+		newsletter.Muted = meta.ViewerMeta.Mute == types.NewsletterMuteOn
+	}
+	return newsletter
+}
+
+// Build a channel post from a fetched message or live update. Live updates
+// only carry counts, so their content is empty.
+func newsletterPost(jid types.JID, msg *types.NewsletterMessage) *NewsletterPost {
+	post := &NewsletterPost{
+		NewsletterJID: jid.String(),
+		ServerID:      int(msg.MessageServerID),
+		ID:            msg.MessageID,
+		Timestamp:     msg.Timestamp,
+		Views:         msg.ViewsCount,
+		Reactions:     msg.ReactionCounts,
+	}
+	if msg.Message != nil {
+		details := extractMessageDetails(msg.Message)
+		post.Type, post.Caption = details.Type, details.Caption
+		post.Content = extractTextContent(msg.Message)
+	}
+	return post
+}
+
+// Extract the code from a channel invite link, accepting the bare code as well
+func newsletterInviteCode(link string) (string, error) {
+	code := strings.TrimSpace(link)
+	code = strings.TrimPrefix(code, "http://")
+	code = strings.TrimPrefix(code, "https://")
+	code = strings.TrimPrefix(code, "www.")
+	code = strings.TrimPrefix(code, "whatsapp.com/channel/")
+	code = strings.TrimRight(code, "/")
+	if code == "" || strings.ContainsAny(code, "/?# ") {
+		return "", fmt.Errorf("invalid channel invite link: %s", link)
+	}
+	return code, nil
+}
+
+// Parse a channel JID from a request
+func parseNewsletterJID(value string) (types.JID, error) {
+	jid, err := types.ParseJID(strings.TrimSpace(value))
+	if err != nil || jid.Server != types.NewsletterServer || jid.User == "" {
+		return types.JID{}, fmt.Errorf("invalid channel JID: %s", value)
+	}
+	return jid, nil
+}
+
+// Cache a followed channel
+func (store *MessageStore) StoreNewsletter(newsletter *Newsletter) error {
+	return storeNewsletter(store.db, newsletter)
+}
+
+// Replace the cached channels with the full list of followed ones
+func (store *MessageStore) StoreNewsletters(newsletters []*Newsletter) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM newsletters"); err != nil {
+		return err
+	}
+	for _, newsletter := range newsletters {
+		if err := storeNewsletter(tx, newsletter); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Write a channel
+func storeNewsletter(db execer, newsletter *Newsletter) error {
+	_, err := db.Exec(
+		`INSERT OR REPLACE INTO newsletters (jid, name, description, invite_link, subscriber_count, verified, role, muted, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		newsletter.JID, newsletter.Name, newsletter.Description, newsletter.InviteLink, newsletter.SubscriberCount,
+		newsletter.Verified, newsletter.Role, newsletter.Muted, newsletter.UpdatedAt,
+	)
+	return err
+}
+
+// Forget a channel and its posts
+func (store *MessageStore) DeleteNewsletter(jid string) error {
+	if _, err := store.db.Exec("DELETE FROM newsletter_messages WHERE newsletter_jid = ?", jid); err != nil {
+		return err
+	}
+	_, err := store.db.Exec("DELETE FROM newsletters WHERE jid = ?", jid)
+	return err
+}
+
+// Update whether a cached channel is muted
+func (store *MessageStore) SetNewsletterMuted(jid string, muted bool) error {
+	_, err := store.db.Exec("UPDATE newsletters SET muted = ? WHERE jid = ?", muted, jid)
+	return err
+}
+
+// List the cached channels by name
+func (store *MessageStore) ListNewsletters() ([]*Newsletter, error) {
+	rows, err := store.db.Query(
+		`SELECT jid, COALESCE(name, ''), COALESCE(description, ''), COALESCE(invite_link, ''), COALESCE(subscriber_count, 0),
+			COALESCE(verified, 0), COALESCE(role, ''), COALESCE(muted, 0), updated_at
+		FROM newsletters ORDER BY LOWER(name), jid`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	newsletters := []*Newsletter{}
+	for rows.Next() {
+		var n Newsletter
+		if err := rows.Scan(&n.JID, &n.Name, &n.Description, &n.InviteLink, &n.SubscriberCount,
+			&n.Verified, &n.Role, &n.Muted, &n.UpdatedAt); err != nil {
+			return nil, err
+		}
+		newsletters = append(newsletters, &n)
+	}
+	return newsletters, rows.Err()
+}
+
+// Save a channel post. Counts always change; content is kept when the
+// update doesn't carry any, as live updates don't.
+func (store *MessageStore) StoreNewsletterPost(post *NewsletterPost) error {
+	var reactions interface{}
+	if post.Reactions != nil {
+		data, err := json.Marshal(post.Reactions)
+		if err != nil {
+			return err
+		}
+		reactions = string(data)
+	}
+	var timestamp interface{}
+	if !post.Timestamp.IsZero() {
+		timestamp = post.Timestamp
+	}
+	_, err := store.db.Exec(
+		`INSERT INTO newsletter_messages (newsletter_jid, server_id, id, type, content, caption, timestamp, views, reactions)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (newsletter_jid, server_id) DO UPDATE SET
+			id = CASE WHEN excluded.id = '' THEN newsletter_messages.id ELSE excluded.id END,
+			type = CASE WHEN excluded.type = '' THEN newsletter_messages.type ELSE excluded.type END,
+			content = CASE WHEN excluded.type = '' THEN newsletter_messages.content ELSE excluded.content END,
+			caption = CASE WHEN excluded.type = '' THEN newsletter_messages.caption ELSE excluded.caption END,
+			timestamp = COALESCE(newsletter_messages.timestamp, excluded.timestamp),
+			views = CASE WHEN excluded.views > COALESCE(newsletter_messages.views, 0) THEN excluded.views ELSE COALESCE(newsletter_messages.views, 0) END,
+			reactions = COALESCE(excluded.reactions, newsletter_messages.reactions)`,
+		post.NewsletterJID, post.ServerID, post.ID, post.Type, post.Content, post.Caption, timestamp, post.Views, reactions,
+	)
+	return err
+}
+
+// Get stored posts of a channel, newest first, older than a server ID when
+// before is set
+func (store *MessageStore) GetNewsletterPosts(jid string, before, limit int) ([]*NewsletterPost, error) {
+	query := `SELECT newsletter_jid, server_id, COALESCE(id, ''), COALESCE(type, ''), COALESCE(content, ''),
+			COALESCE(caption, ''), timestamp, COALESCE(views, 0), reactions
+		FROM newsletter_messages WHERE newsletter_jid = ?`
+	args := []interface{}{jid}
+	if before > 0 {
+		query += " AND server_id < ?"
+		args = append(args, before)
+	}
+	query += " ORDER BY server_id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []*NewsletterPost{}
+	for rows.Next() {
+		var post NewsletterPost
+		var timestamp sql.NullTime
+		var reactions sql.NullString
+		if err := rows.Scan(&post.NewsletterJID, &post.ServerID, &post.ID, &post.Type, &post.Content,
+			&post.Caption, &timestamp, &post.Views, &reactions); err != nil {
+			return nil, err
+		}
+		post.Timestamp = timestamp.Time
+		if reactions.Valid {
+			json.Unmarshal([]byte(reactions.String), &post.Reactions)
+		}
+		posts = append(posts, &post)
+	}
+	return posts, rows.Err()
+}
+
+// Store a channel post received live and forward it
+func (a *Account) handleNewsletterMessage(msg *events.Message) {
+	details := extractMessageDetails(msg.Message)
+	post := &NewsletterPost{
+		NewsletterJID: msg.Info.Chat.String(),
+		ServerID:      int(msg.Info.ServerID),
+		ID:            msg.Info.ID,
+		Type:          details.Type,
+		Content:       extractTextContent(msg.Message),
+		Caption:       details.Caption,
+		Timestamp:     msg.Info.Timestamp,
+	}
+	if err := a.MessageStore.StoreNewsletterPost(post); err != nil {
+		a.Logger.Warnf("Failed to store channel post %s in %s: %v", msg.Info.ID, msg.Info.Chat, err)
+	}
+	a.Notifier.Notify(a.ID, WebhookEventNewsletterMessage, map[string]interface{}{
+		"chat_jid":     post.NewsletterJID,
+		"server_id":    post.ServerID,
+		"id":           post.ID,
+		"message_type": post.Type,
+		"content":      post.Content,
+		"caption":      post.Caption,
+		"timestamp":    post.Timestamp,
+	})
+}
+
+// Keep the cached channels and post counts in sync with server updates
+func (a *Account) handleNewsletterEvent(evt interface{}) {
+	var err error
+	switch v := evt.(type) {
+	case *events.NewsletterJoin:
+		err = a.MessageStore.StoreNewsletter(newsletterFromMetadata(&v.NewsletterMetadata))
+	case *events.NewsletterLeave:
+		err = a.MessageStore.DeleteNewsletter(v.ID.String())
+	case *events.NewsletterMuteChange:
+		err = a.MessageStore.SetNewsletterMuted(v.ID.String(), v.Mute == types.NewsletterMuteOn)
+	case *events.NewsletterLiveUpdate:
+		for _, msg := range v.Messages {
+			if err = a.MessageStore.StoreNewsletterPost(newsletterPost(v.JID, msg)); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		a.Logger.Warnf("Failed to update channel cache: %v", err)
+	}
+}
+
+// Write a channel API response
+func writeNewsletterResponse(w http.ResponseWriter, status int, response NewsletterResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handle GET /api/newsletters
+func (a *Account) HandleNewslettersEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cached := true
+	if a.Client.IsConnected() {
+		if metas, err := a.Client.GetSubscribedNewsletters(); err != nil {
+			a.Logger.Warnf("Serving cached channels: %v", err)
+		} else {
+			newsletters := make([]*Newsletter, len(metas))
+			for i, meta := range metas {
+				newsletters[i] = newsletterFromMetadata(meta)
+			}
+			if err := a.MessageStore.StoreNewsletters(newsletters); err != nil {
+				a.Logger.Warnf("Failed to cache channels: %v", err)
+			}
+			cached = false
+		}
+	}
+
+	newsletters, err := a.MessageStore.ListNewsletters()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load channels: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NewslettersResponse{Newsletters: newsletters, Total: len(newsletters), Cached: cached})
+}
+
+// Handle POST /api/newsletters/follow
+func (a *Account) HandleFollowNewsletterEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var req FollowNewsletterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	var jid types.JID
+	var code string
+	var err error
+	switch {
+	case req.JID != "":
+		jid, err = parseNewsletterJID(req.JID)
+	case req.Invite != "":
+		code, err = newsletterInviteCode(req.Invite)
+	default:
+		err = fmt.Errorf("jid or invite is required")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !a.Client.IsConnected() {
+		writeNewsletterResponse(w, http.StatusServiceUnavailable, NewsletterResponse{Message: errNotConnected.Error(), JID: req.JID})
+		return
+	}
+
+	var meta *types.NewsletterMetadata
+	if code != "" {
+		meta, err = a.Client.GetNewsletterInfoWithInvite(code)
+	} else {
+		meta, err = a.Client.GetNewsletterInfo(jid)
+	}
+	if err != nil {
+		writeNewsletterResponse(w, http.StatusNotFound, NewsletterResponse{Message: fmt.Sprintf("Failed to find channel: %v", err), JID: req.JID})
+		return
+	}
+	if err := a.Client.FollowNewsletter(meta.ID); err != nil {
+		writeNewsletterResponse(w, http.StatusInternalServerError, NewsletterResponse{
+			Message: fmt.Sprintf("Failed to follow channel: %v", err),
+			JID:     meta.ID.String(),
+		})
+		return
+	}
+
+	newsletter := newsletterFromMetadata(meta)
+	newsletter.Role = string(types.NewsletterRoleSubscriber)
+	if err := a.MessageStore.StoreNewsletter(newsletter); err != nil {
+		a.Logger.Warnf("Failed to cache channel %s: %v", meta.ID, err)
+	}
+	writeNewsletterResponse(w, http.StatusOK, NewsletterResponse{
+		Success:    true,
+		Message:    fmt.Sprintf("Following %s", newsletter.Name),
+		JID:        newsletter.JID,
+		Newsletter: newsletter,
+	})
+}
+
+// Handle POST /api/newsletters/{jid}/unfollow
+func (a *Account) HandleUnfollowNewsletterEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jid, err := parseNewsletterJID(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !a.Client.IsConnected() {
+		writeNewsletterResponse(w, http.StatusServiceUnavailable, NewsletterResponse{Message: errNotConnected.Error(), JID: jid.String()})
+		return
+	}
+	if err := a.Client.UnfollowNewsletter(jid); err != nil {
+		writeNewsletterResponse(w, http.StatusInternalServerError, NewsletterResponse{
+			Message: fmt.Sprintf("Failed to unfollow channel: %v", err),
+			JID:     jid.String(),
+		})
+		return
+	}
+	if err := a.MessageStore.DeleteNewsletter(jid.String()); err != nil {
+		a.Logger.Warnf("Failed to remove channel %s from the cache: %v", jid, err)
+	}
+	writeNewsletterResponse(w, http.StatusOK, NewsletterResponse{Success: true, Message: fmt.Sprintf("Unfollowed %s", jid), JID: jid.String()})
+}
+
+// Handle GET /api/newsletters/{jid}/messages?limit=&before=. Posts are
+// fetched from WhatsApp and stored, or read from the store when offline.
+func (a *Account) HandleNewsletterMessagesEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jid, err := parseNewsletterJID(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	limit := defaultNewsletterPosts
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxNewsletterPosts)
+	}
+	var before int
+	if value := query.Get("before"); value != "" {
+		if before, err = strconv.Atoi(value); err != nil || before < 1 {
+			http.Error(w, "before must be a post server ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	response := NewsletterPostsResponse{JID: jid.String(), Cached: true}
+	if a.Client.IsConnected() {
+		msgs, err := a.Client.GetNewsletterMessages(jid, &whatsmeow.GetNewsletterMessagesParams{
+			Count:  limit,
+			Before: types.MessageServerID(before),
+		})
+		if err != nil {
+			a.Logger.Warnf("Serving stored posts of %s: %v", jid, err)
+		} else {
+			for _, msg := range msgs {
+				if err := a.MessageStore.StoreNewsletterPost(newsletterPost(jid, msg)); err != nil {
+					a.Logger.Warnf("Failed to store channel post %d in %s: %v", msg.MessageServerID, jid, err)
+				}
+			}
+			response.Cached = false
+		}
+	}
+
+	// Read back from the store so live and fetched posts look the same
+	posts, err := a.MessageStore.GetNewsletterPosts(jid.String(), before, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load channel posts: %v", err), http.StatusInternalServerError)
+		return
+	}
+	response.Messages = posts
+	response.HasMore = len(posts) == limit
+	if len(posts) > 0 && response.HasMore {
+		response.NextBefore = posts[len(posts)-1].ServerID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handle POST /api/newsletters/{jid}/messages/{server_id}/reaction
+func (a *Account) HandleNewsletterReactionEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jid, err := parseNewsletterJID(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	serverID, err := strconv.Atoi(r.PathValue("server_id"))
+	if err != nil || serverID < 1 {
+		http.Error(w, "invalid post server ID", http.StatusBadRequest)
+		return
+	}
+
+	// Parse the request body
+	var req NewsletterReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if !a.Client.IsConnected() {
+		writeNewsletterResponse(w, http.StatusServiceUnavailable, NewsletterResponse{Message: errNotConnected.Error(), JID: jid.String()})
+		return
+	}
+	if err := a.Client.NewsletterSendReaction(jid, types.MessageServerID(serverID), req.Reaction, ""); err != nil {
+		writeNewsletterResponse(w, http.StatusInternalServerError, NewsletterResponse{
+			Message: fmt.Sprintf("Failed to react: %v", err),
+			JID:     jid.String(),
+		})
+		return
+	}
+
+	message := fmt.Sprintf("Reacted %s to post %d", req.Reaction, serverID)
+	if req.Reaction == "" {
+		message = fmt.Sprintf("Removed reaction from post %d", serverID)
+	}
+	writeNewsletterResponse(w, http.StatusOK, NewsletterResponse{Success: true, Message: message, JID: jid.String()})
+}
+
+// Handle POST /api/newsletters/{jid}/views
+func (a *Account) HandleNewsletterViewsEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jid, err := parseNewsletterJID(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse the request body
+	var req NewsletterViewsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if len(req.ServerIDs) == 0 {
+		http.Error(w, "server_ids is required", http.StatusBadRequest)
+		return
+	}
+	serverIDs := make([]types.MessageServerID, len(req.ServerIDs))
+	for i, id := range req.ServerIDs {
+		serverIDs[i] = types.MessageServerID(id)
+	}
+
+	if !a.Client.IsConnected() {
+		writeNewsletterResponse(w, http.StatusServiceUnavailable, NewsletterResponse{Message: errNotConnected.Error(), JID: jid.String()})
+		return
+	}
+	if err := a.Client.NewsletterMarkViewed(jid, serverIDs); err != nil {
+		writeNewsletterResponse(w, http.StatusInternalServerError, NewsletterResponse{
+			Message: fmt.Sprintf("Failed to mark posts viewed: %v", err),
+			JID:     jid.String(),
+		})
+		return
+	}
+	writeNewsletterResponse(w, http.StatusOK, NewsletterResponse{
+		Success: true,
+		Message: fmt.Sprintf("Marked %d posts viewed", len(serverIDs)),
+		JID:     jid.String(),
+	})
+}
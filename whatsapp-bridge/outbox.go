@@ -0,0 +1,383 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Returned by sendOrQueue when the message was stored for later delivery
+var errQueued = errors.New("not connected to WhatsApp, message queued for delivery")
+
+// Outbox entry states
+const (
+	OutboxStatusPending   = "pending"
+	OutboxStatusSent      = "sent"
+	OutboxStatusFailed    = "failed"
+	OutboxStatusCancelled = "cancelled"
+)
+
+// Queued messages are retried with backoff and dropped once they get stale
+const (
+	maxOutboxAttempts   = 5
+	outboxRetryBaseWait = 10 * time.Second
+	maxOutboxAge        = 24 * time.Hour
+)
+
+// OutboxEntry is a message waiting for the connection to come back
+type OutboxEntry struct {
+	ID        string     `json:"id"`
+	ChatJID   string     `json:"chat_jid"`
+	Content   string     `json:"content,omitempty"`
+	Status    string     `json:"status"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+	message   *waProto.Message
+	// Trace of the request that queued the message
+	traceparent string
+}
+
+// OutboxResponse represents the response for the single outbox entry APIs
+type OutboxResponse struct {
+	Success bool         `json:"success"`
+	Message string       `json:"message"`
+	Entry   *OutboxEntry `json:"entry,omitempty"`
+}
+
+// Add a message to the outbox
+func (store *MessageStore) EnqueueOutbox(id string, chat types.JID, msg *waProto.Message, createdAt time.Time, traceparent string) error {
+	encoded, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(
+		`INSERT INTO outbox (id, chat_jid, message, status, attempts, created_at, next_attempt_at, traceparent)
+		VALUES (?, ?, ?, ?, 0, ?, ?, ?)`,
+		id, chat.String(), encoded, OutboxStatusPending, createdAt.UTC(), createdAt.UTC(), traceparent,
+	)
+	return err
+}
+
+const outboxColumns = "id, chat_jid, message, status, attempts, last_error, created_at, sent_at, COALESCE(traceparent, '')"
+
+// Scan an outbox row
+func scanOutboxEntry(row interface{ Scan(...interface{}) error }) (*OutboxEntry, error) {
+	var entry OutboxEntry
+	var encoded []byte
+	var lastError sql.NullString
+	var sentAt sql.NullTime
+	err := row.Scan(&entry.ID, &entry.ChatJID, &encoded, &entry.Status, &entry.Attempts, &lastError, &entry.CreatedAt, &sentAt, &entry.traceparent)
+	if err != nil {
+		return nil, err
+	}
+	entry.message = &waProto.Message{}
+	if err := proto.Unmarshal(encoded, entry.message); err != nil {
+		return nil, err
+	}
+	entry.Content = extractTextContent(entry.message)
+	entry.LastError = lastError.String
+	if sentAt.Valid {
+		entry.SentAt = &sentAt.Time
+	}
+	return &entry, nil
+}
+
+// Get an outbox entry by ID
+func (store *MessageStore) GetOutboxEntry(id string) (*OutboxEntry, error) {
+	return scanOutboxEntry(store.db.QueryRow("SELECT "+outboxColumns+" FROM outbox WHERE id = ?", id))
+}
+
+// List outbox entries in queue order, optionally filtered by status
+func (store *MessageStore) ListOutbox(status string) ([]*OutboxEntry, error) {
+	query := "SELECT " + outboxColumns + " FROM outbox"
+	var args []interface{}
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY created_at"
+	return store.queryOutbox(query, args...)
+}
+
+// Pending outbox entries whose next attempt is due
+func (store *MessageStore) DueOutbox(now time.Time) ([]*OutboxEntry, error) {
+	return store.queryOutbox(
+		"SELECT "+outboxColumns+" FROM outbox WHERE status = ? AND next_attempt_at <= ? ORDER BY created_at",
+		OutboxStatusPending, now.UTC(),
+	)
+}
+
+// Run a query returning outbox entries
+func (store *MessageStore) queryOutbox(query string, args ...interface{}) ([]*OutboxEntry, error) {
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*OutboxEntry{}
+	for rows.Next() {
+		entry, err := scanOutboxEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Cancel a queued message; only pending entries can be cancelled
+func (store *MessageStore) CancelOutboxEntry(id string) (bool, error) {
+	result, err := store.db.Exec(
+		"UPDATE outbox SET status = ? WHERE id = ? AND status = ?",
+		OutboxStatusCancelled, id, OutboxStatusPending,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Record a delivered outbox entry
+func (store *MessageStore) MarkOutboxSent(id string, sentAt time.Time) error {
+	_, err := store.db.Exec(
+		"UPDATE outbox SET status = ?, attempts = attempts + 1, sent_at = ?, last_error = NULL WHERE id = ?",
+		OutboxStatusSent, sentAt.UTC(), id,
+	)
+	return err
+}
+
+// Record a failed delivery, either retrying later or giving up
+func (store *MessageStore) MarkOutboxAttemptFailed(id string, sendErr error, retryAt *time.Time) error {
+	if retryAt == nil {
+		_, err := store.db.Exec(
+			"UPDATE outbox SET status = ?, attempts = attempts + 1, last_error = ? WHERE id = ?",
+			OutboxStatusFailed, sendErr.Error(), id,
+		)
+		return err
+	}
+	_, err := store.db.Exec(
+		"UPDATE outbox SET attempts = attempts + 1, last_error = ?, next_attempt_at = ? WHERE id = ?",
+		sendErr.Error(), retryAt.UTC(), id,
+	)
+	return err
+}
+
+// Outbox delivers messages that were sent while the account was disconnected
+type Outbox struct {
+	account *Account
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Create an outbox for an account
+func NewOutbox(account *Account) *Outbox {
+	return &Outbox{
+		account: account,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Run delivers queued messages on reconnect and retries failures until Stop is called
+func (o *Outbox) Run() {
+	defer close(o.done)
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		o.flush()
+		select {
+		case <-ticker.C:
+		case <-o.wake:
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// Wake the outbox, e.g. right after connecting
+func (o *Outbox) Wake() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop the outbox and wait for an in-flight delivery to finish
+func (o *Outbox) Stop() {
+	close(o.stop)
+	<-o.done
+}
+
+// Deliver queued messages in order while the connection lasts
+func (o *Outbox) flush() {
+	a := o.account
+	if !a.Client.IsConnected() || a.ReadOnly {
+		return
+	}
+
+	due, err := a.MessageStore.DueOutbox(time.Now())
+	if err != nil {
+		a.Logger.Warnf("Failed to load outbox: %v", err)
+		return
+	}
+	for _, entry := range due {
+		if !o.deliver(entry) {
+			return
+		}
+	}
+}
+
+// Deliver a single entry, returning false if the connection dropped meanwhile
+func (o *Outbox) deliver(entry *OutboxEntry) bool {
+	a := o.account
+	if time.Since(entry.CreatedAt) > maxOutboxAge {
+		err := fmt.Errorf("not delivered within %d hours", int(maxOutboxAge.Hours()))
+		if err := a.MessageStore.MarkOutboxAttemptFailed(entry.ID, err, nil); err != nil {
+			a.Logger.Warnf("Failed to update outbox entry %s: %v", entry.ID, err)
+		}
+		return true
+	}
+
+	parent, _ := parseTraceparent(entry.traceparent)
+	span := a.withTrace(parent).span("outbox.deliver", spanKindInternal)
+	span.SetAttr("whatsapp.message_id", entry.ID)
+	span.SetAttr("outbox.attempt", entry.Attempts+1)
+	span.SetAttr("outbox.queued_ms", time.Since(entry.CreatedAt).Milliseconds())
+	defer span.End()
+
+	chat, err := types.ParseJID(entry.ChatJID)
+	if err == nil {
+		// Reuse the ID handed out when the message was queued. The content
+		// policy already passed it before it was queued.
+		var resp whatsmeow.SendResponse
+		resp, err = a.withTrace(span.context()).deliverMessage(chat, entry.message, whatsmeow.SendRequestExtra{ID: types.MessageID(entry.ID)})
+		if err == nil {
+			if err := a.MessageStore.MarkOutboxSent(entry.ID, resp.Timestamp); err != nil {
+				a.Logger.Warnf("Failed to update outbox entry %s: %v", entry.ID, err)
+			}
+			a.Logger.Infof("Delivered queued message %s to %s", entry.ID, chat)
+			return true
+		}
+	}
+	span.RecordError(err)
+	if isDisconnectError(err) {
+		return false
+	}
+
+	var retryAt *time.Time
+	if entry.Attempts+1 < maxOutboxAttempts {
+		next := time.Now().Add(outboxRetryBaseWait << entry.Attempts)
+		retryAt = &next
+	}
+	if err := a.MessageStore.MarkOutboxAttemptFailed(entry.ID, err, retryAt); err != nil {
+		a.Logger.Warnf("Failed to update outbox entry %s: %v", entry.ID, err)
+	}
+	a.Logger.Warnf("Failed to deliver queued message %s: %v", entry.ID, err)
+	return true
+}
+
+// Report whether a send failed only because the socket is down
+func isDisconnectError(err error) bool {
+	return errors.Is(err, errNotConnected) || errors.Is(err, whatsmeow.ErrNotConnected)
+}
+
+// Send a message, or queue it in the outbox if the account is disconnected.
+// Queued sends return errQueued along with the ID the message will be sent with.
+func (a *Account) sendOrQueue(to types.JID, msg *waProto.Message) (whatsmeow.SendResponse, error) {
+	resp, err := a.sendMessage(to, msg)
+	if !isDisconnectError(err) {
+		return resp, err
+	}
+
+	resp = whatsmeow.SendResponse{ID: a.Client.GenerateMessageID(), Timestamp: time.Now()}
+	span := a.span("outbox.enqueue", spanKindInternal)
+	span.SetAttr("whatsapp.message_id", resp.ID)
+	span.End()
+	// The delivery attempt continues the trace of the request that queued it
+	if err := a.MessageStore.EnqueueOutbox(resp.ID, to, msg, resp.Timestamp, a.traceParent.traceparent()); err != nil {
+		return whatsmeow.SendResponse{}, fmt.Errorf("failed to queue message: %v", err)
+	}
+	a.Logger.Infof("Queued message %s to %s until the connection returns", resp.ID, to)
+	return resp, errQueued
+}
+
+// Handle GET /api/outbox
+func (a *Account) HandleOutboxEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries, err := a.MessageStore.ListOutbox(r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list outbox: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// Handle /api/outbox/{id}: GET returns a queued message, DELETE cancels it
+func (a *Account) HandleOutboxEntryEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	w.Header().Set("Content-Type", "application/json")
+
+	entry, err := a.MessageStore.GetOutboxEntry(id)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(OutboxResponse{Success: false, Message: fmt.Sprintf("Outbox entry %s not found", id)})
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(OutboxResponse{Success: false, Message: fmt.Sprintf("Failed to load outbox entry: %v", err)})
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(OutboxResponse{Success: true, Message: "OK", Entry: entry})
+		return
+	}
+
+	cancelled, err := a.MessageStore.CancelOutboxEntry(id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(OutboxResponse{Success: false, Message: fmt.Sprintf("Failed to cancel outbox entry: %v", err)})
+		return
+	}
+	if !cancelled {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(OutboxResponse{
+			Success: false,
+			Message: fmt.Sprintf("Outbox entry %s is already %s", id, entry.Status),
+			Entry:   entry,
+		})
+		return
+	}
+	entry.Status = OutboxStatusCancelled
+	json.NewEncoder(w).Encode(OutboxResponse{
+		Success: true,
+		Message: fmt.Sprintf("Outbox entry %s cancelled", id),
+		Entry:   entry,
+	})
+}
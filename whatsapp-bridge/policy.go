@@ -0,0 +1,767 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// What a content policy can do with an outgoing message
+const (
+	PolicyActionAllow   = "allow"
+	PolicyActionBlock   = "block"
+	PolicyActionRedact  = "redact"
+	PolicyActionConfirm = "confirm"
+)
+
+// Held message states
+const (
+	PolicyHoldStatusPending  = "pending"
+	PolicyHoldStatusApproved = "approved"
+	PolicyHoldStatusRejected = "rejected"
+)
+
+// Event sent when the policy blocks, redacts or holds a message
+const WebhookEventPolicyDecision = "policy_decision"
+
+// How long the policy endpoint gets to answer
+const policyCheckTimeout = 10 * time.Second
+
+// Text put in place of redacted matches when a rule sets none
+const defaultPolicyReplacement = "[redacted]"
+
+// PolicyMessage is what a content policy sees of an outgoing message
+type PolicyMessage struct {
+	AccountID string `json:"account_id"`
+	Recipient string `json:"recipient"`
+	// Text or caption; empty for media sent without one
+	Text      string `json:"text"`
+	MediaType string `json:"media_type,omitempty"`
+}
+
+// PolicyDecision is a content policy's verdict on a message
+type PolicyDecision struct {
+	Action string `json:"action"`
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// The text to send instead, for redact and for confirm after earlier redactions
+	Text string `json:"text,omitempty"`
+}
+
+// ContentPolicy reviews every outgoing message with text or media before it
+// is sent. Implementations are the regex rules of --policy-rules and the
+// external endpoint of --policy-url.
+type ContentPolicy interface {
+	Check(ctx context.Context, msg *PolicyMessage) (*PolicyDecision, error)
+}
+
+// PolicyError is returned by sendMessage when the policy blocked a message
+// or held it for confirmation
+type PolicyError struct {
+	Decision PolicyDecision
+	// Set when the message is held
+	HoldID string
+}
+
+func (e *PolicyError) Error() string {
+	what := "blocked by the content policy"
+	if e.HoldID != "" {
+		what = fmt.Sprintf("held for confirmation as %s by the content policy", e.HoldID)
+	}
+	if e.Decision.Rule != "" {
+		what += " (rule " + e.Decision.Rule + ")"
+	}
+	if e.Decision.Reason != "" {
+		what += ": " + e.Decision.Reason
+	}
+	return "message " + what
+}
+
+// PolicyRule matches message text with a regular expression
+type PolicyRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	// block, redact or confirm
+	Action string `json:"action"`
+	// Text replacing each match when redacting, which may refer to groups as $1
+	Replacement string `json:"replacement,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	re          *regexp.Regexp
+}
+
+// RulePolicy applies regex rules. A blocking match wins over a confirming
+// one; redactions all apply, and the rules after them see the redacted text.
+type RulePolicy struct {
+	rules []*PolicyRule
+}
+
+// Load rules from a JSON file holding an array of PolicyRule
+func LoadRulePolicy(path string) (*RulePolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*PolicyRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules: %v", err)
+	}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = "rule " + strconv.Itoa(i+1)
+		}
+		switch rule.Action {
+		case PolicyActionBlock, PolicyActionRedact, PolicyActionConfirm:
+		default:
+			return nil, fmt.Errorf("%s: action must be block, redact or confirm", rule.Name)
+		}
+		if rule.re, err = regexp.Compile(rule.Pattern); err != nil {
+			return nil, fmt.Errorf("%s: %v", rule.Name, err)
+		}
+		if rule.Replacement == "" {
+			rule.Replacement = defaultPolicyReplacement
+		}
+	}
+	return &RulePolicy{rules: rules}, nil
+}
+
+// Check the message text against every rule
+func (p *RulePolicy) Check(ctx context.Context, msg *PolicyMessage) (*PolicyDecision, error) {
+	text := msg.Text
+	var confirm, redact *PolicyRule
+	for _, rule := range p.rules {
+		if !rule.re.MatchString(text) {
+			continue
+		}
+		switch rule.Action {
+		case PolicyActionBlock:
+			return &PolicyDecision{Action: PolicyActionBlock, Rule: rule.Name, Reason: rule.Reason}, nil
+		case PolicyActionConfirm:
+			if confirm == nil {
+				confirm = rule
+			}
+		case PolicyActionRedact:
+			text = rule.re.ReplaceAllString(text, rule.Replacement)
+			if redact == nil {
+				redact = rule
+			}
+		}
+	}
+	switch {
+	case confirm != nil:
+		return &PolicyDecision{Action: PolicyActionConfirm, Rule: confirm.Name, Reason: confirm.Reason, Text: text}, nil
+	case redact != nil:
+		return &PolicyDecision{Action: PolicyActionRedact, Rule: redact.Name, Reason: redact.Reason, Text: text}, nil
+	}
+	return &PolicyDecision{Action: PolicyActionAllow}, nil
+}
+
+// HTTPPolicy asks an external endpoint, which receives the PolicyMessage as
+// JSON and answers with a PolicyDecision
+type HTTPPolicy struct {
+	URL    string
+	client *http.Client
+}
+
+// Create a policy calling the endpoint at url
+func NewHTTPPolicy(url string) *HTTPPolicy {
+	return &HTTPPolicy{URL: url, client: &http.Client{Timeout: policyCheckTimeout}}
+}
+
+// Ask the endpoint about a message
+func (p *HTTPPolicy) Check(ctx context.Context, msg *PolicyMessage) (*PolicyDecision, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("policy endpoint returned %s", resp.Status)
+	}
+
+	var decision PolicyDecision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("invalid policy decision: %v", err)
+	}
+	switch decision.Action {
+	case PolicyActionAllow, PolicyActionBlock, PolicyActionConfirm:
+	case PolicyActionRedact:
+		if decision.Text == "" {
+			return nil, fmt.Errorf("redact decision without text")
+		}
+	default:
+		return nil, fmt.Errorf("unknown policy action %q", decision.Action)
+	}
+	return &decision, nil
+}
+
+// policyChain runs several policies in turn, each seeing the text as
+// redacted by the ones before; a block ends the chain
+type policyChain struct {
+	policies []ContentPolicy
+	// Let messages through when a policy fails instead of blocking them
+	failOpen bool
+}
+
+func (c *policyChain) Check(ctx context.Context, msg *PolicyMessage) (*PolicyDecision, error) {
+	current := *msg
+	var final *PolicyDecision
+	for _, policy := range c.policies {
+		decision, err := policy.Check(ctx, &current)
+		if err != nil {
+			if c.failOpen {
+				continue
+			}
+			return nil, err
+		}
+		switch decision.Action {
+		case PolicyActionBlock:
+			return decision, nil
+		case PolicyActionRedact:
+			current.Text = decision.Text
+			if final == nil {
+				final = decision
+			}
+		case PolicyActionConfirm:
+			if decision.Text != "" {
+				current.Text = decision.Text
+			}
+			if final == nil || final.Action != PolicyActionConfirm {
+				final = decision
+			}
+		}
+	}
+	if final == nil {
+		return &PolicyDecision{Action: PolicyActionAllow}, nil
+	}
+	// The text after every redaction, empty when nothing changed
+	final.Text = ""
+	if current.Text != msg.Text {
+		final.Text = current.Text
+	}
+	return final, nil
+}
+
+// Build the content policy from --policy-rules and --policy-url, nil when
+// neither is set
+func newContentPolicy(cfg *Config) (ContentPolicy, error) {
+	chain := &policyChain{failOpen: cfg.PolicyFailOpen}
+	if cfg.PolicyRules != "" {
+		rules, err := LoadRulePolicy(cfg.PolicyRules)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load --policy-rules: %v", err)
+		}
+		chain.policies = append(chain.policies, rules)
+	}
+	if cfg.PolicyURL != "" {
+		chain.policies = append(chain.policies, NewHTTPPolicy(cfg.PolicyURL))
+	}
+	if len(chain.policies) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// The text fields a policy reviews, looking inside view-once wrappers and
+// edits: the text or caption, a poll's question and options, the texts of
+// buttons, templates, lists and products, a location's name and address and
+// contact cards
+func policyTextFields(msg *waProto.Message) []*string {
+	var fields []*string
+	add := func(values ...*string) {
+		for _, value := range values {
+			if value != nil {
+				fields = append(fields, value)
+			}
+		}
+	}
+	switch {
+	case msg == nil:
+	case msg.Conversation != nil:
+		add(msg.Conversation)
+	case msg.ExtendedTextMessage != nil:
+		add(msg.ExtendedTextMessage.Text)
+	case msg.ImageMessage != nil:
+		add(msg.ImageMessage.Caption)
+	case msg.VideoMessage != nil:
+		add(msg.VideoMessage.Caption)
+	case msg.DocumentMessage != nil:
+		add(msg.DocumentMessage.Caption)
+	case pollCreation(msg) != nil:
+		poll := pollCreation(msg)
+		add(poll.Name)
+		for _, option := range poll.Options {
+			add(option.OptionName)
+		}
+	case msg.ButtonsMessage != nil:
+		buttons := msg.ButtonsMessage
+		if header, ok := buttons.Header.(*waProto.ButtonsMessage_Text); ok {
+			add(&header.Text)
+		}
+		add(buttons.ContentText, buttons.FooterText)
+		for _, button := range buttons.Buttons {
+			add(button.GetButtonText().DisplayText)
+		}
+	case msg.TemplateMessage != nil:
+		template := msg.TemplateMessage.GetHydratedTemplate()
+		if template == nil {
+			template = msg.TemplateMessage.GetHydratedFourRowTemplate()
+		}
+		if template == nil {
+			break
+		}
+		if title, ok := template.Title.(*waProto.TemplateMessage_HydratedFourRowTemplate_HydratedTitleText); ok {
+			add(&title.HydratedTitleText)
+		}
+		add(template.HydratedContentText, template.HydratedFooterText)
+		for _, button := range template.HydratedButtons {
+			switch {
+			case button.GetUrlButton() != nil:
+				add(button.GetUrlButton().DisplayText, button.GetUrlButton().URL)
+			case button.GetCallButton() != nil:
+				add(button.GetCallButton().DisplayText, button.GetCallButton().PhoneNumber)
+			case button.GetQuickReplyButton() != nil:
+				add(button.GetQuickReplyButton().DisplayText)
+			}
+		}
+	case msg.ListMessage != nil:
+		list := msg.ListMessage
+		add(list.Title, list.Description, list.ButtonText, list.FooterText)
+		for _, section := range list.Sections {
+			add(section.Title)
+			for _, row := range section.Rows {
+				add(row.Title, row.Description)
+			}
+		}
+	case msg.ProductMessage != nil:
+		add(msg.ProductMessage.Body, msg.ProductMessage.Footer)
+	case msg.LocationMessage != nil:
+		add(msg.LocationMessage.Name, msg.LocationMessage.Address, msg.LocationMessage.Comment)
+	case msg.LiveLocationMessage != nil:
+		add(msg.LiveLocationMessage.Caption)
+	case msg.ContactMessage != nil:
+		add(msg.ContactMessage.DisplayName, msg.ContactMessage.Vcard)
+	case msg.ContactsArrayMessage != nil:
+		add(msg.ContactsArrayMessage.DisplayName)
+		for _, contact := range msg.ContactsArrayMessage.Contacts {
+			add(contact.DisplayName, contact.Vcard)
+		}
+	case msg.GroupInviteMessage != nil:
+		add(msg.GroupInviteMessage.Caption)
+	case msg.ViewOnceMessage != nil:
+		return policyTextFields(msg.ViewOnceMessage.GetMessage())
+	case msg.ViewOnceMessageV2 != nil:
+		return policyTextFields(msg.ViewOnceMessageV2.GetMessage())
+	case msg.ProtocolMessage != nil:
+		return policyTextFields(msg.ProtocolMessage.GetEditedMessage())
+	}
+	return fields
+}
+
+// Text of a message as a policy reviews it, one field per line
+func policyText(fields []*string) string {
+	texts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if *field != "" {
+			texts = append(texts, *field)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// Media type of a message for the policy, looking inside view-once wrappers
+func policyMediaType(msg *waProto.Message) string {
+	if inner := msg.GetViewOnceMessage().GetMessage(); inner != nil {
+		msg = inner
+	}
+	if inner := msg.GetViewOnceMessageV2().GetMessage(); inner != nil {
+		msg = inner
+	}
+	mediaType, _, _, _, _, _, _ := extractMediaInfo(msg)
+	return mediaType
+}
+
+// Run the content policy over an outgoing message. Redactions rewrite msg;
+// otherwise a non-nil error means the message must not be sent now.
+func (a *Account) applyContentPolicy(to types.JID, msg *waProto.Message) error {
+	if a.Policy == nil {
+		return nil
+	}
+	fields := policyTextFields(msg)
+	check := &PolicyMessage{AccountID: a.ID, Recipient: to.String(), Text: policyText(fields), MediaType: policyMediaType(msg)}
+	// Reactions, revokes and other messages without content are let through
+	if check.Text == "" && check.MediaType == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), policyCheckTimeout)
+	defer cancel()
+	decision, err := a.Policy.Check(ctx, check)
+	if err != nil {
+		decision = &PolicyDecision{Action: PolicyActionBlock, Reason: fmt.Sprintf("content policy unavailable: %v", err)}
+	}
+	if decision.Action == PolicyActionAllow {
+		return nil
+	}
+	if (decision.Action == PolicyActionRedact || decision.Action == PolicyActionConfirm) && decision.Text != "" {
+		switch {
+		case len(fields) == 1:
+			*fields[0] = decision.Text
+		case len(fields) > 1:
+			// The redacted text can't be split back over several fields
+			decision = &PolicyDecision{Action: PolicyActionBlock, Rule: decision.Rule,
+				Reason: strings.TrimSuffix("redacting a message with several text fields isn't supported; "+decision.Reason, "; ")}
+		}
+	}
+
+	var policyErr error
+	holdID := ""
+	switch decision.Action {
+	case PolicyActionBlock:
+		policyErr = &PolicyError{Decision: *decision}
+	case PolicyActionConfirm:
+		holdID = newJobID()
+		if err := a.MessageStore.HoldMessage(holdID, to, msg, decision); err != nil {
+			return fmt.Errorf("failed to hold message for confirmation: %v", err)
+		}
+		policyErr = &PolicyError{Decision: *decision, HoldID: holdID}
+	}
+
+	a.Logger.Infof("Content policy %s message to %s (rule %q): %s", decision.Action, to, decision.Rule, decision.Reason)
+	if err := a.MessageStore.RecordPolicyDecision(to, decision, holdID); err != nil {
+		a.Logger.Warnf("Failed to record policy decision: %v", err)
+	}
+	a.Notifier.Notify(a.ID, WebhookEventPolicyDecision, map[string]interface{}{
+		"chat_jid": to.String(),
+		"action":   decision.Action,
+		"rule":     decision.Rule,
+		"reason":   decision.Reason,
+		"hold_id":  holdID,
+	})
+	return policyErr
+}
+
+// PolicyHold is a message waiting for an operator to confirm it
+type PolicyHold struct {
+	ID         string     `json:"id"`
+	ChatJID    string     `json:"chat_jid"`
+	Text       string     `json:"text,omitempty"`
+	MediaType  string     `json:"media_type,omitempty"`
+	Rule       string     `json:"rule,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	// ID of the sent message once approved
+	MessageID string `json:"message_id,omitempty"`
+	message   *waProto.Message
+}
+
+// PolicyDecisionEntry is a logged decision other than allow
+type PolicyDecisionEntry struct {
+	ID      int64     `json:"id"`
+	Time    time.Time `json:"time"`
+	ChatJID string    `json:"chat_jid"`
+	Action  string    `json:"action"`
+	Rule    string    `json:"rule,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	HoldID  string    `json:"hold_id,omitempty"`
+}
+
+// PolicyHoldResponse represents the response for the held message APIs
+type PolicyHoldResponse struct {
+	Success bool        `json:"success"`
+	Message string      `json:"message"`
+	Hold    *PolicyHold `json:"hold,omitempty"`
+}
+
+// Keep a message until it is approved or rejected
+func (store *MessageStore) HoldMessage(id string, chat types.JID, msg *waProto.Message, decision *PolicyDecision) error {
+	encoded, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(
+		`INSERT INTO policy_holds (id, chat_jid, message, rule, reason, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, chat.String(), encoded, decision.Rule, decision.Reason, PolicyHoldStatusPending, time.Now().UTC(),
+	)
+	return err
+}
+
+// Log a policy decision
+func (store *MessageStore) RecordPolicyDecision(chat types.JID, decision *PolicyDecision, holdID string) error {
+	_, err := store.db.Exec(
+		"INSERT INTO policy_decisions (time, chat_jid, action, rule, reason, hold_id) VALUES (?, ?, ?, ?, ?, ?)",
+		time.Now().UTC(), chat.String(), decision.Action, decision.Rule, decision.Reason, holdID,
+	)
+	return err
+}
+
+const policyHoldColumns = "id, chat_jid, message, rule, reason, status, created_at, resolved_at, message_id"
+
+// Scan a held message row
+func scanPolicyHold(row interface{ Scan(...interface{}) error }) (*PolicyHold, error) {
+	var hold PolicyHold
+	var encoded []byte
+	var resolvedAt sql.NullTime
+	var messageID sql.NullString
+	err := row.Scan(&hold.ID, &hold.ChatJID, &encoded, &hold.Rule, &hold.Reason, &hold.Status, &hold.CreatedAt, &resolvedAt, &messageID)
+	if err != nil {
+		return nil, err
+	}
+	hold.message = &waProto.Message{}
+	if err := proto.Unmarshal(encoded, hold.message); err != nil {
+		return nil, fmt.Errorf("failed to decode held message %s: %v", hold.ID, err)
+	}
+	hold.Text = policyText(policyTextFields(hold.message))
+	hold.MediaType = policyMediaType(hold.message)
+	if resolvedAt.Valid {
+		hold.ResolvedAt = &resolvedAt.Time
+	}
+	hold.MessageID = messageID.String
+	return &hold, nil
+}
+
+// Held messages, newest first, optionally only those in a status
+func (store *MessageStore) ListPolicyHolds(status string) ([]*PolicyHold, error) {
+	query := "SELECT " + policyHoldColumns + " FROM policy_holds"
+	var args []interface{}
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	rows, err := store.db.Query(query+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []*PolicyHold{}
+	for rows.Next() {
+		hold, err := scanPolicyHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+	return holds, rows.Err()
+}
+
+// A held message by ID, nil if there is none
+func (store *MessageStore) GetPolicyHold(id string) (*PolicyHold, error) {
+	hold, err := scanPolicyHold(store.db.QueryRow("SELECT "+policyHoldColumns+" FROM policy_holds WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return hold, err
+}
+
+// Settle a pending hold, reporting false if it was no longer pending
+func (store *MessageStore) ResolvePolicyHold(id, status, messageID string) (bool, error) {
+	result, err := store.db.Exec(
+		"UPDATE policy_holds SET status = ?, resolved_at = ?, message_id = ? WHERE id = ? AND status = ?",
+		status, time.Now().UTC(), messageID, id, PolicyHoldStatusPending,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// Return an approved hold whose message failed to send to pending
+func (store *MessageStore) ReopenPolicyHold(id string) error {
+	_, err := store.db.Exec(
+		"UPDATE policy_holds SET status = ?, resolved_at = NULL, message_id = NULL WHERE id = ? AND status = ?",
+		PolicyHoldStatusPending, id, PolicyHoldStatusApproved,
+	)
+	return err
+}
+
+// Record the ID an approved hold was sent as
+func (store *MessageStore) SetPolicyHoldMessageID(id, messageID string) error {
+	_, err := store.db.Exec("UPDATE policy_holds SET message_id = ? WHERE id = ?", messageID, id)
+	return err
+}
+
+// Logged decisions, newest first
+func (store *MessageStore) ListPolicyDecisions(limit int) ([]PolicyDecisionEntry, error) {
+	rows, err := store.db.Query(
+		"SELECT id, time, chat_jid, action, rule, reason, hold_id FROM policy_decisions ORDER BY id DESC LIMIT ?", limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []PolicyDecisionEntry{}
+	for rows.Next() {
+		var entry PolicyDecisionEntry
+		if err := rows.Scan(&entry.ID, &entry.Time, &entry.ChatJID, &entry.Action, &entry.Rule, &entry.Reason, &entry.HoldID); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Write a held message response with a status code
+func writePolicyHoldResponse(w http.ResponseWriter, status int, response PolicyHoldResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Answer 409 for a hold that is no longer pending, with its current state
+func writePolicyHoldConflict(w http.ResponseWriter, store *MessageStore, id string) {
+	hold, _ := store.GetPolicyHold(id)
+	status := "resolved"
+	if hold != nil {
+		status = hold.Status
+	}
+	writePolicyHoldResponse(w, http.StatusConflict, PolicyHoldResponse{Message: fmt.Sprintf("Held message was already %s", status), Hold: hold})
+}
+
+// Handle GET /api/policy/holds
+func (a *Account) HandlePolicyHoldsEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	holds, err := a.MessageStore.ListPolicyHolds(r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list held messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(holds)
+}
+
+// Handle /api/policy/holds/{id}: GET returns a held message, POST sends it
+// and DELETE rejects it
+func (a *Account) HandlePolicyHoldEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hold, err := a.MessageStore.GetPolicyHold(r.PathValue("id"))
+	if err != nil {
+		writePolicyHoldResponse(w, http.StatusInternalServerError, PolicyHoldResponse{Message: fmt.Sprintf("Failed to load held message: %v", err)})
+		return
+	}
+	if hold == nil {
+		writePolicyHoldResponse(w, http.StatusNotFound, PolicyHoldResponse{Message: "Held message not found"})
+		return
+	}
+	if r.Method == http.MethodGet {
+		writePolicyHoldResponse(w, http.StatusOK, PolicyHoldResponse{Success: true, Message: "Held message", Hold: hold})
+		return
+	}
+	if hold.Status != PolicyHoldStatusPending {
+		writePolicyHoldConflict(w, a.MessageStore, hold.ID)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		rejected, err := a.MessageStore.ResolvePolicyHold(hold.ID, PolicyHoldStatusRejected, "")
+		if err != nil {
+			writePolicyHoldResponse(w, http.StatusInternalServerError, PolicyHoldResponse{Message: fmt.Sprintf("Failed to reject held message: %v", err)})
+			return
+		}
+		if !rejected {
+			writePolicyHoldConflict(w, a.MessageStore, hold.ID)
+			return
+		}
+		a.Logger.Infof("Rejected held message %s to %s", hold.ID, hold.ChatJID)
+		hold, _ = a.MessageStore.GetPolicyHold(hold.ID)
+		writePolicyHoldResponse(w, http.StatusOK, PolicyHoldResponse{Success: true, Message: "Held message rejected", Hold: hold})
+		return
+	}
+
+	// Approved messages skip the policy that held them
+	chat, err := types.ParseJID(hold.ChatJID)
+	if err != nil {
+		writePolicyHoldResponse(w, http.StatusInternalServerError, PolicyHoldResponse{Message: fmt.Sprintf("Invalid chat of held message: %v", err)})
+		return
+	}
+	// Claim the hold before sending, so approvals racing each other send it once
+	claimed, err := a.MessageStore.ResolvePolicyHold(hold.ID, PolicyHoldStatusApproved, "")
+	if err != nil {
+		writePolicyHoldResponse(w, http.StatusInternalServerError, PolicyHoldResponse{Message: fmt.Sprintf("Failed to approve held message: %v", err)})
+		return
+	}
+	if !claimed {
+		writePolicyHoldConflict(w, a.MessageStore, hold.ID)
+		return
+	}
+	resp, err := a.deliverMessage(chat, hold.message)
+	if err != nil {
+		// Unsent, so it can be approved again
+		if err := a.MessageStore.ReopenPolicyHold(hold.ID); err != nil {
+			a.Logger.Warnf("Failed to reopen held message %s: %v", hold.ID, err)
+		}
+		status := http.StatusInternalServerError
+		if errors.Is(err, errNotConnected) {
+			status = http.StatusServiceUnavailable
+		}
+		writePolicyHoldResponse(w, status, PolicyHoldResponse{Message: err.Error(), Hold: hold})
+		return
+	}
+	if err := a.MessageStore.SetPolicyHoldMessageID(hold.ID, resp.ID); err != nil {
+		a.Logger.Warnf("Failed to update held message %s: %v", hold.ID, err)
+	}
+	a.Logger.Infof("Sent held message %s to %s as %s", hold.ID, chat, resp.ID)
+	hold, _ = a.MessageStore.GetPolicyHold(hold.ID)
+	writePolicyHoldResponse(w, http.StatusOK, PolicyHoldResponse{Success: true, Message: "Held message sent", Hold: hold})
+}
+
+// Handle GET /api/policy/decisions
+func (a *Account) HandlePolicyDecisionsEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultHistoryPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxHistoryPageSize)
+	}
+	entries, err := a.MessageStore.ListPolicyDecisions(limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list policy decisions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
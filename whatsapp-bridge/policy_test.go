@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPolicyTextCoversEveryField(t *testing.T) {
+	for name, msg := range map[string]*waProto.Message{
+		"poll option": {PollCreationMessage: &waProto.PollCreationMessage{
+			Name:    proto.String("Lunch?"),
+			Options: []*waProto.PollCreationMessage_Option{{OptionName: proto.String("secret")}},
+		}},
+		"list row": wrapInteractive(buildListMessage(&SendListRequest{
+			Title: "Menu", Body: "Pick one", ButtonText: "Open",
+			Sections: []ListSection{{Title: "Food", Rows: []ListRow{{ID: "1", Title: "Pizza", Description: "secret"}}}},
+		}, nil)),
+		"button header": wrapInteractive(buildButtonsMessage(&SendButtonsRequest{
+			Header: "secret", Body: "Pick one", Buttons: []Button{{Type: ButtonTypeReply, ID: "1", Text: "Yes"}},
+		}, nil)),
+		"template button": wrapInteractive(buildButtonsMessage(&SendButtonsRequest{
+			Body: "Pick one", Buttons: []Button{{Type: ButtonTypeURL, Text: "Open", URL: "https://example.com/secret"}},
+		}, nil)),
+		"product footer":   {ProductMessage: &waProto.ProductMessage{Body: proto.String("Look"), Footer: proto.String("secret")}},
+		"location address": {LocationMessage: &waProto.LocationMessage{Name: proto.String("Home"), Address: proto.String("secret")}},
+		"vcard": {ContactsArrayMessage: &waProto.ContactsArrayMessage{Contacts: []*waProto.ContactMessage{
+			{DisplayName: proto.String("A"), Vcard: proto.String("BEGIN:VCARD\nNOTE:secret\nEND:VCARD")},
+		}}},
+		"edited caption": {ProtocolMessage: &waProto.ProtocolMessage{EditedMessage: &waProto.Message{
+			ImageMessage: &waProto.ImageMessage{Caption: proto.String("secret")},
+		}}},
+	} {
+		if text := policyText(policyTextFields(msg)); !strings.Contains(text, "secret") {
+			t.Errorf("%s: the policy reviews %q, missing the field", name, text)
+		}
+	}
+}
+
+func TestPolicyHoldIsClaimedOnce(t *testing.T) {
+	store, err := NewMessageStore(&Config{}, DefaultAccountID, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	chat := types.NewJID("1", types.DefaultUserServer)
+	msg := &waProto.Message{Conversation: proto.String("hi")}
+	if err := store.HoldMessage("h1", chat, msg, &PolicyDecision{Action: PolicyActionConfirm}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	claims := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claimed, err := store.ResolvePolicyHold("h1", PolicyHoldStatusApproved, "")
+			if err != nil {
+				t.Error(err)
+			}
+			if claimed {
+				mu.Lock()
+				claims++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if claims != 1 {
+		t.Errorf("the hold was claimed %d times, want once", claims)
+	}
+
+	// A failed send puts it back for another approval
+	if err := store.ReopenPolicyHold("h1"); err != nil {
+		t.Fatal(err)
+	}
+	if hold, err := store.GetPolicyHold("h1"); err != nil || hold.Status != PolicyHoldStatusPending {
+		t.Errorf("reopened hold is %+v (%v), want pending", hold, err)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// WhatsApp polls allow between 2 and 12 options
+const (
+	minPollOptions = 2
+	maxPollOptions = 12
+)
+
+// SendPollRequest represents the request body for the poll message API
+type SendPollRequest struct {
+	Recipient       string   `json:"recipient"`
+	Question        string   `json:"question"`
+	Options         []string `json:"options"`
+	MultiSelect     bool     `json:"multi_select"`
+	QuotedMessageID string   `json:"quoted_message_id"`
+}
+
+// PollOptionResult is the tally of a single poll option
+type PollOptionResult struct {
+	Name   string   `json:"name"`
+	Votes  int      `json:"votes"`
+	Voters []string `json:"voters"`
+}
+
+// PollResults represents the response for the poll results API
+type PollResults struct {
+	ID          string             `json:"id"`
+	ChatJID     string             `json:"chat_jid"`
+	Question    string             `json:"question"`
+	MultiSelect bool               `json:"multi_select"`
+	TotalVoters int                `json:"total_voters"`
+	Options     []PollOptionResult `json:"options"`
+}
+
+// Store a poll so votes for it can be tallied
+func (store *MessageStore) StorePoll(id, chatJID, sender, question string, options []string, selectableCount uint32, timestamp time.Time) error {
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(
+		"INSERT OR REPLACE INTO polls (id, chat_jid, sender, question, options, selectable_count, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		id, chatJID, sender, question, string(encoded), selectableCount, timestamp,
+	)
+	return err
+}
+
+// Get the question and options of a poll
+func (store *MessageStore) GetPoll(id, chatJID string) (question string, options []string, selectableCount uint32, err error) {
+	var encoded string
+	err = store.db.QueryRow(
+		"SELECT question, options, selectable_count FROM polls WHERE id = ? AND chat_jid = ?",
+		id, chatJID,
+	).Scan(&question, &encoded, &selectableCount)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	err = json.Unmarshal([]byte(encoded), &options)
+	return question, options, selectableCount, err
+}
+
+// Find the chat a poll belongs to when the caller only knows its ID
+func (store *MessageStore) FindPollChat(id string) (string, error) {
+	var chatJID string
+	err := store.db.QueryRow("SELECT chat_jid FROM polls WHERE id = ? ORDER BY timestamp DESC LIMIT 1", id).Scan(&chatJID)
+	return chatJID, err
+}
+
+// Replace a voter's selection; every vote update carries the full selection
+func (store *MessageStore) StorePollVote(pollID, chatJID, voter string, options []string, timestamp time.Time) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM poll_votes WHERE poll_id = ? AND chat_jid = ? AND voter = ?", pollID, chatJID, voter)
+	if err != nil {
+		return err
+	}
+	for _, option := range options {
+		_, err = tx.Exec(
+			"INSERT OR REPLACE INTO poll_votes (poll_id, chat_jid, voter, option, timestamp) VALUES (?, ?, ?, ?, ?)",
+			pollID, chatJID, voter, option, timestamp,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Tally the votes of a poll
+func (store *MessageStore) GetPollResults(id, chatJID string) (*PollResults, error) {
+	question, options, selectableCount, err := store.GetPoll(id, chatJID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &PollResults{
+		ID:          id,
+		ChatJID:     chatJID,
+		Question:    question,
+		MultiSelect: selectableCount != 1,
+		Options:     make([]PollOptionResult, len(options)),
+	}
+	index := make(map[string]int, len(options))
+	for i, option := range options {
+		results.Options[i] = PollOptionResult{Name: option, Voters: []string{}}
+		index[option] = i
+	}
+
+	rows, err := store.db.Query(
+		"SELECT voter, option FROM poll_votes WHERE poll_id = ? AND chat_jid = ? ORDER BY timestamp",
+		id, chatJID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	voters := make(map[string]bool)
+	for rows.Next() {
+		var voter, option string
+		if err := rows.Scan(&voter, &option); err != nil {
+			return nil, err
+		}
+		i, ok := index[option]
+		if !ok {
+			continue
+		}
+		results.Options[i].Votes++
+		results.Options[i].Voters = append(results.Options[i].Voters, voter)
+		voters[voter] = true
+	}
+	results.TotalVoters = len(voters)
+	return results, rows.Err()
+}
+
+// Get the poll creation message regardless of which version was used
+func pollCreation(msg *waProto.Message) *waProto.PollCreationMessage {
+	if poll := msg.GetPollCreationMessage(); poll != nil {
+		return poll
+	}
+	if poll := msg.GetPollCreationMessageV2(); poll != nil {
+		return poll
+	}
+	return msg.GetPollCreationMessageV3()
+}
+
+// Record a poll sent by someone else (or by us from another device)
+func (a *Account) handlePollCreation(evt *events.Message, poll *waProto.PollCreationMessage) {
+	options := make([]string, 0, len(poll.GetOptions()))
+	for _, option := range poll.GetOptions() {
+		options = append(options, option.GetOptionName())
+	}
+	err := a.MessageStore.StorePoll(evt.Info.ID, evt.Info.Chat.String(), evt.Info.Sender.User,
+		poll.GetName(), options, poll.GetSelectableOptionsCount(), evt.Info.Timestamp)
+	if err != nil {
+		a.Logger.Warnf("Failed to store poll: %v", err)
+	}
+}
+
+// Decrypt a poll vote and record the voter's selection
+func (a *Account) handlePollVote(evt *events.Message) {
+	pollID := evt.Message.GetPollUpdateMessage().GetPollCreationMessageKey().GetID()
+	chatJID := evt.Info.Chat.String()
+
+	_, options, _, err := a.MessageStore.GetPoll(pollID, chatJID)
+	if err == sql.ErrNoRows {
+		a.Logger.Debugf("Ignoring vote for unknown poll %s", pollID)
+		return
+	} else if err != nil {
+		a.Logger.Warnf("Failed to look up poll %s: %v", pollID, err)
+		return
+	}
+
+	vote, err := a.Client.DecryptPollVote(evt)
+	if err != nil {
+		a.Logger.Warnf("Failed to decrypt vote for poll %s: %v", pollID, err)
+		return
+	}
+
+	// Votes reference options by the SHA-256 of their name
+	hashes := whatsmeow.HashPollOptions(options)
+	var selected []string
+	for _, selectedHash := range vote.GetSelectedOptions() {
+		for i, hash := range hashes {
+			if bytes.Equal(selectedHash, hash) {
+				selected = append(selected, options[i])
+				break
+			}
+		}
+	}
+
+	voter := evt.Info.Sender.User
+	if err := a.MessageStore.StorePollVote(pollID, chatJID, voter, selected, evt.Info.Timestamp); err != nil {
+		a.Logger.Warnf("Failed to store poll vote: %v", err)
+		return
+	}
+
+	a.Logger.Infof("%s voted %s on poll %s", voter, strings.Join(selected, ", "), pollID)
+}
+
+// Handle POST /api/messages/poll
+func (a *Account) HandleSendPollEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var req SendPollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	to, err := parseRecipient(req.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Question) == "" {
+		http.Error(w, "Question is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Options) < minPollOptions || len(req.Options) > maxPollOptions {
+		http.Error(w, fmt.Sprintf("Polls need between %d and %d options", minPollOptions, maxPollOptions), http.StatusBadRequest)
+		return
+	}
+	seen := make(map[string]bool, len(req.Options))
+	for _, option := range req.Options {
+		if strings.TrimSpace(option) == "" || seen[option] {
+			http.Error(w, "Poll options must be unique and non-empty", http.StatusBadRequest)
+			return
+		}
+		seen[option] = true
+	}
+
+	// A selectable count of 0 lets voters pick any number of options
+	selectableCount := 1
+	if req.MultiSelect {
+		selectableCount = 0
+	}
+	msg := a.Client.BuildPollCreation(req.Question, req.Options, selectableCount)
+	if req.QuotedMessageID != "" {
+		msg.PollCreationMessage.ContextInfo = a.quoteContext(to, req.QuotedMessageID)
+	}
+
+	resp, err := a.sendOrQueue(to, msg)
+	if err == nil || err == errQueued {
+		sender := ""
+		if a.Client.Store.ID != nil {
+			sender = a.Client.Store.ID.User
+		}
+		if err := a.MessageStore.StorePoll(resp.ID, to.String(), sender, req.Question, req.Options, uint32(selectableCount), resp.Timestamp); err != nil {
+			a.Logger.Warnf("Failed to store poll: %v", err)
+		}
+	}
+	writeSendResult(w, to, resp, err)
+}
+
+// Handle GET /api/polls/{message_id}/results
+func (a *Account) HandlePollResultsEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pollID := r.PathValue("message_id")
+	chatJID := r.URL.Query().Get("chat_jid")
+	if chatJID == "" {
+		found, err := a.MessageStore.FindPollChat(pollID)
+		if err == sql.ErrNoRows {
+			http.Error(w, fmt.Sprintf("Poll %s not found", pollID), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to look up poll: %v", err), http.StatusInternalServerError)
+			return
+		}
+		chatJID = found
+	} else if jid, err := parseRecipient(chatJID); err == nil {
+		chatJID = jid.String()
+	} else {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := a.MessageStore.GetPollResults(pollID, chatJID)
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf("Poll %s not found", pollID), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to tally poll: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
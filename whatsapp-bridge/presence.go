@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// PresenceStatus is the last known presence of a contact
+type PresenceStatus struct {
+	JID string `json:"jid"`
+	// Whether the contact was online at the last update
+	Available bool       `json:"available"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	// Set when the contact went offline without sharing their last seen time
+	LastSeenHidden bool       `json:"last_seen_hidden"`
+	Subscribed     bool       `json:"subscribed"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+}
+
+// PresenceSubscribeResponse represents the response for the presence subscribe API
+type PresenceSubscribeResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	JID     string `json:"jid,omitempty"`
+}
+
+// Save a presence update. Whether a last seen time is hidden is only known
+// when the contact goes offline.
+func (store *MessageStore) StorePresence(jid string, available bool, lastSeen time.Time) error {
+	var seen interface{}
+	if !lastSeen.IsZero() {
+		seen = lastSeen.UTC()
+	}
+	_, err := store.db.Exec(
+		`INSERT INTO presence (jid, available, last_seen, last_seen_hidden, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET available = excluded.available,
+			last_seen = COALESCE(excluded.last_seen, CASE WHEN excluded.last_seen_hidden = 1 THEN NULL ELSE presence.last_seen END),
+			last_seen_hidden = excluded.last_seen_hidden, updated_at = excluded.updated_at`,
+		jid, available, seen, !available && lastSeen.IsZero(), time.Now().UTC(),
+	)
+	return err
+}
+
+// Remember that presence updates of a contact were requested, so the
+// subscription can be renewed after reconnecting
+func (store *MessageStore) StorePresenceSubscription(jid string) error {
+	_, err := store.db.Exec(
+		`INSERT INTO presence (jid, subscribed_at) VALUES (?, ?)
+		ON CONFLICT (jid) DO UPDATE SET subscribed_at = excluded.subscribed_at`,
+		jid, time.Now().UTC(),
+	)
+	return err
+}
+
+// Get the last known presence of a contact
+func (store *MessageStore) GetPresence(jid string) (*PresenceStatus, error) {
+	status := &PresenceStatus{JID: jid}
+	var lastSeen, updatedAt sql.NullTime
+	err := store.db.QueryRow(
+		`SELECT COALESCE(available, 0), last_seen, COALESCE(last_seen_hidden, 0), subscribed_at IS NOT NULL, updated_at
+		FROM presence WHERE jid = ?`,
+		jid,
+	).Scan(&status.Available, &lastSeen, &status.LastSeenHidden, &status.Subscribed, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastSeen.Valid {
+		status.LastSeen = &lastSeen.Time
+	}
+	if updatedAt.Valid {
+		status.UpdatedAt = &updatedAt.Time
+	}
+	return status, nil
+}
+
+// Contacts whose presence updates were requested
+func (store *MessageStore) PresenceSubscriptions() ([]string, error) {
+	rows, err := store.db.Query("SELECT jid FROM presence WHERE subscribed_at IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jids []string
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+		jids = append(jids, jid)
+	}
+	return jids, rows.Err()
+}
+
+// Ask for presence updates of a contact. WhatsApp only sends them while we
+// are marked online, so announce that first.
+func (a *Account) subscribePresence(jid types.JID) error {
+	if err := a.Client.SendPresence(types.PresenceAvailable); err != nil {
+		if errors.Is(err, whatsmeow.ErrNoPushName) {
+			return fmt.Errorf("set a display name before subscribing to presence")
+		}
+		return fmt.Errorf("failed to mark ourselves online: %v", err)
+	}
+	if err := a.Client.SubscribePresence(jid); err != nil {
+		return fmt.Errorf("failed to subscribe to presence: %v", err)
+	}
+	return nil
+}
+
+// Renew presence subscriptions, which the server drops when we disconnect
+func (a *Account) resubscribePresence() {
+	jids, err := a.MessageStore.PresenceSubscriptions()
+	if err != nil {
+		a.Logger.Warnf("Failed to load presence subscriptions: %v", err)
+		return
+	}
+	for _, value := range jids {
+		jid, err := types.ParseJID(value)
+		if err != nil {
+			continue
+		}
+		if err := a.subscribePresence(jid); err != nil {
+			a.Logger.Warnf("Failed to renew presence subscription of %s: %v", jid, err)
+			return
+		}
+	}
+}
+
+// Save a presence update so it can be queried later
+func (a *Account) storePresence(evt *events.Presence) {
+	if err := a.MessageStore.StorePresence(evt.From.ToNonAD().String(), !evt.Unavailable, evt.LastSeen); err != nil {
+		a.Logger.Warnf("Failed to store presence of %s: %v", evt.From, err)
+	}
+}
+
+// Handle POST /api/contacts/{jid}/presence/subscribe
+func (a *Account) HandlePresenceSubscribeEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jid, err := parseRecipient(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jid = jid.ToNonAD()
+	if jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer {
+		http.Error(w, "Presence is only available for individual contacts", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !a.Client.IsConnected() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(PresenceSubscribeResponse{Message: errNotConnected.Error(), JID: jid.String()})
+		return
+	}
+	if err := a.subscribePresence(jid); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(PresenceSubscribeResponse{Message: err.Error(), JID: jid.String()})
+		return
+	}
+	if err := a.MessageStore.StorePresenceSubscription(jid.String()); err != nil {
+		a.Logger.Warnf("Failed to store presence subscription of %s: %v", jid, err)
+	}
+
+	json.NewEncoder(w).Encode(PresenceSubscribeResponse{
+		Success: true,
+		Message: fmt.Sprintf("Subscribed to presence of %s; updates arrive as presence events unless their privacy settings hide them", jid),
+		JID:     jid.String(),
+	})
+}
+
+// Handle GET /api/contacts/{jid}/presence
+func (a *Account) HandlePresenceEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jid, err := parseRecipient(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status, err := a.MessageStore.GetPresence(jid.ToNonAD().String())
+	if err == sql.ErrNoRows {
+		http.Error(w, "No presence known for this contact, subscribe to it first", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load presence: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Profile photos are square; WhatsApp serves them at up to 640x640
+const profilePhotoSize = 640
+
+// Limits WhatsApp puts on the display name and about text, in characters
+const (
+	maxProfileNameLength  = 25
+	maxProfileAboutLength = 139
+)
+
+// Profile is the linked account's own profile
+type Profile struct {
+	JID       string `json:"jid"`
+	Name      string `json:"name"`
+	About     string `json:"about"`
+	PictureID string `json:"picture_id,omitempty"`
+}
+
+// UpdateProfileRequest represents the request body for the profile API.
+// Fields left out are not changed.
+type UpdateProfileRequest struct {
+	Name  *string `json:"name"`
+	About *string `json:"about"`
+}
+
+// ProfileResponse represents the response for the profile APIs
+type ProfileResponse struct {
+	Success bool     `json:"success"`
+	Message string   `json:"message"`
+	Profile *Profile `json:"profile,omitempty"`
+}
+
+// Crop an image to the centered square and scale it to the profile photo
+// size. Each output pixel averages the source pixels it covers, so large
+// photos don't come out jagged.
+func squareProfilePhoto(data []byte) ([]byte, error) {
+	src, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	if side == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(
+		bounds.Min.X+(bounds.Dx()-side)/2,
+		bounds.Min.Y+(bounds.Dy()-side)/2,
+	))
+
+	size := min(side, profilePhotoSize)
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := crop.Min.Y+y*side/size, crop.Min.Y+(y+1)*side/size
+		for x := 0; x < size; x++ {
+			x0, x1 := crop.Min.X+x*side/size, crop.Min.X+(x+1)*side/size
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, _ := src.At(sx, sy).RGBA()
+					r, g, b, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: 0xffff})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("failed to encode profile photo: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Read the account's current profile from WhatsApp
+func (a *Account) profile() (*Profile, error) {
+	if !a.Client.IsConnected() {
+		return nil, errNotConnected
+	}
+	if a.Client.Store.ID == nil {
+		return nil, fmt.Errorf("not logged in")
+	}
+
+	own := a.Client.Store.ID.ToNonAD()
+	profile := &Profile{JID: own.String(), Name: a.Client.Store.PushName}
+	users, err := a.Client.GetUserInfo([]types.JID{own})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch profile: %v", err)
+	}
+	if info, ok := users[own]; ok {
+		profile.About = info.Status
+		profile.PictureID = info.PictureID
+	}
+	return profile, nil
+}
+
+// Change the display name other users see
+func (a *Account) setProfileName(name string) error {
+	if err := a.Client.SendAppState(appstate.BuildSettingPushName(name)); err != nil {
+		return fmt.Errorf("failed to set name: %v", err)
+	}
+	// Our own patches aren't echoed back, so update the stored name ourselves
+	a.Client.Store.PushName = name
+	if err := a.Client.Store.Save(); err != nil {
+		a.Logger.Warnf("Failed to save new push name: %v", err)
+	}
+	return nil
+}
+
+// Set the profile photo, or remove it when data is nil. An empty target JID
+// addresses the account's own profile.
+func (a *Account) setProfilePhoto(data []byte) (string, error) {
+	if data != nil {
+		var err error
+		if data, err = squareProfilePhoto(data); err != nil {
+			return "", err
+		}
+	}
+	pictureID, err := a.Client.SetGroupPhoto(types.EmptyJID, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to set profile photo: %v", err)
+	}
+	return pictureID, nil
+}
+
+// Write a profile API response
+func writeProfileResponse(w http.ResponseWriter, status int, response ProfileResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Status code for a failed profile request
+func profileErrorStatus(err error) int {
+	if errors.Is(err, errNotConnected) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// Handle GET and PUT /api/profile
+func (a *Account) HandleProfileEndpoint(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req UpdateProfileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Name == nil && req.About == nil {
+			http.Error(w, "name or about is required", http.StatusBadRequest)
+			return
+		}
+		if req.Name != nil {
+			*req.Name = strings.TrimSpace(*req.Name)
+			if *req.Name == "" || len([]rune(*req.Name)) > maxProfileNameLength {
+				http.Error(w, fmt.Sprintf("name must be 1-%d characters", maxProfileNameLength), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.About != nil && len([]rune(*req.About)) > maxProfileAboutLength {
+			http.Error(w, fmt.Sprintf("about must be at most %d characters", maxProfileAboutLength), http.StatusBadRequest)
+			return
+		}
+		if !a.Client.IsConnected() {
+			writeProfileResponse(w, http.StatusServiceUnavailable, ProfileResponse{Message: errNotConnected.Error()})
+			return
+		}
+
+		if req.Name != nil {
+			if err := a.setProfileName(*req.Name); err != nil {
+				writeProfileResponse(w, http.StatusInternalServerError, ProfileResponse{Message: err.Error()})
+				return
+			}
+		}
+		if req.About != nil {
+			if err := a.Client.SetStatusMessage(*req.About); err != nil {
+				writeProfileResponse(w, http.StatusInternalServerError, ProfileResponse{Message: fmt.Sprintf("failed to set about: %v", err)})
+				return
+			}
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	profile, err := a.profile()
+	if err != nil {
+		writeProfileResponse(w, profileErrorStatus(err), ProfileResponse{Message: err.Error()})
+		return
+	}
+	message := "Profile"
+	if r.Method == http.MethodPut {
+		message = "Profile updated"
+	}
+	writeProfileResponse(w, http.StatusOK, ProfileResponse{Success: true, Message: message, Profile: profile})
+}
+
+// Handle PUT and DELETE /api/profile/photo. PUT takes a multipart upload in
+// the file field, a raw image body, or a JSON body with a url.
+func (a *Account) HandleProfilePhotoEndpoint(w http.ResponseWriter, r *http.Request) {
+	var data []byte
+	switch r.Method {
+	case http.MethodPut:
+		var err error
+		if data, err = readProfilePhoto(w, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !a.Client.IsConnected() {
+		writeProfileResponse(w, http.StatusServiceUnavailable, ProfileResponse{Message: errNotConnected.Error()})
+		return
+	}
+
+	pictureID, err := a.setProfilePhoto(data)
+	if errors.Is(err, whatsmeow.ErrInvalidImageFormat) {
+		writeProfileResponse(w, http.StatusBadRequest, ProfileResponse{Message: err.Error()})
+		return
+	} else if err != nil {
+		writeProfileResponse(w, http.StatusInternalServerError, ProfileResponse{Message: err.Error()})
+		return
+	}
+
+	profile := &Profile{JID: a.Client.Store.ID.ToNonAD().String(), Name: a.Client.Store.PushName}
+	message := "Profile photo removed"
+	if data != nil {
+		profile.PictureID = pictureID
+		message = "Profile photo updated"
+	}
+	writeProfileResponse(w, http.StatusOK, ProfileResponse{Success: true, Message: message, Profile: profile})
+}
+
+// Read the image of a profile photo request
+func readProfilePhoto(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxMediaSize)
+	contentType := r.Header.Get("Content-Type")
+
+	switch {
+	case strings.HasPrefix(contentType, "multipart/form-data"):
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("a file upload is required: %v", err)
+		}
+		defer file.Close()
+		return io.ReadAll(file)
+	case strings.HasPrefix(contentType, "application/json"):
+		var req struct {
+			URL string `json:"url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+			return nil, fmt.Errorf("a JSON body needs the url of the photo")
+		}
+		data, _, err := fetchMedia(req.URL)
+		return data, err
+	default:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read photo: %v", err)
+		}
+		if len(data) == 0 {
+			return nil, fmt.Errorf("photo is empty")
+		}
+		return data, nil
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdp/qrterminal"
+	"github.com/skip2/go-qrcode"
+)
+
+// Pairing states reported by the QR manager
+const (
+	QRStateDisconnected = "disconnected"
+	QRStateWaiting      = "waiting_for_scan"
+	QRStatePairingCode  = "waiting_for_pairing_code"
+	QRStateTimeout      = "timeout"
+	QRStateConnected    = "connected"
+)
+
+// Terminal QR rendering modes
+const (
+	QRTerminalHalf  = "half"
+	QRTerminalANSI  = "ansi"
+	QRTerminalASCII = "ascii"
+)
+
+// Default and allowed sizes (in pixels) for rendered QR images
+const (
+	defaultQRImageSize = 256
+	minQRImageSize     = 64
+	maxQRImageSize     = 2048
+)
+
+// QRManager tracks the current pairing state, the most recent QR code and any phone pairing code
+type QRManager struct {
+	mu           sync.RWMutex
+	state        string
+	code         string
+	pairingCode  string
+	pairingPhone string
+	updatedAt    time.Time
+
+	// Listeners notified on every state change (used by the SSE stream)
+	subscribers map[chan QRStatus]struct{}
+}
+
+// QRStatus is a point-in-time snapshot of the QR manager
+type QRStatus struct {
+	State        string    `json:"state"`
+	Code         string    `json:"code,omitempty"`
+	PairingCode  string    `json:"pairing_code,omitempty"`
+	PairingPhone string    `json:"pairing_phone,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// QRResponse represents the response for the QR code API
+type QRResponse struct {
+	Success     bool      `json:"success"`
+	Message     string    `json:"message"`
+	State       string    `json:"state"`
+	QRString    string    `json:"qr_string,omitempty"`
+	QRBase64    string    `json:"qr_base64,omitempty"`
+	PairingCode string    `json:"pairing_code,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Create a new QR manager in the disconnected state
+func NewQRManager() *QRManager {
+	return &QRManager{
+		state:       QRStateDisconnected,
+		updatedAt:   time.Now(),
+		subscribers: make(map[chan QRStatus]struct{}),
+	}
+}
+
+// SetCode records a freshly issued QR code. QR codes keep rotating during phone
+// pairing, so an outstanding pairing code keeps the pairing code state.
+func (m *QRManager) SetCode(code string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.code = code
+	if m.pairingCode == "" {
+		m.state = QRStateWaiting
+	}
+	m.updatedAt = time.Now()
+	m.notify()
+}
+
+// SetPairingCode records a pairing code issued for linking by phone number
+func (m *QRManager) SetPairingCode(phone, code string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pairingPhone = phone
+	m.pairingCode = code
+	m.state = QRStatePairingCode
+	m.updatedAt = time.Now()
+	m.notify()
+}
+
+// SetState changes the pairing state, discarding the QR and pairing codes once they are no longer usable
+func (m *QRManager) SetState(state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = state
+	if state != QRStateWaiting && state != QRStatePairingCode {
+		m.code = ""
+		m.pairingCode = ""
+		m.pairingPhone = ""
+	}
+	m.updatedAt = time.Now()
+	m.notify()
+}
+
+// Status returns a snapshot of the current pairing state
+func (m *QRManager) Status() QRStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.snapshot()
+}
+
+// Subscribe registers a listener for state changes. The returned channel is
+// buffered; slow listeners miss intermediate updates rather than blocking pairing.
+func (m *QRManager) Subscribe() chan QRStatus {
+	ch := make(chan QRStatus, 8)
+	m.mu.Lock()
+	m.subscribers[ch] = struct{}{}
+	m.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe removes a listener registered with Subscribe
+func (m *QRManager) Unsubscribe(ch chan QRStatus) {
+	m.mu.Lock()
+	delete(m.subscribers, ch)
+	m.mu.Unlock()
+}
+
+// Push the current state to all listeners; the caller must hold the lock
+func (m *QRManager) notify() {
+	status := m.snapshot()
+	for ch := range m.subscribers {
+		select {
+		case ch <- status:
+		default:
+		}
+	}
+}
+
+// Build a status snapshot; the caller must hold the lock
+func (m *QRManager) snapshot() QRStatus {
+	return QRStatus{
+		State:        m.state,
+		Code:         m.code,
+		PairingCode:  m.pairingCode,
+		PairingPhone: m.pairingPhone,
+		UpdatedAt:    m.updatedAt,
+	}
+}
+
+// GenerateQRImage renders a pairing code as a PNG image
+func GenerateQRImage(code string, size int, level qrcode.RecoveryLevel) ([]byte, error) {
+	if code == "" {
+		return nil, fmt.Errorf("no QR code available")
+	}
+	png, err := qrcode.Encode(code, level, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %v", err)
+	}
+	return png, nil
+}
+
+// Check whether a terminal rendering mode is known
+func isValidQRTerminalMode(mode string) bool {
+	switch mode {
+	case QRTerminalHalf, QRTerminalANSI, QRTerminalASCII:
+		return true
+	}
+	return false
+}
+
+// Print a pairing code to the terminal in the given mode
+func printTerminalQR(code, mode string) {
+	switch mode {
+	case QRTerminalANSI:
+		qrterminal.Generate(code, qrterminal.L, os.Stdout)
+	case QRTerminalASCII:
+		// Plain characters keep log collectors that strip or choke on escape codes happy
+		qrterminal.GenerateWithConfig(code, qrterminal.Config{
+			Level:     qrterminal.L,
+			Writer:    os.Stdout,
+			BlackChar: "##",
+			WhiteChar: "  ",
+			QuietZone: 2,
+		})
+	default:
+		qrterminal.GenerateHalfBlock(code, qrterminal.L, os.Stdout)
+	}
+}
+
+// Parse the error correction level from a query parameter (L, M, Q or H)
+func parseQRLevel(value string) (qrcode.RecoveryLevel, error) {
+	switch strings.ToUpper(value) {
+	case "L":
+		return qrcode.Low, nil
+	case "", "M":
+		return qrcode.Medium, nil
+	case "Q":
+		return qrcode.High, nil
+	case "H":
+		return qrcode.Highest, nil
+	default:
+		return qrcode.Medium, fmt.Errorf("invalid error correction level %q (expected L, M, Q or H)", value)
+	}
+}
+
+// Parse the image size from a query parameter
+func parseQRSize(value string) (int, error) {
+	if value == "" {
+		return defaultQRImageSize, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < minQRImageSize || size > maxQRImageSize {
+		return 0, fmt.Errorf("size must be an integer between %d and %d", minQRImageSize, maxQRImageSize)
+	}
+	return size, nil
+}
+
+// HandleQREndpoint serves the current QR code as JSON (default) or as a raw PNG with ?format=png.
+// The image size and error correction level are configurable via ?size= and ?level=.
+func (m *QRManager) HandleQREndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	size, err := parseQRSize(query.Get("size"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	level, err := parseQRLevel(query.Get("level"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := m.Status()
+	format := query.Get("format")
+
+	if status.Code == "" {
+		if format == "png" {
+			http.Error(w, fmt.Sprintf("No QR code available (state: %s)", status.State), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(QRResponse{
+			Success:     false,
+			Message:     "No QR code available",
+			State:       status.State,
+			PairingCode: status.PairingCode,
+			UpdatedAt:   status.UpdatedAt,
+		})
+		return
+	}
+
+	png, err := GenerateQRImage(status.Code, size, level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if format == "png" {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(png)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(QRResponse{
+		Success:     true,
+		Message:     "Scan this QR code with your WhatsApp app",
+		State:       status.State,
+		QRString:    status.Code,
+		QRBase64:    base64.StdEncoding.EncodeToString(png),
+		PairingCode: status.PairingCode,
+		UpdatedAt:   status.UpdatedAt,
+	})
+}
+
+// Map a pairing state to the SSE event name sent to clients
+func qrEventName(state string) string {
+	switch state {
+	case QRStateWaiting:
+		return "qr"
+	case QRStatePairingCode:
+		return "pairing_code"
+	case QRStateTimeout:
+		return "expired"
+	case QRStateConnected:
+		return "connected"
+	default:
+		return "disconnected"
+	}
+}
+
+// HandleQRStreamEndpoint pushes Server-Sent Events whenever the QR code rotates,
+// expires or the session connects. The current state is sent on connect.
+func (m *QRManager) HandleQRStreamEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	updates := m.Subscribe()
+	defer m.Unsubscribe(updates)
+
+	writeEvent := func(status QRStatus) bool {
+		data, err := json.Marshal(status)
+		if err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", qrEventName(status.State), data); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	if !writeEvent(m.Status()) {
+		return
+	}
+
+	// Comments keep idle proxies from closing the connection
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case status := <-updates:
+			if !writeEvent(status) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// qrPageHTML is a self-contained pairing page that polls /api/qr and renders the current code
+const qrPageHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Link WhatsApp</title>
+<style>
+  body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #f0f2f5; color: #111b21; margin: 0; display: flex; align-items: center; justify-content: center; min-height: 100vh; }
+  .card { background: #fff; border-radius: 12px; box-shadow: 0 2px 12px rgba(0,0,0,.08); padding: 32px; max-width: 420px; text-align: center; }
+  h1 { font-size: 22px; font-weight: 500; margin: 0 0 8px; }
+  p { color: #54656f; line-height: 1.5; margin: 8px 0; }
+  #qr { width: 264px; height: 264px; margin: 24px auto; display: flex; align-items: center; justify-content: center; border: 1px solid #e9edef; border-radius: 8px; }
+  #qr img { width: 256px; height: 256px; image-rendering: pixelated; }
+  #status { font-size: 14px; }
+  .connected { color: #008069; font-weight: 500; }
+</style>
+</head>
+<body>
+<div class="card">
+  <h1>Link WhatsApp to the bridge</h1>
+  <p>Open WhatsApp on your phone, go to <b>Settings &rsaquo; Linked devices &rsaquo; Link a device</b> and scan the code below.</p>
+  <div id="qr"><p>Loading&hellip;</p></div>
+  <p id="status">Waiting for QR code&hellip;</p>
+</div>
+<script>
+(function () {
+  var qr = document.getElementById("qr");
+  var status = document.getElementById("status");
+  var lastCode = "";
+  var params = new URLSearchParams(window.location.search);
+  var accountQuery = "";
+  ["account_id", "api_key"].forEach(function (name) {
+    if (params.get(name)) {
+      accountQuery += "&" + name + "=" + encodeURIComponent(params.get(name));
+    }
+  });
+
+  function render(data) {
+    if (data.state === "connected") {
+      qr.innerHTML = "<p class=\"connected\">&#10003; Linked</p>";
+      status.textContent = "Your WhatsApp account is connected. You can close this page.";
+      status.className = "connected";
+      return false;
+    }
+    if (data.pairing_code) {
+      qr.innerHTML = "<p style=\"font-size:32px;letter-spacing:4px;font-weight:500\">" + data.pairing_code.slice(0, 4) + "-" + data.pairing_code.slice(4) + "</p>";
+      lastCode = "";
+      status.textContent = "Enter this code on your phone under Linked devices › Link with phone number instead.";
+    } else if (data.qr_string && data.qr_base64) {
+      if (data.qr_string !== lastCode) {
+        qr.innerHTML = "<img alt=\"WhatsApp QR code\" src=\"data:image/png;base64," + data.qr_base64 + "\">";
+        lastCode = data.qr_string;
+      }
+      status.textContent = "The code refreshes automatically. Last updated " + new Date(data.updated_at).toLocaleTimeString() + ".";
+    } else {
+      qr.innerHTML = "<p>No code available</p>";
+      lastCode = "";
+      status.textContent = "State: " + data.state.replace(/_/g, " ") + ". Retrying…";
+    }
+    return true;
+  }
+
+  function poll() {
+    fetch("/api/qr?size=512" + accountQuery, { cache: "no-store" })
+      .then(function (resp) { return resp.json(); })
+      .then(function (data) {
+        if (render(data)) {
+          setTimeout(poll, 2000);
+        }
+      })
+      .catch(function () {
+        status.textContent = "Cannot reach the bridge. Retrying…";
+        setTimeout(poll, 5000);
+      });
+  }
+
+  poll();
+})();
+</script>
+</body>
+</html>
+`
+
+// HandleQRPage serves the browser pairing page
+func (m *QRManager) HandleQRPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(qrPageHTML))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Reaction is an emoji reaction to a stored message
+type Reaction struct {
+	Sender    string    `json:"sender"`
+	Emoji     string    `json:"emoji"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ReactionRequest represents the request body for the reaction API
+type ReactionRequest struct {
+	ChatJID string `json:"chat_jid"`
+	// An empty emoji removes our reaction
+	Emoji string `json:"emoji"`
+}
+
+// Store or, for an empty emoji, remove a reaction to a message
+func (store *MessageStore) StoreReaction(messageID, chatJID, sender, emoji string, timestamp time.Time) error {
+	if emoji == "" {
+		_, err := store.db.Exec(
+			"DELETE FROM reactions WHERE message_id = ? AND chat_jid = ? AND sender = ?",
+			messageID, chatJID, sender,
+		)
+		return err
+	}
+	_, err := store.db.Exec(
+		"INSERT OR REPLACE INTO reactions (message_id, chat_jid, sender, emoji, timestamp) VALUES (?, ?, ?, ?, ?)",
+		messageID, chatJID, sender, emoji, timestamp,
+	)
+	return err
+}
+
+// Get the reactions to a message
+func (store *MessageStore) GetReactions(messageID, chatJID string) ([]Reaction, error) {
+	rows, err := store.db.Query(
+		"SELECT sender, emoji, timestamp FROM reactions WHERE message_id = ? AND chat_jid = ? ORDER BY timestamp",
+		messageID, chatJID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reactions := []Reaction{}
+	for rows.Next() {
+		var reaction Reaction
+		if err := rows.Scan(&reaction.Sender, &reaction.Emoji, &reaction.Timestamp); err != nil {
+			return nil, err
+		}
+		reactions = append(reactions, reaction)
+	}
+	return reactions, nil
+}
+
+// Find the chat a message belongs to when the caller only knows its ID
+func (store *MessageStore) FindMessageChat(messageID string) (string, error) {
+	var chatJID string
+	err := store.db.QueryRow(
+		"SELECT chat_jid FROM messages WHERE id = ? ORDER BY timestamp DESC LIMIT 1",
+		messageID,
+	).Scan(&chatJID)
+	return chatJID, err
+}
+
+// Resolve the chat of a message from the request, or from the store if not given
+func (a *Account) resolveMessageChat(messageID, chatJID string) (types.JID, error) {
+	if chatJID == "" {
+		found, err := a.MessageStore.FindMessageChat(messageID)
+		if err == sql.ErrNoRows {
+			return types.JID{}, fmt.Errorf("message %s not found, pass chat_jid", messageID)
+		} else if err != nil {
+			return types.JID{}, fmt.Errorf("failed to look up message: %v", err)
+		}
+		chatJID = found
+	}
+	return parseRecipient(chatJID)
+}
+
+// JID of whoever sent a message in a chat, with the server it was stored
+// with so LID senders stay LIDs. In a direct chat anyone but us is the other
+// party; in a group the sender must be in the store.
+func (a *Account) messageSender(chat types.JID, msg *Message) (types.JID, error) {
+	if msg != nil && msg.IsFromMe && a.Client.Store.ID != nil {
+		return a.Client.Store.ID.ToNonAD(), nil
+	}
+	if chat.Server != types.GroupServer {
+		return chat.ToNonAD(), nil
+	}
+	if msg == nil {
+		return types.EmptyJID, fmt.Errorf("the sender of the message is unknown, as it isn't in the store")
+	}
+	// Messages stored before full JIDs were kept only have the user part,
+	// which could be a phone number or a LID
+	sender, err := types.ParseJID(msg.SenderJID)
+	if msg.SenderJID == "" || err != nil {
+		return types.EmptyJID, fmt.Errorf("the sender of the message is unknown, as it was stored without its JID")
+	}
+	return sender.ToNonAD(), nil
+}
+
+// Record an incoming reaction against the message it targets
+func (a *Account) handleReaction(evt *events.Message, reaction *waProto.ReactionMessage) {
+	messageID := reaction.GetKey().GetID()
+	chatJID := evt.Info.Chat.String()
+	sender := evt.Info.Sender.User
+	emoji := reaction.GetText()
+
+	if err := a.MessageStore.StoreReaction(messageID, chatJID, sender, emoji, evt.Info.Timestamp); err != nil {
+		a.Logger.Warnf("Failed to store reaction: %v", err)
+		return
+	}
+
+	if emoji == "" {
+		a.Logger.Infof("%s removed their reaction to %s", sender, messageID)
+	} else {
+		a.Logger.Infof("%s reacted %s to %s", sender, emoji, messageID)
+	}
+
+	a.Notifier.Notify(a.ID, WebhookEventReaction, map[string]interface{}{
+		"message_id": messageID,
+		"chat_jid":   chatJID,
+		"sender":     sender,
+		"emoji":      emoji,
+		"is_from_me": evt.Info.IsFromMe,
+	})
+}
+
+// Handle POST /api/messages/{id}/reaction
+func (a *Account) HandleReactionEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID := r.PathValue("id")
+
+	// Parse the request body
+	var req ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	chat, err := a.resolveMessageChat(messageID, req.ChatJID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The reaction key must name the original sender of the message
+	original, _ := a.MessageStore.GetMessage(messageID, chat.String())
+	sender, err := a.messageSender(chat, original)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot react to %s: %v", messageID, err), http.StatusBadRequest)
+		return
+	}
+
+	msg := a.Client.BuildReaction(chat, sender, messageID, req.Emoji)
+	resp, err := a.sendOrQueue(chat, msg)
+	if (err == nil || err == errQueued) && a.Client.Store.ID != nil {
+		if err := a.MessageStore.StoreReaction(messageID, chat.String(), a.Client.Store.ID.User, req.Emoji, resp.Timestamp); err != nil {
+			a.Logger.Warnf("Failed to store reaction: %v", err)
+		}
+	}
+	writeSendResult(w, chat, resp, err)
+}
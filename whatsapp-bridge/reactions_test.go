@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestMessageSenderKeepsItsServer(t *testing.T) {
+	store, err := NewMessageStore(&Config{}, DefaultAccountID, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	account := &Account{MessageStore: store}
+	group := types.NewJID("120363000000000000", types.GroupServer)
+	if err := store.StoreChat(group.String(), "G", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for id, senderJID := range map[string]string{"lid": "81234567890123@lid", "old": ""} {
+		if err := store.StoreMessage(id, group.String(), "81234567890123", "hi", time.Now(), false, "", "", "", nil, nil, nil, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.StoreMessageDetails(id, group.String(), MessageDetails{Type: "text", SenderJID: senderJID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg, err := store.GetMessage("lid", group.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sender, err := account.messageSender(group, msg); err != nil || sender.String() != "81234567890123@lid" {
+		t.Errorf("sender is %s (%v), want the LID it was stored with", sender, err)
+	}
+
+	msg, err = store.GetMessage("old", group.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sender, err := account.messageSender(group, msg); err == nil {
+		t.Errorf("sender of a message stored without its JID is %s, want an error", sender)
+	}
+	if sender, err := account.messageSender(group, nil); err == nil {
+		t.Errorf("sender of a message missing from a group is %s, want an error", sender)
+	}
+
+	direct := types.NewJID("81234567890123", types.HiddenUserServer)
+	if sender, err := account.messageSender(direct, nil); err != nil || sender != direct {
+		t.Errorf("sender in a direct chat is %s (%v), want the chat", sender, err)
+	}
+}
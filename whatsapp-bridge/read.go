@@ -0,0 +1,198 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// MarkReadRequest represents the request body for the mark read API
+type MarkReadRequest struct {
+	// Messages to mark as read; empty means every unread message in the chat
+	MessageIDs []string `json:"message_ids"`
+}
+
+// MarkReadResponse represents the response for the mark read API
+type MarkReadResponse struct {
+	Success     bool     `json:"success"`
+	Message     string   `json:"message"`
+	ChatJID     string   `json:"chat_jid,omitempty"`
+	MessageIDs  []string `json:"message_ids,omitempty"`
+	UnreadCount int      `json:"unread_count"`
+}
+
+// Flag an incoming message as unread
+func (store *MessageStore) MarkUnread(id, chatJID string) error {
+	_, err := store.db.Exec("UPDATE messages SET is_read = 0 WHERE id = ? AND chat_jid = ?", id, chatJID)
+	return err
+}
+
+// Flag messages in a chat as read; no IDs marks the whole chat
+func (store *MessageStore) MarkMessagesRead(chatJID string, ids []string) error {
+	if len(ids) == 0 {
+		_, err := store.db.Exec("UPDATE messages SET is_read = 1 WHERE chat_jid = ? AND is_read = 0", chatJID)
+		return err
+	}
+	args := []interface{}{chatJID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	_, err := store.db.Exec("UPDATE messages SET is_read = 1 WHERE chat_jid = ? AND id IN ("+placeholders+")", args...)
+	return err
+}
+
+// Get the senders of unread incoming messages in a chat, keyed by message ID
+func (store *MessageStore) UnreadMessages(chatJID string) (map[string]string, error) {
+	rows, err := store.db.Query(
+		"SELECT id, sender FROM messages WHERE chat_jid = ? AND is_read = 0 AND is_from_me = 0",
+		chatJID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	unread := make(map[string]string)
+	for rows.Next() {
+		var id, sender string
+		if err := rows.Scan(&id, &sender); err != nil {
+			return nil, err
+		}
+		unread[id] = sender
+	}
+	return unread, rows.Err()
+}
+
+// Count unread incoming messages in a chat
+func (store *MessageStore) UnreadCount(chatJID string) (int, error) {
+	var count int
+	err := store.db.QueryRow(
+		"SELECT COUNT(*) FROM messages WHERE chat_jid = ? AND is_read = 0 AND is_from_me = 0",
+		chatJID,
+	).Scan(&count)
+	return count, err
+}
+
+// Keep the local unread state in sync when we read messages on another device
+func (a *Account) handleReadSelf(evt *events.Receipt) {
+	ids := make([]string, len(evt.MessageIDs))
+	for i, id := range evt.MessageIDs {
+		ids[i] = string(id)
+	}
+	if err := a.MessageStore.MarkMessagesRead(evt.Chat.String(), ids); err != nil {
+		a.Logger.Warnf("Failed to mark messages read: %v", err)
+	}
+}
+
+// Send read receipts for messages in a chat and mark them read locally.
+// Receipts are grouped by sender since group receipts must name the participant.
+func (a *Account) markRead(chat types.JID, ids []string) ([]string, error) {
+	unread, err := a.MessageStore.UnreadMessages(chat.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load unread messages: %v", err)
+	}
+	if len(ids) == 0 {
+		for id := range unread {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if !a.Client.IsConnected() {
+		return nil, errNotConnected
+	}
+
+	bySender := make(map[types.JID][]types.MessageID)
+	for _, id := range ids {
+		sender := types.EmptyJID
+		if chat.Server == types.GroupServer {
+			msg, err := a.MessageStore.GetMessage(id, chat.String())
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("message %s not found in %s", id, chat)
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to look up message %s: %v", id, err)
+			}
+			if sender, err = a.messageSender(chat, msg); err != nil {
+				return nil, fmt.Errorf("cannot send a read receipt for %s: %v", id, err)
+			}
+		}
+		bySender[sender] = append(bySender[sender], types.MessageID(id))
+	}
+
+	now := time.Now()
+	for sender, senderIDs := range bySender {
+		if err := a.Client.MarkRead(senderIDs, now, chat, sender); err != nil {
+			return nil, fmt.Errorf("failed to send read receipt: %v", err)
+		}
+	}
+	if err := a.MessageStore.MarkMessagesRead(chat.String(), ids); err != nil {
+		return nil, fmt.Errorf("failed to update unread messages: %v", err)
+	}
+	return ids, nil
+}
+
+// Handle POST /api/chats/{jid}/read
+func (a *Account) HandleMarkReadEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	chat, err := parseRecipient(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The body is optional, an empty one marks the whole chat
+	var req MarkReadRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	ids, err := a.markRead(chat, req.MessageIDs)
+	if err != nil {
+		if err == errNotConnected {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(MarkReadResponse{
+			Success: false,
+			Message: err.Error(),
+			ChatJID: chat.String(),
+		})
+		return
+	}
+
+	unread, err := a.MessageStore.UnreadCount(chat.String())
+	if err != nil {
+		a.Logger.Warnf("Failed to count unread messages: %v", err)
+	}
+	message := fmt.Sprintf("Marked %d messages as read", len(ids))
+	if len(ids) == 0 {
+		message = "No unread messages"
+	}
+	json.NewEncoder(w).Encode(MarkReadResponse{
+		Success:     true,
+		Message:     message,
+		ChatJID:     chat.String(),
+		MessageIDs:  ids,
+		UnreadCount: unread,
+	})
+}
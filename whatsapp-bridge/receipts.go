@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Receipt states of an outgoing message, in the order they progress
+const (
+	ReceiptStatusDelivered = "delivered"
+	ReceiptStatusRead      = "read"
+	ReceiptStatusPlayed    = "played"
+)
+
+// Order receipt states so a late delivery receipt never downgrades a read one
+const receiptRankSQL = "CASE %s WHEN 'played' THEN 3 WHEN 'read' THEN 2 ELSE 1 END"
+
+// MessageReceipt is the latest receipt from one recipient of a message
+type MessageReceipt struct {
+	JID       string    `json:"jid"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// MessageReceiptsResponse represents the response for the receipts API
+type MessageReceiptsResponse struct {
+	MessageID string           `json:"message_id"`
+	ChatJID   string           `json:"chat_jid"`
+	Status    string           `json:"status,omitempty"`
+	Receipts  []MessageReceipt `json:"receipts"`
+}
+
+// Record a receipt unless the recipient already reported a later state
+func (store *MessageStore) StoreReceipt(messageID, chatJID, recipient, status string, timestamp time.Time) error {
+	_, err := store.db.Exec(
+		`INSERT INTO message_receipts (message_id, chat_jid, recipient, status, timestamp) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid, recipient) DO UPDATE SET status = excluded.status, timestamp = excluded.timestamp
+		WHERE `+fmt.Sprintf(receiptRankSQL, "excluded.status")+` > `+fmt.Sprintf(receiptRankSQL, "message_receipts.status"),
+		messageID, chatJID, recipient, status, timestamp,
+	)
+	return err
+}
+
+// Get the receipts of a message
+func (store *MessageStore) GetReceipts(messageID, chatJID string) ([]MessageReceipt, error) {
+	rows, err := store.db.Query(
+		"SELECT recipient, status, timestamp FROM message_receipts WHERE message_id = ? AND chat_jid = ? ORDER BY timestamp",
+		messageID, chatJID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	receipts := []MessageReceipt{}
+	for rows.Next() {
+		var receipt MessageReceipt
+		if err := rows.Scan(&receipt.JID, &receipt.Status, &receipt.Timestamp); err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, rows.Err()
+}
+
+// Map a whatsmeow receipt type to the state we track, if any
+func receiptStatus(receiptType types.ReceiptType) string {
+	switch receiptType {
+	case types.ReceiptTypeDelivered:
+		return ReceiptStatusDelivered
+	case types.ReceiptTypeRead:
+		return ReceiptStatusRead
+	case types.ReceiptTypePlayed:
+		return ReceiptStatusPlayed
+	}
+	return ""
+}
+
+// Record delivery, read and played receipts for messages we sent
+func (a *Account) handleReceipt(evt *events.Receipt) {
+	status := receiptStatus(evt.Type)
+	if status == "" {
+		return
+	}
+
+	chatJID := evt.Chat.String()
+	recipient := evt.Sender.ToNonAD().String()
+	ids := make([]string, 0, len(evt.MessageIDs))
+	for _, id := range evt.MessageIDs {
+		if err := a.MessageStore.StoreReceipt(string(id), chatJID, recipient, status, evt.Timestamp); err != nil {
+			a.Logger.Warnf("Failed to store receipt for %s: %v", id, err)
+		}
+		tracer.receiptArrived(string(id), status)
+		ids = append(ids, string(id))
+	}
+
+	a.Logger.Infof("%s %s %s", recipient, status, strings.Join(ids, ", "))
+
+	a.Notifier.Notify(a.ID, WebhookEventReceipt, map[string]interface{}{
+		"message_ids": ids,
+		"chat_jid":    chatJID,
+		"recipient":   recipient,
+		"status":      status,
+		"timestamp":   evt.Timestamp,
+	})
+}
+
+// Handle GET /api/messages/{id}/receipts
+func (a *Account) HandleReceiptsEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID := r.PathValue("id")
+	chat, err := a.resolveMessageChat(messageID, r.URL.Query().Get("chat_jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	receipts, err := a.MessageStore.GetReceipts(messageID, chat.String())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load receipts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// The overall status is the least progressed one, so "read" means everyone read it
+	response := MessageReceiptsResponse{MessageID: messageID, ChatJID: chat.String(), Receipts: receipts}
+	rank := map[string]int{ReceiptStatusDelivered: 1, ReceiptStatusRead: 2, ReceiptStatusPlayed: 3}
+	for _, receipt := range receipts {
+		if response.Status == "" || rank[receipt.Status] < rank[response.Status] {
+			response.Status = receipt.Status
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
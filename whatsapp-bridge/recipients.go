@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Recipient kinds
+const (
+	RecipientContact = "contact"
+	RecipientGroup   = "group"
+)
+
+// Candidates with a lower score are not offered at all
+const minRecipientScore = 0.3
+
+// The best candidate is picked on its own only when it scores at least this
+// and beats the runner-up by recipientScoreMargin
+const (
+	confidentRecipientScore = 0.6
+	recipientScoreMargin    = 0.15
+)
+
+// RecipientCandidate is a contact or group a name may refer to
+type RecipientCandidate struct {
+	JID   string `json:"jid"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Phone string `json:"phone,omitempty"`
+	// 1 for an exact match, down to minRecipientScore
+	Score float64 `json:"score"`
+	// The name or number that matched
+	MatchedOn string `json:"matched_on,omitempty"`
+}
+
+// RecipientResolution represents the response for the recipient resolution API
+type RecipientResolution struct {
+	Query string `json:"query"`
+	// Set when the query clearly means one recipient
+	Resolved *RecipientCandidate `json:"resolved,omitempty"`
+	// Best matches first
+	Candidates []RecipientCandidate `json:"candidates"`
+}
+
+// Find who a phone number, JID, contact name or group name refers to. Names
+// are matched loosely against the contact and group caches, so typos and
+// partial names still find someone.
+func (a *Account) resolveRecipient(query string, limit int) (*RecipientResolution, error) {
+	query = strings.TrimSpace(query)
+	result := &RecipientResolution{Query: query, Candidates: []RecipientCandidate{}}
+
+	// Numbers and JIDs need no lookup, only a name to confirm with
+	if jid, err := parseRecipient(query); err == nil {
+		candidate := a.knownRecipient(jid)
+		result.Resolved = &candidate
+		result.Candidates = append(result.Candidates, candidate)
+		return result, nil
+	}
+
+	// A limit of -1 lifts SQLite's limit; the whole directory is ranked
+	contacts, _, err := a.MessageStore.ListContacts("", -1, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contacts: %v", err)
+	}
+	groups, err := a.MessageStore.ListGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read groups: %v", err)
+	}
+
+	for _, contact := range contacts {
+		if score, matchedOn := scoreContact(query, contact); score >= minRecipientScore {
+			result.Candidates = append(result.Candidates, RecipientCandidate{
+				JID: contact.JID, Name: contact.Name, Type: RecipientContact, Phone: contact.Phone, Score: score, MatchedOn: matchedOn,
+			})
+		}
+	}
+	for _, group := range groups {
+		if score := fuzzyScore(query, group.Name); score >= minRecipientScore {
+			result.Candidates = append(result.Candidates, RecipientCandidate{
+				JID: group.JID, Name: group.Name, Type: RecipientGroup, Score: score, MatchedOn: group.Name,
+			})
+		}
+	}
+
+	sort.SliceStable(result.Candidates, func(i, j int) bool {
+		return result.Candidates[i].Score > result.Candidates[j].Score
+	})
+	if len(result.Candidates) > limit {
+		result.Candidates = result.Candidates[:limit]
+	}
+	if len(result.Candidates) > 0 {
+		best := result.Candidates[0]
+		if best.Score >= confidentRecipientScore &&
+			(len(result.Candidates) == 1 || best.Score-result.Candidates[1].Score >= recipientScoreMargin) {
+			result.Resolved = &best
+		}
+	}
+	return result, nil
+}
+
+// Score a contact by whichever of its names or its number matches a query best
+func scoreContact(query string, contact Contact) (float64, string) {
+	var best float64
+	var matchedOn string
+	for _, name := range []string{contact.FullName, contact.FirstName, contact.PushName, contact.BusinessName, contact.Phone} {
+		if score := fuzzyScore(query, name); score > best {
+			best, matchedOn = score, name
+		}
+	}
+	return best, matchedOn
+}
+
+// Describe a JID with the name the caches have for it
+func (a *Account) knownRecipient(jid types.JID) RecipientCandidate {
+	candidate := RecipientCandidate{JID: jid.String(), Type: RecipientContact, Score: 1}
+	if jid.Server == types.GroupServer {
+		candidate.Type = RecipientGroup
+		if group, err := a.MessageStore.GetGroup(jid.String()); err == nil {
+			candidate.Name = group.Name
+		}
+		return candidate
+	}
+	if jid.Server == types.DefaultUserServer {
+		candidate.Phone = jid.User
+	}
+	if contact, err := a.MessageStore.GetContact(jid.ToNonAD().String()); err == nil {
+		candidate.Name = contact.displayName()
+	}
+	return candidate
+}
+
+// Strip the accents of common Latin letters so "jose" matches "José"
+var accentFolder = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "a", "ã", "a", "å", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "ö", "o", "õ", "o", "ø", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n", "ý", "y", "ÿ", "y", "ß", "ss",
+)
+
+// Normalize a name for matching: lower case, no accents, single spaces
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(accentFolder.Replace(strings.ToLower(name))), " ")
+}
+
+// Score how well a query matches a name from 0 to 1: exact matches, then
+// prefixes, then substrings, then names sharing enough trigrams to be a typo
+func fuzzyScore(query, name string) float64 {
+	q, n := normalizeName(query), normalizeName(name)
+	if q == "" || n == "" {
+		return 0
+	}
+	// Phone numbers are compared without formatting
+	if digits := strings.NewReplacer("+", "", " ", "", "-", "", "(", "", ")", "").Replace(q); digits != "" && strings.Trim(digits, "0123456789") == "" {
+		if strings.Trim(n, "0123456789") == "" {
+			q = digits
+		}
+	}
+
+	switch {
+	case q == n:
+		return 1
+	case strings.HasPrefix(n, q):
+		return 0.9
+	}
+	words := strings.Fields(n)
+	for _, word := range words {
+		if word == q {
+			return 0.85
+		}
+	}
+	for _, word := range words {
+		if strings.HasPrefix(word, q) {
+			return 0.8
+		}
+	}
+	if strings.Contains(n, q) {
+		return 0.7
+	}
+
+	// Typos: compare against the whole name and each word, counting shared
+	// trigrams so the order of words doesn't matter
+	best := trigramSimilarity(q, n)
+	for _, word := range words {
+		best = max(best, trigramSimilarity(q, word))
+	}
+	return math.Round(best*0.75*100) / 100
+}
+
+// Jaccard similarity of the trigrams of two strings, padded so short words
+// still have some
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for gram := range ta {
+		if tb[gram] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+// Set of the trigrams of a string
+func trigrams(s string) map[string]bool {
+	padded := []rune("  " + s + " ")
+	grams := make(map[string]bool, len(padded))
+	for i := 0; i+3 <= len(padded); i++ {
+		grams[string(padded[i:i+3])] = true
+	}
+	return grams
+}
+
+// Handle GET /api/recipients/resolve
+func (a *Account) HandleResolveRecipientEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	if strings.TrimSpace(query.Get("q")) == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	limit := 5
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 50)
+	}
+
+	result, err := a.resolveRecipient(query.Get("q"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RetentionPolicy says which stored messages pruning deletes. Starred
+// messages are always kept and don't count towards the per-chat cap.
+type RetentionPolicy struct {
+	// Delete text messages older than this many days, 0 to keep them
+	TextMaxAgeDays int `json:"text_max_age_days"`
+	// Delete media messages and their downloaded files older than this many
+	// days, 0 to keep them
+	MediaMaxAgeDays int `json:"media_max_age_days"`
+	// Keep only this many of each chat's newest messages, 0 for no cap
+	MaxMessagesPerChat int `json:"max_messages_per_chat"`
+}
+
+// Report whether the policy deletes anything
+func (p RetentionPolicy) enabled() bool {
+	return p.TextMaxAgeDays > 0 || p.MediaMaxAgeDays > 0 || p.MaxMessagesPerChat > 0
+}
+
+// PruneRequest represents the optional request body for the prune API
+type PruneRequest struct {
+	// VACUUM after pruning so freed pages go back to the disk; defaults to true
+	Vacuum *bool `json:"vacuum,omitempty"`
+}
+
+// PruneResult is what pruning an account reclaimed
+type PruneResult struct {
+	Account string `json:"account"`
+	// Messages deleted by each rule
+	TextMessages  int64 `json:"text_messages"`
+	MediaMessages int64 `json:"media_messages"`
+	OverChatCap   int64 `json:"over_chat_cap"`
+	// Reactions, edits, receipts, labels and polls of the deleted messages
+	RelatedRows int64 `json:"related_rows"`
+	// Downloaded files of the deleted messages
+	MediaFiles int   `json:"media_files"`
+	MediaBytes int64 `json:"media_bytes"`
+	Vacuumed   bool  `json:"vacuumed"`
+	// Size of the message database before and after
+	DatabaseBytesBefore int64 `json:"database_bytes_before"`
+	DatabaseBytesAfter  int64 `json:"database_bytes_after"`
+	// Database shrinkage plus deleted media
+	BytesReclaimed int64  `json:"bytes_reclaimed"`
+	Error          string `json:"error,omitempty"`
+}
+
+// Total messages deleted
+func (r PruneResult) messages() int64 {
+	return r.TextMessages + r.MediaMessages + r.OverChatCap
+}
+
+// PruneResponse represents the response for the prune API
+type PruneResponse struct {
+	Policy   RetentionPolicy `json:"policy"`
+	Accounts []PruneResult   `json:"accounts"`
+	// Totals over every account
+	Rows           int64 `json:"rows"`
+	BytesReclaimed int64 `json:"bytes_reclaimed"`
+}
+
+// Tables holding rows about a message, by their message and chat columns
+var messageRelatedTables = []struct{ table, messageColumn string }{
+	{"reactions", "message_id"},
+	{"message_edits", "message_id"},
+	{"message_receipts", "message_id"},
+	{"label_messages", "message_id"},
+	{"poll_votes", "poll_id"},
+	{"polls", "id"},
+}
+
+// Delete the messages matching a condition along with the rows about them,
+// returning how many messages and related rows went and the downloaded
+// files left to delete
+func (store *MessageStore) PruneMessages(condition string, args ...interface{}) (int64, int64, []localMediaFile, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, chat_jid, local_path, COALESCE(local_size, 0) FROM messages WHERE COALESCE(local_path, '') != '' AND "+condition, args...)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	var files []localMediaFile
+	for rows.Next() {
+		var file localMediaFile
+		if err := rows.Scan(&file.messageID, &file.chatJID, &file.path, &file.size); err != nil {
+			rows.Close()
+			return 0, 0, nil, err
+		}
+		files = append(files, file)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, nil, err
+	}
+
+	matching := "SELECT id, chat_jid FROM messages WHERE " + condition
+	var related int64
+	for _, t := range messageRelatedTables {
+		result, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE (%s, chat_jid) IN (%s)", t.table, t.messageColumn, matching), args...)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("failed to prune %s: %v", t.table, err)
+		}
+		n, _ := result.RowsAffected()
+		related += n
+	}
+	result, err := tx.Exec("DELETE FROM messages WHERE "+condition, args...)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted, related, files, tx.Commit()
+}
+
+// Size of the message database in bytes. For Postgres this is the account's
+// schema, indexes included.
+func (store *MessageStore) DatabaseSize() (int64, error) {
+	query := "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()"
+	if !store.db.isSQLite() {
+		query = `SELECT COALESCE(SUM(pg_total_relation_size(quote_ident(table_schema) || '.' || quote_ident(table_name))), 0)
+			FROM information_schema.tables WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'`
+	}
+	var size int64
+	err := store.db.QueryRow(query).Scan(&size)
+	return size, err
+}
+
+// Rebuild the message database to hand the space of deleted rows back to
+// the disk. Postgres only marks it reusable.
+func (store *MessageStore) Vacuum() error {
+	_, err := store.db.Exec("VACUUM")
+	return err
+}
+
+// Pruner applies the retention policy to an account's messages every night
+// at a set time, and vacuums the database every few days
+type Pruner struct {
+	account *Account
+	policy  RetentionPolicy
+	// Minutes after local midnight the nightly run starts
+	at int
+	// 0 never vacuums on schedule
+	vacuumEvery time.Duration
+
+	// Held for the length of a run
+	running    sync.Mutex
+	lastVacuum time.Time
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// Create a pruner for an account
+func NewPruner(account *Account, policy RetentionPolicy, at int, vacuumEvery time.Duration) *Pruner {
+	return &Pruner{
+		account:     account,
+		policy:      policy,
+		at:          at,
+		vacuumEvery: vacuumEvery,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Next nightly run after now
+func (p *Pruner) next(now time.Time) time.Time {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	next := midnight.Add(time.Duration(p.at) * time.Minute)
+	if !next.After(now) {
+		next = time.Date(now.Year(), now.Month(), now.Day()+1, 0, p.at, 0, 0, now.Location())
+	}
+	return next
+}
+
+// Run prunes every night until Stop is called
+func (p *Pruner) Run() {
+	defer close(p.done)
+	for {
+		timer := time.NewTimer(time.Until(p.next(time.Now())))
+		select {
+		case <-timer.C:
+		case <-p.stop:
+			timer.Stop()
+			return
+		}
+
+		vacuum := p.vacuumEvery > 0 && time.Since(p.lastVacuum) >= p.vacuumEvery
+		if !p.policy.enabled() && !vacuum {
+			continue
+		}
+		result := p.Prune(vacuum)
+		if result.Error != "" {
+			p.account.Logger.Warnf("Failed to prune messages: %s", result.Error)
+		} else if result.messages() > 0 || result.Vacuumed {
+			p.account.Logger.Infof("Pruned %d messages and %d media files, reclaiming %d KB", result.messages(), result.MediaFiles, result.BytesReclaimed>>10)
+		}
+	}
+}
+
+// Stop the pruner and wait for an in-flight run to finish
+func (p *Pruner) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+// Apply the retention policy now, then vacuum if asked
+func (p *Pruner) Prune(vacuum bool) PruneResult {
+	p.running.Lock()
+	defer p.running.Unlock()
+
+	store := p.account.MessageStore
+	result := PruneResult{Account: p.account.ID}
+	before, err := store.DatabaseSize()
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to measure the database: %v", err)
+		return result
+	}
+	result.DatabaseBytesBefore = before
+	result.DatabaseBytesAfter = before
+
+	if err := p.apply(&result, time.Now()); err != nil {
+		result.Error = err.Error()
+	}
+	if vacuum && result.Error == "" {
+		if err := store.Vacuum(); err != nil {
+			result.Error = fmt.Sprintf("failed to vacuum: %v", err)
+		} else {
+			result.Vacuumed = true
+			p.lastVacuum = time.Now()
+		}
+	}
+	if after, err := store.DatabaseSize(); err == nil {
+		result.DatabaseBytesAfter = after
+	}
+	result.BytesReclaimed = result.MediaBytes
+	if shrunk := result.DatabaseBytesBefore - result.DatabaseBytesAfter; shrunk > 0 {
+		result.BytesReclaimed += shrunk
+	}
+	return result
+}
+
+// Run each rule of the policy, deleting the downloaded files of what went
+func (p *Pruner) apply(result *PruneResult, now time.Time) error {
+	const kept = "COALESCE(starred, 0) = 0"
+	type rule struct {
+		name      string
+		condition string
+		args      []interface{}
+		deleted   *int64
+	}
+	var rules []rule
+	if days := p.policy.TextMaxAgeDays; days > 0 {
+		rules = append(rules, rule{"text", kept + " AND COALESCE(media_type, '') = '' AND timestamp < ?",
+			[]interface{}{now.AddDate(0, 0, -days).UTC()}, &result.TextMessages})
+	}
+	if days := p.policy.MediaMaxAgeDays; days > 0 {
+		rules = append(rules, rule{"media", kept + " AND COALESCE(media_type, '') != '' AND timestamp < ?",
+			[]interface{}{now.AddDate(0, 0, -days).UTC()}, &result.MediaMessages})
+	}
+	if limit := p.policy.MaxMessagesPerChat; limit > 0 {
+		rules = append(rules, rule{"per-chat cap", `(id, chat_jid) IN (
+			SELECT id, chat_jid FROM (
+				SELECT id, chat_jid, ROW_NUMBER() OVER (PARTITION BY chat_jid ORDER BY timestamp DESC, id DESC) AS position
+				FROM messages WHERE ` + kept + `
+			) ranked WHERE position > ?)`,
+			[]interface{}{limit}, &result.OverChatCap})
+	}
+
+	for _, r := range rules {
+		deleted, related, files, err := p.account.MessageStore.PruneMessages(r.condition, r.args...)
+		if err != nil {
+			return fmt.Errorf("failed to apply the %s rule: %v", r.name, err)
+		}
+		*r.deleted += deleted
+		result.RelatedRows += related
+		for _, file := range files {
+			// The rows are gone, so a file that can't be deleted is only logged
+			if err := p.account.Media.Delete(file.path); err != nil {
+				p.account.Logger.Warnf("Failed to delete pruned media %s: %v", file.path, err)
+				continue
+			}
+			result.MediaFiles++
+			result.MediaBytes += file.size
+		}
+	}
+	return nil
+}
+
+// Handle POST /api/admin/prune, pruning every account or the one named by
+// account_id
+func (am *AccountManager) HandlePruneEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The body is optional
+	var req PruneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	vacuum := req.Vacuum == nil || *req.Vacuum
+
+	accounts := am.List()
+	if r.URL.Query().Get("account_id") != "" {
+		account, err := am.FromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		accounts = []*Account{account}
+	}
+
+	response := PruneResponse{Policy: am.cfg.retentionPolicy(), Accounts: []PruneResult{}}
+	failed := false
+	for _, account := range accounts {
+		result := account.Pruner.Prune(vacuum)
+		if result.Error != "" {
+			failed = true
+		}
+		response.Accounts = append(response.Accounts, result)
+		response.Rows += result.messages() + result.RelatedRows
+		response.BytesReclaimed += result.BytesReclaimed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if failed {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// How long after sending WhatsApp allows deleting a message for everyone
+const messageRevokeWindow = 60 * time.Hour
+
+// Deletion scopes accepted by DELETE /api/messages/{id}
+const (
+	DeleteScopeEveryone = "everyone"
+	DeleteScopeMe       = "me"
+)
+
+// DeleteMessageResponse represents the response for the delete message API
+type DeleteMessageResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	ID      string `json:"id,omitempty"`
+	Scope   string `json:"scope,omitempty"`
+}
+
+// Remove a message and everything attached to it from the local store
+func (store *MessageStore) DeleteMessage(id, chatJID string) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM messages WHERE id = ? AND chat_jid = ?", id, chatJID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec("DELETE FROM reactions WHERE message_id = ? AND chat_jid = ?", id, chatJID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM message_edits WHERE message_id = ? AND chat_jid = ?", id, chatJID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Drop a message that its sender deleted for everyone
+func (a *Account) handleRevoke(evt *events.Message, protocolMsg *waProto.ProtocolMessage) {
+	messageID := protocolMsg.GetKey().GetID()
+	err := a.MessageStore.DeleteMessage(messageID, evt.Info.Chat.String())
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		a.Logger.Warnf("Failed to delete revoked message: %v", err)
+		return
+	}
+
+	a.Logger.Infof("%s deleted %s for everyone", evt.Info.Sender.User, messageID)
+}
+
+// Handle DELETE /api/messages/{id}?scope=everyone|me
+func (a *Account) handleDeleteEndpoint(w http.ResponseWriter, r *http.Request) {
+	messageID := r.PathValue("id")
+
+	// Revoking can't be undone, so the scope is never assumed
+	scope := r.URL.Query().Get("scope")
+	if scope != DeleteScopeEveryone && scope != DeleteScopeMe {
+		http.Error(w, "Scope is required and must be everyone or me", http.StatusBadRequest)
+		return
+	}
+
+	chat, err := a.resolveMessageChat(messageID, r.URL.Query().Get("chat_jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	original, err := a.MessageStore.GetMessage(messageID, chat.String())
+	if err != nil && err != sql.ErrNoRows {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(DeleteMessageResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to look up message: %v", err),
+		})
+		return
+	}
+
+	if scope == DeleteScopeMe {
+		if original == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(DeleteMessageResponse{
+				Success: false,
+				Message: fmt.Sprintf("Message %s not found", messageID),
+			})
+			return
+		}
+		if err := a.MessageStore.DeleteMessage(messageID, chat.String()); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(DeleteMessageResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to delete message: %v", err),
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(DeleteMessageResponse{
+			Success: true,
+			Message: "Message deleted from the local store",
+			ID:      messageID,
+			Scope:   scope,
+		})
+		return
+	}
+
+	// Others' messages can only be revoked by group admins, which the server enforces
+	sender := types.EmptyJID
+	if original != nil && !original.IsFromMe {
+		if chat.Server != types.GroupServer {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(DeleteMessageResponse{
+				Success: false,
+				Message: "Only messages sent by this account can be deleted for everyone",
+			})
+			return
+		}
+		if sender, err = a.messageSender(chat, original); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(DeleteMessageResponse{
+				Success: false,
+				Message: fmt.Sprintf("Cannot delete %s for everyone: %v", messageID, err),
+			})
+			return
+		}
+	}
+	if original != nil && original.IsFromMe && time.Since(original.Time) > messageRevokeWindow {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(DeleteMessageResponse{
+			Success: false,
+			Message: fmt.Sprintf("Messages can only be deleted for everyone within %d hours of sending, use scope=me instead", int(messageRevokeWindow.Hours())),
+		})
+		return
+	}
+
+	_, err = a.sendMessage(chat, a.Client.BuildRevoke(chat, sender, messageID))
+	if err != nil {
+		status := http.StatusBadGateway
+		if err == errNotConnected {
+			status = http.StatusServiceUnavailable
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(DeleteMessageResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to delete message for everyone: %v", err),
+		})
+		return
+	}
+
+	if original != nil {
+		if err := a.MessageStore.DeleteMessage(messageID, chat.String()); err != nil {
+			a.Logger.Warnf("Failed to delete local copy of revoked message: %v", err)
+		}
+	}
+	json.NewEncoder(w).Encode(DeleteMessageResponse{
+		Success: true,
+		Message: "Message deleted for everyone",
+		ID:      messageID,
+		Scope:   scope,
+	})
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Upper bound for a single object store request
+const s3RequestTimeout = 2 * time.Minute
+
+// S3Config selects the bucket media is kept in. Any S3 compatible store
+// works: AWS, MinIO, or GCS through its interoperability keys.
+type S3Config struct {
+	// For example https://s3.eu-west-1.amazonaws.com or http://minio:9000;
+	// defaults to AWS in Region
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prepended to every object key
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// Address buckets as endpoint/bucket instead of bucket.endpoint, as MinIO needs
+	PathStyle bool
+	// How long signed URLs stay valid
+	URLExpiry time.Duration
+}
+
+// S3MediaStorage keeps media files in an S3 compatible bucket. Locations are
+// object keys, and clients are handed signed URLs to fetch files directly.
+type S3MediaStorage struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// Create a storage driver for a bucket
+func NewS3MediaStorage(cfg S3Config) (*S3MediaStorage, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("an S3 bucket is required")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("S3 access and secret keys are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.URLExpiry <= 0 {
+		cfg.URLExpiry = 15 * time.Minute
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	return &S3MediaStorage{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: s3RequestTimeout},
+	}, nil
+}
+
+// Save implements MediaStorage
+func (s *S3MediaStorage) Save(key string, data []byte, mimeType string) (string, error) {
+	if s.cfg.Prefix != "" {
+		key = s.cfg.Prefix + "/" + key
+	}
+	header := http.Header{}
+	if mimeType != "" {
+		header.Set("Content-Type", mimeType)
+	}
+	resp, err := s.do(http.MethodPut, key, data, header)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return key, nil
+}
+
+// Exists implements MediaStorage
+func (s *S3MediaStorage) Exists(location string) bool {
+	resp, err := s.do(http.MethodHead, location, nil, nil)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// Read implements MediaStorage
+func (s *S3MediaStorage) Read(location string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, location, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Open implements MediaStorage. Objects are read into memory so they can be
+// served with range requests.
+func (s *S3MediaStorage) Open(location string) (io.ReadSeekCloser, error) {
+	data, err := s.Read(location)
+	if err != nil {
+		return nil, err
+	}
+	return nopSeekCloser{bytes.NewReader(data)}, nil
+}
+
+// Delete implements MediaStorage; deleting a missing object is not an error
+func (s *S3MediaStorage) Delete(location string) error {
+	resp, err := s.do(http.MethodDelete, location, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SignedURL implements MediaStorage with a presigned GET that also sets the
+// response headers, so the download keeps its type and file name
+func (s *S3MediaStorage) SignedURL(location, mimeType string) (string, error) {
+	query := url.Values{}
+	query.Set("response-content-disposition", mime.FormatMediaType("inline", map[string]string{"filename": path.Base(location)}))
+	if mimeType != "" {
+		query.Set("response-content-type", mimeType)
+	}
+	return s.presign(location, query, time.Now().UTC()), nil
+}
+
+// A bytes.Reader satisfying io.ReadSeekCloser
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+// Close implements io.Closer
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+// URL of an object, with its path already escaped the way it is signed
+func (s *S3MediaStorage) objectURL(key string) *url.URL {
+	u := &url.URL{Scheme: s.endpoint.Scheme, Host: s.endpoint.Host}
+	objectPath := "/" + key
+	if s.cfg.PathStyle {
+		objectPath = "/" + s.cfg.Bucket + objectPath
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	u.Path = objectPath
+	u.RawPath = s3Escape(objectPath, true)
+	return u
+}
+
+// Send a signed request for an object, failing on any non-2xx status except
+// a 404 on delete
+func (s *S3MediaStorage) do(method, key string, body []byte, header http.Header) (*http.Response, error) {
+	u := s.objectURL(key)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.sign(req, u, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s %s failed: %v", method, key, err)
+	}
+	if resp.StatusCode/100 == 2 || (method == http.MethodDelete && resp.StatusCode == http.StatusNotFound) {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return nil, fmt.Errorf("S3 %s %s failed: %s %s", method, key, resp.Status, bytes.TrimSpace(detail))
+}
+
+// Add AWS Signature Version 4 headers to a request
+func (s *S3MediaStorage) sign(req *http.Request, u *url.URL, payload []byte, now time.Time) {
+	payloadHash := sha256Hex(payload)
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + u.Host + "\n" + "x-amz-content-sha256:" + payloadHash + "\n" + "x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method, u.RawPath, "", canonicalHeaders, signedHeaders, payloadHash,
+	}, "\n")
+
+	scope, signature := s.signature(now, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+// Build a presigned GET URL for an object
+func (s *S3MediaStorage) presign(key string, query url.Values, now time.Time) string {
+	u := s.objectURL(key)
+	scope := s.scope(now)
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(s.cfg.URLExpiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalQuery := s3CanonicalQuery(query)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet, u.RawPath, canonicalQuery, "host:" + u.Host + "\n", "host", "UNSIGNED-PAYLOAD",
+	}, "\n")
+	_, signature := s.signature(now, canonicalRequest)
+	u.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+	return u.String()
+}
+
+// Credential scope of requests signed at a time
+func (s *S3MediaStorage) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// Sign a canonical request, returning the credential scope and signature
+func (s *S3MediaStorage) signature(now time.Time, canonicalRequest string) (string, string) {
+	scope := s.scope(now)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", now.Format("20060102T150405Z"), scope, sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return scope, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// Hex encoded SHA-256 of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// HMAC-SHA256 of a message
+func hmacSHA256(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// Percent-encode everything but unreserved characters, as SigV4 requires
+func s3Escape(value string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', keepSlash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Encode query parameters sorted by name, as SigV4 requires
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
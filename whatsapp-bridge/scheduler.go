@@ -0,0 +1,401 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// Scheduled message states
+const (
+	ScheduleStatusPending   = "pending"
+	ScheduleStatusSent      = "sent"
+	ScheduleStatusFailed    = "failed"
+	ScheduleStatusCancelled = "cancelled"
+)
+
+// How often the scheduler looks for due messages, and how it retries failed sends
+const (
+	schedulerInterval     = 5 * time.Second
+	maxScheduleAttempts   = 5
+	scheduleRetryBaseWait = 30 * time.Second
+)
+
+// ScheduleMessageRequest represents the request body for the schedule API
+type ScheduleMessageRequest struct {
+	SendTextRequest
+	SendAt time.Time `json:"send_at"`
+}
+
+// ScheduledMessage is a text message waiting to be sent, or the record of one
+type ScheduledMessage struct {
+	ID        string          `json:"id"`
+	Message   SendTextRequest `json:"message"`
+	SendAt    time.Time       `json:"send_at"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	MessageID string          `json:"message_id,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	SentAt    *time.Time      `json:"sent_at,omitempty"`
+}
+
+// ScheduleResponse represents the response for the single scheduled message APIs
+type ScheduleResponse struct {
+	Success   bool              `json:"success"`
+	Message   string            `json:"message"`
+	Scheduled *ScheduledMessage `json:"scheduled,omitempty"`
+}
+
+// Generate a random ID for jobs the bridge tracks itself
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Persist a new scheduled message
+func (store *MessageStore) CreateScheduledMessage(msg *ScheduledMessage) error {
+	payload, err := json.Marshal(msg.Message)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(
+		`INSERT INTO scheduled_messages (id, payload, send_at, next_attempt_at, status, attempts, created_at)
+		VALUES (?, ?, ?, ?, ?, 0, ?)`,
+		msg.ID, string(payload), msg.SendAt.UTC(), msg.SendAt.UTC(), msg.Status, msg.CreatedAt.UTC(),
+	)
+	return err
+}
+
+const scheduledMessageColumns = "id, payload, send_at, status, attempts, last_error, message_id, created_at, sent_at"
+
+// Scan a scheduled message row
+func scanScheduledMessage(row interface{ Scan(...interface{}) error }) (*ScheduledMessage, error) {
+	var msg ScheduledMessage
+	var payload string
+	var lastError, messageID sql.NullString
+	var sentAt sql.NullTime
+	err := row.Scan(&msg.ID, &payload, &msg.SendAt, &msg.Status, &msg.Attempts, &lastError, &messageID, &msg.CreatedAt, &sentAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(payload), &msg.Message); err != nil {
+		return nil, err
+	}
+	msg.LastError = lastError.String
+	msg.MessageID = messageID.String
+	if sentAt.Valid {
+		msg.SentAt = &sentAt.Time
+	}
+	return &msg, nil
+}
+
+// Get a scheduled message by ID
+func (store *MessageStore) GetScheduledMessage(id string) (*ScheduledMessage, error) {
+	row := store.db.QueryRow("SELECT "+scheduledMessageColumns+" FROM scheduled_messages WHERE id = ?", id)
+	return scanScheduledMessage(row)
+}
+
+// List scheduled messages by send time, optionally filtered by status
+func (store *MessageStore) ListScheduledMessages(status string) ([]*ScheduledMessage, error) {
+	query := "SELECT " + scheduledMessageColumns + " FROM scheduled_messages"
+	var args []interface{}
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY send_at"
+	return store.queryScheduledMessages(query, args...)
+}
+
+// Pending scheduled messages whose next attempt is due
+func (store *MessageStore) DueScheduledMessages(now time.Time) ([]*ScheduledMessage, error) {
+	return store.queryScheduledMessages(
+		"SELECT "+scheduledMessageColumns+" FROM scheduled_messages WHERE status = ? AND next_attempt_at <= ? ORDER BY send_at",
+		ScheduleStatusPending, now.UTC(),
+	)
+}
+
+// Run a query returning scheduled messages
+func (store *MessageStore) queryScheduledMessages(query string, args ...interface{}) ([]*ScheduledMessage, error) {
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*ScheduledMessage{}
+	for rows.Next() {
+		msg, err := scanScheduledMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// Cancel a scheduled message; only pending messages can be cancelled
+func (store *MessageStore) CancelScheduledMessage(id string) (bool, error) {
+	result, err := store.db.Exec(
+		"UPDATE scheduled_messages SET status = ? WHERE id = ? AND status = ?",
+		ScheduleStatusCancelled, id, ScheduleStatusPending,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Record a successful send
+func (store *MessageStore) MarkScheduledSent(id, messageID string, sentAt time.Time) error {
+	_, err := store.db.Exec(
+		"UPDATE scheduled_messages SET status = ?, attempts = attempts + 1, message_id = ?, sent_at = ?, last_error = NULL WHERE id = ?",
+		ScheduleStatusSent, messageID, sentAt.UTC(), id,
+	)
+	return err
+}
+
+// Record a failed attempt, either retrying later or giving up
+func (store *MessageStore) MarkScheduledAttemptFailed(id string, sendErr error, retryAt *time.Time) error {
+	if retryAt == nil {
+		_, err := store.db.Exec(
+			"UPDATE scheduled_messages SET status = ?, attempts = attempts + 1, last_error = ? WHERE id = ?",
+			ScheduleStatusFailed, sendErr.Error(), id,
+		)
+		return err
+	}
+	_, err := store.db.Exec(
+		"UPDATE scheduled_messages SET attempts = attempts + 1, last_error = ?, next_attempt_at = ? WHERE id = ?",
+		sendErr.Error(), retryAt.UTC(), id,
+	)
+	return err
+}
+
+// Scheduler sends an account's scheduled messages when they fall due
+type Scheduler struct {
+	account *Account
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Create a scheduler for an account
+func NewScheduler(account *Account) *Scheduler {
+	return &Scheduler{
+		account: account,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Run checks for due messages until Stop is called
+func (s *Scheduler) Run() {
+	defer close(s.done)
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		s.dispatchDue()
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Stop the scheduler and wait for an in-flight dispatch to finish
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+// Send every due message. While disconnected nothing is attempted, so pending
+// messages go out as soon as the connection returns.
+func (s *Scheduler) dispatchDue() {
+	a := s.account
+	if !a.Client.IsConnected() || a.ReadOnly || draining.Load() {
+		return
+	}
+
+	due, err := a.MessageStore.DueScheduledMessages(time.Now())
+	if err != nil {
+		a.Logger.Warnf("Failed to load scheduled messages: %v", err)
+		return
+	}
+	for _, scheduled := range due {
+		s.send(scheduled)
+	}
+}
+
+// Send a single scheduled message and record the outcome
+func (s *Scheduler) send(scheduled *ScheduledMessage) {
+	a := s.account
+	to, err := scheduled.Message.validate()
+	if err == nil {
+		var sent whatsmeow.SendResponse
+		sent, err = a.sendMessage(to, a.textMessage(to, &scheduled.Message))
+		if err == nil {
+			if err := a.MessageStore.MarkScheduledSent(scheduled.ID, sent.ID, sent.Timestamp); err != nil {
+				a.Logger.Warnf("Failed to update scheduled message %s: %v", scheduled.ID, err)
+			}
+			a.Logger.Infof("Sent scheduled message %s to %s", scheduled.ID, to)
+			a.Notifier.Notify(a.ID, WebhookEventScheduledSent, map[string]interface{}{
+				"scheduled_id": scheduled.ID,
+				"message_id":   sent.ID,
+				"chat_jid":     to.String(),
+			})
+			return
+		}
+	}
+
+	// Disconnects are retried on the next tick without using up an attempt
+	if isDisconnectError(err) {
+		return
+	}
+
+	// Sending again can't change the content policy's mind
+	var retryAt *time.Time
+	var policyErr *PolicyError
+	if scheduled.Attempts+1 < maxScheduleAttempts && !errors.As(err, &policyErr) {
+		next := time.Now().Add(scheduleRetryBaseWait << scheduled.Attempts)
+		retryAt = &next
+	}
+	if err := a.MessageStore.MarkScheduledAttemptFailed(scheduled.ID, err, retryAt); err != nil {
+		a.Logger.Warnf("Failed to update scheduled message %s: %v", scheduled.ID, err)
+	}
+	if retryAt != nil {
+		a.Logger.Warnf("Scheduled message %s failed, retrying at %s: %v", scheduled.ID, retryAt.Format(time.RFC3339), err)
+		return
+	}
+	a.Logger.Errorf("Scheduled message %s failed after %d attempts: %v", scheduled.ID, maxScheduleAttempts, err)
+	a.Notifier.Notify(a.ID, WebhookEventScheduledFailed, map[string]interface{}{
+		"scheduled_id": scheduled.ID,
+		"chat_jid":     scheduled.Message.Recipient,
+		"error":        err.Error(),
+	})
+}
+
+// Handle /api/messages/schedule: POST schedules a text message, GET lists them
+func (a *Account) HandleScheduleEndpoint(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		messages, err := a.MessageStore.ListScheduledMessages(r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list scheduled messages: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messages)
+
+	case http.MethodPost:
+		// Parse the request body
+		var req ScheduleMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		// Validate request
+		to, err := req.validate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.SendAt.IsZero() {
+			http.Error(w, "send_at is required", http.StatusBadRequest)
+			return
+		}
+
+		req.Recipient = to.String()
+		scheduled := &ScheduledMessage{
+			ID:        newJobID(),
+			Message:   req.SendTextRequest,
+			SendAt:    req.SendAt,
+			Status:    ScheduleStatusPending,
+			CreatedAt: time.Now(),
+		}
+		if err := a.MessageStore.CreateScheduledMessage(scheduled); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to schedule message: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ScheduleResponse{
+			Success:   true,
+			Message:   fmt.Sprintf("Message to %s scheduled for %s", to, req.SendAt.Format(time.RFC3339)),
+			Scheduled: scheduled,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Handle /api/scheduled/{id}: GET returns a scheduled message, DELETE cancels it
+func (a *Account) HandleScheduledMessageEndpoint(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		scheduled, err := a.MessageStore.GetScheduledMessage(id)
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ScheduleResponse{Success: false, Message: fmt.Sprintf("Scheduled message %s not found", id)})
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ScheduleResponse{Success: false, Message: fmt.Sprintf("Failed to load scheduled message: %v", err)})
+			return
+		}
+		json.NewEncoder(w).Encode(ScheduleResponse{Success: true, Message: "OK", Scheduled: scheduled})
+
+	case http.MethodDelete:
+		scheduled, err := a.MessageStore.GetScheduledMessage(id)
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ScheduleResponse{Success: false, Message: fmt.Sprintf("Scheduled message %s not found", id)})
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ScheduleResponse{Success: false, Message: fmt.Sprintf("Failed to load scheduled message: %v", err)})
+			return
+		}
+
+		cancelled, err := a.MessageStore.CancelScheduledMessage(id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ScheduleResponse{Success: false, Message: fmt.Sprintf("Failed to cancel scheduled message: %v", err)})
+			return
+		}
+		if !cancelled {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(ScheduleResponse{
+				Success:   false,
+				Message:   fmt.Sprintf("Scheduled message %s is already %s", id, scheduled.Status),
+				Scheduled: scheduled,
+			})
+			return
+		}
+		scheduled.Status = ScheduleStatusCancelled
+		json.NewEncoder(w).Encode(ScheduleResponse{
+			Success:   true,
+			Message:   fmt.Sprintf("Scheduled message %s cancelled", id),
+			Scheduled: scheduled,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits for message search results
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 200
+)
+
+// SearchResult is a message matching a search
+type SearchResult struct {
+	ID        string    `json:"id"`
+	ChatJID   string    `json:"chat_jid"`
+	ChatName  string    `json:"chat_name,omitempty"`
+	Sender    string    `json:"sender"`
+	PushName  string    `json:"push_name,omitempty"`
+	Content   string    `json:"content,omitempty"`
+	Caption   string    `json:"caption,omitempty"`
+	Snippet   string    `json:"snippet"`
+	Timestamp time.Time `json:"timestamp"`
+	IsFromMe  bool      `json:"is_from_me"`
+	MediaType string    `json:"media_type,omitempty"`
+	// bm25 score, lower is a better match; zero without full-text search
+	Rank float64 `json:"rank"`
+}
+
+// SearchResponse represents the response for the message search API
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Ranked  bool           `json:"ranked"`
+	Results []SearchResult `json:"results"`
+}
+
+// SearchOptions narrows a message search
+type SearchOptions struct {
+	ChatJID string
+	Sender  string
+	After   time.Time
+	Before  time.Time
+	Limit   int
+	Offset  int
+}
+
+// Create the FTS5 index over message bodies and captions, kept in sync by
+// triggers. SQLite has to be built with FTS5 (go build -tags sqlite_fts5);
+// without it search falls back to unranked substring matching.
+func setupSearchIndex(db *DB) (bool, error) {
+	var available bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&available); err != nil {
+		return false, err
+	}
+
+	// The triggers go missing when a build without FTS5 drops them, and then
+	// the index is stale
+	var synced bool
+	err := db.QueryRow("SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'trigger' AND name = 'messages_fts_insert'").Scan(&synced)
+	if err != nil {
+		return false, err
+	}
+
+	if !available {
+		// Triggers on an index this build can't open would make every insert fail
+		_, err := db.Exec(`
+			DROP TRIGGER IF EXISTS messages_fts_insert;
+			DROP TRIGGER IF EXISTS messages_fts_delete;
+			DROP TRIGGER IF EXISTS messages_fts_update;
+		`)
+		return false, err
+	}
+
+	_, err = db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+		content, caption, content='messages', content_rowid='rowid', tokenize='unicode61 remove_diacritics 2'
+	)`)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts (rowid, content, caption) VALUES (new.rowid, new.content, new.caption);
+		END;
+		CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, content, caption) VALUES ('delete', old.rowid, old.content, old.caption);
+		END;
+		CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content, caption ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, content, caption) VALUES ('delete', old.rowid, old.content, old.caption);
+			INSERT INTO messages_fts (rowid, content, caption) VALUES (new.rowid, new.content, new.caption);
+		END;
+	`)
+	if err != nil {
+		return false, err
+	}
+
+	// Index the messages stored before search existed or while it was unavailable
+	if !synced {
+		if _, err := db.Exec("INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')"); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Turn free text into an FTS5 query matching every word, so punctuation in
+// the input can't cause syntax errors. A trailing * keeps prefix matching.
+func ftsQuery(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.TrimRight(word, "*")
+		if word == "" {
+			continue
+		}
+		term := `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+// Parse a time filter given as RFC 3339 or a date
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 time or YYYY-MM-DD date, got %q", value)
+	}
+	return t, nil
+}
+
+// Search message bodies and captions, best matches first
+func (store *MessageStore) SearchMessages(text string, opts SearchOptions) ([]SearchResult, error) {
+	var query strings.Builder
+	var args []interface{}
+
+	if store.searchIndexed {
+		query.WriteString(`SELECT m.id, m.chat_jid, COALESCE(c.name, ''), m.sender, COALESCE(m.push_name, ''),
+			COALESCE(m.content, ''), COALESCE(m.caption, ''), snippet(messages_fts, -1, '[', ']', '…', 12),
+			m.timestamp, m.is_from_me, COALESCE(m.media_type, ''), bm25(messages_fts)
+			FROM messages_fts JOIN messages m ON m.rowid = messages_fts.rowid
+			LEFT JOIN chats c ON c.jid = m.chat_jid
+			WHERE messages_fts MATCH ?`)
+		args = append(args, ftsQuery(text))
+	} else {
+		query.WriteString(`SELECT m.id, m.chat_jid, COALESCE(c.name, ''), m.sender, COALESCE(m.push_name, ''),
+			COALESCE(m.content, ''), COALESCE(m.caption, ''), COALESCE(NULLIF(m.content, ''), m.caption, ''),
+			m.timestamp, m.is_from_me, COALESCE(m.media_type, ''), 0
+			FROM messages m LEFT JOIN chats c ON c.jid = m.chat_jid
+			WHERE (m.content LIKE ? OR m.caption LIKE ?)`)
+		pattern := "%" + text + "%"
+		args = append(args, pattern, pattern)
+	}
+
+	if opts.ChatJID != "" {
+		query.WriteString(" AND m.chat_jid = ?")
+		args = append(args, opts.ChatJID)
+	}
+	if opts.Sender != "" {
+		query.WriteString(" AND m.sender = ?")
+		args = append(args, opts.Sender)
+	}
+	// Timestamps are stored in local time, so compare in local time too
+	if !opts.After.IsZero() {
+		query.WriteString(" AND m.timestamp >= ?")
+		args = append(args, opts.After.Local())
+	}
+	if !opts.Before.IsZero() {
+		query.WriteString(" AND m.timestamp < ?")
+		args = append(args, opts.Before.Local())
+	}
+
+	if store.searchIndexed {
+		query.WriteString(" ORDER BY bm25(messages_fts), m.timestamp DESC")
+	} else {
+		query.WriteString(" ORDER BY m.timestamp DESC")
+	}
+	query.WriteString(" LIMIT ? OFFSET ?")
+	args = append(args, opts.Limit, opts.Offset)
+
+	rows, err := store.db.Query(query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		err := rows.Scan(&result.ID, &result.ChatJID, &result.ChatName, &result.Sender, &result.PushName,
+			&result.Content, &result.Caption, &result.Snippet, &result.Timestamp, &result.IsFromMe, &result.MediaType, &result.Rank)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// Handle GET /api/messages/search
+func (a *Account) HandleSearchEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	text := strings.TrimSpace(query.Get("q"))
+	if ftsQuery(text) == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	opts := SearchOptions{Sender: strings.TrimPrefix(query.Get("sender"), "+"), Limit: defaultSearchLimit}
+	if chat := query.Get("chat_jid"); chat != "" {
+		jid, err := parseRecipient(chat)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.ChatJID = jid.String()
+	}
+	for param, target := range map[string]*time.Time{"after": &opts.After, "before": &opts.Before} {
+		if value := query.Get(param); value != "" {
+			t, err := parseTimeParam(value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s: %v", param, err), http.StatusBadRequest)
+				return
+			}
+			*target = t
+		}
+	}
+	for param, target := range map[string]*int{"limit": &opts.Limit, "offset": &opts.Offset} {
+		if value := query.Get(param); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("%s must be a non-negative number", param), http.StatusBadRequest)
+				return
+			}
+			*target = n
+		}
+	}
+	if opts.Limit == 0 {
+		opts.Limit = defaultSearchLimit
+	} else if opts.Limit > maxSearchLimit {
+		opts.Limit = maxSearchLimit
+	}
+
+	results, err := a.MessageStore.SearchMessages(text, opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to search messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SearchResponse{Query: text, Ranked: a.MessageStore.searchIndexed, Results: results})
+}
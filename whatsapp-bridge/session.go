@@ -0,0 +1,660 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Connection states of a session
+const (
+	ConnStateDisconnected = "disconnected"
+	ConnStateConnecting   = "connecting"
+	ConnStatePairing      = "pairing"
+	ConnStateConnected    = "connected"
+	ConnStateReconnecting = "reconnecting"
+	ConnStateLoggedOut    = "logged_out"
+	ConnStateBanned       = "banned"
+	ConnStateRateLimited  = "rate_limited"
+)
+
+// Allowed transitions of the connection state machine
+var connStateTransitions = map[string][]string{
+	ConnStateDisconnected: {ConnStateConnecting, ConnStatePairing, ConnStateLoggedOut},
+	ConnStateConnecting:   {ConnStateConnected, ConnStateReconnecting, ConnStateBanned, ConnStateRateLimited, ConnStateLoggedOut, ConnStateDisconnected},
+	ConnStatePairing:      {ConnStateConnected, ConnStateReconnecting, ConnStateLoggedOut, ConnStateDisconnected},
+	ConnStateConnected:    {ConnStateReconnecting, ConnStateBanned, ConnStateRateLimited, ConnStateLoggedOut, ConnStateDisconnected},
+	ConnStateReconnecting: {ConnStateConnecting, ConnStateConnected, ConnStateLoggedOut, ConnStateDisconnected},
+	ConnStateLoggedOut:    {ConnStateConnecting, ConnStatePairing, ConnStateDisconnected},
+	ConnStateBanned:       {ConnStateConnecting, ConnStateLoggedOut, ConnStateDisconnected},
+	ConnStateRateLimited:  {ConnStateConnecting, ConnStateLoggedOut, ConnStateDisconnected},
+}
+
+// Reconnect backoff parameters
+const (
+	reconnectBaseDelay = 2 * time.Second
+	reconnectMaxDelay  = 5 * time.Minute
+	rateLimitDelay     = time.Minute
+)
+
+// Connect failure reason WhatsApp uses when too many connection attempts are made
+const connectFailureRateLimited events.ConnectFailureReason = 429
+
+// SessionManager drives the pairing and connection lifecycle of the WhatsApp client.
+// It owns the QR event stream so a re-authentication can restart pairing without restarting the bridge,
+// and it replaces whatsmeow's auto-reconnect with exponential backoff.
+type SessionManager struct {
+	accountID string
+	client    *whatsmeow.Client
+	qrManager *QRManager
+	notifier  *WebhookNotifier
+	logger    waLog.Logger
+	// Terminal QR rendering mode, empty for headless operation
+	terminalQR string
+
+	mu                sync.Mutex
+	state             string
+	stateSince        time.Time
+	lastError         string
+	reconnectAttempts int
+	nextRetryAt       time.Time
+	reconnecting      bool
+	pairedAt          time.Time
+	connectedAt       time.Time
+	disconnectedAt    time.Time
+	cancelReconnect   context.CancelFunc
+	cancelPairing     context.CancelFunc
+	reauthCh          chan struct{}
+	stopCh            chan struct{}
+	stopOnce          sync.Once
+}
+
+// SessionStatus represents the response for the status API
+type SessionStatus struct {
+	State             string     `json:"state"`
+	Connected         bool       `json:"connected"`
+	LoggedIn          bool       `json:"logged_in"`
+	JID               string     `json:"jid,omitempty"`
+	PairingState      string     `json:"pairing_state"`
+	Since             time.Time  `json:"since"`
+	ReconnectAttempts int        `json:"reconnect_attempts"`
+	NextRetryAt       *time.Time `json:"next_retry_at,omitempty"`
+	LastError         string     `json:"last_error,omitempty"`
+}
+
+// ReauthResponse represents the response for the re-authentication and logout APIs
+type ReauthResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	State   string `json:"state"`
+}
+
+// Create a session manager for the given client
+func NewSessionManager(accountID string, client *whatsmeow.Client, qrManager *QRManager, notifier *WebhookNotifier, terminalQR string, logger waLog.Logger) *SessionManager {
+	s := &SessionManager{
+		accountID:  accountID,
+		client:     client,
+		qrManager:  qrManager,
+		notifier:   notifier,
+		logger:     logger,
+		terminalQR: terminalQR,
+		state:      ConnStateDisconnected,
+		stateSince: time.Now(),
+		reauthCh:   make(chan struct{}, 1),
+		stopCh:     make(chan struct{}),
+	}
+
+	// Reconnects are handled by the state machine
+	client.EnableAutoReconnect = false
+	client.AddEventHandler(s.handleEvent)
+	return s
+}
+
+// State returns the current connection state
+func (s *SessionManager) State() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Status returns a snapshot of the connection state machine
+func (s *SessionManager) Status() SessionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := SessionStatus{
+		State:             s.state,
+		Connected:         s.client.IsConnected(),
+		LoggedIn:          s.client.IsLoggedIn(),
+		PairingState:      s.qrManager.Status().State,
+		Since:             s.stateSince,
+		ReconnectAttempts: s.reconnectAttempts,
+		LastError:         s.lastError,
+	}
+	if s.client.Store.ID != nil {
+		status.JID = s.client.Store.ID.String()
+	}
+	if s.reconnecting && !s.nextRetryAt.IsZero() {
+		nextRetryAt := s.nextRetryAt
+		status.NextRetryAt = &nextRetryAt
+	}
+	return status
+}
+
+// DeviceInfo represents the response for the device API
+type DeviceInfo struct {
+	Paired         bool       `json:"paired"`
+	JID            string     `json:"jid,omitempty"`
+	PhoneNumber    string     `json:"phone_number,omitempty"`
+	DeviceID       uint16     `json:"device_id,omitempty"`
+	PushName       string     `json:"push_name,omitempty"`
+	Platform       string     `json:"platform,omitempty"`
+	BusinessName   string     `json:"business_name,omitempty"`
+	Connected      bool       `json:"connected"`
+	State          string     `json:"state"`
+	PairedAt       *time.Time `json:"paired_at,omitempty"`
+	ConnectedAt    *time.Time `json:"connected_at,omitempty"`
+	DisconnectedAt *time.Time `json:"disconnected_at,omitempty"`
+}
+
+// Return a pointer to t, or nil for the zero time so it is omitted from JSON
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Device returns metadata about the linked device
+func (s *SessionManager) Device() DeviceInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store := s.client.Store
+	info := DeviceInfo{
+		Paired:         store.ID != nil,
+		Connected:      s.client.IsConnected(),
+		State:          s.state,
+		PairedAt:       optionalTime(s.pairedAt),
+		ConnectedAt:    optionalTime(s.connectedAt),
+		DisconnectedAt: optionalTime(s.disconnectedAt),
+	}
+	if store.ID != nil {
+		info.JID = store.ID.String()
+		info.PhoneNumber = store.ID.User
+		info.DeviceID = store.ID.Device
+		info.PushName = store.PushName
+		info.Platform = store.Platform
+		info.BusinessName = store.BusinessName
+	}
+	return info
+}
+
+// HandleDeviceEndpoint reports which number and device is linked
+func (s *SessionManager) HandleDeviceEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Device())
+}
+
+// Move the state machine to a new state, ignoring transitions that are not allowed
+func (s *SessionManager) setState(state, reason string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == state {
+		return true
+	}
+	allowed := false
+	for _, next := range connStateTransitions[s.state] {
+		if next == state {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		s.logger.Warnf("Ignoring invalid connection state transition %s -> %s", s.state, state)
+		return false
+	}
+	if reason != "" {
+		s.logger.Infof("Connection state %s -> %s (%s)", s.state, state, reason)
+		s.lastError = reason
+	} else {
+		s.logger.Infof("Connection state %s -> %s", s.state, state)
+	}
+	s.state = state
+	s.stateSince = time.Now()
+	return true
+}
+
+// React to connection lifecycle events from whatsmeow
+func (s *SessionManager) handleEvent(evt interface{}) {
+	switch v := evt.(type) {
+	case *events.Connected:
+		s.mu.Lock()
+		s.reconnectAttempts = 0
+		s.lastError = ""
+		s.connectedAt = time.Now()
+		s.mu.Unlock()
+		s.setState(ConnStateConnected, "")
+		s.qrManager.SetState(QRStateConnected)
+
+	case *events.Disconnected:
+		// Unexpected drop (also sent by the server right after a successful pairing)
+		s.mu.Lock()
+		s.disconnectedAt = time.Now()
+		s.mu.Unlock()
+		s.setState(ConnStateReconnecting, "connection lost")
+		s.scheduleReconnect(0)
+
+	case *events.PairSuccess:
+		s.mu.Lock()
+		s.pairedAt = time.Now()
+		s.mu.Unlock()
+		s.notifier.Notify(s.accountID, WebhookEventPaired, map[string]interface{}{
+			"jid":           v.ID.String(),
+			"business_name": v.BusinessName,
+			"platform":      v.Platform,
+		})
+
+	case *events.LoggedOut:
+		s.stopReconnecting()
+		s.setState(ConnStateLoggedOut, fmt.Sprintf("logged out: %s", v.Reason))
+		s.qrManager.SetState(QRStateDisconnected)
+		s.notifier.Notify(s.accountID, WebhookEventLoggedOut, map[string]interface{}{
+			"reason":     v.Reason.String(),
+			"on_connect": v.OnConnect,
+		})
+
+	case *events.TemporaryBan:
+		s.setState(ConnStateBanned, v.String())
+		s.scheduleReconnect(v.Expire)
+
+	case *events.ConnectFailure:
+		if v.Reason == connectFailureRateLimited {
+			s.setState(ConnStateRateLimited, "rate limited by WhatsApp")
+			s.scheduleReconnect(rateLimitDelay)
+		} else {
+			s.setState(ConnStateDisconnected, fmt.Sprintf("connect failure %d: %s", int(v.Reason), v.Message))
+		}
+
+	case *events.StreamReplaced:
+		s.stopReconnecting()
+		s.setState(ConnStateDisconnected, "stream replaced by another client")
+		s.notifier.Notify(s.accountID, WebhookEventStreamReplaced, nil)
+	}
+}
+
+// Exponential backoff with +/-25% jitter. The first attempt is immediate so
+// plain network blips and the post-pairing reconnect are handled quickly.
+func reconnectDelay(attempt int) time.Duration {
+	if attempt == 0 {
+		return 0
+	}
+	delay := reconnectBaseDelay << min(attempt-1, 10)
+	if delay > reconnectMaxDelay {
+		delay = reconnectMaxDelay
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/2)) - delay/4
+	return delay + jitter
+}
+
+// Start the reconnect loop unless it is already running. minDelay holds off
+// the first attempt, e.g. until a temporary ban expires.
+func (s *SessionManager) scheduleReconnect(minDelay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reconnecting || s.client.Store.ID == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.reconnecting = true
+	s.cancelReconnect = cancel
+	go s.reconnectLoop(ctx, minDelay)
+}
+
+// Abort a running reconnect loop
+func (s *SessionManager) stopReconnecting() {
+	s.mu.Lock()
+	cancel := s.cancelReconnect
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// Keep trying to connect with backoff until a connection attempt succeeds
+func (s *SessionManager) reconnectLoop(ctx context.Context, minDelay time.Duration) {
+	defer func() {
+		s.mu.Lock()
+		s.reconnecting = false
+		s.cancelReconnect = nil
+		s.nextRetryAt = time.Time{}
+		s.mu.Unlock()
+	}()
+
+	for {
+		s.mu.Lock()
+		attempt := s.reconnectAttempts
+		s.reconnectAttempts++
+		delay := reconnectDelay(attempt)
+		if delay < minDelay {
+			delay = minDelay
+		}
+		minDelay = 0
+		s.nextRetryAt = time.Now().Add(delay)
+		s.mu.Unlock()
+
+		if delay > 0 {
+			s.logger.Infof("Reconnecting in %v (attempt %d)", delay.Round(time.Second), attempt+1)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		case <-s.stopCh:
+			return
+		}
+
+		if s.client.Store.ID == nil || s.client.IsConnected() {
+			return
+		}
+
+		s.setState(ConnStateConnecting, "")
+		bridgeMetrics.Reconnects.Inc(s.accountID)
+		err := s.client.Connect()
+		if err == nil || errors.Is(err, whatsmeow.ErrAlreadyConnected) {
+			// The Connected event completes the transition
+			return
+		}
+		s.logger.Warnf("Reconnect attempt %d failed: %v", attempt+1, err)
+		s.setState(ConnStateReconnecting, err.Error())
+	}
+}
+
+// HandleStatusEndpoint reports the connection state machine
+func (s *SessionManager) HandleStatusEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Status())
+}
+
+// Run connects the client (pairing first if there is no stored session) and
+// starts over whenever a re-authentication is requested. It returns once Stop is called.
+func (s *SessionManager) Run() {
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+
+		if err := s.connect(); err != nil {
+			s.logger.Errorf("Failed to connect: %v", err)
+			if s.client.Store.ID != nil {
+				// Logged in but the network is down; keep retrying in the background
+				s.setState(ConnStateReconnecting, err.Error())
+				s.scheduleReconnect(0)
+			} else {
+				s.setState(ConnStateDisconnected, err.Error())
+				if s.qrManager.Status().State != QRStateTimeout {
+					s.qrManager.SetState(QRStateDisconnected)
+				}
+			}
+		}
+
+		// Wait until someone asks for a fresh session
+		select {
+		case <-s.reauthCh:
+			s.logger.Infof("Re-authentication requested, restarting pairing")
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// Stop ends the run loop and aborts any pairing in progress
+func (s *SessionManager) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+	s.stopReconnecting()
+	s.mu.Lock()
+	cancel := s.cancelPairing
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// Connect to WhatsApp, pairing with a phone first if the device store has no session
+func (s *SessionManager) connect() error {
+	if s.client.Store.ID != nil {
+		// Already logged in, just connect
+		s.setState(ConnStateConnecting, "")
+		if err := s.client.Connect(); err != nil {
+			return err
+		}
+		return s.waitForStableConnection()
+	}
+
+	s.setState(ConnStatePairing, "")
+
+	// No ID stored, this is a new client, need to pair with phone
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancelPairing = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.cancelPairing = nil
+		s.mu.Unlock()
+		cancel()
+	}()
+
+	qrChan, err := s.client.GetQRChannel(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get QR channel: %v", err)
+	}
+	if err := s.client.Connect(); err != nil {
+		return err
+	}
+
+	// Print QR codes for pairing with phone until pairing finishes one way or another
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("pairing cancelled")
+		case evt, ok := <-qrChan:
+			if !ok {
+				return fmt.Errorf("QR channel closed before pairing completed")
+			}
+			switch evt.Event {
+			case whatsmeow.QRChannelEventCode:
+				s.qrManager.SetCode(evt.Code)
+				s.notifier.Notify(s.accountID, WebhookEventQRGenerated, map[string]interface{}{
+					"qr_string":  evt.Code,
+					"expires_in": evt.Timeout.Seconds(),
+				})
+				if s.terminalQR != "" {
+					fmt.Println("\nScan this QR code with your WhatsApp app:")
+					printTerminalQR(evt.Code, s.terminalQR)
+				} else {
+					s.logger.Infof("New QR code available at /qr.html or /api/qr")
+				}
+			case whatsmeow.QRChannelSuccess.Event:
+				s.logger.Infof("Successfully connected and authenticated")
+				return s.waitForStableConnection()
+			case whatsmeow.QRChannelTimeout.Event:
+				s.qrManager.SetState(QRStateTimeout)
+				return fmt.Errorf("timeout waiting for QR code scan")
+			default:
+				return fmt.Errorf("pairing failed: %s %v", evt.Event, evt.Error)
+			}
+		}
+	}
+}
+
+// Wait for the connection to be established (after pairing the server drops
+// the socket once and the client logs in again) and record the connected state
+func (s *SessionManager) waitForStableConnection() error {
+	deadline := time.Now().Add(30 * time.Second)
+	for s.State() != ConnStateConnected || !s.client.IsConnected() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("failed to establish stable connection")
+		}
+		select {
+		case <-s.stopCh:
+			return fmt.Errorf("session stopped")
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+	s.qrManager.SetState(QRStateConnected)
+
+	s.logger.Infof("Connected to WhatsApp")
+	return nil
+}
+
+// Reauth logs out the current session, clears the device state and restarts the QR event stream
+func (s *SessionManager) Reauth() error {
+	s.stopReconnecting()
+
+	// Abort any pairing attempt that is still in progress
+	s.mu.Lock()
+	cancel := s.cancelPairing
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+
+	if s.client.Store.ID != nil {
+		if err := s.client.Logout(); err != nil {
+			// The server may not be reachable, but the local session must go regardless
+			s.logger.Warnf("Logout request failed, clearing local session anyway: %v", err)
+			s.client.Disconnect()
+			if err := s.client.Store.Delete(); err != nil {
+				return fmt.Errorf("failed to clear device store: %v", err)
+			}
+		}
+	} else {
+		s.client.Disconnect()
+	}
+
+	s.setState(ConnStateDisconnected, "re-authentication requested")
+	s.qrManager.SetState(QRStateDisconnected)
+
+	// Wake up the run loop; a pending request is as good as a new one
+	select {
+	case s.reauthCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Logout unlinks the device from the phone and wipes the device store row, leaving
+// the session disconnected until a re-authentication is requested. With force, the
+// local session is cleared even if WhatsApp cannot be reached.
+func (s *SessionManager) Logout(force bool) error {
+	s.stopReconnecting()
+
+	s.mu.Lock()
+	cancel := s.cancelPairing
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+
+	if s.client.Store.ID == nil {
+		s.client.Disconnect()
+		s.qrManager.SetState(QRStateDisconnected)
+		return whatsmeow.ErrNotLoggedIn
+	}
+
+	if err := s.client.Logout(); err != nil {
+		if !force {
+			return err
+		}
+		s.logger.Warnf("Logout request failed, clearing local session anyway: %v", err)
+		s.client.Disconnect()
+		if err := s.client.Store.Delete(); err != nil {
+			return fmt.Errorf("failed to clear device store: %v", err)
+		}
+	}
+
+	s.setState(ConnStateLoggedOut, "logged out via API")
+	s.qrManager.SetState(QRStateDisconnected)
+	s.notifier.Notify(s.accountID, WebhookEventLoggedOut, map[string]interface{}{
+		"reason": "api",
+	})
+	return nil
+}
+
+// HandleSessionEndpoint unlinks the device on DELETE (?force=true clears local data even if the logout request fails)
+func (s *SessionManager) HandleSessionEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	force := r.URL.Query().Get("force") == "true"
+	if err := s.Logout(force); err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, whatsmeow.ErrNotLoggedIn) {
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ReauthResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to log out: %v", err),
+			State:   s.qrManager.Status().State,
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(ReauthResponse{
+		Success: true,
+		Message: "Device unlinked, call /api/reauth to pair again",
+		State:   s.qrManager.Status().State,
+	})
+}
+
+// HandleReauthEndpoint drops the current session and starts pairing again.
+// The new QR code shows up on /api/qr as soon as WhatsApp issues it.
+func (s *SessionManager) HandleReauthEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := s.Reauth(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ReauthResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to re-authenticate: %v", err),
+			State:   s.qrManager.Status().State,
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(ReauthResponse{
+		Success: true,
+		Message: "Session cleared, a new QR code will be available at /api/qr shortly",
+		State:   s.qrManager.Status().State,
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Driver used for every database; switched to sqliteCipherDriver once a
+// database key is configured
+var sqliteDriver = "sqlite3"
+
+// Driver that unlocks each connection with the database key
+const sqliteCipherDriver = "sqlite3_sqlcipher"
+
+// Database key applied to every connection, empty when unencrypted
+var databaseKey string
+
+// Encrypt every database with the key from --db-key or --db-key-file. This
+// needs a bridge linked against SQLCipher instead of the bundled SQLite, which
+// is checked here so a plain build never silently writes unencrypted data.
+func configureDatabaseEncryption(cfg *Config) error {
+	key := cfg.DBKey
+	if cfg.DBKeyFile != "" {
+		if key != "" {
+			return fmt.Errorf("--db-key and --db-key-file can't both be set")
+		}
+		data, err := os.ReadFile(cfg.DBKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read --db-key-file: %v", err)
+		}
+		key = strings.TrimRight(string(data), "\r\n")
+	}
+	if key == "" {
+		return nil
+	}
+
+	sql.Register(sqliteCipherDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// Must be the first statement on a connection
+			_, err := conn.Exec(fmt.Sprintf("PRAGMA key = %s", sqliteQuote(key)), nil)
+			return err
+		},
+	})
+
+	db, err := sql.Open(sqliteCipherDriver, ":memory:")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// SQLite without the codec ignores PRAGMA key, but only SQLCipher knows cipher_version
+	var version string
+	if err := db.QueryRow("PRAGMA cipher_version").Scan(&version); err != nil || version == "" {
+		return fmt.Errorf("database encryption needs a bridge built against SQLCipher (go build -tags libsqlite3 with SQLCipher as the system SQLite)")
+	}
+
+	sqliteDriver = sqliteCipherDriver
+	databaseKey = key
+	return nil
+}
+
+// Quote a string as an SQL literal
+func sqliteQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// Open an SQLite database file with DSN parameters, first encrypting it in
+// place if it was written before a key was configured
+func openSQLite(file, params string) (*sql.DB, error) {
+	if databaseKey != "" {
+		if err := encryptPlaintextDatabase(file); err != nil {
+			return nil, fmt.Errorf("failed to encrypt %s: %v", file, err)
+		}
+	}
+	return sql.Open(sqliteDriver, fmt.Sprintf("file:%s?%s", file, params))
+}
+
+// Rewrite an unencrypted database file as an encrypted copy. Files that don't
+// exist yet or are already encrypted are left alone.
+func encryptPlaintextDatabase(file string) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 16)
+	_, err = io.ReadFull(f, header)
+	f.Close()
+	// Encrypted files start with random salt instead of the SQLite header
+	if err != nil || !bytes.Equal(header, []byte("SQLite format 3\x00")) {
+		return nil
+	}
+
+	// The plain driver opens the file without a key
+	plain, err := sql.Open("sqlite3", fmt.Sprintf("file:%s", file))
+	if err != nil {
+		return err
+	}
+	defer plain.Close()
+	// Export over a single connection so the attached database stays visible
+	plain.SetMaxOpenConns(1)
+
+	encrypted := file + ".encrypting"
+	os.Remove(encrypted)
+	if _, err := plain.Exec(fmt.Sprintf("ATTACH DATABASE %s AS encrypted KEY %s", sqliteQuote(encrypted), sqliteQuote(databaseKey))); err != nil {
+		return err
+	}
+	if _, err := plain.Exec("SELECT sqlcipher_export('encrypted')"); err != nil {
+		os.Remove(encrypted)
+		return err
+	}
+	if _, err := plain.Exec("DETACH DATABASE encrypted"); err != nil {
+		os.Remove(encrypted)
+		return err
+	}
+	if err := plain.Close(); err != nil {
+		os.Remove(encrypted)
+		return err
+	}
+
+	// The journal belongs to the plaintext file, which the export already read through
+	os.Remove(file + "-wal")
+	os.Remove(file + "-shm")
+	return os.Rename(encrypted, file)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Page sizes for starred messages
+const (
+	defaultStarredPageSize = 50
+	maxStarredPageSize     = 200
+)
+
+// StarRequest represents the request body for the star API
+type StarRequest struct {
+	ChatJID string `json:"chat_jid"`
+	// Defaults to true; false unstars the message
+	Starred *bool `json:"starred"`
+}
+
+// StarResponse represents the response for the star API
+type StarResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	MessageID string `json:"message_id"`
+	ChatJID   string `json:"chat_jid,omitempty"`
+	Starred   bool   `json:"starred"`
+}
+
+// StarredMessagesResponse represents the response for the starred messages API,
+// newest first
+type StarredMessagesResponse struct {
+	Messages []StoredMessage `json:"messages"`
+	HasMore  bool            `json:"has_more"`
+	// Pass as before to read the next page
+	NextBefore string `json:"next_before,omitempty"`
+}
+
+// Star or unstar a stored message
+func (store *MessageStore) SetMessageStarred(id, chatJID string, starred bool) error {
+	_, err := store.db.Exec("UPDATE messages SET starred = ? WHERE id = ? AND chat_jid = ?", starred, id, chatJID)
+	return err
+}
+
+// Read a page of starred messages across chats, newest first, optionally
+// limited to one chat
+func (store *MessageStore) StarredMessages(chatJID string, before *messageCursor, limit int) (*StarredMessagesResponse, error) {
+	query := "SELECT " + storedMessageColumns + " FROM messages WHERE starred = 1"
+	var args []interface{}
+	if chatJID != "" {
+		query += " AND chat_jid = ?"
+		args = append(args, chatJID)
+	}
+	if before != nil {
+		query += " AND (CAST(timestamp AS TEXT) < ? OR (CAST(timestamp AS TEXT) = ? AND id < ?))"
+		args = append(args, before.Timestamp, before.Timestamp, before.ID)
+	}
+	// Fetch one extra row to know whether there is another page
+	query += " ORDER BY CAST(timestamp AS TEXT) DESC, id DESC LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	response := &StarredMessagesResponse{Messages: []StoredMessage{}}
+	var cursors []messageCursor
+	for rows.Next() {
+		msg, cursor, err := scanStoredMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		response.Messages = append(response.Messages, msg)
+		cursors = append(cursors, cursor)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(response.Messages) > limit {
+		response.Messages = response.Messages[:limit]
+		response.HasMore = true
+		response.NextBefore = cursors[limit-1].encode()
+	}
+	return response, nil
+}
+
+// Keep stars in sync with changes made on other devices
+func (a *Account) handleStarEvent(evt *events.Star) {
+	if err := a.MessageStore.SetMessageStarred(evt.MessageID, evt.ChatJID.String(), evt.Action.GetStarred()); err != nil {
+		a.Logger.Warnf("Failed to update star of %s: %v", evt.MessageID, err)
+	}
+}
+
+// Handle POST /api/messages/{id}/star. The star is sent as an app state patch
+// so the phone and other linked devices show it too.
+func (a *Account) HandleStarEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID := r.PathValue("id")
+
+	// Parse the request body; an empty body stars the message
+	var req StarRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	starred := req.Starred == nil || *req.Starred
+
+	chat, err := a.resolveMessageChat(messageID, req.ChatJID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := StarResponse{MessageID: messageID, ChatJID: chat.String(), Starred: starred}
+	if !a.Client.IsConnected() {
+		response.Message = errNotConnected.Error()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// The key names the sender only for other people's messages in groups
+	original, _ := a.MessageStore.GetMessage(messageID, chat.String())
+	fromMe := original != nil && original.IsFromMe
+	sender := chat
+	if chat.Server == types.GroupServer && !fromMe {
+		if sender, err = a.messageSender(chat, original); err != nil {
+			response.Message = fmt.Sprintf("Cannot star message: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+	}
+
+	if err := a.Client.SendAppState(appstate.BuildStar(chat, sender, messageID, fromMe, starred)); err != nil {
+		response.Message = fmt.Sprintf("Failed to star message: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	// Our own patches aren't echoed back, so update the store ourselves
+	if err := a.MessageStore.SetMessageStarred(messageID, chat.String(), starred); err != nil {
+		a.Logger.Warnf("Failed to update star of %s: %v", messageID, err)
+	}
+
+	response.Success = true
+	response.Message = fmt.Sprintf("Starred %s", messageID)
+	if !starred {
+		response.Message = fmt.Sprintf("Unstarred %s", messageID)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handle GET /api/messages/starred
+func (a *Account) HandleStarredMessagesEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var chatJID string
+	if value := query.Get("chat_jid"); value != "" {
+		chat, err := parseRecipient(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		chatJID = chat.String()
+	}
+
+	var before *messageCursor
+	if value := query.Get("before"); value != "" {
+		var err error
+		if before, err = decodeMessageCursor(value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := defaultStarredPageSize
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		if limit > maxStarredPageSize {
+			limit = maxStarredPageSize
+		}
+	}
+
+	response, err := a.MessageStore.StarredMessages(chatJID, before, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load starred messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// WhatsApp stickers are square 512x512 WebP images
+const stickerSize = 512
+
+// Scale into a 512x512 box and pad with transparent pixels to keep the aspect ratio
+var stickerFilter = fmt.Sprintf(
+	"scale=%[1]d:%[1]d:force_original_aspect_ratio=decrease,format=rgba,pad=%[1]d:%[1]d:(ow-iw)/2:(oh-ih)/2:color=black@0",
+	stickerSize,
+)
+
+// Report whether data is a WebP file, and whether it is animated
+func parseWebP(data []byte) (isWebP bool, animated bool) {
+	if len(data) < 21 || !bytes.Equal(data[0:4], []byte("RIFF")) || !bytes.Equal(data[8:12], []byte("WEBP")) {
+		return false, false
+	}
+	// Extended WebP files carry an animation flag in the VP8X header
+	if bytes.Equal(data[12:16], []byte("VP8X")) {
+		return true, data[20]&0x02 != 0
+	}
+	return true, false
+}
+
+// Read the canvas size of an extended WebP, falling back to the sticker size
+func webPSize(data []byte) (uint32, uint32) {
+	if len(data) >= 30 && bytes.Equal(data[12:16], []byte("VP8X")) {
+		// Both dimensions are stored minus one as 24-bit little endian
+		width := uint32(data[24]) | uint32(data[25])<<8 | uint32(data[26])<<16
+		height := uint32(data[27]) | uint32(data[28])<<8 | uint32(data[29])<<16
+		return width + 1, height + 1
+	}
+	return stickerSize, stickerSize
+}
+
+// Convert a PNG or JPEG into a sticker; WebP input is passed through untouched
+// so animated stickers keep their frames
+func prepareSticker(att *Attachment) ([]byte, bool, error) {
+	if isWebP, animated := parseWebP(att.Data); isWebP {
+		return att.Data, animated, nil
+	}
+
+	var ext string
+	switch att.MimeType {
+	case "image/png":
+		ext = ".png"
+	case "image/jpeg":
+		ext = ".jpg"
+	default:
+		return nil, false, fmt.Errorf("stickers must be PNG, JPEG or WebP, got %s", att.MimeType)
+	}
+
+	webp, err := runFFmpeg(att.Data, ext, ".webp",
+		"-vf", stickerFilter,
+		"-c:v", "libwebp", "-lossless", "0", "-quality", "80", "-frames:v", "1",
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to convert sticker: %v", err)
+	}
+	return webp, false, nil
+}
+
+// Handle POST /api/messages/sticker
+func (a *Account) HandleSendStickerEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, att, err := parseSendMediaRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to, err := parseRecipient(req.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webp, animated, err := prepareSticker(att)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !a.Client.IsConnected() {
+		writeSendResult(w, to, whatsmeow.SendResponse{}, errNotConnected)
+		return
+	}
+
+	resp, err := a.upload(webp, whatsmeow.MediaImage)
+	if err != nil {
+		writeSendResult(w, to, whatsmeow.SendResponse{}, fmt.Errorf("failed to upload sticker: %v", err))
+		return
+	}
+
+	width, height := webPSize(webp)
+	msg := &waProto.Message{StickerMessage: &waProto.StickerMessage{
+		Mimetype:      proto.String("image/webp"),
+		URL:           &resp.URL,
+		DirectPath:    &resp.DirectPath,
+		MediaKey:      resp.MediaKey,
+		FileEncSHA256: resp.FileEncSHA256,
+		FileSHA256:    resp.FileSHA256,
+		FileLength:    &resp.FileLength,
+		Width:         proto.Uint32(width),
+		Height:        proto.Uint32(height),
+		IsAnimated:    proto.Bool(animated),
+	}}
+	if req.QuotedMessageID != "" {
+		applyContextInfo(msg, a.quoteContext(to, req.QuotedMessageID))
+	}
+
+	sent, err := a.sendMessage(to, msg)
+	writeSendResult(w, to, sent, err)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Media storage drivers
+const (
+	MediaStorageLocal = "local"
+	MediaStorageS3    = "s3"
+)
+
+// MediaStorage keeps downloaded media files. Files are saved under a key and
+// referred to afterwards by the location Save returns, which is what the
+// message store records.
+type MediaStorage interface {
+	// Save a file, overwriting any file with the same key
+	Save(key string, data []byte, mimeType string) (string, error)
+	Exists(location string) bool
+	Read(location string) ([]byte, error)
+	Open(location string) (io.ReadSeekCloser, error)
+	Delete(location string) error
+	// URL clients can fetch a file from directly for a limited time, empty
+	// when the bridge has to serve it itself
+	SignedURL(location, mimeType string) (string, error)
+}
+
+// Key of a message's media: one directory per chat, files named by message ID
+func mediaStorageKey(chatJID, name string) string {
+	return path.Join(strings.ReplaceAll(chatJID, ":", "_"), name)
+}
+
+// LocalMediaStorage keeps media files in a directory. Locations are file
+// paths, so files saved before storage drivers existed keep working.
+type LocalMediaStorage struct {
+	dir string
+}
+
+// Create a storage driver for a local directory
+func NewLocalMediaStorage(dir string) *LocalMediaStorage {
+	return &LocalMediaStorage{dir: dir}
+}
+
+// Save implements MediaStorage
+func (s *LocalMediaStorage) Save(key string, data []byte, mimeType string) (string, error) {
+	location := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(location), 0755); err != nil {
+		return "", fmt.Errorf("failed to create media directory: %v", err)
+	}
+	if err := os.WriteFile(location, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to save media file: %v", err)
+	}
+	return location, nil
+}
+
+// Exists implements MediaStorage
+func (s *LocalMediaStorage) Exists(location string) bool {
+	_, err := os.Stat(location)
+	return err == nil
+}
+
+// Read implements MediaStorage
+func (s *LocalMediaStorage) Read(location string) ([]byte, error) {
+	return os.ReadFile(location)
+}
+
+// Open implements MediaStorage
+func (s *LocalMediaStorage) Open(location string) (io.ReadSeekCloser, error) {
+	return os.Open(location)
+}
+
+// Delete implements MediaStorage; deleting a missing file is not an error
+func (s *LocalMediaStorage) Delete(location string) error {
+	if err := os.Remove(location); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SignedURL implements MediaStorage; local files are served by the bridge
+func (s *LocalMediaStorage) SignedURL(location, mimeType string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waCompanionReg"
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// How much history the phone sends after pairing
+const (
+	HistorySyncRecent = "recent"
+	HistorySyncFull   = "full"
+)
+
+// States reported by the sync status API
+const (
+	SyncStateWaiting  = "waiting"
+	SyncStateSyncing  = "syncing"
+	SyncStateComplete = "complete"
+)
+
+// Sent to webhooks and event streams for every history chunk
+const WebhookEventHistorySync = "history_sync"
+
+// Messages requested per on-demand sync, as recommended by whatsmeow
+const (
+	defaultOnDemandCount = 50
+	maxOnDemandCount     = 500
+)
+
+// SyncStatus is the history sync progress of an account
+type SyncStatus struct {
+	Mode  string `json:"mode"`
+	State string `json:"state"`
+	// Percentage reported by the phone for initial syncs
+	Progress        int        `json:"progress"`
+	LastSyncType    string     `json:"last_sync_type,omitempty"`
+	Chunks          int        `json:"chunks"`
+	Conversations   int        `json:"conversations"`
+	Messages        int        `json:"messages"`
+	PendingOnDemand int        `json:"pending_on_demand"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	LastChunkAt     *time.Time `json:"last_chunk_at,omitempty"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+}
+
+// SyncTracker records history sync progress as chunks arrive
+type SyncTracker struct {
+	mu     sync.Mutex
+	status SyncStatus
+}
+
+// OnDemandSyncRequest represents the request body for the on-demand sync API
+type OnDemandSyncRequest struct {
+	ChatJID string `json:"chat_jid"`
+	// Messages to fetch before the oldest stored one; defaults to 50
+	Count int `json:"count"`
+}
+
+// Tell the phone how much history to send. This is part of the pairing
+// handshake, so it only affects accounts paired afterwards.
+func applyHistorySyncConfig(cfg *Config) {
+	store.DeviceProps.RequireFullSync = proto.Bool(cfg.HistorySync == HistorySyncFull)
+	if cfg.HistorySyncDays > 0 {
+		days := uint32(cfg.HistorySyncDays)
+		config := &waCompanionReg.DeviceProps_HistorySyncConfig{}
+		if cfg.HistorySync == HistorySyncFull {
+			config.FullSyncDaysLimit = &days
+		} else {
+			config.RecentSyncDaysLimit = &days
+		}
+		store.DeviceProps.HistorySyncConfig = config
+	}
+}
+
+// Create a tracker for an account that hasn't received history yet
+func NewSyncTracker(mode string) *SyncTracker {
+	return &SyncTracker{status: SyncStatus{Mode: mode, State: SyncStateWaiting}}
+}
+
+// Snapshot of the sync progress
+func (t *SyncTracker) Status() SyncStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// Record a processed history chunk
+func (t *SyncTracker) recordChunk(data *waHistorySync.HistorySync, stored int) SyncStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	s := &t.status
+	if s.StartedAt == nil {
+		s.StartedAt = &now
+	}
+	s.LastChunkAt = &now
+	s.LastSyncType = strings.ToLower(data.GetSyncType().String())
+	s.Chunks++
+	s.Conversations += len(data.GetConversations())
+	s.Messages += stored
+
+	switch data.GetSyncType() {
+	case waHistorySync.HistorySync_ON_DEMAND:
+		if s.PendingOnDemand > 0 {
+			s.PendingOnDemand--
+		}
+	case waHistorySync.HistorySync_INITIAL_BOOTSTRAP, waHistorySync.HistorySync_RECENT, waHistorySync.HistorySync_FULL:
+		if progress := int(data.GetProgress()); progress > s.Progress {
+			s.Progress = progress
+		}
+		if s.Progress >= 100 && s.State != SyncStateComplete {
+			s.State = SyncStateComplete
+			s.CompletedAt = &now
+		} else if s.State == SyncStateWaiting {
+			s.State = SyncStateSyncing
+		}
+	}
+	return *s
+}
+
+// Count an on-demand request that is waiting for its chunk
+func (t *SyncTracker) addPendingOnDemand() {
+	t.mu.Lock()
+	t.status.PendingOnDemand++
+	t.mu.Unlock()
+}
+
+// Store a history chunk and report the progress
+func (a *Account) handleHistorySync(evt *events.HistorySync) {
+	stored := handleHistorySync(a.Client, a.MessageStore, evt, a.Logger)
+	status := a.Sync.recordChunk(evt.Data, stored)
+
+	a.Notifier.Notify(a.ID, WebhookEventHistorySync, map[string]interface{}{
+		"sync_type":     status.LastSyncType,
+		"state":         status.State,
+		"progress":      status.Progress,
+		"conversations": len(evt.Data.GetConversations()),
+		"messages":      stored,
+	})
+}
+
+// Get the oldest stored message of a chat, which on-demand syncs fetch history before
+func (store *MessageStore) OldestMessage(chatJID string) (*types.MessageInfo, error) {
+	var info types.MessageInfo
+	err := store.db.QueryRow(
+		"SELECT id, timestamp, is_from_me FROM messages WHERE chat_jid = ? ORDER BY timestamp ASC LIMIT 1",
+		chatJID,
+	).Scan(&info.ID, &info.Timestamp, &info.IsFromMe)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Ask the phone for older messages of a chat. The messages arrive later as
+// an on-demand history sync.
+func (a *Account) requestHistorySync(chat types.JID, count int) error {
+	if !a.Client.IsConnected() {
+		return errNotConnected
+	}
+	if a.Client.Store.ID == nil {
+		return fmt.Errorf("not logged in")
+	}
+
+	oldest, err := a.MessageStore.OldestMessage(chat.String())
+	if err != nil {
+		return err
+	}
+	oldest.Chat = chat
+
+	msg := a.Client.BuildHistorySyncRequest(oldest, count)
+	_, err = a.Client.SendMessage(context.Background(), a.Client.Store.ID.ToNonAD(), msg, whatsmeow.SendRequestExtra{Peer: true})
+	if err != nil {
+		return fmt.Errorf("failed to request history: %w", err)
+	}
+	a.Sync.addPendingOnDemand()
+	return nil
+}
+
+// Handle GET /api/sync/status
+func (a *Account) HandleSyncStatusEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.Sync.Status())
+}
+
+// Handle POST /api/sync/request
+func (a *Account) HandleSyncRequestEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var req OnDemandSyncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	chat, err := parseRecipient(req.ChatJID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Count == 0 {
+		req.Count = defaultOnDemandCount
+	}
+	if req.Count < 0 || req.Count > maxOnDemandCount {
+		http.Error(w, fmt.Sprintf("count must be between 1 and %d", maxOnDemandCount), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := a.requestHistorySync(chat, req.Count); err != nil {
+		status := http.StatusInternalServerError
+		message := err.Error()
+		if err == errNotConnected {
+			status = http.StatusServiceUnavailable
+		} else if err == sql.ErrNoRows {
+			status = http.StatusNotFound
+			message = "No stored messages in this chat to sync history before"
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(SendResult{Success: false, Message: message, JID: chat.String()})
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SendResult{
+		Success: true,
+		Message: fmt.Sprintf("Requested %d older messages of %s, follow progress at /api/sync/status", req.Count, chat),
+		JID:     chat.String(),
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Thumbnail sizes: the preview embedded in sent messages is kept tiny since
+// it travels in every copy of the message, gallery thumbnails can be larger
+const (
+	messageThumbSize = 96
+	galleryThumbSize = 320
+)
+
+// Whether thumbnails can be made of a media type
+func hasThumbnail(mediaType string) bool {
+	return mediaType == MediaKindImage || mediaType == MediaKindVideo
+}
+
+// Build a JPEG thumbnail of an image or of a video's first frame. Videos need
+// ffmpeg; other media has no thumbnail.
+func mediaThumbnail(kind string, data []byte, filename string, maxSize int) ([]byte, error) {
+	switch kind {
+	case MediaKindImage:
+		return jpegThumbnail(data, maxSize)
+	case MediaKindVideo:
+		ext := filepath.Ext(filename)
+		if ext == "" {
+			ext = ".mp4"
+		}
+		frame, err := runFFmpeg(data, ext, ".jpg", "-frames:v", "1")
+		if err != nil {
+			return nil, err
+		}
+		return jpegThumbnail(frame, maxSize)
+	}
+	return nil, fmt.Errorf("no thumbnail for %s media", kind)
+}
+
+// Get the preview image embedded in a message, if it has one
+func embeddedThumbnail(msg *waProto.Message) []byte {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetJPEGThumbnail()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetJPEGThumbnail()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetJPEGThumbnail()
+	}
+	return nil
+}
+
+// Save the thumbnail of a stored message
+func (store *MessageStore) SetThumbnail(id, chatJID string, thumbnail []byte) error {
+	_, err := store.db.Exec("UPDATE messages SET thumbnail = ? WHERE id = ? AND chat_jid = ?", thumbnail, id, chatJID)
+	return err
+}
+
+// Get the thumbnail of a stored message, nil if it has none
+func (store *MessageStore) GetThumbnail(id, chatJID string) ([]byte, error) {
+	var thumbnail []byte
+	err := store.db.QueryRow("SELECT thumbnail FROM messages WHERE id = ? AND chat_jid = ?", id, chatJID).Scan(&thumbnail)
+	return thumbnail, err
+}
+
+// Keep the preview embedded in a message so galleries can show it without
+// downloading the media. View-once previews follow the media setting.
+func (a *Account) stashThumbnail(id string, chat types.JID, msg *waProto.Message, viewOnce bool) {
+	thumbnail := embeddedThumbnail(msg)
+	if len(thumbnail) == 0 || (viewOnce && !a.MessageStore.keepViewOnceMedia) {
+		return
+	}
+	if err := a.MessageStore.SetThumbnail(id, chat.String(), thumbnail); err != nil {
+		a.Logger.Warnf("Failed to store thumbnail of %s: %v", id, err)
+	}
+}
+
+// Replace a message's thumbnail with a gallery-sized one made from the
+// downloaded image or video
+func (a *Account) galleryThumbnail(id, chatJID, mediaType string, data []byte, filename string) ([]byte, error) {
+	thumbnail, err := mediaThumbnail(mediaType, data, filename, galleryThumbSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.MessageStore.SetThumbnail(id, chatJID, thumbnail); err != nil {
+		return nil, err
+	}
+	return thumbnail, nil
+}
+
+// Handle GET /api/messages/{id}/thumbnail
+func (a *Account) HandleThumbnailEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID := r.PathValue("id")
+	chat, err := a.resolveMessageChat(messageID, r.URL.Query().Get("chat_jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	thumbnail, err := a.MessageStore.GetThumbnail(messageID, chat.String())
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf("Message %s not found in %s", messageID, chat), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load thumbnail: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Media downloaded before thumbnails were kept gets one now
+	if len(thumbnail) == 0 {
+		location, _, _ := a.MessageStore.GetLocalMedia(messageID, chat.String())
+		original, _ := a.MessageStore.GetMessage(messageID, chat.String())
+		if location != "" && original != nil && hasThumbnail(original.MediaType) {
+			data, err := a.Media.Read(location)
+			if err == nil {
+				thumbnail, err = a.galleryThumbnail(messageID, chat.String(), original.MediaType, data, location)
+			}
+			if err != nil {
+				a.Logger.Warnf("Failed to build thumbnail of %s: %v", messageID, err)
+			}
+		}
+	}
+	if len(thumbnail) == 0 {
+		http.Error(w, fmt.Sprintf("Message %s has no thumbnail", messageID), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(thumbnail)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Report whether the REST server should serve HTTPS
+func (c *Config) tlsEnabled() bool {
+	return c.TLSCert != "" || c.TLSKey != "" || c.ACMEDomains != ""
+}
+
+// Build the REST server's TLS settings, from the certificate and key files or
+// from certificates obtained automatically over ACME and cached in the store.
+// With ACME the returned handler answers HTTP-01 challenges and redirects
+// everything else to HTTPS; it is nil otherwise.
+func newServerTLS(cfg *Config, storeDir string) (*tls.Config, http.Handler, error) {
+	if cfg.ACMEDomains != "" {
+		if cfg.TLSCert != "" || cfg.TLSKey != "" {
+			return nil, nil, fmt.Errorf("--acme-domains can't be combined with --tls-cert and --tls-key")
+		}
+		var domains []string
+		for _, domain := range strings.Split(cfg.ACMEDomains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+		if len(domains) == 0 {
+			return nil, nil, fmt.Errorf("--acme-domains has no domains")
+		}
+
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(filepath.Join(storeDir, "acme")),
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Email:      cfg.ACMEEmail,
+		}
+		if cfg.ACMEDirectory != "" {
+			manager.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectory}
+		}
+		// The manager's config also answers TLS-ALPN-01 challenges on the HTTPS port
+		config := manager.TLSConfig()
+		config.MinVersion = tls.VersionTLS12
+		return config, manager.HTTPHandler(nil), nil
+	}
+
+	if cfg.TLSCert == "" || cfg.TLSKey == "" {
+		return nil, nil, fmt.Errorf("--tls-cert and --tls-key must be set together")
+	}
+	loader := &certFileLoader{certFile: cfg.TLSCert, keyFile: cfg.TLSKey}
+	if _, err := loader.GetCertificate(nil); err != nil {
+		return nil, nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: loader.GetCertificate,
+	}, nil, nil
+}
+
+// certFileLoader serves a certificate from files, reloading it when they
+// change so renewals by tools like certbot apply without a restart.
+type certFileLoader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// Return the certificate, reloading it if either file is newer than the loaded one
+func (l *certFileLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var modTime time.Time
+	for _, file := range []string{l.certFile, l.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			if l.cert != nil {
+				return l.cert, nil
+			}
+			return nil, fmt.Errorf("failed to read TLS certificate: %v", err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if l.cert != nil && !modTime.After(l.modTime) {
+		return l.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		// Keep serving the old certificate while the files are being replaced
+		if l.cert != nil {
+			return l.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	l.cert = &cert
+	l.modTime = modTime
+	return l.cert, nil
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds and status codes as numbered by OTLP
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+	spanStatusError  = 2
+)
+
+// Finished spans are sent in batches, and dropped if the collector can't keep up
+const (
+	traceBatchSize     = 512
+	traceFlushInterval = 5 * time.Second
+	traceQueueLimit    = 8192
+	traceExportTimeout = 10 * time.Second
+)
+
+// Sends are remembered this long, up to maxReceiptTraces of them, so their
+// receipts can join the trace
+const (
+	receiptTraceWindow = 24 * time.Hour
+	maxReceiptTraces   = 10000
+)
+
+// Tracer exporting the bridge's spans, nil when tracing is off
+var tracer *Tracer
+
+// spanContext identifies a span across process boundaries, as carried by
+// the W3C traceparent header
+type spanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+func (sc spanContext) valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Format as a traceparent header value, empty when invalid
+func (sc spanContext) traceparent() string {
+	if !sc.valid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// Parse a traceparent header value such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceparent(value string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags&1 == 1
+	return sc, sc.valid()
+}
+
+// Span is one timed operation of a trace. A nil span records nothing, so
+// callers don't need to check whether tracing is on.
+type Span struct {
+	ctx    spanContext
+	parent [8]byte
+	name   string
+	kind   int
+	start  time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  map[string]interface{}
+	failed bool
+	status string
+}
+
+type spanContextKey struct{}
+
+// Span context of the span a context belongs to, which may be remote
+func spanContextFrom(ctx context.Context) spanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(spanContext)
+	return sc
+}
+
+// Start a span under the span of ctx, returning a context carrying it
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	span := startSpanFrom(spanContextFrom(ctx), name, kind)
+	if span == nil {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, spanContextKey{}, span.ctx), span
+}
+
+// Start a span under a parent, or a new trace when the parent is invalid.
+// Children of unsampled parents aren't recorded.
+func startSpanFrom(parent spanContext, name string, kind int) *Span {
+	if tracer == nil || (parent.valid() && !parent.Sampled) {
+		return nil
+	}
+	span := &Span{name: name, kind: kind, start: time.Now(), attrs: make(map[string]interface{})}
+	span.ctx.Sampled = true
+	if parent.valid() {
+		span.ctx.TraceID = parent.TraceID
+		span.parent = parent.SpanID
+	} else {
+		rand.Read(span.ctx.TraceID[:])
+	}
+	rand.Read(span.ctx.SpanID[:])
+	return span
+}
+
+// Identity of the span, for starting children of it
+func (s *Span) context() spanContext {
+	if s == nil {
+		return spanContext{}
+	}
+	return s.ctx
+}
+
+// Attach an attribute; strings, bools, integers and floats are supported
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// Mark the span failed with an error, if there is one
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.failed, s.status = true, err.Error()
+	s.mu.Unlock()
+}
+
+// Finish the span and queue it for export
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	tracer.enqueue(s)
+}
+
+// Tracer batches finished spans and exports them to an OTLP/HTTP collector
+// as JSON
+type Tracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+
+	mu       sync.Mutex
+	pending  []*Span
+	dropped  int
+	receipts map[string]*receiptTrace
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// A send waiting for its receipts
+type receiptTrace struct {
+	ctx      spanContext
+	sentAt   time.Time
+	statuses map[string]bool
+}
+
+// Create the tracer from the config, nil when no collector is configured
+func NewTracer(cfg *Config) (*Tracer, error) {
+	if cfg.OTLPEndpoint == "" {
+		return nil, nil
+	}
+	endpoint := strings.TrimRight(cfg.OTLPEndpoint, "/")
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("--otlp-endpoint must be an http or https URL")
+	}
+	// Like OTEL_EXPORTER_OTLP_ENDPOINT, a base URL gets the traces path appended
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(cfg.OTLPHeaders, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid --otlp-headers entry %q, expected name=value", pair)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return &Tracer{
+		endpoint: endpoint,
+		headers:  headers,
+		service:  cfg.ServiceName,
+		client:   &http.Client{Timeout: traceExportTimeout},
+		receipts: make(map[string]*receiptTrace),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Run exports spans until Close is called
+func (t *Tracer) Run() {
+	defer close(t.done)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.flush()
+		case <-t.wake:
+			t.flush()
+		case <-t.stop:
+			t.flush()
+			return
+		}
+	}
+}
+
+// Stop the exporter after sending the spans still queued
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) >= traceQueueLimit {
+		t.dropped++
+		return
+	}
+	t.pending = append(t.pending, span)
+	if len(t.pending) >= traceBatchSize {
+		select {
+		case t.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Export everything queued, one batch at a time
+func (t *Tracer) flush() {
+	for {
+		t.mu.Lock()
+		if t.dropped > 0 {
+			bridgeLog.Warnf("Dropped %d spans the collector couldn't keep up with", t.dropped)
+			t.dropped = 0
+		}
+		n := min(len(t.pending), traceBatchSize)
+		batch := t.pending[:n:n]
+		t.pending = t.pending[n:]
+		t.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			bridgeLog.Warnf("Failed to export %d spans: %v", n, err)
+			return
+		}
+	}
+}
+
+// OTLP JSON types, see opentelemetry-proto's trace_service.proto
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+// Convert an attribute value to its OTLP form
+func otlpValue(value interface{}) otlpAnyValue {
+	switch v := value.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpAnyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpAnyValue{IntValue: &s}
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+	}
+	s := fmt.Sprint(value)
+	return otlpAnyValue{StringValue: &s}
+}
+
+// Post a batch of spans to the collector
+func (t *Tracer) export(batch []*Span) error {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		span.mu.Lock()
+		out := otlpSpan{
+			TraceID:           hex.EncodeToString(span.ctx.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.ctx.SpanID[:]),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+		}
+		if span.parent != [8]byte{} {
+			out.ParentSpanID = hex.EncodeToString(span.parent[:])
+		}
+		for key, value := range span.attrs {
+			out.Attributes = append(out.Attributes, otlpKeyValue{Key: key, Value: otlpValue(value)})
+		}
+		if span.failed {
+			out.Status = otlpStatus{Code: spanStatusError, Message: span.status}
+		}
+		span.mu.Unlock()
+		spans = append(spans, out)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []map[string]interface{}{{
+			"resource": map[string]interface{}{
+				"attributes": []otlpKeyValue{{Key: "service.name", Value: otlpValue(t.service)}},
+			},
+			"scopeSpans": []map[string]interface{}{{
+				"scope": map[string]string{"name": "whatsapp-bridge"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Remember a sent message so its receipts become spans of the same trace
+func (t *Tracer) awaitReceipts(messageID string, sc spanContext, sentAt time.Time) {
+	if t == nil || !sc.valid() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.receipts) >= maxReceiptTraces {
+		for id, waiting := range t.receipts {
+			if time.Since(waiting.sentAt) > receiptTraceWindow {
+				delete(t.receipts, id)
+			}
+		}
+		if len(t.receipts) >= maxReceiptTraces {
+			return
+		}
+	}
+	t.receipts[messageID] = &receiptTrace{ctx: sc, sentAt: sentAt, statuses: make(map[string]bool)}
+}
+
+// Record the first receipt of each status for a traced message, as a span
+// running from the send to the receipt
+func (t *Tracer) receiptArrived(messageID, status string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	waiting, ok := t.receipts[messageID]
+	if !ok || waiting.statuses[status] {
+		t.mu.Unlock()
+		return
+	}
+	waiting.statuses[status] = true
+	t.mu.Unlock()
+
+	span := startSpanFrom(waiting.ctx, "whatsapp.receipt", spanKindInternal)
+	if span == nil {
+		return
+	}
+	span.start = waiting.sentAt
+	span.SetAttr("whatsapp.message_id", messageID)
+	span.SetAttr("whatsapp.receipt", status)
+	span.End()
+}
+
+// traceMiddleware starts a server span for every request, continuing the
+// trace of an incoming traceparent header. The span is stored in the
+// request context, so handlers, queues and sends add their own spans to it.
+func traceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracer == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		// Requests made by MCP tools already carry the span of the /mcp call
+		if !spanContextFrom(ctx).valid() {
+			if remote, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+				ctx = context.WithValue(ctx, spanContextKey{}, remote)
+			}
+		}
+		ctx, span := startSpan(ctx, r.Method+" "+r.URL.Path, spanKindServer)
+		if span == nil {
+			// Unsampled callers keep their decision for everything below
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		span.SetAttr("http.request.method", r.Method)
+		span.SetAttr("url.path", r.URL.Path)
+		if id := requestID(ctx); id != "" {
+			span.SetAttr("request_id", id)
+		}
+		if tool, _ := ctx.Value(mcpToolContextKey{}).(string); tool != "" {
+			span.SetAttr("mcp.tool", tool)
+		}
+
+		r = r.WithContext(ctx)
+		recorder := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		// Name the span after the route rather than the path, which holds IDs
+		if r.Pattern != "" {
+			span.name = r.Method + " " + strings.TrimPrefix(r.Pattern, r.Method+" ")
+		}
+		span.SetAttr("http.response.status_code", recorder.status)
+		if recorder.status >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("%s", http.StatusText(recorder.status)))
+		}
+		span.End()
+	})
+}
+
+// Copy of an account whose spans become children of a span
+func (a *Account) withTrace(parent spanContext) *Account {
+	if !parent.valid() {
+		return a
+	}
+	traced := *a
+	traced.traceParent = parent
+	return &traced
+}
+
+// Start a span under the span the account copy belongs to
+func (a *Account) span(name string, kind int) *Span {
+	return startSpanFrom(a.traceParent, name, kind)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Upper bound for a single ffmpeg conversion
+const transcodeTimeout = 2 * time.Minute
+
+// Run ffmpeg on in-memory data. The input is written to a temp file with the
+// given extension so ffmpeg can probe it, and the output extension selects
+// the container. args go between the input and the output.
+func runFFmpeg(input []byte, inputExt, outputExt string, args ...string) ([]byte, error) {
+	return runFFmpegTimeout(transcodeTimeout, input, inputExt, outputExt, args...)
+}
+
+// Run ffmpeg like runFFmpeg, for conversions that may take longer
+func runFFmpegTimeout(timeout time.Duration, input []byte, inputExt, outputExt string, args ...string) ([]byte, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg is required for this conversion but was not found in PATH")
+	}
+
+	dir, err := os.MkdirTemp("", "whatsapp-transcode-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	inPath := filepath.Join(dir, "input"+inputExt)
+	outPath := filepath.Join(dir, "output"+outputExt)
+	if err := os.WriteFile(inPath, input, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write temp file: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmdArgs := append([]string{"-hide_banner", "-loglevel", "error", "-y", "-i", inPath}, args...)
+	cmdArgs = append(cmdArgs, outPath)
+	cmd := exec.CommandContext(ctx, ffmpeg, cmdArgs...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	output, err := os.ReadFile(outPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ffmpeg output: %v", err)
+	}
+	return output, nil
+}
+
+// What ffprobe reports about a media file
+type mediaProbe struct {
+	Format      string
+	Duration    float64
+	VideoCodec  string
+	PixelFormat string
+	Width       int
+	Height      int
+	AudioCodec  string
+}
+
+// Inspect in-memory media with ffprobe
+func probeMedia(input []byte, inputExt string) (*mediaProbe, error) {
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe is required to inspect media but was not found in PATH")
+	}
+
+	file, err := os.CreateTemp("", "whatsapp-probe-*"+inputExt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(input)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write temp file: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ffprobe, "-v", "error", "-of", "json",
+		"-show_entries", "format=format_name,duration:stream=codec_type,codec_name,pix_fmt,width,height", file.Name())
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var result struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+			PixFmt    string `json:"pix_fmt"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %v", err)
+	}
+
+	probe := &mediaProbe{Format: result.Format.FormatName}
+	probe.Duration, _ = strconv.ParseFloat(result.Format.Duration, 64)
+	for _, stream := range result.Streams {
+		switch {
+		case stream.CodecType == "video" && probe.VideoCodec == "":
+			probe.VideoCodec, probe.PixelFormat = stream.CodecName, stream.PixFmt
+			probe.Width, probe.Height = stream.Width, stream.Height
+		case stream.CodecType == "audio" && probe.AudioCodec == "":
+			probe.AudioCodec = stream.CodecName
+		}
+	}
+	return probe, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Chat states accepted by the typing API
+const (
+	TypingStateComposing = "composing"
+	TypingStateRecording = "recording"
+	TypingStatePaused    = "paused"
+)
+
+// Simulated typing speed for auto-typing, bounded so short replies still show
+// the indicator and long ones don't stall the request
+const (
+	typingDelayPerChar = 50 * time.Millisecond
+	minTypingDelay     = 1 * time.Second
+	maxTypingDelay     = 8 * time.Second
+)
+
+// TypingRequest represents the request body for the typing API
+type TypingRequest struct {
+	State string `json:"state"`
+}
+
+// Send a chat state to a chat. Recording is composing with audio media.
+func (a *Account) sendTyping(chat types.JID, state string) error {
+	if !a.Client.IsConnected() {
+		return errNotConnected
+	}
+
+	var presence types.ChatPresence
+	media := types.ChatPresenceMediaText
+	switch state {
+	case TypingStateComposing:
+		presence = types.ChatPresenceComposing
+	case TypingStateRecording:
+		presence = types.ChatPresenceComposing
+		media = types.ChatPresenceMediaAudio
+	case TypingStatePaused:
+		presence = types.ChatPresencePaused
+	default:
+		return fmt.Errorf("state must be composing, recording or paused")
+	}
+	return a.Client.SendChatPresence(chat, presence, media)
+}
+
+// How long a person would take to type a message
+func typingDelay(text string) time.Duration {
+	delay := time.Duration(utf8.RuneCountInString(text)) * typingDelayPerChar
+	if delay < minTypingDelay {
+		return minTypingDelay
+	}
+	if delay > maxTypingDelay {
+		return maxTypingDelay
+	}
+	return delay
+}
+
+// Show the typing indicator for as long as the text would take to type.
+// Failures are logged since the message should go out regardless.
+func (a *Account) simulateTyping(chat types.JID, text string) {
+	if err := a.sendTyping(chat, TypingStateComposing); err != nil {
+		a.Logger.Debugf("Failed to send typing indicator to %s: %v", chat, err)
+		return
+	}
+	time.Sleep(typingDelay(text))
+	if err := a.sendTyping(chat, TypingStatePaused); err != nil {
+		a.Logger.Debugf("Failed to clear typing indicator in %s: %v", chat, err)
+	}
+}
+
+// Handle POST /api/chats/{jid}/typing
+func (a *Account) HandleTypingEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	chat, err := parseRecipient(r.PathValue("jid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse the request body
+	var req TypingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if req.State != TypingStateComposing && req.State != TypingStateRecording && req.State != TypingStatePaused {
+		http.Error(w, "state must be composing, recording or paused", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := a.sendTyping(chat, req.State); err != nil {
+		if err == errNotConnected {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(SendResult{
+			Success: false,
+			Message: fmt.Sprintf("Failed to send typing state: %v", err),
+			JID:     chat.String(),
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(SendResult{
+		Success: true,
+		Message: fmt.Sprintf("Typing state %s sent to %s", req.State, chat),
+		JID:     chat.String(),
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// ContactCard is a contact described by structured fields
+type ContactCard struct {
+	Name         string   `json:"name"`
+	Phones       []string `json:"phones"`
+	Organization string   `json:"organization,omitempty"`
+	Email        string   `json:"email,omitempty"`
+}
+
+// SendContactRequest represents the request body for the contact card API.
+// Cards can be given as structured contacts, raw vCards, or both.
+type SendContactRequest struct {
+	Recipient       string        `json:"recipient"`
+	Contacts        []ContactCard `json:"contacts"`
+	VCards          []string      `json:"vcards"`
+	QuotedMessageID string        `json:"quoted_message_id"`
+}
+
+// Escape text for use in a vCard value
+func escapeVCard(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`).Replace(value)
+}
+
+// Render a contact as a vCard 3.0. The waid parameter lets WhatsApp link
+// each number to its account so the card shows Message/Add buttons.
+func (c ContactCard) VCard() (string, error) {
+	if strings.TrimSpace(c.Name) == "" {
+		return "", fmt.Errorf("contact name is required")
+	}
+	if len(c.Phones) == 0 {
+		return "", fmt.Errorf("contact %s needs at least one phone number", c.Name)
+	}
+
+	var b strings.Builder
+	b.WriteString("BEGIN:VCARD\nVERSION:3.0\n")
+	fmt.Fprintf(&b, "N:;%s;;;\nFN:%s\n", escapeVCard(c.Name), escapeVCard(c.Name))
+	if c.Organization != "" {
+		fmt.Fprintf(&b, "ORG:%s;\n", escapeVCard(c.Organization))
+	}
+	for _, phone := range c.Phones {
+		jid, err := parseRecipient(phone)
+		if err != nil {
+			return "", fmt.Errorf("contact %s: %v", c.Name, err)
+		}
+		fmt.Fprintf(&b, "TEL;type=CELL;type=VOICE;waid=%s:+%s\n", jid.User, jid.User)
+	}
+	if c.Email != "" {
+		fmt.Fprintf(&b, "EMAIL;type=INTERNET:%s\n", escapeVCard(c.Email))
+	}
+	b.WriteString("END:VCARD")
+	return b.String(), nil
+}
+
+// Find the display name of a raw vCard
+func vCardDisplayName(vcard string) string {
+	for _, line := range strings.Split(strings.ReplaceAll(vcard, "\r\n", "\n"), "\n") {
+		if name, ok := strings.CutPrefix(line, "FN:"); ok {
+			return strings.TrimSpace(name)
+		} else if strings.HasPrefix(line, "FN;") {
+			if _, name, ok := strings.Cut(line, ":"); ok {
+				return strings.TrimSpace(name)
+			}
+		}
+	}
+	return ""
+}
+
+// Build a contact message, using the array variant for more than one card
+func buildContactMessage(req *SendContactRequest) (*waProto.Message, error) {
+	var cards []*waProto.ContactMessage
+	for _, contact := range req.Contacts {
+		vcard, err := contact.VCard()
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, &waProto.ContactMessage{
+			DisplayName: proto.String(contact.Name),
+			Vcard:       proto.String(vcard),
+		})
+	}
+	for _, vcard := range req.VCards {
+		if !strings.HasPrefix(strings.TrimSpace(vcard), "BEGIN:VCARD") {
+			return nil, fmt.Errorf("vCard must start with BEGIN:VCARD")
+		}
+		name := vCardDisplayName(vcard)
+		if name == "" {
+			return nil, fmt.Errorf("vCard is missing an FN name")
+		}
+		cards = append(cards, &waProto.ContactMessage{
+			DisplayName: proto.String(name),
+			Vcard:       proto.String(vcard),
+		})
+	}
+
+	switch len(cards) {
+	case 0:
+		return nil, fmt.Errorf("at least one contact or vCard is required")
+	case 1:
+		return &waProto.Message{ContactMessage: cards[0]}, nil
+	default:
+		return &waProto.Message{ContactsArrayMessage: &waProto.ContactsArrayMessage{
+			DisplayName: proto.String(fmt.Sprintf("%d contacts", len(cards))),
+			Contacts:    cards,
+		}}, nil
+	}
+}
+
+// Handle POST /api/messages/contact
+func (a *Account) HandleSendContactEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var req SendContactRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	to, err := parseRecipient(req.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg, err := buildContactMessage(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.QuotedMessageID != "" {
+		applyContextInfo(msg, a.quoteContext(to, req.QuotedMessageID))
+	}
+
+	resp, err := a.sendOrQueue(to, msg)
+	writeSendResult(w, to, resp, err)
+}
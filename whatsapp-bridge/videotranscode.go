@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/gif"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Upper bound for transcoding a single video
+const videoTranscodeTimeout = 30 * time.Minute
+
+// Audio bitrate of transcoded videos in bits per second
+const videoAudioBitrate = 128_000
+
+// Lowest video bitrate a size target may squeeze a video down to
+const videoMinBitrate = 150_000
+
+// VideoTranscoding controls how outgoing videos are converted to H.264/AAC
+// MP4, the one format every WhatsApp client plays inline
+type VideoTranscoding struct {
+	Enabled bool
+	// Size in bytes to fit videos into; 0 keeps constant quality instead
+	TargetSize int64
+	// Longest side in pixels after resizing
+	MaxDimension int
+}
+
+// Convert a video attachment to a format WhatsApp clients play when it isn't
+// in one, or shrink it when it is over the target size, returning whether it
+// changed. Playable videos are only remuxed when their container is wrong.
+func transcodeVideo(att *Attachment, opts VideoTranscoding) (bool, error) {
+	ext := filepath.Ext(att.Filename)
+	if ext == "" {
+		ext = ".mp4"
+	}
+	probe, err := probeMedia(att.Data, ext)
+	if err != nil {
+		return false, err
+	}
+	if probe.VideoCodec == "" {
+		return false, fmt.Errorf("no video stream found")
+	}
+
+	playable := probe.VideoCodec == "h264" && probe.PixelFormat == "yuv420p" &&
+		(probe.AudioCodec == "" || probe.AudioCodec == "aac") &&
+		max(probe.Width, probe.Height) <= opts.MaxDimension
+	mimeType := strings.Split(att.MimeType, ";")[0]
+	mp4 := mimeType == "video/mp4" || strings.Contains(probe.Format, "mp4")
+	oversized := opts.TargetSize > 0 && int64(len(att.Data)) > opts.TargetSize
+
+	var args []string
+	switch {
+	case playable && mp4 && !oversized:
+		return false, nil
+	case playable && !oversized:
+		args = []string{"-c", "copy"}
+	default:
+		// Scale the longest side down to the limit, keeping both sides even
+		// as yuv420p requires
+		limit := opts.MaxDimension
+		scale := fmt.Sprintf("scale=w='if(gte(iw,ih),min(iw,%d),-2)':h='if(gte(iw,ih),-2,min(ih,%d))',scale=trunc(iw/2)*2:trunc(ih/2)*2", limit, limit)
+		args = []string{
+			"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main", "-pix_fmt", "yuv420p", "-vf", scale,
+			"-c:a", "aac", "-b:a", fmt.Sprint(videoAudioBitrate), "-ac", "2",
+		}
+		if bitrate := videoBitrate(opts.TargetSize, probe.Duration); bitrate > 0 {
+			args = append(args, "-b:v", fmt.Sprint(bitrate), "-maxrate", fmt.Sprint(bitrate*3/2), "-bufsize", fmt.Sprint(bitrate*2))
+		} else {
+			args = append(args, "-crf", "23")
+		}
+	}
+	// Put the index first so clients can start playing before the download ends
+	args = append(args, "-movflags", "+faststart")
+
+	output, err := runFFmpegTimeout(videoTranscodeTimeout, att.Data, ext, ".mp4", args...)
+	if err != nil {
+		return false, err
+	}
+	att.Data = output
+	att.MimeType = "video/mp4"
+	if att.Filename != "" {
+		att.Filename = strings.TrimSuffix(att.Filename, filepath.Ext(att.Filename)) + ".mp4"
+	}
+	return true, nil
+}
+
+// Video bitrate that fits a video of a duration in seconds into a size,
+// leaving room for audio and container overhead; 0 without a target
+func videoBitrate(targetSize int64, duration float64) int64 {
+	if targetSize <= 0 || duration <= 0 {
+		return 0
+	}
+	total := int64(float64(targetSize*8) * 0.95 / duration)
+	return max(total-videoAudioBitrate, videoMinBitrate)
+}
+
+// Whether an image attachment is a GIF with more than one frame
+func isAnimatedGIF(att *Attachment) bool {
+	if strings.Split(att.MimeType, ";")[0] != "image/gif" {
+		return false
+	}
+	animation, err := gif.DecodeAll(bytes.NewReader(att.Data))
+	return err == nil && len(animation.Image) > 1
+}
+
+// Convert an animated GIF to the silent MP4 WhatsApp loops in place of GIFs
+func convertGIF(att *Attachment) error {
+	// yuv420p needs even sides, which GIFs often don't have
+	output, err := runFFmpeg(att.Data, ".gif", ".mp4",
+		"-an", "-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", "-movflags", "+faststart")
+	if err != nil {
+		return err
+	}
+	att.Data = output
+	att.MimeType = "video/mp4"
+	att.Kind = MediaKindVideo
+	att.GIFPlayback = true
+	if att.Filename != "" {
+		att.Filename = strings.TrimSuffix(att.Filename, filepath.Ext(att.Filename)) + ".mp4"
+	}
+	return nil
+}
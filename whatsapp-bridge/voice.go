@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// WhatsApp voice notes carry 64 waveform samples scaled to 0-100
+const waveformSamples = 64
+
+// Sample rate used when decoding audio for the waveform
+const waveformSampleRate = 8000
+
+// Report whether data is already an Ogg file with an Opus stream
+func isOggOpus(data []byte) bool {
+	if len(data) < 4 || string(data[0:4]) != "OggS" {
+		return false
+	}
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	return bytes.Contains(head, []byte("OpusHead"))
+}
+
+// Extension hint for ffmpeg so it can probe formats without magic bytes
+func audioExt(att *Attachment) string {
+	if ext := filepath.Ext(att.Filename); ext != "" {
+		return strings.ToLower(ext)
+	}
+	return ".audio"
+}
+
+// Transcode any audio ffmpeg understands into mono Ogg/Opus for a voice note
+func transcodeToOpus(data []byte, ext string) ([]byte, error) {
+	return runFFmpeg(data, ext, ".ogg",
+		"-vn", "-map_metadata", "-1",
+		"-ac", "1", "-ar", "48000",
+		"-c:a", "libopus", "-b:a", "32k", "-application", "voip",
+	)
+}
+
+// Compute the voice note waveform by decoding to 16-bit PCM and taking the
+// peak amplitude of each of 64 equal slices, normalized so the loudest is 100
+func computeWaveform(data []byte, ext string) ([]byte, error) {
+	pcm, err := runFFmpeg(data, ext, ".pcm",
+		"-vn", "-ac", "1", "-ar", fmt.Sprint(waveformSampleRate), "-f", "s16le",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := len(pcm) / 2
+	if samples == 0 {
+		return nil, fmt.Errorf("audio has no samples")
+	}
+
+	peaks := make([]float64, waveformSamples)
+	var loudest float64
+	for i := 0; i < waveformSamples; i++ {
+		start := i * samples / waveformSamples
+		end := (i + 1) * samples / waveformSamples
+		for j := start; j < end; j++ {
+			sample := math.Abs(float64(int16(binary.LittleEndian.Uint16(pcm[j*2:]))))
+			if sample > peaks[i] {
+				peaks[i] = sample
+			}
+		}
+		loudest = math.Max(loudest, peaks[i])
+	}
+
+	waveform := make([]byte, waveformSamples)
+	if loudest == 0 {
+		return waveform, nil
+	}
+	for i, peak := range peaks {
+		waveform[i] = byte(math.Round(peak / loudest * 100))
+	}
+	return waveform, nil
+}
+
+// Length in seconds of audio or video, 0 when it can't be determined
+func mediaDuration(data []byte, ext string) uint32 {
+	if isOggOpus(data) {
+		if seconds, _, err := analyzeOggOpus(data); err == nil {
+			return seconds
+		}
+	}
+	probe, err := probeMedia(data, ext)
+	if err != nil || probe.Duration <= 0 {
+		return 0
+	}
+	return uint32(math.Ceil(probe.Duration))
+}
+
+// Turn an attachment into Ogg/Opus plus its duration and waveform
+func prepareVoiceNote(att *Attachment) (ogg []byte, seconds uint32, waveform []byte, err error) {
+	ogg = att.Data
+	if !isOggOpus(att.Data) {
+		if ogg, err = transcodeToOpus(att.Data, audioExt(att)); err != nil {
+			return nil, 0, nil, fmt.Errorf("failed to transcode audio: %v", err)
+		}
+	}
+
+	seconds, fallbackWaveform, err := analyzeOggOpus(ogg)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to analyze voice note: %v", err)
+	}
+
+	waveform, err = computeWaveform(ogg, ".ogg")
+	if err != nil {
+		// Without ffmpeg, pre-encoded Opus still gets a synthetic waveform
+		waveform = fallbackWaveform
+	}
+	return ogg, seconds, waveform, nil
+}
+
+// Handle POST /api/messages/voice
+func (a *Account) HandleSendVoiceEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, att, err := parseSendMediaRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to, err := parseRecipient(req.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ogg, seconds, waveform, err := prepareVoiceNote(att)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !a.Client.IsConnected() {
+		writeSendResult(w, to, whatsmeow.SendResponse{}, errNotConnected)
+		return
+	}
+
+	resp, err := a.upload(ogg, whatsmeow.MediaAudio)
+	if err != nil {
+		writeSendResult(w, to, whatsmeow.SendResponse{}, fmt.Errorf("failed to upload voice note: %v", err))
+		return
+	}
+
+	msg := &waProto.Message{AudioMessage: &waProto.AudioMessage{
+		Mimetype:      proto.String("audio/ogg; codecs=opus"),
+		URL:           &resp.URL,
+		DirectPath:    &resp.DirectPath,
+		MediaKey:      resp.MediaKey,
+		FileEncSHA256: resp.FileEncSHA256,
+		FileSHA256:    resp.FileSHA256,
+		FileLength:    &resp.FileLength,
+		Seconds:       proto.Uint32(seconds),
+		PTT:           proto.Bool(true),
+		Waveform:      waveform,
+	}}
+	if req.QuotedMessageID != "" {
+		applyContextInfo(msg, a.quoteContext(to, req.QuotedMessageID))
+	}
+
+	sent, err := a.sendMessage(to, msg)
+	writeSendResult(w, to, sent, err)
+}
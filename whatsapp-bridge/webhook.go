@@ -0,0 +1,560 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Auth lifecycle events sent to the webhook
+const (
+	WebhookEventQRGenerated    = "qr_generated"
+	WebhookEventPaired         = "paired"
+	WebhookEventLoggedOut      = "logged_out"
+	WebhookEventStreamReplaced = "stream_replaced"
+)
+
+// Message events sent to the webhook
+const (
+	WebhookEventMessage            = "message"
+	WebhookEventReceipt            = "receipt"
+	WebhookEventReaction           = "reaction"
+	WebhookEventScheduledSent      = "scheduled_message_sent"
+	WebhookEventScheduledFailed    = "scheduled_message_failed"
+	WebhookEventBroadcastCompleted = "broadcast_completed"
+)
+
+// Deliveries are retried with exponential backoff before landing in the dead-letter table
+const (
+	maxWebhookAttempts   = 6
+	webhookRetryBaseWait = time.Second
+)
+
+// ID of the webhook configured with --webhook-url
+const configWebhookID = "config"
+
+// WebhookPayload is the JSON body POSTed to the webhook URL
+type WebhookPayload struct {
+	ID        string                 `json:"id"`
+	Event     string                 `json:"event"`
+	AccountID string                 `json:"account_id"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// WebhookTarget is a URL that receives events, optionally only some of them
+type WebhookTarget struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events,omitempty"`
+	Signed    bool      `json:"signed"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	secret    string
+}
+
+// CreateWebhookRequest represents the request body for registering a webhook
+type CreateWebhookRequest struct {
+	URL string `json:"url"`
+	// Event types to deliver; empty or "*" delivers everything
+	Events []string `json:"events"`
+	// Shared secret for the X-Webhook-Signature header
+	Secret string `json:"secret"`
+}
+
+// WebhookResponse represents the response for the webhook management APIs
+type WebhookResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message"`
+	Webhook *WebhookTarget `json:"webhook,omitempty"`
+}
+
+// DeadLetter is a delivery that failed every retry
+type DeadLetter struct {
+	ID        int64           `json:"id"`
+	WebhookID string          `json:"webhook_id"`
+	URL       string          `json:"url"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+	FailedAt  time.Time       `json:"failed_at"`
+}
+
+// WebhookNotifier POSTs events to every webhook subscribed to them
+type WebhookNotifier struct {
+	client  *http.Client
+	db      *sql.DB
+	mu      sync.RWMutex
+	targets []*WebhookTarget
+	hub     *EventHub
+	// Closed while draining so retries stop backing off
+	hurry chan struct{}
+}
+
+// Report whether a webhook wants an event
+func (t *WebhookTarget) wants(event string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, e := range t.Events {
+		if e == "*" || e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Split a comma separated event list
+func parseEventList(value string) []string {
+	var events []string
+	for _, event := range strings.Split(value, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Create a notifier with the webhook from the config plus those registered
+// through the API, which live in webhooks.db in the given directory
+func NewWebhookNotifier(cfg *Config, dir string) (*WebhookNotifier, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %v", err)
+	}
+	db, err := openSQLite(filepath.Join(dir, "webhooks.db"), "_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open webhook database: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id TEXT PRIMARY KEY,
+			url TEXT,
+			events TEXT,
+			secret TEXT,
+			created_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id TEXT,
+			url TEXT,
+			event TEXT,
+			payload TEXT,
+			attempts INTEGER,
+			last_error TEXT,
+			failed_at TIMESTAMP
+		);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create webhook tables: %v", err)
+	}
+
+	n := &WebhookNotifier{
+		client: &http.Client{Timeout: 10 * time.Second},
+		db:     db,
+		hub:    NewEventHub(),
+		hurry:  make(chan struct{}),
+	}
+	if cfg.WebhookURL != "" {
+		n.targets = append(n.targets, &WebhookTarget{
+			ID:        configWebhookID,
+			URL:       cfg.WebhookURL,
+			Events:    parseEventList(cfg.WebhookEvents),
+			Signed:    cfg.WebhookSecret != "",
+			CreatedAt: time.Now(),
+			secret:    cfg.WebhookSecret,
+		})
+	}
+
+	rows, err := db.Query("SELECT id, url, events, secret, created_at FROM webhooks ORDER BY created_at")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load webhooks: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var target WebhookTarget
+		var events string
+		if err := rows.Scan(&target.ID, &target.URL, &events, &target.secret, &target.CreatedAt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to load webhooks: %v", err)
+		}
+		target.Events = parseEventList(events)
+		target.Signed = target.secret != ""
+		n.targets = append(n.targets, &target)
+	}
+	return n, rows.Err()
+}
+
+// Close releases the webhook database
+func (n *WebhookNotifier) Close() error {
+	return n.db.Close()
+}
+
+// Snapshot of the current targets
+func (n *WebhookNotifier) Targets() []*WebhookTarget {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return append([]*WebhookTarget(nil), n.targets...)
+}
+
+// Register a webhook and start delivering to it
+func (n *WebhookNotifier) AddTarget(target *WebhookTarget) error {
+	_, err := n.db.Exec(
+		"INSERT INTO webhooks (id, url, events, secret, created_at) VALUES (?, ?, ?, ?, ?)",
+		target.ID, target.URL, strings.Join(target.Events, ","), target.secret, target.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	n.targets = append(n.targets, target)
+	n.mu.Unlock()
+	return nil
+}
+
+// Remove a webhook registered through the API
+func (n *WebhookNotifier) RemoveTarget(id string) (bool, error) {
+	result, err := n.db.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return false, nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i, target := range n.targets {
+		if target.ID == id {
+			n.targets = append(n.targets[:i], n.targets[i+1:]...)
+			break
+		}
+	}
+	return true, nil
+}
+
+// Notify sends an event to live event streams and, in the background so the
+// WhatsApp event loop is never blocked, to webhooks
+func (n *WebhookNotifier) Notify(accountID, event string, data map[string]interface{}) {
+	if n == nil {
+		return
+	}
+	payload := WebhookPayload{
+		ID:        newJobID(),
+		Event:     event,
+		AccountID: accountID,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+	n.hub.Publish(payload)
+	for _, target := range n.Targets() {
+		if target.wants(event) {
+			go n.deliver(target, payload)
+		}
+	}
+}
+
+// Deliver a payload, backing off exponentially between attempts and
+// recording a dead letter once every attempt has failed
+func (n *WebhookNotifier) deliver(target *WebhookTarget, payload WebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		bridgeLog.Errorf("Failed to encode %s webhook: %v", payload.Event, err)
+		return
+	}
+
+	bridgeMetrics.webhookStarted()
+	defer bridgeMetrics.webhookFinished()
+
+	wait := webhookRetryBaseWait
+	for attempt := 1; ; attempt++ {
+		err = n.post(target, payload.Event, payload.ID, body)
+		if err == nil {
+			bridgeMetrics.Webhooks.Inc(payload.Event, WebhookOutcomeDelivered)
+			return
+		}
+		if attempt == maxWebhookAttempts {
+			break
+		}
+		bridgeMetrics.Webhooks.Inc(payload.Event, WebhookOutcomeRetried)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-n.retryWait():
+		}
+		timer.Stop()
+		wait *= 2
+	}
+
+	bridgeLog.Warnf("Failed to deliver %s webhook to %s after %d attempts: %v", payload.Event, target.URL, maxWebhookAttempts, err)
+	bridgeMetrics.Webhooks.Inc(payload.Event, WebhookOutcomeDeadLetter)
+	_, dbErr := n.db.Exec(
+		`INSERT INTO webhook_dead_letters (webhook_id, url, event, payload, attempts, last_error, failed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		target.ID, target.URL, payload.Event, string(body), maxWebhookAttempts, err.Error(), time.Now().UTC(),
+	)
+	if dbErr != nil {
+		bridgeLog.Errorf("Failed to record dead letter: %v", dbErr)
+	}
+}
+
+// Channel that is closed while retries should skip their backoff
+func (n *WebhookNotifier) retryWait() <-chan struct{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.hurry
+}
+
+// Make the remaining attempts of every delivery right away and wait until
+// each is delivered or dead-lettered, returning how many are still pending
+// when ctx ends. Retries back off again after Resume.
+func (n *WebhookNotifier) Flush(ctx context.Context) int {
+	n.mu.Lock()
+	select {
+	case <-n.hurry:
+	default:
+		close(n.hurry)
+	}
+	n.mu.Unlock()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		pending := int(atomic.LoadInt64(&bridgeMetrics.webhooksPending))
+		if pending == 0 {
+			return 0
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return pending
+		}
+	}
+}
+
+// Back off between retries again after a drain
+func (n *WebhookNotifier) Resume() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	select {
+	case <-n.hurry:
+		n.hurry = make(chan struct{})
+	default:
+	}
+}
+
+// Sign a body with HMAC-SHA256 in the sha256=<hex> format
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver a single payload
+func (n *WebhookNotifier) post(target *WebhookTarget, event, deliveryID string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "whatsapp-bridge")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-ID", deliveryID)
+	if target.secret != "" {
+		req.Header.Set("X-Webhook-Signature", signWebhook(target.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// List dead letters, newest first
+func (n *WebhookNotifier) DeadLetters() ([]DeadLetter, error) {
+	rows, err := n.db.Query(
+		"SELECT id, webhook_id, url, event, payload, attempts, last_error, failed_at FROM webhook_dead_letters ORDER BY id DESC",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := []DeadLetter{}
+	for rows.Next() {
+		var letter DeadLetter
+		var payload string
+		if err := rows.Scan(&letter.ID, &letter.WebhookID, &letter.URL, &letter.Event, &payload, &letter.Attempts, &letter.LastError, &letter.FailedAt); err != nil {
+			return nil, err
+		}
+		letter.Payload = json.RawMessage(payload)
+		letters = append(letters, letter)
+	}
+	return letters, rows.Err()
+}
+
+// Try a dead letter once more, removing it if the delivery succeeds
+func (n *WebhookNotifier) RetryDeadLetter(id int64) error {
+	var webhookID, targetURL, event, payload string
+	err := n.db.QueryRow(
+		"SELECT webhook_id, url, event, payload FROM webhook_dead_letters WHERE id = ?", id,
+	).Scan(&webhookID, &targetURL, &event, &payload)
+	if err != nil {
+		return err
+	}
+
+	// Sign with the webhook's current secret if it still exists
+	target := &WebhookTarget{ID: webhookID, URL: targetURL}
+	for _, t := range n.Targets() {
+		if t.ID == webhookID {
+			target = t
+			break
+		}
+	}
+
+	var delivery WebhookPayload
+	json.Unmarshal([]byte(payload), &delivery)
+	if err := n.post(target, event, delivery.ID, []byte(payload)); err != nil {
+		n.db.Exec(
+			"UPDATE webhook_dead_letters SET attempts = attempts + 1, last_error = ?, failed_at = ? WHERE id = ?",
+			err.Error(), time.Now().UTC(), id,
+		)
+		return fmt.Errorf("delivery failed again: %v", err)
+	}
+	_, err = n.db.Exec("DELETE FROM webhook_dead_letters WHERE id = ?", id)
+	return err
+}
+
+// Register the /api/webhooks endpoints
+func (n *WebhookNotifier) registerHandlers() {
+	// List and register webhooks
+	http.HandleFunc("/api/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(n.Targets())
+
+		case http.MethodPost:
+			var req CreateWebhookRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			parsed, err := url.Parse(req.URL)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				http.Error(w, "url must be an http or https URL", http.StatusBadRequest)
+				return
+			}
+
+			target := &WebhookTarget{
+				ID:        newJobID(),
+				URL:       req.URL,
+				Events:    parseEventList(strings.Join(req.Events, ",")),
+				Signed:    req.Secret != "",
+				CreatedAt: time.Now(),
+				secret:    req.Secret,
+			}
+			if err := n.AddTarget(target); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(WebhookResponse{Success: false, Message: fmt.Sprintf("Failed to register webhook: %v", err)})
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(WebhookResponse{Success: true, Message: "Webhook registered", Webhook: target})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Remove a webhook
+	http.HandleFunc("/api/webhooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := r.PathValue("id")
+		w.Header().Set("Content-Type", "application/json")
+
+		if id == configWebhookID {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(WebhookResponse{Success: false, Message: "The webhook from --webhook-url can only be removed from the config"})
+			return
+		}
+		removed, err := n.RemoveTarget(id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(WebhookResponse{Success: false, Message: fmt.Sprintf("Failed to remove webhook: %v", err)})
+			return
+		}
+		if !removed {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(WebhookResponse{Success: false, Message: fmt.Sprintf("Webhook %s not found", id)})
+			return
+		}
+		json.NewEncoder(w).Encode(WebhookResponse{Success: true, Message: fmt.Sprintf("Webhook %s removed", id)})
+	})
+
+	// Deliveries that exhausted their retries
+	http.HandleFunc("/api/webhooks/dead-letters", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		letters, err := n.DeadLetters()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list dead letters: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(letters)
+	})
+
+	// Redeliver a dead letter
+	http.HandleFunc("/api/webhooks/dead-letters/{id}/retry", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var id int64
+		if _, err := fmt.Sscan(r.PathValue("id"), &id); err != nil {
+			http.Error(w, "Invalid dead letter ID", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		err := n.RetryDeadLetter(id)
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(WebhookResponse{Success: false, Message: fmt.Sprintf("Dead letter %d not found", id)})
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(WebhookResponse{Success: false, Message: err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(WebhookResponse{Success: true, Message: fmt.Sprintf("Dead letter %d delivered", id)})
+	})
+}
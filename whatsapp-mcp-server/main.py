@@ -1,29 +1,645 @@
-from typing import List, Dict, Any, Optional
-from mcp.server.fastmcp import FastMCP
+import asyncio
+import base64
+import json
+from typing import List, Dict, Any, Literal, Optional, Set
+import httpx
+from mcp.server.fastmcp import Context, FastMCP
 from whatsapp_full import (
+    API_KEY,
+    BRIDGE_CA,
+    BRIDGE_URL,
+    list_accounts,
+    create_account,
+    delete_account,
+    list_webhooks,
+    create_webhook,
+    delete_webhook,
+    list_webhook_dead_letters,
+    retry_webhook_dead_letter,
     search_contacts,
+    get_contact,
+    check_phone_numbers,
+    subscribe_presence,
+    get_presence,
+    block_contact,
+    unblock_contact,
+    get_blocklist,
+    set_disappearing_messages,
+    archive_chat,
+    pin_chat,
+    mute_chat,
+    list_groups,
+    leave_group,
+    create_group,
+    update_group_participants,
+    add_group_participants,
+    remove_group_participant,
+    set_group_subject,
+    get_group_info,
+    update_group_settings,
+    get_group_invite_link,
+    resolve_group_invite,
+    join_group,
+    list_group_join_requests,
+    update_group_join_requests,
+    list_communities,
+    list_community_groups,
+    link_community_group,
+    post_community_announcement,
+    list_newsletters,
+    follow_newsletter,
+    unfollow_newsletter,
+    get_newsletter_messages,
+    react_to_newsletter_message,
+    mark_newsletter_viewed,
     list_messages,
+    search_messages,
     list_chats,
+    read_chat_history,
     get_chat,
     get_direct_chat_by_contact,
     get_contact_chats,
     get_last_interaction,
     get_message_context,
-    send_message,
+    send_message_to,
+    resolve_recipient,
+    send_typing,
+    mark_read,
+    get_message_receipts,
+    send_reaction,
+    edit_message,
+    delete_message,
+    forward_message,
+    star_message,
+    list_starred_messages,
+    list_labels,
+    get_label,
+    save_label,
+    delete_label,
+    label_chat,
+    label_message,
+    send_location,
+    stop_live_location,
+    send_contact,
+    send_poll,
+    send_buttons,
+    send_list,
+    get_poll_results,
+    get_catalog,
+    send_product_message,
+    send_catalog_message,
+    get_order,
+    schedule_message,
+    list_scheduled_messages,
+    cancel_scheduled_message,
+    broadcast_message,
+    get_broadcast,
+    list_outbox,
+    cancel_outbox_message,
+    send_sticker,
     send_file,
+    send_document,
+    send_media,
     send_audio_message,
     download_media,
+    fetch_media,
+    get_media_stats,
+    get_media_job,
+    set_media_retention,
     get_whatsapp_status,
+    get_device,
+    get_profile,
+    update_profile,
+    set_profile_photo,
+    get_sync_status,
+    request_history_sync,
     get_whatsapp_qr,
+    pair_phone,
     wait_for_whatsapp_connection
 )
 
 mcp = FastMCP("whatsapp")
 
+# Page sizes of list tools, so large chats and directories can't flood the context.
+# Every list tool takes limit and cursor and returns next_cursor while more remain.
+DEFAULT_PAGE_SIZE = 20
+MAX_PAGE_SIZE = 100
+
+def _page_size(limit: int) -> int:
+    return max(1, min(limit, MAX_PAGE_SIZE))
+
+def _offset_cursor(offset: int) -> str:
+    """Encode a position as a cursor, for lists the bridge pages by offset or not at all."""
+    return base64.urlsafe_b64encode(f"offset:{offset}".encode()).decode().rstrip("=")
+
+def _cursor_offset(cursor: Optional[str]) -> int:
+    """Decode a cursor made by _offset_cursor; no cursor means the first page."""
+    if not cursor:
+        return 0
+    try:
+        kind, _, value = base64.urlsafe_b64decode(cursor + "=" * (-len(cursor) % 4)).decode().partition(":")
+        if kind == "offset" and int(value) >= 0:
+            return int(value)
+    except (ValueError, UnicodeDecodeError):
+        pass
+    raise ValueError("Invalid cursor; pass the next_cursor of a previous page unchanged")
+
+def _paged(key: str, items: List[Any], offset: int, limit: int, **fields) -> Dict[str, Any]:
+    """Cut a page from items, which may hold more than one page past offset."""
+    result = {key: items[:limit], **fields}
+    if len(items) > limit:
+        result["next_cursor"] = _offset_cursor(offset + limit)
+    return result
+
+def _compact(value: Any) -> str:
+    """Serialize tool output as JSON without whitespace, which costs the model fewer tokens."""
+    return json.dumps(value, separators=(",", ":"), ensure_ascii=False)
+
+def _short_time(timestamp: Optional[str]) -> Optional[str]:
+    """Trim an ISO 8601 timestamp to the minute."""
+    if not timestamp:
+        return None
+    return timestamp.replace("T", " ")[:16]
+
+def _media_placeholder(message: Dict[str, Any]) -> str:
+    """Describe a message's media in a few words, e.g. [voice note 0:12] or [document: report.pdf]."""
+    media_type = message.get("media_type") or message.get("message_type") or "media"
+    if media_type == "audio":
+        media_type = "voice note" if not message.get("filename") else "audio"
+    details = []
+    if message.get("filename") and media_type != "voice note":
+        details.append(message["filename"])
+    seconds = message.get("duration_seconds")
+    if seconds:
+        details.append(f"{seconds // 60}:{seconds % 60:02d}")
+    return f"[{media_type}: {', '.join(details)}]" if details else f"[{media_type}]"
+
+def _compact_message(message: Dict[str, Any]) -> Dict[str, Any]:
+    """Keep only what a model needs to follow a conversation."""
+    text = message.get("content") or ""
+    if message.get("media_type"):
+        text = " ".join(part for part in (_media_placeholder(message), message.get("caption") or text) if part)
+    compact = {
+        "id": message.get("id"),
+        "t": _short_time(message.get("timestamp")),
+        "from": "me" if message.get("is_from_me") else (message.get("push_name") or message.get("sender")),
+        "text": text,
+        "reply_to": message.get("quoted_message_id"),
+        "fwd": message.get("is_forwarded") or None
+    }
+    return {k: v for k, v in compact.items() if v}
+
+def _compact_chat(chat: Dict[str, Any]) -> Dict[str, Any]:
+    """Summarize a chat list entry in a few fields."""
+    compact = {
+        "jid": chat.get("jid"),
+        "name": chat.get("name"),
+        "group": chat.get("is_group") or None,
+        "unread": chat.get("unread_count") or None,
+        "pinned": chat.get("pinned") or None,
+        "archived": chat.get("archived") or None,
+        "muted": chat.get("muted") or None
+    }
+    last = chat.get("last_message")
+    if last:
+        text = last.get("text") or _media_placeholder(last)
+        sender = "me" if last.get("is_from_me") else last.get("sender")
+        compact["last"] = f"{sender}: {text}" if sender else text
+        compact["t"] = _short_time(last.get("timestamp"))
+    return {k: v for k, v in compact.items() if v}
+
+@mcp.tool()
+def list_accounts_tool() -> List[Dict[str, Any]]:
+    """List the WhatsApp accounts managed by the bridge."""
+    return list_accounts()
+
+@mcp.tool()
+def create_account_tool(account_id: str) -> Dict[str, Any]:
+    """Add a WhatsApp account to the bridge and start pairing it."""
+    return create_account(account_id)
+
+@mcp.tool()
+def delete_account_tool(account_id: str) -> Dict[str, Any]:
+    """Unlink a WhatsApp account and delete its data from the bridge."""
+    return delete_account(account_id)
+
+@mcp.tool()
+def list_webhooks_tool() -> List[Dict[str, Any]]:
+    """List the webhooks the bridge delivers events to, with their event filters."""
+    return list_webhooks()
+
+@mcp.tool()
+def create_webhook_tool(url: str, events: Optional[List[str]] = None, secret: Optional[str] = None) -> Dict[str, Any]:
+    """Register a webhook URL. events filters the event types delivered (all if empty); with a secret each delivery is signed in the X-Webhook-Signature header."""
+    return create_webhook(url, events, secret)
+
+@mcp.tool()
+def delete_webhook_tool(webhook_id: str) -> Dict[str, Any]:
+    """Remove a webhook registered with create_webhook."""
+    return delete_webhook(webhook_id)
+
+@mcp.tool()
+def list_webhook_dead_letters_tool() -> List[Dict[str, Any]]:
+    """List webhook deliveries that still failed after every retry."""
+    return list_webhook_dead_letters()
+
+@mcp.tool()
+def retry_webhook_dead_letter_tool(dead_letter_id: int) -> Dict[str, Any]:
+    """Try delivering a failed webhook payload again. It is removed from the dead letters on success."""
+    return retry_webhook_dead_letter(dead_letter_id)
+
+@mcp.tool()
+def search_contacts_tool(
+    query: str,
+    limit: int = 10,
+    cursor: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Search WhatsApp contacts by saved name, push name, business name or phone number. Matching tolerates typos, missing accents and partial names, and results are ranked with a score from 0 to 1 and the name that matched, so you can tell several people called "Maria" apart before sending.
+
+    Args:
+        query: Name or number to look for
+        limit: Matches per page, up to 100
+        cursor: The next_cursor of a previous page
+    """
+    offset, limit = _cursor_offset(cursor), _page_size(limit)
+    matches = search_contacts(query, offset + limit + 1, account_id)
+    return _paged("matches", matches[offset:], offset, limit)
+
+@mcp.tool()
+def get_contact_tool(jid: str, refresh: bool = False, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get a WhatsApp contact's push name, business name and verified status.
+
+    Set refresh to fetch the verified business name and about text from WhatsApp first.
+    """
+    return get_contact(jid, refresh, account_id)
+
+@mcp.tool()
+def check_phone_numbers_tool(phone_numbers: List[str], account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Check which phone numbers are registered on WhatsApp before sending to them.
+
+    Returns the canonical JID of each registered number.
+    """
+    return check_phone_numbers(phone_numbers, account_id)
+
+@mcp.tool()
+def subscribe_presence_tool(jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Start tracking when a WhatsApp contact is online and when they were last seen."""
+    return subscribe_presence(jid, account_id)
+
+@mcp.tool()
+def get_presence_tool(jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get a contact's last known online status and last seen time.
+
+    Subscribe to the contact first. Contacts can hide their last seen time, which is reported as last_seen_hidden.
+    """
+    return get_presence(jid, account_id)
+
+@mcp.tool()
+def block_contact_tool(jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Block a WhatsApp contact so they can no longer message or call you."""
+    return block_contact(jid, account_id)
+
+@mcp.tool()
+def unblock_contact_tool(jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Unblock a previously blocked WhatsApp contact."""
+    return unblock_contact(jid, account_id)
+
+@mcp.tool()
+def get_blocklist_tool(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """List the JIDs of blocked WhatsApp contacts."""
+    return get_blocklist(account_id)
+
+@mcp.tool()
+def set_disappearing_messages_tool(chat_jid: str, timer: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Turn disappearing messages on or off in a WhatsApp chat or group.
+
+    Args:
+        chat_jid: The chat JID or phone number
+        timer: off, 24h, 7d or 90d
+    """
+    return set_disappearing_messages(chat_jid, timer, account_id)
+
+@mcp.tool()
+def archive_chat_tool(chat_jid: str, archived: bool = True, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Archive or unarchive a WhatsApp chat. The change syncs to the phone and other linked devices.
+
+    Args:
+        chat_jid: The chat JID or phone number
+        archived: False to unarchive
+    """
+    return archive_chat(chat_jid, archived, account_id)
+
+@mcp.tool()
+def pin_chat_tool(chat_jid: str, pinned: bool = True, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Pin or unpin a WhatsApp chat. At most 3 chats can be pinned. The change syncs to every device.
+
+    Args:
+        chat_jid: The chat JID or phone number
+        pinned: False to unpin
+    """
+    return pin_chat(chat_jid, pinned, account_id)
+
+@mcp.tool()
+def mute_chat_tool(
+    chat_jid: str,
+    muted: bool = True,
+    duration: int = 0,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Mute or unmute notifications for a WhatsApp chat. The change syncs to every device.
+
+    Args:
+        chat_jid: The chat JID or phone number
+        muted: False to unmute
+        duration: Seconds to mute for, e.g. 28800 for 8 hours; 0 mutes until unmuted
+    """
+    return mute_chat(chat_jid, muted, duration, account_id)
+
+@mcp.tool()
+def list_groups_tool(
+    limit: int = DEFAULT_PAGE_SIZE,
+    cursor: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """List the WhatsApp groups you are in, with participant counts and whether you are an admin.
+
+    Args:
+        limit: Groups per page, up to 100
+        cursor: The next_cursor of a previous page
+    """
+    offset, limit = _cursor_offset(cursor), _page_size(limit)
+    groups = list_groups(account_id)
+    return _paged("groups", groups.get("groups", [])[offset:], offset, limit, total=groups.get("total", 0))
+
+@mcp.tool()
+def leave_group_tool(group_jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Leave a WhatsApp group.
+
+    Args:
+        group_jid: The group JID (ending in @g.us)
+    """
+    return leave_group(group_jid, account_id)
+
+@mcp.tool()
+def create_group_tool(name: str, participants: List[str], account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Create a WhatsApp group with a name (up to 25 characters) and phone numbers (with country code) or JIDs of participants.
+
+    Returns the new group JID and whether each participant was added. Participants whose privacy
+    settings block direct adds are reported with invite_required and an invite code.
+    """
+    return create_group(name, participants, account_id)
+
+@mcp.tool()
+def update_group_participants_tool(
+    group_jid: str,
+    action: Literal["add", "remove", "promote", "demote"],
+    participants: List[str],
+    send_invites: bool = False,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Add, remove, promote or demote participants of a WhatsApp group you administer.
+
+    Args:
+        group_jid: The group JID (ending in @g.us)
+        action: One of add, remove, promote or demote
+        participants: Phone numbers or JIDs
+        send_invites: When adding, send an invite message to people whose privacy settings block direct adds
+    """
+    return update_group_participants(group_jid, action, participants, send_invites, account_id)
+
+@mcp.tool()
+def add_participants_tool(
+    group_jid: str,
+    participants: List[str],
+    send_invites: bool = True,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Add people to a WhatsApp group you administer. People whose privacy settings block direct adds get an invite message instead, unless send_invites is False.
+
+    Args:
+        group_jid: The group JID (ending in @g.us)
+        participants: Phone numbers with country code, or JIDs
+        send_invites: Invite people who can't be added directly
+    """
+    return add_group_participants(group_jid, participants, send_invites, account_id)
+
+@mcp.tool()
+def remove_participant_tool(group_jid: str, participant: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Remove someone from a WhatsApp group you administer.
+
+    Args:
+        group_jid: The group JID (ending in @g.us)
+        participant: Their phone number with country code, or JID
+    """
+    return remove_group_participant(group_jid, participant, account_id)
+
+@mcp.tool()
+def set_group_subject_tool(group_jid: str, subject: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Rename a WhatsApp group. Depending on its settings only admins may do this.
+
+    Args:
+        group_jid: The group JID (ending in @g.us)
+        subject: The new name, 1 to 25 characters
+    """
+    return set_group_subject(group_jid, subject, account_id)
+
+@mcp.tool()
+def get_group_info_tool(group_jid: str, refresh: bool = False, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get a WhatsApp group's subject, description, settings and participants.
+
+    Args:
+        group_jid: The group JID (ending in @g.us)
+        refresh: Fetch from WhatsApp instead of the local cache
+    """
+    return get_group_info(group_jid, refresh, account_id)
+
 @mcp.tool()
-def search_contacts_tool(query: str) -> List[Dict[str, Any]]:
-    """Search WhatsApp contacts by name or phone number."""
-    return search_contacts(query)
+def update_group_settings_tool(
+    group_jid: str,
+    name: Optional[str] = None,
+    topic: Optional[str] = None,
+    disappearing_timer: Optional[int] = None,
+    announce: Optional[bool] = None,
+    locked: Optional[bool] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Change a WhatsApp group's subject, description or settings. Only the given fields change.
+
+    Args:
+        group_jid: The group JID (ending in @g.us)
+        name: New subject, up to 25 characters
+        topic: New description; an empty string clears it
+        disappearing_timer: Seconds until messages disappear: 0 (off), 86400, 604800 or 7776000
+        announce: Only admins can send messages
+        locked: Only admins can edit the group info
+    """
+    return update_group_settings(group_jid, name, topic, disappearing_timer, announce, locked, account_id)
+
+@mcp.tool()
+def get_group_invite_link_tool(group_jid: str, revoke: bool = False, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the invite link of a WhatsApp group you administer.
+
+    Args:
+        group_jid: The group JID (ending in @g.us)
+        revoke: Invalidate the current link and return a new one
+    """
+    return get_group_invite_link(group_jid, revoke, account_id)
+
+@mcp.tool()
+def resolve_group_invite_tool(link: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Show which WhatsApp group an invite link leads to, without joining it.
+
+    Args:
+        link: An invite link (https://chat.whatsapp.com/...) or just its code
+    """
+    return resolve_group_invite(link, account_id)
+
+@mcp.tool()
+def join_group_tool(link: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Join a WhatsApp group with an invite link.
+
+    Args:
+        link: An invite link (https://chat.whatsapp.com/...) or just its code
+    """
+    return join_group(link, account_id)
+
+@mcp.tool()
+def list_group_join_requests_tool(group_jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """List pending requests to join a WhatsApp group that requires admin approval.
+
+    Args:
+        group_jid: The group JID (ending in @g.us)
+    """
+    return list_group_join_requests(group_jid, account_id)
+
+@mcp.tool()
+def update_group_join_requests_tool(
+    group_jid: str,
+    action: str,
+    participants: Optional[List[str]] = None,
+    all_pending: bool = False,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Approve or reject requests to join a WhatsApp group you administer.
+
+    Args:
+        group_jid: The group JID (ending in @g.us)
+        action: approve or reject
+        participants: Phone numbers or JIDs of the requesters
+        all_pending: Act on every pending request instead of the listed participants
+    """
+    return update_group_join_requests(group_jid, action, participants, all_pending, account_id)
+
+@mcp.tool()
+def list_communities_tool(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """List the WhatsApp communities you are in."""
+    return list_communities(account_id)
+
+@mcp.tool()
+def list_community_groups_tool(community_jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """List the groups linked to a WhatsApp community, including its announcement group.
+
+    Args:
+        community_jid: The community JID (ending in @g.us)
+    """
+    return list_community_groups(community_jid, account_id)
+
+@mcp.tool()
+def link_community_group_tool(
+    community_jid: str,
+    group_jid: str,
+    unlink: bool = False,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Link an existing group you administer to a WhatsApp community, or unlink it.
+
+    Args:
+        community_jid: The community JID (ending in @g.us)
+        group_jid: The group JID (ending in @g.us)
+        unlink: Remove the group from the community instead
+    """
+    return link_community_group(community_jid, group_jid, unlink, account_id)
+
+@mcp.tool()
+def post_community_announcement_tool(community_jid: str, body: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Post a message to a WhatsApp community's announcement group, which reaches all members.
+
+    Args:
+        community_jid: The community JID (ending in @g.us)
+        body: The message text
+    """
+    return post_community_announcement(community_jid, body, account_id)
+
+@mcp.tool()
+def list_newsletters_tool(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """List the WhatsApp channels you follow."""
+    return list_newsletters(account_id)
+
+@mcp.tool()
+def follow_newsletter_tool(
+    jid: Optional[str] = None,
+    invite: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Follow a WhatsApp channel.
+
+    Args:
+        jid: The channel JID (ending in @newsletter)
+        invite: A channel link (https://whatsapp.com/channel/...) or its code, instead of the JID
+    """
+    return follow_newsletter(jid, invite, account_id)
+
+@mcp.tool()
+def unfollow_newsletter_tool(jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Unfollow a WhatsApp channel.
+
+    Args:
+        jid: The channel JID (ending in @newsletter)
+    """
+    return unfollow_newsletter(jid, account_id)
+
+@mcp.tool()
+def get_newsletter_messages_tool(
+    jid: str,
+    limit: int = 20,
+    before: Optional[int] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Read posts of a WhatsApp channel, newest first, with view and reaction counts.
+
+    Args:
+        jid: The channel JID (ending in @newsletter)
+        limit: Number of posts to return (max 100)
+        before: The next_before value of the previous page, to get older posts
+    """
+    return get_newsletter_messages(jid, limit, before, account_id)
+
+@mcp.tool()
+def react_to_newsletter_message_tool(
+    jid: str,
+    server_id: int,
+    reaction: str,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """React to a WhatsApp channel post.
+
+    Args:
+        jid: The channel JID (ending in @newsletter)
+        server_id: The post's server_id
+        reaction: An emoji; an empty string removes your reaction
+    """
+    return react_to_newsletter_message(jid, server_id, reaction, account_id)
+
+@mcp.tool()
+def mark_newsletter_viewed_tool(jid: str, server_ids: List[int], account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Mark WhatsApp channel posts as viewed, counting towards their views.
+
+    Args:
+        jid: The channel JID (ending in @newsletter)
+        server_ids: server_id values of the posts
+    """
+    return mark_newsletter_viewed(jid, server_ids, account_id)
 
 @mcp.tool()
 def list_messages_tool(
@@ -32,98 +648,898 @@ def list_messages_tool(
     sender_phone_number: Optional[str] = None,
     chat_jid: Optional[str] = None,
     query: Optional[str] = None,
-    limit: int = 20,
-    page: int = 0,
-    include_context: bool = True,
-    context_before: int = 1,
-    context_after: int = 1
-) -> List[Dict[str, Any]]:
-    """Get WhatsApp messages matching specified criteria."""
-    return list_messages(
-        after, before, sender_phone_number, chat_jid, query,
-        limit, page, include_context, context_before, context_after
-    )
+    limit: int = DEFAULT_PAGE_SIZE,
+    cursor: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> str:
+    """Get stored WhatsApp messages matching all the given filters, newest first, as compact JSON.
+
+    Args:
+        after: Only messages at or after this ISO 8601 time or YYYY-MM-DD date
+        before: Only messages before this time or date
+        sender_phone_number: Only messages from this number
+        chat_jid: Only messages in this chat
+        query: Only messages whose text or caption contains this
+        limit: Messages per page, up to 100
+        cursor: The next_cursor of a previous page
+    """
+    page = list_messages(after, before, sender_phone_number, chat_jid, query, _page_size(limit), cursor, account_id)
+    result = {"messages": [_compact_message(message) for message in page.get("messages", [])]}
+    if page.get("next_cursor"):
+        result["next_cursor"] = page["next_cursor"]
+    return _compact(result)
+
+@mcp.tool()
+def search_messages_tool(
+    query: str,
+    chat_jid: Optional[str] = None,
+    sender_phone_number: Optional[str] = None,
+    after: Optional[str] = None,
+    before: Optional[str] = None,
+    limit: int = DEFAULT_PAGE_SIZE,
+    cursor: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Full-text search over stored WhatsApp messages and media captions, ranked by relevance.
+
+    Every word must match; end a word with * to match prefixes. after and before take an
+    ISO 8601 time or a YYYY-MM-DD date. Each result has a snippet with the matches in [brackets].
+    Pass next_cursor from the response as cursor to get the next page (limit is up to 100).
+    """
+    offset, limit = _cursor_offset(cursor), _page_size(limit)
+    # One extra result tells whether there is another page
+    found = search_messages(query, chat_jid, sender_phone_number, after, before, limit + 1, offset, account_id)
+    return _paged("results", found.get("results") or [], offset, limit, query=found.get("query", query))
 
 @mcp.tool()
 def list_chats_tool(
     query: Optional[str] = None,
     limit: int = 20,
-    page: int = 0,
-    include_last_message: bool = True,
-    sort_by: str = "last_active"
-) -> List[Dict[str, Any]]:
-    """Get WhatsApp chats matching specified criteria."""
-    return list_chats(query, limit, page, include_last_message, sort_by)
+    cursor: Optional[str] = None,
+    archived: Optional[bool] = None,
+    account_id: Optional[str] = None
+) -> str:
+    """Get WhatsApp chats by last activity as compact JSON: jid, name, unread count, pinned/archived/muted flags and the last message as "sender: text".
+
+    Pass next_cursor from the response as cursor to get the next page (limit is up to 100). archived=False hides archived chats.
+    """
+    page = list_chats(query, _page_size(limit), cursor, archived, account_id)
+    result = {"chats": [_compact_chat(chat) for chat in page.get("chats", [])]}
+    if page.get("next_cursor"):
+        result["next_cursor"] = page["next_cursor"]
+    return _compact(result)
+
+@mcp.tool()
+def read_chat_history_tool(
+    chat_jid: str,
+    count: int = DEFAULT_PAGE_SIZE,
+    cursor: Optional[str] = None,
+    media_only: bool = False,
+    account_id: Optional[str] = None
+) -> str:
+    """Read the latest messages of a WhatsApp chat, oldest first, as compact JSON. Media shows as placeholders like [image: photo.jpg] followed by its caption; use download_media_tool with the message id to get the file.
+
+    Args:
+        chat_jid: The chat JID or phone number
+        count: How many messages to read, up to 100
+        cursor: The next_cursor of a previous call, to read further back
+        media_only: Only read messages carrying media
+    """
+    history = read_chat_history(chat_jid, _page_size(count), cursor, None, media_only, account_id)
+    result = {
+        "chat": history.get("chat_jid", chat_jid),
+        "messages": [_compact_message(message) for message in history.get("messages") or []]
+    }
+    if history.get("has_more") and history.get("before_cursor"):
+        result["next_cursor"] = history["before_cursor"]
+    return _compact(result)
 
 @mcp.tool()
-def get_chat_tool(chat_jid: str, include_last_message: bool = True) -> Dict[str, Any]:
+def get_chat_tool(chat_jid: str, include_last_message: bool = True, account_id: Optional[str] = None) -> Dict[str, Any]:
     """Get WhatsApp chat metadata by JID."""
-    return get_chat(chat_jid, include_last_message)
+    return get_chat(chat_jid, include_last_message, account_id)
 
 @mcp.tool()
-def get_direct_chat_by_contact_tool(sender_phone_number: str) -> Dict[str, Any]:
+def get_direct_chat_by_contact_tool(sender_phone_number: str, account_id: Optional[str] = None) -> Dict[str, Any]:
     """Get WhatsApp chat metadata by sender phone number."""
-    return get_direct_chat_by_contact(sender_phone_number)
+    return get_direct_chat_by_contact(sender_phone_number, account_id)
 
 @mcp.tool()
-def get_contact_chats_tool(jid: str, limit: int = 20, page: int = 0) -> List[Dict[str, Any]]:
+def get_contact_chats_tool(jid: str, limit: int = 20, page: int = 0, account_id: Optional[str] = None) -> List[Dict[str, Any]]:
     """Get all WhatsApp chats involving the contact."""
-    return get_contact_chats(jid, limit, page)
+    return get_contact_chats(jid, limit, page, account_id)
 
 @mcp.tool()
-def get_last_interaction_tool(jid: str) -> str:
+def get_last_interaction_tool(jid: str, account_id: Optional[str] = None) -> str:
     """Get most recent WhatsApp message involving the contact."""
-    return get_last_interaction(jid)
+    return get_last_interaction(jid, account_id)
 
 @mcp.tool()
 def get_message_context_tool(
     message_id: str,
     before: int = 5,
-    after: int = 5
+    after: int = 5,
+    account_id: Optional[str] = None
 ) -> Dict[str, Any]:
     """Get context around a specific WhatsApp message."""
-    return get_message_context(message_id, before, after)
+    return get_message_context(message_id, before, after, account_id)
+
+@mcp.tool()
+def send_message_tool(
+    recipient: str,
+    message: str,
+    quoted_message_id: Optional[str] = None,
+    mentioned_jids: Optional[List[str]] = None,
+    link_preview: bool = False,
+    simulate_typing: bool = False,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a WhatsApp message to a person or group, optionally as a reply to quoted_message_id. The recipient can be a phone number, a JID, a contact name or a group name; names are matched loosely, and the result's "resolved" field says who the message went to. If a name is ambiguous nothing is sent and the candidates are returned so you can ask the user or retry with a JID. Mention people with @phone in the message or by listing their JIDs or numbers in mentioned_jids. Set link_preview=True to attach a preview card for the first link, and simulate_typing=True to show "typing..." for a moment first. Returns the message ID, timestamp and normalized JID."""
+    return send_message_to(
+        recipient,
+        message,
+        account_id,
+        quoted_message_id=quoted_message_id,
+        mentioned_jids=mentioned_jids,
+        link_preview=link_preview,
+        simulate_typing=simulate_typing
+    )
+
+@mcp.tool()
+def resolve_recipient_tool(query: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Find which WhatsApp contact or group a phone number or (partial, misspelled) name refers to, without sending anything. "resolved" is set when the match is clear; otherwise pick from the ranked candidates."""
+    return resolve_recipient(query, account_id=account_id)
+
+@mcp.tool()
+def get_message_receipts_tool(message_id: str, chat_jid: Optional[str] = None, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get who a sent WhatsApp message was delivered to, read by or played by. status is the least progressed state across recipients."""
+    return get_message_receipts(message_id, chat_jid, account_id)
+
+@mcp.tool()
+def mark_read_tool(chat_jid: str, message_ids: Optional[List[str]] = None, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Mark WhatsApp messages as read (blue ticks). Without message_ids every unread message in the chat is marked. Returns the remaining unread count."""
+    return mark_read(chat_jid, message_ids, account_id)
+
+@mcp.tool()
+def send_typing_tool(chat_jid: str, state: str = "composing", account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Show "typing..." (composing) or "recording audio..." (recording) in a WhatsApp chat, or clear it (paused)."""
+    return send_typing(chat_jid, state, account_id)
+
+@mcp.tool()
+def send_reaction_tool(
+    message_id: str,
+    emoji: str,
+    chat_jid: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """React to a WhatsApp message with an emoji. Pass an empty emoji to remove the reaction."""
+    return send_reaction(message_id, emoji, chat_jid, account_id)
+
+@mcp.tool()
+def edit_message_tool(
+    message_id: str,
+    message: str,
+    chat_jid: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Edit the text of a WhatsApp message sent by this account within the last 15 minutes."""
+    return edit_message(message_id, message, chat_jid, account_id)
+
+@mcp.tool()
+def delete_message_tool(
+    message_id: str,
+    scope: str,
+    chat_jid: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Delete a WhatsApp message. scope="everyone" revokes it for all participants, scope="me" only removes the local copy."""
+    return delete_message(message_id, scope, chat_jid, account_id)
+
+@mcp.tool()
+def forward_message_tool(
+    message_id: str,
+    targets: List[str],
+    chat_jid: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Forward a WhatsApp message to one or more chats (JIDs or phone numbers). Media is forwarded without re-uploading."""
+    return forward_message(message_id, targets, chat_jid, account_id)
+
+@mcp.tool()
+def star_message_tool(
+    message_id: str,
+    starred: bool = True,
+    chat_jid: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Star (bookmark) or unstar a WhatsApp message. The star syncs to the phone and other linked devices.
+
+    Args:
+        message_id: The message ID
+        starred: False to unstar
+        chat_jid: The chat of the message, needed if it isn't in the local store
+    """
+    return star_message(message_id, starred, chat_jid, account_id)
+
+@mcp.tool()
+def list_starred_messages_tool(
+    chat_jid: Optional[str] = None,
+    limit: int = DEFAULT_PAGE_SIZE,
+    cursor: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """List starred (bookmarked) WhatsApp messages, newest first.
+
+    Args:
+        chat_jid: Only list stars in this chat
+        limit: Messages per page, up to 100
+        cursor: The next_cursor of a previous page, to read older stars
+    """
+    page = list_starred_messages(chat_jid, _page_size(limit), cursor, account_id)
+    result = {"messages": page.get("messages") or []}
+    if page.get("next_before"):
+        result["next_cursor"] = page["next_before"]
+    return result
+
+@mcp.tool()
+def list_labels_tool(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """List WhatsApp Business labels with their IDs, colors and how many chats and messages carry them."""
+    return list_labels(account_id)
+
+@mcp.tool()
+def get_label_tool(label_id: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get a WhatsApp Business label with the chats and messages it is on.
+
+    Args:
+        label_id: The label ID from list_labels
+    """
+    return get_label(label_id, account_id)
+
+@mcp.tool()
+def save_label_tool(
+    name: Optional[str] = None,
+    color: Optional[int] = None,
+    label_id: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Create a WhatsApp Business label, or rename or recolor one. Changes sync to every device.
+
+    Args:
+        name: The label name, required when creating
+        color: A color index from 0 to 19
+        label_id: The label to edit; leave out to create a new label
+    """
+    return save_label(name, color, label_id, account_id)
+
+@mcp.tool()
+def delete_label_tool(label_id: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Delete a WhatsApp Business label, removing it from every chat and message.
+
+    Args:
+        label_id: The label ID
+    """
+    return delete_label(label_id, account_id)
+
+@mcp.tool()
+def label_chat_tool(
+    label_id: str,
+    chat_jid: str,
+    labeled: bool = True,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Add or remove a WhatsApp Business label on a chat.
+
+    Args:
+        label_id: The label ID
+        chat_jid: The chat JID or phone number
+        labeled: False to remove the label
+    """
+    return label_chat(label_id, chat_jid, labeled, account_id)
+
+@mcp.tool()
+def label_message_tool(
+    label_id: str,
+    message_id: str,
+    chat_jid: Optional[str] = None,
+    labeled: bool = True,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Add or remove a WhatsApp Business label on a message.
+
+    Args:
+        label_id: The label ID
+        message_id: The message ID
+        chat_jid: The chat of the message, needed if it isn't in the local store
+        labeled: False to remove the label
+    """
+    return label_message(label_id, message_id, chat_jid, labeled, account_id)
+
+@mcp.tool()
+def send_location_tool(
+    recipient: str,
+    latitude: float,
+    longitude: float,
+    name: Optional[str] = None,
+    address: Optional[str] = None,
+    live: bool = False,
+    duration_seconds: Optional[int] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a location via WhatsApp. Set live=True to share a live location for duration_seconds (up to 8 hours)."""
+    return send_location(recipient, latitude, longitude, name, address, live, duration_seconds, account_id)
+
+@mcp.tool()
+def stop_live_location_tool(live_id: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Stop sharing a live location. live_id is the message ID returned when sharing started."""
+    return stop_live_location(live_id, account_id)
+
+@mcp.tool()
+def send_contact_tool(
+    recipient: str,
+    contacts: Optional[List[Dict[str, Any]]] = None,
+    vcards: Optional[List[str]] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send contact cards via WhatsApp. Each contact is {"name": ..., "phones": [...], "organization": ..., "email": ...}; raw vCard strings can be passed in vcards."""
+    return send_contact(recipient, contacts, vcards, account_id)
+
+@mcp.tool()
+def send_poll_tool(
+    recipient: str,
+    question: str,
+    options: List[str],
+    multi_select: bool = False,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Create a WhatsApp poll with 2-12 options. Set multi_select=True to allow picking several options."""
+    return send_poll(recipient, question, options, multi_select, account_id)
+
+@mcp.tool()
+def send_buttons_tool(
+    recipient: str,
+    body: str,
+    buttons: List[Dict[str, Any]],
+    header: Optional[str] = None,
+    footer: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a WhatsApp message with up to 3 buttons. Taps arrive as interactive_reply events.
+
+    Delivery of buttons depends on WhatsApp; some clients show them as plain text.
+
+    Args:
+        recipient: Phone number or JID to send to
+        body: The message text
+        buttons: Buttons like {"id": "yes", "text": "Yes"}; add "type": "url" with "url", or "type": "call" with "phone"
+        header: Optional header text
+        footer: Optional footer text
+    """
+    return send_buttons(recipient, body, buttons, header, footer, account_id)
+
+@mcp.tool()
+def send_list_tool(
+    recipient: str,
+    body: str,
+    button_text: str,
+    sections: List[Dict[str, Any]],
+    title: Optional[str] = None,
+    footer: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a WhatsApp list (menu) message. Choices arrive as interactive_reply events.
+
+    Args:
+        recipient: Phone number or JID to send to
+        body: The message text
+        button_text: Label of the button that opens the list
+        sections: Sections like {"title": "Sizes", "rows": [{"id": "s", "title": "Small", "description": "..."}]}, at most 10 rows in total
+        title: Optional title
+        footer: Optional footer text
+    """
+    return send_list(recipient, body, button_text, sections, title, footer, account_id)
+
+@mcp.tool()
+def get_poll_results_tool(message_id: str, chat_jid: Optional[str] = None, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the votes per option and who voted for a WhatsApp poll."""
+    return get_poll_results(message_id, chat_jid, account_id)
+
+@mcp.tool()
+def get_catalog_tool(
+    business_jid: str = "me",
+    limit: int = 20,
+    after: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Get the product catalog of a WhatsApp Business account.
+
+    Args:
+        business_jid: The business phone number or JID, or "me" for your own catalog
+        limit: Maximum number of products to return (up to 100)
+        after: The next_cursor value of a previous page
+    """
+    return get_catalog(business_jid, limit, after, account_id)
+
+@mcp.tool()
+def send_product_message_tool(
+    recipient: str,
+    product_id: str,
+    business_jid: Optional[str] = None,
+    body: Optional[str] = None,
+    footer: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a product card from a WhatsApp Business catalog.
+
+    Args:
+        recipient: Phone number or JID to send to
+        product_id: The product ID or retailer ID from get_catalog
+        business_jid: The business whose catalog has the product; defaults to your own
+        body: Optional text shown with the card
+        footer: Optional footer text
+    """
+    return send_product_message(recipient, product_id, business_jid, body, footer, account_id)
+
+@mcp.tool()
+def send_catalog_message_tool(
+    recipient: str,
+    business_jid: Optional[str] = None,
+    body: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Share a WhatsApp Business catalog link, which chats show as a catalog card.
+
+    Args:
+        recipient: Phone number or JID to send to
+        business_jid: The business whose catalog to share; defaults to your own
+        body: Optional text sent before the link
+    """
+    return send_catalog_message(recipient, business_jid, body, account_id)
+
+@mcp.tool()
+def get_order_tool(order_id: str, token: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the items and total of a WhatsApp Business order.
+
+    Args:
+        order_id: The order ID from an order event
+        token: The token from the same order event
+    """
+    return get_order(order_id, token, account_id)
+
+@mcp.tool()
+def schedule_message_tool(
+    recipient: str,
+    message: str,
+    send_at: str,
+    quoted_message_id: Optional[str] = None,
+    mentioned_jids: Optional[List[str]] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Schedule a WhatsApp text message. send_at is an RFC 3339 timestamp such as 2025-01-31T09:00:00+01:00. Returns the scheduled message with its ID."""
+    return schedule_message(recipient, message, send_at, quoted_message_id, mentioned_jids, account_id)
+
+@mcp.tool()
+def list_scheduled_messages_tool(status: Optional[str] = None, account_id: Optional[str] = None) -> List[Dict[str, Any]]:
+    """List scheduled WhatsApp messages. status filters by pending, sent, failed or cancelled."""
+    return list_scheduled_messages(status, account_id)
+
+@mcp.tool()
+def cancel_scheduled_message_tool(scheduled_id: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Cancel a scheduled WhatsApp message that has not been sent yet."""
+    return cancel_scheduled_message(scheduled_id, account_id)
+
+@mcp.tool()
+def broadcast_message_tool(
+    recipients: List[str],
+    message: str,
+    delay_ms: Optional[int] = None,
+    jitter_ms: Optional[int] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send the same WhatsApp text message to many recipients in the background. Messages are spaced by delay_ms (default 3000) plus up to jitter_ms (default 2000) of random delay. Returns a broadcast ID for get_broadcast."""
+    return broadcast_message(recipients, message, delay_ms, jitter_ms, account_id)
+
+@mcp.tool()
+def get_broadcast_tool(broadcast_id: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the progress of a WhatsApp broadcast and the delivery result for each recipient."""
+    return get_broadcast(broadcast_id, account_id)
+
+@mcp.tool()
+def list_outbox_tool(status: Optional[str] = None, account_id: Optional[str] = None) -> List[Dict[str, Any]]:
+    """List WhatsApp messages queued while the bridge was disconnected. status filters by pending, sent, failed or cancelled."""
+    return list_outbox(status, account_id)
+
+@mcp.tool()
+def cancel_outbox_message_tool(message_id: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Cancel a queued WhatsApp message before the bridge reconnects and delivers it."""
+    return cancel_outbox_message(message_id, account_id)
+
+@mcp.tool()
+def send_sticker_tool(
+    recipient: str,
+    media_path: Optional[str] = None,
+    url: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a sticker via WhatsApp from a local file or URL. PNG and JPEG images are converted to 512x512 WebP; WebP (including animated) is sent as is."""
+    return send_sticker(recipient, media_path, url, account_id)
 
 @mcp.tool()
-def send_message_tool(recipient: str, message: str) -> Dict[str, Any]:
-    """Send a WhatsApp message to a person or group."""
-    success, status_message = send_message(recipient, message)
-    return {"success": success, "message": status_message}
+def send_file_tool(
+    recipient: str,
+    media_path: Optional[str] = None,
+    caption: Optional[str] = None,
+    url: Optional[str] = None,
+    quoted_message_id: Optional[str] = None,
+    view_once: bool = False,
+    mentioned_jids: Optional[List[str]] = None,
+    original: bool = False,
+    background: bool = False,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send an image, video, audio or document via WhatsApp from a local file path or a URL, with an optional caption and quoted_message_id to reply to. Animated GIFs are sent as looping videos like WhatsApp's own GIFs. Set view_once=True for media that can be opened only once. Mention people in the caption with @phone or mentioned_jids. Set original=True to skip the bridge's image compression and video transcoding. Set background=True for large videos to get a job ID to check with get_media_job_tool instead of waiting."""
+    return send_file(recipient, media_path, caption, url, quoted_message_id, view_once, mentioned_jids, original, background, account_id)
 
 @mcp.tool()
-def send_file_tool(recipient: str, media_path: str) -> Dict[str, Any]:
-    """Send a file via WhatsApp."""
-    success, status_message = send_file(recipient, media_path)
-    return {"success": success, "message": status_message}
+def send_document_tool(
+    recipient: str,
+    media_path: Optional[str] = None,
+    url: Optional[str] = None,
+    filename: Optional[str] = None,
+    caption: Optional[str] = None,
+    quoted_message_id: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a file as a WhatsApp document rather than inline media, so images and videos arrive uncompressed and every file keeps its name. PDFs show their page count and first page.
+
+    Args:
+        recipient: Phone number or JID to send to
+        media_path: Path of a file in WHATSAPP_MEDIA_DIR; give this or url
+        url: http(s) URL to fetch the file from
+        filename: Name recipients see, defaulting to the file's own name
+        caption: Optional text shown under the document
+        quoted_message_id: ID of a message to reply to
+    """
+    return send_document(recipient, media_path, url, filename, caption, quoted_message_id, account_id)
+
+@mcp.tool()
+def send_media_tool(
+    recipient: str,
+    source: str,
+    caption: Optional[str] = None,
+    quoted_message_id: Optional[str] = None,
+    media_type: Optional[str] = None,
+    filename: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Share a file in one call: give an http(s) URL or a local path and it is downloaded, uploaded and sent as the right kind of WhatsApp media. The recipient can be a phone number, JID, contact name or group name, resolved as in send_message_tool.
+
+    Args:
+        recipient: Phone number, JID, contact name or group name to send to
+        source: http(s) URL of the media, or the path of a file in WHATSAPP_MEDIA_DIR
+        caption: Optional text shown with the media
+        quoted_message_id: ID of a message to reply to
+        media_type: image, video, audio or document; inferred from the file when omitted
+        filename: Name recipients see for documents, defaulting to the file's own name
+    """
+    return send_media(recipient, source, caption, quoted_message_id, media_type, filename, account_id)
 
 @mcp.tool()
-def send_audio_message_tool(recipient: str, media_path: str) -> Dict[str, Any]:
-    """Send an audio message via WhatsApp."""
-    success, status_message = send_audio_message(recipient, media_path)
-    return {"success": success, "message": status_message}
+def send_audio_message_tool(
+    recipient: str,
+    media_path: str,
+    quoted_message_id: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send an audio file (mp3, m4a, wav, ogg, ...) as a WhatsApp voice note."""
+    return send_audio_message(recipient, media_path, quoted_message_id, account_id)
 
 @mcp.tool()
-def download_media_tool(message_id: str, chat_jid: str) -> Dict[str, Any]:
-    """Download media from a WhatsApp message."""
-    file_path = download_media(message_id, chat_jid)
+def download_media_tool(
+    message_id: str,
+    chat_jid: Optional[str] = None,
+    save_dir: Optional[str] = None,
+    as_base64: bool = False,
+    max_bytes: Optional[int] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Download media from a WhatsApp message, even if it expired on WhatsApp's servers. Returns the saved file's path, or with as_base64=True the content itself so images and documents can be read without file access.
+
+    Args:
+        message_id: ID of the message carrying the media
+        chat_jid: Chat the message is in; looked up from the message ID when omitted
+        save_dir: Directory to save the file in (default: the system temp directory)
+        as_base64: Return the file as data_base64 with its mime_type instead of saving it
+        max_bytes: Largest file to return as base64 (default 2 MB); larger files are refused
+    """
+    if as_base64:
+        return fetch_media(message_id, chat_jid, max_bytes, account_id)
+    file_path = download_media(message_id, chat_jid, save_dir, account_id)
     if file_path:
         return {"success": True, "message": "Media downloaded", "file_path": file_path}
     return {"success": False, "message": "Failed to download media"}
 
 @mcp.tool()
-def get_whatsapp_status_tool() -> Dict[str, Any]:
+def get_media_stats_tool(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the disk space used by downloaded WhatsApp media, by chat and by media type, and the storage quota."""
+    return get_media_stats(account_id)
+
+@mcp.tool()
+def get_media_job_tool(job_id: Optional[str] = None, account_id: Optional[str] = None) -> Any:
+    """Check on media sent with background=True: its status (queued, processing, sent or failed), the sent message ID or the error.
+
+    Args:
+        job_id: The job ID returned by send_file_tool; lists all recent jobs when omitted
+    """
+    return get_media_job(job_id, account_id)
+
+@mcp.tool()
+def set_media_retention_tool(
+    chat_jid: str,
+    keep: bool = False,
+    max_age_days: int = 0,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Override how long a chat's downloaded media is kept. Leaving both settings off restores the default.
+
+    Args:
+        chat_jid: The chat JID or phone number
+        keep: Never evict this chat's media to stay under the storage quota
+        max_age_days: Delete media downloaded more than this many days ago (0 for no limit)
+    """
+    return set_media_retention(chat_jid, keep, max_age_days, account_id)
+
+@mcp.tool()
+def get_whatsapp_status_tool(account_id: Optional[str] = None) -> Dict[str, Any]:
     """Get WhatsApp connection status and QR code if not connected."""
-    return get_whatsapp_status()
+    return get_whatsapp_status(account_id)
 
 @mcp.tool()
-def get_whatsapp_qr_tool() -> Dict[str, Any]:
+def get_device_tool(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the JID, push name, platform and connection times of the linked WhatsApp device."""
+    return get_device(account_id)
+
+@mcp.tool()
+def get_profile_tool(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the display name, about text and profile photo ID of the linked WhatsApp account."""
+    return get_profile(account_id)
+
+@mcp.tool()
+def update_profile_tool(name: Optional[str] = None, about: Optional[str] = None, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Change the display name (up to 25 characters) and/or about text of the linked WhatsApp account."""
+    return update_profile(name, about, account_id)
+
+@mcp.tool()
+def set_profile_photo_tool(
+    media_path: Optional[str] = None,
+    url: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Set the profile photo of the linked WhatsApp account from a local file or URL.
+
+    The image is cropped to a centered square. Call without a path or URL to remove the photo.
+    """
+    return set_profile_photo(media_path, url, account_id)
+
+@mcp.tool()
+def get_sync_status_tool(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get how far the WhatsApp history backfill has got: state (waiting, syncing or complete), progress percentage and conversations and messages stored."""
+    return get_sync_status(account_id)
+
+@mcp.tool()
+def request_history_sync_tool(chat_jid: str, count: int = 50, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Fetch older WhatsApp messages of a chat from the phone, up to count messages before the oldest one stored. They arrive in the background; check get_sync_status."""
+    return request_history_sync(chat_jid, count, account_id)
+
+@mcp.resource("whatsapp://device")
+def device_resource() -> str:
+    """Linked device metadata for the default account."""
+    return json.dumps(get_device())
+
+@mcp.resource("whatsapp://accounts/{account_id}/device")
+def account_device_resource(account_id: str) -> str:
+    """Linked device metadata for a specific account."""
+    return json.dumps(get_device(account_id))
+
+def _chats_resource(account_id: Optional[str] = None) -> str:
+    page = list_chats(limit=50, archived=False, account_id=account_id)
+    return _compact({"chats": [_compact_chat(chat) for chat in page.get("chats", [])]})
+
+def _recent_resource(jid: str, account_id: Optional[str] = None) -> str:
+    # Clients reread resources on every update; that isn't the user reading them
+    history = read_chat_history(jid, 30, account_id=account_id, mark_as_read=False)
+    return _compact({
+        "chat": history.get("chat_jid", jid),
+        "messages": [_compact_message(message) for message in history.get("messages") or []]
+    })
+
+@mcp.resource("whatsapp://chats")
+def chats_resource() -> str:
+    """The 50 most recently active unarchived chats of the default account."""
+    return _chats_resource()
+
+@mcp.resource("whatsapp://chat/{jid}/recent")
+def recent_messages_resource(jid: str) -> str:
+    """The last 30 messages of a chat of the default account, oldest first."""
+    return _recent_resource(jid)
+
+@mcp.resource("whatsapp://accounts/{account_id}/chats")
+def account_chats_resource(account_id: str) -> str:
+    """The 50 most recently active unarchived chats of a specific account."""
+    return _chats_resource(account_id)
+
+@mcp.resource("whatsapp://accounts/{account_id}/chat/{jid}/recent")
+def account_recent_messages_resource(account_id: str, jid: str) -> str:
+    """The last 30 messages of a chat of a specific account, oldest first."""
+    return _recent_resource(jid, account_id)
+
+# Sessions subscribed to each resource URI
+_resource_subscribers: Dict[str, Set[Any]] = {}
+# Sessions pushed new messages, with the account and chat each is limited to
+_message_subscribers: Dict[Any, tuple] = {}
+_event_watcher: Optional[asyncio.Task] = None
+
+def _start_event_watcher() -> None:
+    """Follow the bridge's events, unless already doing so for another subscriber."""
+    global _event_watcher
+    if _event_watcher is None or _event_watcher.done():
+        _event_watcher = asyncio.create_task(_watch_message_events())
+
+@mcp._mcp_server.subscribe_resource()
+async def subscribe_resource(uri) -> None:
+    _resource_subscribers.setdefault(str(uri), set()).add(mcp._mcp_server.request_context.session)
+    _start_event_watcher()
+
+@mcp._mcp_server.unsubscribe_resource()
+async def unsubscribe_resource(uri) -> None:
+    _resource_subscribers.get(str(uri), set()).discard(mcp._mcp_server.request_context.session)
+
+# The low-level server doesn't advertise subscriptions even with handlers registered
+_get_capabilities = mcp._mcp_server.get_capabilities
+
+def _get_capabilities_with_subscriptions(*args, **kwargs):
+    capabilities = _get_capabilities(*args, **kwargs)
+    if capabilities.resources:
+        capabilities.resources.subscribe = True
+    return capabilities
+
+mcp._mcp_server.get_capabilities = _get_capabilities_with_subscriptions
+
+async def _notify_resource_updated(uri: str) -> None:
+    """Tell a resource's subscribers it changed, forgetting sessions that went away."""
+    for session in list(_resource_subscribers.get(uri, ())):
+        try:
+            await session.send_resource_updated(uri)
+        except Exception:
+            _resource_subscribers[uri].discard(session)
+
+async def _push_message(event: Dict[str, Any]) -> None:
+    """Send a new message to the sessions subscribed to its chat as a log notification."""
+    message = event.get("data") or {}
+    for session, (account_id, chat_jid) in list(_message_subscribers.items()):
+        if (account_id and account_id != event.get("account_id")) or (chat_jid and chat_jid != message.get("chat_jid")):
+            continue
+        try:
+            await session.send_log_message(level="info", logger="whatsapp.messages", data={
+                "account": event.get("account_id"),
+                "chat": message.get("chat_jid"),
+                "id": message.get("id"),
+                "sender": message.get("push_name") or message.get("sender"),
+                "from_me": message.get("is_from_me", False),
+                "text": message.get("content") or message.get("caption") or None,
+                "media": message.get("media_type") or None,
+                "time": message.get("timestamp")
+            })
+        except Exception:
+            _message_subscribers.pop(session, None)
+
+async def _watch_message_events() -> None:
+    """Follow the bridge's event stream, pushing new messages to their subscribers and
+    notifying subscribers of the chat resources they change."""
+    last_event_id = None
+    auth = {"Authorization": f"Bearer {API_KEY}"} if API_KEY else {}
+    async with httpx.AsyncClient(timeout=httpx.Timeout(10, read=None), headers=auth, verify=BRIDGE_CA or True) as client:
+        while _message_subscribers or any(_resource_subscribers.values()):
+            headers = {"Last-Event-ID": last_event_id} if last_event_id else {}
+            try:
+                async with client.stream("GET", f"{BRIDGE_URL}/api/events/sse", params={"events": "message"}, headers=headers) as response:
+                    async for line in response.aiter_lines():
+                        if line.startswith("id:"):
+                            last_event_id = line[3:].strip()
+                        elif line.startswith("data:"):
+                            event = json.loads(line[5:])
+                            await _push_message(event)
+                            account_id = event.get("account_id")
+                            chat_jid = (event.get("data") or {}).get("chat_jid")
+                            uris = ["whatsapp://chats", f"whatsapp://accounts/{account_id}/chats"]
+                            if chat_jid:
+                                uris += [f"whatsapp://chat/{chat_jid}/recent", f"whatsapp://accounts/{account_id}/chat/{chat_jid}/recent"]
+                            for uri in uris:
+                                await _notify_resource_updated(uri)
+            except (httpx.HTTPError, json.JSONDecodeError):
+                pass
+            # The bridge may be restarting; resume where the stream left off
+            await asyncio.sleep(5)
+
+@mcp.tool()
+async def subscribe_messages_tool(ctx: Context, chat_jid: Optional[str] = None, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Have new WhatsApp messages pushed to this session as they arrive, instead of polling. Each arrives as a log notification (logger "whatsapp.messages") with the chat, sender, text and media type. Subscribing again replaces the previous filter.
+
+    Args:
+        chat_jid: Only push messages from this chat; all chats when omitted
+    """
+    _message_subscribers[ctx.session] = (account_id, chat_jid)
+    _start_event_watcher()
+    where = f"chat {chat_jid}" if chat_jid else "every chat"
+    return {"success": True, "message": f"New messages from {where} will be pushed to this session"}
+
+@mcp.tool()
+async def unsubscribe_messages_tool(ctx: Context) -> Dict[str, Any]:
+    """Stop pushing new WhatsApp messages to this session."""
+    if _message_subscribers.pop(ctx.session, None) is None:
+        return {"success": False, "message": "This session was not subscribed"}
+    return {"success": True, "message": "New messages will no longer be pushed"}
+
+@mcp.tool()
+def get_whatsapp_qr_tool(account_id: Optional[str] = None) -> Dict[str, Any]:
     """Get WhatsApp QR code for authentication."""
-    return get_whatsapp_qr()
+    return get_whatsapp_qr(account_id)
+
+@mcp.tool()
+def pair_phone_tool(phone_number: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get an 8-character pairing code to link WhatsApp by phone number instead of QR code."""
+    return pair_phone(phone_number, account_id)
 
 @mcp.tool()
-def wait_for_whatsapp_connection_tool(timeout: int = 60) -> Dict[str, Any]:
+def wait_for_whatsapp_connection_tool(timeout: int = 60, account_id: Optional[str] = None) -> Dict[str, Any]:
     """Wait for WhatsApp to be connected."""
-    return wait_for_whatsapp_connection(timeout)
+    return wait_for_whatsapp_connection(timeout, account_id)
+
+# Prompts for common workflows. Each names the tools to use so clients can run
+# them as they are.
+
+@mcp.prompt()
+def summarize_chat(chat: str, messages: int = 100) -> str:
+    """Summarize a WhatsApp chat: topics, decisions and open questions."""
+    return f"""Summarize my WhatsApp chat with {chat}.
+
+1. Find the chat with resolve_recipient_tool (skip this if "{chat}" is already a JID). If several chats match, ask me which one I mean.
+2. Read the last {messages} messages with read_chat_history_tool, paging back with next_cursor when one page isn't enough.
+3. Write a short summary: the main topics, anything decided, open questions and anything that still needs a reply from me. Mention who said what only where it matters.
+
+Don't send anything."""
+
+@mcp.prompt()
+def draft_reply(chat: str, intent: Optional[str] = None) -> str:
+    """Draft a reply to a WhatsApp chat in my own writing style, without sending it."""
+    goal = f"The reply should {intent}." if intent else "Reply to whatever is waiting on me."
+    return f"""Draft a reply to my WhatsApp chat with {chat}. {goal}
+
+1. Find the chat with resolve_recipient_tool (skip this if "{chat}" is already a JID). If several chats match, ask me which one I mean.
+2. Read the recent messages with read_chat_history_tool to see what I'm replying to.
+3. Study the messages I sent myself (is_from_me) in that chat: their length, tone, language, punctuation, emoji and how I greet people. Write the draft the same way.
+4. Show me the draft. Only send it with send_message_tool, quoting the message it answers, once I approve it."""
+
+@mcp.prompt()
+def catch_up_on_group(group: str) -> str:
+    """Catch me up on what happened in a WhatsApp group since I last read it."""
+    return f"""Catch me up on the WhatsApp group {group}.
+
+1. Find the group with resolve_recipient_tool (skip this if "{group}" is already a JID). If several chats match, ask me which one I mean.
+2. Check its unread count with get_chat_tool, then read at least that many recent messages with read_chat_history_tool.
+3. Tell me what I missed, grouped by topic: decisions, plans and dates, questions addressed to me or mentioning me, and shared media or links worth opening. Keep it brief and skip small talk.
+
+Don't send anything."""
+
+@mcp.prompt()
+def catch_me_up() -> str:
+    """Go through every unread WhatsApp chat and say what needs attention."""
+    return """Catch me up on WhatsApp.
+
+1. List my chats with list_chats_tool and pick the ones with unread messages.
+2. For each, read the unread messages with read_chat_history_tool.
+3. Give me one line per chat, most urgent first: who it is, what they want and whether I need to reply. Put chats that need nothing from me at the end.
+
+Don't send anything."""
 
 if __name__ == "__main__":
-    mcp.run(transport='stdio')
\ No newline at end of file
+    mcp.run(transport='stdio')
@@ -2,11 +2,42 @@
 WhatsApp bridge HTTP client implementation.
 """
 
+import base64
+import os
 import requests
+import tempfile
 import time
-from typing import List, Dict, Any, Optional, Tuple
+from email.message import Message
+from typing import List, Dict, Any, Optional
 
-BRIDGE_URL = "http://localhost:8080"
+BRIDGE_URL = os.environ.get("WHATSAPP_BRIDGE_URL", "http://localhost:8080").rstrip("/")
+
+# CA bundle trusted for bridges serving HTTPS with a private or self-signed certificate
+BRIDGE_CA = os.environ.get("WHATSAPP_BRIDGE_CA", "")
+
+# Key sent to bridges that require one, which needs the scopes of the tools used
+API_KEY = os.environ.get("WHATSAPP_API_KEY", "")
+
+# Every bridge request goes through one session so it carries the key
+_http = requests.Session()
+if API_KEY:
+    _http.headers["Authorization"] = f"Bearer {API_KEY}"
+if BRIDGE_CA:
+    _http.verify = BRIDGE_CA
+
+# Send read receipts for a chat whenever its messages are fetched
+AUTO_MARK_READ = os.environ.get("WHATSAPP_AUTO_MARK_READ", "").lower() in ("1", "true", "yes")
+
+# Largest media file returned inline as base64, so one file can't flood the model's context
+MAX_INLINE_MEDIA_BYTES = int(os.environ.get("WHATSAPP_MAX_INLINE_MEDIA_BYTES", str(2 * 1024 * 1024)))
+
+# Kinds of media the bridge can send
+MEDIA_TYPES = ("image", "video", "audio", "document")
+
+# Directory local files may be sent from. Tools refuse paths outside it, and
+# all local paths when it is unset, so a prompt-injected message can't have
+# the model send files such as ~/.ssh/id_rsa
+MEDIA_DIR = os.environ.get("WHATSAPP_MEDIA_DIR", "")
 
 def _check_response(response):
     """Raise exception if response is not successful."""
@@ -14,41 +45,509 @@ def _check_response(response):
         raise Exception(f"Bridge error: {response.status_code} - {response.text}")
     return response.json()
 
-def get_whatsapp_status() -> Dict[str, Any]:
+def _send_result(response) -> Dict[str, Any]:
+    """Return the JSON result of a send, including failures reported as plain text."""
+    if response.headers.get("Content-Type", "").startswith("application/json"):
+        return response.json()
+    return {"success": False, "message": response.text.strip()}
+
+def _local_file(path: str):
+    """Resolve a local file to send, returning its path or the failure result to give back instead.
+
+    Relative paths are taken from MEDIA_DIR, and links can't lead out of it."""
+    if not MEDIA_DIR:
+        return None, {"success": False, "message": "Sending local files is disabled; set WHATSAPP_MEDIA_DIR to the directory they may be sent from"}
+    root = os.path.realpath(os.path.expanduser(MEDIA_DIR))
+    resolved = os.path.realpath(os.path.join(root, os.path.expanduser(path)))
+    if os.path.commonpath([root, resolved]) != root:
+        return None, {"success": False, "message": f"{path} is outside WHATSAPP_MEDIA_DIR ({root})"}
+    if not os.path.isfile(resolved):
+        return None, {"success": False, "message": f"No file at {path}"}
+    return resolved, None
+
+def _params(account_id: Optional[str] = None, **params) -> Dict[str, Any]:
+    """Build query parameters, adding the target account and dropping unset values."""
+    params["account_id"] = account_id
+    return {k: v for k, v in params.items() if v is not None}
+
+def list_accounts() -> List[Dict[str, Any]]:
+    """List accounts managed by the bridge."""
+    response = _http.get(f"{BRIDGE_URL}/api/accounts")
+    return _check_response(response)
+
+def create_account(account_id: str) -> Dict[str, Any]:
+    """Create a new account and start pairing it."""
+    response = _http.post(f"{BRIDGE_URL}/api/accounts", json={"id": account_id})
+    if response.status_code != 201:
+        raise Exception(f"Bridge error: {response.status_code} - {response.text}")
+    return response.json()
+
+def delete_account(account_id: str) -> Dict[str, Any]:
+    """Unlink an account and delete its data."""
+    response = _http.delete(f"{BRIDGE_URL}/api/accounts/{account_id}")
+    return _check_response(response)
+
+def list_webhooks() -> List[Dict[str, Any]]:
+    """List the webhooks events are delivered to."""
+    response = _http.get(f"{BRIDGE_URL}/api/webhooks")
+    return _check_response(response)
+
+def create_webhook(url: str, events: Optional[List[str]] = None, secret: Optional[str] = None) -> Dict[str, Any]:
+    """Register a webhook for some or all event types."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/webhooks",
+        json={"url": url, "events": events or [], "secret": secret or ""}
+    )
+    return _send_result(response)
+
+def delete_webhook(webhook_id: str) -> Dict[str, Any]:
+    """Remove a webhook registered through the API."""
+    response = _http.delete(f"{BRIDGE_URL}/api/webhooks/{webhook_id}")
+    return _send_result(response)
+
+def list_webhook_dead_letters() -> List[Dict[str, Any]]:
+    """List webhook deliveries that failed every retry."""
+    response = _http.get(f"{BRIDGE_URL}/api/webhooks/dead-letters")
+    return _check_response(response)
+
+def retry_webhook_dead_letter(dead_letter_id: int) -> Dict[str, Any]:
+    """Redeliver a failed webhook delivery."""
+    response = _http.post(f"{BRIDGE_URL}/api/webhooks/dead-letters/{dead_letter_id}/retry")
+    return _send_result(response)
+
+def get_whatsapp_status(account_id: Optional[str] = None) -> Dict[str, Any]:
     """Get WhatsApp connection status."""
-    response = requests.get(f"{BRIDGE_URL}/api/status")
+    response = _http.get(f"{BRIDGE_URL}/api/status", params=_params(account_id))
     data = _check_response(response)
-    
+
     if not data.get("connected"):
-        qr_response = requests.get(f"{BRIDGE_URL}/api/qr")
+        qr_response = _http.get(f"{BRIDGE_URL}/api/qr", params=_params(account_id))
         if qr_response.status_code == 200:
             qr_data = qr_response.json()
             data["qr_code"] = qr_data.get("qr_string")
             data["qr_image"] = qr_data.get("qr_base64")
-    
+
     return data
 
-def get_whatsapp_qr() -> Dict[str, Any]:
+def get_device(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get metadata about the linked device."""
+    response = _http.get(f"{BRIDGE_URL}/api/device", params=_params(account_id))
+    return _check_response(response)
+
+def get_profile(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the linked account's display name, about text and photo ID."""
+    response = _http.get(f"{BRIDGE_URL}/api/profile", params=_params(account_id))
+    return _send_result(response)
+
+def update_profile(name: Optional[str] = None, about: Optional[str] = None, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Change the linked account's display name and/or about text."""
+    data = {k: v for k, v in {"name": name, "about": about}.items() if v is not None}
+    response = _http.put(f"{BRIDGE_URL}/api/profile", params=_params(account_id), json=data)
+    return _send_result(response)
+
+def set_profile_photo(
+    media_path: Optional[str] = None,
+    url: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Set the linked account's profile photo from a local path or a URL, or remove it if neither is given."""
+    if media_path:
+        media_path, failure = _local_file(media_path)
+        if failure:
+            return failure
+        with open(media_path, 'rb') as f:
+            response = _http.put(
+                f"{BRIDGE_URL}/api/profile/photo",
+                params=_params(account_id),
+                files={"file": (os.path.basename(media_path), f)}
+            )
+    elif url:
+        response = _http.put(f"{BRIDGE_URL}/api/profile/photo", params=_params(account_id), json={"url": url})
+    else:
+        response = _http.delete(f"{BRIDGE_URL}/api/profile/photo", params=_params(account_id))
+    return _send_result(response)
+
+def get_sync_status(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the history sync progress."""
+    response = _http.get(f"{BRIDGE_URL}/api/sync/status", params=_params(account_id))
+    return _check_response(response)
+
+def request_history_sync(chat_jid: str, count: int = 50, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Ask the phone for messages older than the oldest stored one in a chat."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/sync/request",
+        params=_params(account_id),
+        json={"chat_jid": chat_jid, "count": count}
+    )
+    return _send_result(response)
+
+def get_whatsapp_qr(account_id: Optional[str] = None) -> Dict[str, Any]:
     """Get WhatsApp QR code."""
-    response = requests.get(f"{BRIDGE_URL}/api/qr")
+    response = _http.get(f"{BRIDGE_URL}/api/qr", params=_params(account_id))
+    return _check_response(response)
+
+def pair_phone(phone_number: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Request a pairing code for linking by phone number."""
+    response = _http.post(f"{BRIDGE_URL}/api/pair-phone", params=_params(account_id), json={
+        "phone_number": phone_number
+    })
     return _check_response(response)
 
-def wait_for_whatsapp_connection(timeout: int = 60) -> Dict[str, Any]:
+def wait_for_whatsapp_connection(timeout: int = 60, account_id: Optional[str] = None) -> Dict[str, Any]:
     """Wait for WhatsApp connection."""
     start_time = time.time()
     while time.time() - start_time < timeout:
-        response = requests.get(f"{BRIDGE_URL}/api/status")
+        response = _http.get(f"{BRIDGE_URL}/api/status", params=_params(account_id))
         data = _check_response(response)
         if data.get("connected"):
             return {"success": True, "message": "Connected"}
         time.sleep(2)
     raise Exception("Connection timeout")
 
-def search_contacts(query: str) -> List[Dict[str, Any]]:
-    """Search contacts."""
-    response = requests.get(f"{BRIDGE_URL}/api/contacts", params={"q": query})
+def search_contacts(query: str, limit: int = 10, account_id: Optional[str] = None) -> List[Dict[str, Any]]:
+    """Search contacts by name or number, tolerating typos; best matches first."""
+    response = _http.get(f"{BRIDGE_URL}/api/contacts/search", params=_params(account_id, q=query, limit=limit))
+    return _check_response(response).get("matches", [])
+
+def get_contact(jid: str, refresh: bool = False, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get a contact's names and business verification."""
+    params = _params(account_id, refresh="true" if refresh else None)
+    response = _http.get(f"{BRIDGE_URL}/api/contacts/{jid}", params=params)
+    return _check_response(response)
+
+def check_phone_numbers(phone_numbers: List[str], account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Check which phone numbers are registered on WhatsApp."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/contacts/check",
+        params=_params(account_id),
+        json={"phone_numbers": phone_numbers}
+    )
+    return _check_response(response)
+
+def subscribe_presence(jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Ask WhatsApp for a contact's online and last seen updates."""
+    response = _http.post(f"{BRIDGE_URL}/api/contacts/{jid}/presence/subscribe", params=_params(account_id))
+    return _send_result(response)
+
+def get_presence(jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get a contact's last known presence."""
+    response = _http.get(f"{BRIDGE_URL}/api/contacts/{jid}/presence", params=_params(account_id))
+    return _check_response(response)
+
+def block_contact(jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Block a contact."""
+    response = _http.post(f"{BRIDGE_URL}/api/contacts/{jid}/block", params=_params(account_id))
+    return _send_result(response)
+
+def unblock_contact(jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Unblock a contact."""
+    response = _http.post(f"{BRIDGE_URL}/api/contacts/{jid}/unblock", params=_params(account_id))
+    return _send_result(response)
+
+def get_blocklist(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the blocked contacts."""
+    response = _http.get(f"{BRIDGE_URL}/api/blocklist", params=_params(account_id))
     return _check_response(response)
 
+def set_disappearing_messages(chat_jid: str, timer: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Turn disappearing messages on or off in a chat."""
+    response = _http.put(
+        f"{BRIDGE_URL}/api/chats/{chat_jid}/disappearing",
+        params=_params(account_id),
+        json={"timer": timer}
+    )
+    return _send_result(response)
+
+def archive_chat(chat_jid: str, archived: bool = True, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Archive or unarchive a chat on every device."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/chats/{chat_jid}/archive",
+        params=_params(account_id),
+        json={"archived": archived}
+    )
+    return _send_result(response)
+
+def pin_chat(chat_jid: str, pinned: bool = True, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Pin or unpin a chat on every device."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/chats/{chat_jid}/pin",
+        params=_params(account_id),
+        json={"pinned": pinned}
+    )
+    return _send_result(response)
+
+def mute_chat(
+    chat_jid: str,
+    muted: bool = True,
+    duration: int = 0,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Mute or unmute a chat on every device, for duration seconds or forever when 0."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/chats/{chat_jid}/mute",
+        params=_params(account_id),
+        json={"muted": muted, "duration": duration}
+    )
+    return _send_result(response)
+
+def list_groups(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """List joined groups with participant counts and whether we are an admin."""
+    response = _http.get(f"{BRIDGE_URL}/api/groups", params=_params(account_id))
+    return _check_response(response)
+
+def leave_group(group_jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Leave a group."""
+    response = _http.post(f"{BRIDGE_URL}/api/groups/{group_jid}/leave", params=_params(account_id))
+    return _send_result(response)
+
+# Changes update_group_participants can make
+GROUP_PARTICIPANT_ACTIONS = ("add", "remove", "promote", "demote")
+
+# Longest group subject WhatsApp accepts
+MAX_GROUP_SUBJECT_LENGTH = 25
+
+def _group_error(
+    group_jid: Optional[str] = None,
+    name: Optional[str] = None,
+    participants: Optional[List[str]] = None
+) -> Optional[Dict[str, Any]]:
+    """Check group arguments before they reach the bridge, returning the failure result for bad ones."""
+    message = None
+    if group_jid is not None and not group_jid.endswith("@g.us"):
+        message = f"{group_jid!r} is not a group JID; group JIDs end in @g.us"
+    elif name is not None and not name.strip():
+        message = "The group subject can't be empty"
+    elif name is not None and len(name) > MAX_GROUP_SUBJECT_LENGTH:
+        message = f"The group subject is {len(name)} characters; WhatsApp allows {MAX_GROUP_SUBJECT_LENGTH}"
+    elif participants is not None and not participants:
+        message = "Give at least one participant"
+    elif participants is not None:
+        for participant in participants:
+            digits = participant.lstrip("+").replace(" ", "").replace("-", "")
+            if "@" not in participant and not (digits.isdigit() and 7 <= len(digits) <= 15):
+                message = f"{participant!r} is neither a phone number with country code nor a JID"
+                break
+    return {"success": False, "message": message} if message else None
+
+def create_group(name: str, participants: List[str], account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Create a group with the given participants."""
+    error = _group_error(name=name, participants=participants)
+    if error:
+        return error
+    response = _http.post(
+        f"{BRIDGE_URL}/api/groups",
+        params=_params(account_id),
+        json={"name": name, "participants": participants}
+    )
+    return _send_result(response)
+
+def update_group_participants(
+    group_jid: str,
+    action: str,
+    participants: List[str],
+    send_invites: bool = False,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Add, remove, promote or demote group participants."""
+    if action not in GROUP_PARTICIPANT_ACTIONS:
+        return {"success": False, "message": f"action must be one of {', '.join(GROUP_PARTICIPANT_ACTIONS)}"}
+    error = _group_error(group_jid=group_jid, participants=participants)
+    if error:
+        return error
+    response = _http.post(
+        f"{BRIDGE_URL}/api/groups/{group_jid}/participants",
+        params=_params(account_id),
+        json={"action": action, "participants": participants, "send_invites": send_invites}
+    )
+    return _send_result(response)
+
+def add_group_participants(
+    group_jid: str,
+    participants: List[str],
+    send_invites: bool = True,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Add people to a group, inviting those whose privacy settings block direct adds."""
+    return update_group_participants(group_jid, "add", participants, send_invites, account_id)
+
+def remove_group_participant(group_jid: str, participant: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Remove one person from a group."""
+    return update_group_participants(group_jid, "remove", [participant], account_id=account_id)
+
+def set_group_subject(group_jid: str, subject: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Rename a group."""
+    error = _group_error(group_jid=group_jid, name=subject)
+    if error:
+        return error
+    return update_group_settings(group_jid, name=subject, account_id=account_id)
+
+def get_group_info(group_jid: str, refresh: bool = False, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get a group's metadata, served from the bridge's cache while it is fresh."""
+    params = _params(account_id, refresh="true" if refresh else None)
+    response = _http.get(f"{BRIDGE_URL}/api/groups/{group_jid}", params=params)
+    return _check_response(response)
+
+def update_group_settings(
+    group_jid: str,
+    name: Optional[str] = None,
+    topic: Optional[str] = None,
+    disappearing_timer: Optional[int] = None,
+    announce: Optional[bool] = None,
+    locked: Optional[bool] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Change a group's subject, description and settings."""
+    body = {
+        "name": name,
+        "topic": topic,
+        "disappearing_timer": disappearing_timer,
+        "announce": announce,
+        "locked": locked,
+    }
+    response = _http.patch(
+        f"{BRIDGE_URL}/api/groups/{group_jid}",
+        params=_params(account_id),
+        json={k: v for k, v in body.items() if v is not None}
+    )
+    return _check_response(response)
+
+def get_group_invite_link(group_jid: str, revoke: bool = False, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get a group's invite link, or revoke it and get its replacement."""
+    error = _group_error(group_jid=group_jid)
+    if error:
+        return error
+    if revoke:
+        response = _http.post(f"{BRIDGE_URL}/api/groups/{group_jid}/invite-link/revoke", params=_params(account_id))
+    else:
+        response = _http.get(f"{BRIDGE_URL}/api/groups/{group_jid}/invite-link", params=_params(account_id))
+    return _send_result(response)
+
+def resolve_group_invite(link: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Look up the group an invite link leads to without joining it."""
+    response = _http.get(f"{BRIDGE_URL}/api/groups/join", params=_params(account_id, link=link))
+    return _send_result(response)
+
+def join_group(link: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Join a group with an invite link."""
+    response = _http.post(f"{BRIDGE_URL}/api/groups/join", params=_params(account_id), json={"link": link})
+    return _send_result(response)
+
+def list_group_join_requests(group_jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """List pending requests to join a group."""
+    response = _http.get(f"{BRIDGE_URL}/api/groups/{group_jid}/requests", params=_params(account_id))
+    return _send_result(response)
+
+def update_group_join_requests(
+    group_jid: str,
+    action: str,
+    participants: Optional[List[str]] = None,
+    all_pending: bool = False,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Approve or reject requests to join a group."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/groups/{group_jid}/requests",
+        params=_params(account_id),
+        json={"action": action, "participants": participants or [], "all": all_pending}
+    )
+    return _send_result(response)
+
+def list_communities(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """List joined communities."""
+    response = _http.get(f"{BRIDGE_URL}/api/communities", params=_params(account_id))
+    return _check_response(response)
+
+def list_community_groups(community_jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """List the groups linked to a community."""
+    response = _http.get(f"{BRIDGE_URL}/api/communities/{community_jid}/groups", params=_params(account_id))
+    return _send_result(response)
+
+def link_community_group(
+    community_jid: str,
+    group_jid: str,
+    unlink: bool = False,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Link a group to a community, or unlink it."""
+    if unlink:
+        response = _http.delete(
+            f"{BRIDGE_URL}/api/communities/{community_jid}/groups/{group_jid}",
+            params=_params(account_id)
+        )
+    else:
+        response = _http.post(
+            f"{BRIDGE_URL}/api/communities/{community_jid}/groups",
+            params=_params(account_id),
+            json={"group_jid": group_jid}
+        )
+    return _send_result(response)
+
+def post_community_announcement(community_jid: str, body: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Post a message to a community's announcement group."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/communities/{community_jid}/announcements",
+        params=_params(account_id),
+        json={"body": body}
+    )
+    return _send_result(response)
+
+def list_newsletters(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """List followed channels."""
+    response = _http.get(f"{BRIDGE_URL}/api/newsletters", params=_params(account_id))
+    return _check_response(response)
+
+def follow_newsletter(
+    jid: Optional[str] = None,
+    invite: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Follow a channel by JID or invite link."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/newsletters/follow",
+        params=_params(account_id),
+        json={"jid": jid or "", "invite": invite or ""}
+    )
+    return _send_result(response)
+
+def unfollow_newsletter(jid: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Unfollow a channel."""
+    response = _http.post(f"{BRIDGE_URL}/api/newsletters/{jid}/unfollow", params=_params(account_id))
+    return _send_result(response)
+
+def get_newsletter_messages(
+    jid: str,
+    limit: int = 20,
+    before: Optional[int] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Get channel posts, newest first."""
+    params = _params(account_id, limit=limit, before=before)
+    response = _http.get(f"{BRIDGE_URL}/api/newsletters/{jid}/messages", params=params)
+    return _check_response(response)
+
+def react_to_newsletter_message(
+    jid: str,
+    server_id: int,
+    reaction: str,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """React to a channel post, or remove our reaction with an empty string."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/newsletters/{jid}/messages/{server_id}/reaction",
+        params=_params(account_id),
+        json={"reaction": reaction}
+    )
+    return _send_result(response)
+
+def mark_newsletter_viewed(jid: str, server_ids: List[int], account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Mark channel posts as viewed."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/newsletters/{jid}/views",
+        params=_params(account_id),
+        json={"server_ids": server_ids}
+    )
+    return _send_result(response)
+
 def list_messages(
     after: Optional[str] = None,
     before: Optional[str] = None,
@@ -56,109 +555,835 @@ def list_messages(
     chat_jid: Optional[str] = None,
     query: Optional[str] = None,
     limit: int = 20,
-    page: int = 0,
-    include_context: bool = True,
-    context_before: int = 1,
-    context_after: int = 1
-) -> str:
-    """List messages."""
-    params = {
-        "after": after,
-        "before": before,
-        "sender": sender_phone_number,
-        "chat": chat_jid,
-        "q": query,
-        "limit": limit,
-        "page": page
-    }
-    params = {k: v for k, v in params.items() if v is not None}
-    response = requests.get(f"{BRIDGE_URL}/api/messages", params=params)
+    cursor: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """List stored messages newest first, one page at a time."""
+    params = _params(
+        account_id,
+        after=after,
+        before=before,
+        sender=sender_phone_number,
+        chat_jid=chat_jid,
+        q=query,
+        limit=limit,
+        cursor=cursor
+    )
+    response = _http.get(f"{BRIDGE_URL}/api/messages", params=params)
+    messages = _check_response(response)
+    if AUTO_MARK_READ and chat_jid:
+        mark_read(chat_jid, account_id=account_id)
+    return messages
+
+def search_messages(
+    query: str,
+    chat_jid: Optional[str] = None,
+    sender_phone_number: Optional[str] = None,
+    after: Optional[str] = None,
+    before: Optional[str] = None,
+    limit: int = 20,
+    offset: int = 0,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Search message text and captions, best matches first."""
+    params = _params(
+        account_id,
+        q=query,
+        chat_jid=chat_jid,
+        sender=sender_phone_number,
+        after=after,
+        before=before,
+        limit=limit,
+        offset=offset
+    )
+    response = _http.get(f"{BRIDGE_URL}/api/messages/search", params=params)
     return _check_response(response)
 
 def list_chats(
     query: Optional[str] = None,
     limit: int = 20,
-    page: int = 0,
-    include_last_message: bool = True,
-    sort_by: str = "last_active"
-) -> List[Dict[str, Any]]:
-    """List chats."""
-    params = {"q": query, "limit": limit, "page": page}
-    params = {k: v for k, v in params.items() if v is not None}
-    response = requests.get(f"{BRIDGE_URL}/api/chats", params=params)
+    cursor: Optional[str] = None,
+    archived: Optional[bool] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """List chats by last activity, one page at a time."""
+    params = _params(
+        account_id,
+        q=query,
+        limit=limit,
+        cursor=cursor,
+        archived=None if archived is None else str(archived).lower()
+    )
+    response = _http.get(f"{BRIDGE_URL}/api/chats", params=params)
     return _check_response(response)
 
-def get_chat(chat_jid: str, include_last_message: bool = True) -> Dict[str, Any]:
+def read_chat_history(
+    chat_jid: str,
+    limit: int = 20,
+    before: Optional[str] = None,
+    after: Optional[str] = None,
+    media_only: bool = False,
+    account_id: Optional[str] = None,
+    mark_as_read: bool = True
+) -> Dict[str, Any]:
+    """Read a page of a chat's stored messages, oldest first. With AUTO_MARK_READ
+    the chat is marked read unless mark_as_read is False."""
+    params = _params(
+        account_id,
+        limit=limit,
+        before=before,
+        after=after,
+        media_only="true" if media_only else None
+    )
+    response = _http.get(f"{BRIDGE_URL}/api/chats/{chat_jid}/messages", params=params)
+    history = _check_response(response)
+    if AUTO_MARK_READ and mark_as_read and not before:
+        mark_read(chat_jid, account_id=account_id)
+    return history
+
+def get_chat(chat_jid: str, include_last_message: bool = True, account_id: Optional[str] = None) -> Dict[str, Any]:
     """Get chat details."""
-    response = requests.get(f"{BRIDGE_URL}/api/chats/{chat_jid}")
+    response = _http.get(f"{BRIDGE_URL}/api/chats/{chat_jid}", params=_params(account_id))
     return _check_response(response)
 
-def get_direct_chat_by_contact(sender_phone_number: str) -> Dict[str, Any]:
+def get_direct_chat_by_contact(sender_phone_number: str, account_id: Optional[str] = None) -> Dict[str, Any]:
     """Get chat by phone number."""
-    response = requests.get(f"{BRIDGE_URL}/api/chats/phone/{sender_phone_number}")
+    response = _http.get(f"{BRIDGE_URL}/api/chats/phone/{sender_phone_number}", params=_params(account_id))
     return _check_response(response)
 
-def get_contact_chats(jid: str, limit: int = 20, page: int = 0) -> List[Dict[str, Any]]:
+def get_contact_chats(jid: str, limit: int = 20, page: int = 0, account_id: Optional[str] = None) -> List[Dict[str, Any]]:
     """Get contact's chats."""
-    params = {"limit": limit, "page": page}
-    response = requests.get(f"{BRIDGE_URL}/api/contacts/{jid}/chats", params=params)
+    params = _params(account_id, limit=limit, page=page)
+    response = _http.get(f"{BRIDGE_URL}/api/contacts/{jid}/chats", params=params)
     return _check_response(response)
 
-def get_last_interaction(jid: str) -> str:
+def get_last_interaction(jid: str, account_id: Optional[str] = None) -> str:
     """Get last interaction."""
-    response = requests.get(f"{BRIDGE_URL}/api/contacts/{jid}/last")
+    response = _http.get(f"{BRIDGE_URL}/api/contacts/{jid}/last", params=_params(account_id))
     data = _check_response(response)
     return data.get("message", "")
 
 def get_message_context(
     message_id: str,
     before: int = 5,
-    after: int = 5
+    after: int = 5,
+    account_id: Optional[str] = None
 ) -> Dict[str, Any]:
     """Get message context."""
-    params = {"before": before, "after": after}
-    response = requests.get(f"{BRIDGE_URL}/api/messages/{message_id}/context", params=params)
+    params = _params(account_id, before=before, after=after)
+    response = _http.get(f"{BRIDGE_URL}/api/messages/{message_id}/context", params=params)
     return _check_response(response)
 
-def send_message(recipient: str, message: str) -> Tuple[bool, str]:
-    """Send message."""
-    response = requests.post(f"{BRIDGE_URL}/api/send", json={
+def send_message(
+    recipient: str,
+    message: str,
+    quoted_message_id: Optional[str] = None,
+    mentioned_jids: Optional[List[str]] = None,
+    link_preview: bool = False,
+    simulate_typing: bool = False,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a text message and return its ID, timestamp and normalized JID."""
+    payload = {"recipient": recipient, "body": message}
+    if quoted_message_id:
+        payload["quoted_message_id"] = quoted_message_id
+    if mentioned_jids:
+        payload["mentioned_jids"] = mentioned_jids
+    if link_preview:
+        payload["link_preview"] = True
+    if simulate_typing:
+        payload["simulate_typing"] = True
+    response = _http.post(f"{BRIDGE_URL}/api/messages/text", params=_params(account_id), json=payload)
+    return _send_result(response)
+
+def resolve_recipient(query: str, limit: int = 5, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Find the contact or group a phone number, JID or name refers to."""
+    response = _http.get(f"{BRIDGE_URL}/api/recipients/resolve", params=_params(account_id, q=query, limit=limit))
+    return _check_response(response)
+
+def send_message_to(
+    recipient: str,
+    message: str,
+    account_id: Optional[str] = None,
+    **options
+) -> Dict[str, Any]:
+    """Resolve a recipient given by number or name and send it a text message.
+
+    The result names the resolved target. When the name is ambiguous nothing is
+    sent and the candidates are returned instead.
+    """
+    target, failure = _resolve_target(recipient, account_id)
+    if failure:
+        return failure
+    result = send_message(target["jid"], message, account_id=account_id, **options)
+    result["resolved"] = target
+    return result
+
+def _resolve_target(recipient: str, account_id: Optional[str] = None):
+    """Resolve a recipient, returning the target or the failure result to give back instead."""
+    resolution = resolve_recipient(recipient, account_id=account_id)
+    target = resolution.get("resolved")
+    if target:
+        return target, None
+    candidates = resolution.get("candidates", [])
+    reason = "matches several chats" if candidates else "matches no contact or group"
+    return None, {
+        "success": False,
+        "message": f"{recipient!r} {reason}; send again with the JID of the one you mean",
+        "candidates": candidates
+    }
+
+def get_message_receipts(message_id: str, chat_jid: Optional[str] = None, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the delivered, read and played receipts of a sent message."""
+    response = _http.get(
+        f"{BRIDGE_URL}/api/messages/{message_id}/receipts",
+        params=_params(account_id, chat_jid=chat_jid)
+    )
+    return _check_response(response)
+
+def mark_read(
+    chat_jid: str,
+    message_ids: Optional[List[str]] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send read receipts for the given messages, or for every unread message in the chat."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/chats/{chat_jid}/read",
+        params=_params(account_id),
+        json={"message_ids": message_ids or []}
+    )
+    return _send_result(response)
+
+def send_typing(chat_jid: str, state: str = "composing", account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Show or clear the typing indicator in a chat: composing, recording or paused."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/chats/{chat_jid}/typing",
+        params=_params(account_id),
+        json={"state": state}
+    )
+    return _send_result(response)
+
+def send_reaction(
+    message_id: str,
+    emoji: str,
+    chat_jid: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """React to a message with an emoji, or remove our reaction with an empty string."""
+    payload = {"emoji": emoji}
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    response = _http.post(
+        f"{BRIDGE_URL}/api/messages/{message_id}/reaction",
+        params=_params(account_id),
+        json=payload
+    )
+    return _send_result(response)
+
+def edit_message(
+    message_id: str,
+    message: str,
+    chat_jid: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Edit the text of a message we sent within the last 15 minutes."""
+    payload = {"body": message}
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    response = _http.patch(
+        f"{BRIDGE_URL}/api/messages/{message_id}",
+        params=_params(account_id),
+        json=payload
+    )
+    return _send_result(response)
+
+def delete_message(
+    message_id: str,
+    scope: str,
+    chat_jid: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Delete a message for everyone or only from the local store."""
+    response = _http.delete(
+        f"{BRIDGE_URL}/api/messages/{message_id}",
+        params=_params(account_id, scope=scope, chat_jid=chat_jid)
+    )
+    return _send_result(response)
+
+def forward_message(
+    message_id: str,
+    targets: List[str],
+    chat_jid: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Forward a stored message to one or more chats."""
+    payload = {"targets": targets}
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    response = _http.post(
+        f"{BRIDGE_URL}/api/messages/{message_id}/forward",
+        params=_params(account_id),
+        json=payload
+    )
+    return _send_result(response)
+
+def star_message(
+    message_id: str,
+    starred: bool = True,
+    chat_jid: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Star or unstar a message on every device."""
+    payload = {"starred": starred}
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    response = _http.post(
+        f"{BRIDGE_URL}/api/messages/{message_id}/star",
+        params=_params(account_id),
+        json=payload
+    )
+    return _send_result(response)
+
+def list_starred_messages(
+    chat_jid: Optional[str] = None,
+    limit: int = 50,
+    before: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """List starred messages newest first, across chats unless chat_jid is given."""
+    response = _http.get(
+        f"{BRIDGE_URL}/api/messages/starred",
+        params=_params(account_id, chat_jid=chat_jid, limit=limit, before=before)
+    )
+    return _check_response(response)
+
+def list_labels(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """List WhatsApp Business labels with how many chats and messages carry them."""
+    response = _http.get(f"{BRIDGE_URL}/api/labels", params=_params(account_id))
+    return _check_response(response)
+
+def get_label(label_id: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get a label with the chats and messages carrying it."""
+    response = _http.get(f"{BRIDGE_URL}/api/labels/{label_id}", params=_params(account_id))
+    return _send_result(response)
+
+def save_label(
+    name: Optional[str] = None,
+    color: Optional[int] = None,
+    label_id: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Create a label, or edit the name or color of an existing one when label_id is given."""
+    payload = {k: v for k, v in {"name": name, "color": color}.items() if v is not None}
+    if label_id:
+        response = _http.patch(f"{BRIDGE_URL}/api/labels/{label_id}", params=_params(account_id), json=payload)
+    else:
+        response = _http.post(f"{BRIDGE_URL}/api/labels", params=_params(account_id), json=payload)
+    return _send_result(response)
+
+def delete_label(label_id: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Delete a label from every device."""
+    response = _http.delete(f"{BRIDGE_URL}/api/labels/{label_id}", params=_params(account_id))
+    return _send_result(response)
+
+def label_chat(
+    label_id: str,
+    chat_jid: str,
+    labeled: bool = True,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Add or remove a label on a chat."""
+    response = _http.post(
+        f"{BRIDGE_URL}/api/labels/{label_id}/chats",
+        params=_params(account_id),
+        json={"chat_jid": chat_jid, "labeled": labeled}
+    )
+    return _send_result(response)
+
+def label_message(
+    label_id: str,
+    message_id: str,
+    chat_jid: Optional[str] = None,
+    labeled: bool = True,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Add or remove a label on a message."""
+    payload = {"message_id": message_id, "labeled": labeled}
+    if chat_jid:
+        payload["chat_jid"] = chat_jid
+    response = _http.post(
+        f"{BRIDGE_URL}/api/labels/{label_id}/messages",
+        params=_params(account_id),
+        json=payload
+    )
+    return _send_result(response)
+
+def send_location(
+    recipient: str,
+    latitude: float,
+    longitude: float,
+    name: Optional[str] = None,
+    address: Optional[str] = None,
+    live: bool = False,
+    duration_seconds: Optional[int] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a location pin, or start sharing a live location for duration_seconds."""
+    payload = {
         "recipient": recipient,
-        "message": message
+        "latitude": latitude,
+        "longitude": longitude,
+        "name": name,
+        "address": address,
+        "live": live,
+        "duration_seconds": duration_seconds
+    }
+    payload = {k: v for k, v in payload.items() if v is not None}
+    response = _http.post(f"{BRIDGE_URL}/api/messages/location", params=_params(account_id), json=payload)
+    return _send_result(response)
+
+def stop_live_location(live_id: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Stop sharing a live location started with send_location."""
+    response = _http.delete(f"{BRIDGE_URL}/api/messages/location/live/{live_id}", params=_params(account_id))
+    return _send_result(response)
+
+def send_contact(
+    recipient: str,
+    contacts: Optional[List[Dict[str, Any]]] = None,
+    vcards: Optional[List[str]] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send one or more contact cards from structured contacts or raw vCards."""
+    payload = {"recipient": recipient, "contacts": contacts or [], "vcards": vcards or []}
+    response = _http.post(f"{BRIDGE_URL}/api/messages/contact", params=_params(account_id), json=payload)
+    return _send_result(response)
+
+def send_poll(
+    recipient: str,
+    question: str,
+    options: List[str],
+    multi_select: bool = False,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Create a poll in a chat."""
+    response = _http.post(f"{BRIDGE_URL}/api/messages/poll", params=_params(account_id), json={
+        "recipient": recipient,
+        "question": question,
+        "options": options,
+        "multi_select": multi_select
     })
-    if response.status_code != 200:
-        return False, response.text
-    return True, "Message sent"
+    return _send_result(response)
+
+def send_buttons(
+    recipient: str,
+    body: str,
+    buttons: List[Dict[str, Any]],
+    header: Optional[str] = None,
+    footer: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a message with up to 3 reply, url or call buttons."""
+    payload = {"recipient": recipient, "body": body, "buttons": buttons}
+    if header:
+        payload["header"] = header
+    if footer:
+        payload["footer"] = footer
+    response = _http.post(f"{BRIDGE_URL}/api/messages/buttons", params=_params(account_id), json=payload)
+    return _send_result(response)
+
+def send_list(
+    recipient: str,
+    body: str,
+    button_text: str,
+    sections: List[Dict[str, Any]],
+    title: Optional[str] = None,
+    footer: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a list (menu) message with sections of selectable rows."""
+    payload = {"recipient": recipient, "body": body, "button_text": button_text, "sections": sections}
+    if title:
+        payload["title"] = title
+    if footer:
+        payload["footer"] = footer
+    response = _http.post(f"{BRIDGE_URL}/api/messages/list", params=_params(account_id), json=payload)
+    return _send_result(response)
+
+def get_poll_results(message_id: str, chat_jid: Optional[str] = None, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the vote tally of a poll."""
+    response = _http.get(
+        f"{BRIDGE_URL}/api/polls/{message_id}/results",
+        params=_params(account_id, chat_jid=chat_jid)
+    )
+    return _check_response(response)
+
+def get_catalog(
+    business_jid: str = "me",
+    limit: int = 20,
+    after: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Get a page of a business's product catalog, or our own with "me"."""
+    response = _http.get(
+        f"{BRIDGE_URL}/api/catalog/{business_jid}",
+        params=_params(account_id, limit=limit, after=after)
+    )
+    return _send_result(response)
+
+def send_product_message(
+    recipient: str,
+    product_id: str,
+    business_jid: Optional[str] = None,
+    body: Optional[str] = None,
+    footer: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a product card from a business catalog."""
+    payload = {"recipient": recipient, "product_id": product_id}
+    for key, value in {"business_jid": business_jid, "body": body, "footer": footer}.items():
+        if value:
+            payload[key] = value
+    response = _http.post(f"{BRIDGE_URL}/api/messages/product", params=_params(account_id), json=payload)
+    return _send_result(response)
+
+def send_catalog_message(
+    recipient: str,
+    business_jid: Optional[str] = None,
+    body: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Share a link to a business catalog."""
+    payload = {"recipient": recipient}
+    if business_jid:
+        payload["business_jid"] = business_jid
+    if body:
+        payload["body"] = body
+    response = _http.post(f"{BRIDGE_URL}/api/messages/catalog", params=_params(account_id), json=payload)
+    return _send_result(response)
+
+def get_order(order_id: str, token: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the items and total of an order, using the token from its order event."""
+    response = _http.get(f"{BRIDGE_URL}/api/orders/{order_id}", params=_params(account_id, token=token))
+    return _check_response(response)
+
+def schedule_message(
+    recipient: str,
+    message: str,
+    send_at: str,
+    quoted_message_id: Optional[str] = None,
+    mentioned_jids: Optional[List[str]] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Schedule a text message for an RFC 3339 send_at timestamp."""
+    payload = {"recipient": recipient, "body": message, "send_at": send_at}
+    if quoted_message_id:
+        payload["quoted_message_id"] = quoted_message_id
+    if mentioned_jids:
+        payload["mentioned_jids"] = mentioned_jids
+    response = _http.post(f"{BRIDGE_URL}/api/messages/schedule", params=_params(account_id), json=payload)
+    return _send_result(response)
+
+def list_scheduled_messages(status: Optional[str] = None, account_id: Optional[str] = None) -> List[Dict[str, Any]]:
+    """List scheduled messages, optionally only those with the given status."""
+    response = _http.get(f"{BRIDGE_URL}/api/messages/schedule", params=_params(account_id, status=status))
+    return _check_response(response)
+
+def cancel_scheduled_message(scheduled_id: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Cancel a pending scheduled message."""
+    response = _http.delete(f"{BRIDGE_URL}/api/scheduled/{scheduled_id}", params=_params(account_id))
+    return _send_result(response)
+
+def broadcast_message(
+    recipients: List[str],
+    message: str,
+    delay_ms: Optional[int] = None,
+    jitter_ms: Optional[int] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Start sending a text message to many recipients, paced by delay_ms plus random jitter_ms."""
+    payload = {"recipients": recipients, "body": message, "delay_ms": delay_ms, "jitter_ms": jitter_ms}
+    payload = {k: v for k, v in payload.items() if v is not None}
+    response = _http.post(f"{BRIDGE_URL}/api/messages/broadcast", params=_params(account_id), json=payload)
+    return _send_result(response)
+
+def get_broadcast(broadcast_id: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get the progress and per-recipient results of a broadcast."""
+    response = _http.get(f"{BRIDGE_URL}/api/broadcasts/{broadcast_id}", params=_params(account_id))
+    return _send_result(response)
+
+def list_outbox(status: Optional[str] = None, account_id: Optional[str] = None) -> List[Dict[str, Any]]:
+    """List messages queued while the bridge was disconnected."""
+    response = _http.get(f"{BRIDGE_URL}/api/outbox", params=_params(account_id, status=status))
+    return _check_response(response)
+
+def cancel_outbox_message(message_id: str, account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Cancel a queued message before it is delivered."""
+    response = _http.delete(f"{BRIDGE_URL}/api/outbox/{message_id}", params=_params(account_id))
+    return _send_result(response)
+
+def send_sticker(
+    recipient: str,
+    media_path: Optional[str] = None,
+    url: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a PNG, JPEG or WebP image as a sticker."""
+    data = {"recipient": recipient}
+    if media_path:
+        media_path, failure = _local_file(media_path)
+        if failure:
+            return failure
+        with open(media_path, 'rb') as f:
+            response = _http.post(
+                f"{BRIDGE_URL}/api/messages/sticker",
+                params=_params(account_id),
+                data=data,
+                files={"file": (os.path.basename(media_path), f)}
+            )
+    else:
+        data["url"] = url
+        response = _http.post(f"{BRIDGE_URL}/api/messages/sticker", params=_params(account_id), json=data)
+    return _send_result(response)
+
+def send_file(
+    recipient: str,
+    media_path: Optional[str] = None,
+    caption: Optional[str] = None,
+    url: Optional[str] = None,
+    quoted_message_id: Optional[str] = None,
+    view_once: bool = False,
+    mentioned_jids: Optional[List[str]] = None,
+    original: bool = False,
+    background: bool = False,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send an image, video, audio or document from a local path or a URL.
+
+    With background set the bridge answers with a media job to poll instead
+    of waiting for the conversion and upload.
+    """
+    data = {
+        "recipient": recipient,
+        "caption": caption,
+        "url": url,
+        "quoted_message_id": quoted_message_id,
+        "mentioned_jids": mentioned_jids or None
+    }
+    data = {k: v for k, v in data.items() if v is not None}
+    if view_once:
+        data["view_once"] = "true" if media_path else True
+    if original:
+        data["original"] = "true" if media_path else True
+    if background:
+        data["async"] = "true" if media_path else True
+    if media_path:
+        media_path, failure = _local_file(media_path)
+        if failure:
+            return failure
+        with open(media_path, 'rb') as f:
+            response = _http.post(
+                f"{BRIDGE_URL}/api/messages/media",
+                params=_params(account_id),
+                data=data,
+                files={"file": (os.path.basename(media_path), f)}
+            )
+    else:
+        response = _http.post(f"{BRIDGE_URL}/api/messages/media", params=_params(account_id), json=data)
+    return _send_result(response)
+
+def send_document(
+    recipient: str,
+    media_path: Optional[str] = None,
+    url: Optional[str] = None,
+    filename: Optional[str] = None,
+    caption: Optional[str] = None,
+    quoted_message_id: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send any file as a document, keeping its name; PDFs get a page count and cover."""
+    data = {
+        "recipient": recipient,
+        "type": "document",
+        "url": url,
+        "filename": filename,
+        "caption": caption,
+        "quoted_message_id": quoted_message_id
+    }
+    data = {k: v for k, v in data.items() if v is not None}
+    if media_path:
+        media_path, failure = _local_file(media_path)
+        if failure:
+            return failure
+        with open(media_path, 'rb') as f:
+            response = _http.post(
+                f"{BRIDGE_URL}/api/messages/media",
+                params=_params(account_id),
+                data=data,
+                files={"file": (os.path.basename(media_path), f)}
+            )
+    else:
+        response = _http.post(f"{BRIDGE_URL}/api/messages/media", params=_params(account_id), json=data)
+    return _send_result(response)
+
+def send_media(
+    recipient: str,
+    source: str,
+    caption: Optional[str] = None,
+    quoted_message_id: Optional[str] = None,
+    media_type: Optional[str] = None,
+    filename: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send a file given by http(s) URL or local path to a recipient given by number or name.
+
+    The bridge downloads URLs itself and infers whether the file is an image,
+    video, audio or document unless media_type says so.
+    """
+    if media_type is not None and media_type not in MEDIA_TYPES:
+        return {"success": False, "message": f"media_type must be one of {', '.join(MEDIA_TYPES)}"}
+    is_url = source.lower().startswith(("http://", "https://"))
+    path = None
+    if not is_url:
+        path, failure = _local_file(source)
+        if failure:
+            return failure
 
-def send_file(recipient: str, media_path: str) -> Tuple[bool, str]:
-    """Send file."""
+    target, failure = _resolve_target(recipient, account_id)
+    if failure:
+        return failure
+    data = {
+        "recipient": target["jid"],
+        "type": media_type,
+        "filename": filename,
+        "caption": caption,
+        "quoted_message_id": quoted_message_id
+    }
+    data = {k: v for k, v in data.items() if v is not None}
+    if is_url:
+        data["url"] = source
+        response = _http.post(f"{BRIDGE_URL}/api/messages/media", params=_params(account_id), json=data)
+    else:
+        with open(path, 'rb') as f:
+            response = _http.post(
+                f"{BRIDGE_URL}/api/messages/media",
+                params=_params(account_id),
+                data=data,
+                files={"file": (filename or os.path.basename(path), f)}
+            )
+    result = _send_result(response)
+    result["resolved"] = target
+    return result
+
+def send_audio_message(
+    recipient: str,
+    media_path: str,
+    quoted_message_id: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Send an audio file as a voice note; the bridge transcodes it to Ogg/Opus."""
+    media_path, failure = _local_file(media_path)
+    if failure:
+        return failure
+    data = {"recipient": recipient}
+    if quoted_message_id:
+        data["quoted_message_id"] = quoted_message_id
     with open(media_path, 'rb') as f:
-        response = requests.post(
-            f"{BRIDGE_URL}/api/send-media",
-            data={"recipient": recipient},
-            files={"media": f}
+        response = _http.post(
+            f"{BRIDGE_URL}/api/messages/voice",
+            params=_params(account_id),
+            data=data,
+            files={"file": (os.path.basename(media_path), f)}
         )
+    return _send_result(response)
+
+def download_media(
+    message_id: str,
+    chat_jid: Optional[str] = None,
+    save_dir: Optional[str] = None,
+    account_id: Optional[str] = None
+) -> Optional[str]:
+    """Download the media of a message into save_dir (the temp directory by default) and return its path.
+
+    The bridge fetches media it has not cached yet, asking the sender to upload it again if it expired."""
+    response = _http.get(
+        f"{BRIDGE_URL}/api/messages/{message_id}/media",
+        params=_params(account_id, chat_jid=chat_jid),
+        stream=True
+    )
     if response.status_code != 200:
-        return False, response.text
-    return True, "File sent"
+        raise Exception(f"Bridge error: {response.status_code} - {response.text}")
+    headers = Message()
+    headers["Content-Disposition"] = response.headers.get("Content-Disposition", "")
+    filename = os.path.basename(headers.get_filename() or message_id)
+    path = os.path.join(save_dir or tempfile.gettempdir(), filename)
+    with open(path, 'wb') as f:
+        for chunk in response.iter_content(chunk_size=64 * 1024):
+            f.write(chunk)
+    return path
 
-def send_audio_message(recipient: str, media_path: str) -> Tuple[bool, str]:
-    """Send audio."""
-    with open(media_path, 'rb') as f:
-        response = requests.post(
-            f"{BRIDGE_URL}/api/send-audio",
-            data={"recipient": recipient},
-            files={"audio": f}
-        )
+def fetch_media(
+    message_id: str,
+    chat_jid: Optional[str] = None,
+    max_bytes: Optional[int] = None,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Download the media of a message into memory and return it base64 encoded.
+
+    Files over max_bytes (MAX_INLINE_MEDIA_BYTES by default) are not returned;
+    the result then says how large the file is so it can be saved with
+    download_media instead.
+    """
+    limit = max_bytes or MAX_INLINE_MEDIA_BYTES
+    response = _http.get(
+        f"{BRIDGE_URL}/api/messages/{message_id}/media",
+        params=_params(account_id, chat_jid=chat_jid),
+        stream=True
+    )
     if response.status_code != 200:
-        return False, response.text
-    return True, "Audio sent"
-
-def download_media(message_id: str, chat_jid: str) -> Optional[str]:
-    """Download media."""
-    response = requests.post(f"{BRIDGE_URL}/api/download-media", json={
-        "message_id": message_id,
-        "chat_jid": chat_jid
-    })
-    data = _check_response(response)
-    return data.get("file_path")
\ No newline at end of file
+        raise Exception(f"Bridge error: {response.status_code} - {response.text}")
+    headers = Message()
+    headers["Content-Disposition"] = response.headers.get("Content-Disposition", "")
+    result = {
+        "filename": os.path.basename(headers.get_filename() or message_id),
+        "mime_type": response.headers.get("Content-Type", "application/octet-stream")
+    }
+
+    too_large = {"success": False, "message": f"Media is larger than {limit} bytes; save it to a file instead"}
+    size = int(response.headers.get("Content-Length") or 0)
+    if size > limit:
+        response.close()
+        return {**too_large, **result, "size": size}
+    data = bytearray()
+    for chunk in response.iter_content(chunk_size=64 * 1024):
+        data.extend(chunk)
+        if len(data) > limit:
+            response.close()
+            return {**too_large, **result}
+    return {"success": True, **result, "size": len(data), "data_base64": base64.b64encode(bytes(data)).decode("ascii")}
+
+def get_media_stats(account_id: Optional[str] = None) -> Dict[str, Any]:
+    """Get disk usage of downloaded media by chat and type."""
+    response = _http.get(f"{BRIDGE_URL}/api/media/stats", params=_params(account_id))
+    return _check_response(response)
+
+def get_media_job(job_id: Optional[str] = None, account_id: Optional[str] = None) -> Any:
+    """Get a media message sent in the background, or all of them without a job ID."""
+    if job_id:
+        response = _http.get(f"{BRIDGE_URL}/api/media/jobs/{job_id}", params=_params(account_id))
+    else:
+        response = _http.get(f"{BRIDGE_URL}/api/media/jobs", params=_params(account_id))
+    return _check_response(response)
+
+def set_media_retention(
+    chat_jid: str,
+    keep: bool = False,
+    max_age_days: int = 0,
+    account_id: Optional[str] = None
+) -> Dict[str, Any]:
+    """Set how long a chat's downloaded media is kept."""
+    response = _http.put(
+        f"{BRIDGE_URL}/api/chats/{chat_jid}/media-retention",
+        params=_params(account_id),
+        json={"keep": keep, "max_age_days": max_age_days}
+    )
+    return _check_response(response)